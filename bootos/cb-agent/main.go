@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/agent"
@@ -20,6 +21,8 @@ func main() {
 	serverURL := flag.String("server", getEnv("CB_SERVER_URL", "http://10.0.0.1:8080"), "CloudBoot server URL")
 	pollInterval := flag.Duration("poll-interval", 5*time.Second, "Task polling interval")
 	debug := flag.Bool("debug", false, "Enable debug logging")
+	bootstrapToken := flag.String("bootstrap-token", getEnv("CB_BOOTSTRAP_TOKEN", kernelParam("cloudboot.token")), "Single-use registration token (default: cloudboot.token kernel parameter)")
+	tokenFile := flag.String("token-file", "/opt/cloudboot/runtime/agent-token", "File to persist the agent token across restarts")
 	flag.Parse()
 
 	// Print banner
@@ -31,8 +34,10 @@ func main() {
 
 	// Create agent
 	ag := agent.New(httpClient, agent.Config{
-		PollInterval: *pollInterval,
-		Debug:        *debug,
+		PollInterval:   *pollInterval,
+		Debug:          *debug,
+		BootstrapToken: *bootstrapToken,
+		TokenFile:      *tokenFile,
 	})
 
 	// Run agent
@@ -51,6 +56,20 @@ func printBanner() {
 `)
 }
 
+// kernelParam returns the value of a key=value parameter on the kernel command line
+func kernelParam(key string) string {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return ""
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if value, ok := strings.CutPrefix(field, key+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
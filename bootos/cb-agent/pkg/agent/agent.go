@@ -3,13 +3,22 @@ package agent
 import (
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/client"
 	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/hardware"
 	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/executor"
+	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/logstream"
 )
 
+// logFlushTimeout bounds how long a finished task waits for buffered logs
+const logFlushTimeout = 2 * time.Minute
+
+// tokenRetryInterval is the delay between failed agent token refreshes
+const tokenRetryInterval = 30 * time.Second
+
 // Agent coordinates task execution on bare-metal servers
 type Agent struct {
 	client       *client.Client
@@ -23,6 +32,12 @@ type Agent struct {
 type Config struct {
 	PollInterval time.Duration
 	Debug        bool
+	// BootstrapToken is the single-use token the iPXE script passes as
+	// cloudboot.token; it is exchanged for an agent token at registration.
+	BootstrapToken string
+	// TokenFile persists the agent token so that an agent restart does not
+	// need a new bootstrap token. Empty disables persistence.
+	TokenFile string
 }
 
 // New creates a new agent
@@ -56,23 +71,93 @@ func (a *Agent) Run() error {
 
 	// Step 3: Register with server
 	log.Println("[INFO] Registering with CloudBoot server...")
-	registerResp, err := a.client.RegisterAgent(&client.RegisterRequest{
-		MacAddress:   macAddr,
-		IPAddress:    ipAddr,
-		HardwareSpec: hwSpec,
+	registerResp, err := a.register(&client.RegisterRequest{
+		MacAddress:     macAddr,
+		IPAddress:      ipAddr,
+		HardwareSpec:   hwSpec,
+		BootstrapToken: a.config.BootstrapToken,
 	})
 	if err != nil {
 		return fmt.Errorf("registration failed: %w", err)
 	}
 
 	a.machineID = registerResp.MachineID
+	a.saveToken(registerResp.AgentToken)
 	log.Printf("[INFO] Registered successfully. Machine ID: %s", a.machineID)
 
+	// Keep the agent token fresh while tasks run
+	go a.refreshLoop(registerResp.TokenExpiresAt)
+
 	// Step 4: Enter task polling loop
 	log.Println("[INFO] Entering task polling loop...")
 	return a.pollLoop()
 }
 
+// register registers with the server. A token stored by a previous run is
+// tried first; if the server rejects it the bootstrap token is used instead.
+func (a *Agent) register(req *client.RegisterRequest) (*client.RegisterResponse, error) {
+	if token := a.loadToken(); token != "" {
+		a.client.SetToken(token)
+		resp, err := a.client.RegisterAgent(req)
+		if err == nil {
+			return resp, nil
+		}
+		log.Printf("[WARN] Stored agent token rejected, using bootstrap token: %v", err)
+		a.client.SetToken("")
+	}
+
+	if req.BootstrapToken == "" {
+		return nil, fmt.Errorf("no bootstrap token: boot via iPXE or pass --bootstrap-token")
+	}
+	return a.client.RegisterAgent(req)
+}
+
+// refreshLoop renews the agent token at half its remaining lifetime so that
+// long-running tasks can keep uploading logs and reporting status
+func (a *Agent) refreshLoop(expiresAt time.Time) {
+	wait := time.Until(expiresAt) / 2
+	for {
+		if wait < tokenRetryInterval {
+			wait = tokenRetryInterval
+		}
+		time.Sleep(wait)
+
+		resp, err := a.client.RefreshToken()
+		if err != nil {
+			log.Printf("[WARN] Agent token refresh failed: %v", err)
+			wait = tokenRetryInterval
+			continue
+		}
+		a.saveToken(resp.AgentToken)
+		wait = time.Until(resp.TokenExpiresAt) / 2
+		if a.config.Debug {
+			log.Printf("[DEBUG] Agent token refreshed, expires at %s", resp.TokenExpiresAt.Format(time.RFC3339))
+		}
+	}
+}
+
+// loadToken reads the agent token persisted by a previous run
+func (a *Agent) loadToken() string {
+	if a.config.TokenFile == "" {
+		return ""
+	}
+	data, err := os.ReadFile(a.config.TokenFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveToken persists the agent token, readable only by the agent
+func (a *Agent) saveToken(token string) {
+	if a.config.TokenFile == "" || token == "" {
+		return
+	}
+	if err := os.WriteFile(a.config.TokenFile, []byte(token), 0600); err != nil {
+		log.Printf("[WARN] Failed to save agent token: %v", err)
+	}
+}
+
 // pollLoop continuously polls for tasks and executes them
 func (a *Agent) pollLoop() error {
 	ticker := time.NewTicker(a.config.PollInterval)
@@ -98,67 +183,101 @@ func (a *Agent) pollAndExecute() error {
 	}
 
 	// No task available
-	if taskResp.NoTask {
+	if taskResp.NoTask() {
 		if a.config.Debug {
 			log.Println("[DEBUG] No task available")
 		}
 		return nil
 	}
 
-	log.Printf("[INFO] Received task: JobID=%s, Type=%s", taskResp.JobID, taskResp.Type)
+	log.Printf("[INFO] Received task: TaskID=%s, StepID=%s, Action=%s", taskResp.TaskID, taskResp.StepID, taskResp.Action)
 
 	// Report task started
-	a.reportStatus(taskResp.JobID, "running", "Task started", "")
+	a.reportStatus(taskResp, "running", "Task started", "", nil)
+
+	// Stream logs to the server while the task runs
+	stream := logstream.New(a.client.UploadLogs, taskResp.TaskID)
+	stream.Start()
+	streamed := 0
+	a.executor.SetDownloader(a.client.DownloadProvider)
+	a.executor.SetLogSink(func(entry executor.LogEntry) {
+		streamed++
+		stream.Add(toClientLog(entry))
+	})
+	defer a.executor.SetLogSink(nil)
 
 	// Execute task
-	result := a.executor.Execute(taskResp.Type, taskResp.Payload)
+	result := a.executor.Execute(taskResp.Action, taskPayload(taskResp))
 
-	// Upload logs
-	a.uploadLogs(taskResp.JobID, result.Logs)
+	// Handlers that do not stream return their logs at the end
+	if streamed == 0 {
+		for _, entry := range result.Logs {
+			stream.Add(toClientLog(entry))
+		}
+	}
+
+	// Deliver buffered logs before the final status so they precede it
+	if err := stream.Close(logFlushTimeout); err != nil {
+		log.Printf("[WARN] Task %s logs incomplete: %v", taskResp.TaskID, err)
+	}
 
 	// Report final status
 	if result.Success {
-		a.reportStatus(taskResp.JobID, "success", "Task completed", "")
-		log.Printf("[INFO] Task %s completed successfully", taskResp.JobID)
+		a.reportStatus(taskResp, "success", "Task completed", "", result.Data)
+		log.Printf("[INFO] Task %s completed successfully", taskResp.TaskID)
 	} else {
-		a.reportStatus(taskResp.JobID, "failed", "Task failed", result.Error)
-		log.Printf("[ERROR] Task %s failed: %s", taskResp.JobID, result.Error)
+		a.reportStatus(taskResp, "failed", "Task failed", result.Error, result.Data)
+		log.Printf("[ERROR] Task %s failed: %s", taskResp.TaskID, result.Error)
 	}
 
 	return nil
 }
 
-// uploadLogs uploads execution logs to the server
-func (a *Agent) uploadLogs(jobID string, logs []executor.LogEntry) {
-	if len(logs) == 0 {
-		return
+// taskPayload flattens a task response into the executor payload
+func taskPayload(t *client.TaskResponse) map[string]interface{} {
+	payload := map[string]interface{}{
+		"config": t.Config,
 	}
-
-	clientLogs := make([]client.LogEntry, len(logs))
-	for i, log := range logs {
-		clientLogs[i] = client.LogEntry{
-			Timestamp: log.Timestamp,
-			Level:     log.Level,
-			Component: "agent",
-			Message:   log.Message,
+	if t.ProviderURL != "" {
+		payload["provider_id"] = t.ProviderID
+		payload["provider_url"] = t.ProviderURL
+		payload["session_key"] = t.SessionKey
+	}
+	if t.Timeout > 0 {
+		payload["timeout"] = float64(t.Timeout)
+	}
+	// Legacy handlers read their parameters from the top level
+	for k, v := range t.Config {
+		if _, exists := payload[k]; !exists {
+			payload[k] = v
 		}
 	}
+	return payload
+}
 
-	if err := a.client.UploadLogs(&client.LogUploadRequest{
-		JobID: jobID,
-		Logs:  clientLogs,
-	}); err != nil {
-		log.Printf("[ERROR] Failed to upload logs: %v", err)
+// toClientLog converts an executor log entry for upload
+func toClientLog(entry executor.LogEntry) client.LogEntry {
+	component := entry.Component
+	if component == "" {
+		component = "agent"
+	}
+	return client.LogEntry{
+		Timestamp: entry.Timestamp,
+		Level:     entry.Level,
+		Component: component,
+		Message:   entry.Message,
 	}
 }
 
 // reportStatus reports task execution status to the server
-func (a *Agent) reportStatus(jobID, status, step, errorMsg string) {
+func (a *Agent) reportStatus(task *client.TaskResponse, status, step, errorMsg string, result map[string]interface{}) {
 	if err := a.client.ReportStatus(&client.StatusReportRequest{
-		JobID:       jobID,
-		Status:      status,
-		CurrentStep: step,
-		Error:       errorMsg,
+		TaskID:   task.TaskID,
+		StepID:   task.StepID,
+		Status:   status,
+		Step:     step,
+		ErrorMsg: errorMsg,
+		Result:   result,
 	}); err != nil {
 		log.Printf("[ERROR] Failed to report status: %v", err)
 	}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -13,6 +14,9 @@ import (
 type Client struct {
 	serverURL  string
 	httpClient *http.Client
+
+	mu    sync.RWMutex
+	token string // agent token sent as a Bearer credential
 }
 
 // New creates a new CloudBoot client
@@ -25,11 +29,41 @@ func New(serverURL string) *Client {
 	}
 }
 
-// RegisterAgent registers this agent with the CloudBoot server
+// SetToken sets the agent token used to authenticate subsequent requests
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Token returns the current agent token
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// RegisterAgent registers this agent with the CloudBoot server.
+// The request must carry the bootstrap token from the kernel command line
+// unless the client already holds a valid agent token for this machine.
+// On success the issued agent token is used for subsequent requests.
 func (c *Client) RegisterAgent(req *RegisterRequest) (*RegisterResponse, error) {
 	resp := &RegisterResponse{}
-	err := c.doRequest("POST", "/api/boot/v1/register", req, resp)
-	return resp, err
+	if err := c.doRequest("POST", "/api/boot/v1/register", req, resp); err != nil {
+		return resp, err
+	}
+	c.SetToken(resp.AgentToken)
+	return resp, nil
+}
+
+// RefreshToken exchanges the current agent token for a fresh one
+func (c *Client) RefreshToken() (*TokenResponse, error) {
+	resp := &TokenResponse{}
+	if err := c.doRequest("POST", "/api/boot/v1/token", nil, resp); err != nil {
+		return resp, err
+	}
+	c.SetToken(resp.AgentToken)
+	return resp, nil
 }
 
 // GetTask polls for a new task from the server
@@ -40,9 +74,28 @@ func (c *Client) GetTask(machineID string) (*TaskResponse, error) {
 	return resp, err
 }
 
-// UploadLogs sends logs to the server
-func (c *Client) UploadLogs(req *LogUploadRequest) error {
-	return c.doRequest("POST", "/api/boot/v1/logs", req, nil)
+// DownloadProvider fetches a session-encrypted provider from a signed URL
+// issued in the task response. The URL is absolute and single-use.
+func (c *Client) DownloadProvider(providerURL string) ([]byte, error) {
+	resp, err := c.httpClient.Get(providerURL)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// UploadLogs sends a batch of logs to the server
+func (c *Client) UploadLogs(req *LogUploadRequest) (*LogUploadResponse, error) {
+	resp := &LogUploadResponse{}
+	err := c.doRequest("POST", "/api/boot/v1/logs", req, resp)
+	return resp, err
 }
 
 // ReportStatus reports task execution status
@@ -69,6 +122,9 @@ func (c *Client) doRequest(method, path string, body, result interface{}) error
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -92,33 +148,62 @@ func (c *Client) doRequest(method, path string, body, result interface{}) error
 
 // RegisterRequest represents agent registration payload
 type RegisterRequest struct {
-	MacAddress   string                 `json:"mac_address"`
-	IPAddress    string                 `json:"ip_address"`
-	HardwareSpec map[string]interface{} `json:"hardware_spec"`
+	MacAddress     string                 `json:"mac_address"`
+	IPAddress      string                 `json:"ip_address"`
+	HardwareSpec   map[string]interface{} `json:"hardware_spec"`
+	BootstrapToken string                 `json:"bootstrap_token,omitempty"` // single-use token from cloudboot.token
 }
 
 // RegisterResponse represents server response for registration
 type RegisterResponse struct {
-	MachineID string `json:"machine_id"`
-	Status    string `json:"status"`
+	MachineID      string    `json:"machine_id"`
+	Status         string    `json:"status"`
+	AgentToken     string    `json:"agent_token"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+}
+
+// TokenResponse represents a refreshed agent token
+type TokenResponse struct {
+	AgentToken     string    `json:"agent_token"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
 }
 
 // TaskResponse represents a task from the server
 type TaskResponse struct {
-	JobID      string                 `json:"job_id"`
-	Type       string                 `json:"type"`
-	Payload    map[string]interface{} `json:"payload"`
-	NoTask     bool                   `json:"no_task"`
+	TaskID      string                 `json:"task_id"`
+	StepID      string                 `json:"step_id"`
+	Action      string                 `json:"action"`
+	Attempt     int                    `json:"attempt"`
+	Timeout     int                    `json:"timeout"` // seconds, 0 means no limit
+	ProviderID  string                 `json:"provider_id"`
+	ProviderURL string                 `json:"provider_url"`
+	SessionKey  string                 `json:"session_key"` // base64
+	Config      map[string]interface{} `json:"config"`
+	Message     string                 `json:"message"`
+}
+
+// NoTask reports whether the server had nothing to run
+func (t *TaskResponse) NoTask() bool {
+	return t.TaskID == ""
 }
 
 // LogUploadRequest represents log upload payload
 type LogUploadRequest struct {
-	JobID string    `json:"job_id"`
-	Logs  []LogEntry `json:"logs"`
+	JobID  string     `json:"job_id"`
+	Stream string     `json:"stream,omitempty"` // sequence numbers are unique within a stream
+	Logs   []LogEntry `json:"logs"`
+}
+
+// LogUploadResponse acknowledges uploaded logs
+type LogUploadResponse struct {
+	LogsReceived int   `json:"logs_received"`
+	Duplicates   int   `json:"duplicates"`
+	LastSeq      int64 `json:"last_seq"` // highest sequence number the server has for the stream
 }
 
 // LogEntry represents a single log entry
 type LogEntry struct {
+	Seq       int64  `json:"seq,omitempty"`
 	Timestamp string `json:"ts"`
 	Level     string `json:"level"`
 	Component string `json:"component"`
@@ -127,8 +212,10 @@ type LogEntry struct {
 
 // StatusReportRequest represents status report payload
 type StatusReportRequest struct {
-	JobID       string `json:"job_id"`
-	Status      string `json:"status"`
-	CurrentStep string `json:"step_current"`
-	Error       string `json:"error,omitempty"`
+	TaskID   string                 `json:"task_id"`
+	StepID   string                 `json:"step_id,omitempty"`
+	Status   string                 `json:"status"`
+	Step     string                 `json:"step,omitempty"`
+	ErrorMsg string                 `json:"error_msg,omitempty"`
+	Result   map[string]interface{} `json:"result,omitempty"`
 }
@@ -1,6 +1,7 @@
 package executor
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os/exec"
@@ -9,9 +10,14 @@ import (
 
 // Executor executes tasks on the agent
 type Executor struct {
-	handlers map[string]TaskHandler
+	handlers   map[string]TaskHandler
+	downloader ProviderDownloader
+	logSink    func(LogEntry)
 }
 
+// ProviderDownloader fetches a session-encrypted provider from a signed URL
+type ProviderDownloader func(providerURL string) ([]byte, error)
+
 // TaskHandler is a function that handles a specific task type
 type TaskHandler func(payload map[string]interface{}) *ExecutionResult
 
@@ -20,12 +26,14 @@ type ExecutionResult struct {
 	Success bool
 	Error   string
 	Logs    []LogEntry
+	Data    map[string]interface{} // structured result reported to the server
 }
 
 // LogEntry represents a log entry
 type LogEntry struct{
 	Timestamp string
 	Level     string
+	Component string // empty for the agent's own logs
 	Message   string
 }
 
@@ -43,6 +51,16 @@ func New() *Executor {
 	return e
 }
 
+// SetDownloader sets how providers are fetched from the server
+func (e *Executor) SetDownloader(downloader ProviderDownloader) {
+	e.downloader = downloader
+}
+
+// SetLogSink receives log entries while a task is running
+func (e *Executor) SetLogSink(sink func(LogEntry)) {
+	e.logSink = sink
+}
+
 // RegisterHandler registers a task handler
 func (e *Executor) RegisterHandler(taskType string, handler TaskHandler) {
 	e.handlers[taskType] = handler
@@ -97,62 +115,82 @@ func (e *Executor) handleAudit(payload map[string]interface{}) *ExecutionResult
 }
 
 // handleConfigRAID handles RAID configuration task
+//
+// The provider is downloaded encrypted with a per-task session key,
+// decrypted into a memfd and executed from memory; the plaintext is never
+// written to disk.
 func (e *Executor) handleConfigRAID(payload map[string]interface{}) *ExecutionResult {
-	logs := []LogEntry{
-		{
+	logs := []LogEntry{}
+	logf := func(level, format string, args ...interface{}) {
+		entry := LogEntry{
 			Timestamp: time.Now().Format(time.RFC3339),
-			Level:     "INFO",
-			Message:   "Starting RAID configuration",
-		},
+			Level:     level,
+			Message:   fmt.Sprintf(format, args...),
+		}
+		logs = append(logs, entry)
+		if e.logSink != nil {
+			e.logSink(entry)
+		}
 	}
+	failed := func(err error) *ExecutionResult {
+		logf("ERROR", "RAID configuration failed: %v", err)
+		return &ExecutionResult{Success: false, Error: err.Error(), Logs: logs}
+	}
+
+	logf("INFO", "Starting RAID configuration")
 
-	// Extract provider info
-	providerName, _ := payload["provider"].(string)
+	providerID, _ := payload["provider_id"].(string)
+	providerURL, _ := payload["provider_url"].(string)
+	sessionKey, _ := payload["session_key"].(string)
 	config, _ := payload["config"].(map[string]interface{})
 
-	if providerName == "" {
-		return &ExecutionResult{
-			Success: false,
-			Error:   "provider name not specified",
-			Logs:    logs,
-		}
+	if providerURL == "" || sessionKey == "" {
+		return failed(fmt.Errorf("provider not specified"))
+	}
+	if e.downloader == nil {
+		return failed(fmt.Errorf("no provider downloader configured"))
 	}
 
-	logs = append(logs, LogEntry{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Level:     "INFO",
-		Message:   fmt.Sprintf("Using provider: %s", providerName),
-	})
+	logf("INFO", "Downloading provider: %s", providerID)
+	encrypted, err := e.downloader(providerURL)
+	if err != nil {
+		return failed(fmt.Errorf("provider download failed: %w", err))
+	}
 
-	// Download provider script from server (cb-exec functionality)
-	scriptPath := fmt.Sprintf("/tmp/%s.sh", providerName)
-	logs = append(logs, LogEntry{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Level:     "INFO",
-		Message:   fmt.Sprintf("Downloading provider script to %s", scriptPath),
-	})
+	runner, err := NewProviderRunner(encrypted, sessionKey)
+	if err != nil {
+		return failed(err)
+	}
+	defer runner.Close()
 
-	// TODO: Download provider script from server's Private Store
-	// For now, simulate execution
-	logs = append(logs, LogEntry{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Level:     "INFO",
-		Message:   fmt.Sprintf("Executing RAID configuration with config: %v", config),
+	if timeout, ok := payload["timeout"].(float64); ok && timeout > 0 {
+		runner.SetTimeout(time.Duration(timeout) * time.Second)
+	}
+	runner.SetLogSink(func(entry LogEntry) {
+		logs = append(logs, entry)
+		if e.logSink != nil {
+			e.logSink(entry)
+		}
 	})
 
-	// Simulate provider execution
-	time.Sleep(2 * time.Second)
+	logf("INFO", "Executing provider from memory (plan -> probe -> apply)")
+	outcome := runner.Run(context.Background(), config)
 
-	logs = append(logs, LogEntry{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Level:     "INFO",
-		Message:   "RAID configuration completed successfully",
-	})
-
-	return &ExecutionResult{
-		Success: true,
-		Logs:    logs,
+	result := &ExecutionResult{
+		Success: outcome.Success,
+		Error:   outcome.Error,
+		Data:    outcome.ToMap(),
 	}
+	if outcome.Success {
+		if outcome.Idempotent {
+			logf("INFO", "RAID already in desired state, apply skipped")
+		}
+		logf("INFO", "RAID configuration completed successfully")
+	} else {
+		logf("ERROR", "RAID configuration failed: %s", outcome.Error)
+	}
+	result.Logs = logs
+	return result
 }
 
 // handleInstallOS handles OS installation task
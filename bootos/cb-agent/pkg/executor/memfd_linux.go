@@ -0,0 +1,53 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// memExec holds a provider binary in an anonymous memory file so that
+// the decrypted bytes never touch a filesystem.
+type memExec struct {
+	file *os.File
+}
+
+// newMemExec copies data into a sealed memfd.
+func newMemExec(name string, data []byte) (*memExec, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, fmt.Errorf("memfd_create failed: %w", err)
+	}
+	file := os.NewFile(uintptr(fd), name)
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write memfd: %w", err)
+	}
+
+	// Prevent any further modification of the provider image
+	seals := unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+	if _, err := unix.FcntlInt(file.Fd(), unix.F_ADD_SEALS, seals); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seal memfd: %w", err)
+	}
+
+	return &memExec{file: file}, nil
+}
+
+// Path returns a path that can be passed to exec.
+// The path names this process's fd table rather than /proc/self, which in
+// the child would refer to the child's own table. Because the descriptor is
+// close-on-exec, only ELF binaries can be run this way: a script interpreter
+// started by the kernel cannot open the path once the descriptor is closed.
+func (m *memExec) Path() string {
+	return fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), m.file.Fd())
+}
+
+// Close releases the memory file.
+func (m *memExec) Close() error {
+	return m.file.Close()
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package executor
+
+import (
+	"errors"
+)
+
+// memExec is only available on Linux (BootOS).
+type memExec struct{}
+
+func newMemExec(name string, data []byte) (*memExec, error) {
+	return nil, errors.New("in-memory execution requires memfd_create (linux only)")
+}
+
+func (m *memExec) Path() string { return "" }
+
+func (m *memExec) Close() error { return nil }
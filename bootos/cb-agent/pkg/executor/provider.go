@@ -0,0 +1,266 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+// ProviderStep is the result of a single provider invocation
+type ProviderStep struct {
+	Name     string                 `json:"name"`
+	Success  bool                   `json:"success"`
+	ExitCode int                    `json:"exit_code"`
+	Duration time.Duration          `json:"duration"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// ProviderOutcome is the structured result reported back to the server
+type ProviderOutcome struct {
+	Success    bool           `json:"success"`
+	Idempotent bool           `json:"idempotent"` // apply skipped because probe matched desired state
+	Steps      []ProviderStep `json:"steps"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// ToMap converts the outcome to a JSON-compatible map
+func (o *ProviderOutcome) ToMap() map[string]interface{} {
+	data, _ := json.Marshal(o)
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+// ProviderRunner runs a decrypted provider from memory following the
+// same contract as the server-side cspm.Orchestrator:
+// Plan -> Probe -> (skip if converged) -> Apply -> Verify (probe).
+type ProviderRunner struct {
+	binary  *memExec
+	timeout time.Duration
+	logSink func(LogEntry)
+}
+
+// NewProviderRunner decrypts a session-encrypted provider into memory
+func NewProviderRunner(encrypted []byte, sessionKeyB64 string) (*ProviderRunner, error) {
+	sessionKey, err := base64.StdEncoding.DecodeString(sessionKeyB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session key: %w", err)
+	}
+
+	plain, err := crypto.DecryptFile(encrypted, sessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt provider: %w", err)
+	}
+
+	binary, err := newMemExec("cb-provider", plain)
+	// Drop the plaintext copy from the Go heap as soon as it is in the memfd
+	for i := range plain {
+		plain[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProviderRunner{
+		binary:  binary,
+		timeout: 5 * time.Minute,
+	}, nil
+}
+
+// SetTimeout sets the timeout for each provider invocation
+func (r *ProviderRunner) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// SetLogSink receives provider stderr log lines as they are produced
+func (r *ProviderRunner) SetLogSink(sink func(LogEntry)) {
+	r.logSink = sink
+}
+
+// Close releases the in-memory provider
+func (r *ProviderRunner) Close() error {
+	return r.binary.Close()
+}
+
+// Run executes the full provider sequence
+func (r *ProviderRunner) Run(ctx context.Context, config map[string]interface{}) *ProviderOutcome {
+	outcome := &ProviderOutcome{Steps: make([]ProviderStep, 0, 4)}
+	fail := func(step *ProviderStep, err error) *ProviderOutcome {
+		if step != nil {
+			outcome.Steps = append(outcome.Steps, *step)
+		}
+		outcome.Error = err.Error()
+		return outcome
+	}
+
+	plan, err := r.invoke(ctx, "plan", config)
+	if err != nil || !plan.Success {
+		return fail(plan, stepError("plan", plan, err))
+	}
+	outcome.Steps = append(outcome.Steps, *plan)
+
+	probe, err := r.invoke(ctx, "probe", nil)
+	if err != nil {
+		return fail(probe, stepError("probe", probe, err))
+	}
+	outcome.Steps = append(outcome.Steps, *probe)
+
+	if isConverged(probe, config) {
+		outcome.Success = true
+		outcome.Idempotent = true
+		return outcome
+	}
+
+	apply, err := r.invoke(ctx, "apply", config)
+	if err != nil || !apply.Success {
+		return fail(apply, stepError("apply", apply, err))
+	}
+	outcome.Steps = append(outcome.Steps, *apply)
+
+	verify, err := r.invoke(ctx, "probe", nil)
+	if err != nil || !verify.Success {
+		return fail(verify, stepError("verify", verify, err))
+	}
+	verify.Name = "verify"
+	outcome.Steps = append(outcome.Steps, *verify)
+
+	outcome.Success = true
+	return outcome
+}
+
+// invoke runs one provider command, streaming stderr logs to the sink
+func (r *ProviderRunner) invoke(ctx context.Context, command string, config map[string]interface{}) (*ProviderStep, error) {
+	execCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(execCtx, r.binary.Path(), command)
+	if config != nil {
+		stdin, err := json.Marshal(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal config: %w", err)
+		}
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start provider: %w", err)
+	}
+
+	// stderr must be drained before Wait
+	r.streamLogs(stderr)
+	runErr := cmd.Wait()
+
+	step := &ProviderStep{Name: command, Duration: time.Since(start)}
+	if runErr != nil {
+		exitErr, ok := runErr.(*exec.ExitError)
+		if !ok {
+			return step, fmt.Errorf("failed to execute provider: %w", runErr)
+		}
+		step.ExitCode = exitErr.ExitCode()
+	}
+
+	if stdout.Len() > 0 {
+		var result struct {
+			Status string                 `json:"status"`
+			Data   map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			return step, fmt.Errorf("failed to parse provider stdout: %w", err)
+		}
+		step.Success = result.Status == "success" && step.ExitCode == 0
+		step.Data = result.Data
+	}
+
+	return step, nil
+}
+
+// streamLogs forwards stderr lines (JSON log entries or plain text).
+// Fields the provider omits default to the current time, INFO and "provider".
+func (r *ProviderRunner) streamLogs(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry struct {
+			Timestamp string `json:"ts"`
+			Level     string `json:"level"`
+			Component string `json:"component"`
+			Message   string `json:"msg"`
+		}
+		logEntry := LogEntry{Timestamp: time.Now().Format(time.RFC3339), Level: "INFO", Component: "provider", Message: string(line)}
+		if err := json.Unmarshal(line, &entry); err == nil && entry.Message != "" {
+			logEntry.Message = entry.Message
+			if entry.Timestamp != "" {
+				logEntry.Timestamp = entry.Timestamp
+			}
+			if entry.Level != "" {
+				logEntry.Level = entry.Level
+			}
+			if entry.Component != "" {
+				logEntry.Component = entry.Component
+			}
+		}
+
+		if r.logSink != nil {
+			r.logSink(logEntry)
+		}
+	}
+}
+
+func stepError(name string, step *ProviderStep, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return fmt.Errorf("%s failed: provider reported failure (exit code %d)", name, step.ExitCode)
+}
+
+// isConverged checks whether probe data already contains a virtual drive
+// matching config.desired_state (same rule as cspm.Orchestrator)
+func isConverged(probe *ProviderStep, config map[string]interface{}) bool {
+	if !probe.Success || probe.Data == nil {
+		return false
+	}
+
+	desired, ok := config["desired_state"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	level, _ := desired["level"].(string)
+	drives, ok := desired["drives"].([]interface{})
+	if !ok {
+		return false
+	}
+
+	vds, _ := probe.Data["virtual_drives"].([]interface{})
+	for _, raw := range vds {
+		vd, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		current, _ := vd["drives"].([]interface{})
+		if vd["level"] == level && len(current) == len(drives) {
+			return true
+		}
+	}
+	return false
+}
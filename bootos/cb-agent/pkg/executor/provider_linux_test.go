@@ -0,0 +1,170 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+const mockStateFile = "/tmp/cloudboot-provider-mock-state.json"
+
+// buildMockProvider compiles cmd/provider-mock and encrypts it with a fresh session key
+func buildMockProvider(t *testing.T) ([]byte, string) {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	bin := filepath.Join(t.TempDir(), "provider-mock")
+	cmd := exec.Command("go", "build", "-o", bin, "github.com/cloudboot/cloudboot-ng/cmd/provider-mock")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build provider-mock: %v\n%s", err, out)
+	}
+
+	plain, err := os.ReadFile(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := crypto.EncryptFile(plain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted, base64.StdEncoding.EncodeToString(key)
+}
+
+func TestProviderRunner_Run(t *testing.T) {
+	encrypted, sessionKey := buildMockProvider(t)
+	os.Remove(mockStateFile)
+	defer os.Remove(mockStateFile)
+
+	config := map[string]interface{}{
+		"action": "create_raid",
+		"desired_state": map[string]interface{}{
+			"level":  "raid10",
+			"drives": []interface{}{"0:1", "0:2", "0:3", "0:4"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		steps      []string
+	}{
+		{"first run applies", false, []string{"plan", "probe", "apply", "verify"}},
+		{"second run converged", true, []string{"plan", "probe"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, err := NewProviderRunner(encrypted, sessionKey)
+			if err != nil {
+				t.Fatalf("NewProviderRunner() error = %v", err)
+			}
+			defer runner.Close()
+
+			var logs []LogEntry
+			runner.SetLogSink(func(entry LogEntry) { logs = append(logs, entry) })
+
+			outcome := runner.Run(context.Background(), config)
+			if !outcome.Success {
+				t.Fatalf("Run() failed: %s", outcome.Error)
+			}
+			if outcome.Idempotent != tt.idempotent {
+				t.Errorf("Idempotent = %v, want %v", outcome.Idempotent, tt.idempotent)
+			}
+			if len(outcome.Steps) != len(tt.steps) {
+				t.Fatalf("got %d steps, want %d", len(outcome.Steps), len(tt.steps))
+			}
+			for i, name := range tt.steps {
+				if outcome.Steps[i].Name != name {
+					t.Errorf("step %d = %s, want %s", i, outcome.Steps[i].Name, name)
+				}
+			}
+			if len(logs) == 0 {
+				t.Error("expected provider stderr logs to be streamed")
+			}
+		})
+	}
+}
+
+func TestProviderRunner_BadSessionKey(t *testing.T) {
+	encrypted, _ := buildMockProvider(t)
+
+	wrong := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if _, err := NewProviderRunner(encrypted, wrong); err == nil {
+		t.Error("expected decryption to fail with the wrong session key")
+	}
+}
+
+func TestExecutor_ConfigRAID(t *testing.T) {
+	encrypted, sessionKey := buildMockProvider(t)
+	os.Remove(mockStateFile)
+	defer os.Remove(mockStateFile)
+
+	e := New()
+	e.SetDownloader(func(string) ([]byte, error) { return encrypted, nil })
+	streamed := 0
+	e.SetLogSink(func(LogEntry) { streamed++ })
+
+	result := e.Execute("config_raid", map[string]interface{}{
+		"provider_id":  "provider-mock",
+		"provider_url": "http://server/api/boot/v1/providers/provider-mock/blob",
+		"session_key":  sessionKey,
+		"config": map[string]interface{}{
+			"desired_state": map[string]interface{}{
+				"level":  "raid1",
+				"drives": []interface{}{"0:1", "0:2"},
+			},
+		},
+	})
+
+	if !result.Success {
+		t.Fatalf("Execute() failed: %s", result.Error)
+	}
+	if result.Data["success"] != true {
+		t.Errorf("result data = %v, want success", result.Data)
+	}
+	if streamed != len(result.Logs) {
+		t.Errorf("streamed %d logs, result has %d", streamed, len(result.Logs))
+	}
+}
+
+func TestProviderRunner_StreamLogs(t *testing.T) {
+	stderr := strings.Join([]string{
+		`{"ts":"2026-03-01T12:00:00Z","level":"ERROR","component":"raid","msg":"PD 0:1 offline"}`,
+		`{"level":"WARN","msg":"no timestamp"}`,
+		`plain text`,
+	}, "\n")
+
+	var got []LogEntry
+	r := &ProviderRunner{logSink: func(e LogEntry) { got = append(got, e) }}
+	r.streamLogs(strings.NewReader(stderr))
+
+	if len(got) != 3 {
+		t.Fatalf("got %d entries, want 3", len(got))
+	}
+	if got[0].Timestamp != "2026-03-01T12:00:00Z" || got[0].Level != "ERROR" || got[0].Component != "raid" || got[0].Message != "PD 0:1 offline" {
+		t.Errorf("entry[0] = %+v", got[0])
+	}
+	for _, e := range got[1:] {
+		if e.Timestamp == "" || e.Component != "provider" {
+			t.Errorf("entry %+v: want default timestamp and component", e)
+		}
+	}
+	if got[1].Level != "WARN" || got[2].Level != "INFO" || got[2].Message != "plain text" {
+		t.Errorf("entries = %+v", got[1:])
+	}
+}
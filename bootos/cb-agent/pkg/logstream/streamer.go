@@ -0,0 +1,216 @@
+package logstream
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/client"
+)
+
+const (
+	defaultBatchSize   = 50
+	defaultMaxBuffered = 10000
+	defaultInterval    = 500 * time.Millisecond
+	maxBackoff         = 30 * time.Second
+)
+
+// Uploader sends a batch of logs to the server
+type Uploader func(req *client.LogUploadRequest) (*client.LogUploadResponse, error)
+
+// Streamer streams task logs to the server while the task runs.
+//
+// Every line gets a sequence number within the stream. Lines stay buffered
+// until the server acknowledges them (last_seq), so batches that fail while
+// the server is unreachable are replayed on reconnect; the server drops
+// sequence numbers it has already seen.
+type Streamer struct {
+	upload      Uploader
+	jobID       string
+	stream      string
+	batchSize   int
+	maxBuffered int
+	interval    time.Duration
+
+	mu      sync.Mutex
+	buf     []client.LogEntry
+	seq     int64
+	dropped int
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New creates a streamer for a task
+func New(upload Uploader, jobID string) *Streamer {
+	return &Streamer{
+		upload:      upload,
+		jobID:       jobID,
+		stream:      newStreamID(),
+		batchSize:   defaultBatchSize,
+		maxBuffered: defaultMaxBuffered,
+		interval:    defaultInterval,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Start begins flushing logs in the background
+func (s *Streamer) Start() {
+	go s.loop()
+}
+
+// Add queues a log line
+// When the buffer is full the oldest unsent lines are dropped.
+func (s *Streamer) Add(entry client.LogEntry) {
+	s.mu.Lock()
+	s.seq++
+	entry.Seq = s.seq
+	s.buf = append(s.buf, entry)
+	if over := len(s.buf) - s.maxBuffered; over > 0 {
+		s.buf = s.buf[over:]
+		s.dropped += over
+	}
+	full := len(s.buf) >= s.batchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Pending returns the number of lines not yet acknowledged by the server
+func (s *Streamer) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buf)
+}
+
+// Close stops the background loop and tries to deliver the remaining lines
+// until the timeout expires. Lines still buffered afterwards are lost.
+func (s *Streamer) Close(timeout time.Duration) error {
+	close(s.done)
+	<-s.stopped
+
+	deadline := time.Now().Add(timeout)
+	backoff := s.interval
+	var lastErr error
+	for s.Pending() > 0 {
+		if time.Now().After(deadline) {
+			if lastErr != nil {
+				return fmt.Errorf("%d log lines not delivered: %w", s.Pending(), lastErr)
+			}
+			return fmt.Errorf("%d log lines not delivered before timeout", s.Pending())
+		}
+		if lastErr = s.flush(); lastErr != nil {
+			time.Sleep(min(backoff, time.Until(deadline)))
+			backoff = nextBackoff(backoff)
+		}
+	}
+
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+	if dropped > 0 {
+		return fmt.Errorf("%d log lines dropped while the server was unreachable", dropped)
+	}
+	return nil
+}
+
+// loop flushes periodically, backing off while uploads fail
+func (s *Streamer) loop() {
+	defer close(s.stopped)
+
+	delay := s.interval
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		if err := s.flush(); err != nil {
+			if delay == s.interval {
+				log.Printf("[WARN] Log upload failed, buffering %d lines: %v", s.Pending(), err)
+			}
+			delay = nextBackoff(delay)
+		} else {
+			delay = s.interval
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(delay)
+	}
+}
+
+// flush uploads the oldest unacknowledged batch
+func (s *Streamer) flush() error {
+	s.mu.Lock()
+	n := min(len(s.buf), s.batchSize)
+	batch := make([]client.LogEntry, n)
+	copy(batch, s.buf[:n])
+	s.mu.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+
+	resp, err := s.upload(&client.LogUploadRequest{
+		JobID:  s.jobID,
+		Stream: s.stream,
+		Logs:   batch,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Servers without sequence support do not report last_seq;
+	// treat the batch as delivered.
+	acked := resp.LastSeq
+	if acked == 0 {
+		acked = batch[n-1].Seq
+	}
+	s.ack(acked)
+	return nil
+}
+
+// ack removes lines up to and including seq
+func (s *Streamer) ack(seq int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.buf) && s.buf[i].Seq <= seq {
+		i++
+	}
+	s.buf = s.buf[i:]
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	return min(d*2, maxBackoff)
+}
+
+func newStreamID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package logstream
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/bootos/cb-agent/pkg/client"
+)
+
+// fakeServer mimics the server's per-stream sequence dedup
+type fakeServer struct {
+	mu       sync.Mutex
+	lastSeq  int64
+	received []string
+	// failures decides per call whether the upload fails and whether
+	// the server processed the batch before the failure (lost response)
+	failures []string
+	calls    int
+}
+
+func (f *fakeServer) upload(req *client.LogUploadRequest) (*client.LogUploadResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	mode := ""
+	if f.calls < len(f.failures) {
+		mode = f.failures[f.calls]
+	}
+	f.calls++
+
+	if mode == "down" {
+		return nil, errors.New("connection refused")
+	}
+	for _, entry := range req.Logs {
+		if entry.Seq > f.lastSeq {
+			f.lastSeq = entry.Seq
+			f.received = append(f.received, entry.Message)
+		}
+	}
+	if mode == "lost" {
+		return nil, errors.New("response lost")
+	}
+	return &client.LogUploadResponse{LastSeq: f.lastSeq}, nil
+}
+
+func TestStreamer_ReplayAfterFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []string
+	}{
+		{"server up", nil},
+		{"server down then back", []string{"down", "down"}},
+		{"response lost", []string{"lost", "lost"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeServer{failures: tt.failures}
+			s := New(server.upload, "job-1")
+			s.interval = 5 * time.Millisecond
+			s.batchSize = 3
+			s.Start()
+
+			for i := 0; i < 10; i++ {
+				s.Add(client.LogEntry{Level: "INFO", Message: fmt.Sprintf("line %d", i)})
+			}
+			if err := s.Close(5 * time.Second); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if len(server.received) != 10 {
+				t.Fatalf("server received %d lines, want 10: %v", len(server.received), server.received)
+			}
+			for i, msg := range server.received {
+				if want := fmt.Sprintf("line %d", i); msg != want {
+					t.Errorf("line %d = %q, want %q", i, msg, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamer_BufferLimit(t *testing.T) {
+	server := &fakeServer{failures: []string{"down", "down", "down", "down", "down", "down"}}
+	s := New(server.upload, "job-1")
+	s.interval = time.Hour // no background flush
+	s.maxBuffered = 5
+	s.Start()
+
+	for i := 0; i < 8; i++ {
+		s.Add(client.LogEntry{Message: fmt.Sprintf("line %d", i)})
+	}
+	if got := s.Pending(); got != 5 {
+		t.Errorf("Pending() = %d, want 5", got)
+	}
+
+	if err := s.Close(0); err == nil {
+		t.Error("Close() with server down should report undelivered lines")
+	}
+}
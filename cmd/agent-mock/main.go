@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/cloudboot/cloudboot-ng/internal/models"
@@ -24,8 +26,15 @@ type AgentConfig struct {
 	Heartbeats  int
 	Interval    int // seconds
 	ModifyHW    bool
+	// BootstrapToken 注册用的引导令牌，为空时像真实机器一样从iPXE脚本中获取
+	BootstrapToken string
+	// AgentToken 注册后获得的Agent令牌，心跳时携带
+	AgentToken string
 }
 
+// bootstrapParam iPXE脚本内核参数中的引导令牌
+var bootstrapParam = regexp.MustCompile(`cloudboot\.token=(\S+)`)
+
 func main() {
 	config := parseFlags()
 
@@ -36,14 +45,24 @@ func main() {
 	log.Printf("   - Heartbeats: %d", config.Heartbeats)
 	log.Printf("   - Interval: %ds", config.Interval)
 
-	// 第一步：注册
+	// 第一步：获取引导令牌（模拟PXE启动）
+	if config.BootstrapToken == "" {
+		token, err := fetchBootstrapToken(config)
+		if err != nil {
+			log.Fatalf("❌ 获取引导令牌失败: %v", err)
+		}
+		config.BootstrapToken = token
+		log.Printf("🔑 已从iPXE脚本获取引导令牌")
+	}
+
+	// 第二步：注册
 	machineID, err := register(config)
 	if err != nil {
 		log.Fatalf("❌ 注册失败: %v", err)
 	}
 	log.Printf("✅ 注册成功: machine_id=%s", machineID)
 
-	// 第二步：发送心跳
+	// 第三步：发送心跳
 	if config.Heartbeats > 0 {
 		log.Printf("📡 开始发送心跳...")
 		for i := 0; i < config.Heartbeats; i++ {
@@ -78,11 +97,31 @@ func parseFlags() *AgentConfig {
 	flag.IntVar(&config.Heartbeats, "heartbeats", 5, "Number of heartbeats to send")
 	flag.IntVar(&config.Interval, "interval", 2, "Heartbeat interval in seconds")
 	flag.BoolVar(&config.ModifyHW, "modify-hw", false, "Modify hardware on 3rd heartbeat")
+	flag.StringVar(&config.BootstrapToken, "bootstrap-token", "", "Bootstrap token (default: fetched from the iPXE script)")
 	flag.Parse()
 
 	return config
 }
 
+// fetchBootstrapToken 请求iPXE启动脚本并提取 cloudboot.token 内核参数
+func fetchBootstrapToken(config *AgentConfig) (string, error) {
+	resp, err := http.Get(config.ServerURL + "/boot/ipxe/" + config.MacAddress)
+	if err != nil {
+		return "", fmt.Errorf("HTTP请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	script, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("读取iPXE脚本失败: %w", err)
+	}
+	match := bootstrapParam.FindSubmatch(script)
+	if match == nil {
+		return "", fmt.Errorf("iPXE脚本中没有引导令牌（机器不处于discovery模式？）")
+	}
+	return string(match[1]), nil
+}
+
 func register(config *AgentConfig) (string, error) {
 	url := config.ServerURL + "/api/boot/v1/register"
 
@@ -91,8 +130,9 @@ func register(config *AgentConfig) (string, error) {
 	payload := map[string]interface{}{
 		"mac_address":   config.MacAddress,
 		"ip_address":    "10.0.2.15",
-		"hostname":      config.Hostname,
-		"hardware_spec": hwSpec,
+		"hostname":        config.Hostname,
+		"hardware_spec":   hwSpec,
+		"bootstrap_token": config.BootstrapToken,
 	}
 
 	body, _ := json.Marshal(payload)
@@ -112,6 +152,7 @@ func register(config *AgentConfig) (string, error) {
 		Message      string `json:"message"`
 		HeartbeatURL string `json:"heartbeat_url"`
 		PollInterval int    `json:"poll_interval_seconds"`
+		AgentToken   string `json:"agent_token"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -123,6 +164,7 @@ func register(config *AgentConfig) (string, error) {
 	log.Printf("   - HeartbeatURL: %s", result.HeartbeatURL)
 	log.Printf("   - PollInterval: %ds", result.PollInterval)
 
+	config.AgentToken = result.AgentToken
 	return result.MachineID, nil
 }
 
@@ -139,7 +181,13 @@ func heartbeat(config *AgentConfig, machineID string, modifyHW bool) (bool, erro
 	}
 
 	body, _ := json.Marshal(payload)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.AgentToken)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return false, fmt.Errorf("HTTP请求失败: %w", err)
 	}
@@ -0,0 +1,387 @@
+// cbp Provider包（.cbp）打包工具
+//
+// build 加密Provider二进制并与manifest、schema、水印一起签名打包；
+// inspect/verify/extract 按服务端导入时相同的规则读取包（条目布局、规范摘要和签名）。
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/keystore"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+const usage = `Usage: cbp <command> [flags] [package.cbp]
+
+Commands:
+  build      加密Provider二进制，嵌入manifest、schema和水印并签名
+  inspect    打印manifest、水印、schema和签名状态
+  verify     校验包的条目布局和签名（与服务端导入时的检查一致），失败时退出码为1
+  extract    解出包内条目（不解密）
+
+build flags:
+  -manifest     manifest.json（必填，id/version不能为空）
+  -binary       Provider可执行文件（必填）
+  -schema       meta/schema.json（可选）
+  -watermark    watermark.json（可选）
+  -license-id   覆盖水印中的License ID
+  -sign-key     签名私钥PEM（必填）
+  -master-key   Master Key文件（keytool generate 的输出，或hex/base64的32字节密钥）；
+                不指定时使用服务端密钥库（KEY_PROVIDER、KEYSTORE_FILE 等环境变量）
+  -key-version  Master Key版本（master-key文件不含版本时必填；使用密钥库时默认当前版本）
+  -o            输出文件（默认 <id>-<version>.cbp）
+
+inspect/verify flags:
+  -pubkey       签名公钥PEM（也可传入签名私钥PEM；默认 $OFFICIAL_PUBKEY_FILE 或 ./data/keys/official_pub.pem）
+
+extract flags:
+  -o            输出目录（默认为包名去掉 .cbp）
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "build":
+		err = runBuild(args)
+	case "inspect":
+		err = runInspect(args)
+	case "verify":
+		err = runVerify(args)
+	case "extract":
+		err = runExtract(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	return fs
+}
+
+// packageArg 解析参数并返回唯一的包路径
+func packageArg(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	return fs.Arg(0)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func runBuild(args []string) error {
+	fs := newFlagSet("build")
+	manifestFile := fs.String("manifest", "", "manifest.json")
+	binaryFile := fs.String("binary", "", "provider executable")
+	schemaFile := fs.String("schema", "", "schema.json")
+	watermarkFile := fs.String("watermark", "", "watermark.json")
+	licenseID := fs.String("license-id", "", "watermark license ID")
+	signKeyFile := fs.String("sign-key", "", "signing private key (PEM)")
+	masterKeyFile := fs.String("master-key", "", "master key file")
+	keyVersion := fs.Int("key-version", 0, "master key version")
+	output := fs.String("o", "", "output file")
+	fs.Parse(args)
+
+	if *manifestFile == "" || *binaryFile == "" || *signKeyFile == "" {
+		return errors.New("-manifest, -binary and -sign-key are required")
+	}
+
+	pkg := &cspm.CBPPackage{}
+	if err := readJSON(*manifestFile, &pkg.Manifest); err != nil {
+		return err
+	}
+	if pkg.Manifest.ID == "" || pkg.Manifest.Version == "" {
+		return fmt.Errorf("%s: id and version are required", *manifestFile)
+	}
+	if pkg.Manifest.CreatedAt == "" {
+		pkg.Manifest.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if *watermarkFile != "" {
+		if err := readJSON(*watermarkFile, &pkg.Watermark); err != nil {
+			return err
+		}
+	}
+	if *licenseID != "" {
+		pkg.Watermark.LicenseID = *licenseID
+	}
+	if pkg.Watermark.DownloadTime == "" {
+		pkg.Watermark.DownloadTime = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if *schemaFile != "" {
+		data, err := os.ReadFile(*schemaFile)
+		if err != nil {
+			return err
+		}
+		if _, err := cspm.ParseSchema(data); err != nil {
+			return fmt.Errorf("%s: %w", *schemaFile, err)
+		}
+		pkg.Schema = data
+	}
+
+	signer, err := loadPrivateKey(*signKeyFile)
+	if err != nil {
+		return err
+	}
+	key, err := loadMasterKey(*masterKeyFile, *keyVersion)
+	if err != nil {
+		return err
+	}
+	pkg.Manifest.KeyVersion = key.Version
+
+	binary, err := os.ReadFile(*binaryFile)
+	if err != nil {
+		return err
+	}
+	if pkg.ProviderBinary, err = crypto.EncryptFile(binary, key.Material); err != nil {
+		return fmt.Errorf("failed to encrypt provider: %w", err)
+	}
+
+	path := *output
+	if path == "" {
+		path = pkg.Manifest.ID + "-" + pkg.Manifest.Version + ".cbp"
+	}
+	if err := cspm.CreateCBP(pkg, signer, path); err != nil {
+		return err
+	}
+	// 重新解析一次，确保产物能通过服务端的导入检查
+	if _, err := cspm.ParseCBP(path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("built package is invalid: %w", err)
+	}
+
+	log.Printf("🔐 Provider已用Master Key v%d 加密", key.Version)
+	if pkg.Watermark.LicenseID != "" {
+		log.Printf("✅ 水印已嵌入（License ID: %s）", pkg.Watermark.LicenseID)
+	}
+	log.Printf("📦 Package created: %s (digest %s)", path, hex.EncodeToString(pkg.Digest))
+	return nil
+}
+
+func runInspect(args []string) error {
+	fs := newFlagSet("inspect")
+	pubKeyFile := fs.String("pubkey", envOr("OFFICIAL_PUBKEY_FILE", "./data/keys/official_pub.pem"), "signing public key (PEM)")
+	path := packageArg(fs, args)
+
+	pkg, err := cspm.ParseCBP(path)
+	if err != nil {
+		return err
+	}
+
+	m := pkg.Manifest
+	fmt.Printf("Manifest:\n")
+	fmt.Printf("  id:          %s\n", m.ID)
+	fmt.Printf("  name:        %s\n", m.Name)
+	fmt.Printf("  version:     %s\n", m.Version)
+	fmt.Printf("  vendor:      %s %s\n", m.Vendor, m.Model)
+	fmt.Printf("  hardware:    %s\n", strings.Join(m.SupportedHardware, ", "))
+	fmt.Printf("  author:      %s\n", m.Author)
+	fmt.Printf("  created_at:  %s\n", m.CreatedAt)
+	fmt.Printf("  key_version: %d\n", m.KeyVersion)
+
+	w := pkg.Watermark
+	fmt.Printf("Watermark:\n")
+	fmt.Printf("  license_id:      %s\n", w.LicenseID)
+	fmt.Printf("  organization_id: %s\n", w.OrganizationID)
+	fmt.Printf("  downloader_id:   %s\n", w.DownloaderID)
+	fmt.Printf("  transaction_id:  %s\n", w.TransactionID)
+	fmt.Printf("  download_time:   %s\n", w.DownloadTime)
+
+	if pkg.Schema == nil {
+		fmt.Printf("Schema: none\n")
+	} else {
+		schema, _ := cspm.ParseSchema(pkg.Schema)
+		fmt.Printf("Schema: version %s, %d parameters\n", schema.Version, len(schema.Parameters))
+		for _, p := range schema.Parameters {
+			required := ""
+			if p.Required {
+				required = " (required)"
+			}
+			fmt.Printf("  %-20s %s%s\n", p.Name, p.Type, required)
+		}
+	}
+
+	fmt.Printf("Provider: %d bytes encrypted\n", len(pkg.ProviderBinary))
+	fmt.Printf("Digest:   %s\n", hex.EncodeToString(pkg.Digest))
+
+	publicKey, err := loadPublicKey(*pubKeyFile)
+	switch {
+	case err != nil:
+		fmt.Printf("Signature: not checked (%v)\n", err)
+	case cspm.VerifyCBP(pkg, publicKey) != nil:
+		fmt.Printf("Signature: INVALID\n")
+	default:
+		fmt.Printf("Signature: valid\n")
+	}
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs := newFlagSet("verify")
+	pubKeyFile := fs.String("pubkey", envOr("OFFICIAL_PUBKEY_FILE", "./data/keys/official_pub.pem"), "signing public key (PEM)")
+	path := packageArg(fs, args)
+
+	publicKey, err := loadPublicKey(*pubKeyFile)
+	if err != nil {
+		return err
+	}
+	pkg, err := cspm.ParseCBP(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("provider: %s %s (key v%d)\n", pkg.Manifest.ID, pkg.Manifest.Version, pkg.Manifest.KeyVersion)
+	fmt.Printf("digest:   %s\n", hex.EncodeToString(pkg.Digest))
+	if err := cspm.VerifyCBP(pkg, publicKey); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	log.Printf("✅ %s 签名有效", path)
+	return nil
+}
+
+func runExtract(args []string) error {
+	fs := newFlagSet("extract")
+	output := fs.String("o", "", "output directory")
+	path := packageArg(fs, args)
+
+	dir := *output
+	if dir == "" {
+		dir = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := cspm.ExtractCBP(path, dir); err != nil {
+		return err
+	}
+	log.Printf("✅ 已解出到 %s", dir)
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// loadPublicKey 读取公钥PEM；传入私钥PEM时取其公钥（开发签名密钥）
+func loadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	if key, err := crypto.PublicKeyFromPEM(string(data)); err == nil {
+		return key, nil
+	}
+	if key, err := crypto.PrivateKeyFromPEM(string(data)); err == nil {
+		return &key.PublicKey, nil
+	}
+	return nil, errors.New("no ECDSA key found in " + path)
+}
+
+func loadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	return crypto.PrivateKeyFromPEM(string(data))
+}
+
+// loadMasterKey 从文件读取Master Key；未指定文件时使用服务端密钥库
+func loadMasterKey(path string, version int) (*keystore.Key, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key: %w", err)
+		}
+		key, err := parseMasterKey(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if version > 0 {
+			key.Version = version
+		}
+		if key.Version <= 0 {
+			return nil, errors.New("-key-version is required when the master key file has no version")
+		}
+		return key, nil
+	}
+
+	cfg := keystore.ConfigFromEnv()
+	if cfg.Type == keystore.TypeFile {
+		// 打包工具只读取已有的密钥库，不隐式创建
+		if _, err := os.Stat(cfg.File); err != nil {
+			return nil, fmt.Errorf("no -master-key given and keystore unavailable: %w", err)
+		}
+	}
+	keys, err := keystore.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if version > 0 {
+		return keys.Get(version)
+	}
+	return keys.Current()
+}
+
+// parseMasterKey 解析 "MASTER_KEY_V<n>=<base64>"（keytool generate 的输出）或裸hex/base64密钥
+func parseMasterKey(s string) (*keystore.Key, error) {
+	s = strings.TrimSpace(s)
+	key := &keystore.Key{}
+	if strings.HasPrefix(s, keystore.EnvPrefix) {
+		name, value, _ := strings.Cut(s, "=")
+		version, err := strconv.Atoi(strings.TrimPrefix(name, keystore.EnvPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key version in %s", name)
+		}
+		key.Version = version
+		s = value
+	}
+
+	material, err := hex.DecodeString(s)
+	if err != nil {
+		if material, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("master key is neither hex nor base64")
+		}
+	}
+	if len(material) != keystore.KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keystore.KeySize, len(material))
+	}
+	key.Material = material
+	return key, nil
+}
@@ -0,0 +1,175 @@
+// keytool Master Key离线管理工具
+//
+// 与服务端读取相同的环境变量（KEY_PROVIDER、KEYSTORE_FILE、KEYSTORE_PASSPHRASE 等，
+// 见 keystore.ConfigFromEnv）。rotate/rewrap 会改写Store中的Provider，执行前需停止服务。
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/keystore"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+const usage = `Usage: keytool <command> [flags]
+
+Commands:
+  init       创建文件密钥库（已存在时只校验口令）
+  list       列出Master Key版本
+  rotate     生成新版本，并把Store中的Provider重新加密到新版本（需停止服务）
+  rewrap     把Store中的Provider重新加密到当前版本（env/KMS来源新增版本后使用，需停止服务）
+  generate   生成新的Master Key，输出 MASTER_KEY_V<n> 环境变量（配置KMS时输出包装后的值）
+
+Flags:
+  -store-dir  Provider Store目录（默认 $STORE_DIR 或 ./data/store）
+  -version    generate 输出的版本号
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	defaultStore := os.Getenv("STORE_DIR")
+	if defaultStore == "" {
+		defaultStore = "./data/store"
+	}
+	storeDir := fs.String("store-dir", defaultStore, "Provider store directory")
+	version := fs.Int("version", 0, "key version for generate")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	fs.Parse(os.Args[2:])
+
+	cfg := keystore.ConfigFromEnv()
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = runInit(cfg)
+	case "list":
+		err = runList(cfg)
+	case "rotate":
+		err = runRotate(cfg, *storeDir)
+	case "rewrap":
+		err = runRewrap(cfg, *storeDir)
+	case "generate":
+		err = runGenerate(cfg, *version)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+func runInit(cfg keystore.Config) error {
+	if cfg.Type != keystore.TypeFile {
+		return fmt.Errorf("init only applies to KEY_PROVIDER=file (current: %s)", cfg.Type)
+	}
+	keys, err := keystore.Open(cfg)
+	if err != nil {
+		return err
+	}
+	current, err := keys.Current()
+	if err != nil {
+		return err
+	}
+	log.Printf("✅ 密钥库 %s 可用，当前版本 v%d", keys.Name(), current.Version)
+	return nil
+}
+
+func runList(cfg keystore.Config) error {
+	keys, err := keystore.Open(cfg)
+	if err != nil {
+		return err
+	}
+	current, err := keys.Current()
+	if err != nil {
+		return err
+	}
+	versions, err := keys.Versions()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", keys.Name())
+	for _, k := range versions {
+		marker := " "
+		if k.Version == current.Version {
+			marker = "*"
+		}
+		created := "-"
+		if !k.CreatedAt.IsZero() {
+			created = k.CreatedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%s v%d  %s\n", marker, k.Version, created)
+	}
+	return nil
+}
+
+func runRotate(cfg keystore.Config, storeDir string) error {
+	keys, err := keystore.Open(cfg)
+	if err != nil {
+		return err
+	}
+	key, err := keys.Rotate()
+	if errors.Is(err, keystore.ErrUnsupported) {
+		return fmt.Errorf("%s cannot generate keys: add the new version externally (keytool generate), then run rewrap", keys.Name())
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("🔐 已生成Master Key v%d", key.Version)
+	return rewrap(keys, storeDir)
+}
+
+func runRewrap(cfg keystore.Config, storeDir string) error {
+	keys, err := keystore.Open(cfg)
+	if err != nil {
+		return err
+	}
+	return rewrap(keys, storeDir)
+}
+
+func rewrap(keys keystore.Provider, storeDir string) error {
+	current, err := keys.Current()
+	if err != nil {
+		return err
+	}
+	n, err := cspm.RewrapStore(storeDir, keys)
+	if err != nil {
+		return fmt.Errorf("rewrap stopped after %d providers: %w", n, err)
+	}
+	log.Printf("✅ %d 个Provider已重新加密到 v%d (%s)", n, current.Version, storeDir)
+	return nil
+}
+
+func runGenerate(cfg keystore.Config, version int) error {
+	if version <= 0 {
+		return errors.New("-version is required")
+	}
+	material, err := crypto.GenerateAES256Key()
+	if err != nil {
+		return err
+	}
+	if cfg.KMSPlugin != "" {
+		kms, err := keystore.OpenKMS(cfg.KMSPlugin)
+		if err != nil {
+			return err
+		}
+		if material, err = kms.Encrypt(material); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%s%d=%s\n", keystore.EnvPrefix, version, base64.StdEncoding.EncodeToString(material))
+	return nil
+}
@@ -3,18 +3,29 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cloudboot/cloudboot-ng/internal/api"
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
 	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/dispatch"
 	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/keystore"
+	"github.com/cloudboot/cloudboot-ng/internal/core/license"
+	"github.com/cloudboot/cloudboot-ng/internal/core/logindex"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
-	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/dhcp"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/monitor"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/renderer"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/tftp"
 	"github.com/cloudboot/cloudboot-ng/web"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -50,32 +61,122 @@ func main() {
 		LogLevel: logger.Info,
 	}
 
+	// 应用通过API准备的备份恢复（需在打开数据库之前）
+	backupManager := database.NewBackupManager(dbConfig.DSN, getEnv("BACKUP_DIR", "./backups"))
+	restored, err := backupManager.ApplyStagedRestore()
+	if err != nil {
+		log.Fatalf("❌ 数据库恢复失败: %v", err)
+	} else if restored {
+		log.Println("✅ 已从备份恢复数据库")
+	}
+
 	if err := database.Init(dbConfig); err != nil {
 		log.Fatalf("❌ 数据库初始化失败: %v", err)
 	}
 	defer database.Close()
 
+	// 操作审计表只允许追加
+	if err := audit.EnsureAppendOnly(database.GetDB()); err != nil {
+		log.Fatalf("❌ 审计表初始化失败: %v", err)
+	}
+	if restored {
+		// 恢复后的审计链截止于备份时刻，记录恢复事件作为新的起点
+		if err := audit.Record(database.GetDB(), &models.AuditEvent{Actor: "system", Action: "backup.apply"}, nil, nil); err != nil {
+			log.Printf("⚠️  审计记录写入失败: backup.apply: %v", err)
+		}
+	}
+
+	// 初始化认证服务，首次启动时创建初始管理员
+	sessionTTL, err := time.ParseDuration(getEnv("SESSION_TTL", "12h"))
+	if err != nil {
+		log.Printf("⚠️  会话有效期配置无效，使用默认值12h: %v", err)
+		sessionTTL = auth.DefaultSessionTTL
+	}
+	authService := auth.NewService(database.GetDB(), sessionTTL)
+	if err := authService.SyncBuiltinRoles(); err != nil {
+		log.Fatalf("❌ 内置角色同步失败: %v", err)
+	}
+	admin, generated, err := authService.EnsureAdmin(getEnv("ADMIN_USERNAME", "admin"), os.Getenv("ADMIN_PASSWORD"))
+	if err != nil {
+		log.Fatalf("❌ 初始管理员创建失败: %v", err)
+	}
+	if admin != nil {
+		log.Printf("🔑 已创建初始管理员: %s", admin.Username)
+		if generated != "" {
+			log.Printf("🔑 初始管理员密码: %s （仅显示一次，请登录后立即修改）", generated)
+		}
+	}
+
+	// 初始化Agent凭据签发（签名密钥需跨重启保持，AGENT_TOKEN_TTL 默认1小时）
+	agentKey, err := agentauth.LoadOrCreateKey(getEnv("AGENT_KEY_FILE", "./data/agent.key"))
+	if err != nil {
+		log.Fatalf("❌ Agent签名密钥加载失败: %v", err)
+	}
+	agentTokenTTL, err := time.ParseDuration(getEnv("AGENT_TOKEN_TTL", "1h"))
+	if err != nil {
+		log.Printf("⚠️  Agent令牌有效期配置无效，使用默认值1h: %v", err)
+		agentTokenTTL = agentauth.DefaultTokenTTL
+	}
+	agentAuthority := agentauth.New(database.GetDB(), agentKey, agentTokenTTL)
+
 	// 初始化系统监控
 	monitor.Init()
 	log.Println("✅ 系统监控初始化完成")
 
 	// 初始化LogBroker
 	broker := logbroker.NewBroker()
+	logStore, err := logbroker.NewFileStore(getEnv("LOG_DIR", "./data/logs"))
+	if err != nil {
+		log.Fatalf("❌ 任务日志存储初始化失败: %v", err)
+	}
+	logIndex, err := logindex.New(database.GetDB())
+	if err != nil {
+		log.Fatalf("❌ 任务日志索引初始化失败: %v", err)
+	}
+	logIndex.Start()
+	defer logIndex.Stop()
+	broker.SetStore(logindex.Wrap(logStore, logIndex))
 	log.Println("✅ LogBroker初始化完成")
 
+	// 任务日志保留策略 (LOG_RETENTION，默认30天)
+	logRetention, err := time.ParseDuration(getEnv("LOG_RETENTION", "720h"))
+	if err != nil {
+		log.Printf("⚠️  日志保留期配置无效，使用默认值720h: %v", err)
+		logRetention = 720 * time.Hour
+	}
+	retention := logbroker.NewRetention(broker.Store(), logRetention, time.Hour)
+	retention.Start()
+	defer retention.Stop()
+
 	// 初始化数据库备份调度器
-	backupDir := getEnv("BACKUP_DIR", "./backups")
 	backupInterval := getEnv("BACKUP_INTERVAL", "24h")
 	interval, err := time.ParseDuration(backupInterval)
 	if err != nil {
 		log.Printf("⚠️  备份间隔配置无效，使用默认值24h: %v", err)
 		interval = 24 * time.Hour
 	}
-	backupManager := database.NewBackupManager(dbConfig.DSN, backupDir)
 	backupScheduler := database.NewBackupScheduler(backupManager, interval)
 	backupScheduler.Start()
 	log.Println("✅ 数据库备份调度器已启动")
 
+	// 启动工作流超时巡检
+	stepSweeper := workflow.NewSweeper(database.GetDB(), time.Minute, workflow.NewFinisher(broker))
+	stepSweeper.Start()
+	defer stepSweeper.Stop()
+
+	// 初始化内置DHCP/ProxyDHCP (DHCP_MODE=full|proxy，留空则不启用)
+	// full 模式下的租约用于校验iPXE和安装配置请求来自目标机器
+	var leases api.LeaseSource
+	if dhcpServer := startDHCPServer(); dhcpServer != nil {
+		defer dhcpServer.Stop()
+		leases = dhcpServer
+	}
+
+	// 初始化内置TFTP (TFTP_ENABLED=1 启用)
+	if tftpServer := startTFTPServer(); tftpServer != nil {
+		defer tftpServer.Stop()
+	}
+
 	// 检测运行模式 (DEV=1 开发模式, 默认生产模式)
 	isDev := getEnv("DEV", "") != ""
 
@@ -110,9 +211,17 @@ func main() {
 	e.Renderer = templateRenderer
 
 	// 中间件
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
+	// CORS默认关闭（仅同源访问），需要跨域调用API时通过 CORS_ALLOW_ORIGINS 显式配置
+	if origins := getEnv("CORS_ALLOW_ORIGINS", ""); origins != "" {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins: strings.Split(origins, ","),
+			AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, api.HeaderTenantID},
+		}))
+		log.Printf("ℹ️  CORS已启用: %s", origins)
+	}
 
 	// 静态文件服务
 	if isDev {
@@ -130,7 +239,7 @@ func main() {
 	}
 
 	// 路由
-	setupRoutes(e, broker)
+	setupRoutes(e, broker, logIndex, authService, agentAuthority, leases, backupManager, isDev)
 
 	// 启动信息
 	port := getEnv("PORT", "8080")
@@ -145,49 +254,132 @@ func main() {
 	}
 }
 
-func setupRoutes(e *echo.Echo, broker *logbroker.Broker) {
+func setupRoutes(e *echo.Echo, broker *logbroker.Broker, logIndex *logindex.Index, authService *auth.Service, agentAuthority *agentauth.Authority, leases api.LeaseSource, backupManager *database.BackupManager, isDev bool) {
 	// ========== DRM/安全初始化 ==========
-	// TODO(生产环境): 从安全存储(HSM/Vault)加载Master Key和License
-	// 当前为开发环境临时方案
+	// Master Key来自密钥来源 (KEY_PROVIDER=file|hsm|env，见 keystore.ConfigFromEnv)，
+	// 按版本保存，重启后仍能解密Store中的Provider；轮换使用 cmd/keytool 离线执行
+	keyConfig := keystore.ConfigFromEnv()
+	keys, err := keystore.Open(keyConfig)
+	if err != nil {
+		log.Fatalf("❌ Master Key加载失败: %v", err)
+	}
+	masterKey, err := keys.Current()
+	if err != nil {
+		log.Fatalf("❌ Master Key加载失败 (%s): %v", keys.Name(), err)
+	}
+	log.Printf("🔐 Master Key: %s (当前版本 v%d)", keys.Name(), masterKey.Version)
+	if keyConfig.Type == keystore.TypeFile && keyConfig.Passphrase == "" {
+		log.Printf("⚠️  密钥库口令保存在 %s，生产环境请通过 KEYSTORE_PASSPHRASE 或独立存储的口令文件提供", keyConfig.PassphraseFile)
+	}
 
-	masterKey, err := crypto.GenerateAES256Key()
+	// 官方ECDSA公钥 (OFFICIAL_PUBKEY_FILE)，用于校验License和Provider包签名
+	pubKeyFile := getEnv("OFFICIAL_PUBKEY_FILE", "./data/keys/official_pub.pem")
+	officialPubKey, err := license.LoadPublicKey(pubKeyFile)
 	if err != nil {
-		log.Fatalf("❌ Master Key生成失败: %v", err)
+		if !os.IsNotExist(err) {
+			log.Fatalf("❌ 官方公钥加载失败: %v", err)
+		}
+		// 生产模式必须提供官方公钥，否则任何人都能用本地密钥签发License和Provider包
+		if !isDev {
+			log.Fatalf("❌ 官方公钥不存在: %s (请通过 OFFICIAL_PUBKEY_FILE 指定，开发环境可设置 DEV=1 使用本地签名密钥)", pubKeyFile)
+		}
+		// 开发模式没有官方公钥时使用本地签名密钥 (DEV_SIGNING_KEY_FILE)，跨重启保持
+		devKeyFile := getEnv("DEV_SIGNING_KEY_FILE", "./data/keys/dev_signing.pem")
+		privateKey, err := keystore.LoadOrCreateSigningKey(devKeyFile)
+		if err != nil {
+			log.Fatalf("❌ 开发签名密钥加载失败: %v", err)
+		}
+		officialPubKey = &privateKey.PublicKey
+		log.Printf("⚠️  开发模式: 未找到官方公钥 %s，使用本地签名密钥 %s", pubKeyFile, devKeyFile)
 	}
-	log.Println("⚠️  开发模式: 使用临时生成的Master Key (生产环境需从HSM加载)")
 
-	// 生成临时ECDSA密钥对 (开发环境)
-	privateKey, err := crypto.GenerateECDSAKeyPair()
+	// License：启动时重新校验已导入的License，LICENSE_FILE 指定时先导入该文件
+	// 过期后宽限期 LICENSE_GRACE_PERIOD (默认14天) 内商业功能仍可用
+	gracePeriod, err := time.ParseDuration(getEnv("LICENSE_GRACE_PERIOD", "336h"))
 	if err != nil {
-		log.Fatalf("❌ ECDSA密钥对生成失败: %v", err)
+		log.Printf("⚠️  License宽限期配置无效，使用默认值336h: %v", err)
+		gracePeriod = license.DefaultGracePeriod
 	}
-	officialPubKey := &privateKey.PublicKey
-	log.Println("⚠️  开发模式: 使用临时生成的ECDSA公钥 (生产环境需使用官方公钥)")
+	licenseManager := license.NewManager(database.GetDB(), officialPubKey, gracePeriod)
+	if err := licenseManager.Load(); err != nil {
+		log.Fatalf("❌ License加载失败: %v", err)
+	}
+	if licenseFile := getEnv("LICENSE_FILE", ""); licenseFile != "" {
+		data, err := os.ReadFile(licenseFile)
+		if err == nil {
+			_, err = licenseManager.Import(data)
+		}
+		if err != nil {
+			log.Printf("❌ License文件导入失败: %s: %v", licenseFile, err)
+		}
+	}
+	licenseManager.Start(12 * time.Hour)
 
-	// 当前License ID (开发环境使用默认值)
-	currentLicenseID := getEnv("LICENSE_ID", "dev-license-00000000")
+	// 水印校验使用当前生效的License ID，导入新License后同步更新
+	currentLicenseID := "unlicensed"
+	if current := licenseManager.Current(); current != nil {
+		currentLicenseID = current.ID
+	}
 	log.Printf("📋 当前License ID: %s", currentLicenseID)
 
 	// 初始化PluginManager (带DRM支持)
 	storeDir := getEnv("STORE_DIR", "./data/store")
-	pluginManager, err := cspm.NewPluginManager(storeDir, masterKey, officialPubKey, currentLicenseID)
+	pluginManager, err := cspm.NewPluginManager(database.GetDB(), storeDir, keys, officialPubKey, currentLicenseID)
 	if err != nil {
 		log.Fatalf("❌ PluginManager初始化失败: %v", err)
 	}
 	log.Println("✅ PluginManager初始化完成 (含DRM安全机制)")
+	licenseManager.OnChange(func(current *models.License) {
+		if current != nil {
+			pluginManager.SetLicenseID(current.ID)
+		}
+	})
 
 	// 初始化Handler
 	machineHandler := api.NewMachineHandler()
-	jobHandler := api.NewJobHandler()
-	bootHandler := api.NewBootHandler(broker)
-	agentHandler := api.NewAgentHandler() // 新增：标准Agent硬件上报协议
-	pxeHandler := api.NewPXEHandler(getEnv("SERVER_URL", "http://localhost:8080")) // 新增：PXE/iPXE启动
-	bootConfigHandler := api.NewBootConfigHandler(getEnv("SERVER_URL", "http://localhost:8080")) // 新增：Boot配置
+	machineHandler.SetLicenses(licenseManager)
+	machineHandler.SetAgentAuthority(agentAuthority)
+	jobHandler := api.NewJobHandler(workflow.NewFinisher(broker))
+	bootHandler := api.NewBootHandler(broker, agentAuthority)
+	dispatcher := dispatch.NewDispatcher(pluginManager, getEnv("SERVER_URL", "http://localhost:8080"))
+	dispatcher.SetLicenses(licenseManager)
+	bootHandler.SetDispatcher(dispatcher)
+	agentHandler := api.NewAgentHandler(agentAuthority) // 新增：标准Agent硬件上报协议
+	pxeHandler := api.NewPXEHandler(getEnv("SERVER_URL", "http://localhost:8080"), agentAuthority) // 新增：PXE/iPXE启动
+	bootConfigHandler := api.NewBootConfigHandler(getEnv("SERVER_URL", "http://localhost:8080"), agentAuthority) // 新增：Boot配置
+	pxeHandler.SetLeases(leases)
+	bootConfigHandler.SetLeases(leases)
 	streamHandler := api.NewStreamHandler(broker)
+	logHandler := api.NewLogHandler(broker)
+	logHandler.SetIndex(logIndex)
 	demoHandler := api.NewDemoHandler(broker)
-	profileHandler := api.NewProfileHandler()
+	profileHandler := api.NewProfileHandler(getEnv("SERVER_URL", "http://localhost:8080"))
 	storeHandler := api.NewStoreHandler(pluginManager)
 	webHandler := api.NewWebHandler(pluginManager)
+	authHandler := api.NewAuthHandler(authService)
+	userHandler := api.NewUserHandler(authService)
+	roleHandler := api.NewRoleHandler(authService)
+	backupHandler := api.NewBackupHandler(backupManager)
+	auditHandler := api.NewAuditHandler()
+	tenantHandler := api.NewTenantHandler()
+	licenseHandler := api.NewLicenseHandler(licenseManager)
+
+	// 认证：/api/v1、Web控制台和日志流需要登录或API令牌
+	// Boot API 与 PXE 由裸机/Agent调用，不在此列（Boot API 使用Agent令牌认证）
+	// 认证后按License与调用者确定租户范围
+	authenticate := api.RequireAuth(authService)
+	resolveTenant := api.ResolveTenant(licenseManager)
+	requireAuth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return authenticate(resolveTenant(next))
+	}
+	// 商业功能需要可用的License（过期后宽限期内仍可用）
+	licensed := func(feature string) echo.MiddlewareFunc {
+		return api.RequireFeature(licenseManager, feature)
+	}
+	multiTenant := licensed(models.FeatureMultiTenant)
+	can := api.RequirePermission
+	requireAgent := api.RequireAgent(agentAuthority)
+	identifyAgent := api.IdentifyAgent(agentAuthority)
 
 	// 健康检查
 	e.GET("/health", func(c echo.Context) error {
@@ -207,32 +399,42 @@ func setupRoutes(e *echo.Echo, broker *logbroker.Broker) {
 	})
 
 
+	// 登录/注销
+	e.GET("/login", authHandler.LoginPage)
+	e.POST("/login", authHandler.Login)
+	e.POST("/logout", authHandler.Logout)
+
 	// Design System 页面
-	e.GET("/design-system", webHandler.DesignSystemPage)
+	e.GET("/design-system", webHandler.DesignSystemPage, requireAuth)
 
 	// Frontend Pages
-	e.GET("/", webHandler.HomePage)
-	e.GET("/machines", webHandler.MachinesPage)
-	e.GET("/jobs", webHandler.JobsPage)
-	e.GET("/jobs/:job_id/logs", jobLogsPageHandler)
-	e.GET("/os-designer", webHandler.OSDesignerPage)
-	e.GET("/store", webHandler.StorePage)
-	e.GET("/settings", webHandler.SettingsPage)
+	e.GET("/", webHandler.HomePage, requireAuth)
+	e.GET("/machines", webHandler.MachinesPage, requireAuth, can(auth.PermMachineRead))
+	e.GET("/jobs", webHandler.JobsPage, requireAuth, can(auth.PermJobRead))
+	e.GET("/jobs/:job_id/logs", jobLogsPageHandler, requireAuth, can(auth.PermJobRead))
+	e.GET("/os-designer", webHandler.OSDesignerPage, requireAuth, can(auth.PermProfileRead))
+	e.GET("/store", webHandler.StorePage, requireAuth, can(auth.PermStoreRead))
+	e.GET("/settings", webHandler.SettingsPage, requireAuth)
 
 	// Boot API (Agent ↔ Core)
+	// 注册用iPXE下发的引导令牌换取Agent令牌，其余接口携带 Authorization: Bearer <agent_token>
 	bootAPI := e.Group("/api/boot/v1")
 	{
 		// 标准硬件上报协议 (agent_handler.go)
-		bootAPI.POST("/register", agentHandler.Register)   // Agent首次注册
-		bootAPI.POST("/heartbeat", agentHandler.Heartbeat) // Agent心跳（定期上报）
+		bootAPI.POST("/register", agentHandler.Register, identifyAgent)  // Agent首次注册
+		bootAPI.POST("/heartbeat", agentHandler.Heartbeat, requireAgent) // Agent心跳（定期上报）
+		bootAPI.POST("/token", agentHandler.RefreshToken, requireAgent)  // Agent令牌刷新
 
 		// 兼容老协议 (boot_handler.go)
-		bootAPI.POST("/register-legacy", bootHandler.RegisterAgent)
+		bootAPI.POST("/register-legacy", bootHandler.RegisterAgent, identifyAgent)
 
 		// 任务管理
-		bootAPI.GET("/task", bootHandler.GetTask)
-		bootAPI.POST("/logs", bootHandler.UploadLogs)
-		bootAPI.POST("/status", bootHandler.ReportStatus)
+		bootAPI.GET("/task", bootHandler.GetTask, requireAgent)
+		bootAPI.POST("/logs", bootHandler.UploadLogs, requireAgent)
+		bootAPI.POST("/status", bootHandler.ReportStatus, identifyAgent) // 安装器携带安装器凭据，无Agent令牌
+
+		// Provider下载（会话密钥加密，一次性链接）
+		bootAPI.GET("/providers/:id/blob", bootHandler.DownloadProvider)
 	}
 
 	// PXE/iPXE Boot (裸机网络启动)
@@ -244,46 +446,100 @@ func setupRoutes(e *echo.Echo, broker *logbroker.Broker) {
 		// OS安装配置文件
 		bootGroup.GET("/kickstart/:machine_id", bootConfigHandler.ServeKickstart)   // RHEL/CentOS
 		bootGroup.GET("/autoyast/:machine_id", bootConfigHandler.ServeAutoYaST)     // SUSE/openSUSE
-		// TODO: Ubuntu Autoinstall, Debian Preseed
+		bootGroup.GET("/autoinstall/:machine_id/:file", bootConfigHandler.ServeAutoinstall) // Ubuntu (NoCloud: user-data/meta-data/vendor-data)
+		bootGroup.GET("/preseed/:machine_id", bootConfigHandler.ServePreseed)                 // Debian
 	}
 
 	// External API
-	apiV1 := e.Group("/api/v1")
+	apiV1 := e.Group("/api/v1", requireAuth)
 	{
+		// Auth endpoints (当前用户、修改密码、API令牌)
+		apiV1.GET("/auth/me", authHandler.Me)
+		apiV1.POST("/auth/password", authHandler.ChangePassword)
+		apiV1.GET("/auth/tokens", authHandler.ListTokens)
+		apiV1.POST("/auth/tokens", authHandler.CreateToken)
+		apiV1.DELETE("/auth/tokens/:id", authHandler.RevokeToken)
+
+		// User endpoints
+		apiV1.GET("/users", userHandler.ListUsers, can(auth.PermUserManage))
+		apiV1.POST("/users", userHandler.CreateUser, can(auth.PermUserManage))
+		apiV1.PUT("/users/:id", userHandler.UpdateUser, can(auth.PermUserManage))
+		apiV1.DELETE("/users/:id", userHandler.DeleteUser, can(auth.PermUserManage))
+
+		// Role endpoints
+		apiV1.GET("/roles", roleHandler.ListRoles, can(auth.PermRoleManage))
+		apiV1.POST("/roles", roleHandler.CreateRole, can(auth.PermRoleManage))
+		apiV1.PUT("/roles/:name", roleHandler.UpdateRole, can(auth.PermRoleManage))
+		apiV1.DELETE("/roles/:name", roleHandler.DeleteRole, can(auth.PermRoleManage))
+		apiV1.GET("/permissions", roleHandler.ListPermissions, can(auth.PermRoleManage))
+
 		// Machine endpoints
-		apiV1.GET("/machines", machineHandler.ListMachines)
-		apiV1.GET("/machines/:id", machineHandler.GetMachine)
-		apiV1.POST("/machines", machineHandler.CreateMachine)
-		apiV1.PUT("/machines/:id", machineHandler.UpdateMachine)
-		apiV1.DELETE("/machines/:id", machineHandler.DeleteMachine)
-		apiV1.POST("/machines/:id/provision", machineHandler.ProvisionMachine)
+		apiV1.GET("/machines", machineHandler.ListMachines, can(auth.PermMachineRead))
+		apiV1.GET("/machines/:id", machineHandler.GetMachine, can(auth.PermMachineRead))
+		apiV1.POST("/machines", machineHandler.CreateMachine, can(auth.PermMachineWrite))
+		apiV1.PUT("/machines/:id", machineHandler.UpdateMachine, can(auth.PermMachineWrite))
+		apiV1.DELETE("/machines/:id", machineHandler.DeleteMachine, can(auth.PermMachineDelete))
+		apiV1.POST("/machines/:id/provision", machineHandler.ProvisionMachine, can(auth.PermMachineProvision))
+		apiV1.GET("/machines/:id/events", machineHandler.ListMachineEvents, can(auth.PermMachineRead))
+		apiV1.POST("/machines/:id/reenroll", machineHandler.ApproveReenroll, can(auth.PermMachineWrite))
 
 		// Job endpoints
-		apiV1.GET("/jobs", jobHandler.ListJobs)
-		apiV1.GET("/jobs/:id", jobHandler.GetJob)
-		apiV1.DELETE("/jobs/:id", jobHandler.CancelJob)
+		apiV1.GET("/jobs", jobHandler.ListJobs, can(auth.PermJobRead))
+		apiV1.GET("/jobs/:id", jobHandler.GetJob, can(auth.PermJobRead))
+		apiV1.GET("/jobs/:id/steps", jobHandler.ListJobSteps, can(auth.PermJobRead))
+		apiV1.GET("/jobs/:id/logs", logHandler.DownloadLogs, can(auth.PermJobRead))
+		apiV1.DELETE("/jobs/:id", jobHandler.CancelJob, can(auth.PermJobCancel))
 
-		// Profile endpoints
-		apiV1.GET("/profiles", profileHandler.ListProfiles)
-		apiV1.GET("/profiles/:id", profileHandler.GetProfile)
-		apiV1.POST("/profiles", profileHandler.CreateProfile)
-		apiV1.PUT("/profiles/:id", profileHandler.UpdateProfile)
-		apiV1.DELETE("/profiles/:id", profileHandler.DeleteProfile)
-		apiV1.POST("/profiles/:id/preview", profileHandler.PreviewConfig)
-		apiV1.POST("/profiles/preview", profileHandler.PreviewFromPayload)
+		// Log search endpoints
+		apiV1.GET("/logs/search", logHandler.SearchLogs, can(auth.PermJobRead))
 
-		// Store endpoints (Private Store for Provider packages)
-		apiV1.POST("/store/import", storeHandler.ImportProvider)
-		apiV1.GET("/store/providers", storeHandler.ListProviders)
-		apiV1.GET("/store/providers/:id", storeHandler.GetProvider)
-		apiV1.DELETE("/store/providers/:id", storeHandler.DeleteProvider)
+		// Profile endpoints
+		apiV1.GET("/profiles", profileHandler.ListProfiles, can(auth.PermProfileRead))
+		apiV1.GET("/profiles/:id", profileHandler.GetProfile, can(auth.PermProfileRead))
+		apiV1.POST("/profiles", profileHandler.CreateProfile, can(auth.PermProfileWrite))
+		apiV1.PUT("/profiles/:id", profileHandler.UpdateProfile, can(auth.PermProfileWrite))
+		apiV1.DELETE("/profiles/:id", profileHandler.DeleteProfile, can(auth.PermProfileWrite))
+		apiV1.POST("/profiles/:id/preview", profileHandler.PreviewConfig, can(auth.PermProfileRead))
+		apiV1.POST("/profiles/preview", profileHandler.PreviewFromPayload, can(auth.PermProfileRead))
+
+		// Store endpoints (Private Store for Provider packages，导入离线包需要 offline_bundle)
+		apiV1.POST("/store/import", storeHandler.ImportProvider, licensed(models.FeatureOfflineBundle), can(auth.PermStoreImport))
+		apiV1.GET("/store/providers", storeHandler.ListProviders, can(auth.PermStoreRead))
+		apiV1.GET("/store/providers/:id", storeHandler.GetProvider, can(auth.PermStoreRead))
+		apiV1.DELETE("/store/providers/:id", storeHandler.DeleteProvider, can(auth.PermStoreDelete))
+		apiV1.GET("/store/providers/:id/schema", storeHandler.GetProviderSchema, can(auth.PermStoreRead))
+		apiV1.GET("/store/providers/:id/versions", storeHandler.ListVersions, can(auth.PermStoreRead))
+		apiV1.POST("/store/providers/:id/upgrade", storeHandler.UpgradeProvider, can(auth.PermStoreImport))
+		apiV1.POST("/store/providers/:id/rollback", storeHandler.RollbackProvider, can(auth.PermStoreImport))
+		apiV1.DELETE("/store/providers/:id/versions/:version", storeHandler.DeleteVersion, can(auth.PermStoreDelete))
+
+		// Backup endpoints (恢复在服务重启后生效)
+		apiV1.GET("/backups", backupHandler.ListBackups, can(auth.PermBackupRead))
+		apiV1.POST("/backups", backupHandler.CreateBackup, can(auth.PermBackupCreate))
+		apiV1.POST("/backups/:name/restore", backupHandler.RestoreBackup, can(auth.PermBackupRestore))
+
+		// License endpoints
+		apiV1.GET("/license", licenseHandler.GetLicense)
+		apiV1.POST("/license", licenseHandler.ImportLicense, can(auth.PermLicenseManage))
+
+		// Tenant endpoints (需要License启用 multi_tenant)
+		apiV1.GET("/tenants", tenantHandler.ListTenants, multiTenant, can(auth.PermTenantRead))
+		apiV1.GET("/tenants/:id", tenantHandler.GetTenant, multiTenant, can(auth.PermTenantRead))
+		apiV1.POST("/tenants", tenantHandler.CreateTenant, multiTenant, can(auth.PermTenantManage))
+		apiV1.PUT("/tenants/:id", tenantHandler.UpdateTenant, multiTenant, can(auth.PermTenantManage))
+		apiV1.DELETE("/tenants/:id", tenantHandler.DeleteTenant, multiTenant, can(auth.PermTenantManage))
+
+		// Audit endpoints (操作审计，只读，需要License启用 audit；审计记录始终写入)
+		apiV1.GET("/audit", auditHandler.ListEvents, licensed(models.FeatureAudit), can(auth.PermAuditRead))
+		apiV1.GET("/audit/export", auditHandler.ExportEvents, licensed(models.FeatureAudit), can(auth.PermAuditRead))
+		apiV1.GET("/audit/verify", auditHandler.VerifyChain, licensed(models.FeatureAudit), can(auth.PermAuditRead))
 	}
 
 	// Stream API (SSE)
-	e.GET("/api/stream/logs/:job_id", streamHandler.StreamLogs)
+	e.GET("/api/stream/logs/:job_id", streamHandler.StreamLogs, requireAuth, can(auth.PermJobRead))
 
 	// Demo API (演示Orchestrator执行)
-	e.POST("/api/demo/orchestrator", demoHandler.TriggerOrchestratorDemo)
+	e.POST("/api/demo/orchestrator", demoHandler.TriggerOrchestratorDemo, requireAuth, can(auth.PermMachineProvision))
 }
 
 func designSystemHandler(c echo.Context) error {
@@ -428,7 +684,7 @@ func jobLogsPageHandler(c echo.Context) error {
 
 	// 获取Job信息
 	var job models.Job
-	if err := database.DB.Preload("Machine").First(&job, "id = ?", jobID).Error; err != nil {
+	if err := api.CurrentScope(c).Apply(database.DB).Preload("Machine").First(&job, "id = ?", jobID).Error; err != nil {
 		return c.String(404, "Job not found")
 	}
 
@@ -438,6 +694,78 @@ func jobLogsPageHandler(c echo.Context) error {
 	})
 }
 
+// startDHCPServer 根据环境变量启动内置DHCP服务器
+func startDHCPServer() *dhcp.Server {
+	mode := dhcp.Mode(getEnv("DHCP_MODE", ""))
+	if mode == "" {
+		log.Println("ℹ️  内置DHCP未启用 (设置DHCP_MODE=full|proxy启用)")
+		return nil
+	}
+
+	leaseTime, err := time.ParseDuration(getEnv("DHCP_LEASE_TIME", "12h"))
+	if err != nil {
+		log.Printf("⚠️  DHCP租约时长配置无效，使用默认值12h: %v", err)
+		leaseTime = 12 * time.Hour
+	}
+
+	var dnsServers []net.IP
+	for _, addr := range strings.Split(getEnv("DHCP_DNS", ""), ",") {
+		if ip := net.ParseIP(strings.TrimSpace(addr)); ip != nil {
+			dnsServers = append(dnsServers, ip)
+		}
+	}
+
+	config := dhcp.Config{
+		Mode:          mode,
+		ListenAddr:    getEnv("DHCP_LISTEN", "0.0.0.0:67"),
+		ServerIP:      net.ParseIP(getEnv("DHCP_SERVER_IP", "")),
+		HTTPServerURL: getEnv("SERVER_URL", "http://localhost:8080"),
+		BootFiles: dhcp.BootFiles{
+			BIOS:  getEnv("DHCP_BOOTFILE_BIOS", "undionly.kpxe"),
+			EFI64: getEnv("DHCP_BOOTFILE_EFI64", "ipxe.efi"),
+			ARM64: getEnv("DHCP_BOOTFILE_ARM64", "ipxe-arm64.efi"),
+		},
+		RangeStart: net.ParseIP(getEnv("DHCP_RANGE_START", "")),
+		RangeEnd:   net.ParseIP(getEnv("DHCP_RANGE_END", "")),
+		SubnetMask: net.ParseIP(getEnv("DHCP_SUBNET_MASK", "")),
+		Router:     net.ParseIP(getEnv("DHCP_ROUTER", "")),
+		DNS:        dnsServers,
+		LeaseTime:  leaseTime,
+	}
+
+	server, err := dhcp.NewServer(config, dhcp.NewMachineReservations())
+	if err != nil {
+		log.Fatalf("❌ DHCP服务器配置无效: %v", err)
+	}
+	if err := server.Start(); err != nil {
+		log.Fatalf("❌ DHCP服务器启动失败: %v", err)
+	}
+
+	return server
+}
+
+// startTFTPServer 根据环境变量启动内置TFTP服务器
+// 嵌入的iPXE固件和按MAC生成的iPXE脚本直接从内存提供，其余文件从TFTP_ROOT读取
+func startTFTPServer() *tftp.Server {
+	if getEnv("TFTP_ENABLED", "") == "" {
+		log.Println("ℹ️  内置TFTP未启用 (设置TFTP_ENABLED=1启用)")
+		return nil
+	}
+
+	firmware, err := web.GetIPXEAssets()
+	if err != nil {
+		log.Fatalf("❌ 获取嵌入iPXE固件失败: %v", err)
+	}
+
+	server := tftp.NewServer(getEnv("TFTP_LISTEN", ":69"), getEnv("TFTP_ROOT", "./data/tftpboot"))
+	server.SetFileHook(tftp.NewIPXEHook(firmware, getEnv("SERVER_URL", "http://localhost:8080")))
+	if err := server.Start(); err != nil {
+		log.Fatalf("❌ TFTP服务器启动失败: %v", err)
+	}
+
+	return server
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
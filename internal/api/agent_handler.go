@@ -4,9 +4,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/lifecycle"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/google/uuid"
@@ -15,20 +19,23 @@ import (
 
 // AgentHandler Agent相关API处理器
 type AgentHandler struct {
-	// 可以注入依赖
+	authority *agentauth.Authority
 }
 
 // NewAgentHandler 创建Agent处理器
-func NewAgentHandler() *AgentHandler {
-	return &AgentHandler{}
+func NewAgentHandler(authority *agentauth.Authority) *AgentHandler {
+	return &AgentHandler{
+		authority: authority,
+	}
 }
 
 // RegisterRequest Agent注册请求
 type RegisterRequest struct {
-	MacAddress   string              `json:"mac_address" validate:"required"`
-	IPAddress    string              `json:"ip_address"`
-	Hostname     string              `json:"hostname"`
-	HardwareSpec models.HardwareInfo `json:"hardware_spec" validate:"required"`
+	MacAddress     string              `json:"mac_address" validate:"required"`
+	IPAddress      string              `json:"ip_address"`
+	Hostname       string              `json:"hostname"`
+	HardwareSpec   models.HardwareInfo `json:"hardware_spec" validate:"required"`
+	BootstrapToken string              `json:"bootstrap_token"` // iPXE内核参数 cloudboot.token 下发的引导令牌
 }
 
 // RegisterResponse Agent注册响应
@@ -39,6 +46,9 @@ type RegisterResponse struct {
 	HeartbeatURL  string `json:"heartbeat_url"`
 	TaskPollURL   string `json:"task_poll_url"`
 	PollInterval  int    `json:"poll_interval_seconds"` // 心跳间隔（秒）
+	// AgentToken 调用Boot API的Agent令牌（Authorization: Bearer），过期前通过 /api/boot/v1/token 刷新
+	AgentToken     string    `json:"agent_token"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
 }
 
 // HeartbeatRequest Agent心跳请求
@@ -59,7 +69,8 @@ type HeartbeatResponse struct {
 
 // Register Agent注册 (POST /api/boot/v1/register)
 //
-// Agent首次启动时调用此API注册机器信息
+// Agent首次启动时调用此API注册机器信息，用iPXE下发的引导令牌换取Agent令牌；
+// 已持有本机Agent令牌的Agent重新注册时无需引导令牌
 func (h *AgentHandler) Register(c echo.Context) error {
 	var req RegisterRequest
 	if err := c.Bind(&req); err != nil {
@@ -74,6 +85,20 @@ func (h *AgentHandler) Register(c echo.Context) error {
 			"error": "mac_address is required",
 		})
 	}
+	mac, ok := parseMACAddress(req.MacAddress)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid mac_address",
+		})
+	}
+	req.MacAddress = mac
+
+	bootstrap, code, msg := authorizeRegistration(c, h.authority, req.MacAddress, req.BootstrapToken)
+	if code != http.StatusOK {
+		return c.JSON(code, map[string]string{
+			"error": msg,
+		})
+	}
 
 	// 设置默认Schema版本
 	if req.HardwareSpec.SchemaVersion == "" {
@@ -86,11 +111,43 @@ func (h *AgentHandler) Register(c echo.Context) error {
 
 	if err == nil {
 		// 机器已存在 - 更新信息
-		return h.updateExistingMachine(c, &machine, &req)
+		if err := h.authority.CheckBootstrap(machine.ID, bootstrap); err != nil {
+			return reenrollForbidden(c)
+		}
+		return h.updateExistingMachine(c, &machine, &req, bootstrap)
 	}
 
 	// 机器不存在 - 创建新记录
-	return h.createNewMachine(c, &req)
+	return h.createNewMachine(c, &req, bootstrap)
+}
+
+// RefreshToken 刷新Agent令牌 (POST /api/boot/v1/token)
+//
+// Agent在令牌过期前调用，换取新的令牌（不轮换凭据，旧令牌在过期前仍然有效）
+func (h *AgentHandler) RefreshToken(c echo.Context) error {
+	agent := CurrentAgent(c)
+	if agent == nil {
+		return agentUnauthenticated(c)
+	}
+
+	var machine models.Machine
+	if err := database.DB.First(&machine, "id = ?", agent.MachineID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Machine not found",
+		})
+	}
+
+	token, expiresAt, err := h.authority.Issue(&machine, nil)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to issue agent token",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"agent_token":      token,
+		"token_expires_at": expiresAt,
+	})
 }
 
 // Heartbeat Agent心跳 (POST /api/boot/v1/heartbeat)
@@ -111,6 +168,11 @@ func (h *AgentHandler) Heartbeat(c echo.Context) error {
 		})
 	}
 
+	// 只能上报令牌所属机器的心跳
+	if !agentOwns(c, req.MachineID) {
+		return agentForbidden(c)
+	}
+
 	// 查找机器
 	var machine models.Machine
 	err := database.DB.First(&machine, "id = ?", req.MachineID).Error
@@ -121,7 +183,7 @@ func (h *AgentHandler) Heartbeat(c echo.Context) error {
 	}
 
 	// 验证MAC地址是否匹配（防止伪造）
-	if machine.MacAddress != req.MacAddress {
+	if normalizeMACAddress(machine.MacAddress) != normalizeMACAddress(req.MacAddress) {
 		return c.JSON(http.StatusForbidden, map[string]string{
 			"error": "MAC address mismatch",
 		})
@@ -150,6 +212,7 @@ func (h *AgentHandler) Heartbeat(c echo.Context) error {
 			"error": "Failed to update machine",
 		})
 	}
+	promoteIfInventoried(&machine)
 
 	// 返回响应
 	resp := HeartbeatResponse{
@@ -163,7 +226,7 @@ func (h *AgentHandler) Heartbeat(c echo.Context) error {
 }
 
 // createNewMachine 创建新机器记录
-func (h *AgentHandler) createNewMachine(c echo.Context, req *RegisterRequest) error {
+func (h *AgentHandler) createNewMachine(c echo.Context, req *RegisterRequest, bootstrap *agentauth.Bootstrap) error {
 	// 生成Machine ID
 	machineID := uuid.New().String()
 
@@ -190,6 +253,7 @@ func (h *AgentHandler) createNewMachine(c echo.Context, req *RegisterRequest) er
 			"error": "Failed to create machine record",
 		})
 	}
+	promoteIfInventoried(&machine)
 
 	// 返回注册成功响应
 	resp := RegisterResponse{
@@ -200,12 +264,20 @@ func (h *AgentHandler) createNewMachine(c echo.Context, req *RegisterRequest) er
 		TaskPollURL:  "/api/boot/v1/task",
 		PollInterval: 30, // 30秒心跳间隔
 	}
+	if err := h.attachToken(&resp, &machine, bootstrap); err != nil {
+		if errors.Is(err, agentauth.ErrAlreadyEnrolled) {
+			return reenrollForbidden(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to issue agent token",
+		})
+	}
 
 	return c.JSON(http.StatusCreated, resp)
 }
 
 // updateExistingMachine 更新已存在的机器记录
-func (h *AgentHandler) updateExistingMachine(c echo.Context, machine *models.Machine, req *RegisterRequest) error {
+func (h *AgentHandler) updateExistingMachine(c echo.Context, machine *models.Machine, req *RegisterRequest, bootstrap *agentauth.Bootstrap) error {
 	// 更新IP地址
 	if req.IPAddress != "" {
 		machine.IPAddress = req.IPAddress
@@ -231,6 +303,7 @@ func (h *AgentHandler) updateExistingMachine(c echo.Context, machine *models.Mac
 			"error": "Failed to update machine",
 		})
 	}
+	promoteIfInventoried(machine)
 
 	// 返回响应
 	resp := RegisterResponse{
@@ -241,10 +314,63 @@ func (h *AgentHandler) updateExistingMachine(c echo.Context, machine *models.Mac
 		TaskPollURL:  "/api/boot/v1/task",
 		PollInterval: 30,
 	}
+	if err := h.attachToken(&resp, machine, bootstrap); err != nil {
+		if errors.Is(err, agentauth.ErrAlreadyEnrolled) {
+			return reenrollForbidden(c)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to issue agent token",
+		})
+	}
 
 	return c.JSON(http.StatusOK, resp)
 }
 
+// attachToken 签发Agent令牌并写入注册响应
+func (h *AgentHandler) attachToken(resp *RegisterResponse, machine *models.Machine, bootstrap *agentauth.Bootstrap) error {
+	token, expiresAt, err := h.authority.Issue(machine, bootstrap)
+	if err != nil {
+		return err
+	}
+	resp.AgentToken = token
+	resp.TokenExpiresAt = expiresAt
+	return nil
+}
+
+// authorizeRegistration 校验注册凭据：本机有效的Agent令牌，或iPXE为该MAC下发的一次性引导令牌
+// 使用引导令牌时返回其签发信息（持Agent令牌时为nil），校验失败时返回错误状态码和信息；
+// 引导令牌只能由iPXE请求时的来源地址使用
+func authorizeRegistration(c echo.Context, authority *agentauth.Authority, mac, bootstrapToken string) (*agentauth.Bootstrap, int, string) {
+	if agent := CurrentAgent(c); agent != nil {
+		if normalizeMACAddress(agent.MacAddress) != normalizeMACAddress(mac) {
+			return nil, http.StatusForbidden, "Agent token does not belong to this MAC address"
+		}
+		return nil, http.StatusOK, ""
+	}
+
+	bootstrap, err := authority.ConsumeBootstrap(bootstrapToken, normalizeMACAddress(mac), remoteIP(c))
+	if err != nil {
+		if !errors.Is(err, agentauth.ErrInvalidBootstrap) {
+			log.Printf("⚠️  引导令牌校验失败: %v", err)
+		}
+		return nil, http.StatusUnauthorized, "Valid bootstrap_token or agent token required"
+	}
+	return bootstrap, http.StatusOK, ""
+}
+
+// reenrollForbidden 已注册的机器用未绑定的引导令牌重新注册
+func reenrollForbidden(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error": "Machine already enrolled; re-enrollment requires operator approval",
+	})
+}
+
+// agentOwns 检查当前Agent令牌是否属于指定机器
+func agentOwns(c echo.Context, machineID string) bool {
+	agent := CurrentAgent(c)
+	return agent != nil && agent.MachineID == machineID
+}
+
 // detectHardwareChange 检测硬件变更
 //
 // 通过计算硬件指纹SHA256哈希值判断硬件是否变更
@@ -272,6 +398,19 @@ func calculateHardwareHash(spec *models.HardwareInfo) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// promoteIfInventoried 已发现的机器上报硬件清单后自动进入 ready
+func promoteIfInventoried(machine *models.Machine) {
+	if machine.Status != models.MachineStatusDiscovered || machine.HardwareSpec.CPU.Cores == 0 {
+		return
+	}
+	if err := lifecycle.Transition(database.DB, machine, models.MachineStatusReady, lifecycle.Cause{
+		Actor:  lifecycle.ActorAgent,
+		Reason: "hardware inventory reported",
+	}); err != nil {
+		log.Printf("⚠️  机器 %s 状态迁移失败: %v", machine.ID, err)
+	}
+}
+
 // generateHostname 根据MAC地址生成主机名
 func generateHostname(macAddress string) string {
 	// 提取MAC地址最后6位作为主机名后缀
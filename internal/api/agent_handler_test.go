@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/labstack/echo/v4"
@@ -25,26 +27,68 @@ func setupTestAgentDB(t *testing.T) {
 	}
 }
 
+// testRemoteIP httptest 请求的默认来源地址
+const testRemoteIP = "192.0.2.1"
+
+// newTestAuthority 创建测试用Agent凭据签发（需在数据库初始化之后调用）
+func newTestAuthority() *agentauth.Authority {
+	return agentauth.New(database.GetDB(), []byte("cloudboot-test-agent-signing-key"), time.Hour)
+}
+
+// asAgent 模拟 RequireAgent 中间件，以指定机器的Agent身份发起请求
+func asAgent(c echo.Context, machineID, mac string) echo.Context {
+	c.Set(agentKey, &agentauth.Claims{MachineID: machineID, MacAddress: mac})
+	return c
+}
+
 // TestRegister 测试Agent注册
 func TestRegister(t *testing.T) {
 	setupTestAgentDB(t)
 	defer database.Close()
 
 	e := echo.New()
-	handler := NewAgentHandler()
+	authority := newTestAuthority()
+	handler := NewAgentHandler(authority)
+
+	bootstrap, err := authority.IssueBootstrap("00:11:22:33:44:55", testRemoteIP, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBootstrap, err := authority.IssueBootstrap("00:11:22:33:44:66", testRemoteIP, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var agentToken string // 首次注册签发的Agent令牌
 
 	tests := []struct {
 		name       string
 		payload    map[string]interface{}
+		useToken   bool // 携带此前签发的Agent令牌
 		wantStatus int
 		wantFields []string
 	}{
 		{
-			name: "首次注册成功",
+			name: "无凭据注册被拒绝",
 			payload: map[string]interface{}{
 				"mac_address": "00:11:22:33:44:55",
-				"ip_address":  "10.0.0.100",
-				"hostname":    "test-server-01",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "引导令牌与MAC不符",
+			payload: map[string]interface{}{
+				"mac_address":     "00:11:22:33:44:55",
+				"bootstrap_token": otherBootstrap,
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "首次注册成功",
+			payload: map[string]interface{}{
+				"bootstrap_token": bootstrap,
+				"mac_address":     "00:11:22:33:44:55",
+				"ip_address":      "10.0.0.100",
+				"hostname":        "test-server-01",
 				"hardware_spec": models.HardwareInfo{
 					SchemaVersion: "1.0",
 					System: models.SystemInfo{
@@ -58,7 +102,15 @@ func TestRegister(t *testing.T) {
 				},
 			},
 			wantStatus: http.StatusCreated,
-			wantFields: []string{"machine_id", "status", "heartbeat_url", "poll_interval_seconds"},
+			wantFields: []string{"machine_id", "status", "heartbeat_url", "poll_interval_seconds", "agent_token", "token_expires_at"},
+		},
+		{
+			name: "引导令牌只能使用一次",
+			payload: map[string]interface{}{
+				"mac_address":     "00:11:22:33:44:55",
+				"bootstrap_token": bootstrap,
+			},
+			wantStatus: http.StatusUnauthorized,
 		},
 		{
 			name: "重复注册返回updated",
@@ -66,8 +118,17 @@ func TestRegister(t *testing.T) {
 				"mac_address": "00:11:22:33:44:55",
 				"ip_address":  "10.0.0.101",
 			},
+			useToken:   true,
 			wantStatus: http.StatusOK,
-			wantFields: []string{"machine_id", "status"},
+			wantFields: []string{"machine_id", "status", "agent_token"},
+		},
+		{
+			name: "Agent令牌不能注册其他MAC",
+			payload: map[string]interface{}{
+				"mac_address": "00:11:22:33:44:66",
+			},
+			useToken:   true,
+			wantStatus: http.StatusForbidden,
 		},
 		{
 			name: "缺少MAC地址",
@@ -85,6 +146,13 @@ func TestRegister(t *testing.T) {
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
+			if tt.useToken {
+				claims, err := authority.Verify(agentToken)
+				if err != nil {
+					t.Fatalf("agent token from first registration is invalid: %v", err)
+				}
+				c.Set(agentKey, claims)
+			}
 
 			err := handler.Register(c)
 			if err != nil {
@@ -106,6 +174,9 @@ func TestRegister(t *testing.T) {
 						t.Errorf("missing field: %s", field)
 					}
 				}
+				if agentToken == "" {
+					agentToken, _ = resp["agent_token"].(string)
+				}
 
 				t.Logf("Response: %+v", resp)
 			}
@@ -119,7 +190,7 @@ func TestHeartbeat(t *testing.T) {
 	defer database.Close()
 
 	e := echo.New()
-	handler := NewAgentHandler()
+	handler := NewAgentHandler(newTestAuthority())
 
 	// 先注册一个机器
 	machine := &models.Machine{
@@ -142,6 +213,7 @@ func TestHeartbeat(t *testing.T) {
 
 	tests := []struct {
 		name           string
+		agent          string // 调用者Agent令牌所属机器，为空时使用 test-machine-001
 		payload        map[string]interface{}
 		wantStatus     int
 		wantHWChange   bool
@@ -194,7 +266,17 @@ func TestHeartbeat(t *testing.T) {
 			wantStatusCode: http.StatusForbidden,
 		},
 		{
-			name: "机器不存在",
+			name:  "冒充其他机器",
+			agent: "other-machine",
+			payload: map[string]interface{}{
+				"machine_id":  "test-machine-001",
+				"mac_address": "aa:bb:cc:dd:ee:ff",
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:  "机器不存在",
+			agent: "not-exist",
 			payload: map[string]interface{}{
 				"machine_id":  "not-exist",
 				"mac_address": "aa:bb:cc:dd:ee:ff",
@@ -217,7 +299,11 @@ func TestHeartbeat(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/heartbeat", bytes.NewBuffer(body))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
-			c := e.NewContext(req, rec)
+			agent := tt.agent
+			if agent == "" {
+				agent = "test-machine-001"
+			}
+			c := asAgent(e.NewContext(req, rec), agent, "aa:bb:cc:dd:ee:ff")
 
 			err := handler.Heartbeat(c)
 			if err != nil {
@@ -342,3 +428,94 @@ func TestGenerateHostname(t *testing.T) {
 		})
 	}
 }
+
+// TestRequireAgent 测试Agent令牌认证中间件
+func TestRequireAgent(t *testing.T) {
+	db := setupTestDB(t)
+	authority := newTestAuthority()
+
+	machine := &models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady}
+	db.Create(machine)
+	stale, _, _ := authority.Issue(machine, nil)
+	authority.ApproveReenroll(machine.ID, time.Hour)
+	valid, _, _ := authority.Issue(machine, &agentauth.Bootstrap{Bound: true}) // 轮换后 stale 失效
+
+	tests := []struct {
+		name       string
+		optional   bool
+		header     string
+		wantStatus int
+		wantAgent  string
+	}{
+		{"Valid token", false, "Bearer " + valid, http.StatusOK, "machine-1"},
+		{"Missing token", false, "", http.StatusUnauthorized, ""},
+		{"Rotated token", false, "Bearer " + stale, http.StatusUnauthorized, ""},
+		{"Garbage token", false, "Bearer not-a-token", http.StatusUnauthorized, ""},
+		{"Optional without token", true, "", http.StatusOK, ""},
+		{"Optional with invalid token", true, "Bearer " + stale, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/heartbeat", nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			middleware := RequireAgent(authority)
+			if tt.optional {
+				middleware = IdentifyAgent(authority)
+			}
+			var gotAgent string
+			handler := middleware(func(c echo.Context) error {
+				if agent := CurrentAgent(c); agent != nil {
+					gotAgent = agent.MachineID
+				}
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus || gotAgent != tt.wantAgent {
+				t.Errorf("Status = %v, agent = %q; want %v, %q", rec.Code, gotAgent, tt.wantStatus, tt.wantAgent)
+			}
+		})
+	}
+}
+
+// TestRefreshToken 测试Agent令牌刷新
+func TestRefreshToken(t *testing.T) {
+	db := setupTestDB(t)
+	authority := newTestAuthority()
+	handler := NewAgentHandler(authority)
+
+	machine := &models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady}
+	db.Create(machine)
+	old, _, _ := authority.Issue(machine, nil)
+	claims, _ := authority.Verify(old)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/boot/v1/token", nil), rec)
+	c.Set(agentKey, claims)
+	if err := handler.RefreshToken(c); err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	refreshed, _ := resp["agent_token"].(string)
+	if got, err := authority.Verify(refreshed); err != nil || got.MachineID != "machine-1" {
+		t.Errorf("refreshed token verifies to %+v, %v", got, err)
+	}
+	// 刷新不轮换凭据
+	if _, err := authority.Verify(old); err != nil {
+		t.Errorf("old token after refresh error = %v", err)
+	}
+}
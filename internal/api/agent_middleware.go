@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/labstack/echo/v4"
+)
+
+// agentKey echo.Context 中保存已认证Agent令牌声明的键
+const agentKey = "agent"
+
+// CurrentAgent 获取当前请求的已认证Agent（未携带Agent令牌时为nil）
+func CurrentAgent(c echo.Context) *agentauth.Claims {
+	claims, _ := c.Get(agentKey).(*agentauth.Claims)
+	return claims
+}
+
+// RequireAgent Agent认证中间件，要求 Authorization: Bearer <agent_token>
+func RequireAgent(authority *agentauth.Authority) echo.MiddlewareFunc {
+	return agentMiddleware(authority, true)
+}
+
+// IdentifyAgent 可选的Agent认证：携带令牌时必须有效，未携带时交由处理器按其他凭据校验
+// （注册接口接受引导令牌，状态上报接口接受安装器按 machine_id 上报）
+func IdentifyAgent(authority *agentauth.Authority) echo.MiddlewareFunc {
+	return agentMiddleware(authority, false)
+}
+
+func agentMiddleware(authority *agentauth.Authority, required bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := bearerToken(c.Request())
+			if !ok {
+				if required {
+					return agentUnauthenticated(c)
+				}
+				return next(c)
+			}
+
+			claims, err := authority.Verify(token)
+			if err != nil {
+				return agentUnauthenticated(c)
+			}
+			c.Set(agentKey, claims)
+			return next(c)
+		}
+	}
+}
+
+// agentUnauthenticated 返回401，Agent收到后应重新注册（需重新PXE引导获取引导令牌）
+func agentUnauthenticated(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="cloudboot-agent"`)
+	return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+		"error": "Agent authentication required",
+	})
+}
+
+// agentForbidden 已认证Agent访问不属于本机的资源
+func agentForbidden(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]interface{}{
+		"error": "Resource does not belong to this agent",
+	})
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// defaultAuditLimit 审计查询未指定 limit 时的默认条数
+const defaultAuditLimit = 100
+
+// AuditHandler 操作审计API处理器（需要 audit:read 权限）
+type AuditHandler struct{}
+
+// NewAuditHandler 创建AuditHandler
+func NewAuditHandler() *AuditHandler {
+	return &AuditHandler{}
+}
+
+// ListEvents 查询审计记录（按序号倒序）
+// GET /api/v1/audit?actor=admin&action=machine.delete&resource_type=machine&resource_id=…&request_id=…&since=24h&limit=100
+func (h *AuditHandler) ListEvents(c echo.Context) error {
+	filter, err := parseAuditFilter(c, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditLimit
+	}
+
+	events, total, err := audit.Query(database.GetDB(), filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to query audit events",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": events,
+		"total": total,
+	})
+}
+
+// ExportEvents 导出审计记录
+// GET /api/v1/audit/export?format=csv|json&since=…
+//
+// 过滤参数与 ListEvents 相同，未指定 limit 时导出全部匹配记录。
+func (h *AuditHandler) ExportEvents(c echo.Context) error {
+	filter, err := parseAuditFilter(c, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+	var contentType string
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	case "json":
+		contentType = echo.MIMEApplicationJSONCharsetUTF8
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "format must be csv or json",
+		})
+	}
+
+	events, _, err := audit.Query(database.GetDB(), filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to query audit events",
+		})
+	}
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	if format == "json" {
+		if events == nil {
+			events = []models.AuditEvent{}
+		}
+		return json.NewEncoder(c.Response()).Encode(events)
+	}
+
+	w := csv.NewWriter(c.Response())
+	w.Write([]string{"seq", "timestamp", "actor", "actor_token", "source_ip", "request_id",
+		"action", "resource_type", "resource_id", "changes", "prev_hash", "hash"})
+	for _, e := range events {
+		w.Write([]string{
+			strconv.FormatUint(e.Seq, 10),
+			e.Timestamp.UTC().Format(time.RFC3339Nano),
+			e.Actor,
+			e.ActorToken,
+			e.SourceIP,
+			e.RequestID,
+			e.Action,
+			e.ResourceType,
+			e.ResourceID,
+			string(e.Changes),
+			e.PrevHash,
+			e.Hash,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// VerifyChain 校验审计哈希链是否完整
+// GET /api/v1/audit/verify
+//
+// 返回的 head_hash 应定期记录到系统之外，用于发现末尾记录被截断。
+func (h *AuditHandler) VerifyChain(c echo.Context) error {
+	result, err := audit.Verify(database.GetDB())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to verify audit chain",
+		})
+	}
+	if !result.Valid {
+		log.Printf("🚨 审计链校验失败: seq=%d %s", result.BrokenAt, result.Reason)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// parseAuditFilter 解析审计过滤参数
+func parseAuditFilter(c echo.Context, now time.Time) (audit.Filter, error) {
+	f := audit.Filter{
+		Actor:        c.QueryParam("actor"),
+		Action:       c.QueryParam("action"),
+		ResourceType: c.QueryParam("resource_type"),
+		ResourceID:   c.QueryParam("resource_id"),
+		RequestID:    c.QueryParam("request_id"),
+	}
+
+	var err error
+	if f.Since, err = parseTimeParam(c.QueryParam("since"), now); err != nil {
+		return f, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseTimeParam(c.QueryParam("until"), now); err != nil {
+		return f, fmt.Errorf("invalid until: %w", err)
+	}
+
+	for name, dst := range map[string]*int{"limit": &f.Limit, "offset": &f.Offset} {
+		if raw := c.QueryParam(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid %s", name)
+			}
+			*dst = n
+		}
+	}
+	return f, nil
+}
+
+// recordAudit 记录一次变更操作（调用者、来源IP、请求ID和前后差异）
+//
+// before/after 为变更前后的资源，新建时 before 为nil，删除时 after 为nil。
+// before 若与 after 是同一对象，应先用 audit.Snapshot 保存副本。
+// 审计写入失败不影响已完成的操作，只记录日志。
+func recordAudit(c echo.Context, action, resourceID string, before, after interface{}) {
+	event := &models.AuditEvent{
+		Actor:      "anonymous",
+		SourceIP:   c.RealIP(),
+		RequestID:  requestID(c),
+		Action:     action,
+		ResourceID: resourceID,
+	}
+	if p := CurrentPrincipal(c); p != nil && p.User != nil {
+		event.Actor = p.User.Username
+		if p.Token != nil {
+			event.ActorToken = p.Token.Prefix
+		}
+	}
+
+	if err := audit.Record(database.GetDB(), event, before, after); err != nil {
+		log.Printf("⚠️  审计记录写入失败: %s %s: %v", action, resourceID, err)
+	}
+}
+
+// requestID 获取请求ID（RequestID 中间件写入响应头，否则取客户端传入的值）
+func requestID(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
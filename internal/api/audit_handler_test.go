@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestAuditTrail_RecordsMutations(t *testing.T) {
+	db := setupTestDB(t)
+	machines := NewMachineHandler()
+	operator := &auth.Principal{
+		User:  &models.User{Username: "operator"},
+		Token: &models.APIToken{Prefix: "cbt_abcd1234"},
+	}
+
+	newContext := func(method, target, body, id string) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderXRequestID, "req-"+method)
+		req.RemoteAddr = "192.0.2.10:40000"
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set(principalKey, operator)
+		return c, rec
+	}
+
+	c, rec := newContext(http.MethodPost, "/api/v1/machines", `{"mac":"aa:bb:cc:dd:ee:01","hostname":"node1"}`, "")
+	if err := machines.CreateMachine(c); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("CreateMachine() = %v, %v", rec.Code, err)
+	}
+	var machine models.Machine
+	json.Unmarshal(rec.Body.Bytes(), &machine)
+
+	c, rec = newContext(http.MethodPut, "/api/v1/machines/"+machine.ID, `{"hostname":"node2"}`, machine.ID)
+	if err := machines.UpdateMachine(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("UpdateMachine() = %v, %v", rec.Code, err)
+	}
+
+	c, rec = newContext(http.MethodDelete, "/api/v1/machines/"+machine.ID, "", machine.ID)
+	if err := machines.DeleteMachine(c); err != nil || rec.Code != http.StatusNoContent {
+		t.Fatalf("DeleteMachine() = %v, %v", rec.Code, err)
+	}
+
+	events, total, err := audit.Query(db, audit.Filter{ResourceID: machine.ID})
+	if err != nil || total != 3 {
+		t.Fatalf("Query() = %d events, %v", total, err)
+	}
+
+	tests := []struct {
+		action      string
+		requestID   string
+		wantChanged string
+		wantBefore  string
+		wantAfter   string
+	}{
+		{"machine.delete", "req-DELETE", "hostname", `"node2"`, ""},
+		{"machine.update", "req-PUT", "hostname", `"node1"`, `"node2"`},
+		{"machine.create", "req-POST", "mac_address", "", `"aa:bb:cc:dd:ee:01"`},
+	}
+	for i, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			e := events[i]
+			if e.Action != tt.action || e.ResourceType != "machine" {
+				t.Fatalf("event = %s/%s, want %s", e.ResourceType, e.Action, tt.action)
+			}
+			if e.Actor != "operator" || e.ActorToken != "cbt_abcd1234" || e.SourceIP != "192.0.2.10" || e.RequestID != tt.requestID {
+				t.Errorf("event context = %s %s %s %s", e.Actor, e.ActorToken, e.SourceIP, e.RequestID)
+			}
+
+			var changes map[string]audit.FieldChange
+			if err := json.Unmarshal(e.Changes, &changes); err != nil {
+				t.Fatalf("Changes = %s: %v", e.Changes, err)
+			}
+			change, ok := changes[tt.wantChanged]
+			if !ok || string(change.Before) != tt.wantBefore || string(change.After) != tt.wantAfter {
+				t.Errorf("changes[%s] = %+v, want %s -> %s", tt.wantChanged, change, tt.wantBefore, tt.wantAfter)
+			}
+			if _, ok := changes["updated_at"]; ok {
+				t.Error("updated_at should not be part of the diff")
+			}
+		})
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewAuditHandler()
+
+	for _, action := range []string{"machine.create", "profile.update", "machine.delete"} {
+		event := &models.AuditEvent{Actor: "admin", Action: action, ResourceID: "r1", RequestID: "req-1"}
+		if err := audit.Record(db, event, nil, map[string]string{"name": "a,b"}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	newContext := func(target string) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		return e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec), rec
+	}
+
+	t.Run("list", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			query      string
+			wantStatus int
+			wantTotal  int
+		}{
+			{"all", "", http.StatusOK, 3},
+			{"by resource type", "?resource_type=machine", http.StatusOK, 2},
+			{"by action", "?action=profile.update", http.StatusOK, 1},
+			{"relative since", "?since=1h", http.StatusOK, 3},
+			{"invalid since", "?since=yesterday", http.StatusBadRequest, 0},
+			{"invalid limit", "?limit=-1", http.StatusBadRequest, 0},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c, rec := newContext("/api/v1/audit" + tt.query)
+				if err := handler.ListEvents(c); err != nil {
+					t.Fatalf("ListEvents() error = %v", err)
+				}
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				if tt.wantStatus != http.StatusOK {
+					return
+				}
+				var resp struct {
+					Items []models.AuditEvent `json:"items"`
+					Total int                 `json:"total"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Total != tt.wantTotal || len(resp.Items) != tt.wantTotal {
+					t.Errorf("total = %d (%d items), want %d", resp.Total, len(resp.Items), tt.wantTotal)
+				}
+			})
+		}
+	})
+
+	t.Run("export csv", func(t *testing.T) {
+		c, rec := newContext("/api/v1/audit/export?format=csv")
+		if err := handler.ExportEvents(c); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("ExportEvents() = %d, %v", rec.Code, err)
+		}
+		if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(cd, "attachment") || !strings.Contains(cd, ".csv") {
+			t.Errorf("Content-Disposition = %q", cd)
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("invalid CSV: %v", err)
+		}
+		if len(rows) != 4 || rows[0][0] != "seq" || rows[1][6] != "machine.delete" {
+			t.Errorf("rows = %v", rows)
+		}
+		if !strings.Contains(rows[1][9], `"a,b"`) {
+			t.Errorf("changes column = %q", rows[1][9])
+		}
+	})
+
+	t.Run("export json", func(t *testing.T) {
+		c, rec := newContext("/api/v1/audit/export?format=json&actor=nobody")
+		if err := handler.ExportEvents(c); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("ExportEvents() = %d, %v", rec.Code, err)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+			t.Errorf("body = %s, want []", body)
+		}
+	})
+
+	t.Run("export invalid format", func(t *testing.T) {
+		c, rec := newContext("/api/v1/audit/export?format=xml")
+		if err := handler.ExportEvents(c); err != nil || rec.Code != http.StatusBadRequest {
+			t.Errorf("ExportEvents() = %d, %v", rec.Code, err)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		c, rec := newContext("/api/v1/audit/verify")
+		if err := handler.VerifyChain(c); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("VerifyChain() = %d, %v", rec.Code, err)
+		}
+		var result audit.VerifyResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if !result.Valid || result.Events != 3 || result.HeadSeq != 3 {
+			t.Errorf("VerifyChain() = %+v", result)
+		}
+
+		db.Model(&models.AuditEvent{}).Where("seq = ?", 1).Update("actor", "intruder")
+		c, rec = newContext("/api/v1/audit/verify")
+		handler.VerifyChain(c)
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if result.Valid || result.BrokenAt != 1 {
+			t.Errorf("VerifyChain() after tampering = %+v", result)
+		}
+	})
+}
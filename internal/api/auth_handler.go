@@ -0,0 +1,251 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// AuthHandler 登录会话与API令牌处理器
+type AuthHandler struct {
+	svc *auth.Service
+}
+
+// NewAuthHandler 创建AuthHandler
+func NewAuthHandler(svc *auth.Service) *AuthHandler {
+	return &AuthHandler{
+		svc: svc,
+	}
+}
+
+// LoginPage 登录页面
+// GET /login
+func (h *AuthHandler) LoginPage(c echo.Context) error {
+	next := safeRedirect(c.QueryParam("next"))
+
+	// 已登录则直接跳转
+	if cookie, err := c.Cookie(SessionCookieName); err == nil {
+		if _, err := h.svc.ResolveSession(cookie.Value); err == nil {
+			return c.Redirect(http.StatusFound, next)
+		}
+	}
+
+	return c.Render(http.StatusOK, "login.html", map[string]interface{}{
+		"title": "登录",
+		"next":  next,
+	})
+}
+
+// Login 表单登录，成功后写入会话Cookie
+// POST /login
+func (h *AuthHandler) Login(c echo.Context) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	next := safeRedirect(c.FormValue("next"))
+
+	user, err := h.svc.Authenticate(username, c.FormValue("password"))
+	if err != nil {
+		log.Printf("⚠️  登录失败: user=%s ip=%s", username, c.RealIP())
+		return c.Render(http.StatusUnauthorized, "login.html", map[string]interface{}{
+			"title":        "登录",
+			"next":         next,
+			"username":     username,
+			"errorMessage": "用户名或密码错误",
+		})
+	}
+
+	token, session, err := h.svc.CreateSession(user, c.RealIP())
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Failed to create session")
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("🔑 用户登录: %s (%s)", user.Username, c.RealIP())
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+// Logout 注销会话
+// POST /logout
+func (h *AuthHandler) Logout(c echo.Context) error {
+	if cookie, err := c.Cookie(SessionCookieName); err == nil {
+		h.svc.DeleteSession(cookie.Value)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// Me 当前用户信息
+// GET /api/v1/auth/me
+func (h *AuthHandler) Me(c echo.Context) error {
+	p := CurrentPrincipal(c)
+	resp := map[string]interface{}{
+		"user":        p.User,
+		"permissions": p.Permissions,
+	}
+	if p.Token != nil {
+		resp["token"] = p.Token
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// ChangePassword 修改当前用户密码（会注销该用户的全部会话）
+// POST /api/v1/auth/password
+func (h *AuthHandler) ChangePassword(c echo.Context) error {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	user := CurrentPrincipal(c).User
+	if _, err := h.svc.Authenticate(user.Username, req.CurrentPassword); err != nil {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": "Current password is incorrect",
+		})
+	}
+	if err := h.svc.SetPassword(user.ID, req.NewPassword); err != nil {
+		if errors.Is(err, auth.ErrWeakPassword) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to change password",
+		})
+	}
+
+	recordAudit(c, "user.password", user.ID, nil, map[string]interface{}{"password": "changed"})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Password changed, please log in again",
+	})
+}
+
+// ListTokens 列出当前用户的API令牌
+// GET /api/v1/auth/tokens
+func (h *AuthHandler) ListTokens(c echo.Context) error {
+	var tokens []models.APIToken
+	if err := database.GetDB().Where("user_id = ?", CurrentPrincipal(c).User.ID).
+		Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to fetch tokens",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": tokens,
+		"total": len(tokens),
+	})
+}
+
+// CreateToken 为当前用户签发API令牌，令牌明文只在响应中出现一次
+// POST /api/v1/auth/tokens
+func (h *AuthHandler) CreateToken(c echo.Context) error {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expires_in"` // 如 "720h"，留空表示不过期
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "name is required",
+		})
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "Invalid expires_in",
+			})
+		}
+		ttl = d
+	}
+
+	plaintext, token, err := h.svc.CreateToken(CurrentPrincipal(c), strings.TrimSpace(req.Name), req.Scopes, ttl)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		// 令牌不能获得超出调用者自身的权限
+		if errors.Is(err, auth.ErrScopeDenied) {
+			return c.JSON(http.StatusForbidden, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to create token",
+		})
+	}
+
+	recordAudit(c, "token.create", token.ID, nil, token)
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"token":     plaintext,
+		"api_token": token,
+	})
+}
+
+// RevokeToken 吊销API令牌（本人令牌，user:manage 可吊销任意令牌）
+// DELETE /api/v1/auth/tokens/:id
+func (h *AuthHandler) RevokeToken(c echo.Context) error {
+	p := CurrentPrincipal(c)
+
+	query := database.GetDB().Where("id = ?", c.Param("id"))
+	if !p.Has(auth.PermUserManage) {
+		query = query.Where("user_id = ?", p.User.ID)
+	}
+	var token models.APIToken
+	if err := query.First(&token).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Token not found",
+		})
+	}
+	if err := database.GetDB().Delete(&token).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to revoke token",
+		})
+	}
+
+	recordAudit(c, "token.revoke", token.ID, token, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// safeRedirect 只允许站内相对路径，防止开放重定向
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/renderer"
+	"github.com/labstack/echo/v4"
+)
+
+// setupAuth 创建认证服务和一个管理员、一个操作员
+func setupAuth(t *testing.T) (*auth.Service, *models.User, *models.User) {
+	t.Helper()
+	db := setupTestDB(t)
+	svc := auth.NewService(db, time.Hour)
+	if err := svc.SyncBuiltinRoles(); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &models.User{Username: "admin", Roles: []string{auth.RoleAdmin}}
+	user := &models.User{Username: "alice", Roles: []string{auth.RoleOperator}}
+	for _, u := range []*models.User{admin, user} {
+		if err := svc.CreateUser(u, "password-123"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return svc, admin, user
+}
+
+// sessionPrincipal 以会话方式登录并返回带权限的调用者
+func sessionPrincipal(t *testing.T, svc *auth.Service, user *models.User) *auth.Principal {
+	t.Helper()
+	token, _, err := svc.CreateSession(user, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := svc.ResolveSession(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRequireAuth(t *testing.T) {
+	svc, _, user := setupAuth(t)
+
+	session, _, _ := svc.CreateSession(user, "127.0.0.1")
+	readToken, _, _ := svc.CreateToken(sessionPrincipal(t, svc, user), "ro", []string{auth.ScopeRead}, 0)
+	writeToken, _, _ := svc.CreateToken(sessionPrincipal(t, svc, user), "rw", []string{auth.ScopeRead, auth.ScopeWrite}, 0)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		headers      map[string]string
+		cookie       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "API without credentials", method: http.MethodGet, path: "/api/v1/machines", wantStatus: http.StatusUnauthorized},
+		{name: "Page without credentials", method: http.MethodGet, path: "/machines?status=ready", wantStatus: http.StatusFound, wantLocation: "/login?next=" + url.QueryEscape("/machines?status=ready")},
+		{name: "HTMX request without credentials", method: http.MethodGet, path: "/jobs", headers: map[string]string{"HX-Request": "true"}, wantStatus: http.StatusUnauthorized},
+		{name: "Invalid bearer token", method: http.MethodGet, path: "/api/v1/machines", headers: map[string]string{"Authorization": "Bearer cbt_invalid"}, cookie: session, wantStatus: http.StatusUnauthorized},
+		{name: "Session GET", method: http.MethodGet, path: "/api/v1/machines", cookie: session, wantStatus: http.StatusOK},
+		{name: "Session POST same origin", method: http.MethodPost, path: "/api/v1/machines", cookie: session, headers: map[string]string{"Origin": "http://example.com"}, wantStatus: http.StatusOK},
+		{name: "Session POST cross origin", method: http.MethodPost, path: "/api/v1/machines", cookie: session, headers: map[string]string{"Origin": "http://evil.example"}, wantStatus: http.StatusForbidden},
+		{name: "Read token GET", method: http.MethodGet, path: "/api/v1/machines", headers: map[string]string{"Authorization": "Bearer " + readToken}, wantStatus: http.StatusOK},
+		{name: "Read token DELETE", method: http.MethodDelete, path: "/api/v1/machines/1", headers: map[string]string{"Authorization": "Bearer " + readToken}, wantStatus: http.StatusForbidden},
+		{name: "Write token DELETE", method: http.MethodDelete, path: "/api/v1/machines/1", headers: map[string]string{"Authorization": "bearer " + writeToken}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := RequireAuth(svc)(func(c echo.Context) error {
+				if CurrentPrincipal(c) == nil || c.Get(renderer.CurrentUserKey) == nil || c.Get(renderer.PermissionsKey) == nil {
+					t.Error("principal not set in context")
+				}
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantLocation != "" && rec.Header().Get(echo.HeaderLocation) != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", rec.Header().Get(echo.HeaderLocation), tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_Login(t *testing.T) {
+	svc, _, _ := setupAuth(t)
+	handler := NewAuthHandler(svc)
+
+	templates, err := renderer.NewTemplateRenderer("../../web/templates")
+	if err != nil {
+		t.Fatalf("NewTemplateRenderer() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		form         url.Values
+		wantStatus   int
+		wantLocation string
+		wantCookie   bool
+	}{
+		{
+			name:         "Valid credentials",
+			form:         url.Values{"username": {"alice"}, "password": {"password-123"}, "next": {"/jobs"}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/jobs",
+			wantCookie:   true,
+		},
+		{
+			name:         "Open redirect rejected",
+			form:         url.Values{"username": {"alice"}, "password": {"password-123"}, "next": {"//evil.example/"}},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/",
+			wantCookie:   true,
+		},
+		{
+			name:       "Wrong password",
+			form:       url.Values{"username": {"alice"}, "password": {"nope"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Renderer = templates
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+
+			if err := handler.Login(e.NewContext(req, rec)); err != nil {
+				t.Fatalf("Login() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if tt.wantLocation != "" && rec.Header().Get(echo.HeaderLocation) != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", rec.Header().Get(echo.HeaderLocation), tt.wantLocation)
+			}
+
+			cookies := rec.Result().Cookies()
+			if gotCookie := len(cookies) == 1 && cookies[0].Name == SessionCookieName; gotCookie != tt.wantCookie {
+				t.Fatalf("session cookie set = %v, want %v", gotCookie, tt.wantCookie)
+			}
+			if tt.wantCookie {
+				if !cookies[0].HttpOnly {
+					t.Error("session cookie must be HttpOnly")
+				}
+				if _, err := svc.ResolveSession(cookies[0].Value); err != nil {
+					t.Errorf("ResolveSession() error = %v", err)
+				}
+			} else if !strings.Contains(rec.Body.String(), "用户名或密码错误") {
+				t.Errorf("login page missing error message:\n%s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthHandler_CreateToken(t *testing.T) {
+	svc, admin, user := setupAuth(t)
+	handler := NewAuthHandler(svc)
+
+	userSession := sessionPrincipal(t, svc, user)
+	adminSession := sessionPrincipal(t, svc, admin)
+	_, readToken, _ := svc.CreateToken(userSession, "ro", []string{auth.ScopeRead, auth.ScopeWrite}, 0)
+
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "Session creates token",
+			principal:  userSession,
+			body:       `{"name":"ci","scopes":["read","write"],"expires_in":"720h"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "Admin scope requires admin",
+			principal:  userSession,
+			body:       `{"name":"ci","scopes":["admin"]}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Admin creates admin token",
+			principal:  adminSession,
+			body:       `{"name":"ops","scopes":["admin"]}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "Token cannot escalate",
+			principal:  &auth.Principal{User: user, Token: readToken, Permissions: userSession.Permissions},
+			body:       `{"name":"ci","scopes":["admin"]}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Missing name",
+			principal:  userSession,
+			body:       `{"scopes":["read"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid expiry",
+			principal:  userSession,
+			body:       `{"name":"ci","scopes":["read"],"expires_in":"forever"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/tokens", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(principalKey, tt.principal)
+
+			if err := handler.CreateToken(c); err != nil {
+				t.Fatalf("CreateToken() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			var resp struct {
+				Token string `json:"token"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			p, err := svc.ResolveToken(resp.Token)
+			if err != nil || p.User.ID != tt.principal.User.ID {
+				t.Errorf("ResolveToken() = %+v, %v", p, err)
+			}
+		})
+	}
+}
+
+func TestUserHandler(t *testing.T) {
+	svc, admin, user := setupAuth(t)
+	handler := NewUserHandler(svc)
+	adminSession := sessionPrincipal(t, svc, admin)
+
+	tests := []struct {
+		name       string
+		method     string
+		id         string
+		body       string
+		wantStatus int
+	}{
+		{name: "Create user", method: http.MethodPost, body: `{"username":"bob","password":"bob-password","email":"bob@example.com","roles":["viewer"]}`, wantStatus: http.StatusCreated},
+		{name: "Unknown role", method: http.MethodPost, body: `{"username":"dave","password":"dave-password","roles":["root"]}`, wantStatus: http.StatusBadRequest},
+		{name: "Duplicate username", method: http.MethodPost, body: `{"username":"alice","password":"password-123"}`, wantStatus: http.StatusConflict},
+		{name: "Weak password", method: http.MethodPost, body: `{"username":"carol","password":"short"}`, wantStatus: http.StatusBadRequest},
+		{name: "Promote user", method: http.MethodPut, id: user.ID, body: `{"roles":["operator","store-manager"]}`, wantStatus: http.StatusOK},
+		{name: "Demote yourself", method: http.MethodPut, id: admin.ID, body: `{"roles":["viewer"]}`, wantStatus: http.StatusBadRequest},
+		{name: "Delete yourself", method: http.MethodDelete, id: admin.ID, wantStatus: http.StatusBadRequest},
+		{name: "Delete user", method: http.MethodDelete, id: user.ID, wantStatus: http.StatusNoContent},
+		{name: "Delete missing user", method: http.MethodDelete, id: "missing", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tt.method, "/api/v1/users", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+			c.Set(principalKey, adminSession)
+
+			var err error
+			switch tt.method {
+			case http.MethodPost:
+				err = handler.CreateUser(c)
+			case http.MethodPut:
+				err = handler.UpdateUser(c)
+			case http.MethodDelete:
+				err = handler.DeleteUser(c)
+			}
+			if err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUserHandler_UpdateRoles(t *testing.T) {
+	svc, admin, user := setupAuth(t)
+	handler := NewUserHandler(svc)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/users/"+user.ID, strings.NewReader(`{"roles":["viewer"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(user.ID)
+	c.Set(principalKey, sessionPrincipal(t, svc, admin))
+
+	if err := handler.UpdateUser(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("UpdateUser() = %v, %v: %s", rec.Code, err, rec.Body.String())
+	}
+
+	// 新角色在下一次请求时生效
+	p := sessionPrincipal(t, svc, user)
+	if p.Has(auth.PermMachineProvision) || !p.Has(auth.PermMachineRead) {
+		t.Errorf("Permissions after update = %v", p.Permissions)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	svc, admin, user := setupAuth(t)
+
+	viewer := &models.User{Username: "victor", Roles: []string{auth.RoleViewer}}
+	if err := svc.CreateUser(viewer, "password-123"); err != nil {
+		t.Fatal(err)
+	}
+	userSession := sessionPrincipal(t, svc, user)
+	_, readToken, _ := svc.CreateToken(userSession, "ro", []string{auth.ScopeRead}, 0)
+
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		perm       string
+		wantStatus int
+	}{
+		{"Viewer reads machines", sessionPrincipal(t, svc, viewer), auth.PermMachineRead, http.StatusOK},
+		{"Viewer cannot provision", sessionPrincipal(t, svc, viewer), auth.PermMachineProvision, http.StatusForbidden},
+		{"Operator provisions", userSession, auth.PermMachineProvision, http.StatusOK},
+		{"Operator cannot import providers", userSession, auth.PermStoreImport, http.StatusForbidden},
+		{"Operator cannot restore backups", userSession, auth.PermBackupRestore, http.StatusForbidden},
+		{"Read token cannot provision", &auth.Principal{User: user, Token: readToken, Permissions: userSession.Permissions}, auth.PermMachineProvision, http.StatusForbidden},
+		{"Admin restores backups", sessionPrincipal(t, svc, admin), auth.PermBackupRestore, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/machines/1/provision", nil), rec)
+			c.Set(principalKey, tt.principal)
+
+			handler := RequirePermission(tt.perm)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoleHandler(t *testing.T) {
+	svc, _, _ := setupAuth(t)
+	handler := NewRoleHandler(svc)
+
+	tests := []struct {
+		name       string
+		method     string
+		role       string
+		body       string
+		assignTo   string // 请求前将角色分配给该用户，请求后删除
+		wantStatus int
+	}{
+		{name: "Create role", method: http.MethodPost, body: `{"name":"auditor","permissions":["backup:read","job:read"]}`, wantStatus: http.StatusCreated},
+		{name: "Duplicate role", method: http.MethodPost, body: `{"name":"viewer","permissions":["job:read"]}`, wantStatus: http.StatusConflict},
+		{name: "Unknown permission", method: http.MethodPost, body: `{"name":"hacker","permissions":["machine:explode"]}`, wantStatus: http.StatusBadRequest},
+		{name: "Update role", method: http.MethodPut, role: "auditor", body: `{"permissions":["backup:read"]}`, wantStatus: http.StatusOK},
+		{name: "Update builtin role", method: http.MethodPut, role: auth.RoleViewer, body: `{"permissions":["*"]}`, wantStatus: http.StatusForbidden},
+		{name: "Delete builtin role", method: http.MethodDelete, role: auth.RoleAdmin, wantStatus: http.StatusForbidden},
+		{name: "Delete missing role", method: http.MethodDelete, role: "missing", wantStatus: http.StatusNotFound},
+		{name: "Delete assigned role", method: http.MethodDelete, role: "auditor", assignTo: "eve", wantStatus: http.StatusConflict},
+		{name: "Delete role", method: http.MethodDelete, role: "auditor", wantStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.assignTo != "" {
+				assignee := &models.User{Username: tt.assignTo, Roles: []string{tt.role}}
+				if err := svc.CreateUser(assignee, "password-123"); err != nil {
+					t.Fatal(err)
+				}
+				defer svc.DeleteUser(assignee.ID)
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(tt.method, "/api/v1/roles", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("name")
+			c.SetParamValues(tt.role)
+
+			var err error
+			switch tt.method {
+			case http.MethodPost:
+				err = handler.CreateRole(c)
+			case http.MethodPut:
+				err = handler.UpdateRole(c)
+			case http.MethodDelete:
+				err = handler.DeleteRole(c)
+			}
+			if err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/renderer"
+	"github.com/labstack/echo/v4"
+)
+
+// SessionCookieName Web控制台会话Cookie
+const SessionCookieName = "cloudboot_session"
+
+// principalKey echo.Context 中保存已认证调用者的键
+const principalKey = "principal"
+
+// CurrentPrincipal 获取当前请求的已认证调用者（未经过认证中间件时为nil）
+func CurrentPrincipal(c echo.Context) *auth.Principal {
+	p, _ := c.Get(principalKey).(*auth.Principal)
+	return p
+}
+
+// RequireAuth 认证中间件
+//
+// 支持两种凭据：
+//   - Authorization: Bearer cbt_xxx（API令牌，GET类请求需要read范围，变更请求需要write范围）
+//   - 会话Cookie（Web控制台登录，变更请求校验Origin防止跨站请求伪造）
+//
+// 未认证时 /api/ 下的请求返回401，页面请求跳转到登录页。
+func RequireAuth(svc *auth.Service) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			var (
+				p   *auth.Principal
+				err error
+			)
+			if token, ok := bearerToken(req); ok {
+				p, err = svc.ResolveToken(token)
+			} else if cookie, cerr := c.Cookie(SessionCookieName); cerr == nil {
+				p, err = svc.ResolveSession(cookie.Value)
+			} else {
+				err = auth.ErrUnauthenticated
+			}
+			if err != nil {
+				return unauthenticated(c)
+			}
+
+			if p.Token == nil && !isSafeMethod(req.Method) && !sameOrigin(req) {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error": "Cross-origin request rejected",
+				})
+			}
+
+			scope := auth.ScopeWrite
+			if isSafeMethod(req.Method) {
+				scope = auth.ScopeRead
+			}
+			if !p.Can(scope) {
+				return forbidden(c, scope)
+			}
+
+			c.Set(principalKey, p)
+			c.Set(renderer.CurrentUserKey, p.User)
+			c.Set(renderer.PermissionsKey, permissionSet(p))
+			return next(c)
+		}
+	}
+}
+
+// RequirePermission 要求调用者具备指定权限（需在 RequireAuth 之后使用）
+func RequirePermission(perm string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !CurrentPrincipal(c).Has(perm) {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":               "Insufficient permissions",
+					"required_permission": perm,
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// permissionSet 调用者具备的权限，供模板隐藏无权限的操作: {{if index .can "machine:delete"}}
+func permissionSet(p *auth.Principal) map[string]bool {
+	set := make(map[string]bool, len(auth.Permissions))
+	for _, perm := range auth.Permissions {
+		if p.Has(perm) {
+			set[perm] = true
+		}
+	}
+	return set
+}
+
+// unauthenticated 返回401或跳转登录页
+func unauthenticated(c echo.Context) error {
+	req := c.Request()
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="cloudboot"`)
+		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+			"error": "Authentication required",
+		})
+	}
+
+	loginURL := "/login?next=" + url.QueryEscape(req.URL.RequestURI())
+	// HTMX 局部请求需要通过响应头整页跳转
+	if req.Header.Get("HX-Request") != "" {
+		c.Response().Header().Set("HX-Redirect", loginURL)
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	return c.Redirect(http.StatusFound, loginURL)
+}
+
+func forbidden(c echo.Context, scope string) error {
+	return c.JSON(http.StatusForbidden, map[string]interface{}{
+		"error":          "Insufficient permissions",
+		"required_scope": scope,
+	})
+}
+
+// bearerToken 解析 Authorization: Bearer 头
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get(echo.HeaderAuthorization)
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sameOrigin 检查浏览器请求的来源与服务地址一致
+// 浏览器的跨站变更请求总会携带Origin（或Referer），两者都没有时视为非浏览器客户端
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get(echo.HeaderOrigin)
+	if origin == "" {
+		origin = req.Referer()
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// BackupHandler 数据库备份API处理器
+type BackupHandler struct {
+	manager *database.BackupManager
+}
+
+// NewBackupHandler 创建BackupHandler
+func NewBackupHandler(manager *database.BackupManager) *BackupHandler {
+	return &BackupHandler{
+		manager: manager,
+	}
+}
+
+// ListBackups 获取备份列表
+// GET /api/v1/backups
+func (h *BackupHandler) ListBackups(c echo.Context) error {
+	backups, err := h.manager.ListBackups()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list backups",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": backups,
+		"total": len(backups),
+	})
+}
+
+// CreateBackup 立即执行一次备份
+// POST /api/v1/backups
+func (h *BackupHandler) CreateBackup(c echo.Context) error {
+	if _, err := h.manager.Backup(); err != nil {
+		log.Printf("❌ 手动备份失败: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Backup failed",
+		})
+	}
+
+	backups, err := h.manager.ListBackups()
+	if err != nil || len(backups) == 0 {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list backups",
+		})
+	}
+	backup := backups[len(backups)-1]
+	recordAudit(c, "backup.create", backup.Name, nil, backup)
+	return c.JSON(http.StatusCreated, backup)
+}
+
+// RestoreBackup 从备份恢复，服务重启后生效
+// POST /api/v1/backups/:name/restore
+func (h *BackupHandler) RestoreBackup(c echo.Context) error {
+	name := c.Param("name")
+	if err := h.manager.StageRestore(name); err != nil {
+		if errors.Is(err, database.ErrBackupNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": "Backup not found",
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to stage restore",
+		})
+	}
+
+	recordAudit(c, "backup.restore", name, nil, map[string]interface{}{"status": "staged"})
+	log.Printf("⚠️  已准备从备份恢复: %s (by %s)，重启服务后生效", name, CurrentPrincipal(c).User.Username)
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"message":          "Restore staged, restart the server to apply",
+		"restart_required": true,
+	})
+}
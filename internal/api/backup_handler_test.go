@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+func TestBackupHandler(t *testing.T) {
+	setupTestDB(t)
+	dir := t.TempDir()
+	handler := NewBackupHandler(database.NewBackupManager(filepath.Join(dir, "cloudboot.db"), filepath.Join(dir, "backups")))
+
+	newContext := func(method, name string) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(method, "/api/v1/backups", nil), rec)
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		c.Set(principalKey, &auth.Principal{User: &models.User{Username: "admin"}})
+		return c, rec
+	}
+
+	// 创建备份
+	c, rec := newContext(http.MethodPost, "")
+	if err := handler.CreateBackup(c); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("CreateBackup() = %v, %v: %s", rec.Code, err, rec.Body.String())
+	}
+	var backup database.BackupInfo
+	json.Unmarshal(rec.Body.Bytes(), &backup)
+	if backup.Name == "" || backup.Size == 0 {
+		t.Fatalf("CreateBackup() = %+v", backup)
+	}
+
+	c, rec = newContext(http.MethodGet, "")
+	if err := handler.ListBackups(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("ListBackups() = %v, %v", rec.Code, err)
+	}
+
+	tests := []struct {
+		name       string
+		backup     string
+		wantStatus int
+	}{
+		{"Restore backup", backup.Name, http.StatusAccepted},
+		{"Path traversal", "../cloudboot.db", http.StatusNotFound},
+		{"Missing backup", "cloudboot-missing.db", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(http.MethodPost, tt.backup)
+			if err := handler.RestoreBackup(c); err != nil {
+				t.Fatalf("RestoreBackup() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
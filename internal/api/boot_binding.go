@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"net"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errBindingMismatch 请求地址与DHCP为该MAC分配的地址不符
+var errBindingMismatch = errors.New("request address does not match the dhcp lease")
+
+// LeaseSource DHCP地址查询（内置DHCP以 full 模式运行时由 dhcp.Server 提供）
+type LeaseSource interface {
+	LeaseIP(mac string) (net.IP, bool)
+}
+
+// bootBinding 校验公开启动接口（iPXE脚本、安装配置）的请求方是否就是目标机器
+//
+// 内置DHCP为该MAC分配过地址时以租约为准，否则与Agent上报的机器地址比对。
+// 这些接口没有其他凭据，请求地址是唯一能把请求和机器对应起来的依据。
+type bootBinding struct {
+	leases LeaseSource
+}
+
+// check 返回请求是否来自该机器
+// 有租约但地址不符时返回 errBindingMismatch；没有租约且机器地址未知时返回 false
+func (b bootBinding) check(c echo.Context, mac, knownIP string) (bool, error) {
+	source := net.ParseIP(remoteIP(c))
+	if source == nil {
+		return false, nil
+	}
+	if b.leases != nil {
+		if leased, ok := b.leases.LeaseIP(mac); ok {
+			if !leased.Equal(source) {
+				return false, errBindingMismatch
+			}
+			return true, nil
+		}
+	}
+	if known := net.ParseIP(knownIP); known != nil {
+		return known.Equal(source), nil
+	}
+	return false, nil
+}
+
+// remoteIP 请求的TCP来源地址
+// PXE客户端和安装器直连服务器，不信任可伪造的 X-Forwarded-For/X-Real-IP
+func remoteIP(c echo.Context) string {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return c.Request().RemoteAddr
+	}
+	return host
+}
@@ -4,149 +4,158 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/configgen"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/labstack/echo/v4"
 )
 
-// BootConfigHandler Boot配置处理器（Kickstart/AutoYaST）
+// BootConfigHandler Boot配置处理器（Kickstart/AutoYaST/Preseed/Autoinstall）
+// 所有格式均由 configgen.Generator 渲染，与Profile预览输出一致
+// 渲染出的配置带有安装任务的安装器凭据，安装器凭此上报进度
 type BootConfigHandler struct {
 	serverURL string
+	generator *configgen.Generator
+	authority *agentauth.Authority
+	binding   bootBinding
 }
 
 // NewBootConfigHandler 创建Boot配置处理器
-func NewBootConfigHandler(serverURL string) *BootConfigHandler {
+func NewBootConfigHandler(serverURL string, authority *agentauth.Authority) *BootConfigHandler {
 	return &BootConfigHandler{
 		serverURL: serverURL,
+		generator: configgen.NewGenerator(),
+		authority: authority,
 	}
 }
 
-// BootConfigData 启动配置模板数据
-type BootConfigData struct {
-	ServerURL string
-	Machine   *models.Machine
-	Profile   *models.OSProfile
+// SetLeases 设置DHCP地址查询，安装配置只下发给持有该机器租约的请求方
+func (h *BootConfigHandler) SetLeases(leases LeaseSource) {
+	h.binding.leases = leases
+}
+
+// bootConfigFormats 各安装格式的名称及支持的发行版（用于错误提示）
+var bootConfigFormats = map[string]struct {
+	name     string
+	supports string
+}{
+	configgen.FormatKickstart:   {"Kickstart", "RHEL-based"},
+	configgen.FormatAutoYaST:    {"AutoYaST", "SUSE-based"},
+	configgen.FormatPreseed:     {"Preseed", "Debian"},
+	configgen.FormatAutoinstall: {"Autoinstall", "Ubuntu"},
 }
 
 // ServeKickstart 提供Kickstart配置
 // GET /boot/kickstart/:machine_id
 func (h *BootConfigHandler) ServeKickstart(c echo.Context) error {
-	machineID := c.Param("machine_id")
-	if machineID == "" {
-		return c.String(http.StatusBadRequest, "# Error: machine_id required\n")
+	return h.serveConfig(c, configgen.FormatKickstart)
+}
+
+// ServeAutoYaST 提供AutoYaST配置
+// GET /boot/autoyast/:machine_id
+func (h *BootConfigHandler) ServeAutoYaST(c echo.Context) error {
+	return h.serveConfig(c, configgen.FormatAutoYaST)
+}
+
+// ServePreseed 提供Debian Preseed配置
+// GET /boot/preseed/:machine_id
+func (h *BootConfigHandler) ServePreseed(c echo.Context) error {
+	return h.serveConfig(c, configgen.FormatPreseed)
+}
+
+// ServeAutoinstall 提供Ubuntu Autoinstall (cloud-init NoCloud数据源)
+// GET /boot/autoinstall/:machine_id/:file (user-data, meta-data, vendor-data)
+func (h *BootConfigHandler) ServeAutoinstall(c echo.Context) error {
+	file := c.Param("file")
+	if file != "user-data" && file != "meta-data" && file != "vendor-data" {
+		return configError(c, configgen.FormatAutoinstall, http.StatusNotFound, fmt.Sprintf("Unknown NoCloud file: %s", file))
 	}
 
-	// 查找机器
-	var machine models.Machine
-	if err := database.DB.First(&machine, "id = ?", machineID).Error; err != nil {
-		return c.String(http.StatusNotFound, fmt.Sprintf("# Error: Machine not found: %s\n", machineID))
+	profile, machine, status, msg := h.loadInstallTarget(c, configgen.FormatAutoinstall)
+	if msg != "" {
+		return configError(c, configgen.FormatAutoinstall, status, msg)
 	}
 
-	// 查找待执行的安装任务
-	var job models.Job
-	if err := database.DB.Where("machine_id = ? AND type = ? AND status IN (?)",
-		machine.ID, "install_os", []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}).
-		First(&job).Error; err != nil {
-		return c.String(http.StatusNotFound, "# Error: No pending installation job\n")
+	seed, err := h.generator.GenerateNoCloud(profile, machine)
+	if err != nil {
+		return configError(c, configgen.FormatAutoinstall, http.StatusUnprocessableEntity, err.Error())
 	}
 
-	// 加载OS Profile
-	var profile models.OSProfile
-	if err := database.DB.First(&profile, "id = ?", job.ProfileID).Error; err != nil {
-		return c.String(http.StatusNotFound, "# Error: OS Profile not found\n")
+	content := seed.UserData
+	switch file {
+	case "meta-data":
+		content = seed.MetaData
+	case "vendor-data":
+		content = seed.VendorData
 	}
 
-	// 验证发行版类型
-	if !isRHELBased(profile.Distro) {
-		return c.String(http.StatusBadRequest, "# Error: Kickstart only supports RHEL-based distributions\n")
+	return c.Blob(http.StatusOK, "text/yaml; charset=utf-8", []byte(content))
+}
+
+// serveConfig 渲染单文件安装配置（Kickstart/AutoYaST/Preseed）
+func (h *BootConfigHandler) serveConfig(c echo.Context, format string) error {
+	profile, machine, status, msg := h.loadInstallTarget(c, format)
+	if msg != "" {
+		return configError(c, format, status, msg)
 	}
 
-	// 渲染Kickstart模板
-	data := BootConfigData{
-		ServerURL: h.serverURL,
-		Machine:   &machine,
-		Profile:   &profile,
+	rendered, err := h.generator.Render(profile, machine)
+	if err != nil {
+		return configError(c, format, http.StatusUnprocessableEntity, err.Error())
 	}
 
-	c.Response().Header().Set(echo.HeaderContentType, "text/plain; charset=utf-8")
-	return c.Render(http.StatusOK, "kickstart.tmpl", data)
+	return c.Blob(http.StatusOK, rendered.ContentType, []byte(rendered.Content))
 }
 
-// ServeAutoYaST 提供AutoYaST配置
-// GET /boot/autoyast/:machine_id
-func (h *BootConfigHandler) ServeAutoYaST(c echo.Context) error {
+// loadInstallTarget 加载机器及其待执行安装任务的Profile，并检查发行版与安装格式是否匹配
+// 配置中带有安装器凭据，只下发给目标机器本身（DHCP租约地址或机器登记的地址）
+// 失败时返回HTTP状态码和错误信息
+func (h *BootConfigHandler) loadInstallTarget(c echo.Context, format string) (*models.OSProfile, configgen.MachineContext, int, string) {
 	machineID := c.Param("machine_id")
 	if machineID == "" {
-		return c.String(http.StatusBadRequest, "<!-- Error: machine_id required -->\n")
+		return nil, configgen.MachineContext{}, http.StatusBadRequest, "machine_id required"
 	}
 
 	// 查找机器
 	var machine models.Machine
 	if err := database.DB.First(&machine, "id = ?", machineID).Error; err != nil {
-		return c.String(http.StatusNotFound, fmt.Sprintf("<!-- Error: Machine not found: %s -->\n", machineID))
+		return nil, configgen.MachineContext{}, http.StatusNotFound, fmt.Sprintf("Machine not found: %s", machineID)
+	}
+	if bound, err := h.binding.check(c, normalizeMACAddress(machine.MacAddress), machine.IPAddress); err != nil || !bound {
+		return nil, configgen.MachineContext{}, http.StatusForbidden, "Request does not come from the target machine"
 	}
 
 	// 查找待执行的安装任务
-	var job models.Job
-	if err := database.DB.Where("machine_id = ? AND type = ? AND status IN (?)",
-		machine.ID, "install_os", []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}).
-		First(&job).Error; err != nil {
-		return c.String(http.StatusNotFound, "<!-- Error: No pending installation job -->\n")
+	job, err := workflow.FindInstallJob(database.DB, machine.ID)
+	if err != nil {
+		return nil, configgen.MachineContext{}, http.StatusNotFound, "No pending installation job"
 	}
 
 	// 加载OS Profile
 	var profile models.OSProfile
 	if err := database.DB.First(&profile, "id = ?", job.ProfileID).Error; err != nil {
-		return c.String(http.StatusNotFound, "<!-- Error: OS Profile not found -->\n")
+		return nil, configgen.MachineContext{}, http.StatusNotFound, "OS Profile not found"
 	}
 
 	// 验证发行版类型
-	if !isSUSEBased(profile.Distro) {
-		return c.String(http.StatusBadRequest, "<!-- Error: AutoYaST only supports SUSE-based distributions -->\n")
-	}
-
-	// 渲染AutoYaST模板
-	data := BootConfigData{
-		ServerURL: h.serverURL,
-		Machine:   &machine,
-		Profile:   &profile,
+	distro, ok := configgen.LookupDistro(profile.Distro)
+	if !ok || distro.Spec().Format != format {
+		f := bootConfigFormats[format]
+		return nil, configgen.MachineContext{}, http.StatusBadRequest, fmt.Sprintf("%s only supports %s distributions", f.name, f.supports)
 	}
 
-	c.Response().Header().Set(echo.HeaderContentType, "application/xml; charset=utf-8")
-	return c.Render(http.StatusOK, "autoyast.tmpl", data)
-}
-
-// isRHELBased 检查是否为RHEL系发行版
-func isRHELBased(distro string) bool {
-	rhelBased := map[string]bool{
-		"centos":   true,
-		"centos7":  true,
-		"centos8":  true,
-		"rhel":     true,
-		"rhel7":    true,
-		"rhel8":    true,
-		"rhel9":    true,
-		"rocky":    true,
-		"rocky8":   true,
-		"rocky9":   true,
-		"alma":     true,
-		"alma8":    true,
-		"alma9":    true,
-		"almalinux": true,
-	}
-	return rhelBased[distro]
+	ctx := configgen.NewMachineContext(&machine, h.serverURL)
+	ctx.JobID = job.ID
+	ctx.InstallToken = h.authority.InstallerToken(job.ID, machine.ID)
+	return &profile, ctx, 0, ""
 }
 
-// isSUSEBased 检查是否为SUSE系发行版
-func isSUSEBased(distro string) bool {
-	suseBased := map[string]bool{
-		"suse":     true,
-		"suse15":   true,
-		"sles":     true,
-		"sles15":   true,
-		"opensuse": true,
-		"leap":     true,
-		"leap15":   true,
+// configError 以对应格式的注释返回错误，安装器日志中可直接看到原因
+func configError(c echo.Context, format string, status int, msg string) error {
+	if format == configgen.FormatAutoYaST {
+		return c.String(status, fmt.Sprintf("<!-- Error: %s -->\n", msg))
 	}
-	return suseBased[distro]
+	return c.String(status, fmt.Sprintf("# Error: %s\n", msg))
 }
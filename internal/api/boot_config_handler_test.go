@@ -0,0 +1,199 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestBootConfigHandler_ServeAutoinstall(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootConfigHandler("http://10.0.0.10:8080", newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-2", Hostname: "db-01", MacAddress: "aa:bb:cc:dd:ee:02", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-3", Hostname: "idle-01", MacAddress: "aa:bb:cc:dd:ee:03", IPAddress: testRemoteIP, Status: models.MachineStatusReady})
+	db.Create(&models.OSProfile{ID: "profile-ubuntu", Name: "Ubuntu 22.04", Distro: "ubuntu22", Version: "22.04", Config: models.ProfileConfig{RootPasswordHash: "$6$salt$hash"}})
+	db.Create(&models.OSProfile{ID: "profile-centos", Name: "CentOS 7", Distro: "centos7", Version: "7.9"})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeInstallOS, Status: models.JobStatusPending, ProfileID: "profile-ubuntu"})
+	db.Create(&models.Job{ID: "job-2", MachineID: "machine-2", Type: models.JobTypeInstallOS, Status: models.JobStatusRunning, ProfileID: "profile-centos"})
+
+	tests := []struct {
+		name           string
+		machineID      string
+		file           string
+		wantStatusCode int
+		wantContains   string
+	}{
+		{"user-data", "machine-1", "user-data", http.StatusOK, "autoinstall:"},
+		{"meta-data", "machine-1", "meta-data", http.StatusOK, "local-hostname: web-01"},
+		{"vendor-data", "machine-1", "vendor-data", http.StatusOK, "#cloud-config"},
+		{"Unknown file", "machine-1", "network-config", http.StatusNotFound, "Unknown NoCloud file"},
+		{"Machine not found", "missing", "user-data", http.StatusNotFound, "Machine not found"},
+		{"No install job", "machine-3", "user-data", http.StatusNotFound, "No pending installation job"},
+		{"Non-Ubuntu profile", "machine-2", "user-data", http.StatusBadRequest, "only supports Ubuntu"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/boot/autoinstall/"+tt.machineID+"/"+tt.file, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("machine_id", "file")
+			c.SetParamValues(tt.machineID, tt.file)
+
+			if err := handler.ServeAutoinstall(c); err != nil {
+				t.Fatalf("ServeAutoinstall() error = %v", err)
+			}
+
+			if rec.Code != tt.wantStatusCode {
+				t.Errorf("Status = %v, want %v", rec.Code, tt.wantStatusCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantContains) {
+				t.Errorf("Body = %q, want to contain %q", rec.Body.String(), tt.wantContains)
+			}
+		})
+	}
+}
+
+func TestBootConfigHandler_ServePreseed(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootConfigHandler("http://10.0.0.10:8080", newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "deb-01", MacAddress: "aa:bb:cc:dd:ee:01", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-2", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:02", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.OSProfile{ID: "profile-debian", Name: "Debian 12", Distro: "debian12", Version: "12"})
+	db.Create(&models.OSProfile{ID: "profile-ubuntu", Name: "Ubuntu 22.04", Distro: "ubuntu22", Version: "22.04", Config: models.ProfileConfig{RootPasswordHash: "$6$salt$hash"}})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeInstallOS, Status: models.JobStatusPending, ProfileID: "profile-debian"})
+	db.Create(&models.Job{ID: "job-2", MachineID: "machine-2", Type: models.JobTypeInstallOS, Status: models.JobStatusPending, ProfileID: "profile-ubuntu"})
+
+	tests := []struct {
+		name           string
+		machineID      string
+		wantStatusCode int
+		wantContains   string
+	}{
+		{"Debian preseed", "machine-1", http.StatusOK, "d-i mirror/suite string bookworm"},
+		{"Machine not found", "missing", http.StatusNotFound, "Machine not found"},
+		{"Non-Debian profile", "machine-2", http.StatusBadRequest, "only supports Debian"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/boot/preseed/"+tt.machineID, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("machine_id")
+			c.SetParamValues(tt.machineID)
+
+			if err := handler.ServePreseed(c); err != nil {
+				t.Fatalf("ServePreseed() error = %v", err)
+			}
+
+			if rec.Code != tt.wantStatusCode {
+				t.Errorf("Status = %v, want %v", rec.Code, tt.wantStatusCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantContains) {
+				t.Errorf("Body = %q, want to contain %q", rec.Body.String(), tt.wantContains)
+			}
+		})
+	}
+}
+
+func TestBootConfigHandler_ServeKickstart(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootConfigHandler("http://10.0.0.10:8080", newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-2", Hostname: "suse-01", MacAddress: "aa:bb:cc:dd:ee:02", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.OSProfile{ID: "profile-centos", Name: "CentOS 7", Distro: "centos7", Version: "7.9"})
+	db.Create(&models.OSProfile{ID: "profile-suse", Name: "SLES 15", Distro: "sles15", Version: "15"})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeInstallOS, Status: models.JobStatusPending, ProfileID: "profile-centos"})
+	db.Create(&models.Job{ID: "job-2", MachineID: "machine-2", Type: models.JobTypeInstallOS, Status: models.JobStatusPending, ProfileID: "profile-suse"})
+
+	tests := []struct {
+		name           string
+		machineID      string
+		wantStatusCode int
+		wantContains   string
+	}{
+		{"Kickstart", "machine-1", http.StatusOK, "--hostname=web-01"},
+		{"Installer credential", "machine-1", http.StatusOK, `"install_token": "` + handler.authority.InstallerToken("job-1", "machine-1") + `"`},
+		{"Machine not found", "missing", http.StatusNotFound, "Machine not found"},
+		{"Non-RHEL profile", "machine-2", http.StatusBadRequest, "only supports RHEL-based"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/boot/kickstart/"+tt.machineID, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("machine_id")
+			c.SetParamValues(tt.machineID)
+
+			if err := handler.ServeKickstart(c); err != nil {
+				t.Fatalf("ServeKickstart() error = %v", err)
+			}
+
+			if rec.Code != tt.wantStatusCode {
+				t.Errorf("Status = %v, want %v", rec.Code, tt.wantStatusCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantContains) {
+				t.Errorf("Body = %q, want to contain %q", rec.Body.String(), tt.wantContains)
+			}
+		})
+	}
+}
+
+func TestBootConfigHandler_RequiresTargetMachine(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootConfigHandler("http://10.0.0.10:8080", newTestAuthority())
+	handler.SetLeases(fakeLeases{"aa:bb:cc:dd:ee:02": "10.0.0.2", "aa:bb:cc:dd:ee:03": testRemoteIP})
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", IPAddress: "10.0.0.1", Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-2", Hostname: "web-02", MacAddress: "aa:bb:cc:dd:ee:02", IPAddress: testRemoteIP, Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-3", Hostname: "web-03", MacAddress: "aa:bb:cc:dd:ee:03", Status: models.MachineStatusInstalling})
+	db.Create(&models.Machine{ID: "machine-4", Hostname: "web-04", MacAddress: "aa:bb:cc:dd:ee:04", Status: models.MachineStatusInstalling})
+	db.Create(&models.OSProfile{ID: "profile-centos", Name: "CentOS 7", Distro: "centos7", Version: "7.9"})
+	for _, id := range []string{"machine-1", "machine-2", "machine-3", "machine-4"} {
+		db.Create(&models.Job{ID: "job-" + id, MachineID: id, Type: models.JobTypeInstallOS, Status: models.JobStatusPending, ProfileID: "profile-centos"})
+	}
+
+	tests := []struct {
+		name           string
+		machineID      string
+		wantStatusCode int
+	}{
+		{"Request from another address", "machine-1", http.StatusForbidden},
+		{"Lease overrides reported address", "machine-2", http.StatusForbidden},
+		{"Request from leased address", "machine-3", http.StatusOK},
+		{"Machine address unknown", "machine-4", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/boot/kickstart/"+tt.machineID, nil), rec)
+			c.SetParamNames("machine_id")
+			c.SetParamValues(tt.machineID)
+
+			if err := handler.ServeKickstart(c); err != nil {
+				t.Fatalf("ServeKickstart() error = %v", err)
+			}
+			if rec.Code != tt.wantStatusCode {
+				t.Errorf("Status = %v, want %v: %s", rec.Code, tt.wantStatusCode, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK && strings.Contains(rec.Body.String(), "install_token") {
+				t.Errorf("refused response leaks the installer token")
+			}
+		})
+	}
+}
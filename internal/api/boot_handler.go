@@ -1,10 +1,17 @@
 package api
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/dispatch"
+	"github.com/cloudboot/cloudboot-ng/internal/core/lifecycle"
 	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/google/uuid"
@@ -12,27 +19,44 @@ import (
 )
 
 // BootHandler Boot API处理器（Agent专用）
+//
+// 除注册和Provider下载外，接口均要求Agent令牌（RequireAgent），且只能访问令牌所属机器的任务
 type BootHandler struct {
-	broker *logbroker.Broker
+	broker     *logbroker.Broker
+	dispatcher *dispatch.Dispatcher
+	authority  *agentauth.Authority
+	finisher   *workflow.Finisher
 }
 
 // NewBootHandler 创建BootHandler
-func NewBootHandler(broker *logbroker.Broker) *BootHandler {
+// 默认分发器不含Provider，需要Provider的任务通过 SetDispatcher 配置后才能下发
+func NewBootHandler(broker *logbroker.Broker, authority *agentauth.Authority) *BootHandler {
 	return &BootHandler{
-		broker: broker,
+		broker:     broker,
+		dispatcher: dispatch.NewDispatcher(nil, ""),
+		authority:  authority,
+		finisher:   workflow.NewFinisher(broker),
 	}
 }
 
+// SetDispatcher 设置任务分发器
+func (h *BootHandler) SetDispatcher(dispatcher *dispatch.Dispatcher) {
+	h.dispatcher = dispatcher
+}
+
 // RegisterAgent Agent上线注册/心跳
-// POST /api/boot/v1/register
+// POST /api/boot/v1/register-legacy
+//
+// 凭据要求与 AgentHandler.Register 相同：引导令牌或本机Agent令牌
 func (h *BootHandler) RegisterAgent(c echo.Context) error {
 	db := database.GetDB()
 
 	// 解析请求
 	var req struct {
-		Mac         string                `json:"mac" validate:"required"`
-		IP          string                `json:"ip"`
-		Fingerprint *models.HardwareInfo  `json:"fingerprint"`
+		Mac            string               `json:"mac" validate:"required"`
+		IP             string               `json:"ip"`
+		Fingerprint    *models.HardwareInfo `json:"fingerprint"`
+		BootstrapToken string               `json:"bootstrap_token"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -41,6 +65,21 @@ func (h *BootHandler) RegisterAgent(c echo.Context) error {
 		})
 	}
 
+	mac, ok := parseMACAddress(req.Mac)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid MAC address",
+		})
+	}
+	req.Mac = mac
+
+	bootstrap, code, msg := authorizeRegistration(c, h.authority, req.Mac, req.BootstrapToken)
+	if code != http.StatusOK {
+		return c.JSON(code, map[string]interface{}{
+			"error": msg,
+		})
+	}
+
 	// 查询或创建机器
 	var machine models.Machine
 	err := db.Where("mac_address = ?", req.Mac).First(&machine).Error
@@ -71,6 +110,10 @@ func (h *BootHandler) RegisterAgent(c echo.Context) error {
 			})
 		}
 	} else {
+		if err := h.authority.CheckBootstrap(machine.ID, bootstrap); err != nil {
+			return reenrollForbidden(c)
+		}
+
 		// 机器已存在，更新心跳和IP
 		machine.IPAddress = req.IP
 		machine.UpdatedAt = time.Now()
@@ -98,32 +141,86 @@ func (h *BootHandler) RegisterAgent(c echo.Context) error {
 		taskID = pendingJob.ID
 	}
 
+	token, expiresAt, err := h.authority.Issue(&machine, bootstrap)
+	if errors.Is(err, agentauth.ErrAlreadyEnrolled) {
+		return reenrollForbidden(c)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to issue agent token",
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status":     "ok",
-		"machine_id": machine.ID,
-		"task_id":    taskID,
+		"status":           "ok",
+		"machine_id":       machine.ID,
+		"task_id":          taskID,
+		"agent_token":      token,
+		"token_expires_at": expiresAt,
 	})
 }
 
 // GetTask Agent轮询任务
-// GET /api/boot/v1/task
+// GET /api/boot/v1/task（可选 ?mac=... 或 ?machine_id=...，须与Agent令牌所属机器一致）
+//
+// 工作流任务下发下一个可执行的步骤；普通任务按类型整体下发。
+// 需要Provider的任务附带会话密钥和带签名、有时效的下载链接。
 func (h *BootHandler) GetTask(c echo.Context) error {
 	db := database.GetDB()
 	mac := c.QueryParam("mac")
+	machineID := c.QueryParam("machine_id")
 
-	if mac == "" {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": "MAC address required",
-		})
+	if mac == "" && machineID == "" {
+		agent := CurrentAgent(c)
+		if agent == nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "MAC address required",
+			})
+		}
+		machineID = agent.MachineID
 	}
 
 	// 查询机器
 	var machine models.Machine
-	if err := db.Where("mac_address = ?", mac).First(&machine).Error; err != nil {
+	query := db.Where("mac_address = ?", mac)
+	if machineID != "" {
+		query = db.Where("id = ?", machineID)
+	}
+	if err := query.First(&machine).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Machine not found",
 		})
 	}
+	if !agentOwns(c, machine.ID) {
+		return agentForbidden(c)
+	}
+
+	// 工作流任务：下发下一个可执行的步骤
+	if wfJob, err := workflow.ActiveJob(db, machine.ID); err == nil {
+		step, err := workflow.NextStep(db, wfJob)
+		if err != nil {
+			log.Printf("⚠️  任务 %s 步骤调度失败: %v", wfJob.ID, err)
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to schedule job step",
+			})
+		}
+		if step == nil {
+			// 当前步骤仍在执行
+			return noTask(c)
+		}
+
+		spec, err := h.dispatcher.Dispatch(db, wfJob, step, &machine)
+		if err != nil {
+			// 分发失败按步骤执行失败处理（可重试）
+			log.Printf("⚠️  任务 %s 步骤 %s 分发失败: %v", wfJob.ID, step.Name, err)
+			if err := workflow.CompleteStep(db, wfJob, step.ID, false, err.Error()); err != nil {
+				log.Printf("⚠️  任务 %s 步骤状态更新失败: %v", wfJob.ID, err)
+			}
+			h.finishJob(wfJob, lifecycle.ActorSystem)
+			return noTask(c)
+		}
+		return c.JSON(http.StatusOK, spec)
+	}
 
 	// 查询待执行任务
 	var job models.Job
@@ -132,19 +229,17 @@ func (h *BootHandler) GetTask(c echo.Context) error {
 
 	if err != nil {
 		// 无任务
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"task_id": nil,
-			"message": "No task available",
-		})
+		return noTask(c)
 	}
 
-	// 返回任务规范（简化版，真实实现需要根据任务类型生成CSPM配置）
-	taskSpec := map[string]interface{}{
-		"task_id": job.ID,
-		"action":  "probe", // TODO: 根据Job类型动态生成
-		"provider_url": "",
-		"session_key":  "",
-		"config":       map[string]interface{}{},
+	spec, err := h.dispatcher.Dispatch(db, &job, nil, &machine)
+	if err != nil {
+		log.Printf("⚠️  任务 %s 分发失败: %v", job.ID, err)
+		job.SetError(err)
+		job.UpdatedAt = time.Now()
+		db.Save(&job)
+		h.finishJob(&job, lifecycle.ActorSystem)
+		return noTask(c)
 	}
 
 	// 更新任务状态为Running
@@ -153,16 +248,84 @@ func (h *BootHandler) GetTask(c echo.Context) error {
 	job.UpdatedAt = time.Now()
 	db.Save(&job)
 
-	return c.JSON(http.StatusOK, taskSpec)
+	return c.JSON(http.StatusOK, spec)
+}
+
+// DownloadProvider 下载以会话密钥加密的Provider
+// GET /api/boot/v1/providers/:id/blob?task=...&expires=...&sig=...
+//
+// 链接由 GetTask 签发，绑定任务和机器，过期或下载一次后失效。
+// 会话密钥只在任务响应中下发，这里只返回密文。
+func (h *BootHandler) DownloadProvider(c echo.Context) error {
+	db := database.GetDB()
+	providerID := c.Param("id")
+	taskID := c.QueryParam("task")
+	expires := c.QueryParam("expires")
+
+	if taskID == "" || !h.dispatcher.Sessions().Verify(providerID, taskID, expires, c.QueryParam("sig")) {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": "Invalid download signature",
+		})
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return c.JSON(http.StatusGone, map[string]interface{}{
+			"error": "Download link expired",
+		})
+	}
+
+	// 任务必须仍在执行，且属于会话绑定的机器
+	var job models.Job
+	if err := db.Where("id = ?", taskID).First(&job).Error; err != nil || job.IsTerminal() {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Task not found",
+		})
+	}
+
+	session, ok := h.dispatcher.Sessions().Take(providerID, taskID)
+	if !ok {
+		return c.JSON(http.StatusGone, map[string]interface{}{
+			"error": "Provider session expired or already used",
+		})
+	}
+	if session.MachineID != job.MachineID {
+		log.Printf("⚠️  Provider %s 会话机器不匹配: 任务 %s 属于 %s, 会话绑定 %s", providerID, taskID, job.MachineID, session.MachineID)
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": "Provider session does not belong to this task",
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("X-Provider-ID", providerID)
+	return c.Blob(http.StatusOK, echo.MIMEOctetStream, session.Blob)
+}
+
+// noTask 无可执行任务的响应
+func noTask(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"task_id": nil,
+		"message": "No task available",
+	})
+}
+
+// finishJob 任务结束时驱动机器状态并归档日志
+func (h *BootHandler) finishJob(job *models.Job, actor string) {
+	h.finisher.Finish(database.GetDB(), job, actor)
 }
 
 // UploadLogs Agent上报日志
 // POST /api/boot/v1/logs
+//
+// Agent在任务执行过程中分批上报日志。带 stream 和 seq 的日志按序号去重，
+// Agent断线后重放未确认的日志不会重复出现；响应中的 last_seq 供Agent确认已送达的日志。
 func (h *BootHandler) UploadLogs(c echo.Context) error {
 	var req struct {
 		TaskID string `json:"task_id"`
 		JobID  string `json:"job_id"`
+		Stream string `json:"stream"` // Agent日志流ID，序号在流内递增
 		Logs   []struct {
+			Seq       int64  `json:"seq"`
 			Timestamp string `json:"ts"`
 			Level     string `json:"level"`
 			Component string `json:"component"`
@@ -188,7 +351,19 @@ func (h *BootHandler) UploadLogs(c echo.Context) error {
 		})
 	}
 
+	// 只接受令牌所属机器的任务日志
+	var job models.Job
+	if err := database.GetDB().Select("id", "machine_id").Where("id = ?", jobID).First(&job).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Task not found",
+		})
+	}
+	if !agentOwns(c, job.MachineID) {
+		return agentForbidden(c)
+	}
+
 	// 转发日志到LogBroker
+	duplicates := 0
 	for _, log := range req.Logs {
 		// 解析时间戳
 		var timestamp time.Time
@@ -214,30 +389,57 @@ func (h *BootHandler) UploadLogs(c echo.Context) error {
 			timestamp = time.Now()
 		}
 
-		// 发布到LogBroker
-		h.broker.Publish(jobID, logbroker.LogMessage{
+		msg := logbroker.LogMessage{
 			Timestamp: timestamp,
 			Level:     log.Level,
 			Component: log.Component,
 			Message:   log.Message,
-		})
+			Seq:       log.Seq,
+		}
+
+		// 发布到LogBroker
+		if req.Stream != "" && log.Seq > 0 {
+			if !h.broker.PublishSequenced(jobID, req.Stream, msg) {
+				duplicates++
+			}
+			continue
+		}
+		h.broker.Publish(jobID, msg)
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	resp := map[string]interface{}{
 		"status":        "ok",
-		"logs_received": len(req.Logs),
-	})
+		"logs_received": len(req.Logs) - duplicates,
+		"duplicates":    duplicates,
+	}
+	if req.Stream != "" {
+		resp["last_seq"] = h.broker.LastSeq(jobID, req.Stream)
+	}
+	return c.JSON(http.StatusOK, resp)
 }
 
-// ReportStatus Agent上报任务状态
+// ReportStatus Agent/安装器上报任务状态
 // POST /api/boot/v1/status
+//
+// Agent按 task_id 上报，须携带Agent令牌且任务属于令牌所属机器；
+// 安装器（Kickstart/AutoYaST/Preseed/Autoinstall）携带渲染进安装配置的 task_id、machine_id 和
+// install_token 上报，凭据绑定任务和机器，且只能作用于该机器当前的安装任务（install_os 步骤）。
+// 两种凭据都没有的上报一律拒绝。status 为 installing/running 时仅更新进度，
+// success/failed 结束任务并驱动机器状态迁移。
 func (h *BootHandler) ReportStatus(c echo.Context) error {
 	db := database.GetDB()
 
 	var req struct {
-		TaskID   string `json:"task_id" validate:"required"`
-		Status   string `json:"status" validate:"required"` // success, failed
-		ErrorMsg string `json:"error_msg"`
+		TaskID    string `json:"task_id"`
+		StepID    string `json:"step_id"`
+		MachineID string `json:"machine_id"`
+		Status    string `json:"status" validate:"required"` // installing, running, success, failed
+		Step      string `json:"step"`
+		ErrorMsg  string `json:"error_msg"`
+		// InstallToken 安装器凭据（见 agentauth.InstallerToken）
+		InstallToken string `json:"install_token"`
+		// Result Agent执行Provider后的结构化结果
+		Result map[string]interface{} `json:"result"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -245,32 +447,130 @@ func (h *BootHandler) ReportStatus(c echo.Context) error {
 			"error": "Invalid request body",
 		})
 	}
+	switch req.Status {
+	case "installing", "running", "success", "failed":
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Unknown status: " + req.Status,
+		})
+	}
 
 	// 查询任务
 	var job models.Job
-	if err := db.Where("id = ?", req.TaskID).First(&job).Error; err != nil {
-		return c.JSON(http.StatusNotFound, map[string]interface{}{
-			"error": "Task not found",
+	actor := lifecycle.ActorAgent
+	switch {
+	case req.InstallToken != "":
+		// 安装器上报：先校验凭据，任务须仍是该机器当前的安装任务
+		if !h.authority.VerifyInstaller(req.InstallToken, req.TaskID, req.MachineID) {
+			return agentUnauthenticated(c)
+		}
+		actor = lifecycle.ActorInstaller
+		installJob, err := workflow.FindInstallJob(db, req.MachineID)
+		if err != nil || installJob.ID != req.TaskID {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": "Task not found",
+			})
+		}
+		job = *installJob
+	case CurrentAgent(c) == nil:
+		return agentUnauthenticated(c)
+	case req.TaskID != "":
+		if err := db.Where("id = ?", req.TaskID).First(&job).Error; err != nil {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": "Task not found",
+			})
+		}
+		if !agentOwns(c, job.MachineID) {
+			return agentForbidden(c)
+		}
+	default:
+		// Agent代安装器按 machine_id 上报本机的安装任务
+		if !agentOwns(c, req.MachineID) {
+			return agentForbidden(c)
+		}
+		actor = lifecycle.ActorInstaller
+		installJob, err := workflow.FindInstallJob(db, req.MachineID)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": "Task not found",
+			})
+		}
+		job = *installJob
+	}
+
+	steps, err := workflow.Steps(db, job.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to query job steps",
 		})
 	}
 
-	// 更新任务状态
-	if req.Status == "success" {
-		job.SetSuccess()
+	if len(steps) > 0 {
+		if code, msg := h.reportStepStatus(&job, actor, req.StepID, req.Status, req.Step, req.ErrorMsg, req.Result); code != http.StatusOK {
+			return c.JSON(code, map[string]interface{}{
+				"error": msg,
+			})
+		}
 	} else {
-		job.Error = req.ErrorMsg
-		job.Status = models.JobStatusFailed
-	}
+		// 更新任务状态
+		switch req.Status {
+		case "success":
+			job.SetSuccess()
+		case "installing", "running":
+			job.Status = models.JobStatusRunning
+		case "failed":
+			job.Error = req.ErrorMsg
+			job.Status = models.JobStatusFailed
+		}
+		if req.Step != "" {
+			job.UpdateStep(req.Step)
+		}
+		if req.Result != nil {
+			job.Result = req.Result
+		}
 
-	job.UpdatedAt = time.Now()
+		job.UpdatedAt = time.Now()
 
-	if err := db.Save(&job).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to update job status",
-		})
+		if err := db.Save(&job).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to update job status",
+			})
+		}
 	}
 
+	h.finishJob(&job, actor)
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"status": "ok",
 	})
 }
+
+// reportStepStatus 将上报结果应用到工作流任务的执行中步骤
+// 安装器的上报只能作用于 install_os 步骤
+func (h *BootHandler) reportStepStatus(job *models.Job, actor, stepID, status, progress, errMsg string, result map[string]interface{}) (int, string) {
+	db := database.GetDB()
+
+	step, err := workflow.RunningStep(db, job.ID)
+	if err != nil || (stepID != "" && step.ID != stepID) ||
+		(actor == lifecycle.ActorInstaller && step.Action != models.StepActionInstallOS) {
+		return http.StatusConflict, "Step is not running"
+	}
+
+	if status == "installing" || status == "running" {
+		// 仅更新进度
+		if progress != "" {
+			job.UpdateStep(step.Name + ": " + progress)
+			db.Model(&models.Job{}).Where("id = ?", job.ID).Update("step_current", job.StepCurrent)
+		}
+		return http.StatusOK, ""
+	}
+
+	if err := workflow.RecordStepResult(db, step.ID, result); err != nil {
+		return http.StatusInternalServerError, "Failed to save step result"
+	}
+	err = workflow.CompleteStep(db, job, step.ID, status == "success", errMsg)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to update job status"
+	}
+	return http.StatusOK, ""
+}
@@ -1,22 +1,32 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/dispatch"
 	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
 	"github.com/labstack/echo/v4"
 )
 
 func TestBootHandler_RegisterAgent(t *testing.T) {
 	setupTestDB(t)
 	broker := logbroker.NewBroker()
-	handler := NewBootHandler(broker)
+	authority := newTestAuthority()
+	handler := NewBootHandler(broker, authority)
+
+	token1, _ := authority.IssueBootstrap("aa:bb:cc:dd:ee:01", testRemoteIP, false)
+	token2, _ := authority.IssueBootstrap("aa:bb:cc:dd:ee:02", testRemoteIP, false)
 
 	tests := []struct {
 		name           string
@@ -26,16 +36,31 @@ func TestBootHandler_RegisterAgent(t *testing.T) {
 	}{
 		{
 			name:           "Register new agent",
-			requestBody:    `{"mac":"aa:bb:cc:dd:ee:01","ip":"192.168.1.100"}`,
+			requestBody:    `{"mac":"aa:bb:cc:dd:ee:01","ip":"192.168.1.100","bootstrap_token":"` + token1 + `"}`,
 			wantStatusCode: http.StatusOK,
 			wantMachineID:  true,
 		},
 		{
 			name:           "Register agent with fingerprint",
-			requestBody:    `{"mac":"aa:bb:cc:dd:ee:02","ip":"192.168.1.101","fingerprint":{"schema_version":"1.0"}}`,
+			requestBody:    `{"mac":"AA-BB-CC-DD-EE-02","ip":"192.168.1.101","fingerprint":{"schema_version":"1.0"},"bootstrap_token":"` + token2 + `"}`,
 			wantStatusCode: http.StatusOK,
 			wantMachineID:  true,
 		},
+		{
+			name:           "Bootstrap token reused",
+			requestBody:    `{"mac":"aa:bb:cc:dd:ee:01","bootstrap_token":"` + token1 + `"}`,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Missing credentials",
+			requestBody:    `{"mac":"aa:bb:cc:dd:ee:03"}`,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Invalid MAC",
+			requestBody:    `{"mac":"aa:bb","bootstrap_token":"` + token1 + `"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
 		{
 			name:           "Invalid request body",
 			requestBody:    `{invalid}`,
@@ -72,6 +97,12 @@ func TestBootHandler_RegisterAgent(t *testing.T) {
 				if response["status"] != "ok" {
 					t.Errorf("Status = %v, want ok", response["status"])
 				}
+
+				token, _ := response["agent_token"].(string)
+				claims, err := authority.Verify(token)
+				if err != nil || claims.MachineID != response["machine_id"] {
+					t.Errorf("agent_token = %q verifies to %+v, %v", token, claims, err)
+				}
 			}
 		})
 	}
@@ -80,7 +111,7 @@ func TestBootHandler_RegisterAgent(t *testing.T) {
 func TestBootHandler_RegisterAgent_ExistingMachine(t *testing.T) {
 	db := setupTestDB(t)
 	broker := logbroker.NewBroker()
-	handler := NewBootHandler(broker)
+	handler := NewBootHandler(broker, newTestAuthority())
 
 	// Seed existing machine
 	machine := models.Machine{
@@ -100,7 +131,7 @@ func TestBootHandler_RegisterAgent_ExistingMachine(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/register", strings.NewReader(requestBody))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
-	c := e.NewContext(req, rec)
+	c := asAgent(e.NewContext(req, rec), "existing-machine-id", "aa:bb:cc:dd:ee:ff") // 已持有Agent令牌，无需引导令牌
 
 	if err := handler.RegisterAgent(c); err != nil {
 		t.Fatalf("RegisterAgent() error = %v", err)
@@ -126,9 +157,10 @@ func TestBootHandler_RegisterAgent_ExistingMachine(t *testing.T) {
 func TestBootHandler_GetTask(t *testing.T) {
 	db := setupTestDB(t)
 	broker := logbroker.NewBroker()
-	handler := NewBootHandler(broker)
+	handler := NewBootHandler(broker, newTestAuthority())
 
 	// Seed test machine
+	db.Create(&models.Machine{ID: "machine-3", Hostname: "server-03", MacAddress: "aa:bb:cc:dd:ee:03", Status: models.MachineStatusReady})
 	machine := models.Machine{
 		ID:         "machine-1",
 		Hostname:   "server-01",
@@ -160,15 +192,20 @@ func TestBootHandler_GetTask(t *testing.T) {
 			wantTaskID:     true,
 		},
 		{
-			name:           "No MAC parameter",
+			name:           "No MAC parameter uses agent machine",
 			mac:            "",
-			wantStatusCode: http.StatusBadRequest,
+			wantStatusCode: http.StatusOK,
 		},
 		{
 			name:           "Machine not found",
 			mac:            "ff:ff:ff:ff:ff:ff",
 			wantStatusCode: http.StatusNotFound,
 		},
+		{
+			name:           "Another machine",
+			mac:            "aa:bb:cc:dd:ee:03",
+			wantStatusCode: http.StatusForbidden,
+		},
 	}
 
 	for _, tt := range tests {
@@ -176,7 +213,7 @@ func TestBootHandler_GetTask(t *testing.T) {
 			e := echo.New()
 			req := httptest.NewRequest(http.MethodGet, "/api/boot/v1/task?mac="+tt.mac, nil)
 			rec := httptest.NewRecorder()
-			c := e.NewContext(req, rec)
+			c := asAgent(e.NewContext(req, rec), "machine-1", "aa:bb:cc:dd:ee:01")
 
 			if err := handler.GetTask(c); err != nil {
 				t.Fatalf("GetTask() error = %v", err)
@@ -210,7 +247,7 @@ func TestBootHandler_GetTask(t *testing.T) {
 func TestBootHandler_GetTask_NoTask(t *testing.T) {
 	db := setupTestDB(t)
 	broker := logbroker.NewBroker()
-	handler := NewBootHandler(broker)
+	handler := NewBootHandler(broker, newTestAuthority())
 
 	// Seed test machine without pending job
 	machine := models.Machine{
@@ -224,7 +261,7 @@ func TestBootHandler_GetTask_NoTask(t *testing.T) {
 	e := echo.New()
 	req := httptest.NewRequest(http.MethodGet, "/api/boot/v1/task?mac=aa:bb:cc:dd:ee:02", nil)
 	rec := httptest.NewRecorder()
-	c := e.NewContext(req, rec)
+	c := asAgent(e.NewContext(req, rec), "machine-2", "aa:bb:cc:dd:ee:02")
 
 	if err := handler.GetTask(c); err != nil {
 		t.Fatalf("GetTask() error = %v", err)
@@ -249,9 +286,12 @@ func TestBootHandler_GetTask_NoTask(t *testing.T) {
 }
 
 func TestBootHandler_UploadLogs(t *testing.T) {
-	setupTestDB(t)
+	db := setupTestDB(t)
 	broker := logbroker.NewBroker()
-	handler := NewBootHandler(broker)
+	handler := NewBootHandler(broker, newTestAuthority())
+
+	db.Create(&models.Job{ID: "job-456", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
+	db.Create(&models.Job{ID: "job-other", MachineID: "machine-2", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
 
 	// Subscribe to logs
 	ch := broker.Subscribe("job-456")
@@ -289,6 +329,16 @@ func TestBootHandler_UploadLogs(t *testing.T) {
 			requestBody:    `{"logs":[]}`,
 			wantStatusCode: http.StatusBadRequest,
 		},
+		{
+			name:           "Job of another machine",
+			requestBody:    `{"job_id":"job-other","logs":[{"msg":"spoofed"}]}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Unknown job",
+			requestBody:    `{"job_id":"job-missing","logs":[{"msg":"orphan"}]}`,
+			wantStatusCode: http.StatusNotFound,
+		},
 		{
 			name:           "Invalid JSON",
 			requestBody:    `{invalid}`,
@@ -302,7 +352,7 @@ func TestBootHandler_UploadLogs(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/logs", strings.NewReader(tt.requestBody))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
-			c := e.NewContext(req, rec)
+			c := asAgent(e.NewContext(req, rec), "machine-1", "aa:bb:cc:dd:ee:01")
 
 			if err := handler.UploadLogs(c); err != nil {
 				t.Fatalf("UploadLogs() error = %v", err)
@@ -337,20 +387,86 @@ func TestBootHandler_UploadLogs(t *testing.T) {
 	}
 }
 
+func TestBootHandler_UploadLogs_Replay(t *testing.T) {
+	db := setupTestDB(t)
+	broker := logbroker.NewBroker()
+	handler := NewBootHandler(broker, newTestAuthority())
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
+
+	upload := func(body string) map[string]interface{} {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/logs", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := handler.UploadLogs(asAgent(e.NewContext(req, rec), "machine-1", "aa:bb:cc:dd:ee:01")); err != nil {
+			t.Fatalf("UploadLogs() error = %v", err)
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return response
+	}
+
+	upload(`{"job_id":"job-1","stream":"s1","logs":[{"seq":1,"msg":"a"},{"seq":2,"msg":"b"}]}`)
+	// 响应丢失后Agent重放整批并追加新日志
+	response := upload(`{"job_id":"job-1","stream":"s1","logs":[{"seq":1,"msg":"a"},{"seq":2,"msg":"b"},{"seq":3,"msg":"c"}]}`)
+
+	if response["logs_received"] != float64(1) || response["duplicates"] != float64(2) || response["last_seq"] != float64(3) {
+		t.Errorf("response = %v, want 1 received, 2 duplicates, last_seq 3", response)
+	}
+	if history := broker.GetHistory("job-1"); len(history) != 3 {
+		t.Errorf("history length = %d, want 3", len(history))
+	}
+
+	// 新的日志流（如步骤重试）序号重新开始
+	upload(`{"job_id":"job-1","stream":"s2","logs":[{"seq":1,"msg":"retry"}]}`)
+	if history := broker.GetHistory("job-1"); len(history) != 4 {
+		t.Errorf("history length = %d, want 4", len(history))
+	}
+}
+
+func TestBootHandler_RegisterAgent_NormalizesMAC(t *testing.T) {
+	db := setupTestDB(t)
+	authority := newTestAuthority()
+	handler := NewBootHandler(logbroker.NewBroker(), authority)
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:05", Status: models.MachineStatusReady})
+	token, _ := authority.IssueBootstrap("aa:bb:cc:dd:ee:05", testRemoteIP, false)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/register", strings.NewReader(`{"mac":"AA-BB-CC-DD-EE-05","bootstrap_token":"`+token+`"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := handler.RegisterAgent(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("RegisterAgent() error = %v", err)
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp["machine_id"] != "machine-1" {
+		t.Errorf("RegisterAgent() = %d %v, want existing machine-1", rec.Code, resp)
+	}
+	var count int64
+	db.Model(&models.Machine{}).Count(&count)
+	if count != 1 {
+		t.Errorf("machines = %d, want 1", count)
+	}
+}
+
 func TestBootHandler_ReportStatus(t *testing.T) {
 	db := setupTestDB(t)
 	broker := logbroker.NewBroker()
-	handler := NewBootHandler(broker)
+	handler := NewBootHandler(broker, newTestAuthority())
 
 	tests := []struct {
 		name           string
+		agent          string // 调用者Agent令牌所属机器，为空表示未携带令牌
 		setupJob       func() string
 		requestBody    string
 		wantStatusCode int
 		wantJobStatus  models.JobStatus
 	}{
 		{
-			name: "Report success",
+			name:  "Report success",
+			agent: "machine-1",
 			setupJob: func() string {
 				job := models.Job{
 					ID:        "job-success",
@@ -367,7 +483,8 @@ func TestBootHandler_ReportStatus(t *testing.T) {
 			wantJobStatus:  models.JobStatusSuccess,
 		},
 		{
-			name: "Report failure",
+			name:  "Report failure",
+			agent: "machine-2",
 			setupJob: func() string {
 				job := models.Job{
 					ID:        "job-failed",
@@ -383,12 +500,65 @@ func TestBootHandler_ReportStatus(t *testing.T) {
 			wantStatusCode: http.StatusOK,
 			wantJobStatus:  models.JobStatusFailed,
 		},
+		{
+			name:  "Job of another machine",
+			agent: "machine-2",
+			setupJob: func() string {
+				db.Create(&models.Job{ID: "job-foreign", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
+				return ""
+			},
+			requestBody:    `{"task_id":"job-foreign","status":"success"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Task report without agent token",
+			setupJob:       func() string { return "" },
+			requestBody:    `{"task_id":"job-foreign","status":"success"}`,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Installer report without credential",
+			setupJob:       func() string { return "" },
+			requestBody:    `{"machine_id":"machine-1","status":"success"}`,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Installer report with forged credential",
+			setupJob:       func() string { return "" },
+			requestBody:    `{"task_id":"job-success","machine_id":"machine-1","install_token":"forged","status":"success"}`,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Installer credential of another machine",
+			setupJob:       func() string { return "" },
+			requestBody:    `{"task_id":"job-success","machine_id":"machine-1","install_token":"` + handler.authority.InstallerToken("job-success", "machine-2") + `","status":"success"}`,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Installer report for another machine",
+			agent:          "machine-2",
+			setupJob:       func() string { return "" },
+			requestBody:    `{"machine_id":"machine-1","status":"success"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
 		{
 			name:           "Task not found",
+			agent:          "machine-1",
 			setupJob:       func() string { return "non-existent-job" },
 			requestBody:    `{"task_id":"non-existent-job","status":"success"}`,
 			wantStatusCode: http.StatusNotFound,
 		},
+		{
+			name:  "Unknown status",
+			agent: "machine-3",
+			setupJob: func() string {
+				db.Create(&models.Job{ID: "job-unknown", MachineID: "machine-3", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
+				return "job-unknown"
+			},
+			requestBody:    `{"task_id":"job-unknown","status":"done"}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantJobStatus:  models.JobStatusRunning,
+		},
 		{
 			name:           "Invalid JSON",
 			setupJob:       func() string { return "" },
@@ -406,6 +576,9 @@ func TestBootHandler_ReportStatus(t *testing.T) {
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
+			if tt.agent != "" {
+				asAgent(c, tt.agent, "")
+			}
 
 			if err := handler.ReportStatus(c); err != nil {
 				t.Fatalf("ReportStatus() error = %v", err)
@@ -415,7 +588,7 @@ func TestBootHandler_ReportStatus(t *testing.T) {
 				t.Errorf("Status = %v, want %v", rec.Code, tt.wantStatusCode)
 			}
 
-			if tt.wantStatusCode == http.StatusOK && taskID != "" {
+			if tt.wantJobStatus != "" && taskID != "" {
 				// Verify job status was updated
 				var updatedJob models.Job
 				db.Where("id = ?", taskID).First(&updatedJob)
@@ -431,3 +604,295 @@ func TestBootHandler_ReportStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestBootHandler_ReportStatus_Installer(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootHandler(logbroker.NewBroker(), newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusInstalling})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeInstallOS, Status: models.JobStatusPending})
+	credential := `"task_id":"job-1","machine_id":"machine-1","install_token":"` + handler.authority.InstallerToken("job-1", "machine-1") + `"`
+
+	steps := []struct {
+		body          string
+		wantCode      int
+		wantJob       models.JobStatus
+		wantMachine   models.MachineStatus
+		wantEventsLen int
+	}{
+		{`{"machine_id":"machine-1","status":"success"}`, http.StatusUnauthorized, models.JobStatusPending, models.MachineStatusInstalling, 0},
+		{`{` + credential + `,"status":"installing","step":"pre_install"}`, http.StatusOK, models.JobStatusRunning, models.MachineStatusInstalling, 0},
+		{`{` + credential + `,"status":"success","step":"post_install"}`, http.StatusOK, models.JobStatusSuccess, models.MachineStatusActive, 1},
+		// 任务结束后凭据失效
+		{`{` + credential + `,"status":"failed"}`, http.StatusNotFound, models.JobStatusSuccess, models.MachineStatusActive, 1},
+	}
+
+	for _, step := range steps {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/status", strings.NewReader(step.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		if err := handler.ReportStatus(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("ReportStatus() error = %v", err)
+		}
+		if rec.Code != step.wantCode {
+			t.Fatalf("Status = %v, want %v: %s", rec.Code, step.wantCode, rec.Body.String())
+		}
+
+		var job models.Job
+		db.First(&job, "id = ?", "job-1")
+		var machine models.Machine
+		db.First(&machine, "id = ?", "machine-1")
+		var events int64
+		db.Model(&models.MachineEvent{}).Where("machine_id = ?", "machine-1").Count(&events)
+
+		if job.Status != step.wantJob || machine.Status != step.wantMachine || int(events) != step.wantEventsLen {
+			t.Errorf("after %s: job = %s, machine = %s, events = %d; want %s, %s, %d",
+				step.body, job.Status, machine.Status, events, step.wantJob, step.wantMachine, step.wantEventsLen)
+		}
+	}
+}
+
+func TestBootHandler_Workflow(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootHandler(logbroker.NewBroker(), newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusInstalling})
+	job := models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeProvision, Status: models.JobStatusPending}
+	if err := workflow.Create(db, &job, workflow.ProvisionPipeline); err != nil {
+		t.Fatalf("workflow.Create() error = %v", err)
+	}
+
+	poll := func() map[string]interface{} {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api/boot/v1/task", nil)
+		rec := httptest.NewRecorder()
+		if err := handler.GetTask(asAgent(e.NewContext(req, rec), "machine-1", "aa:bb:cc:dd:ee:01")); err != nil {
+			t.Fatalf("GetTask() error = %v", err)
+		}
+		var task map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &task)
+		return task
+	}
+	// 带 install_token 的为安装器上报（无Agent令牌），其余为Agent上报
+	installer := `"task_id":"job-1","machine_id":"machine-1","install_token":"` + handler.authority.InstallerToken("job-1", "machine-1") + `"`
+	report := func(body string, wantCode int) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/status", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if !strings.Contains(body, "install_token") {
+			asAgent(c, "machine-1", "aa:bb:cc:dd:ee:01")
+		}
+		if err := handler.ReportStatus(c); err != nil {
+			t.Fatalf("ReportStatus() error = %v", err)
+		}
+		if rec.Code != wantCode {
+			t.Fatalf("ReportStatus(%s) status = %v, want %v: %s", body, rec.Code, wantCode, rec.Body.String())
+		}
+	}
+
+	// 审计步骤下发给Agent；执行期间不再下发新步骤
+	task := poll()
+	if task["action"] != "audit" {
+		t.Fatalf("first action = %v, want audit", task["action"])
+	}
+	if next := poll(); next["task_id"] != nil {
+		t.Errorf("poll while step running = %v, want no task", next)
+	}
+	// 安装器不能结束非 install_os 步骤
+	report(`{`+installer+`,"status":"success"}`, http.StatusNotFound)
+	auditStep := task["step_id"].(string)
+	report(`{"task_id":"job-1","step_id":"`+auditStep+`","status":"success","result":{"disks":4}}`, http.StatusOK)
+
+	var audit models.JobStep
+	db.First(&audit, "id = ?", auditStep)
+	if audit.Result["disks"] != float64(4) {
+		t.Errorf("audit step result = %v, want disks=4", audit.Result)
+	}
+
+	// 无固件/RAID/BIOS参数，直接进入安装，由安装器回报
+	if task = poll(); task["action"] != "install_os" {
+		t.Fatalf("second action = %v, want install_os", task["action"])
+	}
+	report(`{`+installer+`,"status":"installing","step":"partitioning"}`, http.StatusOK)
+	report(`{`+installer+`,"status":"success"}`, http.StatusOK)
+
+	if task = poll(); task["action"] != "verify" {
+		t.Fatalf("third action = %v, want verify", task["action"])
+	}
+	report(`{"task_id":"job-1","status":"success"}`, http.StatusOK)
+
+	var stored models.Job
+	db.First(&stored, "id = ?", "job-1")
+	var machine models.Machine
+	db.First(&machine, "id = ?", "machine-1")
+	if stored.Status != models.JobStatusSuccess || machine.Status != models.MachineStatusActive {
+		t.Errorf("job = %s, machine = %s; want success, active", stored.Status, machine.Status)
+	}
+}
+
+func TestBootHandler_GetTask_DispatchFailure(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewBootHandler(logbroker.NewBroker(), newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeConfigRAID, Status: models.JobStatusPending})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/boot/v1/task?machine_id=machine-1", nil)
+	rec := httptest.NewRecorder()
+	if err := handler.GetTask(asAgent(e.NewContext(req, rec), "machine-1", "aa:bb:cc:dd:ee:01")); err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if rec.Code != http.StatusOK || response["task_id"] != nil {
+		t.Errorf("response = %d %v, want no task", rec.Code, response)
+	}
+
+	// 无匹配Provider时任务失败，机器进入 error
+	var job models.Job
+	db.First(&job, "id = ?", "job-1")
+	var machine models.Machine
+	db.First(&machine, "id = ?", "machine-1")
+	if job.Status != models.JobStatusFailed || !strings.Contains(job.Error, "no provider") || machine.Status != models.MachineStatusError {
+		t.Errorf("job = %s (%s), machine = %s; want failed, error", job.Status, job.Error, machine.Status)
+	}
+}
+
+type testProviders struct {
+	drm    *crypto.DRMManager
+	binary []byte
+}
+
+func (p *testProviders) ListProviders() []*cspm.ProviderInfo {
+	return []*cspm.ProviderInfo{{ID: "mock-raid", Manifest: cspm.Manifest{SupportedHardware: []string{"megaraid_sas"}}}}
+}
+func (p *testProviders) DRM() *crypto.DRMManager { return p.drm }
+func (p *testProviders) GetProviderVersion(id, version string) (*cspm.ProviderInfo, error) {
+	return nil, cspm.ErrVersionNotFound
+}
+func (p *testProviders) EncryptedBinary(id, version string) ([]byte, error) {
+	return p.drm.EncryptProviderWithMasterKey(p.binary)
+}
+
+func TestBootHandler_DownloadProvider(t *testing.T) {
+	db := setupTestDB(t)
+	masterKey, _ := crypto.GenerateAES256Key()
+	privateKey, _ := crypto.GenerateECDSAKeyPair()
+	drm, _ := crypto.NewDRMManager(masterKey, &privateKey.PublicKey)
+	providers := &testProviders{drm: drm, binary: []byte("provider-binary")}
+
+	handler := NewBootHandler(logbroker.NewBroker(), newTestAuthority())
+	handler.SetDispatcher(dispatch.NewDispatcher(providers, "http://10.0.0.10:8080"))
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady,
+		HardwareSpec: models.HardwareInfo{StorageControllers: []models.ControllerInfo{{Driver: "megaraid_sas"}}}})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeConfigRAID, Status: models.JobStatusPending})
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := asAgent(e.NewContext(httptest.NewRequest(http.MethodGet, "/api/boot/v1/task", nil), rec), "machine-1", "aa:bb:cc:dd:ee:01")
+	if err := handler.GetTask(c); err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	var spec dispatch.TaskSpec
+	json.Unmarshal(rec.Body.Bytes(), &spec)
+	if spec.ProviderURL == "" || spec.SessionKey == "" {
+		t.Fatalf("task spec missing provider: %s", rec.Body.String())
+	}
+	u, _ := url.Parse(spec.ProviderURL)
+
+	download := func(rawQuery string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, u.Path+"?"+rawQuery, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("mock-raid")
+		if err := handler.DownloadProvider(c); err != nil {
+			t.Fatalf("DownloadProvider() error = %v", err)
+		}
+		return rec
+	}
+
+	tampered := strings.Replace(u.RawQuery, "task=job-1", "task=job-2", 1)
+	if rec := download(tampered); rec.Code != http.StatusForbidden {
+		t.Errorf("tampered link status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = download(u.RawQuery)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("download status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "provider-binary") {
+		t.Error("provider served in plaintext")
+	}
+	key, _ := base64.StdEncoding.DecodeString(spec.SessionKey)
+	plain, err := drm.DecryptWithSessionKey(rec.Body.Bytes(), key)
+	if err != nil || string(plain) != "provider-binary" {
+		t.Errorf("decrypted provider = %q, %v", plain, err)
+	}
+
+	// 一次性链接
+	if rec := download(u.RawQuery); rec.Code != http.StatusGone {
+		t.Errorf("second download status = %d, want %d", rec.Code, http.StatusGone)
+	}
+}
+
+func TestBootHandler_RegisterAgent_Reenroll(t *testing.T) {
+	db := setupTestDB(t)
+	authority := newTestAuthority()
+	handler := NewBootHandler(logbroker.NewBroker(), authority)
+	machine := models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:05", IPAddress: "10.0.0.5", Status: models.MachineStatusReady}
+	db.Create(&machine)
+	old, _, _ := authority.Issue(&machine, nil)
+
+	register := func(token string) (int, map[string]interface{}) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/register", strings.NewReader(`{"mac":"aa:bb:cc:dd:ee:05","ip":"`+testRemoteIP+`","bootstrap_token":"`+token+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := handler.RegisterAgent(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("RegisterAgent() error = %v", err)
+		}
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	// 未绑定的引导令牌不能接管已注册的机器，机器信息保持不变
+	unbound, _ := authority.IssueBootstrap("aa:bb:cc:dd:ee:05", testRemoteIP, false)
+	if code, resp := register(unbound); code != http.StatusForbidden {
+		t.Fatalf("unbound bootstrap: %d %v, want 403", code, resp)
+	}
+	var stored models.Machine
+	db.First(&stored, "id = ?", "machine-1")
+	if stored.IPAddress != "10.0.0.5" {
+		t.Errorf("IPAddress = %q after refused registration, want 10.0.0.5", stored.IPAddress)
+	}
+	if _, err := authority.Verify(old); err != nil {
+		t.Errorf("old token after refused registration error = %v", err)
+	}
+
+	// 运维批准后用绑定的引导令牌注册，凭据轮换
+	authority.ApproveReenroll("machine-1", time.Hour)
+	bound, _ := authority.IssueBootstrap("aa:bb:cc:dd:ee:05", testRemoteIP, true)
+	code, resp := register(bound)
+	if code != http.StatusOK {
+		t.Fatalf("approved re-enrollment: %d %v, want 200", code, resp)
+	}
+	if _, err := authority.Verify(old); err == nil {
+		t.Error("old token still valid after re-enrollment")
+	}
+	token, _ := resp["agent_token"].(string)
+	if claims, err := authority.Verify(token); err != nil || claims.MachineID != "machine-1" {
+		t.Errorf("agent_token verifies to %+v, %v", claims, err)
+	}
+	if authority.ReenrollApproved("machine-1") {
+		t.Error("approval not cleared after re-enrollment")
+	}
+}
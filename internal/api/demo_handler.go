@@ -52,6 +52,7 @@ func (h *DemoHandler) TriggerOrchestratorDemo(c echo.Context) error {
 	job := &models.Job{
 		ID:        uuid.New().String(),
 		MachineID: machine.ID,
+		TenantID:  machine.TenantID,
 		Type:      "config_raid",
 		Status:    "running",
 	}
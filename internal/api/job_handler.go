@@ -1,19 +1,26 @@
 package api
 
 import (
+	"log"
 	"net/http"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/lifecycle"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 // JobHandler 任务管理API处理器
-type JobHandler struct{}
+type JobHandler struct {
+	finisher *workflow.Finisher
+}
 
 // NewJobHandler 创建JobHandler
-func NewJobHandler() *JobHandler {
-	return &JobHandler{}
+func NewJobHandler(finisher *workflow.Finisher) *JobHandler {
+	return &JobHandler{finisher: finisher}
 }
 
 // ListJobs 获取任务列表
@@ -25,8 +32,8 @@ func (h *JobHandler) ListJobs(c echo.Context) error {
 	status := c.QueryParam("status")
 	machineID := c.QueryParam("machine_id")
 
-	// 构建查询
-	query := db.Model(&models.Job{}).Preload("Machine").Preload("Profile")
+	// 构建查询（按租户范围过滤）
+	query := CurrentScope(c).Apply(db.Model(&models.Job{})).Preload("Machine").Preload("Profile").Preload("Steps", orderBySeq)
 
 	// 按状态过滤
 	if status != "" {
@@ -58,7 +65,7 @@ func (h *JobHandler) GetJob(c echo.Context) error {
 	id := c.Param("id")
 
 	var job models.Job
-	if err := db.Preload("Machine").Preload("Profile").Where("id = ?", id).First(&job).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Preload("Machine").Preload("Profile").Preload("Steps", orderBySeq).Where("id = ?", id).First(&job).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Job not found",
 		})
@@ -67,6 +74,34 @@ func (h *JobHandler) GetJob(c echo.Context) error {
 	return c.JSON(http.StatusOK, job)
 }
 
+// ListJobSteps 获取任务的工作流步骤进度
+// GET /api/v1/jobs/:id/steps
+func (h *JobHandler) ListJobSteps(c echo.Context) error {
+	db := database.GetDB()
+	id := c.Param("id")
+
+	var job models.Job
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&job).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Job not found",
+		})
+	}
+
+	steps, err := workflow.Steps(db, job.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to query job steps",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"job_id":       job.ID,
+		"status":       job.Status,
+		"step_current": job.StepCurrent,
+		"items":        steps,
+	})
+}
+
 // CancelJob 取消任务（仅运行中的任务）
 // DELETE /api/v1/jobs/:id
 func (h *JobHandler) CancelJob(c echo.Context) error {
@@ -75,7 +110,7 @@ func (h *JobHandler) CancelJob(c echo.Context) error {
 
 	// 查询任务
 	var job models.Job
-	if err := db.Where("id = ?", id).First(&job).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&job).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Job not found",
 		})
@@ -89,6 +124,7 @@ func (h *JobHandler) CancelJob(c echo.Context) error {
 	}
 
 	// 取消任务
+	before := audit.Snapshot(job)
 	job.Status = models.JobStatusFailed
 	job.Error = "Cancelled by user"
 	if err := db.Save(&job).Error; err != nil {
@@ -97,5 +133,19 @@ func (h *JobHandler) CancelJob(c echo.Context) error {
 		})
 	}
 
+	// 未结束的工作流步骤一并取消
+	if err := workflow.Cancel(db, job.ID); err != nil {
+		log.Printf("⚠️  任务 %s 步骤取消失败: %v", job.ID, err)
+	}
+
+	// 安装中的机器回到 ready，通知日志订阅者并归档日志
+	h.finisher.Cancel(db, &job, lifecycle.ActorUser)
+
+	recordAudit(c, "job.cancel", job.ID, before, job)
 	return c.JSON(http.StatusOK, job)
 }
+
+// orderBySeq 预加载步骤时按序号排序
+func orderBySeq(db *gorm.DB) *gorm.DB {
+	return db.Order("seq")
+}
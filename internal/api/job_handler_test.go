@@ -7,13 +7,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/labstack/echo/v4"
 )
 
 func TestJobHandler_ListJobs(t *testing.T) {
 	db := setupTestDB(t)
-	handler := NewJobHandler()
+	handler := NewJobHandler(workflow.NewFinisher(nil))
 
 	// Seed test machines first
 	machine1 := models.Machine{
@@ -109,7 +110,7 @@ func TestJobHandler_ListJobs(t *testing.T) {
 
 func TestJobHandler_GetJob(t *testing.T) {
 	db := setupTestDB(t)
-	handler := NewJobHandler()
+	handler := NewJobHandler(workflow.NewFinisher(nil))
 
 	// Seed test machine
 	machine := models.Machine{
@@ -180,7 +181,7 @@ func TestJobHandler_GetJob(t *testing.T) {
 
 func TestJobHandler_CancelJob(t *testing.T) {
 	db := setupTestDB(t)
-	handler := NewJobHandler()
+	handler := NewJobHandler(workflow.NewFinisher(nil))
 
 	// Seed test machine
 	machine := models.Machine{
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/license"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// maxLicenseSize License文件大小上限
+const maxLicenseSize = 64 << 10
+
+// LicenseHandler License API处理器
+type LicenseHandler struct {
+	licenses *license.Manager
+}
+
+// NewLicenseHandler 创建LicenseHandler
+func NewLicenseHandler(licenses *license.Manager) *LicenseHandler {
+	return &LicenseHandler{
+		licenses: licenses,
+	}
+}
+
+// licenseView License状态（不含加密的Master Key和签名）
+type licenseView struct {
+	ID           string          `json:"id"`
+	CustomerName string          `json:"customer_name"`
+	CustomerCode string          `json:"customer_code"`
+	Features     models.Features `json:"features"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+	State        license.State   `json:"state"`
+	DaysLeft     int             `json:"days_left"`
+	GraceEndsAt  time.Time       `json:"grace_ends_at"`
+}
+
+func newLicenseView(s license.Status) licenseView {
+	return licenseView{
+		ID:           s.License.ID,
+		CustomerName: s.License.CustomerName,
+		CustomerCode: s.License.CustomerCode,
+		Features:     s.License.Features,
+		ExpiresAt:    s.License.ExpiresAt,
+		State:        s.State,
+		DaysLeft:     s.DaysLeft,
+		GraceEndsAt:  s.GraceEndsAt,
+	}
+}
+
+// GetLicense 获取已导入License的状态和当前可用功能
+// GET /api/v1/license
+func (h *LicenseHandler) GetLicense(c echo.Context) error {
+	statuses := h.licenses.Statuses()
+	items := make([]licenseView, 0, len(statuses))
+	for _, s := range statuses {
+		items = append(items, newLicenseView(s))
+	}
+
+	features := []string{}
+	for _, f := range []string{
+		models.FeatureAudit, models.FeatureOfflineBundle, models.FeatureMultiTenant, models.FeatureAdvancedRAID,
+	} {
+		if h.licenses.HasFeature(f) {
+			features = append(features, f)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items":    items,
+		"total":    len(items),
+		"features": features,
+	})
+}
+
+// ImportLicense 导入License文件（multipart 字段 file，或直接以请求体上传）
+// POST /api/v1/license
+func (h *LicenseHandler) ImportLicense(c echo.Context) error {
+	var reader io.Reader = c.Request().Body
+	if file, err := c.FormFile("file"); err == nil {
+		src, err := file.Open()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to open uploaded file",
+			})
+		}
+		defer src.Close()
+		reader = src
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxLicenseSize+1))
+	if err != nil || len(data) > maxLicenseSize {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid license file",
+		})
+	}
+
+	l, err := h.licenses.Import(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, license.ErrInvalidLicense):
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		case errors.Is(err, license.ErrInvalidSignature), errors.Is(err, license.ErrExpired):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to import license",
+		})
+	}
+
+	for _, s := range h.licenses.Statuses() {
+		if s.License.ID == l.ID {
+			view := newLicenseView(s)
+			recordAudit(c, "license.import", l.ID, nil, view)
+			return c.JSON(http.StatusCreated, view)
+		}
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error": "Failed to load imported license",
+	})
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/license"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestLicenses 创建License管理器，features 非空时导入一份包含这些功能的有效License
+func newTestLicenses(t *testing.T, features ...string) *license.Manager {
+	t.Helper()
+	licenses, key := newTestLicenseManager(t)
+	if len(features) > 0 {
+		data := signedLicense(t, key, models.License{
+			ID:           "license-1",
+			CustomerCode: "test",
+			Features:     features,
+			ExpiresAt:    time.Now().Add(24 * time.Hour),
+		})
+		if _, err := licenses.Import(data); err != nil {
+			t.Fatalf("Failed to import license: %v", err)
+		}
+	}
+	return licenses
+}
+
+func newTestLicenseManager(t *testing.T) (*license.Manager, *ecdsa.PrivateKey) {
+	t.Helper()
+	db := database.GetDB()
+	db.Where("1 = 1").Delete(&models.License{})
+	key, err := crypto.GenerateECDSAKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return license.NewManager(db, &key.PublicKey, license.DefaultGracePeriod), key
+}
+
+func signedLicense(t *testing.T, key *ecdsa.PrivateKey, l models.License) []byte {
+	t.Helper()
+	if err := license.Sign(&l, key); err != nil {
+		t.Fatalf("Failed to sign license: %v", err)
+	}
+	data, _ := json.Marshal(l)
+	return data
+}
+
+func TestRequireFeature(t *testing.T) {
+	setupTestDB(t)
+
+	tests := []struct {
+		name       string
+		features   []string
+		wantStatus int
+	}{
+		{"no license", nil, http.StatusForbidden},
+		{"feature missing", []string{models.FeatureAudit}, http.StatusForbidden},
+		{"feature licensed", []string{models.FeatureAudit, models.FeatureOfflineBundle}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			licenses := newTestLicenses(t, tt.features...)
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/store/import", nil), rec)
+
+			handler := RequireFeature(licenses, models.FeatureOfflineBundle)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusForbidden && !strings.Contains(rec.Body.String(), models.FeatureOfflineBundle) {
+				t.Errorf("response should name the missing feature: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLicenseHandler(t *testing.T) {
+	setupTestDB(t)
+	licenses, key := newTestLicenseManager(t)
+	handler := NewLicenseHandler(licenses)
+
+	valid := models.License{
+		ID:           "license-1",
+		CustomerName: "ACME",
+		CustomerCode: "acme",
+		ProductKey:   "encrypted-master-key",
+		Features:     models.Features{models.FeatureMultiTenant},
+		ExpiresAt:    time.Now().Add(10 * 24 * time.Hour),
+	}
+	otherKey, _ := crypto.GenerateECDSAKeyPair()
+
+	multipartBody := func(data []byte) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "license.json")
+		part.Write(data)
+		writer.Close()
+		return body, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		multipart  bool
+		wantStatus int
+	}{
+		{"wrong signature", signedLicense(t, otherKey, valid), false, http.StatusUnprocessableEntity},
+		{"malformed", []byte("{"), false, http.StatusBadRequest},
+		{"valid raw body", signedLicense(t, key, valid), false, http.StatusCreated},
+		{"valid multipart", signedLicense(t, key, valid), true, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				body        *bytes.Buffer
+				contentType = echo.MIMEApplicationJSON
+			)
+			if tt.multipart {
+				body, contentType = multipartBody(tt.data)
+			} else {
+				body = bytes.NewBuffer(tt.data)
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/license", body)
+			req.Header.Set(echo.HeaderContentType, contentType)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(principalKey, tenantPrincipal("", auth.PermAll))
+
+			if err := handler.ImportLicense(c); err != nil {
+				t.Fatalf("ImportLicense() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/license", nil), rec)
+	if err := handler.GetLicense(c); err != nil {
+		t.Fatalf("GetLicense() error = %v", err)
+	}
+	if strings.Contains(rec.Body.String(), "encrypted-master-key") {
+		t.Errorf("GetLicense() must not expose the product key")
+	}
+	var resp struct {
+		Items    []licenseView `json:"items"`
+		Features []string      `json:"features"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Items) != 1 || resp.Items[0].State != license.StateExpiring {
+		t.Errorf("GetLicense() items = %+v, want one expiring license", resp.Items)
+	}
+	if len(resp.Features) != 1 || resp.Features[0] != models.FeatureMultiTenant {
+		t.Errorf("GetLicense() features = %v", resp.Features)
+	}
+}
+
+func TestProvisionRequiresAdvancedRAID(t *testing.T) {
+	db := setupTestDB(t)
+	db.Create(&models.Machine{ID: "m1", Hostname: "node1", MacAddress: "aa:00:00:00:00:01", Status: models.MachineStatusReady})
+	db.Create(&models.OSProfile{ID: "p1", Name: "centos"})
+
+	tests := []struct {
+		name       string
+		features   []string
+		body       string
+		wantStatus int
+	}{
+		{"raid without license", nil, `{"profile_id":"p1","workflow":"provision","config":{"raid":{"level":"10"}}}`, http.StatusForbidden},
+		{"plain install without license", nil, `{"profile_id":"p1"}`, http.StatusAccepted},
+		{"raid with license", []string{models.FeatureAdvancedRAID}, `{"profile_id":"p1","workflow":"provision","config":{"raid":{"level":"10"}}}`, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.Model(&models.Machine{}).Where("id = ?", "m1").Update("status", models.MachineStatusReady)
+			handler := NewMachineHandler()
+			handler.SetLicenses(newTestLicenses(t, tt.features...))
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/machines/m1/provision", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("m1")
+			c.Set(principalKey, tenantPrincipal("", auth.PermAll))
+
+			if err := handler.ProvisionMachine(c); err != nil {
+				t.Fatalf("ProvisionMachine() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/license"
+	"github.com/labstack/echo/v4"
+)
+
+// RequireFeature 要求存在包含指定功能且仍可用（未过期或在宽限期内）的License
+func RequireFeature(licenses *license.Manager, feature string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !licenses.HasFeature(feature) {
+				return featureNotLicensed(c, feature)
+			}
+			return next(c)
+		}
+	}
+}
+
+// featureNotLicensed 功能未授权响应
+func featureNotLicensed(c echo.Context, feature string) error {
+	return c.JSON(http.StatusForbidden, map[string]interface{}{
+		"error":   "Feature not licensed",
+		"feature": feature,
+	})
+}
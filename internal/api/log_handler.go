@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/logindex"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// LogHandler 任务日志API处理器
+type LogHandler struct {
+	broker *logbroker.Broker
+	index  *logindex.Index
+}
+
+// NewLogHandler 创建LogHandler
+func NewLogHandler(broker *logbroker.Broker) *LogHandler {
+	return &LogHandler{
+		broker: broker,
+	}
+}
+
+// SetIndex 设置日志检索索引（跨任务检索需要）
+func (h *LogHandler) SetIndex(index *logindex.Index) {
+	h.index = index
+}
+
+// DownloadLogs 下载任务日志
+// GET /api/v1/jobs/:id/logs?format=ndjson|text&level=ERROR&component=raid&since=…&until=…&q=…
+//
+// 不带过滤条件时返回完整日志；since/until 可以是RFC3339时间或相对时长（如 24h）。
+func (h *LogHandler) DownloadLogs(c echo.Context) error {
+	id := c.Param("id")
+
+	query, err := parseLogQuery(c, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	var job models.Job
+	if err := CurrentScope(c).Apply(database.GetDB()).Select("id").Where("id = ?", id).First(&job).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Job not found",
+		})
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "ndjson"
+	}
+
+	var contentType, ext string
+	switch format {
+	case "ndjson":
+		contentType, ext = "application/x-ndjson", "ndjson"
+	case "text":
+		contentType, ext = echo.MIMETextPlainCharsetUTF8, "log"
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "format must be ndjson or text",
+		})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="job-%s.%s"`, job.ID, ext))
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := c.Response()
+	enc := json.NewEncoder(w)
+	write := func(msg logbroker.LogMessage) bool {
+		if !query.Match(&msg) {
+			return true
+		}
+		if format == "ndjson" {
+			return enc.Encode(msg) == nil
+		}
+		_, err := fmt.Fprintf(w, "%s [%s] [%s] %s\n", msg.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"), msg.Level, msg.Component, msg.Message)
+		return err == nil
+	}
+
+	// 有持久化存储时流式输出，避免将完整日志读入内存
+	if store := h.broker.Store(); store != nil {
+		return store.Scan(job.ID, write)
+	}
+	for _, msg := range h.broker.GetHistory(job.ID) {
+		if !write(msg) {
+			break
+		}
+	}
+	return nil
+}
+
+// SearchLogs 跨任务检索日志
+// GET /api/v1/logs/search?q=PD offline&since=24h&level=ERROR&component=raid&limit=50
+//
+// 返回命中的任务（按最近命中时间倒序），每个任务附带命中数和第一条命中日志。
+func (h *LogHandler) SearchLogs(c echo.Context) error {
+	if h.index == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"error": "Log search index not configured",
+		})
+	}
+
+	query, err := parseLogQuery(c, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	if query.Text == "" && len(query.Levels) == 0 && query.Component == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "q, level or component is required",
+		})
+	}
+	query.JobID = c.QueryParam("job_id")
+	scope := CurrentScope(c)
+	query.Scope = &scope
+
+	// 检索前写入排队中的日志
+	h.index.Flush()
+
+	matches, err := h.index.SearchJobs(query)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to search logs",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": matches,
+	})
+}
+
+// parseLogQuery 解析日志过滤参数
+func parseLogQuery(c echo.Context, now time.Time) (logindex.Query, error) {
+	q := logindex.Query{
+		Component: c.QueryParam("component"),
+		Text:      c.QueryParam("q"),
+	}
+
+	if levels := c.QueryParam("level"); levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				q.Levels = append(q.Levels, level)
+			}
+		}
+	}
+
+	var err error
+	if q.Since, err = parseTimeParam(c.QueryParam("since"), now); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseTimeParam(c.QueryParam("until"), now); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+
+	for name, dst := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		if raw := c.QueryParam(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s", name)
+			}
+			*dst = n
+		}
+	}
+	return q, nil
+}
+
+// parseTimeParam 解析RFC3339时间或相对时长（now 减去时长）
+func parseTimeParam(raw string, now time.Time) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/logindex"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func newStoreBroker(t *testing.T) *logbroker.Broker {
+	t.Helper()
+	store, err := logbroker.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := logbroker.NewBroker()
+	broker.SetStore(store)
+	return broker
+}
+
+func TestLogHandler_DownloadLogs(t *testing.T) {
+	db := setupTestDB(t)
+	broker := newStoreBroker(t)
+	handler := NewLogHandler(broker)
+
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusSuccess})
+	ts := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: ts, Level: "INFO", Component: "agent", Message: "Task started"})
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: ts, Level: "ERROR", Component: "agent", Message: "Task failed"})
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: ts.Add(time.Hour), Level: "ERROR", Component: "raid", Message: "PD 0:1 offline"})
+
+	tests := []struct {
+		name       string
+		jobID      string
+		query      string
+		wantStatus int
+		wantBody   []string
+		notWant    []string
+	}{
+		{
+			name:       "NDJSON by default",
+			jobID:      "job-1",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{`"msg":"Task started"`, `"level":"ERROR"`},
+		},
+		{
+			name:       "Plain text",
+			jobID:      "job-1",
+			query:      "?format=text",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"2026-01-15T10:00:00.000Z [INFO] [agent] Task started\n", "[ERROR] [agent] Task failed"},
+		},
+		{
+			name:       "Filter by level and component",
+			jobID:      "job-1",
+			query:      "?level=error&component=RAID",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"PD 0:1 offline"},
+			notWant:    []string{"Task started", "Task failed"},
+		},
+		{
+			name:       "Filter by text and time range",
+			jobID:      "job-1",
+			query:      "?q=task&until=2026-01-15T10:30:00Z",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"Task started", "Task failed"},
+			notWant:    []string{"offline"},
+		},
+		{
+			name:       "Invalid since",
+			jobID:      "job-1",
+			query:      "?since=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown format",
+			jobID:      "job-1",
+			query:      "?format=xml",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Job not found",
+			jobID:      "job-missing",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+tt.jobID+"/logs"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.jobID)
+
+			if err := handler.DownloadLogs(c); err != nil {
+				t.Fatalf("DownloadLogs() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("body missing %q:\n%s", want, rec.Body.String())
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(rec.Body.String(), notWant) {
+					t.Errorf("body should not contain %q:\n%s", notWant, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestLogHandler_SearchLogs(t *testing.T) {
+	db := setupTestDB(t)
+	index, err := logindex.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	defer index.Stop()
+
+	store, err := logbroker.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := logbroker.NewBroker()
+	broker.SetStore(logindex.Wrap(store, index))
+	handler := NewLogHandler(broker)
+	handler.SetIndex(index)
+
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeConfigRAID, Status: models.JobStatusFailed})
+	db.Create(&models.Job{ID: "job-2", MachineID: "machine-2", Type: models.JobTypeConfigRAID, Status: models.JobStatusSuccess})
+	now := time.Now()
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: now, Level: "ERROR", Component: "raid", Message: "PD 0:1 offline"})
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: now, Level: "ERROR", Component: "raid", Message: "PD 0:2 offline"})
+	broker.Publish("job-2", logbroker.LogMessage{Timestamp: now, Level: "INFO", Component: "raid", Message: "VD 0 created"})
+	broker.Publish("job-2", logbroker.LogMessage{Timestamp: now.Add(-48 * time.Hour), Level: "ERROR", Component: "raid", Message: "PD 0:3 offline"})
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantJobs   []string
+	}{
+		{
+			name:       "Text across jobs",
+			query:      "?q=offline",
+			wantStatus: http.StatusOK,
+			wantJobs:   []string{"job-1", "job-2"},
+		},
+		{
+			name:       "Text within last 24h",
+			query:      "?q=offline&since=24h",
+			wantStatus: http.StatusOK,
+			wantJobs:   []string{"job-1"},
+		},
+		{
+			name:       "Level filter",
+			query:      "?level=INFO",
+			wantStatus: http.StatusOK,
+			wantJobs:   []string{"job-2"},
+		},
+		{
+			name:       "No criteria",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid limit",
+			query:      "?q=offline&limit=-1",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/logs/search"+tt.query, nil)
+			rec := httptest.NewRecorder()
+
+			if err := handler.SearchLogs(e.NewContext(req, rec)); err != nil {
+				t.Fatalf("SearchLogs() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Items []logindex.JobMatch `json:"items"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range resp.Items {
+				got = append(got, item.JobID)
+				if item.Job == nil || item.Sample == nil {
+					t.Errorf("item %s missing job or sample", item.JobID)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.wantJobs, ",") {
+				t.Errorf("jobs = %v, want %v", got, tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestBootHandler_FinishJobSealsLogs(t *testing.T) {
+	db := setupTestDB(t)
+	broker := newStoreBroker(t)
+	handler := NewBootHandler(broker, newTestAuthority())
+
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady})
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "audit done"})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/boot/v1/status", strings.NewReader(`{"task_id":"job-1","status":"success"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := handler.ReportStatus(asAgent(e.NewContext(req, rec), "machine-1", "aa:bb:cc:dd:ee:01")); err != nil {
+		t.Fatalf("ReportStatus() error = %v", err)
+	}
+
+	var job models.Job
+	db.First(&job, "id = ?", "job-1")
+	if job.LogsPath != broker.Store().Path("job-1") {
+		t.Errorf("LogsPath = %q, want %q", job.LogsPath, broker.Store().Path("job-1"))
+	}
+	if history := broker.GetHistory("job-1"); len(history) != 1 {
+		t.Errorf("GetHistory() after seal = %d messages, want 1", len(history))
+	}
+}
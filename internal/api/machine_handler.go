@@ -1,23 +1,46 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/license"
+	"github.com/cloudboot/cloudboot-ng/internal/core/lifecycle"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 // MachineHandler 机器管理API处理器
-type MachineHandler struct{}
+type MachineHandler struct {
+	licenses  *license.Manager
+	authority *agentauth.Authority
+}
 
 // NewMachineHandler 创建MachineHandler
 func NewMachineHandler() *MachineHandler {
 	return &MachineHandler{}
 }
 
+// SetLicenses 设置License管理器（RAID配置参数需要 advanced_raid 功能，未设置时不校验）
+func (h *MachineHandler) SetLicenses(licenses *license.Manager) {
+	h.licenses = licenses
+}
+
+// SetAgentAuthority 设置Agent凭据签发器（批准重新注册时使用）
+func (h *MachineHandler) SetAgentAuthority(authority *agentauth.Authority) {
+	h.authority = authority
+}
+
 // ListMachines 获取机器列表
 // GET /api/v1/machines
 func (h *MachineHandler) ListMachines(c echo.Context) error {
@@ -28,8 +51,8 @@ func (h *MachineHandler) ListMachines(c echo.Context) error {
 	page := c.QueryParam("page")
 	pageSize := c.QueryParam("page_size")
 
-	// 构建查询
-	query := db.Model(&models.Machine{})
+	// 构建查询（按租户范围过滤）
+	query := CurrentScope(c).Apply(db.Model(&models.Machine{}))
 
 	// 按状态过滤
 	if status != "" {
@@ -70,7 +93,7 @@ func (h *MachineHandler) GetMachine(c echo.Context) error {
 	id := c.Param("id")
 
 	var machine models.Machine
-	if err := db.Where("id = ?", id).First(&machine).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&machine).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Machine not found",
 		})
@@ -96,14 +119,29 @@ func (h *MachineHandler) CreateMachine(c echo.Context) error {
 			"error": "Invalid request body",
 		})
 	}
+	mac, ok := parseMACAddress(req.Mac)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid MAC address",
+		})
+	}
+	req.Mac = mac
 
-	// 检查MAC地址是否已存在
+	// 检查MAC地址是否已存在（其他租户的机器不返回ID）
+	scope := CurrentScope(c)
 	var existing models.Machine
 	if err := db.Where("mac_address = ?", req.Mac).First(&existing).Error; err == nil {
-		return c.JSON(http.StatusConflict, map[string]interface{}{
+		resp := map[string]interface{}{
 			"error": "Machine with this MAC address already exists",
-			"id":    existing.ID,
-		})
+		}
+		if scope.Allows(existing.TenantID) {
+			resp["id"] = existing.ID
+		}
+		return c.JSON(http.StatusConflict, resp)
+	}
+
+	if err := tenant.CheckQuota(db, scope.Assign(), tenant.ResourceMachines, 1); err != nil {
+		return quotaError(c, err)
 	}
 
 	// 创建机器记录
@@ -113,6 +151,7 @@ func (h *MachineHandler) CreateMachine(c echo.Context) error {
 		MacAddress: req.Mac,
 		IPAddress:  req.IP,
 		Status:     models.MachineStatusDiscovered,
+		TenantID:   scope.Assign(),
 		HardwareSpec: models.HardwareInfo{
 			SchemaVersion: "1.0",
 		},
@@ -126,6 +165,7 @@ func (h *MachineHandler) CreateMachine(c echo.Context) error {
 		})
 	}
 
+	recordAudit(c, "machine.create", machine.ID, nil, machine)
 	return c.JSON(http.StatusCreated, machine)
 }
 
@@ -137,17 +177,19 @@ func (h *MachineHandler) UpdateMachine(c echo.Context) error {
 
 	// 查询机器
 	var machine models.Machine
-	if err := db.Where("id = ?", id).First(&machine).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&machine).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Machine not found",
 		})
 	}
+	before := audit.Snapshot(machine)
 
 	// 解析更新数据
 	var req struct {
 		Hostname *string                `json:"hostname"`
 		Status   *models.MachineStatus  `json:"status"`
 		Hardware *models.HardwareInfo   `json:"hardware"`
+		TenantID *string                `json:"tenant_id"` // 调整归属租户，需要 tenant:manage
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -156,25 +198,59 @@ func (h *MachineHandler) UpdateMachine(c echo.Context) error {
 		})
 	}
 
-	// 更新字段
-	if req.Hostname != nil {
-		machine.Hostname = *req.Hostname
-	}
-	if req.Status != nil {
-		machine.Status = *req.Status
+	if req.TenantID != nil && *req.TenantID != machine.TenantID {
+		if !CurrentScope(c).Enabled || !CurrentPrincipal(c).Has(auth.PermTenantManage) {
+			return c.JSON(http.StatusForbidden, map[string]interface{}{
+				"error": "Changing the tenant requires tenant:manage",
+			})
+		}
+		if *req.TenantID != "" {
+			if _, err := tenant.Get(db, *req.TenantID); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]interface{}{
+					"error": "Unknown tenant",
+				})
+			}
+		}
+		if err := tenant.CheckQuota(db, *req.TenantID, tenant.ResourceMachines, 1); err != nil {
+			return quotaError(c, err)
+		}
 	}
-	if req.Hardware != nil {
-		machine.HardwareSpec = *req.Hardware
-	}
-
-	machine.UpdatedAt = time.Now()
 
-	if err := db.Save(&machine).Error; err != nil {
+	// 状态变更必须经过状态机，与其他字段在同一事务中提交
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if req.Status != nil {
+			if err := lifecycle.Transition(tx, &machine, *req.Status, lifecycle.Cause{
+				Actor:  lifecycle.ActorUser,
+				Reason: "updated via API",
+			}); err != nil {
+				return err
+			}
+		}
+
+		// 更新字段
+		if req.Hostname != nil {
+			machine.Hostname = *req.Hostname
+		}
+		if req.Hardware != nil {
+			machine.HardwareSpec = *req.Hardware
+		}
+		if req.TenantID != nil {
+			machine.TenantID = *req.TenantID
+		}
+
+		machine.UpdatedAt = time.Now()
+		return tx.Save(&machine).Error
+	})
+	if err != nil {
+		if isTransitionError(err) {
+			return transitionError(c, err)
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error": "Failed to update machine",
 		})
 	}
 
+	recordAudit(c, "machine.update", machine.ID, before, machine)
 	return c.JSON(http.StatusOK, machine)
 }
 
@@ -186,7 +262,7 @@ func (h *MachineHandler) DeleteMachine(c echo.Context) error {
 
 	// 检查机器是否存在
 	var machine models.Machine
-	if err := db.Where("id = ?", id).First(&machine).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&machine).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Machine not found",
 		})
@@ -199,9 +275,45 @@ func (h *MachineHandler) DeleteMachine(c echo.Context) error {
 		})
 	}
 
+	recordAudit(c, "machine.delete", machine.ID, machine, nil)
 	return c.NoContent(http.StatusNoContent)
 }
 
+// ApproveReenroll 批准已注册机器重新注册
+// POST /api/v1/machines/:id/reenroll
+//
+// 机器更换了地址（不再持有原DHCP租约或登记地址）时，iPXE只有在批准期内才为其签发引导令牌；
+// 批准期内完成注册会轮换凭据，此前签发的Agent令牌全部失效
+func (h *MachineHandler) ApproveReenroll(c echo.Context) error {
+	db := database.GetDB()
+	id := c.Param("id")
+
+	var machine models.Machine
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&machine).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Machine not found",
+		})
+	}
+	if h.authority == nil || !h.authority.Enrolled(machine.ID) {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": "Machine has not enrolled yet",
+		})
+	}
+
+	until, err := h.authority.ApproveReenroll(machine.ID, agentauth.BootstrapTTL)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to approve re-enrollment",
+		})
+	}
+
+	recordAudit(c, "machine.reenroll", machine.ID, nil, map[string]interface{}{"reenroll_until": until})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"machine_id":     machine.ID,
+		"reenroll_until": until,
+	})
+}
+
 // ProvisionMachine 触发安装任务
 // POST /api/v1/machines/:id/provision
 func (h *MachineHandler) ProvisionMachine(c echo.Context) error {
@@ -210,7 +322,7 @@ func (h *MachineHandler) ProvisionMachine(c echo.Context) error {
 
 	// 检查机器是否存在
 	var machine models.Machine
-	if err := db.Where("id = ?", machineID).First(&machine).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", machineID).First(&machine).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Machine not found",
 		})
@@ -219,7 +331,10 @@ func (h *MachineHandler) ProvisionMachine(c echo.Context) error {
 	// 解析请求
 	var req struct {
 		ProfileID string                 `json:"profile_id" validate:"required"`
+		Workflow  string                 `json:"workflow"` // 留空则仅安装OS；provision 走完整装机流程
 		Config    map[string]interface{} `json:"config"`
+		// ProviderVersions 本任务固定使用的Provider版本（provider_id → version）
+		ProviderVersions map[string]string `json:"provider_versions"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -228,28 +343,132 @@ func (h *MachineHandler) ProvisionMachine(c echo.Context) error {
 		})
 	}
 
+	if !machine.Status.CanTransitionTo(models.MachineStatusInstalling) {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": fmt.Sprintf("Machine in status %s cannot be provisioned", machine.Status),
+		})
+	}
+
+	// RAID配置参数（工作流 config_raid 步骤）需要 advanced_raid 授权
+	if _, ok := req.Config["raid"]; ok && h.licenses != nil && !h.licenses.HasFeature(models.FeatureAdvancedRAID) {
+		return featureNotLicensed(c, models.FeatureAdvancedRAID)
+	}
+
+	// 启用多租户时配置模板必须与机器属于同一租户
+	if CurrentScope(c).Enabled {
+		var count int64
+		db.Model(&models.OSProfile{}).Where("id = ? AND tenant_id = ?", req.ProfileID, machine.TenantID).Count(&count)
+		if count == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "Profile not found in the machine's tenant",
+			})
+		}
+	}
+	if err := tenant.CheckQuota(db, machine.TenantID, tenant.ResourceActiveJobs, 1); err != nil {
+		return quotaError(c, err)
+	}
+
+	var pipeline *workflow.Pipeline
+	if req.Workflow != "" {
+		p, ok := workflow.Lookup(req.Workflow)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": fmt.Sprintf("Unknown workflow: %s", req.Workflow),
+			})
+		}
+		pipeline = &p
+	}
+
 	// 创建Job任务
 	job := models.Job{
-		ID:          uuid.New().String(),
-		MachineID:   machineID,
-		Type:        models.JobTypeInstallOS,
-		Status:      models.JobStatusPending,
-		ProfileID:   req.ProfileID,
-		StepCurrent: "pending",
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		ID:               uuid.New().String(),
+		MachineID:        machineID,
+		Type:             models.JobTypeInstallOS,
+		Status:           models.JobStatusPending,
+		TenantID:         machine.TenantID,
+		ProfileID:        req.ProfileID,
+		StepCurrent:      "pending",
+		Params:           req.Config,
+		ProviderVersions: req.ProviderVersions,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if pipeline != nil {
+		job.Type = models.JobType(pipeline.Name)
 	}
 
-	if err := db.Create(&job).Error; err != nil {
+	// 创建任务并迁移机器状态（同一事务）
+	fromStatus := machine.Status
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if pipeline != nil {
+			if err := workflow.Create(tx, &job, *pipeline); err != nil {
+				return err
+			}
+		} else if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		return lifecycle.Transition(tx, &machine, models.MachineStatusInstalling, lifecycle.Cause{
+			Actor:  lifecycle.ActorUser,
+			JobID:  job.ID,
+			Reason: "provision requested",
+		})
+	})
+	if err != nil {
+		if isTransitionError(err) {
+			return transitionError(c, err)
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error": "Failed to create job",
 		})
 	}
 
-	// 更新机器状态
-	machine.Status = models.MachineStatusInstalling
-	machine.UpdatedAt = time.Now()
-	db.Save(&machine)
-
+	recordAudit(c, "machine.provision", machine.ID,
+		map[string]interface{}{"status": fromStatus},
+		map[string]interface{}{"status": machine.Status, "job_id": job.ID, "profile_id": job.ProfileID, "workflow": req.Workflow})
 	return c.JSON(http.StatusAccepted, job)
 }
+
+// ListMachineEvents 查询机器状态迁移记录
+// GET /api/v1/machines/:id/events
+func (h *MachineHandler) ListMachineEvents(c echo.Context) error {
+	db := database.GetDB()
+	id := c.Param("id")
+
+	var machine models.Machine
+	if err := CurrentScope(c).Apply(db).Where("id = ?", id).First(&machine).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Machine not found",
+		})
+	}
+
+	events, err := lifecycle.History(db, machine.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to query machine events",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": events,
+	})
+}
+
+// isTransitionError 是否为状态机拒绝的迁移（非法迁移、前置条件不满足或并发修改）
+func isTransitionError(err error) bool {
+	return errors.Is(err, lifecycle.ErrInvalidTransition) ||
+		errors.Is(err, lifecycle.ErrGuardRejected) ||
+		errors.Is(err, lifecycle.ErrConflict)
+}
+
+// transitionError 将状态机错误转换为 409 响应
+func transitionError(c echo.Context, err error) error {
+	if isTransitionError(err) {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":   "Invalid status transition",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error": "Failed to update machine status",
+	})
+}
@@ -26,6 +26,20 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		&models.Machine{},
 		&models.Job{},
 		&models.OSProfile{},
+		&models.License{},
+		&models.MachineEvent{},
+		&models.JobStep{},
+		&models.Overlay{},
+		&models.User{},
+		&models.Role{},
+		&models.APIToken{},
+		&models.Session{},
+		&models.AgentBootstrapToken{},
+		&models.AgentCredential{},
+		&models.AuditEvent{},
+		&models.Tenant{},
+		&models.ProviderTenant{},
+		&models.ProviderVersion{},
 	); err != nil {
 		t.Fatalf("Failed to migrate tables: %v", err)
 	}
@@ -365,6 +379,12 @@ func TestMachineHandler_ProvisionMachine(t *testing.T) {
 		Status:     models.MachineStatusReady,
 	}
 	db.Create(&machine)
+	db.Create(&models.Machine{
+		ID:         "discovered-machine-id",
+		Hostname:   "new-server",
+		MacAddress: "aa:bb:cc:dd:ee:01",
+		Status:     models.MachineStatusDiscovered,
+	})
 
 	tests := []struct {
 		name           string
@@ -390,6 +410,12 @@ func TestMachineHandler_ProvisionMachine(t *testing.T) {
 			requestBody:    `{invalid}`,
 			wantStatusCode: http.StatusBadRequest,
 		},
+		{
+			name:           "Provision machine not yet ready",
+			machineID:      "discovered-machine-id",
+			requestBody:    `{"profile_id":"profile-123"}`,
+			wantStatusCode: http.StatusConflict,
+		},
 	}
 
 	for _, tt := range tests {
@@ -434,3 +460,45 @@ func TestMachineHandler_ProvisionMachine(t *testing.T) {
 		})
 	}
 }
+
+func TestMachineHandler_ApproveReenroll(t *testing.T) {
+	db := setupTestDB(t)
+	authority := newTestAuthority()
+	handler := NewMachineHandler()
+	handler.SetAgentAuthority(authority)
+
+	enrolled := models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady}
+	db.Create(&enrolled)
+	authority.Issue(&enrolled, nil)
+	db.Create(&models.Machine{ID: "machine-2", Hostname: "web-02", MacAddress: "aa:bb:cc:dd:ee:02", Status: models.MachineStatusDiscovered})
+
+	tests := []struct {
+		name           string
+		machineID      string
+		wantStatusCode int
+	}{
+		{"Enrolled machine", "machine-1", http.StatusOK},
+		{"Not enrolled yet", "machine-2", http.StatusConflict},
+		{"Machine not found", "missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/v1/machines/"+tt.machineID+"/reenroll", nil), rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.machineID)
+
+			if err := handler.ApproveReenroll(c); err != nil {
+				t.Fatalf("ApproveReenroll() error = %v", err)
+			}
+			if rec.Code != tt.wantStatusCode {
+				t.Errorf("Status = %v, want %v", rec.Code, tt.wantStatusCode)
+			}
+			if approved := authority.ReenrollApproved(tt.machineID); approved != (tt.wantStatusCode == http.StatusOK) {
+				t.Errorf("ReenrollApproved() = %v", approved)
+			}
+		})
+	}
+}
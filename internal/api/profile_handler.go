@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/cloudboot/cloudboot-ng/internal/core/configgen"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/google/uuid"
@@ -13,12 +14,14 @@ import (
 
 // ProfileHandler Profile API处理器
 type ProfileHandler struct {
+	serverURL string
 	generator *configgen.Generator
 }
 
 // NewProfileHandler 创建ProfileHandler
-func NewProfileHandler() *ProfileHandler {
+func NewProfileHandler(serverURL string) *ProfileHandler {
 	return &ProfileHandler{
+		serverURL: serverURL,
 		generator: configgen.NewGenerator(),
 	}
 }
@@ -29,7 +32,7 @@ func (h *ProfileHandler) ListProfiles(c echo.Context) error {
 	db := database.GetDB()
 
 	var profiles []models.OSProfile
-	if err := db.Find(&profiles).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Find(&profiles).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error": "Failed to query profiles",
 		})
@@ -48,7 +51,7 @@ func (h *ProfileHandler) GetProfile(c echo.Context) error {
 	profileID := c.Param("id")
 
 	var profile models.OSProfile
-	if err := db.Where("id = ?", profileID).First(&profile).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", profileID).First(&profile).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Profile not found",
 		})
@@ -82,6 +85,12 @@ func (h *ProfileHandler) CreateProfile(c echo.Context) error {
 		req.ID = uuid.New().String()
 	}
 
+	// 归属当前租户并检查配额
+	req.TenantID = CurrentScope(c).Assign()
+	if err := tenant.CheckQuota(db, req.TenantID, tenant.ResourceProfiles, 1); err != nil {
+		return quotaError(c, err)
+	}
+
 	// 设置时间戳
 	now := time.Now()
 	req.CreatedAt = now
@@ -94,6 +103,7 @@ func (h *ProfileHandler) CreateProfile(c echo.Context) error {
 		})
 	}
 
+	recordAudit(c, "profile.create", req.ID, nil, req)
 	return c.JSON(http.StatusCreated, req)
 }
 
@@ -105,7 +115,7 @@ func (h *ProfileHandler) UpdateProfile(c echo.Context) error {
 
 	// 查询现有Profile
 	var profile models.OSProfile
-	if err := db.Where("id = ?", profileID).First(&profile).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", profileID).First(&profile).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Profile not found",
 		})
@@ -127,8 +137,9 @@ func (h *ProfileHandler) UpdateProfile(c echo.Context) error {
 		})
 	}
 
-	// 保留ID和CreatedAt
+	// 保留ID、租户和CreatedAt
 	req.ID = profile.ID
+	req.TenantID = profile.TenantID
 	req.CreatedAt = profile.CreatedAt
 	req.UpdatedAt = time.Now()
 
@@ -139,6 +150,7 @@ func (h *ProfileHandler) UpdateProfile(c echo.Context) error {
 		})
 	}
 
+	recordAudit(c, "profile.update", req.ID, profile, req)
 	return c.JSON(http.StatusOK, req)
 }
 
@@ -150,7 +162,7 @@ func (h *ProfileHandler) DeleteProfile(c echo.Context) error {
 
 	// 查询Profile
 	var profile models.OSProfile
-	if err := db.Where("id = ?", profileID).First(&profile).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", profileID).First(&profile).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Profile not found",
 		})
@@ -163,6 +175,7 @@ func (h *ProfileHandler) DeleteProfile(c echo.Context) error {
 		})
 	}
 
+	recordAudit(c, "profile.delete", profile.ID, profile, nil)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"status":  "ok",
 		"message": "Profile deleted successfully",
@@ -170,34 +183,25 @@ func (h *ProfileHandler) DeleteProfile(c echo.Context) error {
 }
 
 // PreviewConfig 预览生成的OS安装配置
-// POST /api/v1/profiles/:id/preview
+// POST /api/v1/profiles/:id/preview[?machine_id=xxx]
+// 指定 machine_id 时使用该机器的主机名、MAC、IP、序列号渲染，与安装器实际拉取的内容一致
 func (h *ProfileHandler) PreviewConfig(c echo.Context) error {
 	db := database.GetDB()
 	profileID := c.Param("id")
 
 	// 查询Profile
 	var profile models.OSProfile
-	if err := db.Where("id = ?", profileID).First(&profile).Error; err != nil {
+	if err := CurrentScope(c).Apply(db).Where("id = ?", profileID).First(&profile).Error; err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Profile not found",
 		})
 	}
 
-	// 生成配置
-	config, err := h.generator.Generate(&profile)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error":   "Failed to generate config",
-			"details": err.Error(),
-		})
-	}
-
-	// 返回纯文本配置
-	return c.String(http.StatusOK, config)
+	return h.renderPreview(c, &profile)
 }
 
 // PreviewFromPayload 从请求体预览配置（不保存）
-// POST /api/v1/profiles/preview
+// POST /api/v1/profiles/preview[?machine_id=xxx]
 func (h *ProfileHandler) PreviewFromPayload(c echo.Context) error {
 	var req models.OSProfile
 	if err := c.Bind(&req); err != nil {
@@ -214,8 +218,23 @@ func (h *ProfileHandler) PreviewFromPayload(c echo.Context) error {
 		})
 	}
 
+	return h.renderPreview(c, &req)
+}
+
+// renderPreview 渲染预览，machine_id 查询参数可选
+func (h *ProfileHandler) renderPreview(c echo.Context, profile *models.OSProfile) error {
+	var machine *models.Machine
+	if machineID := c.QueryParam("machine_id"); machineID != "" {
+		machine = &models.Machine{}
+		if err := CurrentScope(c).Apply(database.GetDB()).Where("id = ?", machineID).First(machine).Error; err != nil {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error": "Machine not found",
+			})
+		}
+	}
+
 	// 生成配置
-	config, err := h.generator.Generate(&req)
+	rendered, err := h.generator.Render(profile, configgen.NewMachineContext(machine, h.serverURL))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error":   "Failed to generate config",
@@ -224,5 +243,5 @@ func (h *ProfileHandler) PreviewFromPayload(c echo.Context) error {
 	}
 
 	// 返回纯文本配置
-	return c.String(http.StatusOK, config)
+	return c.String(http.StatusOK, rendered.Content)
 }
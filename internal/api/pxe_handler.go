@@ -2,9 +2,14 @@ package api
 
 import (
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/cloudboot/cloudboot-ng/internal/core/agentauth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/configgen"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/labstack/echo/v4"
@@ -13,15 +18,25 @@ import (
 // PXEHandler PXE/iPXE启动处理器
 type PXEHandler struct {
 	serverURL string
+	generator *configgen.Generator
+	authority *agentauth.Authority
+	binding   bootBinding
 }
 
 // NewPXEHandler 创建PXE处理器
-func NewPXEHandler(serverURL string) *PXEHandler {
+func NewPXEHandler(serverURL string, authority *agentauth.Authority) *PXEHandler {
 	return &PXEHandler{
 		serverURL: serverURL,
+		generator: configgen.NewGenerator(),
+		authority: authority,
 	}
 }
 
+// SetLeases 设置DHCP地址查询，签发引导令牌时校验请求方持有该MAC的租约
+func (h *PXEHandler) SetLeases(leases LeaseSource) {
+	h.binding.leases = leases
+}
+
 // iPXEBootScript iPXE启动脚本数据
 type iPXEBootScript struct {
 	ServerURL  string
@@ -30,15 +45,18 @@ type iPXEBootScript struct {
 	Hostname   string
 	BootMode   string // "discovery", "install", "localboot"
 	OSProfile  *OSProfileData
+	// BootstrapToken Discovery模式下通过内核参数 cloudboot.token 交给Agent的一次性引导令牌
+	BootstrapToken string
 }
 
 // OSProfileData OS配置数据
 type OSProfileData struct {
-	Distro    string
-	Version   string
-	KernelURL string
-	InitrdURL string
-	RepoURL   string
+	Distro     string
+	Version    string
+	KernelURL  string
+	InitrdURL  string
+	RepoURL    string
+	KernelArgs string // 安装器内核参数（含配置文件URL），为空表示发行版不受支持
 }
 
 // ServeiPXEScript 提供iPXE启动脚本
@@ -49,8 +67,11 @@ func (h *PXEHandler) ServeiPXEScript(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "#!ipxe\necho Error: MAC address required\n")
 	}
 
-	// 规范化MAC地址格式
-	macAddr = normalizeMACAddress(macAddr)
+	// 规范化并校验MAC地址格式（校验通过前不签发引导令牌）
+	macAddr, ok := parseMACAddress(macAddr)
+	if !ok {
+		return c.String(http.StatusBadRequest, "#!ipxe\necho Error: invalid MAC address\n")
+	}
 
 	// 查找机器
 	var machine models.Machine
@@ -71,36 +92,49 @@ func (h *PXEHandler) ServeiPXEScript(c echo.Context) error {
 		BootMode:   bootMode,
 	}
 
+	if bootMode == "discovery" {
+		bound, err := h.binding.check(c, macAddr, machine.IPAddress)
+		if err != nil {
+			log.Printf("⚠️  机器 %s 的iPXE请求来自 %s，与DHCP租约不符", machine.ID, remoteIP(c))
+			return c.String(http.StatusForbidden, "#!ipxe\necho Error: request does not come from the leased address\n")
+		}
+		// 已注册的机器只为确认过的请求方签发引导令牌，地址变化时需运维批准重新注册
+		if !bound && h.authority.ReenrollApproved(machine.ID) {
+			bound = true
+		}
+		if !bound && h.authority.Enrolled(machine.ID) {
+			log.Printf("⚠️  机器 %s 的iPXE请求来自未知地址 %s，需运维批准重新注册", machine.ID, remoteIP(c))
+			return c.String(http.StatusForbidden, "#!ipxe\necho Error: re-enrollment requires operator approval\n")
+		}
+		if scriptData.BootstrapToken, err = h.authority.IssueBootstrap(macAddr, remoteIP(c), bound); err != nil {
+			log.Printf("⚠️  机器 %s 引导令牌签发失败: %v", machine.ID, err)
+			return c.String(http.StatusInternalServerError, "#!ipxe\necho Error: failed to issue bootstrap token\n")
+		}
+	}
+
 	// 如果是安装模式，加载OS配置
 	if bootMode == "install" {
 		// 查询待执行的安装任务
-		var job models.Job
-		err := database.DB.Where("machine_id = ? AND type = ? AND status = ?",
-			machine.ID, "install_os", models.JobStatusPending).First(&job).Error
-
+		job, err := workflow.FindInstallJob(database.DB, machine.ID)
 		if err == nil && job.ProfileID != "" {
 			// 加载OS Profile
 			var profile models.OSProfile
 			if err := database.DB.First(&profile, "id = ?", job.ProfileID).Error; err == nil {
-				scriptData.OSProfile = h.buildOSProfileData(&profile)
+				scriptData.OSProfile = h.buildOSProfileData(&profile, &machine)
 			}
 		}
 	}
 
-	// 渲染iPXE脚本模板
+	// 渲染iPXE脚本模板（含一次性引导令牌，禁止缓存）
 	c.Response().Header().Set(echo.HeaderContentType, "text/plain; charset=utf-8")
+	c.Response().Header().Set("Cache-Control", "no-store")
 	return c.Render(http.StatusOK, "ipxe.tmpl", scriptData)
 }
 
 // determineBootMode 确定启动模式
 func (h *PXEHandler) determineBootMode(machine *models.Machine) string {
 	// 检查是否有待执行的安装任务
-	var installJob models.Job
-	err := database.DB.Where("machine_id = ? AND type = ? AND status IN (?)",
-		machine.ID, "install_os", []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}).
-		First(&installJob).Error
-
-	if err == nil {
+	if _, err := workflow.FindInstallJob(database.DB, machine.ID); err == nil {
 		return "install"
 	}
 
@@ -119,58 +153,63 @@ func (h *PXEHandler) determineBootMode(machine *models.Machine) string {
 }
 
 // renderDiscoveryMode 渲染Discovery模式（未注册机器）
+// 首次注册不需要运维批准，但有DHCP租约时请求须来自租约地址
 func (h *PXEHandler) renderDiscoveryMode(c echo.Context, macAddr string) error {
+	bound, err := h.binding.check(c, macAddr, "")
+	if err != nil {
+		log.Printf("⚠️  MAC %s 的iPXE请求来自 %s，与DHCP租约不符", macAddr, remoteIP(c))
+		return c.String(http.StatusForbidden, "#!ipxe\necho Error: request does not come from the leased address\n")
+	}
+	token, err := h.authority.IssueBootstrap(macAddr, remoteIP(c), bound)
+	if err != nil {
+		log.Printf("⚠️  MAC %s 引导令牌签发失败: %v", macAddr, err)
+		return c.String(http.StatusInternalServerError, "#!ipxe\necho Error: failed to issue bootstrap token\n")
+	}
+
 	scriptData := iPXEBootScript{
-		ServerURL:  h.serverURL,
-		MachineID:  "unknown",
-		MacAddress: macAddr,
-		Hostname:   "unknown-" + macAddr[len(macAddr)-8:],
-		BootMode:   "discovery",
+		ServerURL:      h.serverURL,
+		MachineID:      "unknown",
+		MacAddress:     macAddr,
+		Hostname:       "unknown-" + macAddr[len(macAddr)-8:],
+		BootMode:       "discovery",
+		BootstrapToken: token,
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, "text/plain; charset=utf-8")
+	c.Response().Header().Set("Cache-Control", "no-store")
 	return c.Render(http.StatusOK, "ipxe.tmpl", scriptData)
 }
 
 // buildOSProfileData 构建OS配置数据
-func (h *PXEHandler) buildOSProfileData(profile *models.OSProfile) *OSProfileData {
+// 内核、initrd及内核参数由 configgen 发行版注册表统一计算
+func (h *PXEHandler) buildOSProfileData(profile *models.OSProfile, machine *models.Machine) *OSProfileData {
 	data := &OSProfileData{
 		Distro:  profile.Distro,
 		Version: profile.Version,
 	}
 
-	// 根据发行版设置默认URL
-	switch profile.Distro {
-	case "centos7":
-		data.KernelURL = fmt.Sprintf("%s/images/centos7/vmlinuz", h.serverURL)
-		data.InitrdURL = fmt.Sprintf("%s/images/centos7/initrd.img", h.serverURL)
-		data.RepoURL = "http://mirror.centos.org/centos/7/os/x86_64/"
-
-	case "ubuntu22":
-		data.KernelURL = fmt.Sprintf("%s/images/ubuntu22/vmlinuz", h.serverURL)
-		data.InitrdURL = fmt.Sprintf("%s/images/ubuntu22/initrd", h.serverURL)
-		data.RepoURL = "http://archive.ubuntu.com/ubuntu/dists/jammy/main/installer-amd64/"
-
-	case "rocky8":
-		data.KernelURL = fmt.Sprintf("%s/images/rocky8/vmlinuz", h.serverURL)
-		data.InitrdURL = fmt.Sprintf("%s/images/rocky8/initrd.img", h.serverURL)
-		data.RepoURL = "https://download.rockylinux.org/pub/rocky/8/BaseOS/x86_64/os/"
-
-	case "suse15":
-		data.KernelURL = fmt.Sprintf("%s/images/suse15/linux", h.serverURL)
-		data.InitrdURL = fmt.Sprintf("%s/images/suse15/initrd", h.serverURL)
-		data.RepoURL = "https://download.opensuse.org/distribution/leap/15.5/repo/oss/"
-
-	default:
-		// 使用profile中的自定义URL（如果有）
-		data.KernelURL = fmt.Sprintf("%s/images/%s/vmlinuz", h.serverURL, profile.Distro)
-		data.InitrdURL = fmt.Sprintf("%s/images/%s/initrd.img", h.serverURL, profile.Distro)
-		data.RepoURL = ""
+	params, err := h.generator.BootParams(profile, configgen.NewMachineContext(machine, h.serverURL))
+	if err != nil {
+		return data
 	}
 
+	data.KernelURL = params.KernelURL
+	data.InitrdURL = params.InitrdURL
+	data.RepoURL = params.RepoURL
+	data.KernelArgs = params.KernelArgs
 	return data
 }
 
+// parseMACAddress 校验并规范化MAC地址（aa:bb:cc:dd:ee:ff），格式无效时返回false
+// 机器以规范化后的MAC存储和查询，避免大小写或分隔符不同产生重复机器
+func parseMACAddress(mac string) (string, bool) {
+	hw, err := net.ParseMAC(normalizeMACAddress(mac))
+	if err != nil || len(hw) != 6 {
+		return "", false
+	}
+	return hw.String(), true
+}
+
 // normalizeMACAddress 规范化MAC地址格式
 func normalizeMACAddress(mac string) string {
 	// 移除所有分隔符
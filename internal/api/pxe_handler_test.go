@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/renderer"
+	"github.com/labstack/echo/v4"
+)
+
+// fakeLeases 测试用DHCP租约（MAC → IP）
+type fakeLeases map[string]string
+
+func (f fakeLeases) LeaseIP(mac string) (net.IP, bool) {
+	ip, ok := f[mac]
+	return net.ParseIP(ip), ok
+}
+
+func TestPXEHandler_BootstrapToken(t *testing.T) {
+	db := setupTestDB(t)
+	authority := newTestAuthority()
+	handler := NewPXEHandler("http://10.0.0.10:8080", authority)
+	handler.SetLeases(fakeLeases{
+		"aa:bb:cc:dd:ee:08": "10.0.0.8",
+		"aa:bb:cc:dd:ee:05": testRemoteIP,
+	})
+
+	templates, err := renderer.NewTemplateRenderer("../../web/templates")
+	if err != nil {
+		t.Fatalf("NewTemplateRenderer() error = %v", err)
+	}
+	machines := []models.Machine{
+		{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusReady},
+		{ID: "machine-2", Hostname: "web-02", MacAddress: "aa:bb:cc:dd:ee:02", Status: models.MachineStatusActive},
+		{ID: "machine-3", Hostname: "web-03", MacAddress: "aa:bb:cc:dd:ee:03", IPAddress: "10.0.0.3", Status: models.MachineStatusReady},
+		{ID: "machine-4", Hostname: "web-04", MacAddress: "aa:bb:cc:dd:ee:04", IPAddress: testRemoteIP, Status: models.MachineStatusReady},
+		{ID: "machine-5", Hostname: "web-05", MacAddress: "aa:bb:cc:dd:ee:05", Status: models.MachineStatusReady},
+		{ID: "machine-6", Hostname: "web-06", MacAddress: "aa:bb:cc:dd:ee:06", IPAddress: "10.0.0.6", Status: models.MachineStatusReady},
+	}
+	for i := range machines {
+		db.Create(&machines[i])
+		if i >= 2 {
+			authority.Issue(&machines[i], nil) // 已注册
+		}
+	}
+	authority.ApproveReenroll("machine-6", time.Hour)
+
+	tokenParam := regexp.MustCompile(`cloudboot\.token=(\S+)`)
+
+	tests := []struct {
+		name       string
+		mac        string
+		wantStatus int
+		wantToken  bool
+		wantBound  bool
+	}{
+		{"Unknown machine", "AA-BB-CC-DD-EE-09", http.StatusOK, true, false},
+		{"Unknown machine from another leased address", "aa:bb:cc:dd:ee:08", http.StatusForbidden, false, false},
+		{"Known machine in discovery", "aa:bb:cc:dd:ee:01", http.StatusOK, true, false},
+		{"Local boot", "aa:bb:cc:dd:ee:02", http.StatusOK, false, false},
+		{"Enrolled machine from unknown address", "aa:bb:cc:dd:ee:03", http.StatusForbidden, false, false},
+		{"Enrolled machine from its address", "aa:bb:cc:dd:ee:04", http.StatusOK, true, true},
+		{"Enrolled machine from its lease", "aa:bb:cc:dd:ee:05", http.StatusOK, true, true},
+		{"Enrolled machine with re-enrollment approved", "aa:bb:cc:dd:ee:06", http.StatusOK, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Renderer = templates
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/boot/ipxe/"+tt.mac, nil), rec)
+			c.SetParamNames("mac")
+			c.SetParamValues(tt.mac)
+
+			if err := handler.ServeiPXEScript(c); err != nil {
+				t.Fatalf("ServeiPXEScript() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %v, want %v:\n%s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK && rec.Header().Get("Cache-Control") != "no-store" {
+				t.Fatalf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+			}
+
+			match := tokenParam.FindStringSubmatch(rec.Body.String())
+			if !tt.wantToken {
+				if match != nil {
+					t.Errorf("unexpected bootstrap token in script")
+				}
+				return
+			}
+			if match == nil {
+				t.Fatalf("script has no cloudboot.token:\n%s", rec.Body.String())
+			}
+			if _, err := authority.ConsumeBootstrap(match[1], normalizeMACAddress(tt.mac), "10.0.0.99"); err == nil {
+				t.Errorf("bootstrap token accepted from another address")
+			}
+			bootstrap, err := authority.ConsumeBootstrap(match[1], normalizeMACAddress(tt.mac), testRemoteIP)
+			if err != nil {
+				t.Fatalf("ConsumeBootstrap() error = %v", err)
+			}
+			if bootstrap.Bound != tt.wantBound {
+				t.Errorf("Bound = %v, want %v", bootstrap.Bound, tt.wantBound)
+			}
+		})
+	}
+}
+
+func TestPXEHandler_InvalidMAC(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewPXEHandler("http://10.0.0.10:8080", newTestAuthority())
+
+	for _, mac := range []string{"x", "aa:bb", "not-a-mac-address", "aa:bb:cc:dd:ee:ff:00:11", "zz:bb:cc:dd:ee:ff"} {
+		t.Run(mac, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			c.SetParamNames("mac")
+			c.SetParamValues(mac)
+
+			if err := handler.ServeiPXEScript(c); err != nil {
+				t.Fatalf("ServeiPXEScript() error = %v", err)
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Status = %v, want %v", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	var tokens int64
+	db.Model(&models.AgentBootstrapToken{}).Count(&tokens)
+	if tokens != 0 {
+		t.Errorf("%d bootstrap tokens issued for invalid MACs", tokens)
+	}
+}
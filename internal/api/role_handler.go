@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// RoleHandler 角色管理API处理器（需要 role:manage 权限）
+type RoleHandler struct {
+	svc *auth.Service
+}
+
+// NewRoleHandler 创建RoleHandler
+func NewRoleHandler(svc *auth.Service) *RoleHandler {
+	return &RoleHandler{
+		svc: svc,
+	}
+}
+
+// ListRoles 获取角色列表
+// GET /api/v1/roles
+func (h *RoleHandler) ListRoles(c echo.Context) error {
+	var roles []models.Role
+	if err := database.GetDB().Order("built_in DESC, name").Find(&roles).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to fetch roles",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": roles,
+		"total": len(roles),
+	})
+}
+
+// ListPermissions 获取全部可分配的权限
+// GET /api/v1/permissions
+func (h *RoleHandler) ListPermissions(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": auth.Permissions,
+		"total": len(auth.Permissions),
+	})
+}
+
+// CreateRole 创建自定义角色
+// POST /api/v1/roles
+func (h *RoleHandler) CreateRole(c echo.Context) error {
+	var req struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "name is required",
+		})
+	}
+	if err := auth.ValidatePermissions(req.Permissions); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	db := database.GetDB()
+	var count int64
+	db.Model(&models.Role{}).Where("name = ?", req.Name).Count(&count)
+	if count > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": "Role already exists",
+		})
+	}
+
+	role := models.Role{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	if err := db.Create(&role).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to create role",
+		})
+	}
+
+	recordAudit(c, "role.create", role.Name, nil, role)
+	return c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole 更新自定义角色的描述或权限
+// PUT /api/v1/roles/:name
+func (h *RoleHandler) UpdateRole(c echo.Context) error {
+	role, status, msg := findMutableRole(c.Param("name"))
+	if role == nil {
+		return c.JSON(status, map[string]interface{}{
+			"error": msg,
+		})
+	}
+
+	before := audit.Snapshot(role)
+
+	var req struct {
+		Description *string   `json:"description"`
+		Permissions *[]string `json:"permissions"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		if err := auth.ValidatePermissions(*req.Permissions); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		role.Permissions = *req.Permissions
+	}
+
+	if err := database.GetDB().Save(role).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to update role",
+		})
+	}
+
+	recordAudit(c, "role.update", role.Name, before, role)
+	return c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole 删除自定义角色（仍分配给用户时拒绝）
+// DELETE /api/v1/roles/:name
+func (h *RoleHandler) DeleteRole(c echo.Context) error {
+	role, status, msg := findMutableRole(c.Param("name"))
+	if role == nil {
+		return c.JSON(status, map[string]interface{}{
+			"error": msg,
+		})
+	}
+
+	inUse, err := h.svc.RoleInUse(role.Name)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to check role usage",
+		})
+	}
+	if inUse {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": "Role is assigned to users",
+		})
+	}
+
+	if err := database.GetDB().Delete(role).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to delete role",
+		})
+	}
+
+	recordAudit(c, "role.delete", role.Name, role, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// findMutableRole 查找可修改的角色，不存在或为内置角色时返回错误状态码和信息
+func findMutableRole(name string) (*models.Role, int, string) {
+	var role models.Role
+	if err := database.GetDB().Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, http.StatusNotFound, "Role not found"
+	}
+	if role.BuiltIn {
+		return nil, http.StatusForbidden, "Built-in roles cannot be modified"
+	}
+	return &role, 0, ""
+}
@@ -23,99 +23,35 @@ func NewSSEHandler(broker *logbroker.Broker) *SSEHandler {
 // StreamLogs SSE日志流端点
 // GET /api/stream/logs/:job_id
 func (h *SSEHandler) StreamLogs(c echo.Context) error {
-	jobID := c.Param("job_id")
-	if jobID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "job_id is required",
-		})
-	}
-
-	// 设置SSE响应头
-	c.Response().Header().Set("Content-Type", "text/event-stream")
-	c.Response().Header().Set("Cache-Control", "no-cache")
-	c.Response().Header().Set("Connection", "keep-alive")
-	c.Response().Header().Set("X-Accel-Buffering", "no") // Nginx禁用缓冲
-
-	// 订阅日志流
-	logChan := h.broker.Subscribe(jobID)
-	defer func() {
-		// 客户端断开时取消订阅
-		h.broker.Unsubscribe(jobID, logChan)
-	}()
-
-	// 发送初始连接成功事件
-	fmt.Fprintf(c.Response(), "event: connected\ndata: {\"job_id\":\"%s\",\"status\":\"streaming\"}\n\n", jobID)
-	c.Response().Flush()
-
-	// 持续推送日志
-	for {
-		select {
-		case msg, ok := <-logChan:
-			if !ok {
-				// Channel关闭，结束流
-				return nil
-			}
-
-			// 发送日志事件 (HTML格式)
-			// 使用 "message" 事件名以匹配 HTMX sse-swap="message"
-			htmlLog := msg.FormatAsHTML()
-			fmt.Fprintf(c.Response(), "event: message\ndata: %s\n\n", htmlLog)
-			c.Response().Flush()
-
-		case <-c.Request().Context().Done():
-			// 客户端断开连接
-			return nil
-		}
-	}
+	return h.stream(c, htmlLogFormat)
 }
 
 // StreamLogsJSON SSE日志流端点 (JSON格式)
 // GET /api/stream/logs/:job_id/json
 func (h *SSEHandler) StreamLogsJSON(c echo.Context) error {
+	return h.stream(c, jsonLogFormat)
+}
+
+func (h *SSEHandler) stream(c echo.Context, format logFormat) error {
 	jobID := c.Param("job_id")
 	if jobID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "job_id is required",
 		})
 	}
+	if !jobInScope(c, jobID) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+	}
 
 	// 设置SSE响应头
-	c.Response().Header().Set("Content-Type", "text/event-stream")
-	c.Response().Header().Set("Cache-Control", "no-cache")
-	c.Response().Header().Set("Connection", "keep-alive")
-	c.Response().Header().Set("X-Accel-Buffering", "no")
-
-	// 订阅日志流
-	logChan := h.broker.Subscribe(jobID)
-	defer func() {
-		h.broker.Unsubscribe(jobID, logChan)
-	}()
+	setSSEHeaders(c)
 
-	// 发送初始连接事件
-	fmt.Fprintf(c.Response(), "event: connected\ndata: {\"job_id\":\"%s\",\"status\":\"streaming\"}\n\n", jobID)
+	// 发送初始连接成功事件
+	fmt.Fprintf(c.Response(), "event: connected\ndata: {\"job_id\":%q,\"status\":\"streaming\"}\n\n", jobID)
 	c.Response().Flush()
 
-	// 持续推送日志 (JSON格式)
-	for {
-		select {
-		case msg, ok := <-logChan:
-			if !ok {
-				return nil
-			}
-
-			// 发送JSON格式的日志
-			jsonData := fmt.Sprintf(
-				`{"ts":"%s","level":"%s","component":"%s","msg":"%s"}`,
-				msg.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
-				msg.Level,
-				msg.Component,
-				msg.Message,
-			)
-			fmt.Fprintf(c.Response(), "event: log\ndata: %s\n\n", jsonData)
-			c.Response().Flush()
-
-		case <-c.Request().Context().Done():
-			return nil
-		}
-	}
+	// 持续推送日志，客户端断开或任务结束时返回
+	return serveLogEvents(c, h.broker, jobID, format)
 }
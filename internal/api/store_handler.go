@@ -1,12 +1,16 @@
 package api
 
 import (
+	"errors"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 
 	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/labstack/echo/v4"
 )
 
@@ -68,26 +72,72 @@ func (h *StoreHandler) ImportProvider(c echo.Context) error {
 	}
 	tempFile.Close()
 
+	// 启用多租户时：不能覆盖范围外的同名Provider，新导入的Provider计入租户配额
+	scope := CurrentScope(c)
+	db := database.GetDB()
+	isNew := true
+	if scope.Enabled {
+		pkg, err := cspm.ParseCBP(tempPath)
+		if err != nil {
+			return importError(c, err)
+		}
+		if _, err := h.pluginManager.GetProvider(pkg.Manifest.ID); err == nil {
+			isNew = false
+			owner, err := tenant.ProviderOwner(db, pkg.Manifest.ID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+					"error": "Failed to check provider owner",
+				})
+			}
+			if !scope.All && owner != scope.TenantID {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error": "Provider belongs to another tenant or is shared",
+				})
+			}
+		} else if err := tenant.CheckQuota(db, scope.Assign(), tenant.ResourceProviders, 1); err != nil {
+			return quotaError(c, err)
+		}
+	}
+
 	// 导入到Plugin Manager
 	info, err := h.pluginManager.ImportProvider(tempPath)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error":   "Failed to import provider",
-			"details": err.Error(),
-		})
+		return importError(c, err)
+	}
+	if scope.Enabled && isNew {
+		if err := tenant.SetProviderOwner(db, info.ID, scope.Assign()); err != nil {
+			log.Printf("⚠️  Provider %s 租户归属保存失败: %v", info.ID, err)
+		}
 	}
 
+	recordAudit(c, "store.import", info.ID, nil, info)
+	message := "Provider imported successfully"
+	if !info.IsDefault {
+		message = "Provider version installed; upgrade to make it the default"
+	}
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"status":   "ok",
 		"provider": info,
-		"message":  "Provider imported successfully",
+		"message":  message,
+	})
+}
+
+// importError 包本身不合法（格式、签名、水印等）时返回400，其他错误返回500
+func importError(c echo.Context, err error) error {
+	status := http.StatusInternalServerError
+	if errors.Is(err, cspm.ErrInvalidPackage) || errors.Is(err, cspm.ErrInvalidPackageSignature) {
+		status = http.StatusBadRequest
+	}
+	return c.JSON(status, map[string]interface{}{
+		"error":   "Failed to import provider",
+		"details": err.Error(),
 	})
 }
 
 // ListProviders 查询已安装的Provider
 // GET /api/v1/store/providers
 func (h *StoreHandler) ListProviders(c echo.Context) error {
-	providers := h.pluginManager.ListProviders()
+	providers := visibleProviders(c, h.pluginManager.ListProviders())
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"providers": providers,
@@ -95,13 +145,13 @@ func (h *StoreHandler) ListProviders(c echo.Context) error {
 	})
 }
 
-// GetProvider 获取单个Provider详情
+// GetProvider 获取单个Provider详情（默认版本，或 ?version= 指定的版本）
 // GET /api/v1/store/providers/:id
 func (h *StoreHandler) GetProvider(c echo.Context) error {
 	providerID := c.Param("id")
 
-	provider, err := h.pluginManager.GetProvider(providerID)
-	if err != nil {
+	provider, err := h.pluginManager.GetProviderVersion(providerID, c.QueryParam("version"))
+	if err != nil || len(visibleProviders(c, []*cspm.ProviderInfo{provider})) == 0 {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{
 			"error": "Provider not found",
 		})
@@ -110,25 +160,233 @@ func (h *StoreHandler) GetProvider(c echo.Context) error {
 	return c.JSON(http.StatusOK, provider)
 }
 
+// GetProviderSchema 获取Provider的配置Schema和默认配置，用于渲染配置表单
+// GET /api/v1/store/providers/:id/schema?version=
+func (h *StoreHandler) GetProviderSchema(c echo.Context) error {
+	providerID := c.Param("id")
+
+	provider, err := h.pluginManager.GetProviderVersion(providerID, c.QueryParam("version"))
+	if err != nil || len(visibleProviders(c, []*cspm.ProviderInfo{provider})) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Provider not found",
+		})
+	}
+	if provider.Schema == nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Provider has no config schema",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"provider_id": provider.ID,
+		"version":     provider.Version,
+		"schema":      provider.Schema,
+		"defaults":    provider.Schema.GenerateDefaultConfig(),
+	})
+}
+
 // DeleteProvider 删除Provider
 // DELETE /api/v1/store/providers/:id
 func (h *StoreHandler) DeleteProvider(c echo.Context) error {
 	providerID := c.Param("id")
 
+	// 删除前保存Provider信息用于审计
+	provider, _ := h.pluginManager.GetProvider(providerID)
+
+	if ok, err := checkProviderOwner(c, providerID, provider != nil); !ok {
+		return err
+	}
+
 	if err := h.pluginManager.DeleteProvider(providerID); err != nil {
-		if err.Error() == "provider not found: "+providerID {
+		var pinned *cspm.PinnedError
+		if errors.Is(err, cspm.ErrProviderNotFound) {
 			return c.JSON(http.StatusNotFound, map[string]interface{}{
 				"error": "Provider not found",
 			})
 		}
+		if errors.As(err, &pinned) {
+			return c.JSON(http.StatusConflict, map[string]interface{}{
+				"error": err.Error(),
+				"pins":  pinned.Pins,
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error":   "Failed to delete provider",
 			"details": err.Error(),
 		})
 	}
 
+	if err := tenant.SetProviderOwner(database.GetDB(), providerID, ""); err != nil {
+		log.Printf("⚠️  Provider %s 租户归属清理失败: %v", providerID, err)
+	}
+
+	recordAudit(c, "store.delete", providerID, provider, nil)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"status":  "ok",
 		"message": "Provider deleted successfully",
 	})
 }
+
+// ListVersions 查询Provider已安装的全部版本（新版本在前）
+// GET /api/v1/store/providers/:id/versions
+func (h *StoreHandler) ListVersions(c echo.Context) error {
+	providerID := c.Param("id")
+
+	versions, err := h.pluginManager.ListVersions(providerID)
+	if err != nil || len(visibleProviders(c, versions[:1])) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Provider not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"versions": versions,
+		"total":    len(versions),
+	})
+}
+
+// switchVersionRequest 升级/回滚请求，version 为空时自动选择
+type switchVersionRequest struct {
+	Version string `json:"version"`
+}
+
+// UpgradeProvider 把默认版本切换到更新的已安装版本（未指定时为最新版本）
+// POST /api/v1/store/providers/:id/upgrade
+func (h *StoreHandler) UpgradeProvider(c echo.Context) error {
+	return h.switchVersion(c, "store.upgrade", h.pluginManager.Upgrade)
+}
+
+// RollbackProvider 把默认版本切换到更旧的已安装版本（未指定时为默认版本之前的最近版本）
+// POST /api/v1/store/providers/:id/rollback
+func (h *StoreHandler) RollbackProvider(c echo.Context) error {
+	return h.switchVersion(c, "store.rollback", h.pluginManager.Rollback)
+}
+
+func (h *StoreHandler) switchVersion(c echo.Context, action string, switchFn func(id, version string) (*cspm.ProviderInfo, *cspm.ProviderInfo, error)) error {
+	providerID := c.Param("id")
+
+	var req switchVersionRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	_, err := h.pluginManager.GetProvider(providerID)
+	if ok, err := checkProviderOwner(c, providerID, err == nil); !ok {
+		return err
+	}
+
+	previous, current, err := switchFn(providerID, req.Version)
+	if err != nil {
+		return versionError(c, err)
+	}
+
+	recordAudit(c, action, providerID, previous, current)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":   "ok",
+		"provider": current,
+	})
+}
+
+// DeleteVersion 删除Provider的单个版本（还有其他版本时不能删除默认版本，被固定使用的版本不能删除）
+// DELETE /api/v1/store/providers/:id/versions/:version
+func (h *StoreHandler) DeleteVersion(c echo.Context) error {
+	providerID := c.Param("id")
+	version := c.Param("version")
+
+	provider, err := h.pluginManager.GetProviderVersion(providerID, version)
+	if ok, err := checkProviderOwner(c, providerID, err == nil); !ok {
+		return err
+	}
+
+	if err := h.pluginManager.DeleteVersion(providerID, version); err != nil {
+		return versionError(c, err)
+	}
+
+	// 删除的是最后一个版本时清理租户归属
+	if _, err := h.pluginManager.GetProvider(providerID); errors.Is(err, cspm.ErrProviderNotFound) {
+		if err := tenant.SetProviderOwner(database.GetDB(), providerID, ""); err != nil {
+			log.Printf("⚠️  Provider %s 租户归属清理失败: %v", providerID, err)
+		}
+	}
+
+	recordAudit(c, "store.delete_version", providerID, provider, nil)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"message": "Provider version deleted successfully",
+	})
+}
+
+// versionError 把Provider版本操作的错误转换为响应
+func versionError(c echo.Context, err error) error {
+	var pinned *cspm.PinnedError
+	switch {
+	case errors.As(err, &pinned):
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": err.Error(),
+			"pins":  pinned.Pins,
+		})
+	case errors.Is(err, cspm.ErrProviderNotFound), errors.Is(err, cspm.ErrVersionNotFound):
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error":   "Provider version not found",
+			"details": err.Error(),
+		})
+	case errors.Is(err, cspm.ErrNoTargetVersion), errors.Is(err, cspm.ErrDefaultVersion):
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error":   "Failed to update provider version",
+		"details": err.Error(),
+	})
+}
+
+// checkProviderOwner 限定租户时只能修改本租户的Provider
+// 返回false时已写出响应（范围外的Provider按不存在处理，共享Provider需要跨租户管理员）
+func checkProviderOwner(c echo.Context, providerID string, exists bool) (bool, error) {
+	scope := CurrentScope(c)
+	if scope.All || !exists {
+		return true, nil
+	}
+
+	owner, err := tenant.ProviderOwner(database.GetDB(), providerID)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to check provider owner",
+		})
+	}
+	if !scope.ProviderVisible(owner) {
+		return false, c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Provider not found",
+		})
+	}
+	if owner != scope.TenantID {
+		return false, c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": "Shared providers can only be changed by cross-tenant admins",
+		})
+	}
+	return true, nil
+}
+
+// visibleProviders 过滤出租户范围内可见的Provider（共享Provider对所有租户可见）
+func visibleProviders(c echo.Context, providers []*cspm.ProviderInfo) []*cspm.ProviderInfo {
+	scope := CurrentScope(c)
+	if scope.All {
+		return providers
+	}
+
+	owners, err := tenant.ProviderOwners(database.GetDB())
+	if err != nil {
+		log.Printf("⚠️  Provider租户归属查询失败: %v", err)
+		return nil
+	}
+	visible := make([]*cspm.ProviderInfo, 0, len(providers))
+	for _, p := range providers {
+		if scope.ProviderVisible(owners[p.ID]) {
+			visible = append(visible, p)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/keystore"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestPluginManager 创建PluginManager并导入 raid-mock 的指定版本
+func newTestPluginManager(t *testing.T, versions ...string) *cspm.PluginManager {
+	t.Helper()
+	return newTestPluginManagerWithSchema(t, nil, versions...)
+}
+
+// newTestPluginManagerWithSchema 同 newTestPluginManager，包内带 meta/schema.json
+func newTestPluginManagerWithSchema(t *testing.T, schema []byte, versions ...string) *cspm.PluginManager {
+	t.Helper()
+	root := t.TempDir()
+	keys, err := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	key, _ := keys.Current()
+	signer, _ := crypto.GenerateECDSAKeyPair()
+
+	pm, err := cspm.NewPluginManager(setupTestDB(t), filepath.Join(root, "store"), keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() error = %v", err)
+	}
+	for _, version := range versions {
+		encrypted, _ := crypto.EncryptFile([]byte("provider "+version), key.Material)
+		path := filepath.Join(root, version+".cbp")
+		pkg := &cspm.CBPPackage{
+			Manifest:       cspm.Manifest{ID: "raid-mock", Name: "RAID Mock", Version: version},
+			Watermark:      audit.Watermark{LicenseID: "lic-1"},
+			Schema:         schema,
+			ProviderBinary: encrypted,
+		}
+		if err := cspm.CreateCBP(pkg, signer, path); err != nil {
+			t.Fatalf("CreateCBP() error = %v", err)
+		}
+		if _, err := pm.ImportProvider(path); err != nil {
+			t.Fatalf("ImportProvider(%s) error = %v", version, err)
+		}
+	}
+	return pm
+}
+
+func TestStoreHandlerVersions(t *testing.T) {
+	pm := newTestPluginManager(t, "1.0.0", "1.1.0", "2.0.0")
+	handler := NewStoreHandler(pm)
+
+	call := func(fn func(echo.Context) error, method, body, id, version string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "version")
+		c.SetParamValues(id, version)
+		if err := fn(c); err != nil {
+			t.Fatalf("handler error = %v", err)
+		}
+		return rec
+	}
+
+	rec := call(handler.ListVersions, http.MethodGet, "", "raid-mock", "")
+	var list struct {
+		Versions []cspm.ProviderInfo `json:"versions"`
+		Total    int                 `json:"total"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if rec.Code != http.StatusOK || list.Total != 3 || list.Versions[0].Version != "2.0.0" || !list.Versions[2].IsDefault {
+		t.Fatalf("ListVersions() = %d %+v", rec.Code, list)
+	}
+
+	tests := []struct {
+		name        string
+		fn          func(echo.Context) error
+		method      string
+		body        string
+		id          string
+		version     string
+		wantStatus  int
+		wantDefault string
+	}{
+		{"upgrade to latest", handler.UpgradeProvider, http.MethodPost, `{}`, "raid-mock", "", http.StatusOK, "2.0.0"},
+		{"upgrade without newer version", handler.UpgradeProvider, http.MethodPost, `{}`, "raid-mock", "", http.StatusConflict, "2.0.0"},
+		{"rollback to previous", handler.RollbackProvider, http.MethodPost, `{}`, "raid-mock", "", http.StatusOK, "1.1.0"},
+		{"rollback to missing version", handler.RollbackProvider, http.MethodPost, `{"version":"0.9.0"}`, "raid-mock", "", http.StatusNotFound, "1.1.0"},
+		{"upgrade unknown provider", handler.UpgradeProvider, http.MethodPost, `{}`, "other", "", http.StatusNotFound, "1.1.0"},
+		{"delete default version", handler.DeleteVersion, http.MethodDelete, "", "raid-mock", "1.1.0", http.StatusConflict, "1.1.0"},
+		{"delete old version", handler.DeleteVersion, http.MethodDelete, "", "raid-mock", "1.0.0", http.StatusOK, "1.1.0"},
+		{"delete missing version", handler.DeleteVersion, http.MethodDelete, "", "raid-mock", "1.0.0", http.StatusNotFound, "1.1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := call(tt.fn, tt.method, tt.body, tt.id, tt.version)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got, _ := pm.GetProvider("raid-mock"); got.Version != tt.wantDefault {
+				t.Errorf("default version = %s, want %s", got.Version, tt.wantDefault)
+			}
+		})
+	}
+
+	// 未指定版本时返回默认版本
+	rec = call(handler.GetProvider, http.MethodGet, "", "raid-mock", "")
+	var info cspm.ProviderInfo
+	json.Unmarshal(rec.Body.Bytes(), &info)
+	if info.Version != "1.1.0" {
+		t.Errorf("GetProvider() version = %s, want 1.1.0", info.Version)
+	}
+
+	var events []models.AuditEvent
+	database.GetDB().Where("action IN ?", []string{"store.upgrade", "store.rollback", "store.delete_version"}).Find(&events)
+	if len(events) != 3 {
+		t.Errorf("recorded %d version audit events, want 3", len(events))
+	}
+}
+
+func TestStoreHandlerVersionsTenantScope(t *testing.T) {
+	pm := newTestPluginManager(t, "1.0.0", "2.0.0")
+	handler := NewStoreHandler(pm)
+	tenant.SetProviderOwner(database.GetDB(), "raid-mock", "tenant-a")
+
+	tests := []struct {
+		name       string
+		scope      tenant.Scope
+		wantStatus int
+	}{
+		{"other tenant", tenant.Scope{Enabled: true, TenantID: "tenant-b"}, http.StatusNotFound},
+		{"owner tenant", tenant.Scope{Enabled: true, TenantID: "tenant-a"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"version":"2.0.0"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("raid-mock")
+			c.Set(tenantScopeKey, tt.scope)
+			if err := handler.UpgradeProvider(c); err != nil {
+				t.Fatalf("UpgradeProvider() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStoreHandlerImportInvalidPackage(t *testing.T) {
+	handler := NewStoreHandler(newTestPluginManager(t))
+	database.GetDB().Create(&models.Tenant{ID: "tenant-a", Name: "A"})
+
+	// 其他密钥签名的包
+	otherKey, _ := crypto.GenerateECDSAKeyPair()
+	foreign := filepath.Join(t.TempDir(), "foreign.cbp")
+	if err := cspm.CreateCBP(&cspm.CBPPackage{
+		Manifest:       cspm.Manifest{ID: "raid-mock", Name: "RAID Mock", Version: "9.0.0"},
+		ProviderBinary: []byte("payload"),
+	}, otherKey, foreign); err != nil {
+		t.Fatalf("CreateCBP() error = %v", err)
+	}
+	foreignData, _ := os.ReadFile(foreign)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		scope tenant.Scope
+	}{
+		{"not a zip", []byte("garbage"), tenant.Scope{}},
+		{"not a zip multi-tenant", []byte("garbage"), tenant.Scope{Enabled: true, TenantID: "tenant-a"}},
+		{"foreign signature", foreignData, tenant.Scope{}},
+		{"foreign signature multi-tenant", foreignData, tenant.Scope{Enabled: true, TenantID: "tenant-a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", "provider.cbp")
+			part.Write(tt.data)
+			writer.Close()
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(tenantScopeKey, tt.scope)
+			if err := handler.ImportProvider(c); err != nil {
+				t.Fatalf("ImportProvider() error = %v", err)
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestStoreHandlerDeleteVersionPinned(t *testing.T) {
+	pm := newTestPluginManager(t, "1.0.0", "2.0.0")
+	handler := NewStoreHandler(pm)
+	database.GetDB().Create(&models.Job{ID: "job-pinned", MachineID: "machine-1", Status: models.JobStatusPending,
+		ProviderVersions: map[string]string{"raid-mock": "2.0.0"}})
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), rec)
+	c.SetParamNames("id", "version")
+	c.SetParamValues("raid-mock", "2.0.0")
+	if err := handler.DeleteVersion(c); err != nil {
+		t.Fatalf("DeleteVersion() error = %v", err)
+	}
+
+	var resp struct {
+		Pins []string `json:"pins"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusConflict || len(resp.Pins) != 1 || resp.Pins[0] != "job:job-pinned" {
+		t.Errorf("DeleteVersion() = %d %s, want 409 listing job:job-pinned", rec.Code, rec.Body.String())
+	}
+	if _, err := pm.GetProviderVersion("raid-mock", "2.0.0"); err != nil {
+		t.Errorf("pinned version was deleted: %v", err)
+	}
+}
+
+func TestStoreHandlerProviderSchema(t *testing.T) {
+	schema := []byte(`{"version":"1","parameters":[
+		{"name":"level","type":"string","required":true,"constraints":{"enum":["raid1","raid5"]}},
+		{"name":"cache","type":"object","properties":[{"name":"policy","type":"string","default":"write-back"}]}
+	]}`)
+	withSchema := NewStoreHandler(newTestPluginManagerWithSchema(t, schema, "1.0.0"))
+	withoutSchema := NewStoreHandler(newTestPluginManager(t, "1.0.0"))
+
+	tests := []struct {
+		name       string
+		handler    *StoreHandler
+		id         string
+		version    string
+		wantStatus int
+	}{
+		{"schema and defaults", withSchema, "raid-mock", "", http.StatusOK},
+		{"explicit version", withSchema, "raid-mock", "1.0.0", http.StatusOK},
+		{"missing version", withSchema, "raid-mock", "9.9.9", http.StatusNotFound},
+		{"unknown provider", withSchema, "other", "", http.StatusNotFound},
+		{"package without schema", withoutSchema, "raid-mock", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/?version="+tt.version, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+			if err := tt.handler.GetProviderSchema(c); err != nil {
+				t.Fatalf("GetProviderSchema() error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Version  string                 `json:"version"`
+				Schema   cspm.ProviderSchema    `json:"schema"`
+				Defaults map[string]interface{} `json:"defaults"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Version != "1.0.0" || len(resp.Schema.Parameters) != 2 || resp.Schema.Parameters[1].Properties[0].Name != "policy" {
+				t.Errorf("schema response = %+v", resp)
+			}
+			if cache, _ := resp.Defaults["cache"].(map[string]interface{}); cache["policy"] != "write-back" {
+				t.Errorf("defaults = %v", resp.Defaults)
+			}
+		})
+	}
+}
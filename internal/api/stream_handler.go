@@ -1,10 +1,16 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
 	"github.com/labstack/echo/v4"
 )
 
@@ -22,39 +28,122 @@ func NewStreamHandler(broker *logbroker.Broker) *StreamHandler {
 
 // StreamLogs 实时日志流 (Server-Sent Events)
 // GET /api/stream/logs/:job_id
+//
+// 每条日志带 id 字段，浏览器重连时通过 Last-Event-ID 续传；
+// 未能送达的日志以 gap 事件通知，任务结束时发送 finished 事件。
 func (h *StreamHandler) StreamLogs(c echo.Context) error {
 	jobID := c.Param("job_id")
 
+	if !jobInScope(c, jobID) {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Job not found",
+		})
+	}
+
 	// 设置SSE响应头
+	setSSEHeaders(c)
+	c.Response().WriteHeader(http.StatusOK)
+
+	// 发送初始连接消息
+	initialMsg := `<div class="text-emerald-500">📡 Connected to log stream...</div>`
+	fmt.Fprintf(c.Response(), "data: %s\n\n", initialMsg)
+	c.Response().Flush()
+
+	return serveLogEvents(c, h.broker, jobID, htmlLogFormat)
+}
+
+// logFormat SSE日志事件格式
+type logFormat struct {
+	event  string
+	render func(msg *logbroker.LogMessage) string
+	html   bool // 额外以 message 事件输出丢失/结束提示，供 HTMX 直接显示
+}
+
+var (
+	// htmlLogFormat HTML片段，使用 "message" 事件名以匹配 HTMX sse-swap="message"
+	htmlLogFormat = logFormat{event: "message", render: (*logbroker.LogMessage).FormatAsHTML, html: true}
+	// jsonLogFormat JSON格式
+	jsonLogFormat = logFormat{event: "log", render: func(msg *logbroker.LogMessage) string {
+		data, _ := json.Marshal(msg)
+		return string(data)
+	}}
+)
+
+// setSSEHeaders 设置SSE响应头
+func setSSEHeaders(c echo.Context) {
 	c.Response().Header().Set("Content-Type", "text/event-stream")
 	c.Response().Header().Set("Cache-Control", "no-cache")
 	c.Response().Header().Set("Connection", "keep-alive")
 	c.Response().Header().Set("X-Accel-Buffering", "no") // 禁用Nginx缓冲
+}
 
-	c.Response().WriteHeader(http.StatusOK)
+// lastEventID 客户端已收到的最后日志ID（Last-Event-ID 头或 last_event_id 参数）
+func lastEventID(c echo.Context) int64 {
+	raw := c.Request().Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = c.QueryParam("last_event_id")
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
 
-	// 订阅日志
-	logChan := h.broker.Subscribe(jobID)
-	defer h.broker.Unsubscribe(jobID, logChan)
+// serveLogEvents 推送历史和实时日志，直到任务结束或客户端断开
+func serveLogEvents(c echo.Context, broker *logbroker.Broker, jobID string, format logFormat) error {
+	sub := broker.SubscribeFrom(jobID, lastEventID(c))
+	defer sub.Close()
 
-	// 发送初始连接消息
-	initialMsg := `<div class="text-emerald-500">📡 Connected to log stream...</div>`
-	fmt.Fprintf(c.Response(), "data: %s\n\n", initialMsg)
-	c.Response().Flush()
+	w := c.Response()
+	send := func(ev logbroker.Event) bool {
+		switch ev.Type {
+		case logbroker.EventLog:
+			writeSSE(w, strconv.FormatInt(ev.Message.ID, 10), format.event, format.render(&ev.Message))
+		case logbroker.EventGap:
+			// 尽量从历史补发，补不回的部分通知客户端
+			recovered := broker.Range(jobID, ev.From, ev.To)
+			for i := range recovered {
+				writeSSE(w, strconv.FormatInt(recovered[i].ID, 10), format.event, format.render(&recovered[i]))
+			}
+			if missing := ev.To - ev.From + 1 - int64(len(recovered)); missing > 0 {
+				writeSSE(w, "", "gap", fmt.Sprintf(`{"job_id":%q,"from":%d,"to":%d,"missing":%d}`, jobID, ev.From, ev.To, missing))
+				if format.html {
+					writeSSE(w, "", "message", fmt.Sprintf(`<div class="text-amber-500">⚠️ 日志 #%d-#%d 中有 %d 条未能送达</div>`, ev.From, ev.To, missing))
+				}
+			}
+		case logbroker.EventFinished:
+			writeFinished(w, jobID, ev.Status, format)
+			w.Flush()
+			return false
+		}
+		w.Flush()
+		return true
+	}
+
+	for _, ev := range sub.Backlog() {
+		if !send(ev) {
+			return nil
+		}
+	}
+
+	// 已结束的任务回放完历史即结束
+	if status, done := jobFinished(jobID); done {
+		writeFinished(w, jobID, status, format)
+		w.Flush()
+		return nil
+	}
 
-	// 监听日志消息
 	for {
 		select {
-		case msg, ok := <-logChan:
+		case ev, ok := <-sub.Events():
 			if !ok {
-				// channel关闭
+				// 订阅关闭，结束流
+				return nil
+			}
+			if !send(ev) {
 				return nil
 			}
-
-			// 格式化为HTML并发送
-			html := msg.FormatAsHTML()
-			fmt.Fprintf(c.Response(), "data: %s\n\n", html)
-			c.Response().Flush()
 
 		case <-c.Request().Context().Done():
 			// 客户端断开连接
@@ -62,3 +151,50 @@ func (h *StreamHandler) StreamLogs(c echo.Context) error {
 		}
 	}
 }
+
+// jobInScope 限定租户时只能订阅本租户的任务
+func jobInScope(c echo.Context, jobID string) bool {
+	scope := CurrentScope(c)
+	if scope.All {
+		return true
+	}
+	var job models.Job
+	return scope.Apply(database.GetDB()).Select("id").Where("id = ?", jobID).First(&job).Error == nil
+}
+
+// jobFinished 查询任务是否已结束
+func jobFinished(jobID string) (string, bool) {
+	db := database.GetDB()
+	if db == nil {
+		return "", false
+	}
+	var job models.Job
+	if err := db.Select("id", "status").Where("id = ?", jobID).First(&job).Error; err != nil {
+		return "", false
+	}
+	return string(job.Status), job.IsTerminal()
+}
+
+// writeFinished 发送任务结束事件
+func writeFinished(w io.Writer, jobID, status string, format logFormat) {
+	if format.html {
+		writeSSE(w, "", "message", fmt.Sprintf(`<div class="text-slate-400">■ 任务已结束 (%s)</div>`, status))
+	}
+	writeSSE(w, "", "finished", fmt.Sprintf(`{"job_id":%q,"status":%q}`, jobID, status))
+}
+
+// writeSSE 写入一个SSE事件，多行数据逐行加 data: 前缀
+func writeSSE(w io.Writer, id, event, data string) {
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestStreamHandler_StreamLogs(t *testing.T) {
+	db := setupTestDB(t)
+	broker := logbroker.NewBroker()
+	handler := NewStreamHandler(broker)
+
+	db.Create(&models.Job{ID: "job-done", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusSuccess})
+	for _, msg := range []string{"one", "two", "three"} {
+		broker.Publish("job-done", logbroker.LogMessage{Timestamp: time.Now(), Level: "INFO", Message: msg})
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []string
+		notWant     []string
+	}{
+		{
+			name: "Full replay then finished",
+			want: []string{"id: 1\nevent: message\n", "id: 3\n", "event: finished\ndata: {\"job_id\":\"job-done\",\"status\":\"success\"}"},
+		},
+		{
+			name:        "Resume after Last-Event-ID",
+			lastEventID: "2",
+			want:        []string{"id: 3\n", "event: finished"},
+			notWant:     []string{"id: 1\n", "id: 2\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/stream/logs/job-done", nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("job_id")
+			c.SetParamValues("job-done")
+
+			if err := handler.StreamLogs(c); err != nil {
+				t.Fatalf("StreamLogs() error = %v", err)
+			}
+
+			body := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("body missing %q:\n%s", want, body)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(body, notWant) {
+					t.Errorf("body should not contain %q:\n%s", notWant, body)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamHandler_StreamLogs_Live(t *testing.T) {
+	db := setupTestDB(t)
+	broker := logbroker.NewBroker()
+	handler := NewStreamHandler(broker)
+
+	db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeAudit, Status: models.JobStatusRunning})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/logs/job-1", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("job_id")
+	c.SetParamValues("job-1")
+
+	done := make(chan error)
+	go func() { done <- handler.StreamLogs(c) }()
+
+	time.Sleep(50 * time.Millisecond)
+	broker.Publish("job-1", logbroker.LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "live line"})
+	db.Model(&models.Job{}).Where("id = ?", "job-1").Update("status", models.JobStatusFailed)
+	broker.Finish("job-1", string(models.JobStatusFailed))
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StreamLogs() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end after the job finished")
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "live line") || !strings.Contains(body, `"status":"failed"`) {
+		t.Errorf("unexpected stream body:\n%s", body)
+	}
+}
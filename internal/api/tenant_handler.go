@@ -0,0 +1,241 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// TenantHandler 租户管理API处理器（需要License启用 multi_tenant）
+type TenantHandler struct{}
+
+// NewTenantHandler 创建TenantHandler
+func NewTenantHandler() *TenantHandler {
+	return &TenantHandler{}
+}
+
+// tenantView 租户及其资源用量
+type tenantView struct {
+	models.Tenant
+	Usage models.Quota `json:"usage"`
+}
+
+// ListTenants 获取租户列表（含资源用量）
+// GET /api/v1/tenants
+func (h *TenantHandler) ListTenants(c echo.Context) error {
+	db := database.GetDB()
+
+	var tenants []models.Tenant
+	if err := db.Order("name").Find(&tenants).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to fetch tenants",
+		})
+	}
+
+	items := make([]tenantView, 0, len(tenants))
+	for _, t := range tenants {
+		usage, err := tenant.Usage(db, t.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to count tenant usage",
+			})
+		}
+		items = append(items, tenantView{Tenant: t, Usage: usage})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": items,
+		"total": len(items),
+	})
+}
+
+// GetTenant 获取租户详情（含资源用量）
+// GET /api/v1/tenants/:id
+func (h *TenantHandler) GetTenant(c echo.Context) error {
+	db := database.GetDB()
+
+	t, err := tenant.Get(db, c.Param("id"))
+	if err != nil {
+		return tenantLookupError(c, err)
+	}
+	usage, err := tenant.Usage(db, t.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to count tenant usage",
+		})
+	}
+
+	return c.JSON(http.StatusOK, tenantView{Tenant: *t, Usage: usage})
+}
+
+// CreateTenant 创建租户
+// POST /api/v1/tenants
+func (h *TenantHandler) CreateTenant(c echo.Context) error {
+	var req struct {
+		Name        string       `json:"name"`
+		Description string       `json:"description"`
+		Quota       models.Quota `json:"quota"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "name is required",
+		})
+	}
+	if !validQuota(req.Quota) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "quota values must not be negative",
+		})
+	}
+
+	db := database.GetDB()
+	var count int64
+	db.Model(&models.Tenant{}).Where("name = ?", req.Name).Count(&count)
+	if count > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": "Tenant already exists",
+		})
+	}
+
+	t := models.Tenant{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Description: req.Description,
+		Quota:       req.Quota,
+	}
+	if err := db.Create(&t).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to create tenant",
+		})
+	}
+
+	recordAudit(c, "tenant.create", t.ID, nil, t)
+	return c.JSON(http.StatusCreated, t)
+}
+
+// UpdateTenant 更新租户名称、描述或配额
+// PUT /api/v1/tenants/:id
+//
+// 配额低于当前用量时只阻止新增，不影响已有资源。
+func (h *TenantHandler) UpdateTenant(c echo.Context) error {
+	db := database.GetDB()
+
+	t, err := tenant.Get(db, c.Param("id"))
+	if err != nil {
+		return tenantLookupError(c, err)
+	}
+	before := audit.Snapshot(t)
+
+	var req struct {
+		Name        *string       `json:"name"`
+		Description *string       `json:"description"`
+		Quota       *models.Quota `json:"quota"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "name is required",
+			})
+		}
+		var count int64
+		db.Model(&models.Tenant{}).Where("name = ? AND id <> ?", name, t.ID).Count(&count)
+		if count > 0 {
+			return c.JSON(http.StatusConflict, map[string]interface{}{
+				"error": "Tenant already exists",
+			})
+		}
+		t.Name = name
+	}
+	if req.Description != nil {
+		t.Description = *req.Description
+	}
+	if req.Quota != nil {
+		if !validQuota(*req.Quota) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "quota values must not be negative",
+			})
+		}
+		t.Quota = *req.Quota
+	}
+
+	if err := db.Save(t).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to update tenant",
+		})
+	}
+
+	recordAudit(c, "tenant.update", t.ID, before, t)
+	return c.JSON(http.StatusOK, t)
+}
+
+// DeleteTenant 删除租户（仍有机器、配置模板、Provider、任务或用户时拒绝）
+// DELETE /api/v1/tenants/:id
+func (h *TenantHandler) DeleteTenant(c echo.Context) error {
+	db := database.GetDB()
+
+	t, err := tenant.Get(db, c.Param("id"))
+	if err != nil {
+		return tenantLookupError(c, err)
+	}
+
+	for _, model := range []interface{}{
+		&models.Machine{}, &models.OSProfile{}, &models.Job{}, &models.User{}, &models.ProviderTenant{},
+	} {
+		var count int64
+		if err := db.Model(model).Where("tenant_id = ?", t.ID).Count(&count).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to check tenant usage",
+			})
+		}
+		if count > 0 {
+			return c.JSON(http.StatusConflict, map[string]interface{}{
+				"error": "Tenant still owns resources or users",
+			})
+		}
+	}
+
+	if err := db.Delete(t).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to delete tenant",
+		})
+	}
+
+	recordAudit(c, "tenant.delete", t.ID, t, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// tenantLookupError 租户查询错误转换为 404/500 响应
+func tenantLookupError(c echo.Context, err error) error {
+	if errors.Is(err, tenant.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "Tenant not found",
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error": "Failed to fetch tenant",
+	})
+}
+
+func validQuota(q models.Quota) bool {
+	return q.Machines >= 0 && q.Profiles >= 0 && q.Providers >= 0 && q.ActiveJobs >= 0
+}
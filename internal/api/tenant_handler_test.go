@@ -0,0 +1,245 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/core/workflow"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+func tenantPrincipal(tenantID string, perms ...string) *auth.Principal {
+	return &auth.Principal{
+		User:        &models.User{ID: "user-" + tenantID, Username: "user-" + tenantID, TenantID: tenantID},
+		Permissions: perms,
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	db := setupTestDB(t)
+	db.Create(&models.Tenant{ID: "tenant-a", Name: "A"})
+
+	tests := []struct {
+		name       string
+		licensed   bool
+		principal  *auth.Principal
+		header     string
+		wantStatus int
+		wantScope  tenant.Scope
+	}{
+		{"not licensed", false, tenantPrincipal("tenant-a", auth.PermMachineRead), "", http.StatusOK, tenant.Unrestricted},
+		{"tenant user", true, tenantPrincipal("tenant-a", auth.PermMachineRead), "", http.StatusOK,
+			tenant.Scope{Enabled: true, TenantID: "tenant-a"}},
+		{"tenant user own header", true, tenantPrincipal("tenant-a", auth.PermMachineRead), "tenant-a", http.StatusOK,
+			tenant.Scope{Enabled: true, TenantID: "tenant-a"}},
+		{"tenant user other header", true, tenantPrincipal("tenant-a", auth.PermMachineRead), "tenant-b", http.StatusForbidden, tenant.Scope{}},
+		{"user without tenant", true, tenantPrincipal("", auth.PermMachineRead), "", http.StatusOK,
+			tenant.Scope{Enabled: true}},
+		{"cross-tenant admin", true, tenantPrincipal("", auth.PermAll), "", http.StatusOK,
+			tenant.Scope{Enabled: true, All: true}},
+		{"cross-tenant admin acting as tenant", true, tenantPrincipal("", auth.PermTenantRead), "tenant-a", http.StatusOK,
+			tenant.Scope{Enabled: true, TenantID: "tenant-a"}},
+		{"cross-tenant admin unknown tenant", true, tenantPrincipal("", auth.PermAll), "missing", http.StatusBadRequest, tenant.Scope{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			licenses := newTestLicenses(t)
+			if tt.licensed {
+				licenses = newTestLicenses(t, models.FeatureMultiTenant)
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/machines", nil)
+			if tt.header != "" {
+				req.Header.Set(HeaderTenantID, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.Set(principalKey, tt.principal)
+
+			var got tenant.Scope
+			handler := ResolveTenant(licenses)(func(c echo.Context) error {
+				got = CurrentScope(c)
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK && got != tt.wantScope {
+				t.Errorf("scope = %+v, want %+v", got, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestTenantHandler(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewTenantHandler()
+
+	newContext := func(method, body, id string) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		req := httptest.NewRequest(method, "/api/v1/tenants", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set(principalKey, tenantPrincipal("", auth.PermAll))
+		return c, rec
+	}
+
+	c, rec := newContext(http.MethodPost, `{"name":"Payments","quota":{"machines":1}}`, "")
+	if err := handler.CreateTenant(c); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("CreateTenant() = %d, %v: %s", rec.Code, err, rec.Body.String())
+	}
+	var created models.Tenant
+	json.Unmarshal(rec.Body.Bytes(), &created)
+
+	tests := []struct {
+		name       string
+		call       func(echo.Context) error
+		method     string
+		body       string
+		id         string
+		wantStatus int
+	}{
+		{"duplicate name", handler.CreateTenant, http.MethodPost, `{"name":"Payments"}`, "", http.StatusConflict},
+		{"missing name", handler.CreateTenant, http.MethodPost, `{"name":" "}`, "", http.StatusBadRequest},
+		{"negative quota", handler.CreateTenant, http.MethodPost, `{"name":"Other","quota":{"profiles":-1}}`, "", http.StatusBadRequest},
+		{"update quota", handler.UpdateTenant, http.MethodPut, `{"quota":{"machines":5}}`, created.ID, http.StatusOK},
+		{"update missing", handler.UpdateTenant, http.MethodPut, `{}`, "missing", http.StatusNotFound},
+		{"get", handler.GetTenant, http.MethodGet, "", created.ID, http.StatusOK},
+		{"list", handler.ListTenants, http.MethodGet, "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(tt.method, tt.body, tt.id)
+			if err := tt.call(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	// 仍有机器时不能删除
+	db.Create(&models.Machine{ID: "m1", Hostname: "a1", MacAddress: "aa:00:00:00:00:01", TenantID: created.ID})
+	c, rec = newContext(http.MethodDelete, "", created.ID)
+	if err := handler.DeleteTenant(c); err != nil || rec.Code != http.StatusConflict {
+		t.Errorf("DeleteTenant() with machines = %d, %v", rec.Code, err)
+	}
+
+	c, rec = newContext(http.MethodGet, "", created.ID)
+	handler.GetTenant(c)
+	var view tenantView
+	json.Unmarshal(rec.Body.Bytes(), &view)
+	if view.Quota.Machines != 5 || view.Usage.Machines != 1 {
+		t.Errorf("GetTenant() = %+v", view)
+	}
+
+	db.Delete(&models.Machine{ID: "m1"})
+	c, rec = newContext(http.MethodDelete, "", created.ID)
+	if err := handler.DeleteTenant(c); err != nil || rec.Code != http.StatusNoContent {
+		t.Errorf("DeleteTenant() = %d, %v", rec.Code, err)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := setupTestDB(t)
+	db.Create(&models.Tenant{ID: "tenant-a", Name: "A", Quota: models.Quota{Machines: 2}})
+	db.Create(&models.Tenant{ID: "tenant-b", Name: "B"})
+	db.Create(&models.Machine{ID: "ma", Hostname: "a1", MacAddress: "aa:00:00:00:00:01", TenantID: "tenant-a", Status: models.MachineStatusReady})
+	db.Create(&models.Machine{ID: "mb", Hostname: "b1", MacAddress: "bb:00:00:00:00:01", TenantID: "tenant-b", Status: models.MachineStatusReady})
+	db.Create(&models.OSProfile{ID: "pa", Name: "profile-a", TenantID: "tenant-a"})
+	db.Create(&models.OSProfile{ID: "pb", Name: "profile-b", TenantID: "tenant-b"})
+	db.Create(&models.Job{ID: "jb", MachineID: "mb", TenantID: "tenant-b", Status: models.JobStatusRunning})
+
+	machines := NewMachineHandler()
+	jobs := NewJobHandler(workflow.NewFinisher(nil))
+	scopeA := tenant.Scope{Enabled: true, TenantID: "tenant-a"}
+
+	newContext := func(method, body, id string, scope tenant.Scope, p *auth.Principal) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set(principalKey, p)
+		c.Set(tenantScopeKey, scope)
+		return c, rec
+	}
+	userA := tenantPrincipal("tenant-a", auth.PermMachineWrite)
+
+	t.Run("list only own machines", func(t *testing.T) {
+		c, rec := newContext(http.MethodGet, "", "", scopeA, userA)
+		machines.ListMachines(c)
+		var resp struct {
+			Items []models.Machine `json:"items"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if len(resp.Items) != 1 || resp.Items[0].ID != "ma" {
+			t.Errorf("ListMachines() = %+v", resp.Items)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		call       func(echo.Context) error
+		method     string
+		body       string
+		id         string
+		scope      tenant.Scope
+		principal  *auth.Principal
+		wantStatus int
+	}{
+		{"get other tenant machine", machines.GetMachine, http.MethodGet, "", "mb", scopeA, userA, http.StatusNotFound},
+		{"delete other tenant machine", machines.DeleteMachine, http.MethodDelete, "", "mb", scopeA, userA, http.StatusNotFound},
+		{"get other tenant job", jobs.GetJob, http.MethodGet, "", "jb", scopeA, userA, http.StatusNotFound},
+		{"cancel other tenant job", jobs.CancelJob, http.MethodDelete, "", "jb", scopeA, userA, http.StatusNotFound},
+		{"provision with other tenant profile", machines.ProvisionMachine, http.MethodPost, `{"profile_id":"pb"}`, "ma", scopeA, userA, http.StatusBadRequest},
+		{"provision with own profile", machines.ProvisionMachine, http.MethodPost, `{"profile_id":"pa"}`, "ma", scopeA, userA, http.StatusAccepted},
+		{"reassign without tenant:manage", machines.UpdateMachine, http.MethodPut, `{"tenant_id":"tenant-b"}`, "ma", scopeA, userA, http.StatusForbidden},
+		{"create within quota", machines.CreateMachine, http.MethodPost, `{"mac":"aa:00:00:00:00:02","hostname":"a2"}`, "", scopeA, userA, http.StatusCreated},
+		{"create over quota", machines.CreateMachine, http.MethodPost, `{"mac":"aa:00:00:00:00:03","hostname":"a3"}`, "", scopeA, userA, http.StatusForbidden},
+		{"reassign into full tenant", machines.UpdateMachine, http.MethodPut, `{"tenant_id":"tenant-a"}`, "mb",
+			tenant.Scope{Enabled: true, All: true}, tenantPrincipal("", auth.PermAll), http.StatusForbidden},
+		{"admin moves machine out", machines.UpdateMachine, http.MethodPut, `{"tenant_id":"tenant-b"}`, "ma",
+			tenant.Scope{Enabled: true, All: true}, tenantPrincipal("", auth.PermAll), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(tt.method, tt.body, tt.id, tt.scope, tt.principal)
+			if err := tt.call(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	var job models.Job
+	db.Where("machine_id = ?", "ma").First(&job)
+	if job.TenantID != "tenant-a" {
+		t.Errorf("provisioned job tenant = %q, want tenant-a", job.TenantID)
+	}
+	var moved models.Machine
+	db.Where("id = ?", "ma").First(&moved)
+	if moved.TenantID != "tenant-b" {
+		t.Errorf("reassigned machine tenant = %q, want tenant-b", moved.TenantID)
+	}
+}
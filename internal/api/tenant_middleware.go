@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/license"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderTenantID 跨租户管理员指定操作租户的请求头
+const HeaderTenantID = "X-Tenant-ID"
+
+// tenantScopeKey echo.Context 中保存租户范围的键
+const tenantScopeKey = "tenant_scope"
+
+// CurrentScope 获取当前请求的租户范围（未经过 ResolveTenant 时不限租户）
+func CurrentScope(c echo.Context) tenant.Scope {
+	if s, ok := c.Get(tenantScopeKey).(tenant.Scope); ok {
+		return s
+	}
+	return tenant.Unrestricted
+}
+
+// ResolveTenant 租户范围中间件（需在 RequireAuth 之后使用）
+//
+// 没有可用的 multi_tenant License 时不做隔离。启用后：
+//   - 具备 tenant:read 的跨租户管理员默认访问全部租户，可用 X-Tenant-ID 指定操作租户
+//   - 其他用户只能访问所属租户的资源，新建资源归属该租户
+func ResolveTenant(licenses *license.Manager) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			db := database.GetDB()
+			scope := tenant.Unrestricted
+			if licenses.HasFeature(models.FeatureMultiTenant) {
+				p := CurrentPrincipal(c)
+				requested := c.Request().Header.Get(HeaderTenantID)
+
+				scope = tenant.Scope{Enabled: true, TenantID: p.User.TenantID}
+				switch {
+				case p.Has(auth.PermTenantRead) && requested == "":
+					scope.All = true
+				case p.Has(auth.PermTenantRead):
+					if _, err := tenant.Get(db, requested); err != nil {
+						if errors.Is(err, tenant.ErrNotFound) {
+							return c.JSON(http.StatusBadRequest, map[string]interface{}{
+								"error": "Unknown tenant",
+							})
+						}
+						return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+							"error": "Failed to resolve tenant",
+						})
+					}
+					scope.TenantID = requested
+				case requested != "" && requested != p.User.TenantID:
+					return c.JSON(http.StatusForbidden, map[string]interface{}{
+						"error": "Cannot access another tenant",
+					})
+				}
+			}
+
+			c.Set(tenantScopeKey, scope)
+			return next(c)
+		}
+	}
+}
+
+// quotaError 将配额错误转换为响应
+func quotaError(c echo.Context, err error) error {
+	if errors.Is(err, tenant.ErrQuotaExceeded) {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error": "Failed to check tenant quota",
+	})
+}
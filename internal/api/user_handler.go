@@ -0,0 +1,273 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/auth"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+	"github.com/labstack/echo/v4"
+)
+
+// UserHandler 用户管理API处理器（需要 user:manage 权限）
+type UserHandler struct {
+	svc *auth.Service
+}
+
+// NewUserHandler 创建UserHandler
+func NewUserHandler(svc *auth.Service) *UserHandler {
+	return &UserHandler{
+		svc: svc,
+	}
+}
+
+// ListUsers 获取用户列表
+// GET /api/v1/users
+func (h *UserHandler) ListUsers(c echo.Context) error {
+	var users []models.User
+	if err := database.GetDB().Order("username").Find(&users).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to fetch users",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items": users,
+		"total": len(users),
+	})
+}
+
+// CreateUser 创建用户
+// POST /api/v1/users
+func (h *UserHandler) CreateUser(c echo.Context) error {
+	var req struct {
+		Username    string   `json:"username"`
+		Password    string   `json:"password"`
+		DisplayName string   `json:"display_name"`
+		Email       string   `json:"email"`
+		Roles       []string `json:"roles"`
+		TenantID    string   `json:"tenant_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "username is required",
+		})
+	}
+
+	if err := h.svc.ValidateRoles(req.Roles); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	if err := validateUserTenant(req.TenantID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	var count int64
+	database.GetDB().Model(&models.User{}).Where("username = ?", req.Username).Count(&count)
+	if count > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": "Username already exists",
+		})
+	}
+
+	user := models.User{
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		Email:       req.Email,
+		Roles:       req.Roles,
+		TenantID:    req.TenantID,
+	}
+	if err := h.svc.CreateUser(&user, req.Password); err != nil {
+		if errors.Is(err, auth.ErrWeakPassword) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to create user",
+		})
+	}
+
+	recordAudit(c, "user.create", user.ID, nil, user)
+	return c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser 更新用户信息、角色或重置密码
+// PUT /api/v1/users/:id
+func (h *UserHandler) UpdateUser(c echo.Context) error {
+	db := database.GetDB()
+	id := c.Param("id")
+
+	var user models.User
+	if err := db.Where("id = ?", id).First(&user).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "User not found",
+		})
+	}
+
+	before := audit.Snapshot(user)
+
+	var req struct {
+		DisplayName *string   `json:"display_name"`
+		Email       *string   `json:"email"`
+		Roles       *[]string `json:"roles"`
+		Disabled    *bool     `json:"disabled"`
+		Password    *string   `json:"password"`
+		TenantID    *string   `json:"tenant_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Roles != nil {
+		if err := h.svc.ValidateRoles(*req.Roles); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+	if req.TenantID != nil {
+		if err := validateUserTenant(*req.TenantID); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	// 防止管理员把自己锁在系统外
+	if user.ID == CurrentPrincipal(c).User.ID {
+		demoted := false
+		if req.Roles != nil {
+			perms, err := h.svc.PermissionsFor(*req.Roles)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+					"error": "Failed to resolve roles",
+				})
+			}
+			demoted = !slices.Contains(perms, auth.PermAll) && !slices.Contains(perms, auth.PermUserManage)
+		}
+		if demoted || (req.Disabled != nil && *req.Disabled) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error": "Cannot demote or disable yourself",
+			})
+		}
+	}
+
+	if req.Password != nil {
+		if err := h.svc.SetPassword(user.ID, *req.Password); err != nil {
+			if errors.Is(err, auth.ErrWeakPassword) {
+				return c.JSON(http.StatusBadRequest, map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to update password",
+			})
+		}
+	}
+
+	// 按结构体更新，roles 字段才会经过JSON序列化
+	var columns []string
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+		columns = append(columns, "display_name")
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+		columns = append(columns, "email")
+	}
+	if req.Roles != nil {
+		user.Roles = *req.Roles
+		columns = append(columns, "roles")
+	}
+	if req.Disabled != nil {
+		user.Disabled = *req.Disabled
+		columns = append(columns, "disabled")
+	}
+	if req.TenantID != nil {
+		user.TenantID = *req.TenantID
+		columns = append(columns, "tenant_id")
+	}
+	if len(columns) > 0 {
+		if err := db.Model(&user).Select(columns).Updates(&user).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to update user",
+			})
+		}
+	}
+	if req.Disabled != nil && *req.Disabled {
+		h.svc.RevokeSessions(user.ID)
+	}
+
+	db.Where("id = ?", id).First(&user)
+	var after interface{} = user
+	if req.Password != nil {
+		// 密码哈希不出现在JSON中，单独标记密码已重置
+		after = struct {
+			models.User
+			Password string `json:"password"`
+		}{user, "reset"}
+	}
+	recordAudit(c, "user.update", user.ID, before, after)
+	return c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser 删除用户（同时吊销其会话和API令牌）
+// DELETE /api/v1/users/:id
+func (h *UserHandler) DeleteUser(c echo.Context) error {
+	id := c.Param("id")
+	if id == CurrentPrincipal(c).User.ID {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error": "Cannot delete yourself",
+		})
+	}
+
+	var user models.User
+	if err := database.GetDB().Where("id = ?", id).First(&user).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"error": "User not found",
+		})
+	}
+
+	if err := h.svc.DeleteUser(user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to delete user",
+		})
+	}
+
+	recordAudit(c, "user.delete", user.ID, user, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// validateUserTenant 检查用户所属租户存在（空表示不属于任何租户）
+func validateUserTenant(tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+	if _, err := tenant.Get(database.GetDB(), tenantID); err != nil {
+		if errors.Is(err, tenant.ErrNotFound) {
+			return fmt.Errorf("unknown tenant: %s", tenantID)
+		}
+		return err
+	}
+	return nil
+}
@@ -26,7 +26,7 @@ func NewWebHandler(pm *cspm.PluginManager) *WebHandler {
 func (h *WebHandler) OSDesignerPage(c echo.Context) error {
 	// Fetch all profiles
 	var profiles []models.OSProfile
-	database.DB.Find(&profiles)
+	CurrentScope(c).Apply(database.DB).Find(&profiles)
 
 	// Calculate stats
 	stats := struct {
@@ -47,7 +47,7 @@ func (h *WebHandler) OSDesignerPage(c echo.Context) error {
 
 	// Count active jobs
 	var activeJobs int64
-	database.DB.Model(&models.Job{}).Where("status IN ?", []models.JobStatus{
+	CurrentScope(c).Apply(database.DB.Model(&models.Job{})).Where("status IN ?", []models.JobStatus{
 		models.JobStatusPending,
 		models.JobStatusRunning,
 	}).Count(&activeJobs)
@@ -68,12 +68,13 @@ func (h *WebHandler) OSDesignerPage(c echo.Context) error {
 
 // MachinesPage renders the Machines page
 func (h *WebHandler) MachinesPage(c echo.Context) error {
+	scope := CurrentScope(c)
 	var machines []models.Machine
-	database.DB.Find(&machines)
+	scope.Apply(database.DB).Find(&machines)
 
 	// Fetch all profiles for task creation form
 	var profiles []models.OSProfile
-	database.DB.Find(&profiles)
+	scope.Apply(database.DB).Find(&profiles)
 
 	// Calculate stats
 	stats := struct {
@@ -108,7 +109,7 @@ func (h *WebHandler) MachinesPage(c echo.Context) error {
 // JobsPage renders the Jobs page
 func (h *WebHandler) JobsPage(c echo.Context) error {
 	var jobs []models.Job
-	database.DB.Preload("Machine").Preload("Profile").Order("created_at DESC").Find(&jobs)
+	CurrentScope(c).Apply(database.DB).Preload("Machine").Preload("Profile").Order("created_at DESC").Find(&jobs)
 
 	// Calculate stats
 	stats := struct {
@@ -147,8 +148,8 @@ func (h *WebHandler) JobsPage(c echo.Context) error {
 
 // StorePage renders the Private Store page
 func (h *WebHandler) StorePage(c echo.Context) error {
-	// Get providers from PluginManager
-	providers := h.pluginManager.ListProviders()
+	// Get providers from PluginManager (filtered by tenant scope)
+	providers := visibleProviders(c, h.pluginManager.ListProviders())
 
 	// Calculate stats
 	stats := struct {
@@ -176,13 +177,14 @@ func (h *WebHandler) StorePage(c echo.Context) error {
 // HomePage renders the home/dashboard page
 func (h *WebHandler) HomePage(c echo.Context) error {
 	// Get overview stats
+	scope := CurrentScope(c)
 	var machineCount, jobCount, profileCount int64
-	database.DB.Model(&models.Machine{}).Count(&machineCount)
-	database.DB.Model(&models.Job{}).Count(&jobCount)
-	database.DB.Model(&models.OSProfile{}).Count(&profileCount)
+	scope.Apply(database.DB.Model(&models.Machine{})).Count(&machineCount)
+	scope.Apply(database.DB.Model(&models.Job{})).Count(&jobCount)
+	scope.Apply(database.DB.Model(&models.OSProfile{})).Count(&profileCount)
 
 	var recentJobs []models.Job
-	database.DB.Preload("Machine").Order("created_at DESC").Limit(10).Find(&recentJobs)
+	scope.Apply(database.DB).Preload("Machine").Order("created_at DESC").Limit(10).Find(&recentJobs)
 
 	// Get system monitor stats
 	sysStats := monitor.GetStats()
@@ -191,7 +193,7 @@ func (h *WebHandler) HomePage(c echo.Context) error {
 	dbHealthy := database.HealthCheck() == nil
 
 	// Get CSPM providers
-	providers := h.pluginManager.ListProviders()
+	providers := visibleProviders(c, h.pluginManager.ListProviders())
 
 	data := map[string]any{
 		"title":  "Dashboard",
@@ -0,0 +1,345 @@
+// Package agentauth BootOS Agent身份认证
+//
+// iPXE脚本通过内核参数下发一次性引导令牌（绑定MAC和获取脚本的地址），Agent注册时
+// 用它换取签名的短期Agent令牌（绑定机器ID、MAC和凭据版本），之后调用 /api/boot/v1/*
+// 时携带 Authorization: Bearer <agent_token>。
+//
+// iPXE脚本接口是公开的，引导令牌不足以证明请求方就是该机器：已注册的机器只接受
+// 签发时已绑定（DHCP租约或已知地址匹配、或运维批准重新注册）的引导令牌，且只有
+// 运维批准重新注册时才提升凭据版本，使该机器此前签发的令牌全部失效。
+package agentauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+// DefaultTokenTTL Agent令牌默认有效期
+const DefaultTokenTTL = time.Hour
+
+// BootstrapTTL 引导令牌有效期（覆盖一次PXE启动到Agent注册的时间）
+const BootstrapTTL = 30 * time.Minute
+
+// keySize 签名密钥长度
+const keySize = 32
+
+var (
+	// ErrInvalidToken Agent令牌格式错误、签名不符、已过期或已被轮换
+	ErrInvalidToken = errors.New("invalid agent token")
+	// ErrInvalidBootstrap 引导令牌不存在、已使用、已过期或与MAC、来源地址不符
+	ErrInvalidBootstrap = errors.New("invalid bootstrap token")
+	// ErrAlreadyEnrolled 机器已注册，未绑定的引导令牌不能换取Agent令牌
+	ErrAlreadyEnrolled = errors.New("machine already enrolled")
+)
+
+// Bootstrap 已使用的引导令牌
+type Bootstrap struct {
+	// Bound 签发时已确认请求方就是该机器
+	Bound bool
+}
+
+// Claims Agent令牌声明
+type Claims struct {
+	MachineID  string `json:"machine_id"`
+	MacAddress string `json:"mac"`
+	Generation int    `json:"gen"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+// Authority 签发和校验Agent凭据
+type Authority struct {
+	db       *gorm.DB
+	key      []byte
+	tokenTTL time.Duration
+}
+
+// New 创建Authority，tokenTTL 为0时使用默认有效期
+func New(db *gorm.DB, key []byte, tokenTTL time.Duration) *Authority {
+	if tokenTTL <= 0 {
+		tokenTTL = DefaultTokenTTL
+	}
+	return &Authority{db: db, key: key, tokenTTL: tokenTTL}
+}
+
+// LoadOrCreateKey 读取签名密钥，文件不存在时随机生成并以0600权限写入
+// 密钥需跨重启保持不变，否则已部署的Agent需重新PXE引导才能取得凭据
+func LoadOrCreateKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < keySize {
+			return nil, fmt.Errorf("invalid agent key file %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// TokenTTL Agent令牌有效期
+func (a *Authority) TokenTTL() time.Duration {
+	return a.tokenTTL
+}
+
+// IssueBootstrap 为MAC签发一次性引导令牌，同一MAC此前未使用的引导令牌作废
+// sourceIP 为获取iPXE脚本的地址，bound 表示已确认请求方就是该机器
+func (a *Authority) IssueBootstrap(mac, sourceIP string, bound bool) (string, error) {
+	token, err := randomString(24)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		// 顺带清理过期引导令牌
+		if err := tx.Where("mac_address = ? OR expires_at < ?", mac, now).Delete(&models.AgentBootstrapToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AgentBootstrapToken{
+			TokenHash:  digest(token),
+			MacAddress: mac,
+			SourceIP:   sourceIP,
+			Bound:      bound,
+			ExpiresAt:  now.Add(BootstrapTTL),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeBootstrap 校验并作废引导令牌（并发使用时只有一个调用成功）
+// 注册请求须来自获取iPXE脚本的同一地址
+func (a *Authority) ConsumeBootstrap(token, mac, sourceIP string) (*Bootstrap, error) {
+	if token == "" {
+		return nil, ErrInvalidBootstrap
+	}
+
+	var bootstrap *Bootstrap
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		var record models.AgentBootstrapToken
+		err := tx.Where("token_hash = ? AND mac_address = ? AND source_ip = ? AND expires_at > ?",
+			digest(token), mac, sourceIP, time.Now()).First(&record).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidBootstrap
+		}
+		if err != nil {
+			return err
+		}
+		result := tx.Where("token_hash = ?", record.TokenHash).Delete(&models.AgentBootstrapToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrInvalidBootstrap
+		}
+		bootstrap = &Bootstrap{Bound: record.Bound}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bootstrap, nil
+}
+
+// Enrolled 机器是否已有Agent凭据
+func (a *Authority) Enrolled(machineID string) bool {
+	var count int64
+	a.db.Model(&models.AgentCredential{}).Where("machine_id = ?", machineID).Count(&count)
+	return count > 0
+}
+
+// ApproveReenroll 运维批准机器重新注册：ttl 内iPXE为该机器签发绑定的引导令牌，
+// Agent用它注册时轮换凭据（机器更换了地址或需要吊销旧凭据时使用）
+func (a *Authority) ApproveReenroll(machineID string, ttl time.Duration) (time.Time, error) {
+	until := time.Now().Add(ttl)
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		var cred models.AgentCredential
+		err := tx.Where("machine_id = ?", machineID).First(&cred).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 尚未注册的机器首次注册无需批准
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&cred).Update("reenroll_until", until).Error
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+// ReenrollApproved 机器是否处于运维批准的重新注册期
+func (a *Authority) ReenrollApproved(machineID string) bool {
+	var count int64
+	a.db.Model(&models.AgentCredential{}).
+		Where("machine_id = ? AND reenroll_until > ?", machineID, time.Now()).Count(&count)
+	return count > 0
+}
+
+// CheckBootstrap 机器注册前校验引导令牌：已注册的机器不接受未绑定的引导令牌
+// 在修改机器记录之前调用，避免被拒绝的注册请求篡改机器信息
+func (a *Authority) CheckBootstrap(machineID string, bootstrap *Bootstrap) error {
+	if bootstrap != nil && !bootstrap.Bound && a.Enrolled(machineID) {
+		return ErrAlreadyEnrolled
+	}
+	return nil
+}
+
+// Issue 为机器签发Agent令牌
+//
+// bootstrap 为nil时（持本机有效的Agent令牌）沿用当前凭据版本。用引导令牌换取时：
+// 首次注册创建凭据；已注册的机器只接受绑定的引导令牌（否则返回 ErrAlreadyEnrolled），
+// 处于运维批准的重新注册期时提升凭据版本，使此前签发的令牌全部失效，批准随之作废。
+func (a *Authority) Issue(machine *models.Machine, bootstrap *Bootstrap) (string, time.Time, error) {
+	var cred models.AgentCredential
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("machine_id = ?", machine.ID).First(&cred).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cred = models.AgentCredential{MachineID: machine.ID, Generation: 1, IssuedAt: time.Now()}
+			return tx.Create(&cred).Error
+		}
+		if err != nil {
+			return err
+		}
+		if bootstrap == nil {
+			return nil
+		}
+		if !bootstrap.Bound {
+			return ErrAlreadyEnrolled
+		}
+		if cred.ReenrollUntil == nil || !cred.ReenrollUntil.After(time.Now()) {
+			return nil
+		}
+		return tx.Model(&cred).Updates(map[string]interface{}{
+			"generation":     gorm.Expr("generation + 1"),
+			"issued_at":      time.Now(),
+			"reenroll_until": nil,
+		}).Error
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := a.db.Where("machine_id = ?", machine.ID).First(&cred).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(a.tokenTTL)
+	token, err := a.sign(Claims{
+		MachineID:  machine.ID,
+		MacAddress: machine.MacAddress,
+		Generation: cred.Generation,
+		ExpiresAt:  expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Verify 校验Agent令牌：签名、有效期、机器仍存在且MAC一致、凭据版本未被轮换
+func (a *Authority) Verify(token string) (*Claims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(a.signature(payload))) {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.MachineID == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	var machine models.Machine
+	if err := a.db.Select("id", "mac_address").Where("id = ?", claims.MachineID).First(&machine).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !strings.EqualFold(machine.MacAddress, claims.MacAddress) {
+		return nil, ErrInvalidToken
+	}
+	var cred models.AgentCredential
+	if err := a.db.Where("machine_id = ?", claims.MachineID).First(&cred).Error; err != nil || cred.Generation != claims.Generation {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// InstallerToken 为安装任务签发安装器凭据（绑定任务ID和机器ID）
+// 凭据渲染进 Kickstart/AutoYaST/Preseed/Autoinstall 配置，安装器上报进度时携带；
+// 任务结束后该机器不再有对应的安装任务，凭据随之失效
+func (a *Authority) InstallerToken(jobID, machineID string) string {
+	return a.signature("installer\n" + jobID + "\n" + machineID)
+}
+
+// VerifyInstaller 校验安装器凭据是否属于指定任务和机器
+func (a *Authority) VerifyInstaller(token, jobID, machineID string) bool {
+	if token == "" || jobID == "" || machineID == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(a.InstallerToken(jobID, machineID)))
+}
+
+// sign 生成令牌：base64url(claims).base64url(hmac)
+func (a *Authority) sign(claims Claims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + a.signature(payload), nil
+}
+
+func (a *Authority) signature(payload string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomString 生成 n 字节随机数的URL安全编码
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// digest 令牌摘要（令牌本身为高熵随机数，无需加盐）
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,249 @@
+package agentauth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAuthority(t *testing.T) (*Authority, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Machine{}, &models.AgentBootstrapToken{}, &models.AgentCredential{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return New(db, []byte("0123456789abcdef0123456789abcdef"), time.Hour), db
+}
+
+func createMachine(t *testing.T, db *gorm.DB, id, mac string) *models.Machine {
+	t.Helper()
+	machine := &models.Machine{ID: id, Hostname: id, MacAddress: mac, Status: models.MachineStatusDiscovered}
+	if err := db.Create(machine).Error; err != nil {
+		t.Fatalf("Failed to create machine: %v", err)
+	}
+	return machine
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "agent.key")
+
+	key, err := LoadOrCreateKey(path)
+	if err != nil || len(key) != keySize {
+		t.Fatalf("LoadOrCreateKey() = %d bytes, %v", len(key), err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	again, err := LoadOrCreateKey(path)
+	if err != nil || string(again) != string(key) {
+		t.Errorf("second LoadOrCreateKey() returned a different key, err = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not-hex"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateKey(path); err == nil {
+		t.Error("LoadOrCreateKey() with corrupt file error = nil")
+	}
+}
+
+func TestAuthority_Bootstrap(t *testing.T) {
+	a, db := setupAuthority(t)
+	mac := "aa:bb:cc:dd:ee:01"
+
+	first, err := a.IssueBootstrap(mac, "10.0.0.5", false)
+	if err != nil {
+		t.Fatalf("IssueBootstrap() error = %v", err)
+	}
+	second, err := a.IssueBootstrap(mac, "10.0.0.5", true)
+	if err != nil {
+		t.Fatalf("IssueBootstrap() error = %v", err)
+	}
+	expired, err := a.IssueBootstrap("aa:bb:cc:dd:ee:02", "10.0.0.6", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.AgentBootstrapToken{}).Where("mac_address = ?", "aa:bb:cc:dd:ee:02").
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name     string
+		token    string
+		mac      string
+		sourceIP string
+		wantErr  bool
+	}{
+		{"Replaced by newer token", first, mac, "10.0.0.5", true},
+		{"Wrong MAC", second, "aa:bb:cc:dd:ee:99", "10.0.0.5", true},
+		{"Wrong source address", second, mac, "10.0.0.66", true},
+		{"Valid", second, mac, "10.0.0.5", false},
+		{"Already used", second, mac, "10.0.0.5", true},
+		{"Expired", expired, "aa:bb:cc:dd:ee:02", "10.0.0.6", true},
+		{"Empty", "", mac, "10.0.0.5", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bootstrap, err := a.ConsumeBootstrap(tt.token, tt.mac, tt.sourceIP)
+			if tt.wantErr && !errors.Is(err, ErrInvalidBootstrap) {
+				t.Errorf("ConsumeBootstrap() error = %v, want ErrInvalidBootstrap", err)
+			}
+			if !tt.wantErr && (err != nil || !bootstrap.Bound) {
+				t.Errorf("ConsumeBootstrap() = %+v, %v, want bound bootstrap", bootstrap, err)
+			}
+		})
+	}
+}
+
+func TestAuthority_IssueVerify(t *testing.T) {
+	a, db := setupAuthority(t)
+	machine := createMachine(t, db, "m-1", "aa:bb:cc:dd:ee:01")
+
+	token, expiresAt, err := a.Issue(machine, &Bootstrap{})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if time.Until(expiresAt) <= 0 || time.Until(expiresAt) > time.Hour {
+		t.Errorf("Issue() expiresAt = %v, want within 1h", expiresAt)
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.MachineID != machine.ID || claims.MacAddress != machine.MacAddress {
+		t.Errorf("Verify() claims = %+v", claims)
+	}
+
+	// 刷新不轮换：旧令牌仍然有效
+	refreshed, _, err := a.Issue(machine, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Verify(token); err != nil {
+		t.Errorf("Verify() after refresh error = %v", err)
+	}
+
+	// 已注册的机器：未绑定的引导令牌被拒绝，绑定的引导令牌不轮换凭据
+	if _, _, err := a.Issue(machine, &Bootstrap{}); !errors.Is(err, ErrAlreadyEnrolled) {
+		t.Errorf("Issue() with unbound bootstrap error = %v, want ErrAlreadyEnrolled", err)
+	}
+	if _, _, err := a.Issue(machine, &Bootstrap{Bound: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Verify(token); err != nil {
+		t.Errorf("Verify() after bound bootstrap without approval error = %v", err)
+	}
+
+	// 运维批准重新注册后轮换：此前的令牌全部失效，批准只生效一次
+	if !a.Enrolled(machine.ID) || a.ReenrollApproved(machine.ID) {
+		t.Fatal("machine should be enrolled without pending approval")
+	}
+	if _, err := a.ApproveReenroll(machine.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !a.ReenrollApproved(machine.ID) {
+		t.Fatal("ReenrollApproved() = false after ApproveReenroll()")
+	}
+	rotated, _, err := a.Issue(machine, &Bootstrap{Bound: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ReenrollApproved(machine.ID) {
+		t.Error("approval should be consumed by the rotation")
+	}
+	for _, old := range []string{token, refreshed} {
+		if _, err := a.Verify(old); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify() old token after rotate error = %v, want ErrInvalidToken", err)
+		}
+	}
+	if _, err := a.Verify(rotated); err != nil {
+		t.Errorf("Verify() rotated token error = %v", err)
+	}
+}
+
+func TestAuthority_VerifyRejects(t *testing.T) {
+	a, db := setupAuthority(t)
+	machine := createMachine(t, db, "m-1", "aa:bb:cc:dd:ee:01")
+	moved := createMachine(t, db, "m-2", "aa:bb:cc:dd:ee:02")
+	gone := createMachine(t, db, "m-3", "aa:bb:cc:dd:ee:03")
+
+	valid, _, err := a.Issue(machine, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	movedToken, _, _ := a.Issue(moved, nil)
+	db.Model(moved).Update("mac_address", "aa:bb:cc:dd:ee:22")
+	goneToken, _, _ := a.Issue(gone, nil)
+	db.Delete(gone)
+
+	otherKey := New(db, []byte("another-key-another-key-another!!"), time.Hour)
+	forged, _, _ := otherKey.Issue(machine, nil)
+
+	short := New(db, a.key, time.Nanosecond)
+	expired, _, _ := short.Issue(machine, nil)
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Empty", ""},
+		{"No signature", payload},
+		{"Tampered payload", payload + "x." + sig},
+		{"Wrong key", forged},
+		{"Expired", expired},
+		{"MAC changed", movedToken},
+		{"Machine deleted", goneToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestAuthority_InstallerToken(t *testing.T) {
+	a, db := setupAuthority(t)
+	token := a.InstallerToken("job-1", "machine-1")
+	otherKey := New(db, []byte("another-key-another-key-another!!"), time.Hour)
+
+	tests := []struct {
+		name      string
+		token     string
+		jobID     string
+		machineID string
+		want      bool
+	}{
+		{"Valid", token, "job-1", "machine-1", true},
+		{"Empty", "", "job-1", "machine-1", false},
+		{"Other job", token, "job-2", "machine-1", false},
+		{"Other machine", token, "job-1", "machine-2", false},
+		{"Wrong key", otherKey.InstallerToken("job-1", "machine-1"), "job-1", "machine-1", false},
+		{"Empty job", a.InstallerToken("", "machine-1"), "", "machine-1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.VerifyInstaller(tt.token, tt.jobID, tt.machineID); got != tt.want {
+				t.Errorf("VerifyInstaller() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
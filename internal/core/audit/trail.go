@@ -0,0 +1,293 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+// genesisHash is the PrevHash of the first event in the chain
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// verifyBatchSize bounds the number of events loaded per query during Verify
+const verifyBatchSize = 500
+
+// ignoredFields are excluded from diffs because they change on every write
+var ignoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// chainMu serializes appends so that seq and prev_hash are assigned without gaps
+var chainMu sync.Mutex
+
+// FieldChange is the before/after value of a single field
+type FieldChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Filter selects audit events for Query
+type Filter struct {
+	Actor        string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	RequestID    string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+	Offset       int
+}
+
+// VerifyResult describes the outcome of a chain verification
+type VerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Events   int    `json:"events"`
+	HeadSeq  uint64 `json:"head_seq"`
+	HeadHash string `json:"head_hash"`
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Snapshot captures the JSON form of v at call time, so that later mutations
+// of the same object do not leak into the "before" side of a diff
+func Snapshot(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Record appends an event to the audit chain. The diff between before and
+// after (either may be nil) is stored as the event's changes; seq, timestamp
+// and hashes are assigned here.
+func Record(db *gorm.DB, event *models.AuditEvent, before, after interface{}) error {
+	changes, err := Diff(before, after)
+	if err != nil {
+		return fmt.Errorf("failed to diff audit event: %w", err)
+	}
+	event.Changes = changes
+	if event.ResourceType == "" {
+		event.ResourceType, _, _ = strings.Cut(event.Action, ".")
+	}
+
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var last []models.AuditEvent
+		if err := tx.Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+
+		event.Seq = 1
+		event.PrevHash = genesisHash
+		if len(last) > 0 {
+			event.Seq = last[0].Seq + 1
+			event.PrevHash = last[0].Hash
+		}
+		// 截断到微秒，保证存储往返后哈希仍一致
+		event.Timestamp = time.Now().UTC().Truncate(time.Microsecond)
+		event.Hash = computeHash(event)
+
+		return tx.Create(event).Error
+	})
+}
+
+// Diff returns the top-level fields that differ between the JSON forms of
+// before and after, as a JSON object of FieldChange. It returns nil when
+// nothing changed.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	b, err := toFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]FieldChange)
+	for key, value := range b {
+		if ignoredFields[key] {
+			continue
+		}
+		if other, ok := a[key]; !ok || !bytes.Equal(value, other) {
+			changes[key] = FieldChange{Before: value, After: a[key]}
+		}
+	}
+	for key, value := range a {
+		if ignoredFields[key] {
+			continue
+		}
+		if _, ok := b[key]; !ok {
+			changes[key] = FieldChange{After: value}
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+// toFields decodes the JSON object form of v into compacted top-level fields
+func toFields(v interface{}) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("audit value must be a JSON object: %w", err)
+	}
+	fields := make(map[string]json.RawMessage, len(raw))
+	for key, value := range raw {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, value); err != nil {
+			return nil, err
+		}
+		fields[key] = buf.Bytes()
+	}
+	return fields, nil
+}
+
+// Verify walks the whole chain and checks seq continuity, prev_hash linkage
+// and every event's hash. It cannot detect removal of the newest events on
+// its own; operators should anchor HeadHash somewhere outside the database
+// (e.g. a ticket or a periodic export) and compare it on later runs.
+func Verify(db *gorm.DB) (*VerifyResult, error) {
+	result := &VerifyResult{Valid: true, HeadHash: genesisHash}
+
+	var lastSeq uint64
+	for {
+		var batch []models.AuditEvent
+		if err := db.Where("seq > ?", lastSeq).Order("seq").Limit(verifyBatchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+
+		for i := range batch {
+			event := &batch[i]
+			switch {
+			case event.Seq != lastSeq+1:
+				return result.broken(event.Seq, fmt.Sprintf("sequence gap: expected %d", lastSeq+1)), nil
+			case event.PrevHash != result.HeadHash:
+				return result.broken(event.Seq, "prev_hash does not match previous event"), nil
+			case event.Hash != computeHash(event):
+				return result.broken(event.Seq, "hash mismatch, event content was modified"), nil
+			}
+			lastSeq = event.Seq
+			result.Events++
+			result.HeadSeq = event.Seq
+			result.HeadHash = event.Hash
+		}
+
+		if len(batch) < verifyBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// broken marks the result invalid at the given seq
+func (r *VerifyResult) broken(seq uint64, reason string) *VerifyResult {
+	r.Valid = false
+	r.BrokenAt = seq
+	r.Reason = reason
+	return r
+}
+
+// Query returns matching events (newest first) and the total match count
+func Query(db *gorm.DB, f Filter) ([]models.AuditEvent, int64, error) {
+	query := db.Model(&models.AuditEvent{})
+	if f.Actor != "" {
+		query = query.Where("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if f.ResourceType != "" {
+		query = query.Where("resource_type = ?", f.ResourceType)
+	}
+	if f.ResourceID != "" {
+		query = query.Where("resource_id = ?", f.ResourceID)
+	}
+	if f.RequestID != "" {
+		query = query.Where("request_id = ?", f.RequestID)
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("timestamp >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("timestamp < ?", f.Until.UTC())
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query = query.Order("seq DESC")
+	if f.Limit > 0 {
+		query = query.Limit(f.Limit).Offset(f.Offset)
+	}
+	var events []models.AuditEvent
+	if err := query.Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// EnsureAppendOnly installs SQLite triggers that reject UPDATE and DELETE on
+// the audit table, so rows can only be appended through the application
+func EnsureAppendOnly(db *gorm.DB) error {
+	for _, op := range []string{"UPDATE", "DELETE"} {
+		stmt := fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS audit_events_no_%s
+			BEFORE %s ON audit_events
+			BEGIN
+				SELECT RAISE(ABORT, 'audit_events is append-only');
+			END`, strings.ToLower(op), op)
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to create audit trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+// computeHash hashes every field of the event except Hash itself
+func computeHash(e *models.AuditEvent) string {
+	// 固定字段顺序，避免结构体调整影响历史记录的哈希
+	payload, _ := json.Marshal([]interface{}{
+		e.Seq,
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.ActorToken,
+		e.SourceIP,
+		e.RequestID,
+		e.Action,
+		e.ResourceType,
+		e.ResourceID,
+		string(e.Changes),
+		e.PrevHash,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
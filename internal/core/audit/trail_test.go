@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTrailDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "audit.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.AuditEvent{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return db
+}
+
+func record(t *testing.T, db *gorm.DB, actor, action, id string, before, after interface{}) *models.AuditEvent {
+	t.Helper()
+	event := &models.AuditEvent{Actor: actor, Action: action, ResourceID: id, SourceIP: "10.0.0.1", RequestID: "req-" + id}
+	if err := Record(db, event, before, after); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	return event
+}
+
+func TestDiff(t *testing.T) {
+	type item struct {
+		Name      string            `json:"name"`
+		Tags      map[string]string `json:"tags,omitempty"`
+		UpdatedAt time.Time         `json:"updated_at"`
+	}
+
+	tests := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   map[string]FieldChange
+	}{
+		{
+			name:  "create",
+			after: item{Name: "a"},
+			want: map[string]FieldChange{
+				"name": {After: json.RawMessage(`"a"`)},
+			},
+		},
+		{
+			name:   "delete",
+			before: item{Name: "a"},
+			want: map[string]FieldChange{
+				"name": {Before: json.RawMessage(`"a"`)},
+			},
+		},
+		{
+			name:   "update ignores updated_at",
+			before: item{Name: "a", UpdatedAt: time.Unix(1, 0)},
+			after:  item{Name: "b", Tags: map[string]string{"k": "v"}, UpdatedAt: time.Unix(2, 0)},
+			want: map[string]FieldChange{
+				"name": {Before: json.RawMessage(`"a"`), After: json.RawMessage(`"b"`)},
+				"tags": {After: json.RawMessage(`{"k":"v"}`)},
+			},
+		},
+		{
+			name:   "snapshot is not affected by later mutation",
+			before: Snapshot(item{Name: "a"}),
+			after:  item{Name: "a"},
+			want:   nil,
+		},
+		{
+			name: "nothing",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("Diff() = %s, want nil", got)
+				}
+				return
+			}
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("Diff() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	db := setupTrailDB(t)
+
+	result, err := Verify(db)
+	if err != nil || !result.Valid || result.Events != 0 || result.HeadHash != genesisHash {
+		t.Fatalf("Verify(empty) = %+v, %v", result, err)
+	}
+
+	first := record(t, db, "admin", "machine.create", "m1", nil, map[string]string{"hostname": "node1"})
+	second := record(t, db, "admin", "machine.update", "m1",
+		map[string]string{"hostname": "node1"}, map[string]string{"hostname": "node2"})
+
+	if first.Seq != 1 || second.Seq != 2 {
+		t.Errorf("seq = %d, %d, want 1, 2", first.Seq, second.Seq)
+	}
+	if first.PrevHash != genesisHash || second.PrevHash != first.Hash {
+		t.Error("events are not chained")
+	}
+	if first.ResourceType != "machine" {
+		t.Errorf("ResourceType = %q, want machine", first.ResourceType)
+	}
+
+	result, err = Verify(db)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !result.Valid || result.Events != 2 || result.HeadSeq != 2 || result.HeadHash != second.Hash {
+		t.Errorf("Verify() = %+v", result)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(db *gorm.DB)
+		wantAt uint64
+	}{
+		{
+			name: "modified field",
+			tamper: func(db *gorm.DB) {
+				db.Model(&models.AuditEvent{}).Where("seq = ?", 2).Update("actor", "someone-else")
+			},
+			wantAt: 2,
+		},
+		{
+			name: "deleted middle event",
+			tamper: func(db *gorm.DB) {
+				db.Where("seq = ?", 2).Delete(&models.AuditEvent{})
+			},
+			wantAt: 3,
+		},
+		{
+			name: "rewritten chain link",
+			tamper: func(db *gorm.DB) {
+				db.Model(&models.AuditEvent{}).Where("seq = ?", 3).Update("prev_hash", genesisHash)
+			},
+			wantAt: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTrailDB(t)
+			for _, id := range []string{"p1", "p2", "p3"} {
+				record(t, db, "admin", "profile.create", id, nil, map[string]string{"id": id})
+			}
+
+			tt.tamper(db)
+
+			result, err := Verify(db)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if result.Valid || result.BrokenAt != tt.wantAt {
+				t.Errorf("Verify() = %+v, want broken at %d", result, tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestEnsureAppendOnly(t *testing.T) {
+	db := setupTrailDB(t)
+	if err := EnsureAppendOnly(db); err != nil {
+		t.Fatalf("EnsureAppendOnly() error = %v", err)
+	}
+	// 重复调用应幂等
+	if err := EnsureAppendOnly(db); err != nil {
+		t.Fatalf("EnsureAppendOnly() second call error = %v", err)
+	}
+
+	record(t, db, "admin", "machine.delete", "m1", map[string]string{"id": "m1"}, nil)
+	record(t, db, "admin", "machine.delete", "m2", map[string]string{"id": "m2"}, nil)
+
+	if err := db.Model(&models.AuditEvent{}).Where("seq = ?", 1).Update("actor", "x").Error; err == nil {
+		t.Error("UPDATE on audit_events should be rejected")
+	}
+	if err := db.Where("seq = ?", 1).Delete(&models.AuditEvent{}).Error; err == nil {
+		t.Error("DELETE on audit_events should be rejected")
+	}
+
+	result, err := Verify(db)
+	if err != nil || !result.Valid || result.Events != 2 {
+		t.Errorf("Verify() = %+v, %v", result, err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := setupTrailDB(t)
+	record(t, db, "alice", "machine.create", "m1", nil, map[string]string{"id": "m1"})
+	record(t, db, "bob", "machine.delete", "m1", map[string]string{"id": "m1"}, nil)
+	record(t, db, "alice", "profile.create", "p1", nil, map[string]string{"id": "p1"})
+
+	tests := []struct {
+		name      string
+		filter    Filter
+		wantTotal int64
+		wantFirst string
+	}{
+		{name: "all newest first", filter: Filter{}, wantTotal: 3, wantFirst: "profile.create"},
+		{name: "by actor", filter: Filter{Actor: "alice"}, wantTotal: 2, wantFirst: "profile.create"},
+		{name: "by resource", filter: Filter{ResourceType: "machine", ResourceID: "m1"}, wantTotal: 2, wantFirst: "machine.delete"},
+		{name: "by action", filter: Filter{Action: "machine.create"}, wantTotal: 1, wantFirst: "machine.create"},
+		{name: "by request", filter: Filter{RequestID: "req-p1"}, wantTotal: 1, wantFirst: "profile.create"},
+		{name: "paged", filter: Filter{Limit: 1, Offset: 1}, wantTotal: 3, wantFirst: "machine.delete"},
+		{name: "since future", filter: Filter{Since: time.Now().Add(time.Hour)}, wantTotal: 0},
+		{name: "until future", filter: Filter{Until: time.Now().Add(time.Hour)}, wantTotal: 3, wantFirst: "profile.create"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, total, err := Query(db, tt.filter)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", total, tt.wantTotal)
+			}
+			if tt.wantFirst == "" {
+				if len(events) != 0 {
+					t.Errorf("got %d events, want 0", len(events))
+				}
+				return
+			}
+			if len(events) == 0 || events[0].Action != tt.wantFirst {
+				t.Errorf("first event = %+v, want action %s", events, tt.wantFirst)
+			}
+		})
+	}
+}
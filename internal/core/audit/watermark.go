@@ -42,6 +42,13 @@ func NewWatermarkValidator(licenseID string, auditLogPath string) (*WatermarkVal
 	}, nil
 }
 
+// SetLicenseID updates the license ID that watermarks are checked against
+func (v *WatermarkValidator) SetLicenseID(licenseID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.currentLicenseID = licenseID
+}
+
 // ValidateWatermark checks if a watermark matches the current license
 func (v *WatermarkValidator) ValidateWatermark(providerID string, providerName string, watermark Watermark) (*WatermarkViolation, error) {
 	v.mu.RLock()
@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 令牌权限范围
+const (
+	ScopeRead  = "read"  // 只读接口
+	ScopeWrite = "write" // 变更接口
+	ScopeAdmin = "admin" // 用户与角色管理
+)
+
+// Scopes 全部权限范围
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// TokenPrefix API令牌明文前缀
+const TokenPrefix = "cbt_"
+
+// DefaultSessionTTL 登录会话默认有效期
+const DefaultSessionTTL = 12 * time.Hour
+
+// MinPasswordLength 密码最小长度
+const MinPasswordLength = 8
+
+var (
+	// ErrInvalidCredentials 用户名或密码错误（不区分具体原因，避免泄露用户是否存在）
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrUnauthenticated 会话或令牌无效、过期或已吊销
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrWeakPassword 密码不满足要求
+	ErrWeakPassword = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	// ErrInvalidScope 未知的令牌范围
+	ErrInvalidScope = errors.New("invalid token scope")
+	// ErrScopeDenied 令牌范围超出调用者权限
+	ErrScopeDenied = errors.New("token scope exceeds caller permissions")
+)
+
+// dummyHash 用户不存在时参与比对，使登录耗时与用户是否存在无关
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("cloudboot-dummy-password"), bcrypt.DefaultCost)
+
+// Principal 已认证的调用者
+type Principal struct {
+	User        *models.User
+	Token       *models.APIToken // 使用API令牌认证时非空
+	Permissions []string         // 用户角色授予的权限
+}
+
+// Can 检查令牌范围是否允许（会话登录不受范围限制）
+func (p *Principal) Can(scope string) bool {
+	if p == nil || p.User == nil {
+		return false
+	}
+	return p.Token == nil || slices.Contains(p.Token.Scopes, scope)
+}
+
+// Has 检查调用者是否具备权限：角色授予该权限，且令牌范围覆盖该权限
+func (p *Principal) Has(perm string) bool {
+	return p.Can(ScopeFor(perm)) && grants(p.Permissions, perm)
+}
+
+// Service 用户、会话与API令牌管理
+type Service struct {
+	db         *gorm.DB
+	sessionTTL time.Duration
+}
+
+// NewService 创建认证服务
+func NewService(db *gorm.DB, sessionTTL time.Duration) *Service {
+	if sessionTTL <= 0 {
+		sessionTTL = DefaultSessionTTL
+	}
+	return &Service{db: db, sessionTTL: sessionTTL}
+}
+
+// HashPassword 使用bcrypt计算密码摘要
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// EnsureAdmin 首次启动（用户表为空）时创建初始管理员
+// password 为空时随机生成，返回生成的密码；已有用户时不做任何操作
+func (s *Service) EnsureAdmin(username, password string) (user *models.User, generated string, err error) {
+	var count int64
+	if err := s.db.Model(&models.User{}).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count > 0 {
+		return nil, "", nil
+	}
+
+	if password == "" {
+		if password, err = randomString(18); err != nil {
+			return nil, "", err
+		}
+		generated = password
+	}
+	user = &models.User{Username: username, Roles: []string{RoleAdmin}}
+	if err := s.CreateUser(user, password); err != nil {
+		return nil, "", err
+	}
+	return user, generated, nil
+}
+
+// CreateUser 创建用户，ID和密码摘要由此处生成
+func (s *Service) CreateUser(user *models.User, password string) error {
+	user.Username = strings.TrimSpace(user.Username)
+	if user.Username == "" {
+		return errors.New("username is required")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.ID = uuid.New().String()
+	user.PasswordHash = hash
+	return s.db.Create(user).Error
+}
+
+// SetPassword 修改用户密码并注销其全部会话
+func (s *Service) SetPassword(userID, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+	})
+}
+
+// RevokeSessions 注销用户的全部会话
+func (s *Service) RevokeSessions(userID string) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+}
+
+// DeleteUser 删除用户及其会话和API令牌
+func (s *Service) DeleteUser(userID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.APIToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", userID).Delete(&models.User{}).Error
+	})
+}
+
+// Authenticate 校验用户名和密码
+func (s *Service) Authenticate(username, password string) (*models.User, error) {
+	var user models.User
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// CreateSession 为用户创建登录会话，返回写入Cookie的会话令牌
+func (s *Service) CreateSession(user *models.User, sourceIP string) (string, *models.Session, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:        digest(token),
+		UserID:    user.ID,
+		SourceIP:  sourceIP,
+		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 顺带清理过期会话
+		if err := tx.Where("expires_at < ?", now).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Update("last_login_at", now).Error
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// ResolveSession 根据会话令牌查找用户
+func (s *Service) ResolveSession(token string) (*Principal, error) {
+	var session models.Session
+	if token == "" || s.db.Where("id = ?", digest(token)).First(&session).Error != nil {
+		return nil, ErrUnauthenticated
+	}
+	if time.Now().After(session.ExpiresAt) {
+		s.db.Delete(&session)
+		return nil, ErrUnauthenticated
+	}
+	user, err := s.activeUser(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.principal(user, nil)
+}
+
+// DeleteSession 注销会话
+func (s *Service) DeleteSession(token string) error {
+	return s.db.Where("id = ?", digest(token)).Delete(&models.Session{}).Error
+}
+
+// CreateToken 为调用者签发API令牌，明文只在此时返回一次
+// 令牌范围不能超出调用者：通过令牌调用时受其范围限制，且用户角色须有该范围内的权限
+// ttl 为0表示不过期
+func (s *Service) CreateToken(p *Principal, name string, scopes []string, ttl time.Duration) (string, *models.APIToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if !p.Can(scope) || !grantsScope(p.Permissions, scope) {
+			return "", nil, fmt.Errorf("%w: %s", ErrScopeDenied, scope)
+		}
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	plaintext := TokenPrefix + secret
+
+	token := &models.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    p.User.ID,
+		Name:      name,
+		Prefix:    plaintext[:len(TokenPrefix)+6],
+		TokenHash: digest(plaintext),
+		Scopes:    scopes,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return "", nil, err
+	}
+	return plaintext, token, nil
+}
+
+// ResolveToken 根据API令牌明文查找用户
+func (s *Service) ResolveToken(plaintext string) (*Principal, error) {
+	if !strings.HasPrefix(plaintext, TokenPrefix) {
+		return nil, ErrUnauthenticated
+	}
+	var token models.APIToken
+	if err := s.db.Where("token_hash = ?", digest(plaintext)).First(&token).Error; err != nil {
+		return nil, ErrUnauthenticated
+	}
+	if token.IsExpired() {
+		return nil, ErrUnauthenticated
+	}
+	user, err := s.activeUser(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.db.Model(&token).Update("last_used_at", now)
+	token.LastUsedAt = &now
+	return s.principal(user, &token)
+}
+
+// activeUser 查找未禁用的用户
+func (s *Service) activeUser(id string) (*models.User, error) {
+	var user models.User
+	if err := s.db.Where("id = ?", id).First(&user).Error; err != nil || user.Disabled {
+		return nil, ErrUnauthenticated
+	}
+	return &user, nil
+}
+
+// randomString 生成 n 字节随机数的URL安全编码
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// digest 令牌摘要（令牌本身为高熵随机数，无需加盐）
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupService(t *testing.T) (*Service, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Role{}, &models.APIToken{}, &models.Session{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	svc := NewService(db, time.Hour)
+	if err := svc.SyncBuiltinRoles(); err != nil {
+		t.Fatalf("Failed to sync builtin roles: %v", err)
+	}
+	return svc, db
+}
+
+func TestService_EnsureAdmin(t *testing.T) {
+	svc, _ := setupService(t)
+
+	user, generated, err := svc.EnsureAdmin("admin", "")
+	if err != nil {
+		t.Fatalf("EnsureAdmin() error = %v", err)
+	}
+	if user == nil || !user.HasRole(RoleAdmin) || generated == "" {
+		t.Fatalf("EnsureAdmin() = %+v, %q, want admin with generated password", user, generated)
+	}
+	if _, err := svc.Authenticate("admin", generated); err != nil {
+		t.Errorf("Authenticate() with generated password error = %v", err)
+	}
+
+	// 已有用户时不再创建
+	user, generated, err = svc.EnsureAdmin("admin2", "another-password")
+	if err != nil || user != nil || generated != "" {
+		t.Errorf("second EnsureAdmin() = %v, %q, %v, want no-op", user, generated, err)
+	}
+}
+
+func TestService_Authenticate(t *testing.T) {
+	svc, db := setupService(t)
+
+	if err := svc.CreateUser(&models.User{Username: "alice"}, "alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	disabled := &models.User{Username: "bob"}
+	if err := svc.CreateUser(disabled, "bob-password"); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(disabled).Update("disabled", true)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{"Valid", "alice", "alice-password", false},
+		{"Wrong password", "alice", "wrong-password", true},
+		{"Unknown user", "mallory", "alice-password", true},
+		{"Disabled user", "bob", "bob-password", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := svc.Authenticate(tt.username, tt.password)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Errorf("Authenticate() error = %v, want ErrInvalidCredentials", err)
+				}
+				return
+			}
+			if err != nil || user.Username != tt.username {
+				t.Errorf("Authenticate() = %v, %v", user, err)
+			}
+		})
+	}
+
+	if err := svc.CreateUser(&models.User{Username: "carol"}, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("CreateUser() with short password error = %v, want ErrWeakPassword", err)
+	}
+}
+
+func TestService_Sessions(t *testing.T) {
+	svc, db := setupService(t)
+
+	user := &models.User{Username: "alice"}
+	if err := svc.CreateUser(user, "alice-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	token, session, err := svc.CreateSession(user, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if session.ID == token {
+		t.Error("session ID must not be the plaintext token")
+	}
+	p, err := svc.ResolveSession(token)
+	if err != nil || p.User.ID != user.ID || p.Token != nil {
+		t.Fatalf("ResolveSession() = %+v, %v", p, err)
+	}
+
+	// 过期会话
+	expired, _, _ := svc.CreateSession(user, "10.0.0.1")
+	db.Model(&models.Session{}).Where("id = ?", digest(expired)).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := svc.ResolveSession(expired); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ResolveSession(expired) error = %v, want ErrUnauthenticated", err)
+	}
+
+	// 修改密码注销全部会话
+	if err := svc.SetPassword(user.ID, "new-alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ResolveSession(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ResolveSession() after password change error = %v, want ErrUnauthenticated", err)
+	}
+
+	// 注销
+	token, _, _ = svc.CreateSession(user, "10.0.0.1")
+	svc.DeleteSession(token)
+	if _, err := svc.ResolveSession(token); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ResolveSession() after logout error = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestService_Tokens(t *testing.T) {
+	svc, db := setupService(t)
+
+	admin := &models.User{Username: "admin", Roles: []string{RoleAdmin}}
+	user := &models.User{Username: "alice", Roles: []string{RoleOperator}}
+	viewer := &models.User{Username: "victor", Roles: []string{RoleViewer}}
+	for _, u := range []*models.User{admin, user, viewer} {
+		if err := svc.CreateUser(u, "password-123"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	principal := func(u *models.User) *Principal {
+		p, err := svc.principal(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		scopes  []string
+		wantErr error
+	}{
+		{"Read only", user, []string{ScopeRead}, nil},
+		{"Write for operator", user, []string{ScopeRead, ScopeWrite}, nil},
+		{"Admin scope for admin", admin, []string{ScopeRead, ScopeAdmin}, nil},
+		{"Admin scope for operator", user, []string{ScopeAdmin}, ErrScopeDenied},
+		{"Write scope for viewer", viewer, []string{ScopeWrite}, ErrScopeDenied},
+		{"Unknown scope", user, []string{"root"}, ErrInvalidScope},
+		{"No scopes", user, nil, ErrInvalidScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, token, err := svc.CreateToken(principal(tt.user), "ci", tt.scopes, 0)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("CreateToken() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateToken() error = %v", err)
+			}
+			if token.TokenHash == plaintext || token.Prefix != plaintext[:len(token.Prefix)] {
+				t.Errorf("CreateToken() stored %+v for %q", token, plaintext)
+			}
+
+			p, err := svc.ResolveToken(plaintext)
+			if err != nil {
+				t.Fatalf("ResolveToken() error = %v", err)
+			}
+			if p.User.ID != tt.user.ID || p.Token.LastUsedAt == nil {
+				t.Errorf("ResolveToken() = %+v", p)
+			}
+		})
+	}
+
+	// 过期与吊销
+	plaintext, token, _ := svc.CreateToken(principal(user), "short-lived", []string{ScopeRead}, time.Hour)
+	db.Model(token).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := svc.ResolveToken(plaintext); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ResolveToken(expired) error = %v, want ErrUnauthenticated", err)
+	}
+	plaintext, token, _ = svc.CreateToken(principal(user), "revoked", []string{ScopeRead}, 0)
+	db.Delete(token)
+	if _, err := svc.ResolveToken(plaintext); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ResolveToken(revoked) error = %v, want ErrUnauthenticated", err)
+	}
+
+	// 删除用户同时吊销其令牌
+	plaintext, _, _ = svc.CreateToken(principal(user), "orphan", []string{ScopeRead}, 0)
+	if err := svc.DeleteUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ResolveToken(plaintext); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ResolveToken() after user deletion error = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestPrincipal_Can(t *testing.T) {
+	user := &models.User{Username: "alice"}
+
+	tests := []struct {
+		name  string
+		p     *Principal
+		scope string
+		want  bool
+	}{
+		{"Nil principal", nil, ScopeRead, false},
+		{"Session write", &Principal{User: user}, ScopeWrite, true},
+		{"Session admin", &Principal{User: user}, ScopeAdmin, true},
+		{"Read token read", &Principal{User: user, Token: &models.APIToken{Scopes: []string{ScopeRead}}}, ScopeRead, true},
+		{"Read token write", &Principal{User: user, Token: &models.APIToken{Scopes: []string{ScopeRead}}}, ScopeWrite, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Can(tt.scope); got != tt.want {
+				t.Errorf("Can(%s) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
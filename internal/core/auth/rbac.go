@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+// 权限（resource:action）
+const (
+	PermMachineRead      = "machine:read"
+	PermMachineWrite     = "machine:write" // 创建/更新
+	PermMachineDelete    = "machine:delete"
+	PermMachineProvision = "machine:provision"
+	PermJobRead          = "job:read" // 含任务日志
+	PermJobCancel        = "job:cancel"
+	PermProfileRead      = "profile:read"
+	PermProfileWrite     = "profile:write" // 创建/更新/删除
+	PermStoreRead        = "store:read"
+	PermStoreImport      = "store:import"
+	PermStoreDelete      = "store:delete"
+	PermBackupRead       = "backup:read"
+	PermBackupCreate     = "backup:create"
+	PermBackupRestore    = "backup:restore"
+	PermUserManage       = "user:manage"
+	PermRoleManage       = "role:manage"
+	PermAuditRead        = "audit:read"     // 查询、导出和校验操作审计
+	PermTenantRead       = "tenant:read"    // 查看租户，并可跨租户访问资源
+	PermTenantManage     = "tenant:manage"  // 管理租户、配额和资源归属
+	PermLicenseManage    = "license:manage" // 导入License
+
+	// PermAll 通配，拥有全部权限
+	PermAll = "*"
+)
+
+// Permissions 全部已定义权限
+var Permissions = []string{
+	PermMachineRead, PermMachineWrite, PermMachineDelete, PermMachineProvision,
+	PermJobRead, PermJobCancel,
+	PermProfileRead, PermProfileWrite,
+	PermStoreRead, PermStoreImport, PermStoreDelete,
+	PermBackupRead, PermBackupCreate, PermBackupRestore,
+	PermUserManage, PermRoleManage,
+	PermAuditRead,
+	PermTenantRead, PermTenantManage,
+	PermLicenseManage,
+}
+
+// 内置角色
+const (
+	RoleViewer       = "viewer"
+	RoleOperator     = "operator"
+	RoleStoreManager = "store-manager"
+	RoleAdmin        = "admin"
+)
+
+var readPermissions = []string{PermMachineRead, PermJobRead, PermProfileRead, PermStoreRead}
+
+// BuiltinRoles 内置角色，启动时同步到 roles 表（权限随版本更新）
+var BuiltinRoles = []models.Role{
+	{
+		Name:        RoleViewer,
+		Description: "只读访问机器、任务、配置模板和Provider",
+		Permissions: readPermissions,
+	},
+	{
+		Name:        RoleOperator,
+		Description: "管理机器、执行装机、编辑配置模板",
+		Permissions: append(slices.Clone(readPermissions),
+			PermMachineWrite, PermMachineDelete, PermMachineProvision, PermJobCancel, PermProfileWrite),
+	},
+	{
+		Name:        RoleStoreManager,
+		Description: "导入和删除Provider包",
+		Permissions: append(slices.Clone(readPermissions), PermStoreImport, PermStoreDelete),
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "全部权限，含用户、角色和备份管理",
+		Permissions: []string{PermAll},
+	},
+}
+
+var (
+	// ErrUnknownRole 角色不存在
+	ErrUnknownRole = errors.New("unknown role")
+	// ErrUnknownPermission 权限未定义
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// ScopeFor 权限对应的令牌范围：*:read 为read，用户/角色/租户/License管理为admin，其余为write
+func ScopeFor(perm string) string {
+	switch {
+	case strings.HasSuffix(perm, ":read"):
+		return ScopeRead
+	case perm == PermUserManage || perm == PermRoleManage || perm == PermTenantManage || perm == PermLicenseManage:
+		return ScopeAdmin
+	}
+	return ScopeWrite
+}
+
+// ValidatePermissions 检查权限是否均已定义
+func ValidatePermissions(perms []string) error {
+	for _, perm := range perms {
+		if perm != PermAll && !slices.Contains(Permissions, perm) {
+			return fmt.Errorf("%w: %s", ErrUnknownPermission, perm)
+		}
+	}
+	return nil
+}
+
+// SyncBuiltinRoles 写入/更新内置角色
+func (s *Service) SyncBuiltinRoles() error {
+	for _, role := range BuiltinRoles {
+		role.BuiltIn = true
+		err := s.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "permissions", "built_in", "updated_at"}),
+		}).Create(&role).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateRoles 检查角色是否均存在
+func (s *Service) ValidateRoles(names []string) error {
+	for _, name := range names {
+		var count int64
+		if err := s.db.Model(&models.Role{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownRole, name)
+		}
+	}
+	return nil
+}
+
+// PermissionsFor 角色授予的权限并集
+func (s *Service) PermissionsFor(roleNames []string) ([]string, error) {
+	if len(roleNames) == 0 {
+		return nil, nil
+	}
+	var roles []models.Role
+	if err := s.db.Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+
+	var perms []string
+	for _, role := range roles {
+		for _, perm := range role.Permissions {
+			if !slices.Contains(perms, perm) {
+				perms = append(perms, perm)
+			}
+		}
+	}
+	return perms, nil
+}
+
+// RoleInUse 检查角色是否仍分配给用户
+func (s *Service) RoleInUse(name string) (bool, error) {
+	var users []models.User
+	if err := s.db.Select("roles").Find(&users).Error; err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if u.HasRole(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// principal 加载用户权限
+func (s *Service) principal(user *models.User, token *models.APIToken) (*Principal, error) {
+	perms, err := s.PermissionsFor(user.Roles)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{User: user, Token: token, Permissions: perms}, nil
+}
+
+// grants 权限集合是否覆盖 perm
+func grants(perms []string, perm string) bool {
+	return slices.Contains(perms, PermAll) || slices.Contains(perms, perm)
+}
+
+// grantsScope 权限集合中是否有属于 scope 的权限
+func grantsScope(perms []string, scope string) bool {
+	for _, perm := range perms {
+		if perm == PermAll || ScopeFor(perm) == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+)
+
+func TestScopeFor(t *testing.T) {
+	tests := []struct {
+		perm string
+		want string
+	}{
+		{PermMachineRead, ScopeRead},
+		{PermStoreRead, ScopeRead},
+		{PermMachineProvision, ScopeWrite},
+		{PermBackupRestore, ScopeWrite},
+		{PermUserManage, ScopeAdmin},
+		{PermRoleManage, ScopeAdmin},
+		{PermLicenseManage, ScopeAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.perm, func(t *testing.T) {
+			if got := ScopeFor(tt.perm); got != tt.want {
+				t.Errorf("ScopeFor(%s) = %s, want %s", tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	if err := ValidatePermissions([]string{PermMachineRead, PermAll}); err != nil {
+		t.Errorf("ValidatePermissions() error = %v", err)
+	}
+	if err := ValidatePermissions([]string{"machine:explode"}); !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("ValidatePermissions() error = %v, want ErrUnknownPermission", err)
+	}
+}
+
+func TestService_PermissionsFor(t *testing.T) {
+	svc, _ := setupService(t)
+
+	perms, err := svc.PermissionsFor([]string{RoleOperator, RoleStoreManager})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{PermMachineProvision, PermStoreImport, PermMachineRead} {
+		if !slices.Contains(perms, want) {
+			t.Errorf("PermissionsFor() = %v, missing %s", perms, want)
+		}
+	}
+	if slices.Contains(perms, PermBackupRestore) {
+		t.Errorf("PermissionsFor() = %v, must not contain %s", perms, PermBackupRestore)
+	}
+
+	// 重复同步内置角色不会报错
+	if err := svc.SyncBuiltinRoles(); err != nil {
+		t.Errorf("SyncBuiltinRoles() error = %v", err)
+	}
+}
+
+func TestService_Roles(t *testing.T) {
+	svc, db := setupService(t)
+
+	if err := svc.ValidateRoles([]string{RoleViewer, RoleAdmin}); err != nil {
+		t.Errorf("ValidateRoles() error = %v", err)
+	}
+	if err := svc.ValidateRoles([]string{"superuser"}); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("ValidateRoles() error = %v, want ErrUnknownRole", err)
+	}
+
+	db.Create(&models.Role{Name: "auditor", Permissions: []string{PermBackupRead}})
+	if inUse, _ := svc.RoleInUse("auditor"); inUse {
+		t.Error("RoleInUse() = true before assignment")
+	}
+	if err := svc.CreateUser(&models.User{Username: "alice", Roles: []string{"auditor"}}, "password-123"); err != nil {
+		t.Fatal(err)
+	}
+	if inUse, _ := svc.RoleInUse("auditor"); !inUse {
+		t.Error("RoleInUse() = false after assignment")
+	}
+}
+
+func TestPrincipal_Has(t *testing.T) {
+	user := &models.User{Username: "alice"}
+	operator := []string{PermMachineRead, PermMachineProvision}
+	readToken := &models.APIToken{Scopes: []string{ScopeRead}}
+
+	tests := []struct {
+		name string
+		p    *Principal
+		perm string
+		want bool
+	}{
+		{"Nil principal", nil, PermMachineRead, false},
+		{"Granted", &Principal{User: user, Permissions: operator}, PermMachineProvision, true},
+		{"Not granted", &Principal{User: user, Permissions: operator}, PermMachineDelete, false},
+		{"Wildcard", &Principal{User: user, Permissions: []string{PermAll}}, PermRoleManage, true},
+		{"Read token allows read", &Principal{User: user, Token: readToken, Permissions: operator}, PermMachineRead, true},
+		{"Read token blocks provision", &Principal{User: user, Token: readToken, Permissions: operator}, PermMachineProvision, false},
+		{"Read token blocks wildcard admin", &Principal{User: user, Token: readToken, Permissions: []string{PermAll}}, PermUserManage, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Has(tt.perm); got != tt.want {
+				t.Errorf("Has(%s) = %v, want %v", tt.perm, got, tt.want)
+			}
+		})
+	}
+}
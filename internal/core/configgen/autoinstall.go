@@ -0,0 +1,380 @@
+package configgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// MachineContext 机器相关的渲染上下文
+type MachineContext struct {
+	MachineID    string
+	Hostname     string
+	MacAddress   string
+	IPAddress    string
+	SerialNumber string
+	ServerURL    string
+	// JobID 和 InstallToken 为安装任务及其安装器凭据，安装器上报进度时携带（预览时为空）
+	JobID        string
+	InstallToken string
+}
+
+// NewMachineContext 由机器资产构建渲染上下文，machine 为 nil 时仅包含 ServerURL
+func NewMachineContext(machine *models.Machine, serverURL string) MachineContext {
+	ctx := MachineContext{ServerURL: serverURL}
+	if machine != nil {
+		ctx.MachineID = machine.ID
+		ctx.Hostname = machine.Hostname
+		ctx.MacAddress = machine.MacAddress
+		ctx.IPAddress = machine.IPAddress
+		ctx.SerialNumber = machine.HardwareSpec.System.SerialNumber
+	}
+	return ctx
+}
+
+// hostname 返回机器主机名，未设置时由MAC生成
+func (m MachineContext) hostname() string {
+	if m.Hostname != "" {
+		return m.Hostname
+	}
+	if m.MacAddress == "" {
+		return "cloudboot"
+	}
+	return "cloudboot-" + strings.ReplaceAll(m.MacAddress, ":", "")
+}
+
+// NoCloudSeed cloud-init NoCloud 数据源的三个文件
+type NoCloudSeed struct {
+	UserData   string
+	MetaData   string
+	VendorData string
+}
+
+// autoinstallConfig Ubuntu Subiquity autoinstall v1
+type autoinstallConfig struct {
+	Version       int               `yaml:"version"`
+	Locale        string            `yaml:"locale"`
+	Keyboard      map[string]string `yaml:"keyboard"`
+	Timezone      string            `yaml:"timezone,omitempty"`
+	Identity      autoinstallID     `yaml:"identity"`
+	SSH           map[string]bool   `yaml:"ssh"`
+	Apt           *aptConfig        `yaml:"apt,omitempty"`
+	Network       netplanConfig     `yaml:"network"`
+	Storage       storageConfig     `yaml:"storage"`
+	Packages      []string          `yaml:"packages,omitempty"`
+	EarlyCommands []string          `yaml:"early-commands,omitempty"`
+	LateCommands  []string          `yaml:"late-commands,omitempty"`
+	UserData      map[string]any    `yaml:"user-data,omitempty"`
+	Shutdown      string            `yaml:"shutdown"`
+}
+
+type autoinstallID struct {
+	Hostname string `yaml:"hostname"`
+	Realname string `yaml:"realname"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type aptConfig struct {
+	Primary []aptMirror `yaml:"primary"`
+}
+
+type aptMirror struct {
+	Arches []string `yaml:"arches"`
+	URI    string   `yaml:"uri"`
+}
+
+// netplanConfig netplan v2 网络配置
+type netplanConfig struct {
+	Version   int                        `yaml:"version"`
+	Ethernets map[string]netplanEthernet `yaml:"ethernets"`
+}
+
+type netplanEthernet struct {
+	Match       map[string]string `yaml:"match,omitempty"`
+	SetName     string            `yaml:"set-name,omitempty"`
+	DHCP4       bool              `yaml:"dhcp4"`
+	Addresses   []string          `yaml:"addresses,omitempty"`
+	Routes      []netplanRoute    `yaml:"routes,omitempty"`
+	Nameservers map[string]any    `yaml:"nameservers,omitempty"`
+}
+
+type netplanRoute struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+// storageConfig curtin 存储配置（无分区配置时使用内置布局）
+type storageConfig struct {
+	Layout map[string]string `yaml:"layout,omitempty"`
+	Swap   map[string]int    `yaml:"swap,omitempty"`
+	Config []curtinAction    `yaml:"config,omitempty"`
+}
+
+// curtinAction curtin storage action (disk/partition/format/mount)
+type curtinAction struct {
+	Type       string            `yaml:"type"`
+	ID         string            `yaml:"id"`
+	Ptable     string            `yaml:"ptable,omitempty"`
+	Wipe       string            `yaml:"wipe,omitempty"`
+	Match      map[string]string `yaml:"match,omitempty"`
+	GrubDevice bool              `yaml:"grub_device,omitempty"`
+	Device     string            `yaml:"device,omitempty"`
+	Number     int               `yaml:"number,omitempty"`
+	Size       any               `yaml:"size,omitempty"`
+	Flag       string            `yaml:"flag,omitempty"`
+	Volume     string            `yaml:"volume,omitempty"`
+	Fstype     string            `yaml:"fstype,omitempty"`
+	Path       string            `yaml:"path,omitempty"`
+	Preserve   bool              `yaml:"preserve"`
+}
+
+// GenerateNoCloud 生成 Ubuntu Autoinstall 的 NoCloud 数据源
+// user-data: autoinstall配置; meta-data: 实例标识; vendor-data: 留空
+// 未配置分区时使用 Subiquity 默认布局
+func (g *Generator) GenerateNoCloud(profile *models.OSProfile, machine MachineContext) (*NoCloudSeed, error) {
+	base, err := g.templateData(profile, machine)
+	if err != nil {
+		return nil, err
+	}
+	if base.Distro.Family != FamilyUbuntu {
+		return nil, fmt.Errorf("autoinstall does not support OS type: %s", profile.Distro)
+	}
+
+	// Subiquity 必须创建一个用户，没有密码哈希时该用户被锁定且无法登录
+	if profile.Config.RootPasswordHash == "" {
+		return nil, fmt.Errorf("validation failed: root password hash is required for autoinstall")
+	}
+	network, err := buildNetplan(profile.Config.NetworkConfig, machine.MacAddress)
+	if err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+	storage, err := buildCurtinStorage(profile.Config.Partitions)
+	if err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	hostname := base.Hostname
+	hash := profile.Config.RootPasswordHash
+
+	cfg := autoinstallConfig{
+		Version:  1,
+		Locale:   "en_US.UTF-8",
+		Keyboard: map[string]string{"layout": "us"},
+		Timezone: profile.Config.Timezone,
+		Identity: autoinstallID{
+			Hostname: hostname,
+			Realname: "CloudBoot",
+			Username: "cloudboot",
+			Password: hash,
+		},
+		SSH:     map[string]bool{"install-server": true, "allow-pw": true},
+		Network: network,
+		Storage: storage,
+		UserData: map[string]any{
+			"disable_root": false,
+			"chpasswd": map[string]any{
+				"expire": false,
+				"users":  []map[string]string{{"name": "root", "password": hash, "type": "hash"}},
+			},
+		},
+		Packages: profile.Config.Packages,
+		Shutdown: "reboot",
+	}
+
+	if profile.Config.RepoURL != "" {
+		cfg.Apt = &aptConfig{Primary: []aptMirror{{Arches: []string{"default"}, URI: profile.Config.RepoURL}}}
+	}
+
+	cfg.EarlyCommands = []string{statusCommand(machine, "installing", "pre_install")}
+	cfg.LateCommands = buildLateCommands(profile, machine)
+
+	var body bytes.Buffer
+	encoder := yaml.NewEncoder(&body)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(map[string]any{"autoinstall": cfg}); err != nil {
+		return nil, fmt.Errorf("failed to marshal autoinstall config: %w", err)
+	}
+	encoder.Close()
+
+	return &NoCloudSeed{
+		UserData:   "#cloud-config\n" + body.String(),
+		MetaData:   fmt.Sprintf("instance-id: cloudboot-%s\nlocal-hostname: %s\n", machine.MachineID, hostname),
+		VendorData: "#cloud-config\n{}\n",
+	}, nil
+}
+
+// buildNetplan 将 NetworkConfigDetail 转换为 netplan v2
+// 未指定网卡名时按MAC匹配，避免依赖网卡命名
+func buildNetplan(network *models.NetworkConfigDetail, mac string) (netplanConfig, error) {
+	eth := netplanEthernet{DHCP4: true}
+	name := "primary"
+
+	if network != nil && network.Device != "" {
+		name = network.Device
+	} else if mac != "" {
+		eth.Match = map[string]string{"macaddress": mac}
+	} else {
+		eth.Match = map[string]string{"name": "en*"}
+	}
+
+	if network != nil && network.BootProto == "static" {
+		eth.DHCP4 = false
+		prefix, bits := net.IPMask(net.ParseIP(network.Netmask).To4()).Size()
+		if bits == 0 {
+			return netplanConfig{}, fmt.Errorf("invalid netmask: %s", network.Netmask)
+		}
+		eth.Addresses = []string{fmt.Sprintf("%s/%d", network.IPAddress, prefix)}
+		if network.Gateway != "" {
+			eth.Routes = []netplanRoute{{To: "default", Via: network.Gateway}}
+		}
+		if network.DNS != "" {
+			eth.Nameservers = map[string]any{"addresses": []string{network.DNS}}
+		}
+	}
+
+	return netplanConfig{
+		Version:   2,
+		Ethernets: map[string]netplanEthernet{name: eth},
+	}, nil
+}
+
+// buildCurtinStorage 将分区配置转换为 curtin storage config
+// 始终创建 bios_grub 和 ESP 分区，使同一配置可在 BIOS/UEFI 下启动；
+// 需要占满剩余空间的分区（Grow 或 SizeMB=0）放到最后，curtin 只允许一个
+func buildCurtinStorage(partitions []models.PartitionConfig) (storageConfig, error) {
+	if len(partitions) == 0 {
+		return storageConfig{Layout: map[string]string{"name": "direct"}}, nil
+	}
+
+	actions := []curtinAction{
+		{Type: "disk", ID: "disk0", Ptable: "gpt", Wipe: "superblock-recursive", Match: map[string]string{"size": "largest"}, GrubDevice: true},
+		{Type: "partition", ID: "part-bios", Device: "disk0", Number: 1, Size: "1M", Flag: "bios_grub"},
+	}
+
+	ordered := make([]models.PartitionConfig, 0, len(partitions)+1)
+	var grow *models.PartitionConfig
+	hasESP, hasSwap := false, false
+	for i := range partitions {
+		p := partitions[i]
+		switch p.MountPoint {
+		case "/boot/efi":
+			hasESP = true
+		case "swap":
+			hasSwap = true
+		}
+		if p.Grow || p.SizeMB == 0 {
+			if grow != nil {
+				return storageConfig{}, fmt.Errorf("only one partition may grow: %s and %s", grow.MountPoint, p.MountPoint)
+			}
+			grow = &p
+			continue
+		}
+		ordered = append(ordered, p)
+	}
+	if !hasESP {
+		ordered = append([]models.PartitionConfig{{MountPoint: "/boot/efi", SizeMB: 512, FileSystem: "vfat"}}, ordered...)
+	}
+	if grow != nil {
+		ordered = append(ordered, *grow)
+	}
+
+	for i, p := range ordered {
+		n := i + 2
+		partID := fmt.Sprintf("part-%d", n)
+		fmtID := fmt.Sprintf("fmt-%d", n)
+
+		part := curtinAction{Type: "partition", ID: partID, Device: "disk0", Number: n, Size: fmt.Sprintf("%dM", p.SizeMB)}
+		if grow != nil && i == len(ordered)-1 {
+			part.Size = -1
+		}
+		if p.MountPoint == "/boot/efi" {
+			part.Flag = "boot"
+		}
+		if p.MountPoint == "swap" {
+			part.Flag = "swap"
+		}
+
+		fstype := p.FileSystem
+		switch {
+		case p.MountPoint == "swap":
+			fstype = "swap"
+		case fstype == "vfat":
+			fstype = "fat32"
+		case fstype == "":
+			fstype = "ext4"
+		}
+
+		path := p.MountPoint
+		if p.MountPoint == "swap" {
+			path = "none"
+		}
+
+		actions = append(actions,
+			part,
+			curtinAction{Type: "format", ID: fmtID, Volume: partID, Fstype: fstype},
+			curtinAction{Type: "mount", ID: fmt.Sprintf("mnt-%d", n), Device: fmtID, Path: path},
+		)
+	}
+
+	storage := storageConfig{Config: actions}
+	if hasSwap {
+		// 已有swap分区时不再创建swapfile
+		storage.Swap = map[string]int{"size": 0}
+	}
+	return storage, nil
+}
+
+// buildLateCommands 生成 late-commands（PostScript、Agent安装、完成上报）
+// 多行脚本以 base64 写入目标系统，避免 YAML/Shell 转义问题
+func buildLateCommands(profile *models.OSProfile, machine MachineContext) []string {
+	var cmds []string
+
+	if profile.Config.InstallAgent {
+		unit := fmt.Sprintf(`[Unit]
+Description=CloudBoot Agent
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=/usr/local/bin/cloudboot-agent --server=%s --mac=%s
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`, machine.ServerURL, machine.MacAddress)
+
+		cmds = append(cmds,
+			fmt.Sprintf("curl -fsSL -o /target/usr/local/bin/cloudboot-agent %s/static/bin/cloudboot-agent", machine.ServerURL),
+			"chmod +x /target/usr/local/bin/cloudboot-agent",
+			writeFileCommand("/target/etc/systemd/system/cloudboot-agent.service", unit),
+			"curtin in-target --target=/target -- systemctl enable cloudboot-agent",
+		)
+	}
+
+	if profile.Config.PostScript != "" {
+		cmds = append(cmds,
+			writeFileCommand("/target/root/cloudboot-post.sh", profile.Config.PostScript),
+			"curtin in-target --target=/target -- bash /root/cloudboot-post.sh",
+		)
+	}
+
+	return append(cmds, statusCommand(machine, "success", "post_install"))
+}
+
+// writeFileCommand 生成将内容写入文件的命令
+func writeFileCommand(path, content string) string {
+	return fmt.Sprintf("echo %s | base64 -d > %s", base64.StdEncoding.EncodeToString([]byte(content)), path)
+}
+
+// statusCommand 生成安装进度上报命令（携带任务ID和安装器凭据，失败不影响安装）
+func statusCommand(machine MachineContext, status, step string) string {
+	return fmt.Sprintf(`curl -s -X POST %s/api/boot/v1/status -H "Content-Type: application/json" -d '{"task_id": "%s", "machine_id": "%s", "install_token": "%s", "status": "%s", "step": "%s"}' || true`,
+		machine.ServerURL, machine.JobID, machine.MachineID, machine.InstallToken, status, step)
+}
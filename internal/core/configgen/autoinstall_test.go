@@ -0,0 +1,206 @@
+package configgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+var testMachine = MachineContext{
+	MachineID:  "machine-1",
+	Hostname:   "web-01",
+	MacAddress: "aa:bb:cc:dd:ee:01",
+	ServerURL:  "http://10.0.0.10:8080",
+}
+
+// parseUserData 解析user-data中的autoinstall段
+func parseUserData(t *testing.T, userData string) map[string]interface{} {
+	t.Helper()
+	if !strings.HasPrefix(userData, "#cloud-config\n") {
+		t.Fatalf("user-data must start with #cloud-config, got %q", userData[:20])
+	}
+
+	var doc map[string]map[string]interface{}
+	if err := yaml.Unmarshal([]byte(userData), &doc); err != nil {
+		t.Fatalf("user-data is not valid YAML: %v", err)
+	}
+	ai, ok := doc["autoinstall"]
+	if !ok {
+		t.Fatal("user-data missing autoinstall section")
+	}
+	return ai
+}
+
+func TestGenerateNoCloud_Storage(t *testing.T) {
+	profile := &models.OSProfile{
+		Distro: "ubuntu22",
+		Config: models.ProfileConfig{
+			RootPasswordHash: "$6$salt$hash",
+			Partitions: []models.PartitionConfig{
+				{MountPoint: "/", SizeMB: 0, FileSystem: "ext4", Grow: true},
+				{MountPoint: "/boot", SizeMB: 1024, FileSystem: "ext4"},
+				{MountPoint: "swap", SizeMB: 4096, FileSystem: "swap"},
+			},
+		},
+	}
+
+	seed, err := NewGenerator().GenerateNoCloud(profile, testMachine)
+	if err != nil {
+		t.Fatalf("GenerateNoCloud() error = %v", err)
+	}
+
+	ai := parseUserData(t, seed.UserData)
+	storage := ai["storage"].(map[string]interface{})
+	config := storage["config"].([]interface{})
+
+	var mounts []string
+	var lastPartSize interface{}
+	for _, item := range config {
+		action := item.(map[string]interface{})
+		switch action["type"] {
+		case "mount":
+			mounts = append(mounts, action["path"].(string))
+		case "partition":
+			lastPartSize = action["size"]
+		}
+	}
+
+	want := []string{"/boot/efi", "/boot", "none", "/"}
+	if strings.Join(mounts, ",") != strings.Join(want, ",") {
+		t.Errorf("mount order = %v, want %v", mounts, want)
+	}
+	if lastPartSize != -1 {
+		t.Errorf("grow partition size = %v, want -1", lastPartSize)
+	}
+	if swap := storage["swap"].(map[string]interface{}); swap["size"] != 0 {
+		t.Errorf("swap size = %v, want 0 when swap partition exists", swap["size"])
+	}
+}
+
+func TestGenerateNoCloud_DefaultLayout(t *testing.T) {
+	seed, err := NewGenerator().GenerateNoCloud(&models.OSProfile{Distro: "ubuntu24", Config: models.ProfileConfig{RootPasswordHash: "$6$salt$hash"}}, testMachine)
+	if err != nil {
+		t.Fatalf("GenerateNoCloud() error = %v", err)
+	}
+
+	ai := parseUserData(t, seed.UserData)
+	layout := ai["storage"].(map[string]interface{})["layout"].(map[string]interface{})
+	if layout["name"] != "direct" {
+		t.Errorf("layout = %v, want direct", layout["name"])
+	}
+
+	// 未指定网卡时按MAC匹配并使用DHCP
+	eth := ai["network"].(map[string]interface{})["ethernets"].(map[string]interface{})["primary"].(map[string]interface{})
+	if eth["dhcp4"] != true {
+		t.Errorf("dhcp4 = %v, want true", eth["dhcp4"])
+	}
+	if eth["match"].(map[string]interface{})["macaddress"] != testMachine.MacAddress {
+		t.Errorf("match = %v, want macaddress %s", eth["match"], testMachine.MacAddress)
+	}
+
+	if !strings.Contains(seed.MetaData, "instance-id: cloudboot-machine-1") ||
+		!strings.Contains(seed.MetaData, "local-hostname: web-01") {
+		t.Errorf("unexpected meta-data: %q", seed.MetaData)
+	}
+}
+
+func TestGenerateNoCloud_StaticNetworkAndCommands(t *testing.T) {
+	profile := &models.OSProfile{
+		Distro: "ubuntu22",
+		Config: models.ProfileConfig{
+			RootPasswordHash: "$6$salt$hash",
+			RepoURL:          "http://mirror.example.com/ubuntu",
+			Packages:         []string{"nginx", "htop"},
+			PostScript:       "#!/bin/bash\necho 'done' > /root/marker\n",
+			InstallAgent:     true,
+			NetworkConfig: &models.NetworkConfigDetail{
+				BootProto: "static",
+				Device:    "ens192",
+				IPAddress: "10.0.0.50",
+				Netmask:   "255.255.255.0",
+				Gateway:   "10.0.0.1",
+				DNS:       "10.0.0.2",
+			},
+		},
+	}
+
+	machine := testMachine
+	machine.JobID = "job-1"
+	machine.InstallToken = "install-token"
+	seed, err := NewGenerator().GenerateNoCloud(profile, machine)
+	if err != nil {
+		t.Fatalf("GenerateNoCloud() error = %v", err)
+	}
+	ai := parseUserData(t, seed.UserData)
+
+	eth := ai["network"].(map[string]interface{})["ethernets"].(map[string]interface{})["ens192"].(map[string]interface{})
+	if addrs := eth["addresses"].([]interface{}); addrs[0] != "10.0.0.50/24" {
+		t.Errorf("addresses = %v, want 10.0.0.50/24", addrs)
+	}
+	if eth["dhcp4"] != false {
+		t.Errorf("dhcp4 = %v, want false", eth["dhcp4"])
+	}
+
+	identity := ai["identity"].(map[string]interface{})
+	if identity["password"] != "$6$salt$hash" || identity["hostname"] != "web-01" {
+		t.Errorf("identity = %v", identity)
+	}
+
+	if pkgs := ai["packages"].([]interface{}); len(pkgs) != 2 {
+		t.Errorf("packages = %v, want 2 entries", pkgs)
+	}
+
+	late := ai["late-commands"].([]interface{})
+	joined := ""
+	for _, cmd := range late {
+		joined += cmd.(string) + "\n"
+	}
+	for _, want := range []string{"/target/root/cloudboot-post.sh", "curtin in-target --target=/target -- bash /root/cloudboot-post.sh", "systemctl enable cloudboot-agent", `"status": "success"`, `"task_id": "job-1"`, `"install_token": "install-token"`} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("late-commands missing %q", want)
+		}
+	}
+}
+
+func TestGenerateNoCloud_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.ProfileConfig
+	}{
+		{"Invalid netmask", models.ProfileConfig{
+			RootPasswordHash: "$6$salt$hash",
+			NetworkConfig:    &models.NetworkConfigDetail{BootProto: "static", IPAddress: "10.0.0.50", Netmask: "255.0.255.0"},
+		}},
+		{"Two grow partitions", models.ProfileConfig{
+			RootPasswordHash: "$6$salt$hash",
+			Partitions: []models.PartitionConfig{
+				{MountPoint: "/", FileSystem: "ext4", Grow: true},
+				{MountPoint: "/home", SizeMB: 1024, FileSystem: "ext4", Grow: true},
+			},
+		}},
+		{"Two unsized partitions", models.ProfileConfig{
+			RootPasswordHash: "$6$salt$hash",
+			Partitions: []models.PartitionConfig{
+				{MountPoint: "/", FileSystem: "ext4"},
+				{MountPoint: "/var", FileSystem: "ext4"},
+			},
+		}},
+		{"No password hash", models.ProfileConfig{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := &models.OSProfile{Distro: "ubuntu22", Config: tt.config}
+			if _, err := NewGenerator().GenerateNoCloud(profile, testMachine); err == nil {
+				t.Error("GenerateNoCloud() should fail")
+			}
+		})
+	}
+
+	// 渲染前校验之外，netplan 生成本身也不接受无效掩码
+	if _, err := buildNetplan(&models.NetworkConfigDetail{BootProto: "static", IPAddress: "10.0.0.50", Netmask: "255.0.255.0"}, ""); err == nil {
+		t.Error("buildNetplan() should fail on invalid netmask")
+	}
+}
@@ -0,0 +1,224 @@
+package configgen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+)
+
+// Family 发行版家族
+type Family string
+
+const (
+	FamilyRHEL   Family = "rhel"
+	FamilySUSE   Family = "suse"
+	FamilyDebian Family = "debian"
+	FamilyUbuntu Family = "ubuntu"
+)
+
+// 安装配置格式
+const (
+	FormatKickstart   = "kickstart"
+	FormatAutoYaST    = "autoyast"
+	FormatPreseed     = "preseed"
+	FormatAutoinstall = "autoinstall"
+)
+
+// Distro 发行版定义
+type Distro struct {
+	Name     string // centos7, ubuntu22, debian12 ...
+	Family   Family
+	Version  string
+	Codename string
+	Kernel   string // 内核文件名（位于 /images/<name>/ 下）
+	Initrd   string // initrd文件名
+	RepoURL  string // 默认安装源
+}
+
+// FamilySpec 家族级渲染规则：模板、校验、内核参数
+type FamilySpec struct {
+	Format      string
+	Template    string // 模板名，autoinstall 由代码生成时为空
+	ContentType string
+	// ConfigPath 安装器拉取配置的路径前缀，后接 /<machine_id>
+	ConfigPath string
+	// Filesystems 该家族安装器支持的文件系统
+	Filesystems []string
+	// KernelArgs 生成安装器内核参数
+	KernelArgs func(configURL, repoURL string) string
+}
+
+const consoleArgs = "console=tty0 console=ttyS0,115200n8"
+
+// families 家族规则注册表
+var families = map[Family]*FamilySpec{
+	FamilyRHEL: {
+		Format:      FormatKickstart,
+		Template:    "kickstart",
+		ContentType: "text/plain; charset=utf-8",
+		ConfigPath:  "/boot/kickstart",
+		// RHEL 8+ 已移除 btrfs
+		Filesystems: []string{"ext4", "xfs", "swap", "vfat"},
+		KernelArgs: func(configURL, repoURL string) string {
+			return fmt.Sprintf("ip=dhcp inst.ks=%s inst.repo=%s %s", configURL, repoURL, consoleArgs)
+		},
+	},
+	FamilySUSE: {
+		Format:      FormatAutoYaST,
+		Template:    "autoyast",
+		ContentType: "application/xml; charset=utf-8",
+		ConfigPath:  "/boot/autoyast",
+		Filesystems: []string{"ext4", "xfs", "btrfs", "swap", "vfat"},
+		KernelArgs: func(configURL, repoURL string) string {
+			return fmt.Sprintf("ip=dhcp autoyast=%s install=%s %s", configURL, repoURL, consoleArgs)
+		},
+	},
+	FamilyDebian: {
+		Format:      FormatPreseed,
+		Template:    "preseed",
+		ContentType: "text/plain; charset=utf-8",
+		ConfigPath:  "/boot/preseed",
+		Filesystems: []string{"ext4", "xfs", "btrfs", "swap", "vfat"},
+		KernelArgs: func(configURL, repoURL string) string {
+			return fmt.Sprintf("auto=true priority=critical url=%s interface=auto netcfg/dhcp_timeout=60 %s", configURL, consoleArgs)
+		},
+	},
+	FamilyUbuntu: {
+		Format:      FormatAutoinstall,
+		ContentType: "text/yaml; charset=utf-8",
+		ConfigPath:  "/boot/autoinstall",
+		Filesystems: []string{"ext4", "xfs", "btrfs", "swap", "vfat"},
+		KernelArgs: func(configURL, repoURL string) string {
+			args := "ip=dhcp"
+			if repoURL != "" {
+				args += " url=" + repoURL
+			}
+			return fmt.Sprintf("%s autoinstall ds=nocloud-net;s=%s/ %s", args, configURL, consoleArgs)
+		},
+	},
+}
+
+// distros 发行版注册表（含无版本号的别名，兼容已有Profile）
+var distros = map[string]*Distro{}
+
+func registerDistro(d Distro) {
+	distros[d.Name] = &d
+}
+
+func init() {
+	rhel := func(name, version, repo string) {
+		registerDistro(Distro{Name: name, Family: FamilyRHEL, Version: version, Kernel: "vmlinuz", Initrd: "initrd.img", RepoURL: repo})
+	}
+	rhel("centos", "7", "http://mirror.centos.org/centos/7/os/x86_64/")
+	rhel("centos7", "7", "http://mirror.centos.org/centos/7/os/x86_64/")
+	rhel("centos8", "8", "http://vault.centos.org/8.5.2111/BaseOS/x86_64/os/")
+	rhel("rhel", "9", "")
+	rhel("rhel7", "7", "")
+	rhel("rhel8", "8", "")
+	rhel("rhel9", "9", "")
+	rhel("rocky", "9", "https://download.rockylinux.org/pub/rocky/9/BaseOS/x86_64/os/")
+	rhel("rocky8", "8", "https://download.rockylinux.org/pub/rocky/8/BaseOS/x86_64/os/")
+	rhel("rocky9", "9", "https://download.rockylinux.org/pub/rocky/9/BaseOS/x86_64/os/")
+	rhel("alma", "9", "https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/")
+	rhel("almalinux", "9", "https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/")
+	rhel("alma8", "8", "https://repo.almalinux.org/almalinux/8/BaseOS/x86_64/os/")
+	rhel("alma9", "9", "https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/")
+
+	suse := func(name, version, repo string) {
+		registerDistro(Distro{Name: name, Family: FamilySUSE, Version: version, Kernel: "linux", Initrd: "initrd", RepoURL: repo})
+	}
+	leapRepo := "https://download.opensuse.org/distribution/leap/15.5/repo/oss/"
+	suse("suse", "15", leapRepo)
+	suse("suse15", "15", leapRepo)
+	suse("opensuse", "15", leapRepo)
+	suse("leap", "15", leapRepo)
+	suse("leap15", "15", leapRepo)
+	suse("sles", "15", "")
+	suse("sles12", "12", "")
+	suse("sles15", "15", "")
+
+	debian := func(name, version, codename string) {
+		registerDistro(Distro{Name: name, Family: FamilyDebian, Version: version, Codename: codename, Kernel: "linux", Initrd: "initrd.gz", RepoURL: "http://deb.debian.org/debian/"})
+	}
+	debian("debian10", "10", "buster")
+	debian("debian11", "11", "bullseye")
+	debian("debian12", "12", "bookworm")
+
+	ubuntu := func(name, version, codename string) {
+		registerDistro(Distro{Name: name, Family: FamilyUbuntu, Version: version, Codename: codename, Kernel: "vmlinuz", Initrd: "initrd"})
+	}
+	ubuntu("ubuntu", "24.04", "noble")
+	ubuntu("ubuntu20", "20.04", "focal")
+	ubuntu("ubuntu22", "22.04", "jammy")
+	ubuntu("ubuntu24", "24.04", "noble")
+}
+
+// LookupDistro 查找发行版定义
+func LookupDistro(name string) (*Distro, bool) {
+	d, ok := distros[name]
+	return d, ok
+}
+
+// Distros 返回所有已注册发行版（按名称排序）
+func Distros() []*Distro {
+	list := make([]*Distro, 0, len(distros))
+	for _, d := range distros {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Spec 返回发行版所属家族的渲染规则
+func (d *Distro) Spec() *FamilySpec {
+	return families[d.Family]
+}
+
+// MajorVersion 返回主版本号（20.04 -> 20），无法解析时返回0
+func (d *Distro) MajorVersion() int {
+	major, _, _ := strings.Cut(d.Version, ".")
+	n, _ := strconv.Atoi(major)
+	return n
+}
+
+// BootParams iPXE启动参数
+type BootParams struct {
+	KernelURL  string
+	InitrdURL  string
+	RepoURL    string
+	ConfigURL  string
+	KernelArgs string
+}
+
+// BootParams 计算安装器的内核、initrd和内核参数
+// Profile 中的 KernelURL/InitrdURL/RepoURL 优先于发行版默认值
+func (g *Generator) BootParams(profile *models.OSProfile, machine MachineContext) (*BootParams, error) {
+	distro, ok := LookupDistro(profile.Distro)
+	if !ok {
+		return nil, fmt.Errorf("unsupported OS type: %s", profile.Distro)
+	}
+	spec := distro.Spec()
+	serverURL := strings.TrimRight(machine.ServerURL, "/")
+
+	params := &BootParams{
+		KernelURL: fmt.Sprintf("%s/images/%s/%s", serverURL, distro.Name, distro.Kernel),
+		InitrdURL: fmt.Sprintf("%s/images/%s/%s", serverURL, distro.Name, distro.Initrd),
+		RepoURL:   distro.RepoURL,
+		ConfigURL: fmt.Sprintf("%s%s/%s", serverURL, spec.ConfigPath, machine.MachineID),
+	}
+	if profile.Config.KernelURL != "" {
+		params.KernelURL = profile.Config.KernelURL
+	}
+	if profile.Config.InitrdURL != "" {
+		params.InitrdURL = profile.Config.InitrdURL
+	}
+	if profile.Config.RepoURL != "" {
+		params.RepoURL = profile.Config.RepoURL
+	}
+
+	params.KernelArgs = spec.KernelArgs(params.ConfigURL, params.RepoURL)
+	return params, nil
+}
@@ -0,0 +1,145 @@
+package configgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+)
+
+func TestDistros_FamilySpec(t *testing.T) {
+	for _, d := range Distros() {
+		spec := d.Spec()
+		if spec == nil {
+			t.Errorf("%s: no family spec for %q", d.Name, d.Family)
+			continue
+		}
+		if spec.Template != "" {
+			if _, ok := NewGenerator().templates[spec.Template]; !ok {
+				t.Errorf("%s: template %q not registered", d.Name, spec.Template)
+			}
+		}
+		if d.Kernel == "" || d.Initrd == "" {
+			t.Errorf("%s: kernel/initrd not set", d.Name)
+		}
+	}
+}
+
+func TestRender_Formats(t *testing.T) {
+	machine := testMachine
+	machine.SerialNumber = "SN123456"
+
+	tests := []struct {
+		distro          string
+		wantFormat      string
+		wantContentType string
+		wantContains    []string
+	}{
+		{"centos7", FormatKickstart, "text/plain; charset=utf-8", []string{
+			"# Serial Number: SN123456",
+			"--hostname=web-01",
+			"url --url=http://mirror.centos.org/centos/7/os/x86_64/",
+			"timezone UTC --utc",
+			`"machine_id": "machine-1"`,
+		}},
+		{"sles15", FormatAutoYaST, "application/xml; charset=utf-8", []string{
+			"Serial Number: SN123456",
+			"<hostname>web-01</hostname>",
+		}},
+		{"debian12", FormatPreseed, "text/plain; charset=utf-8", []string{"d-i mirror/suite string bookworm"}},
+		{"ubuntu22", FormatAutoinstall, "text/yaml; charset=utf-8", []string{"#cloud-config", "hostname: web-01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.distro, func(t *testing.T) {
+			profile := &models.OSProfile{ID: "p1", Distro: tt.distro, Config: models.ProfileConfig{RootPasswordHash: "$6$salt$hash"}}
+			got, err := NewGenerator().Render(profile, machine)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got.Format != tt.wantFormat || got.ContentType != tt.wantContentType {
+				t.Errorf("format = %s (%s), want %s (%s)", got.Format, got.ContentType, tt.wantFormat, tt.wantContentType)
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(got.Content, want) {
+					t.Errorf("content missing %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestRender_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile *models.OSProfile
+	}{
+		{"unknown distro", &models.OSProfile{Distro: "gentoo"}},
+		{"btrfs on RHEL", &models.OSProfile{Distro: "rocky9", Config: models.ProfileConfig{
+			Partitions: []models.PartitionConfig{{MountPoint: "/", FileSystem: "btrfs", Grow: true}},
+		}}},
+		{"missing root", &models.OSProfile{Distro: "sles15", Config: models.ProfileConfig{
+			Partitions: []models.PartitionConfig{{MountPoint: "/boot", SizeMB: 1024, FileSystem: "ext4"}},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGenerator().Render(tt.profile, testMachine); err == nil {
+				t.Error("Render() should fail")
+			}
+		})
+	}
+}
+
+func TestBootParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		profile    *models.OSProfile
+		wantKernel string
+		wantArgs   string
+	}{
+		{
+			name:       "kickstart",
+			profile:    &models.OSProfile{Distro: "rocky9"},
+			wantKernel: "http://10.0.0.10:8080/images/rocky9/vmlinuz",
+			wantArgs:   "ip=dhcp inst.ks=http://10.0.0.10:8080/boot/kickstart/machine-1 inst.repo=https://download.rockylinux.org/pub/rocky/9/BaseOS/x86_64/os/ " + consoleArgs,
+		},
+		{
+			name:       "preseed",
+			profile:    &models.OSProfile{Distro: "debian11"},
+			wantKernel: "http://10.0.0.10:8080/images/debian11/linux",
+			wantArgs:   "auto=true priority=critical url=http://10.0.0.10:8080/boot/preseed/machine-1 interface=auto netcfg/dhcp_timeout=60 " + consoleArgs,
+		},
+		{
+			name:       "autoinstall",
+			profile:    &models.OSProfile{Distro: "ubuntu24"},
+			wantKernel: "http://10.0.0.10:8080/images/ubuntu24/vmlinuz",
+			wantArgs:   "ip=dhcp autoinstall ds=nocloud-net;s=http://10.0.0.10:8080/boot/autoinstall/machine-1/ " + consoleArgs,
+		},
+		{
+			name: "profile overrides",
+			profile: &models.OSProfile{Distro: "suse15", Config: models.ProfileConfig{
+				KernelURL: "http://cdn.example.com/linux",
+				RepoURL:   "http://cdn.example.com/leap/",
+			}},
+			wantKernel: "http://cdn.example.com/linux",
+			wantArgs:   "ip=dhcp autoyast=http://10.0.0.10:8080/boot/autoyast/machine-1 install=http://cdn.example.com/leap/ " + consoleArgs,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := NewGenerator().BootParams(tt.profile, testMachine)
+			if err != nil {
+				t.Fatalf("BootParams() error = %v", err)
+			}
+			if params.KernelURL != tt.wantKernel {
+				t.Errorf("KernelURL = %s, want %s", params.KernelURL, tt.wantKernel)
+			}
+			if params.KernelArgs != tt.wantArgs {
+				t.Errorf("KernelArgs = %s, want %s", params.KernelArgs, tt.wantArgs)
+			}
+		})
+	}
+}
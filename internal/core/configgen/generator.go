@@ -2,12 +2,19 @@ package configgen
 
 import (
 	"bytes"
+	"embed"
 	"fmt"
+	"io/fs"
+	"path"
+	"strings"
 	"text/template"
 
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 )
 
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
 // Generator 配置生成器
 type Generator struct {
 	templates map[string]*template.Template
@@ -25,57 +32,109 @@ func NewGenerator() *Generator {
 	return g
 }
 
-// Generate 生成配置文件
+// TemplateData 各安装格式共用的模板数据
+type TemplateData struct {
+	Profile   *models.OSProfile
+	Distro    *Distro
+	Machine   MachineContext
+	ServerURL string
+	Hostname  string
+	RepoURL   string // Profile未指定时使用发行版默认安装源
+}
+
+// RenderedConfig 渲染后的安装配置
+type RenderedConfig struct {
+	Distro      *Distro
+	Format      string
+	ContentType string
+	Content     string
+}
+
+// Generate 生成配置文件（不含机器信息的预览）
 func (g *Generator) Generate(profile *models.OSProfile) (string, error) {
-	// 验证配置
-	if err := g.Validate(profile); err != nil {
-		return "", fmt.Errorf("validation failed: %w", err)
+	return g.GenerateFor(profile, MachineContext{})
+}
+
+// GenerateFor 为指定机器生成配置文件
+func (g *Generator) GenerateFor(profile *models.OSProfile, machine MachineContext) (string, error) {
+	rendered, err := g.Render(profile, machine)
+	if err != nil {
+		return "", err
 	}
+	return rendered.Content, nil
+}
 
-	// 根据OS类型选择模板
-	templateName := g.selectTemplate(profile.Distro)
-	tmpl, ok := g.templates[templateName]
-	if !ok {
-		return "", fmt.Errorf("template not found for OS type: %s", profile.Distro)
+// Render 按发行版家族渲染安装配置
+// 预览与 /boot/* 安装器拉取的内容走同一流程；Ubuntu 返回 NoCloud user-data
+func (g *Generator) Render(profile *models.OSProfile, machine MachineContext) (*RenderedConfig, error) {
+	data, err := g.templateData(profile, machine)
+	if err != nil {
+		return nil, err
 	}
+	spec := data.Distro.Spec()
 
-	// 准备模板数据
-	data := g.prepareTemplateData(profile)
+	rendered := &RenderedConfig{
+		Distro:      data.Distro,
+		Format:      spec.Format,
+		ContentType: spec.ContentType,
+	}
 
-	// 渲染模板
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("template execution failed: %w", err)
+	switch spec.Format {
+	case FormatAutoinstall:
+		seed, err := g.GenerateNoCloud(profile, machine)
+		if err != nil {
+			return nil, err
+		}
+		rendered.Content = seed.UserData
+	case FormatPreseed:
+		rendered.Content, err = g.GeneratePreseed(profile, machine)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		tmpl, ok := g.templates[spec.Template]
+		if !ok {
+			return nil, fmt.Errorf("template not found for OS type: %s", profile.Distro)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("template execution failed: %w", err)
+		}
+		rendered.Content = buf.String()
 	}
 
-	return buf.String(), nil
+	return rendered, nil
 }
 
-// selectTemplate 根据OS类型选择模板
-func (g *Generator) selectTemplate(osType string) string {
-	switch osType {
-	case "centos7", "centos8":
-		return "kickstart"
-	case "ubuntu20", "ubuntu22":
-		return "preseed"
-	case "sles12", "sles15":
-		return "autoyast"
-	default:
-		return "kickstart" // 默认
+// templateData 校验Profile并准备模板数据
+func (g *Generator) templateData(profile *models.OSProfile, machine MachineContext) (*TemplateData, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("profile is nil")
+	}
+
+	distro, ok := LookupDistro(profile.Distro)
+	if !ok {
+		return nil, fmt.Errorf("unsupported OS type: %s", profile.Distro)
+	}
+
+	if err := validateForRender(profile, distro); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
 	}
-}
 
-// prepareTemplateData 准备模板数据
-func (g *Generator) prepareTemplateData(profile *models.OSProfile) map[string]interface{} {
-	return map[string]interface{}{
-		"Profile":    profile,
-		"Partitions": profile.Config.Partitions,
-		"Network":    profile.Config.NetworkConfig,
-		"Packages":   profile.Config.Packages,
-		"PostScript": profile.Config.PostScript,
-		"RepoURL":    profile.Config.RepoURL,
-		"Helpers":    g.getHelperFuncs(),
+	repoURL := profile.Config.RepoURL
+	if repoURL == "" {
+		repoURL = distro.RepoURL
 	}
+
+	return &TemplateData{
+		Profile:   profile,
+		Distro:    distro,
+		Machine:   machine,
+		ServerURL: machine.ServerURL,
+		Hostname:  machine.hostname(),
+		RepoURL:   repoURL,
+	}, nil
 }
 
 // getHelperFuncs 获取模板辅助函数
@@ -110,179 +169,17 @@ func (g *Generator) RegisterTemplate(name, content string) error {
 	return nil
 }
 
-// registerBuiltinTemplates 注册内置模板
+// registerBuiltinTemplates 注册内置模板（templates/*.tmpl，模板名为文件名）
 func (g *Generator) registerBuiltinTemplates() {
-	// Kickstart 模板 (CentOS/RHEL)
-	g.RegisterTemplate("kickstart", kickstartTemplate)
-
-	// Preseed 模板 (Ubuntu/Debian)
-	g.RegisterTemplate("preseed", preseedTemplate)
-
-	// AutoYaST 模板 (SUSE)
-	g.RegisterTemplate("autoyast", autoyastTemplate)
+	files, _ := fs.Glob(builtinTemplates, "templates/*.tmpl")
+	for _, file := range files {
+		content, err := builtinTemplates.ReadFile(file)
+		if err != nil {
+			panic(fmt.Sprintf("configgen: failed to read builtin template %s: %v", file, err))
+		}
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		if err := g.RegisterTemplate(name, string(content)); err != nil {
+			panic(fmt.Sprintf("configgen: invalid builtin template %s: %v", file, err))
+		}
+	}
 }
-
-const kickstartTemplate = `# Kickstart for {{ .Profile.Distro }}
-# Generated by CloudBoot NG
-
-# System authorization
-auth --enableshadow --passalgo=sha512
-
-# Use network installation
-url --url={{ .RepoURL }}
-
-# Keyboard layouts
-keyboard us
-
-# System language
-lang en_US.UTF-8
-
-# Network information
-{{ with .Network -}}
-network --bootproto=static --ip={{ .IP }} --netmask={{ .Netmask }} --gateway={{ .Gateway }} --nameserver={{ call $.Helpers.joinDNS .DNS }} --hostname={{ .Hostname }}
-{{- end }}
-
-# Root password
-{{ if .Profile.Config.RootPasswordHash -}}
-rootpw --iscrypted {{ .Profile.Config.RootPasswordHash }}
-{{ else -}}
-rootpw --iscrypted $6$rounds=4096$salt$hash
-{{- end }}
-
-# System timezone
-{{ if .Profile.Config.Timezone -}}
-timezone {{ .Profile.Config.Timezone }} --isUtc
-{{ else -}}
-timezone Asia/Shanghai --isUtc
-{{- end }}
-
-# Bootloader configuration
-bootloader --location=mbr --boot-drive=sda
-
-# Clear the Master Boot Record
-zerombr
-
-# Partition clearing information
-clearpart --all --initlabel --drives=sda
-
-# Disk partitioning information
-{{ range .Partitions -}}
-{{ if call $.Helpers.isSwap .MountPoint -}}
-part swap --fstype=swap --size={{ .Size }}
-{{ else -}}
-part {{ .MountPoint }} --fstype={{ .FSType }} --size={{ .Size }}
-{{ end -}}
-{{ end }}
-
-# Packages
-%packages
-@core
-{{ range .Packages -}}
-{{ . }}
-{{ end }}
-%end
-
-# Post-installation script
-{{ if .PostScript -}}
-%post --log=/root/ks-post.log
-{{ .PostScript }}
-%end
-{{- end }}
-
-# Reboot after installation
-reboot
-`
-
-const preseedTemplate = `# Preseed for {{ .Profile.Distro }}
-# Generated by CloudBoot NG
-
-# Localization
-d-i debian-installer/locale string en_US.UTF-8
-d-i keyboard-configuration/xkb-keymap select us
-
-# Network configuration
-{{ with .Network -}}
-d-i netcfg/choose_interface select auto
-d-i netcfg/get_ipaddress string {{ .IP }}
-d-i netcfg/get_netmask string {{ .Netmask }}
-d-i netcfg/get_gateway string {{ .Gateway }}
-d-i netcfg/get_nameservers string {{ .DNS }}
-d-i netcfg/get_hostname string {{ .Hostname }}
-{{- end }}
-
-# Mirror settings
-d-i mirror/country string manual
-d-i mirror/http/hostname string {{ .RepoURL }}
-
-# Partitioning
-d-i partman-auto/method string regular
-d-i partman-auto/expert_recipe string \\
-{{ range .Partitions -}}
-{{ .MountPoint }} :: {{ .Size }} {{ .FSType }} . \\
-{{ end }}
-
-# Package selection
-tasksel tasksel/first multiselect standard
-{{ range .Packages -}}
-d-i pkgsel/include string {{ . }}
-{{ end }}
-
-# Post-installation
-d-i preseed/late_command string \\
-{{ .PostScript }}
-`
-
-const autoyastTemplate = `<?xml version="1.0"?>
-<!DOCTYPE profile>
-<!-- AutoYaST for {{ .Profile.Distro }} -->
-<!-- Generated by CloudBoot NG -->
-<profile xmlns="http://www.suse.com/1.0/yast2ns" xmlns:config="http://www.suse.com/1.0/configns">
-  <networking>
-    <interfaces config:type="list">
-      <interface>
-        <bootproto>static</bootproto>
-        {{ with .Network -}}
-        <ipaddr>{{ .IP }}</ipaddr>
-        <netmask>{{ .Netmask }}</netmask>
-        <hostname>{{ .Hostname }}</hostname>
-        {{- end }}
-      </interface>
-    </interfaces>
-  </networking>
-
-  <partitioning config:type="list">
-    <drive>
-      <device>/dev/sda</device>
-      <use>all</use>
-      <partitions config:type="list">
-        {{ range .Partitions -}}
-        <partition>
-          <mount>{{ .MountPoint }}</mount>
-          <filesystem config:type="symbol">{{ .FSType }}</filesystem>
-          <size>{{ .Size }}</size>
-        </partition>
-        {{ end }}
-      </partitions>
-    </drive>
-  </partitioning>
-
-  <software>
-    <packages config:type="list">
-      {{ range .Packages -}}
-      <package>{{ . }}</package>
-      {{ end }}
-    </packages>
-  </software>
-
-  <scripts>
-    <post-scripts config:type="list">
-      <script>
-        <filename>post.sh</filename>
-        <source><![CDATA[
-{{ .PostScript }}
-        ]]></source>
-      </script>
-    </post-scripts>
-  </scripts>
-</profile>
-`
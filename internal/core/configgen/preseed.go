@@ -0,0 +1,210 @@
+package configgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+)
+
+// preseedMirror 镜像源拆分后的各部分
+type preseedMirror struct {
+	Protocol  string
+	Hostname  string
+	Directory string
+}
+
+// preseedData preseed模板数据
+type preseedData struct {
+	TemplateData
+	Interface string
+	Network   *models.NetworkConfigDetail
+	// NonFreeFirmware Debian 12 起固件拆分到 non-free-firmware 组件
+	NonFreeFirmware bool
+	Mirror          preseedMirror
+	Recipe          string
+	GPT             bool
+	Packages        string
+	EarlyCommand    string
+	LateCommand     string
+}
+
+// GeneratePreseed 生成Debian preseed配置
+// 未配置分区时使用 partman 内置的 atomic 方案
+func (g *Generator) GeneratePreseed(profile *models.OSProfile, machine MachineContext) (string, error) {
+	base, err := g.templateData(profile, machine)
+	if err != nil {
+		return "", err
+	}
+	if base.Distro.Family != FamilyDebian {
+		return "", fmt.Errorf("preseed does not support OS type: %s", profile.Distro)
+	}
+
+	tmpl, ok := g.templates[FormatPreseed]
+	if !ok {
+		return "", fmt.Errorf("template not found for OS type: %s", profile.Distro)
+	}
+
+	mirror, err := parseMirror(profile.Config.RepoURL)
+	if err != nil {
+		return "", err
+	}
+
+	iface := "auto"
+	if network := profile.Config.NetworkConfig; network != nil && network.Device != "" {
+		iface = network.Device
+	}
+
+	data := preseedData{
+		TemplateData:    *base,
+		Interface:       iface,
+		Network:         profile.Config.NetworkConfig,
+		NonFreeFirmware: base.Distro.MajorVersion() >= 12,
+		Mirror:          mirror,
+		Recipe:          buildExpertRecipe(profile.Config.Partitions),
+		GPT:             hasMountPoint(profile.Config.Partitions, "/boot/efi"),
+		Packages:        strings.Join(append([]string{"openssh-server", "curl"}, profile.Config.Packages...), " "),
+		EarlyCommand:    preseedStatusCommand(machine, "installing", "pre_install"),
+		LateCommand:     buildPreseedLateCommand(profile, machine),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template execution failed: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// parseMirror 将RepoURL拆分为 protocol/hostname/directory
+func parseMirror(repoURL string) (preseedMirror, error) {
+	if repoURL == "" {
+		return preseedMirror{Protocol: "http", Hostname: "deb.debian.org", Directory: "/debian"}, nil
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return preseedMirror{}, fmt.Errorf("invalid repo URL: %s", repoURL)
+	}
+
+	dir := strings.TrimRight(u.Path, "/")
+	if dir == "" {
+		dir = "/"
+	}
+
+	return preseedMirror{Protocol: u.Scheme, Hostname: u.Host, Directory: dir}, nil
+}
+
+// buildExpertRecipe 将分区配置转换为 partman-auto/expert_recipe
+// 每个分区格式: <最小MB> <优先级> <最大MB> <文件系统> <属性...> .
+// 固定大小分区 最小=优先级=最大；Grow 或 SizeMB=0 的分区最大值为-1，占满剩余空间
+func buildExpertRecipe(partitions []models.PartitionConfig) string {
+	if len(partitions) == 0 {
+		return ""
+	}
+
+	hasBoot := hasMountPoint(partitions, "/boot")
+
+	lines := []string{"cloudboot ::"}
+	for _, p := range partitions {
+		minSize, priority, maxSize := p.SizeMB, p.SizeMB, fmt.Sprint(p.SizeMB)
+		if p.Grow || p.SizeMB == 0 {
+			if minSize == 0 {
+				minSize = 1024
+			}
+			priority, maxSize = 100000, "-1"
+		}
+
+		var fs string
+		var attrs []string
+		switch {
+		case p.MountPoint == "swap":
+			fs = "linux-swap"
+			attrs = []string{"method{ swap }", "format{ }"}
+		case p.MountPoint == "/boot/efi":
+			fs = "fat32"
+			attrs = []string{"$iflabel{ gpt }", "$reusemethod{ }", "method{ efi }", "format{ }"}
+		default:
+			fs = p.FileSystem
+			if p.MountPoint == "/boot" || p.MountPoint == "/" {
+				attrs = append(attrs, "$primary{ }")
+			}
+			// 没有独立/boot时根分区为启动分区
+			if p.MountPoint == "/boot" || (p.MountPoint == "/" && !hasBoot) {
+				attrs = append(attrs, "$bootable{ }")
+			}
+			attrs = append(attrs,
+				"method{ format }", "format{ }",
+				"use_filesystem{ }", fmt.Sprintf("filesystem{ %s }", fs),
+				fmt.Sprintf("mountpoint{ %s }", p.MountPoint))
+		}
+
+		lines = append(lines, fmt.Sprintf("%d %d %s %s %s .", minSize, priority, maxSize, fs, strings.Join(attrs, " ")))
+	}
+
+	return strings.Join(lines, " \\\n    ")
+}
+
+// hasMountPoint 检查分区配置中是否包含指定挂载点
+func hasMountPoint(partitions []models.PartitionConfig, mountPoint string) bool {
+	for _, p := range partitions {
+		if p.MountPoint == mountPoint {
+			return true
+		}
+	}
+	return false
+}
+
+// buildPreseedLateCommand 生成 preseed/late_command
+// 脚本在目标系统中以 base64 解码，避免 debconf 单行值的转义问题
+func buildPreseedLateCommand(profile *models.OSProfile, machine MachineContext) string {
+	var cmds []string
+
+	if profile.Config.InstallAgent {
+		unit := fmt.Sprintf(`[Unit]
+Description=CloudBoot Agent
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=/usr/local/bin/cloudboot-agent --server=%s --mac=%s
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`, machine.ServerURL, machine.MacAddress)
+
+		cmds = append(cmds,
+			fmt.Sprintf("in-target curl -fsSL -o /usr/local/bin/cloudboot-agent %s/static/bin/cloudboot-agent", machine.ServerURL),
+			"in-target chmod +x /usr/local/bin/cloudboot-agent",
+			inTargetWriteFile("/etc/systemd/system/cloudboot-agent.service", unit),
+			"in-target systemctl enable cloudboot-agent",
+		)
+	}
+
+	if profile.Config.PostScript != "" {
+		cmds = append(cmds,
+			inTargetWriteFile("/root/cloudboot-post.sh", profile.Config.PostScript),
+			"in-target bash /root/cloudboot-post.sh",
+		)
+	}
+
+	cmds = append(cmds, preseedStatusCommand(machine, "success", "post_install"))
+	return strings.Join(cmds, "; \\\n    ")
+}
+
+// inTargetWriteFile 生成在目标系统中写入文件的命令
+func inTargetWriteFile(path, content string) string {
+	return fmt.Sprintf("in-target sh -c 'echo %s | base64 -d > %s'", base64.StdEncoding.EncodeToString([]byte(content)), path)
+}
+
+// preseedStatusCommand 生成安装进度上报命令（携带任务ID和安装器凭据）
+// 安装环境只有busybox wget，失败不影响安装
+func preseedStatusCommand(machine MachineContext, status, step string) string {
+	return fmt.Sprintf(`wget -q -O /dev/null --header='Content-Type: application/json' --post-data='{"task_id": "%s", "machine_id": "%s", "install_token": "%s", "status": "%s", "step": "%s"}' %s/api/boot/v1/status || true`,
+		machine.JobID, machine.MachineID, machine.InstallToken, status, step, machine.ServerURL)
+}
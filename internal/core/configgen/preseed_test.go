@@ -0,0 +1,126 @@
+package configgen
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// goldenProfile 用于golden文件的完整配置（静态网络、UEFI分区、Agent、后置脚本）
+func goldenProfile(distro string) *models.OSProfile {
+	return &models.OSProfile{
+		ID:     "profile-" + distro,
+		Distro: distro,
+		Config: models.ProfileConfig{
+			RootPasswordHash: "$6$cloudboot$hash",
+			Timezone:         "Asia/Shanghai",
+			RepoURL:          "https://mirrors.example.com/debian/",
+			Partitions: []models.PartitionConfig{
+				{MountPoint: "/boot/efi", SizeMB: 512, FileSystem: "vfat"},
+				{MountPoint: "/boot", SizeMB: 1024, FileSystem: "ext4"},
+				{MountPoint: "swap", SizeMB: 4096, FileSystem: "swap"},
+				{MountPoint: "/", SizeMB: 20480, FileSystem: "xfs", Grow: true},
+			},
+			NetworkConfig: &models.NetworkConfigDetail{
+				BootProto: "static",
+				Device:    "eno1",
+				IPAddress: "10.0.0.50",
+				Netmask:   "255.255.255.0",
+				Gateway:   "10.0.0.1",
+				DNS:       "10.0.0.2",
+			},
+			Packages:     []string{"vim", "htop"},
+			PostScript:   "#!/bin/bash\necho done > /root/marker\n",
+			InstallAgent: true,
+		},
+	}
+}
+
+func TestGeneratePreseed_Golden(t *testing.T) {
+	machine := testMachine
+	machine.JobID = "job-1"
+	machine.InstallToken = "install-token"
+	for _, distro := range []string{"debian10", "debian11", "debian12"} {
+		t.Run(distro, func(t *testing.T) {
+			got, err := NewGenerator().GeneratePreseed(goldenProfile(distro), machine)
+			if err != nil {
+				t.Fatalf("GeneratePreseed() error = %v", err)
+			}
+
+			golden := filepath.Join("testdata", "preseed", distro+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("preseed mismatch for %s (run with -update to accept)\n--- got ---\n%s", distro, got)
+			}
+		})
+	}
+}
+
+func TestBuildExpertRecipe(t *testing.T) {
+	recipe := buildExpertRecipe([]models.PartitionConfig{
+		{MountPoint: "swap", SizeMB: 2048, FileSystem: "swap"},
+		{MountPoint: "/", SizeMB: 0, FileSystem: "ext4"},
+	})
+
+	lines := strings.Split(recipe, " \\\n    ")
+	want := []string{
+		"cloudboot ::",
+		"2048 2048 2048 linux-swap method{ swap } format{ } .",
+		"1024 100000 -1 ext4 $primary{ } $bootable{ } method{ format } format{ } use_filesystem{ } filesystem{ ext4 } mountpoint{ / } .",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("recipe lines = %d, want %d:\n%s", len(lines), len(want), recipe)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestGeneratePreseed_Defaults(t *testing.T) {
+	got, err := NewGenerator().GeneratePreseed(&models.OSProfile{Distro: "debian12"}, testMachine)
+	if err != nil {
+		t.Fatalf("GeneratePreseed() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"d-i partman-auto/choose_recipe select atomic",
+		"d-i mirror/http/hostname string deb.debian.org",
+		"d-i netcfg/choose_interface select auto",
+		"d-i time/zone string Etc/UTC",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("preseed missing %q", want)
+		}
+	}
+	if strings.Contains(got, "expert_recipe") || strings.Contains(got, "netcfg/get_ipaddress") {
+		t.Error("default preseed should not contain expert recipe or static network")
+	}
+}
+
+func TestGenerate_PreseedPreview(t *testing.T) {
+	profile := goldenProfile("debian11")
+	got, err := NewGenerator().Generate(profile)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.Contains(got, "d-i mirror/suite string bullseye") {
+		t.Error("preview should render preseed for Debian profiles")
+	}
+}
@@ -3,24 +3,25 @@ package configgen
 import (
 	"fmt"
 	"net"
-	// "strings"
+	"slices"
 
 	"github.com/cloudboot/cloudboot-ng/internal/models"
 )
 
-// Validate 验证OSProfile配置
+// Validate 验证OSProfile配置（创建/更新Profile时使用，要求显式分区）
 func (g *Generator) Validate(profile *models.OSProfile) error {
 	if profile == nil {
 		return fmt.Errorf("profile is nil")
 	}
 
 	// 验证OS类型
-	if err := validateOSType(profile.Distro); err != nil {
-		return err
+	distro, ok := LookupDistro(profile.Distro)
+	if !ok {
+		return fmt.Errorf("unsupported OS type: %s", profile.Distro)
 	}
 
 	// 验证分区配置
-	if err := validatePartitions(profile.Config.Partitions); err != nil {
+	if err := validatePartitions(profile.Config.Partitions, distro.Spec().Filesystems); err != nil {
 		return err
 	}
 
@@ -34,26 +35,18 @@ func (g *Generator) Validate(profile *models.OSProfile) error {
 	return nil
 }
 
-// validateOSType 验证操作系统类型
-func validateOSType(osType string) error {
-	validTypes := map[string]bool{
-		"centos7":  true,
-		"centos8":  true,
-		"ubuntu20": true,
-		"ubuntu22": true,
-		"sles12":   true,
-		"sles15":   true,
-	}
-
-	if !validTypes[osType] {
-		return fmt.Errorf("unsupported OS type: %s", osType)
+// validateForRender 渲染前校验，未配置分区时由各安装器使用默认布局
+func validateForRender(profile *models.OSProfile, distro *Distro) error {
+	if len(profile.Config.Partitions) > 0 {
+		if err := validatePartitions(profile.Config.Partitions, distro.Spec().Filesystems); err != nil {
+			return err
+		}
 	}
-
-	return nil
+	return validateNetwork(profile.Config.NetworkConfig)
 }
 
 // validatePartitions 验证分区配置
-func validatePartitions(partitions []models.PartitionConfig) error {
+func validatePartitions(partitions []models.PartitionConfig, filesystems []string) error {
 	if len(partitions) == 0 {
 		return fmt.Errorf("no partitions defined")
 	}
@@ -83,7 +76,7 @@ func validatePartitions(partitions []models.PartitionConfig) error {
 		}
 
 		// 验证文件系统类型
-		if err := validateFilesystem(part.FileSystem, part.MountPoint); err != nil {
+		if err := validateFilesystem(part.FileSystem, part.MountPoint, filesystems); err != nil {
 			return fmt.Errorf("partition %d: %w", i, err)
 		}
 	}
@@ -96,17 +89,9 @@ func validatePartitions(partitions []models.PartitionConfig) error {
 	return nil
 }
 
-// validateFilesystem 验证文件系统类型
-func validateFilesystem(fstype, mount string) error {
-	validFS := map[string]bool{
-		"ext4":  true,
-		"xfs":   true,
-		"swap":  true,
-		"vfat":  true,
-		"btrfs": true,
-	}
-
-	if !validFS[fstype] {
+// validateFilesystem 验证文件系统类型（filesystems 为发行版家族支持的列表）
+func validateFilesystem(fstype, mount string, filesystems []string) error {
+	if !slices.Contains(filesystems, fstype) {
 		return fmt.Errorf("unsupported filesystem type: %s", fstype)
 	}
 
@@ -140,6 +125,10 @@ func validateNetwork(network *models.NetworkConfigDetail) error {
 			return fmt.Errorf("netmask is required for static network")
 		}
 
+		if !isValidNetmask(network.Netmask) {
+			return fmt.Errorf("invalid netmask: %s", network.Netmask)
+		}
+
 		if network.Gateway != "" {
 			gw := net.ParseIP(network.Gateway)
 			if gw == nil {
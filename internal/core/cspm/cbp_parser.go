@@ -2,22 +2,80 @@ package cspm
 
 import (
 	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
 )
 
+// Entry names inside a .cbp package. Any other entry is rejected.
+const (
+	EntryManifest  = "meta/manifest.json"
+	EntryWatermark = "meta/watermark.json"
+	EntrySchema    = "meta/schema.json" // optional
+	EntryProvider  = "bin/provider.enc"
+	EntrySignature = "signature.sig"
+)
+
+// digestVersion prefixes the canonical digest so the format can evolve
+const digestVersion = "cbp-digest-v1"
+
+var (
+	// ErrUnexpectedEntry is returned for zip entries outside the .cbp layout
+	ErrUnexpectedEntry = errors.New("unexpected entry in cbp package")
+	// ErrDuplicateEntry is returned when a zip entry name appears more than once
+	ErrDuplicateEntry = errors.New("duplicate entry in cbp package")
+	// ErrInvalidPackageSignature is returned when signature.sig does not match the package digest
+	ErrInvalidPackageSignature = errors.New("invalid package signature")
+	// ErrInvalidPackage wraps every error caused by the package contents
+	// (layout, manifest, schema, watermark or payload) rather than the server
+	ErrInvalidPackage = errors.New("invalid cbp package")
+)
+
+var allowedEntries = map[string]bool{
+	EntryManifest:  true,
+	EntryWatermark: true,
+	EntrySchema:    true,
+	EntryProvider:  true,
+	EntrySignature: true,
+}
+
+// allowedDirs are directory entries some zip tools add; they carry no content
+var allowedDirs = map[string]bool{
+	"meta/": true,
+	"bin/":  true,
+}
+
+// namePattern keeps manifest IDs and versions usable in store file names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func validName(s string) bool {
+	return namePattern.MatchString(s) && s != "." && s != ".."
+}
+
 // CBPPackage represents the structure of a CloudBoot Package (.cbp)
 type CBPPackage struct {
 	Manifest  Manifest  `json:"manifest"`
 	Watermark audit.Watermark `json:"watermark"`
 	Signature string    `json:"signature"`
+	// Schema is the raw meta/schema.json (nil when the package has none)
+	Schema []byte `json:"-"`
 	// ProviderBinary is the encrypted provider binary
 	ProviderBinary []byte `json:"-"`
+	// Digest is the canonical digest of every entry except signature.sig (see PackageDigest)
+	Digest []byte `json:"-"`
 }
 
 // Manifest contains metadata about the provider
@@ -31,12 +89,25 @@ type Manifest struct {
 	Description      string   `json:"description"`
 	Author           string   `json:"author"`
 	CreatedAt        string   `json:"created_at"`
+	// KeyVersion 加密 bin/provider.enc 使用的Master Key版本（缺省为服务端当前版本）
+	KeyVersion int `json:"key_version,omitempty"`
 }
 
 // Note: Watermark type moved to internal/core/audit package to avoid duplication
 
-// ParseCBP parses a .cbp package file
+// ParseCBP parses a .cbp package file and computes its canonical digest.
+// Packages with unexpected or duplicate entries are rejected; the signature
+// itself is checked by the caller (see VerifyCBP). All errors wrap
+// ErrInvalidPackage.
 func ParseCBP(cbpPath string) (*CBPPackage, error) {
+	pkg, err := parseCBP(cbpPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPackage, err)
+	}
+	return pkg, nil
+}
+
+func parseCBP(cbpPath string) (*CBPPackage, error) {
 	// 打开ZIP文件
 	reader, err := zip.OpenReader(cbpPath)
 	if err != nil {
@@ -44,37 +115,41 @@ func ParseCBP(cbpPath string) (*CBPPackage, error) {
 	}
 	defer reader.Close()
 
-	pkg := &CBPPackage{}
-
-	// 遍历ZIP文件内容
-	for _, file := range reader.File {
-		switch file.Name {
-		case "meta/manifest.json":
-			if err := parseManifest(file, pkg); err != nil {
-				return nil, fmt.Errorf("failed to parse manifest: %w", err)
-			}
-
-		case "meta/watermark.json":
-			if err := parseWatermark(file, pkg); err != nil {
-				return nil, fmt.Errorf("failed to parse watermark: %w", err)
-			}
-
-		case "signature.sig":
-			if err := parseSignature(file, pkg); err != nil {
-				return nil, fmt.Errorf("failed to parse signature: %w", err)
-			}
+	entries, err := readEntries(&reader.Reader)
+	if err != nil {
+		return nil, err
+	}
 
-		case "bin/provider.enc":
-			if err := parseProviderBinary(file, pkg); err != nil {
-				return nil, fmt.Errorf("failed to parse provider binary: %w", err)
-			}
+	pkg := &CBPPackage{}
+	if data, ok := entries[EntryManifest]; ok {
+		if err := json.Unmarshal(data, &pkg.Manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		}
+	}
+	if data, ok := entries[EntryWatermark]; ok {
+		if err := json.Unmarshal(data, &pkg.Watermark); err != nil {
+			return nil, fmt.Errorf("failed to parse watermark: %w", err)
 		}
 	}
+	if data, ok := entries[EntrySchema]; ok {
+		if _, err := ParseSchema(data); err != nil {
+			return nil, err
+		}
+		pkg.Schema = data
+	}
+	pkg.Signature = strings.TrimSpace(string(entries[EntrySignature]))
+	pkg.ProviderBinary = entries[EntryProvider]
 
 	// 验证必需字段
 	if pkg.Manifest.ID == "" {
 		return nil, fmt.Errorf("manifest.json is missing or invalid")
 	}
+	if !validName(pkg.Manifest.ID) {
+		return nil, fmt.Errorf("invalid provider id %q", pkg.Manifest.ID)
+	}
+	if !validName(pkg.Manifest.Version) {
+		return nil, fmt.Errorf("invalid provider version %q", pkg.Manifest.Version)
+	}
 	if pkg.Signature == "" {
 		return nil, fmt.Errorf("signature.sig is missing")
 	}
@@ -82,73 +157,115 @@ func ParseCBP(cbpPath string) (*CBPPackage, error) {
 		return nil, fmt.Errorf("provider.enc is missing")
 	}
 
+	pkg.Digest = PackageDigest(entries)
 	return pkg, nil
 }
 
-func parseManifest(file *zip.File, pkg *CBPPackage) error {
-	rc, err := file.Open()
-	if err != nil {
-		return err
-	}
-	defer rc.Close()
+// readEntries reads every file entry of the package into memory, rejecting
+// names outside the .cbp layout and names that appear more than once.
+func readEntries(r *zip.Reader) (map[string][]byte, error) {
+	entries := make(map[string][]byte, len(r.File))
+	seenDirs := make(map[string]bool)
+	for _, file := range r.File {
+		if file.FileInfo().IsDir() {
+			if !allowedDirs[file.Name] {
+				return nil, fmt.Errorf("%w: %s", ErrUnexpectedEntry, file.Name)
+			}
+			if seenDirs[file.Name] {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicateEntry, file.Name)
+			}
+			seenDirs[file.Name] = true
+			continue
+		}
+		if !allowedEntries[file.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrUnexpectedEntry, file.Name)
+		}
+		if _, dup := entries[file.Name]; dup {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateEntry, file.Name)
+		}
 
-	data, err := io.ReadAll(rc)
-	if err != nil {
-		return err
+		data, err := readZipFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		entries[file.Name] = data
 	}
-
-	return json.Unmarshal(data, &pkg.Manifest)
+	return entries, nil
 }
 
-func parseWatermark(file *zip.File, pkg *CBPPackage) error {
+func readZipFile(file *zip.File) ([]byte, error) {
 	rc, err := file.Open()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rc.Close()
 
-	data, err := io.ReadAll(rc)
-	if err != nil {
-		return err
-	}
-
-	return json.Unmarshal(data, &pkg.Watermark)
+	return io.ReadAll(rc)
 }
 
-func parseSignature(file *zip.File, pkg *CBPPackage) error {
-	rc, err := file.Open()
-	if err != nil {
-		return err
+// PackageDigest computes the canonical digest that signature.sig signs:
+// SHA-256 over "cbp-digest-v1\n" followed by "<name>\n<hex sha256>\n" for
+// every entry except signature.sig, in byte-wise sorted name order.
+func PackageDigest(entries map[string][]byte) []byte {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		if name != EntrySignature {
+			names = append(names, name)
+		}
 	}
-	defer rc.Close()
+	sort.Strings(names)
 
-	data, err := io.ReadAll(rc)
-	if err != nil {
-		return err
+	var buf bytes.Buffer
+	buf.WriteString(digestVersion + "\n")
+	for _, name := range names {
+		sum := sha256.Sum256(entries[name])
+		buf.WriteString(name + "\n" + hex.EncodeToString(sum[:]) + "\n")
 	}
+	digest := sha256.Sum256(buf.Bytes())
+	return digest[:]
+}
 
-	pkg.Signature = string(data)
+// VerifyCBP checks signature.sig against the package digest
+func VerifyCBP(pkg *CBPPackage, publicKey *ecdsa.PublicKey) error {
+	if publicKey == nil {
+		return fmt.Errorf("%w: no public key", ErrInvalidPackageSignature)
+	}
+	valid, err := crypto.VerifySignature(pkg.Digest, pkg.Signature, publicKey)
+	if err != nil || !valid {
+		return ErrInvalidPackageSignature
+	}
 	return nil
 }
 
-func parseProviderBinary(file *zip.File, pkg *CBPPackage) error {
-	rc, err := file.Open()
+// CreateCBP writes pkg as a .cbp file signed over its canonical digest (used by build tools).
+// pkg.Digest and pkg.Signature are filled in; pkg.Schema is only embedded when set.
+func CreateCBP(pkg *CBPPackage, signer *ecdsa.PrivateKey, outputPath string) error {
+	manifestData, err := json.MarshalIndent(pkg.Manifest, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer rc.Close()
-
-	data, err := io.ReadAll(rc)
+	watermarkData, err := json.MarshalIndent(pkg.Watermark, "", "  ")
 	if err != nil {
 		return err
 	}
+	entries := map[string][]byte{
+		EntryManifest:  manifestData,
+		EntryWatermark: watermarkData,
+		EntryProvider:  pkg.ProviderBinary,
+	}
+	if pkg.Schema != nil {
+		entries[EntrySchema] = pkg.Schema
+	}
 
-	pkg.ProviderBinary = data
-	return nil
-}
+	// 对包内全部条目的规范摘要签名
+	pkg.Digest = PackageDigest(entries)
+	signature, err := crypto.SignData(pkg.Digest, signer)
+	if err != nil {
+		return fmt.Errorf("failed to sign package: %w", err)
+	}
+	pkg.Signature = signature
+	entries[EntrySignature] = []byte(signature)
 
-// CreateCBP creates a .cbp package file (used by build tools)
-func CreateCBP(manifest Manifest, watermark audit.Watermark, encryptedBinary []byte, signature string, outputPath string) error {
 	// 创建ZIP文件
 	file, err := os.Create(outputPath)
 	if err != nil {
@@ -157,37 +274,16 @@ func CreateCBP(manifest Manifest, watermark audit.Watermark, encryptedBinary []b
 	defer file.Close()
 
 	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
-
-	// 写入manifest.json
-	manifestData, err := json.MarshalIndent(manifest, "", "  ")
-	if err != nil {
-		return err
-	}
-	if err := writeZipFile(zipWriter, "meta/manifest.json", manifestData); err != nil {
-		return err
-	}
-
-	// 写入watermark.json
-	watermarkData, err := json.MarshalIndent(watermark, "", "  ")
-	if err != nil {
-		return err
-	}
-	if err := writeZipFile(zipWriter, "meta/watermark.json", watermarkData); err != nil {
-		return err
-	}
-
-	// 写入加密的provider二进制
-	if err := writeZipFile(zipWriter, "bin/provider.enc", encryptedBinary); err != nil {
-		return err
-	}
-
-	// 写入签名
-	if err := writeZipFile(zipWriter, "signature.sig", []byte(signature)); err != nil {
-		return err
+	for _, name := range []string{EntryManifest, EntryWatermark, EntrySchema, EntryProvider, EntrySignature} {
+		data, ok := entries[name]
+		if !ok {
+			continue
+		}
+		if err := writeZipFile(zipWriter, name, data); err != nil {
+			return err
+		}
 	}
-
-	return nil
+	return zipWriter.Close()
 }
 
 func writeZipFile(zipWriter *zip.Writer, name string, data []byte) error {
@@ -200,7 +296,8 @@ func writeZipFile(zipWriter *zip.Writer, name string, data []byte) error {
 	return err
 }
 
-// ExtractCBP extracts a .cbp package to a directory
+// ExtractCBP extracts a .cbp package to a directory. Only the entries of the
+// .cbp layout are written, so a crafted package cannot escape destDir.
 func ExtractCBP(cbpPath string, destDir string) error {
 	reader, err := zip.OpenReader(cbpPath)
 	if err != nil {
@@ -208,37 +305,19 @@ func ExtractCBP(cbpPath string, destDir string) error {
 	}
 	defer reader.Close()
 
-	for _, file := range reader.File {
-		path := filepath.Join(destDir, file.Name)
+	entries, err := readEntries(&reader.Reader)
+	if err != nil {
+		return err
+	}
 
-		// 创建目录
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, file.Mode())
-			continue
-		}
+	for name, data := range entries {
+		path := filepath.Join(destDir, filepath.FromSlash(name))
 
 		// 创建父目录
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return err
 		}
-
-		// 写入文件
-		outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return err
-		}
-
-		rc, err := file.Open()
-		if err != nil {
-			outFile.Close()
-			return err
-		}
-
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
-
-		if err != nil {
+		if err := os.WriteFile(path, data, 0644); err != nil {
 			return err
 		}
 	}
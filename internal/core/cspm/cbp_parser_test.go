@@ -0,0 +1,163 @@
+package cspm
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+// writeRawZip 按给定顺序写入条目（可构造重复或多余条目）
+func writeRawZip(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		if err := writeZipFile(w, e.name, e.data); err != nil {
+			t.Fatalf("writeZipFile() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("zip Close() error = %v", err)
+	}
+}
+
+// readRawZip 读取包内条目（保持原顺序）
+func readRawZip(t *testing.T, path string) []zipEntry {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader() error = %v", err)
+	}
+	defer r.Close()
+	var entries []zipEntry
+	for _, f := range r.File {
+		data, err := readZipFile(f)
+		if err != nil {
+			t.Fatalf("readZipFile() error = %v", err)
+		}
+		entries = append(entries, zipEntry{f.Name, data})
+	}
+	return entries
+}
+
+func replaceEntry(entries []zipEntry, name string, data []byte) []zipEntry {
+	out := make([]zipEntry, len(entries))
+	copy(out, entries)
+	for i := range out {
+		if out[i].name == name {
+			out[i].data = data
+		}
+	}
+	return out
+}
+
+// withEntry 返回追加了一个条目的副本
+func withEntry(entries []zipEntry, name string, data []byte) []zipEntry {
+	out := make([]zipEntry, len(entries), len(entries)+1)
+	copy(out, entries)
+	return append(out, zipEntry{name, data})
+}
+
+func TestVerifyCBP(t *testing.T) {
+	dir := t.TempDir()
+	signer, _ := crypto.GenerateECDSAKeyPair()
+	other, _ := crypto.GenerateECDSAKeyPair()
+
+	signed := filepath.Join(dir, "signed.cbp")
+	manifest := Manifest{ID: "raid-mock", Name: "RAID Mock", Version: "1.0.0"}
+	pkg := &CBPPackage{
+		Manifest:       manifest,
+		Watermark:      audit.Watermark{LicenseID: "lic-1"},
+		Schema:         []byte(`{"version":"1","parameters":[]}`),
+		ProviderBinary: []byte("encrypted"),
+	}
+	if err := CreateCBP(pkg, signer, signed); err != nil {
+		t.Fatalf("CreateCBP() error = %v", err)
+	}
+	original := readRawZip(t, signed)
+
+	tests := []struct {
+		name      string
+		entries   []zipEntry
+		wrongKey  bool
+		parseErr  error
+		wantValid bool
+	}{
+		{"untouched", original, false, nil, true},
+		{"dir entries allowed", append([]zipEntry{{"meta/", nil}}, original...), false, nil, true},
+		{"wrong public key", original, true, nil, false},
+		{"tampered binary", replaceEntry(original, EntryProvider, []byte("swapped")), false, nil, false},
+		{"tampered watermark", replaceEntry(original, EntryWatermark, []byte(`{"license_id":"lic-2"}`)), false, nil, false},
+		{"tampered schema", replaceEntry(original, EntrySchema, []byte(`{"version":"2","parameters":[]}`)), false, nil, false},
+		{"tampered manifest", replaceEntry(original, EntryManifest, []byte(`{"id":"raid-mock","name":"Other","version":"1.0.0"}`)), false, nil, false},
+		{"extra entry", withEntry(original, "bin/payload.sh", []byte("rm -rf /")), false, ErrUnexpectedEntry, false},
+		{"path traversal", withEntry(original, "../evil", []byte("x")), false, ErrUnexpectedEntry, false},
+		{"duplicate entry", withEntry(original, EntryProvider, []byte("second")), false, ErrDuplicateEntry, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "case.cbp")
+			writeRawZip(t, path, tt.entries)
+
+			pkg, err := ParseCBP(path)
+			if tt.parseErr != nil {
+				if !errors.Is(err, tt.parseErr) {
+					t.Fatalf("ParseCBP() error = %v, want %v", err, tt.parseErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCBP() error = %v", err)
+			}
+
+			key := &signer.PublicKey
+			if tt.wrongKey {
+				key = &other.PublicKey
+			}
+			err = VerifyCBP(pkg, key)
+			if (err == nil) != tt.wantValid {
+				t.Errorf("VerifyCBP() error = %v, wantValid %v", err, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestParseCBPRejectsUnsafeID(t *testing.T) {
+	dir := t.TempDir()
+	signer, _ := crypto.GenerateECDSAKeyPair()
+	path := filepath.Join(dir, "bad.cbp")
+	manifest := Manifest{ID: "../raid", Version: "1.0.0"}
+	if err := CreateCBP(&CBPPackage{Manifest: manifest, ProviderBinary: []byte("encrypted")}, signer, path); err != nil {
+		t.Fatalf("CreateCBP() error = %v", err)
+	}
+	if _, err := ParseCBP(path); err == nil {
+		t.Errorf("ParseCBP() accepted provider id %q", manifest.ID)
+	}
+}
+
+func TestPackageDigestOrderIndependent(t *testing.T) {
+	a := map[string][]byte{EntryManifest: []byte("m"), EntryProvider: []byte("p")}
+	b := map[string][]byte{EntryProvider: []byte("p"), EntryManifest: []byte("m"), EntrySignature: []byte("sig")}
+	if string(PackageDigest(a)) != string(PackageDigest(b)) {
+		t.Errorf("PackageDigest() depends on entry order or signature.sig")
+	}
+	b[EntryProvider] = []byte("q")
+	if string(PackageDigest(a)) == string(PackageDigest(b)) {
+		t.Errorf("PackageDigest() ignores entry contents")
+	}
+}
@@ -4,21 +4,73 @@ import (
 	"crypto/ecdsa"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/keystore"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
 	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Store目录布局（<key> 为 <id>@<version>）：
+//
+//	<key>.enc   Master Key加密的Provider二进制（密文头部记录密钥版本）
+//	run/<key>   启动或导入时解密出的可执行文件（Executor使用）
+//
+// Provider元数据和默认版本保存在数据库 provider_versions 表中。
+// 早期版本的 <id>.enc + <id>.json 布局和明文Provider在启动时迁移。
+const (
+	encryptedExt = ".enc"
+	metadataExt  = ".json"
+	runDirName   = "run"
+	versionSep   = "@"
+)
+
+var (
+	// ErrProviderNotFound Provider未安装
+	ErrProviderNotFound = errors.New("provider not found")
+	// ErrVersionNotFound Provider未安装指定版本
+	ErrVersionNotFound = errors.New("provider version not found")
+	// ErrNoTargetVersion 升级/回滚没有可切换的版本
+	ErrNoTargetVersion = errors.New("no version to switch to")
+	// ErrDefaultVersion 还有其他版本时不能删除默认版本
+	ErrDefaultVersion = errors.New("default version cannot be deleted while other versions are installed")
+	// ErrVersionPinned 版本被配置模板或未结束的任务固定使用
+	ErrVersionPinned = errors.New("provider version is pinned")
+)
+
+// PinnedError 要删除的版本仍被固定使用，Pins 列出固定方（profile:<名称> 或 job:<ID>）
+type PinnedError struct {
+	ProviderID string
+	Version    string
+	Pins       []string
+}
+
+func (e *PinnedError) Error() string {
+	return fmt.Sprintf("%v: %s@%s is pinned by %s", ErrVersionPinned, e.ProviderID, e.Version, strings.Join(e.Pins, ", "))
+}
+
+func (e *PinnedError) Unwrap() error {
+	return ErrVersionPinned
+}
+
 // PluginManager Provider插件管理器
 type PluginManager struct {
+	db                 *gorm.DB
 	storeDir           string // Private Store目录
+	keys               keystore.Provider
 	mu                 sync.RWMutex
-	plugins            map[string]*ProviderInfo
+	plugins            map[string]map[string]*ProviderInfo // id → version → info
 	drmManager         *crypto.DRMManager
 	watermarkValidator *audit.WatermarkValidator
 }
@@ -32,20 +84,38 @@ type ProviderInfo struct {
 	Model    string `json:"model"`
 	FilePath string `json:"file_path"`
 	Checksum string `json:"checksum"` // SHA256
+	// KeyVersion Store中加密保存该Provider使用的Master Key版本
+	KeyVersion int `json:"key_version"`
+	// IsDefault 未固定版本的任务使用默认版本
+	IsDefault bool `json:"is_default"`
 	Manifest Manifest `json:"manifest"`
 	Watermark audit.Watermark `json:"watermark"`
 	WatermarkViolation *audit.WatermarkViolation `json:"watermark_violation,omitempty"`
+	// Schema 包内 meta/schema.json（可选），用于生成默认配置和校验任务配置
+	Schema *ProviderSchema `json:"schema,omitempty"`
+
+	// encrypted Master Key加密的Provider二进制（仅用于会话重加密，不对外暴露）
+	encrypted []byte
 }
 
-// NewPluginManager 创建Plugin Manager
-func NewPluginManager(storeDir string, masterKey []byte, officialPubKey *ecdsa.PublicKey, currentLicenseID string) (*PluginManager, error) {
+// NewPluginManager 创建Plugin Manager（DRM使用密钥来源的当前版本）
+// Provider版本登记在 db 的 provider_versions 表中
+func NewPluginManager(db *gorm.DB, storeDir string, keys keystore.Provider, officialPubKey *ecdsa.PublicKey, currentLicenseID string) (*PluginManager, error) {
 	// 确保Store目录存在
 	if err := os.MkdirAll(storeDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create store directory: %w", err)
 	}
+	if err := os.MkdirAll(filepath.Join(storeDir, runDirName), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create provider run directory: %w", err)
+	}
+
+	masterKey, err := keys.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load master key: %w", err)
+	}
 
 	// 创建DRM管理器
-	drmManager, err := crypto.NewDRMManager(masterKey, officialPubKey)
+	drmManager, err := crypto.NewDRMManager(masterKey.Material, officialPubKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create DRM manager: %w", err)
 	}
@@ -58,14 +128,19 @@ func NewPluginManager(storeDir string, masterKey []byte, officialPubKey *ecdsa.P
 	}
 
 	pm := &PluginManager{
+		db:                 db,
 		storeDir:           storeDir,
-		plugins:            make(map[string]*ProviderInfo),
+		keys:               keys,
+		plugins:            make(map[string]map[string]*ProviderInfo),
 		drmManager:         drmManager,
 		watermarkValidator: watermarkValidator,
 	}
 
-	// 扫描已存在的Provider
-	if err := pm.scanProviders(); err != nil {
+	// 加载已登记的Provider版本，并迁移早期的Store布局
+	if err := pm.loadRegistry(); err != nil {
+		return nil, fmt.Errorf("failed to load provider registry: %w", err)
+	}
+	if err := pm.migrateStore(); err != nil {
 		return nil, fmt.Errorf("failed to scan providers: %w", err)
 	}
 
@@ -74,6 +149,9 @@ func NewPluginManager(storeDir string, masterKey []byte, officialPubKey *ecdsa.P
 
 // ImportProvider 导入Provider包（.cbp文件）
 // 完整的DRM解密和水印验证逻辑
+//
+// 同一Provider的不同版本并存：首个版本成为默认版本，之后导入的新版本需通过 Upgrade 切换；
+// 重新导入已安装的版本会覆盖该版本并保留其默认标记。
 func (pm *PluginManager) ImportProvider(cbpPath string) (*ProviderInfo, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -84,12 +162,10 @@ func (pm *PluginManager) ImportProvider(cbpPath string) (*ProviderInfo, error) {
 		return nil, fmt.Errorf("failed to parse cbp package: %w", err)
 	}
 
-	// 步骤2: 验证签名（防止篡改）
-	// 注意：实际签名应该是对整个.cbp文件的签名，这里简化为对manifest的签名
-	packageData := []byte(pkg.Manifest.ID + pkg.Manifest.Version)
-	valid, err := pm.drmManager.VerifyPackageSignature(packageData, pkg.Signature)
+	// 步骤2: 验证签名（覆盖包内全部条目的规范摘要，防止篡改）
+	valid, err := pm.drmManager.VerifyPackageSignature(pkg.Digest, pkg.Signature)
 	if err != nil || !valid {
-		return nil, fmt.Errorf("signature verification failed: invalid or tampered package")
+		return nil, fmt.Errorf("%w: invalid or tampered package", ErrInvalidPackageSignature)
 	}
 
 	// 步骤3: 验证水印
@@ -99,85 +175,198 @@ func (pm *PluginManager) ImportProvider(cbpPath string) (*ProviderInfo, error) {
 		pkg.Watermark,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("watermark validation failed: %w", err)
+		return nil, fmt.Errorf("%w: watermark validation failed: %w", ErrInvalidPackage, err)
 	}
 
-	// 步骤4: 解密Provider二进制
-	plainProvider, err := pm.drmManager.DecryptProviderWithMasterKey(pkg.ProviderBinary)
+	// 步骤4: 解密Provider二进制（manifest.key_version 为包使用的Master Key版本，缺省为当前版本）
+	packageKey, err := pm.packageKey(pkg.Manifest.KeyVersion)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt provider: %w", err)
+		return nil, fmt.Errorf("%w: failed to load package key: %w", ErrInvalidPackage, err)
 	}
-
-	// 步骤5: 保存明文Provider到临时文件（用于执行）
-	providerID := pkg.Manifest.ID
-	providerPath := filepath.Join(pm.storeDir, providerID)
-
-	if err := os.WriteFile(providerPath, plainProvider, 0755); err != nil {
-		return nil, fmt.Errorf("failed to save provider: %w", err)
+	plainProvider, err := crypto.DecryptFile(pkg.ProviderBinary, packageKey.Material)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decrypt provider: %w", ErrInvalidPackage, err)
 	}
 
-	// 步骤6: 计算校验和
+	// 步骤5: 计算校验和
 	hash := sha256.Sum256(plainProvider)
 	checksum := hex.EncodeToString(hash[:])
 
-	// 步骤7: 创建Provider信息
+	// 步骤6: 创建Provider信息
+	providerID := pkg.Manifest.ID
 	info := &ProviderInfo{
 		ID:                 providerID,
 		Name:               pkg.Manifest.Name,
 		Version:            pkg.Manifest.Version,
 		Vendor:             pkg.Manifest.Vendor,
 		Model:              pkg.Manifest.Model,
-		FilePath:           providerPath,
 		Checksum:           checksum,
 		Manifest:           pkg.Manifest,
 		Watermark:          pkg.Watermark,
 		WatermarkViolation: watermarkViolation, // 如果有违规，记录下来
 	}
+	if pkg.Schema != nil {
+		// ParseCBP 已校验过Schema
+		if info.Schema, err = ParseSchema(pkg.Schema); err != nil {
+			return nil, fmt.Errorf("%w: invalid provider schema: %w", ErrInvalidPackage, err)
+		}
+	}
+	info.IsDefault = pm.defaultFlag(providerID, info.Version)
+	if current, err := pm.keys.Current(); err == nil && current.Version == packageKey.Version {
+		info.encrypted = pkg.ProviderBinary
+	}
+
+	// 步骤7: 以当前Master Key版本加密保存并登记，解密到运行目录供执行
+	if err := pm.persist(info, plainProvider); err != nil {
+		return nil, fmt.Errorf("failed to save provider: %w", err)
+	}
 
-	pm.plugins[providerID] = info
+	pm.put(info)
 
 	return info, nil
 }
 
-// GetProvider 获取Provider信息
+// GetProvider 获取Provider默认版本的信息
 func (pm *PluginManager) GetProvider(id string) (*ProviderInfo, error) {
+	return pm.GetProviderVersion(id, "")
+}
+
+// GetProviderVersion 获取Provider指定版本的信息（version 为空时返回默认版本）
+func (pm *PluginManager) GetProviderVersion(id, version string) (*ProviderInfo, error) {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	info, ok := pm.plugins[id]
-	if !ok {
-		return nil, fmt.Errorf("provider not found: %s", id)
-	}
-
-	return info, nil
+	return pm.lookup(id, version)
 }
 
-// ListProviders 列出所有Provider
+// ListProviders 列出所有Provider（每个Provider只返回默认版本，按ID排序）
 func (pm *PluginManager) ListProviders() []*ProviderInfo {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
 	providers := make([]*ProviderInfo, 0, len(pm.plugins))
-	for _, info := range pm.plugins {
-		providers = append(providers, info)
+	for id := range pm.plugins {
+		if info := pm.defaultVersion(id); info != nil {
+			providers = append(providers, info)
+		}
 	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].ID < providers[j].ID })
 
 	return providers
 }
 
-// DeleteProvider 删除Provider
+// ListVersions 列出Provider已安装的全部版本（新版本在前）
+func (pm *PluginManager) ListVersions(id string) ([]*ProviderInfo, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	versions, ok := pm.plugins[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, id)
+	}
+	list := make([]*ProviderInfo, 0, len(versions))
+	for _, info := range versions {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return compareVersions(list[i].Version, list[j].Version) > 0 })
+	return list, nil
+}
+
+// SetDefault 把指定版本设为默认版本，返回之前的默认版本（可能为nil）和新的默认版本
+func (pm *PluginManager) SetDefault(id, version string) (previous, current *ProviderInfo, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	target, err := pm.lookup(id, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	previous = pm.defaultVersion(id)
+	if err := pm.setDefault(target); err != nil {
+		return nil, nil, err
+	}
+	return previous, target, nil
+}
+
+// Upgrade 把默认版本切换到更新的版本（version 为空时选择已安装的最新版本）
+func (pm *PluginManager) Upgrade(id, version string) (previous, current *ProviderInfo, err error) {
+	return pm.switchDefault(id, version, 1)
+}
+
+// Rollback 把默认版本切换到更旧的版本（version 为空时选择默认版本之前的最近版本）
+func (pm *PluginManager) Rollback(id, version string) (previous, current *ProviderInfo, err error) {
+	return pm.switchDefault(id, version, -1)
+}
+
+// switchDefault 按方向（1 升级，-1 回滚）切换默认版本
+func (pm *PluginManager) switchDefault(id, version string, direction int) (previous, current *ProviderInfo, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	versions, ok := pm.plugins[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrProviderNotFound, id)
+	}
+	previous = pm.defaultVersion(id)
+
+	var target *ProviderInfo
+	if version != "" {
+		if target, ok = versions[version]; !ok {
+			return nil, nil, fmt.Errorf("%w: %s@%s", ErrVersionNotFound, id, version)
+		}
+		if previous != nil && compareVersions(target.Version, previous.Version)*direction <= 0 {
+			return nil, nil, fmt.Errorf("%w: %s is not %s than %s", ErrNoTargetVersion, version, directionWord(direction), previous.Version)
+		}
+	} else {
+		// 选择方向上离当前默认版本最近的版本：升级取最新，回滚取紧邻的旧版本
+		for _, info := range versions {
+			if previous != nil && compareVersions(info.Version, previous.Version)*direction <= 0 {
+				continue
+			}
+			if target == nil || compareVersions(info.Version, target.Version) > 0 {
+				target = info
+			}
+		}
+		if target == nil {
+			return nil, nil, fmt.Errorf("%w: no %s version of %s installed", ErrNoTargetVersion, directionWord(direction), id)
+		}
+	}
+
+	if err := pm.setDefault(target); err != nil {
+		return nil, nil, err
+	}
+	return previous, target, nil
+}
+
+func directionWord(direction int) string {
+	if direction > 0 {
+		return "newer"
+	}
+	return "older"
+}
+
+// DeleteProvider 删除Provider的全部版本
 func (pm *PluginManager) DeleteProvider(id string) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	info, ok := pm.plugins[id]
+	versions, ok := pm.plugins[id]
 	if !ok {
-		return fmt.Errorf("provider not found: %s", id)
+		return fmt.Errorf("%w: %s", ErrProviderNotFound, id)
+	}
+	for version := range versions {
+		if err := pm.checkPins(id, version); err != nil {
+			return err
+		}
 	}
 
-	// 删除文件
-	if err := os.Remove(info.FilePath); err != nil {
-		return fmt.Errorf("failed to delete provider file: %w", err)
+	for _, info := range versions {
+		if err := pm.removeFiles(info); err != nil {
+			return err
+		}
+	}
+	if err := pm.db.Where("provider_id = ?", id).Delete(&models.ProviderVersion{}).Error; err != nil {
+		return fmt.Errorf("failed to delete provider record: %w", err)
 	}
 
 	// 从内存中移除
@@ -186,21 +375,245 @@ func (pm *PluginManager) DeleteProvider(id string) error {
 	return nil
 }
 
-// CreateExecutor 为指定Provider创建Executor
+// DeleteVersion 删除Provider的单个版本
+// 还有其他版本时不能删除默认版本；被配置模板或未结束的任务固定使用的版本不能删除；
+// 删除唯一的版本等同于删除Provider
+func (pm *PluginManager) DeleteVersion(id, version string) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	info, err := pm.lookup(id, version)
+	if err != nil {
+		return err
+	}
+	if info.IsDefault && len(pm.plugins[id]) > 1 {
+		return fmt.Errorf("%w: %s@%s", ErrDefaultVersion, id, version)
+	}
+	if err := pm.checkPins(id, info.Version); err != nil {
+		return err
+	}
+
+	if err := pm.removeFiles(info); err != nil {
+		return err
+	}
+	if err := pm.db.Where("provider_id = ? AND version = ?", id, version).Delete(&models.ProviderVersion{}).Error; err != nil {
+		return fmt.Errorf("failed to delete provider record: %w", err)
+	}
+
+	delete(pm.plugins[id], version)
+	if len(pm.plugins[id]) == 0 {
+		delete(pm.plugins, id)
+	}
+	return nil
+}
+
+// checkPins 检查版本是否被配置模板或未结束的任务固定使用
+func (pm *PluginManager) checkPins(id, version string) error {
+	var pins []string
+
+	var profiles []models.OSProfile
+	if err := pm.db.Select("id", "name", "provider_versions").Find(&profiles).Error; err != nil {
+		return fmt.Errorf("failed to query profiles: %w", err)
+	}
+	for _, p := range profiles {
+		if p.ProviderVersions[id] == version {
+			pins = append(pins, "profile:"+p.Name)
+		}
+	}
+
+	var jobs []models.Job
+	if err := pm.db.Select("id", "provider_versions").
+		Where("status IN ?", []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}).
+		Find(&jobs).Error; err != nil {
+		return fmt.Errorf("failed to query jobs: %w", err)
+	}
+	for _, j := range jobs {
+		if j.ProviderVersions[id] == version {
+			pins = append(pins, "job:"+j.ID)
+		}
+	}
+
+	if len(pins) > 0 {
+		return &PinnedError{ProviderID: id, Version: version, Pins: pins}
+	}
+	return nil
+}
+
+// SetLicenseID 更新水印校验使用的License ID（导入新License后调用）
+func (pm *PluginManager) SetLicenseID(licenseID string) {
+	pm.watermarkValidator.SetLicenseID(licenseID)
+}
+
+// DRM 返回DRM管理器
+func (pm *PluginManager) DRM() *crypto.DRMManager {
+	return pm.drmManager
+}
+
+// EncryptedBinary 返回Master Key加密的Provider二进制（version 为空时使用默认版本）
+// 启动时加载的Provider没有原始密文，首次调用时加密并缓存
+func (pm *PluginManager) EncryptedBinary(id, version string) ([]byte, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	info, err := pm.lookup(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.encrypted == nil {
+		plain, err := os.ReadFile(info.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read provider: %w", err)
+		}
+		encrypted, err := pm.drmManager.EncryptProviderWithMasterKey(plain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt provider: %w", err)
+		}
+		info.encrypted = encrypted
+	}
+
+	return info.encrypted, nil
+}
+
+// CreateExecutor 为指定Provider的默认版本创建Executor
+// 执行的是加载时从加密副本解密到 run 目录（仅属主可访问）的运行副本
 func (pm *PluginManager) CreateExecutor(id string) (*Executor, error) {
 	info, err := pm.GetProvider(id)
 	if err != nil {
 		return nil, err
 	}
+	return NewExecutor(info.FilePath), nil
+}
+
+// lookup 查找Provider版本（version 为空时返回默认版本），调用方需持有锁
+func (pm *PluginManager) lookup(id, version string) (*ProviderInfo, error) {
+	versions, ok := pm.plugins[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, id)
+	}
+	if version == "" {
+		if info := pm.defaultVersion(id); info != nil {
+			return info, nil
+		}
+		return nil, fmt.Errorf("%w: %s has no default version", ErrVersionNotFound, id)
+	}
+	info, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s@%s", ErrVersionNotFound, id, version)
+	}
+	return info, nil
+}
 
-	// TODO: 实现内存解密逻辑
-	// 当前直接使用文件路径（开发阶段）
+// defaultVersion 返回Provider的默认版本，调用方需持有锁
+func (pm *PluginManager) defaultVersion(id string) *ProviderInfo {
+	for _, info := range pm.plugins[id] {
+		if info.IsDefault {
+			return info
+		}
+	}
+	return nil
+}
 
-	return NewExecutor(info.FilePath), nil
+// defaultFlag 登记版本时的默认标记：覆盖已安装的版本时保留其标记，Provider的首个版本为默认版本
+func (pm *PluginManager) defaultFlag(id, version string) bool {
+	if existing, ok := pm.plugins[id][version]; ok {
+		return existing.IsDefault
+	}
+	return len(pm.plugins[id]) == 0
+}
+
+// put 登记到内存，调用方需持有锁
+func (pm *PluginManager) put(info *ProviderInfo) {
+	if pm.plugins[info.ID] == nil {
+		pm.plugins[info.ID] = make(map[string]*ProviderInfo)
+	}
+	pm.plugins[info.ID][info.Version] = info
+}
+
+// setDefault 在数据库和内存中切换默认版本，调用方需持有锁
+func (pm *PluginManager) setDefault(target *ProviderInfo) error {
+	err := pm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ProviderVersion{}).Where("provider_id = ?", target.ID).
+			Update("is_default", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.ProviderVersion{}).Where("provider_id = ? AND version = ?", target.ID, target.Version).
+			Update("is_default", true).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update default version: %w", err)
+	}
+	for _, info := range pm.plugins[target.ID] {
+		info.IsDefault = info == target
+	}
+	return nil
+}
+
+// loadRegistry 按数据库登记加载Provider版本，并解密到运行目录
+// 无法加载的版本（如缺少对应版本的Master Key）跳过并告警
+func (pm *PluginManager) loadRegistry() error {
+	var records []models.ProviderVersion
+	if err := pm.db.Order("provider_id, version").Find(&records).Error; err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := pm.loadVersion(record); err != nil {
+			log.Printf("⚠️  Provider %s 加载失败: %v", storeKey(record.ProviderID, record.Version), err)
+		}
+	}
+	return nil
+}
+
+// loadVersion 解密登记的Provider版本到运行目录
+func (pm *PluginManager) loadVersion(record models.ProviderVersion) error {
+	info := &ProviderInfo{}
+	if record.Metadata != "" {
+		if err := json.Unmarshal([]byte(record.Metadata), info); err != nil {
+			return fmt.Errorf("invalid metadata: %w", err)
+		}
+	}
+	info.ID = record.ProviderID
+	info.Version = record.Version
+	info.IsDefault = record.IsDefault
+	info.Checksum = record.Checksum
+
+	key := storeKey(info.ID, info.Version)
+	blob, err := os.ReadFile(pm.storePath(key, encryptedExt))
+	if err != nil {
+		return err
+	}
+	plain, version, err := keystore.Decrypt(pm.keys, blob)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(plain)
+	if checksum := hex.EncodeToString(hash[:]); info.Checksum != "" && checksum != info.Checksum {
+		return errors.New("checksum mismatch")
+	}
+
+	// 离线轮换（keytool）只改写密文，登记的密钥版本在此同步
+	info.KeyVersion = version
+	if version != record.KeyVersion {
+		pm.db.Model(&models.ProviderVersion{}).
+			Where("provider_id = ? AND version = ?", info.ID, info.Version).
+			Update("key_version", version)
+	}
+
+	info.FilePath = pm.runPath(key)
+	if err := os.WriteFile(info.FilePath, plain, 0755); err != nil {
+		return err
+	}
+	pm.put(info)
+	return nil
 }
 
-// scanProviders 扫描Store目录中已存在的Provider
-func (pm *PluginManager) scanProviders() error {
+// migrateStore 迁移早期的Store布局，只处理以下两种文件，其他文件保持不动
+//
+//	<id>.json + <id>.enc  按元数据登记为对应版本（两个文件须同时存在）
+//	<id>                  早期以可执行权限明文保存的Provider，加密后登记为 unknown 版本
+func (pm *PluginManager) migrateStore() error {
 	entries, err := os.ReadDir(pm.storeDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -210,67 +623,232 @@ func (pm *PluginManager) scanProviders() error {
 	}
 
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if !entry.Type().IsRegular() {
 			continue
 		}
 
-		filePath := filepath.Join(pm.storeDir, entry.Name())
-
-		// 计算校验和
-		checksum, err := calculateFileChecksum(filePath)
-		if err != nil {
-			continue // 跳过无法计算的文件
+		name := entry.Name()
+		if id, ok := strings.CutSuffix(name, metadataExt); ok {
+			if !legacyID(id) {
+				continue
+			}
+			if _, err := os.Stat(pm.storePath(id, encryptedExt)); err != nil {
+				continue
+			}
+			if err := pm.migrateMetadata(id); err != nil {
+				log.Printf("⚠️  Provider %s 迁移失败: %v", id, err)
+			}
+			continue
 		}
 
-		info := &ProviderInfo{
-			ID:       entry.Name(),
-			Name:     entry.Name(),
-			Version:  "unknown",
-			FilePath: filePath,
-			Checksum: checksum,
+		if !legacyID(name) || !legacyPlaintext(entry) {
+			continue
+		}
+		if err := pm.migrateLegacy(name); err != nil {
+			log.Printf("⚠️  Provider %s 迁移为加密存储失败: %v", name, err)
 		}
+	}
+
+	return nil
+}
+
+// legacyID 早期布局的Provider ID：合法名称且不含版本分隔符
+func legacyID(id string) bool {
+	return validName(id) && !strings.Contains(id, versionSep)
+}
 
-		pm.plugins[entry.Name()] = info
+// legacyPlaintext 早期明文Provider：无Store使用的扩展名且带可执行权限
+func legacyPlaintext(entry os.DirEntry) bool {
+	switch filepath.Ext(entry.Name()) {
+	case encryptedExt, metadataExt, ".tmp", ".cbp":
+		return false
 	}
+	info, err := entry.Info()
+	return err == nil && info.Mode().Perm()&0111 != 0
+}
+
+// migrateMetadata 把 <id>.json + <id>.enc 布局的Provider登记到数据库
+func (pm *PluginManager) migrateMetadata(id string) error {
+	metaPath := pm.storePath(id, metadataExt)
+	encPath := pm.storePath(id, encryptedExt)
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	info := &ProviderInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if info.ID != id {
+		return fmt.Errorf("metadata id %q does not match file name", info.ID)
+	}
+	if !validName(info.Version) {
+		info.Version = "unknown"
+	}
+
+	blob, err := os.ReadFile(encPath)
+	if err != nil {
+		return err
+	}
+	plain, _, err := keystore.Decrypt(pm.keys, blob)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(plain)
+	if checksum := hex.EncodeToString(hash[:]); info.Checksum != "" && checksum != info.Checksum {
+		return errors.New("checksum mismatch")
+	}
+
+	info.IsDefault = pm.defaultFlag(id, info.Version)
+	if err := pm.persist(info, plain); err != nil {
+		return err
+	}
+	pm.put(info)
 
+	for _, path := range []string{encPath, metaPath, pm.runPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
-// 辅助函数
+// migrateLegacy 把明文保存的Provider迁移为加密保存
+func (pm *PluginManager) migrateLegacy(name string) error {
+	legacyPath := filepath.Join(pm.storeDir, name)
+	plain, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return err
+	}
 
-func generateProviderID(path string) string {
-	hash := sha256.Sum256([]byte(path))
-	return hex.EncodeToString(hash[:])[:16]
+	hash := sha256.Sum256(plain)
+	info := &ProviderInfo{
+		ID:        name,
+		Name:      name,
+		Version:   "unknown",
+		Checksum:  hex.EncodeToString(hash[:]),
+		IsDefault: pm.defaultFlag(name, "unknown"),
+	}
+	if err := pm.persist(info, plain); err != nil {
+		return err
+	}
+	pm.put(info)
+	return os.Remove(legacyPath)
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// persist 以当前Master Key版本加密保存Provider并登记到数据库，写出运行副本
+func (pm *PluginManager) persist(info *ProviderInfo, plain []byte) error {
+	blob, err := keystore.Encrypt(pm.keys, plain)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	if info.KeyVersion, err = keystore.BlobVersion(blob); err != nil {
+		return err
+	}
+	key := storeKey(info.ID, info.Version)
+	info.FilePath = pm.runPath(key)
 
-	destFile, err := os.Create(dst)
+	metadata, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
+	if err := writeFileAtomic(pm.storePath(key, encryptedExt), blob, 0600); err != nil {
+		return err
+	}
+	record := models.ProviderVersion{
+		ProviderID: info.ID,
+		Version:    info.Version,
+		Name:       info.Name,
+		Vendor:     info.Vendor,
+		Model:      info.Model,
+		Checksum:   info.Checksum,
+		KeyVersion: info.KeyVersion,
+		IsDefault:  info.IsDefault,
+		Metadata:   string(metadata),
+	}
+	if err := pm.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&record).Error; err != nil {
+		return fmt.Errorf("failed to register provider: %w", err)
+	}
+	return os.WriteFile(info.FilePath, plain, 0755)
+}
+
+// removeFiles 删除Provider版本的加密副本和运行副本
+func (pm *PluginManager) removeFiles(info *ProviderInfo) error {
+	for _, path := range []string{
+		pm.storePath(storeKey(info.ID, info.Version), encryptedExt),
+		info.FilePath,
+	} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete provider file: %w", err)
+		}
+	}
+	return nil
+}
+
+// packageKey .cbp包使用的Master Key版本（0表示当前版本）
+func (pm *PluginManager) packageKey(version int) (*keystore.Key, error) {
+	if version == 0 {
+		return pm.keys.Current()
+	}
+	return pm.keys.Get(version)
+}
+
+// storeKey Provider版本在Store中的文件名（ID和版本号不含 @）
+func storeKey(id, version string) string {
+	return id + versionSep + version
+}
+
+func (pm *PluginManager) storePath(key, ext string) string {
+	return filepath.Join(pm.storeDir, key+ext)
+}
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+func (pm *PluginManager) runPath(key string) string {
+	return filepath.Join(pm.storeDir, runDirName, key)
 }
 
-func calculateFileChecksum(path string) (string, error) {
-	file, err := os.Open(path)
+// RewrapStore 把Store中加密保存的Provider重新加密到当前Master Key版本（离线轮换使用，需停止服务）
+// 返回重新加密的Provider数量
+func RewrapStore(storeDir string, keys keystore.Provider) (int, error) {
+	entries, err := os.ReadDir(storeDir)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	defer file.Close()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
+	rewrapped := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != encryptedExt {
+			continue
+		}
+		key := strings.TrimSuffix(entry.Name(), encryptedExt)
+		encPath := filepath.Join(storeDir, entry.Name())
+
+		blob, err := os.ReadFile(encPath)
+		if err != nil {
+			return rewrapped, err
+		}
+		newBlob, changed, err := keystore.Rewrap(keys, blob)
+		if err != nil {
+			return rewrapped, fmt.Errorf("provider %s: %w", key, err)
+		}
+		if !changed {
+			continue
+		}
+		// 密文头部自带版本号，替换 .enc 即完成轮换；登记的版本号在服务启动时同步
+		if err := writeFileAtomic(encPath, newBlob, 0600); err != nil {
+			return rewrapped, fmt.Errorf("provider %s: %w", key, err)
+		}
+		rewrapped++
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return rewrapped, nil
+}
+
+// writeFileAtomic 写入临时文件后改名
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
@@ -0,0 +1,325 @@
+package cspm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/audit"
+	"github.com/cloudboot/cloudboot-ng/internal/core/keystore"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupRegistryDB 创建Provider登记使用的测试数据库
+func setupRegistryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.ProviderVersion{}, &models.OSProfile{}, &models.Job{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return db
+}
+
+// buildTestCBP 用指定版本的Master Key加密并签名一个测试.cbp包
+func buildTestCBP(t *testing.T, dir, version string, key *keystore.Key, signer *ecdsa.PrivateKey, binary []byte) string {
+	t.Helper()
+	encrypted, err := crypto.EncryptFile(binary, key.Material)
+	if err != nil {
+		t.Fatalf("EncryptFile() error = %v", err)
+	}
+	manifest := Manifest{ID: "raid-mock", Name: "RAID Mock", Version: version, KeyVersion: key.Version}
+	path := filepath.Join(dir, "raid-mock-"+version+".cbp")
+	schema := []byte(`{"version":"1","parameters":[{"name":"level","type":"string","default":"raid1"}]}`)
+	pkg := &CBPPackage{Manifest: manifest, Watermark: audit.Watermark{LicenseID: "lic-1"}, Schema: schema, ProviderBinary: encrypted}
+	if err := CreateCBP(pkg, signer, path); err != nil {
+		t.Fatalf("CreateCBP() error = %v", err)
+	}
+	return path
+}
+
+func TestPluginManagerPersistsAcrossRestart(t *testing.T) {
+	root := t.TempDir()
+	storeDir := filepath.Join(root, "store")
+	db := setupRegistryDB(t)
+	keys, err := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	signer, _ := crypto.GenerateECDSAKeyPair()
+	v1, _ := keys.Current()
+	binary := []byte("#!/bin/sh\necho provider\n")
+
+	pm, err := NewPluginManager(db, storeDir, keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() error = %v", err)
+	}
+	info, err := pm.ImportProvider(buildTestCBP(t, root, "1.0.0", v1, signer, binary))
+	if err != nil {
+		t.Fatalf("ImportProvider() error = %v", err)
+	}
+	if info.KeyVersion != 1 {
+		t.Errorf("KeyVersion = %d, want 1", info.KeyVersion)
+	}
+
+	// Store中只保存密文
+	stored, _ := os.ReadFile(filepath.Join(storeDir, "raid-mock@1.0.0.enc"))
+	if bytes.Contains(stored, binary) {
+		t.Errorf("provider stored in plaintext")
+	}
+
+	// 轮换并重新加密后，重启仍能解密
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if n, err := RewrapStore(storeDir, keys); err != nil || n != 1 {
+		t.Fatalf("RewrapStore() = %d, %v", n, err)
+	}
+	if n, err := RewrapStore(storeDir, keys); err != nil || n != 0 {
+		t.Errorf("second RewrapStore() = %d, %v, want 0", n, err)
+	}
+
+	reopened, err := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	pm, err = NewPluginManager(db, storeDir, reopened, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() after restart error = %v", err)
+	}
+	got, err := pm.GetProvider("raid-mock")
+	if err != nil {
+		t.Fatalf("GetProvider() error = %v", err)
+	}
+	if got.KeyVersion != 2 || got.Name != "RAID Mock" || got.Version != "1.0.0" || !got.IsDefault {
+		t.Errorf("provider after restart = %+v", got)
+	}
+	if got.Schema == nil || got.Schema.GenerateDefaultConfig()["level"] != "raid1" {
+		t.Errorf("schema after restart = %+v", got.Schema)
+	}
+	if plain, _ := os.ReadFile(got.FilePath); !bytes.Equal(plain, binary) {
+		t.Errorf("decrypted provider does not match the imported binary")
+	}
+
+	// 旧版本包仍可导入（按 manifest.key_version 解密）
+	if _, err := pm.ImportProvider(buildTestCBP(t, root, "1.0.0", v1, signer, binary)); err != nil {
+		t.Errorf("ImportProvider() with key v1 error = %v", err)
+	}
+
+	if err := pm.DeleteProvider("raid-mock"); err != nil {
+		t.Fatalf("DeleteProvider() error = %v", err)
+	}
+	for _, name := range []string{"raid-mock@1.0.0.enc", "run/raid-mock@1.0.0"} {
+		if _, err := os.Stat(filepath.Join(storeDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after delete", name)
+		}
+	}
+	var count int64
+	db.Model(&models.ProviderVersion{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d provider records left after delete", count)
+	}
+}
+
+func TestPluginManagerMigratesLegacyPlaintext(t *testing.T) {
+	root := t.TempDir()
+	storeDir := filepath.Join(root, "store")
+	os.MkdirAll(storeDir, 0755)
+	os.WriteFile(filepath.Join(storeDir, "legacy-provider"), []byte("legacy"), 0755)
+
+	keys, _ := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+	signer, _ := crypto.GenerateECDSAKeyPair()
+	pm, err := NewPluginManager(setupRegistryDB(t), storeDir, keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() error = %v", err)
+	}
+
+	info, err := pm.GetProvider("legacy-provider")
+	if err != nil || info.KeyVersion != 1 || info.Version != "unknown" || !info.IsDefault {
+		t.Fatalf("GetProvider() = %+v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(storeDir, "legacy-provider")); !os.IsNotExist(err) {
+		t.Errorf("legacy plaintext file should be removed after migration")
+	}
+	if _, err := os.Stat(filepath.Join(storeDir, "legacy-provider@unknown.enc")); err != nil {
+		t.Errorf("encrypted copy missing: %v", err)
+	}
+}
+
+func TestPluginManagerMigratesMetadataLayout(t *testing.T) {
+	root := t.TempDir()
+	storeDir := filepath.Join(root, "store")
+	os.MkdirAll(storeDir, 0755)
+	keys, _ := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+
+	// 旧布局：<id>.enc + <id>.json
+	blob, _ := keystore.Encrypt(keys, []byte("provider"))
+	os.WriteFile(filepath.Join(storeDir, "raid-mock.enc"), blob, 0600)
+	os.WriteFile(filepath.Join(storeDir, "raid-mock.json"), []byte(`{"id":"raid-mock","name":"RAID Mock","version":"1.2.0","vendor":"LSI"}`), 0600)
+
+	db := setupRegistryDB(t)
+	signer, _ := crypto.GenerateECDSAKeyPair()
+	pm, err := NewPluginManager(db, storeDir, keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() error = %v", err)
+	}
+
+	info, err := pm.GetProvider("raid-mock")
+	if err != nil || info.Version != "1.2.0" || info.Vendor != "LSI" || !info.IsDefault {
+		t.Fatalf("GetProvider() = %+v, %v", info, err)
+	}
+	var record models.ProviderVersion
+	if err := db.First(&record, "provider_id = ? AND version = ?", "raid-mock", "1.2.0").Error; err != nil || !record.IsDefault {
+		t.Errorf("provider record = %+v, %v", record, err)
+	}
+	for _, name := range []string{"raid-mock.enc", "raid-mock.json"} {
+		if _, err := os.Stat(filepath.Join(storeDir, name)); !os.IsNotExist(err) {
+			t.Errorf("legacy file %s should be removed after migration", name)
+		}
+	}
+}
+
+func TestPluginManagerSkipsUnknownStoreFiles(t *testing.T) {
+	root := t.TempDir()
+	storeDir := filepath.Join(root, "store")
+	os.MkdirAll(storeDir, 0755)
+	keys, _ := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+
+	// 不属于任何旧布局的文件：无执行权限的普通文件、缺少 .enc 的元数据、孤立的 .enc、版本化命名的文件
+	files := map[string]os.FileMode{
+		"README":            0644,
+		"notes.json":        0600,
+		"orphan.enc":        0600,
+		"raid-mock@1.0.0":   0755,
+		"raid-mock.cbp":     0755,
+		"provider.txt.json": 0600,
+	}
+	for name, mode := range files {
+		os.WriteFile(filepath.Join(storeDir, name), []byte(`{"id":"x"}`), mode)
+	}
+
+	signer, _ := crypto.GenerateECDSAKeyPair()
+	pm, err := NewPluginManager(setupRegistryDB(t), storeDir, keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() error = %v", err)
+	}
+	if list := pm.ListProviders(); len(list) != 0 {
+		t.Errorf("ListProviders() = %d providers, want none", len(list))
+	}
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(storeDir, name)); err != nil {
+			t.Errorf("unrelated file %s should be left alone: %v", name, err)
+		}
+	}
+}
+
+func TestPluginManagerVersions(t *testing.T) {
+	root := t.TempDir()
+	storeDir := filepath.Join(root, "store")
+	db := setupRegistryDB(t)
+	keys, _ := keystore.OpenFile(filepath.Join(root, "keystore.json"), "secret")
+	key, _ := keys.Current()
+	signer, _ := crypto.GenerateECDSAKeyPair()
+
+	pm, err := NewPluginManager(db, storeDir, keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() error = %v", err)
+	}
+	for _, version := range []string{"1.0.0", "1.10.0", "1.2.0"} {
+		if _, err := pm.ImportProvider(buildTestCBP(t, root, version, key, signer, []byte("provider "+version))); err != nil {
+			t.Fatalf("ImportProvider(%s) error = %v", version, err)
+		}
+	}
+
+	// 首个版本为默认版本，之后导入的版本不改变默认版本
+	if got, _ := pm.GetProvider("raid-mock"); got.Version != "1.0.0" {
+		t.Errorf("default version = %s, want 1.0.0", got.Version)
+	}
+	versions, _ := pm.ListVersions("raid-mock")
+	if len(versions) != 3 || versions[0].Version != "1.10.0" || versions[2].Version != "1.0.0" {
+		t.Errorf("ListVersions() order = %v", versions)
+	}
+	if n := len(pm.ListProviders()); n != 1 {
+		t.Errorf("ListProviders() returned %d entries, want 1", n)
+	}
+
+	steps := []struct {
+		name    string
+		op      func(id, version string) (*ProviderInfo, *ProviderInfo, error)
+		version string
+		want    string
+		wantErr error
+	}{
+		{"upgrade to latest", pm.Upgrade, "", "1.10.0", nil},
+		{"upgrade without newer", pm.Upgrade, "", "", ErrNoTargetVersion},
+		{"rollback to previous", pm.Rollback, "", "1.2.0", nil},
+		{"rollback to newer rejected", pm.Rollback, "1.10.0", "", ErrNoTargetVersion},
+		{"upgrade to missing version", pm.Upgrade, "9.0.0", "", ErrVersionNotFound},
+		{"rollback to explicit version", pm.Rollback, "1.0.0", "1.0.0", nil},
+		{"upgrade to explicit version", pm.Upgrade, "1.2.0", "1.2.0", nil},
+	}
+	for _, step := range steps {
+		_, current, err := step.op("raid-mock", step.version)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: error = %v, want %v", step.name, err, step.wantErr)
+		}
+		if err == nil && current.Version != step.want {
+			t.Fatalf("%s: default = %s, want %s", step.name, current.Version, step.want)
+		}
+	}
+
+	// 固定版本的任务仍可取到非默认版本
+	old, err := pm.GetProviderVersion("raid-mock", "1.0.0")
+	if err != nil || old.IsDefault {
+		t.Fatalf("GetProviderVersion(1.0.0) = %+v, %v", old, err)
+	}
+	if plain, _ := os.ReadFile(old.FilePath); string(plain) != "provider 1.0.0" {
+		t.Errorf("run copy of 1.0.0 = %q", plain)
+	}
+
+	if err := pm.DeleteVersion("raid-mock", "1.2.0"); !errors.Is(err, ErrDefaultVersion) {
+		t.Errorf("DeleteVersion(default) error = %v, want ErrDefaultVersion", err)
+	}
+
+	// 被配置模板或未结束的任务固定的版本不能删除，已结束的任务不算
+	pins := map[string]string{"raid-mock": "1.0.0"}
+	db.Create(&models.OSProfile{ID: "profile-1", Name: "raid-profile", ProviderVersions: pins})
+	db.Create(&models.Job{ID: "job-1", Status: models.JobStatusRunning, ProviderVersions: pins})
+	db.Create(&models.Job{ID: "job-2", Status: models.JobStatusSuccess, ProviderVersions: pins})
+	var pinned *PinnedError
+	if err := pm.DeleteVersion("raid-mock", "1.0.0"); !errors.As(err, &pinned) ||
+		strings.Join(pinned.Pins, ",") != "profile:raid-profile,job:job-1" {
+		t.Fatalf("DeleteVersion(pinned) error = %v, want pins profile:raid-profile,job:job-1", err)
+	}
+	if err := pm.DeleteProvider("raid-mock"); !errors.Is(err, ErrVersionPinned) {
+		t.Errorf("DeleteProvider(pinned) error = %v, want ErrVersionPinned", err)
+	}
+	db.Delete(&models.OSProfile{}, "id = ?", "profile-1")
+	db.Model(&models.Job{}).Where("id = ?", "job-1").Update("status", models.JobStatusFailed)
+
+	if err := pm.DeleteVersion("raid-mock", "1.0.0"); err != nil {
+		t.Fatalf("DeleteVersion(1.0.0) error = %v", err)
+	}
+
+	// 重启后从数据库恢复版本和默认版本
+	pm, err = NewPluginManager(db, storeDir, keys, &signer.PublicKey, "lic-1")
+	if err != nil {
+		t.Fatalf("NewPluginManager() after restart error = %v", err)
+	}
+	got, err := pm.GetProvider("raid-mock")
+	if err != nil || got.Version != "1.2.0" || got.Name != "RAID Mock" {
+		t.Errorf("default after restart = %+v, %v", got, err)
+	}
+	if versions, _ := pm.ListVersions("raid-mock"); len(versions) != 2 {
+		t.Errorf("versions after restart = %d, want 2", len(versions))
+	}
+}
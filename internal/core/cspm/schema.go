@@ -3,33 +3,37 @@ package cspm
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"regexp"
 )
 
 // ProviderSchema defines the configuration schema for a Provider
 type ProviderSchema struct {
-	Version    string                  `json:"version"`
+	Version    string                `json:"version"`
 	Parameters []ParameterDefinition `json:"parameters"`
 }
 
 // ParameterDefinition defines a single configuration parameter
 type ParameterDefinition struct {
-	Name        string        `json:"name"`
-	Type        string        `json:"type"`        // string, integer, boolean, array, object
-	Required    bool          `json:"required"`
-	Default     interface{}   `json:"default,omitempty"`
-	Description string        `json:"description"`
-	Constraints *Constraints  `json:"constraints,omitempty"`
-	Options     []Option      `json:"options,omitempty"` // For enum-like parameters
+	Name        string                `json:"name"`
+	Type        string                `json:"type"` // string, integer, boolean, array, object
+	Required    bool                  `json:"required"`
+	Default     interface{}           `json:"default,omitempty"`
+	Description string                `json:"description"`
+	Constraints *Constraints          `json:"constraints,omitempty"`
+	Options     []Option              `json:"options,omitempty"`    // For enum-like parameters
+	Items       *ParameterDefinition  `json:"items,omitempty"`      // Element definition for arrays
+	Properties  []ParameterDefinition `json:"properties,omitempty"` // Nested parameters for objects
 }
 
 // Constraints defines validation rules for a parameter
 type Constraints struct {
-	Min         *int     `json:"min,omitempty"`          // For integers
-	Max         *int     `json:"max,omitempty"`          // For integers
-	MinLength   *int     `json:"min_length,omitempty"`   // For strings
-	MaxLength   *int     `json:"max_length,omitempty"`   // For strings
-	Pattern     string   `json:"pattern,omitempty"`      // Regex for strings
-	Enum        []string `json:"enum,omitempty"`         // Allowed values
+	Min       *int     `json:"min,omitempty"`        // For integers
+	Max       *int     `json:"max,omitempty"`        // For integers
+	MinLength *int     `json:"min_length,omitempty"` // For strings
+	MaxLength *int     `json:"max_length,omitempty"` // For strings
+	Pattern   string   `json:"pattern,omitempty"`    // Regex for strings (unanchored, use ^...$ for a full match)
+	Enum      []string `json:"enum,omitempty"`       // Allowed values
 }
 
 // Option represents a selectable option for a parameter
@@ -39,40 +43,135 @@ type Option struct {
 	Description string `json:"description,omitempty"`
 }
 
-// ParseSchema parses a schema JSON string
+var parameterTypes = map[string]bool{
+	"string":  true,
+	"integer": true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+}
+
+// ParseSchema parses a schema JSON string and checks that its definitions are usable
 func ParseSchema(schemaJSON []byte) (*ProviderSchema, error) {
 	var schema ProviderSchema
 	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
 		return nil, fmt.Errorf("failed to parse schema: %w", err)
 	}
+	if err := schema.checkParameters("", schema.Parameters); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
 	return &schema, nil
 }
 
-// ValidateConfig validates a configuration against the schema
+// checkParameters rejects unnamed or duplicate parameters and invalid definitions
+func (s *ProviderSchema) checkParameters(prefix string, params []ParameterDefinition) error {
+	seen := make(map[string]bool, len(params))
+	for _, param := range params {
+		path := prefix + param.Name
+		if param.Name == "" {
+			return fmt.Errorf("parameter without name in '%s'", prefix)
+		}
+		if seen[param.Name] {
+			return fmt.Errorf("duplicate parameter '%s'", path)
+		}
+		seen[param.Name] = true
+
+		if err := s.checkDefinition(path, param); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ProviderSchema) checkDefinition(path string, param ParameterDefinition) error {
+	if !parameterTypes[param.Type] {
+		return fmt.Errorf("parameter '%s': unknown type: %s", path, param.Type)
+	}
+	if c := param.Constraints; c != nil && c.Pattern != "" {
+		if _, err := regexp.Compile(c.Pattern); err != nil {
+			return fmt.Errorf("parameter '%s': invalid pattern: %w", path, err)
+		}
+	}
+	if param.Items != nil {
+		if param.Type != "array" {
+			return fmt.Errorf("parameter '%s': items is only allowed for arrays", path)
+		}
+		if err := s.checkDefinition(path+"[]", *param.Items); err != nil {
+			return err
+		}
+	}
+	if len(param.Properties) > 0 {
+		if param.Type != "object" {
+			return fmt.Errorf("parameter '%s': properties is only allowed for objects", path)
+		}
+		if err := s.checkParameters(path+".", param.Properties); err != nil {
+			return err
+		}
+	}
+	if param.Default != nil {
+		if err := s.validateValue(path, param, param.Default); err != nil {
+			return fmt.Errorf("invalid default: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateConfig validates a configuration against the schema.
+// Array items and nested object parameters are validated recursively;
+// errors name the offending parameter by path (e.g. "disks[1].size").
 func (s *ProviderSchema) ValidateConfig(config map[string]interface{}) error {
-	for _, param := range s.Parameters {
+	return s.validateParameters("", s.Parameters, config)
+}
+
+func (s *ProviderSchema) validateParameters(prefix string, params []ParameterDefinition, config map[string]interface{}) error {
+	for _, param := range params {
+		path := prefix + param.Name
 		value, exists := config[param.Name]
 
 		// Check required parameters
 		if param.Required && !exists {
-			return fmt.Errorf("required parameter '%s' is missing", param.Name)
+			return fmt.Errorf("required parameter '%s' is missing", path)
 		}
 
 		if !exists {
 			continue // Optional parameter not provided
 		}
 
-		// Type validation
-		if err := s.validateType(param, value); err != nil {
-			return fmt.Errorf("parameter '%s': %w", param.Name, err)
+		if err := s.validateValue(path, param, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ProviderSchema) validateValue(path string, param ParameterDefinition, value interface{}) error {
+	// Type validation
+	if err := s.validateType(param, value); err != nil {
+		return fmt.Errorf("parameter '%s': %w", path, err)
+	}
+
+	// Constraint validation
+	if param.Constraints != nil {
+		if err := s.validateConstraints(param, value); err != nil {
+			return fmt.Errorf("parameter '%s': %w", path, err)
 		}
+	}
 
-		// Constraint validation
-		if param.Constraints != nil {
-			if err := s.validateConstraints(param, value); err != nil {
-				return fmt.Errorf("parameter '%s': %w", param.Name, err)
+	switch v := value.(type) {
+	case []interface{}:
+		if param.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.validateValue(fmt.Sprintf("%s[%d]", path, i), *param.Items, item); err != nil {
+				return err
 			}
 		}
+	case map[string]interface{}:
+		if len(param.Properties) > 0 {
+			return s.validateParameters(path+".", param.Properties, v)
+		}
 	}
 
 	return nil
@@ -85,12 +184,14 @@ func (s *ProviderSchema) validateType(param ParameterDefinition, value interface
 			return fmt.Errorf("expected string, got %T", value)
 		}
 	case "integer":
-		switch value.(type) {
-		case int, int64, float64:
-			// JSON numbers are float64
-		default:
+		// JSON numbers are float64
+		num, ok := toNumber(value)
+		if !ok {
 			return fmt.Errorf("expected integer, got %T", value)
 		}
+		if num != math.Trunc(num) {
+			return fmt.Errorf("expected integer, got %v", num)
+		}
 	case "boolean":
 		if _, ok := value.(bool); !ok {
 			return fmt.Errorf("expected boolean, got %T", value)
@@ -121,6 +222,15 @@ func (s *ProviderSchema) validateConstraints(param ParameterDefinition, value in
 		if c.MaxLength != nil && len(str) > *c.MaxLength {
 			return fmt.Errorf("string length %d exceeds maximum %d", len(str), *c.MaxLength)
 		}
+		if c.Pattern != "" {
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern: %w", err)
+			}
+			if !re.MatchString(str) {
+				return fmt.Errorf("value '%s' does not match pattern %s", str, c.Pattern)
+			}
+		}
 		if c.Enum != nil {
 			valid := false
 			for _, allowed := range c.Enum {
@@ -136,7 +246,7 @@ func (s *ProviderSchema) validateConstraints(param ParameterDefinition, value in
 	}
 
 	// Integer constraints
-	if num, ok := value.(float64); ok { // JSON numbers are float64
+	if num, ok := toNumber(value); ok {
 		intVal := int(num)
 		if c.Min != nil && intVal < *c.Min {
 			return fmt.Errorf("value %d is less than minimum %d", intVal, *c.Min)
@@ -149,13 +259,36 @@ func (s *ProviderSchema) validateConstraints(param ParameterDefinition, value in
 	return nil
 }
 
-// GenerateDefaultConfig generates a default configuration from the schema
+// toNumber converts JSON (float64) and Go integer values to float64
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// GenerateDefaultConfig generates a default configuration from the schema.
+// Objects without their own default collect the defaults of their properties.
 func (s *ProviderSchema) GenerateDefaultConfig() map[string]interface{} {
+	return s.defaults(s.Parameters)
+}
+
+func (s *ProviderSchema) defaults(params []ParameterDefinition) map[string]interface{} {
 	config := make(map[string]interface{})
 
-	for _, param := range s.Parameters {
+	for _, param := range params {
 		if param.Default != nil {
 			config[param.Name] = param.Default
+		} else if param.Type == "object" && len(param.Properties) > 0 {
+			if nested := s.defaults(param.Properties); len(nested) > 0 {
+				config[param.Name] = nested
+			}
 		}
 	}
 
@@ -1,6 +1,8 @@
 package cspm
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -215,3 +217,99 @@ func TestGenerateDefaultConfig(t *testing.T) {
 		t.Error("Expected name to not be in default config")
 	}
 }
+
+func TestValidateConfig_NestedAndPatterns(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"version": "1.0",
+		"parameters": [
+			{"name": "hostname", "type": "string", "constraints": {"pattern": "^[a-z][a-z0-9-]*$"}},
+			{"name": "drives", "type": "array", "items": {"type": "string", "constraints": {"pattern": "^sd[a-z]$"}}},
+			{
+				"name": "volumes",
+				"type": "array",
+				"items": {
+					"type": "object",
+					"properties": [
+						{"name": "level", "type": "string", "required": true, "constraints": {"enum": ["0", "1", "5"]}},
+						{"name": "size_gb", "type": "integer", "constraints": {"min": 1}}
+					]
+				}
+			},
+			{
+				"name": "cache",
+				"type": "object",
+				"properties": [
+					{"name": "policy", "type": "string", "default": "write-back"},
+					{"name": "enabled", "type": "boolean", "required": true, "default": true}
+				]
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"valid", `{"hostname":"node-1","drives":["sda","sdb"],"volumes":[{"level":"1","size_gb":100}],"cache":{"enabled":false}}`, ""},
+		{"pattern mismatch", `{"hostname":"Node_1"}`, "parameter 'hostname'"},
+		{"array item type", `{"drives":["sda",1]}`, "parameter 'drives[1]': expected string"},
+		{"array item pattern", `{"drives":["sda","nvme0n1"]}`, "parameter 'drives[1]'"},
+		{"nested required", `{"volumes":[{"level":"1"},{"size_gb":10}]}`, "required parameter 'volumes[1].level' is missing"},
+		{"nested enum", `{"volumes":[{"level":"6"}]}`, "parameter 'volumes[0].level'"},
+		{"nested min", `{"volumes":[{"level":"0","size_gb":0}]}`, "parameter 'volumes[0].size_gb'"},
+		{"nested integer", `{"volumes":[{"level":"0","size_gb":1.5}]}`, "parameter 'volumes[0].size_gb': expected integer"},
+		{"nested object type", `{"cache":{"enabled":"yes"}}`, "parameter 'cache.enabled': expected boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.config), &config); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			err := schema.ValidateConfig(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	defaults := schema.GenerateDefaultConfig()
+	cache, _ := defaults["cache"].(map[string]interface{})
+	if cache["policy"] != "write-back" || cache["enabled"] != true {
+		t.Errorf("GenerateDefaultConfig() cache = %v", defaults["cache"])
+	}
+}
+
+func TestParseSchema_InvalidDefinitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"unknown type", `{"parameters":[{"name":"a","type":"float"}]}`},
+		{"missing name", `{"parameters":[{"type":"string"}]}`},
+		{"duplicate name", `{"parameters":[{"name":"a","type":"string"},{"name":"a","type":"integer"}]}`},
+		{"invalid pattern", `{"parameters":[{"name":"a","type":"string","constraints":{"pattern":"("}}]}`},
+		{"items on string", `{"parameters":[{"name":"a","type":"string","items":{"type":"string"}}]}`},
+		{"unknown item type", `{"parameters":[{"name":"a","type":"array","items":{"type":"float"}}]}`},
+		{"invalid nested default", `{"parameters":[{"name":"a","type":"object","properties":[{"name":"b","type":"integer","default":"x"}]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSchema([]byte(tt.schema)); err == nil {
+				t.Errorf("ParseSchema() accepted %s", tt.schema)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package cspm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// compareVersions 比较Provider版本号，返回 -1、0 或 1
+//
+// 按语义化版本优先级比较：主版本号按 "." 分段逐段比较，前缀相同时段数多的版本更新（1.0.1 > 1.0）；
+// 主版本号相同时带预发布标识的版本更旧（1.0.0-rc1 < 1.0.0），预发布标识之间按 semver 规则比较；
+// "+" 之后的构建元数据不参与比较。
+func compareVersions(a, b string) int {
+	coreA, preA := splitVersion(a)
+	coreB, preB := splitVersion(b)
+	if c := compareIdentifiers(coreA, coreB); c != 0 {
+		return c
+	}
+	switch {
+	case preA == nil && preB == nil:
+		return 0
+	case preA == nil:
+		return 1
+	case preB == nil:
+		return -1
+	}
+	return compareIdentifiers(preA, preB)
+}
+
+// splitVersion 拆分主版本号和预发布标识（无预发布标识时为 nil）
+func splitVersion(v string) (core, pre []string) {
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "v"), "V")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	return strings.Split(v, "."), pre
+}
+
+// compareIdentifiers 逐段比较，前缀相同时段数多的更大
+func compareIdentifiers(pa, pb []string) int {
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if c := compareVersionPart(pa[i], pb[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	}
+	return 0
+}
+
+// compareVersionPart 比较单个标识：数字按数值比较，数字低于非数字，非数字按字符串比较
+func compareVersionPart(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
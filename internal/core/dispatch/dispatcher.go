@@ -0,0 +1,318 @@
+package dispatch
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"gorm.io/gorm"
+)
+
+// DefaultSessionTTL Provider下载链接默认有效期
+const DefaultSessionTTL = 10 * time.Minute
+
+var (
+	// ErrNoProvider 没有与机器硬件匹配的Provider
+	ErrNoProvider = errors.New("no provider matches machine hardware")
+	// ErrInvalidConfig 叠加Overlay后的任务配置不符合Provider的Schema
+	ErrInvalidConfig = errors.New("provider config rejected by schema")
+	// ErrFeatureNotLicensed 动作需要的License功能未授权
+	ErrFeatureNotLicensed = errors.New("feature not licensed")
+)
+
+// ProviderSource Provider来源（由 cspm.PluginManager 实现）
+type ProviderSource interface {
+	// ListProviders 返回每个Provider的默认版本
+	ListProviders() []*cspm.ProviderInfo
+	GetProviderVersion(id, version string) (*cspm.ProviderInfo, error)
+	EncryptedBinary(id, version string) ([]byte, error)
+	DRM() *crypto.DRMManager
+}
+
+// FeatureChecker License功能校验（由 license.Manager 实现）
+type FeatureChecker interface {
+	HasFeature(feature string) bool
+}
+
+// TaskSpec 下发给Agent的任务规范
+type TaskSpec struct {
+	TaskID          string                 `json:"task_id"`
+	StepID          string                 `json:"step_id,omitempty"`
+	Action          models.StepAction      `json:"action"`
+	Attempt         int                    `json:"attempt,omitempty"`
+	Timeout         int                    `json:"timeout,omitempty"`
+	ProviderID      string                 `json:"provider_id,omitempty"`
+	ProviderVersion string                 `json:"provider_version,omitempty"`
+	ProviderURL     string                 `json:"provider_url"`
+	SessionKey      string                 `json:"session_key"`
+	ExpiresAt       *time.Time             `json:"expires_at,omitempty"`
+	Config          map[string]interface{} `json:"config"`
+}
+
+// Dispatcher 将任务/工作流步骤转换为Agent可执行的任务规范
+type Dispatcher struct {
+	providers ProviderSource
+	serverURL string
+	ttl       time.Duration
+	sessions  *SessionStore
+	licenses  FeatureChecker
+}
+
+// NewDispatcher 创建任务分发器
+// providers 为 nil 时需要Provider的任务将分发失败
+func NewDispatcher(providers ProviderSource, serverURL string) *Dispatcher {
+	return &Dispatcher{
+		providers: providers,
+		serverURL: strings.TrimRight(serverURL, "/"),
+		ttl:       DefaultSessionTTL,
+		sessions:  NewSessionStore(),
+	}
+}
+
+// SetTTL 设置Provider下载链接有效期
+func (d *Dispatcher) SetTTL(ttl time.Duration) {
+	d.ttl = ttl
+}
+
+// SetLicenses 设置License管理器（config_raid 动作需要 advanced_raid 功能，未设置时不校验）
+func (d *Dispatcher) SetLicenses(licenses FeatureChecker) {
+	d.licenses = licenses
+}
+
+// Sessions 返回Provider会话存储
+func (d *Dispatcher) Sessions() *SessionStore {
+	return d.sessions
+}
+
+// legacyActions 无步骤任务的类型到动作映射
+var legacyActions = map[models.JobType]models.StepAction{
+	models.JobTypeAudit:      models.StepActionAudit,
+	models.JobTypeConfigRAID: models.StepActionConfigRAID,
+	models.JobTypeInstallOS:  models.StepActionInstallOS,
+}
+
+// providerActions 需要下发Provider的动作
+var providerActions = map[models.StepAction]bool{
+	models.StepActionConfigRAID: true,
+}
+
+// actionFeatures 动作需要的License功能
+var actionFeatures = map[models.StepAction]string{
+	models.StepActionConfigRAID: models.FeatureAdvancedRAID,
+}
+
+// Dispatch 生成任务规范
+// step 为 nil 时按 Job.Type 分发整个任务
+func (d *Dispatcher) Dispatch(db *gorm.DB, job *models.Job, step *models.JobStep, machine *models.Machine) (*TaskSpec, error) {
+	spec := &TaskSpec{TaskID: job.ID}
+
+	var config map[string]interface{}
+	if step != nil {
+		spec.StepID = step.ID
+		spec.Action = step.Action
+		spec.Attempt = step.Attempts
+		spec.Timeout = step.TimeoutSeconds
+		config = step.Config
+	} else {
+		action, ok := legacyActions[job.Type]
+		if !ok {
+			return nil, fmt.Errorf("unsupported job type: %s", job.Type)
+		}
+		spec.Action = action
+		config = job.Params
+	}
+	if feature, ok := actionFeatures[spec.Action]; ok && d.licenses != nil && !d.licenses.HasFeature(feature) {
+		return nil, fmt.Errorf("%w: %s requires %s", ErrFeatureNotLicensed, spec.Action, feature)
+	}
+
+	if !providerActions[spec.Action] {
+		spec.Config = models.MergeConfig(map[string]interface{}{}, &models.Overlay{Config: config})
+		return spec, nil
+	}
+
+	provider, err := d.selectProvider(db, machine)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := jobProfile(db, job)
+	if err != nil {
+		return nil, err
+	}
+	// 任务或配置模板固定了版本时使用该版本，否则使用默认版本
+	if version := pinnedVersion(job, profile, provider.ID); version != "" && version != provider.Version {
+		if provider, err = d.providers.GetProviderVersion(provider.ID, version); err != nil {
+			return nil, fmt.Errorf("pinned provider version unavailable: %w", err)
+		}
+	}
+	spec.ProviderID = provider.ID
+	spec.ProviderVersion = provider.Version
+
+	// Schema默认值 < 配置模板 < 任务配置 < 全局Overlay < 机器Overlay
+	effective := map[string]interface{}{}
+	if provider.Schema != nil {
+		effective = provider.Schema.GenerateDefaultConfig()
+	}
+	if profile != nil {
+		effective = models.MergeConfig(effective, &models.Overlay{Config: profile.ProviderConfigs[provider.ID]})
+	}
+	effective = models.MergeConfig(effective, &models.Overlay{Config: config})
+	overlays, err := loadOverlays(db, provider.ID, machine)
+	if err != nil {
+		return nil, err
+	}
+	for i := range overlays {
+		effective = models.MergeConfig(effective, &overlays[i])
+	}
+	// 最终配置在下发给Agent执行前按Provider的Schema校验
+	if provider.Schema != nil {
+		if err := provider.Schema.ValidateConfig(effective); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	spec.Config = effective
+
+	// 每个任务独立的会话密钥，Provider以会话密钥重加密后下发
+	encrypted, err := d.providers.EncryptedBinary(provider.ID, provider.Version)
+	if err != nil {
+		return nil, err
+	}
+	_, sessionKey, blob, err := d.providers.DRM().CompleteDecryptionFlow(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare provider session: %w", err)
+	}
+
+	expiresAt := time.Now().Add(d.ttl)
+	d.sessions.Put(&ProviderSession{
+		ProviderID: provider.ID,
+		TaskID:     job.ID,
+		MachineID:  machine.ID,
+		Blob:       blob,
+		ExpiresAt:  expiresAt,
+	})
+
+	spec.SessionKey = base64.StdEncoding.EncodeToString(sessionKey)
+	spec.ProviderURL = d.providerURL(provider.ID, job.ID, expiresAt)
+	spec.ExpiresAt = &expiresAt
+	return spec, nil
+}
+
+// providerURL 生成带签名和过期时间的Provider下载链接
+func (d *Dispatcher) providerURL(providerID, taskID string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	q := url.Values{}
+	q.Set("task", taskID)
+	q.Set("expires", expires)
+	q.Set("sig", d.sessions.Sign(providerID, taskID, expires))
+	return fmt.Sprintf("%s/api/boot/v1/providers/%s/blob?%s", d.serverURL, url.PathEscape(providerID), q.Encode())
+}
+
+// selectProvider 按存储控制器选择Provider
+// PCI ID或驱动名精确匹配优先于型号关键字匹配，同分按ID排序
+func (d *Dispatcher) selectProvider(db *gorm.DB, machine *models.Machine) (*cspm.ProviderInfo, error) {
+	if d.providers == nil || machine == nil {
+		return nil, ErrNoProvider
+	}
+
+	// 其他租户的私有Provider不参与选择
+	owners, err := tenant.ProviderOwners(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider owners: %w", err)
+	}
+
+	providers := d.providers.ListProviders()
+	sort.Slice(providers, func(i, j int) bool { return providers[i].ID < providers[j].ID })
+
+	var best *cspm.ProviderInfo
+	bestScore := 0
+	for _, p := range providers {
+		if owner := owners[p.ID]; owner != "" && owner != machine.TenantID {
+			continue
+		}
+		if score := matchScore(p.Manifest.SupportedHardware, machine.HardwareSpec.StorageControllers); score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: %d storage controllers", ErrNoProvider, len(machine.HardwareSpec.StorageControllers))
+	}
+	return best, nil
+}
+
+// jobProfile 加载任务的配置模板中与Provider相关的设置，任务没有配置模板时返回 nil
+func jobProfile(db *gorm.DB, job *models.Job) (*models.OSProfile, error) {
+	if job.ProfileID == "" {
+		return nil, nil
+	}
+	var profile models.OSProfile
+	err := db.Select("id", "provider_versions", "provider_configs").Where("id = ?", job.ProfileID).Limit(1).Find(&profile).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile: %w", err)
+	}
+	if profile.ID == "" {
+		return nil, nil
+	}
+	return &profile, nil
+}
+
+// pinnedVersion 返回任务固定的Provider版本：任务参数优先，其次是任务的配置模板，未固定时返回空
+func pinnedVersion(job *models.Job, profile *models.OSProfile, providerID string) string {
+	if version := job.ProviderVersions[providerID]; version != "" {
+		return version
+	}
+	if profile == nil {
+		return ""
+	}
+	return profile.ProviderVersions[providerID]
+}
+
+// matchScore 计算Provider声明的硬件与控制器的匹配度
+//
+//	2  supported_hardware 与控制器 PCI ID 或驱动名相同
+//	1  supported_hardware 按 "_" 拆分的关键字全部出现在控制器厂商/型号/驱动中
+func matchScore(supported []string, controllers []models.ControllerInfo) int {
+	score := 0
+	for _, hw := range supported {
+		hw = strings.ToLower(hw)
+		for _, ctrl := range controllers {
+			if strings.EqualFold(hw, ctrl.PCIID) || strings.EqualFold(hw, ctrl.Driver) {
+				return 2
+			}
+			text := strings.ToLower(ctrl.Vendor + " " + ctrl.Model + " " + ctrl.Driver)
+			if containsAll(text, strings.Split(hw, "_")) {
+				score = 1
+			}
+		}
+	}
+	return score
+}
+
+func containsAll(text string, keywords []string) bool {
+	for _, kw := range keywords {
+		if kw == "" || !strings.Contains(text, kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadOverlays 查询Provider的全局Overlay和机器专属Overlay（全局在前）
+// 全局Overlay只取共享的和机器所属租户的
+func loadOverlays(db *gorm.DB, providerID string, machine *models.Machine) ([]models.Overlay, error) {
+	var overlays []models.Overlay
+	err := db.Where("provider_id = ? AND (machine_id = '' OR machine_id IS NULL OR machine_id = ?)", providerID, machine.ID).
+		Where("tenant_id = '' OR tenant_id IS NULL OR tenant_id = ?", machine.TenantID).
+		Order("machine_id, created_at").Find(&overlays).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load overlays: %w", err)
+	}
+	return overlays, nil
+}
@@ -0,0 +1,378 @@
+package dispatch
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/cspm"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type fakeProviders struct {
+	drm       *crypto.DRMManager
+	providers []*cspm.ProviderInfo // 默认版本
+	others    []*cspm.ProviderInfo // 其他已安装版本
+	binary    []byte
+}
+
+func (f *fakeProviders) ListProviders() []*cspm.ProviderInfo { return f.providers }
+func (f *fakeProviders) DRM() *crypto.DRMManager             { return f.drm }
+func (f *fakeProviders) GetProviderVersion(id, version string) (*cspm.ProviderInfo, error) {
+	for _, p := range append(f.providers, f.others...) {
+		if p.ID == id && p.Version == version {
+			return p, nil
+		}
+	}
+	return nil, cspm.ErrVersionNotFound
+}
+func (f *fakeProviders) EncryptedBinary(id, version string) ([]byte, error) {
+	return f.drm.EncryptProviderWithMasterKey(append([]byte(version+":"), f.binary...))
+}
+
+func newFakeProviders(t *testing.T) *fakeProviders {
+	masterKey, _ := crypto.GenerateAES256Key()
+	privateKey, _ := crypto.GenerateECDSAKeyPair()
+	drm, err := crypto.NewDRMManager(masterKey, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("NewDRMManager() error = %v", err)
+	}
+
+	schema := &cspm.ProviderSchema{Parameters: []cspm.ParameterDefinition{
+		{Name: "level", Type: "string", Default: "raid1"},
+		{Name: "strip_size", Type: "integer", Default: 64.0},
+	}}
+	return &fakeProviders{
+		drm:    drm,
+		binary: []byte("#!/bin/sh\necho provider\n"),
+		providers: []*cspm.ProviderInfo{
+			{ID: "generic-raid", Manifest: cspm.Manifest{SupportedHardware: []string{"generic_raid"}}},
+			{ID: "lsi-megaraid", Version: "2.0.0", Schema: schema, Manifest: cspm.Manifest{SupportedHardware: []string{"lsi_megaraid_3108"}}},
+			{ID: "lsi-pci", Manifest: cspm.Manifest{SupportedHardware: []string{"1000:005f"}}},
+		},
+		others: []*cspm.ProviderInfo{
+			{ID: "lsi-megaraid", Version: "1.0.0", Manifest: cspm.Manifest{SupportedHardware: []string{"lsi_megaraid_3108"}}},
+		},
+	}
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Overlay{}, &models.ProviderTenant{}, &models.OSProfile{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return db
+}
+
+func TestMatchScore(t *testing.T) {
+	ctrl := []models.ControllerInfo{{PCIID: "1000:005d", Vendor: "LSI Logic", Model: "MegaRAID SAS 3108", Driver: "megaraid_sas"}}
+
+	tests := []struct {
+		supported []string
+		want      int
+	}{
+		{[]string{"1000:005D"}, 2},
+		{[]string{"megaraid_sas"}, 2},
+		{[]string{"lsi_megaraid_3108"}, 1},
+		{[]string{"lsi_megaraid_3508"}, 0},
+		{[]string{"generic_raid"}, 0},
+		{nil, 0},
+	}
+
+	for _, tt := range tests {
+		if got := matchScore(tt.supported, ctrl); got != tt.want {
+			t.Errorf("matchScore(%v) = %d, want %d", tt.supported, got, tt.want)
+		}
+	}
+}
+
+func TestDispatch_ConfigRAID(t *testing.T) {
+	db := setupTestDB(t)
+	providers := newFakeProviders(t)
+	d := NewDispatcher(providers, "http://10.0.0.10:8080/")
+
+	machine := &models.Machine{ID: "machine-1", HardwareSpec: models.HardwareInfo{
+		StorageControllers: []models.ControllerInfo{{Vendor: "LSI Logic", Model: "MegaRAID SAS 3108", Driver: "megaraid_sas"}},
+	}}
+	db.Create(&models.Overlay{ID: "o1", ProviderID: "lsi-megaraid", Config: models.OverlayConfig{"level": "raid10", "hot_spare": true}})
+	db.Create(&models.Overlay{ID: "o2", ProviderID: "lsi-megaraid", MachineID: "machine-1", Config: models.OverlayConfig{"hot_spare": false}})
+	db.Create(&models.Overlay{ID: "o3", ProviderID: "lsi-megaraid", MachineID: "machine-2", Config: models.OverlayConfig{"level": "raid0"}})
+
+	job := &models.Job{ID: "job-1", Type: models.JobTypeConfigRAID, Params: map[string]interface{}{"level": "raid5", "disks": 4.0}}
+	spec, err := d.Dispatch(db, job, nil, machine)
+	if err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	if spec.Action != models.StepActionConfigRAID || spec.ProviderID != "lsi-megaraid" {
+		t.Errorf("action = %s, provider = %s", spec.Action, spec.ProviderID)
+	}
+	want := map[string]interface{}{"level": "raid10", "strip_size": 64.0, "disks": 4.0, "hot_spare": false}
+	for k, v := range want {
+		if spec.Config[k] != v {
+			t.Errorf("config[%s] = %v, want %v", k, spec.Config[k], v)
+		}
+	}
+
+	// 下载链接带签名，会话密钥可解密会话中的Provider
+	u, err := url.Parse(spec.ProviderURL)
+	if err != nil || !strings.HasPrefix(spec.ProviderURL, "http://10.0.0.10:8080/api/boot/v1/providers/lsi-megaraid/blob?") {
+		t.Fatalf("ProviderURL = %s", spec.ProviderURL)
+	}
+	q := u.Query()
+	if q.Get("task") != "job-1" || !d.Sessions().Verify("lsi-megaraid", "job-1", q.Get("expires"), q.Get("sig")) {
+		t.Errorf("ProviderURL signature invalid: %s", spec.ProviderURL)
+	}
+	if d.Sessions().Verify("lsi-megaraid", "job-2", q.Get("expires"), q.Get("sig")) {
+		t.Error("signature should be bound to task")
+	}
+
+	session, ok := d.Sessions().Get("lsi-megaraid", "job-1")
+	if !ok || session.MachineID != "machine-1" {
+		t.Fatalf("session = %+v, %v", session, ok)
+	}
+	key, _ := base64.StdEncoding.DecodeString(spec.SessionKey)
+	plain, err := providers.DRM().DecryptWithSessionKey(session.Blob, key)
+	if err != nil || string(plain) != "2.0.0:"+string(providers.binary) {
+		t.Errorf("session blob decrypt = %q, %v", plain, err)
+	}
+}
+
+type fakeLicenses []string
+
+func (f fakeLicenses) HasFeature(feature string) bool {
+	for _, have := range f {
+		if have == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDispatch_ConfigRAIDLicense(t *testing.T) {
+	machine := &models.Machine{ID: "machine-1", HardwareSpec: models.HardwareInfo{
+		StorageControllers: []models.ControllerInfo{{Vendor: "LSI Logic", Model: "MegaRAID SAS 3108", Driver: "megaraid_sas"}},
+	}}
+	tests := []struct {
+		name     string
+		licenses fakeLicenses
+		job      *models.Job
+		step     *models.JobStep
+		wantErr  error
+	}{
+		{"raid step without license", fakeLicenses{}, &models.Job{ID: "job-1", Type: models.JobTypeProvision},
+			&models.JobStep{ID: "step-1", Action: models.StepActionConfigRAID}, ErrFeatureNotLicensed},
+		{"raid job without license", fakeLicenses{models.FeatureAudit}, &models.Job{ID: "job-2", Type: models.JobTypeConfigRAID}, nil, ErrFeatureNotLicensed},
+		{"raid step with license", fakeLicenses{models.FeatureAdvancedRAID}, &models.Job{ID: "job-3", Type: models.JobTypeProvision},
+			&models.JobStep{ID: "step-3", Action: models.StepActionConfigRAID}, nil},
+		{"audit without license", fakeLicenses{}, &models.Job{ID: "job-4", Type: models.JobTypeAudit}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher(newFakeProviders(t), "http://10.0.0.10:8080")
+			d.SetLicenses(tt.licenses)
+			_, err := d.Dispatch(setupTestDB(t), tt.job, tt.step, machine)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Dispatch() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDispatch_PinnedVersion(t *testing.T) {
+	db := setupTestDB(t)
+	providers := newFakeProviders(t)
+	d := NewDispatcher(providers, "http://10.0.0.10:8080")
+
+	db.Create(&models.OSProfile{ID: "p-pinned", Name: "pinned", ProviderVersions: map[string]string{"lsi-megaraid": "1.0.0"}})
+	db.Create(&models.OSProfile{ID: "p-plain", Name: "plain"})
+
+	machine := &models.Machine{ID: "machine-1", HardwareSpec: models.HardwareInfo{
+		StorageControllers: []models.ControllerInfo{{Vendor: "LSI Logic", Model: "MegaRAID SAS 3108"}},
+	}}
+	tests := []struct {
+		name        string
+		profileID   string
+		jobPins     map[string]string
+		wantVersion string
+		wantErr     bool
+	}{
+		{"default version", "p-plain", nil, "2.0.0", false},
+		{"profile pin", "p-pinned", nil, "1.0.0", false},
+		{"job pin overrides profile", "p-pinned", map[string]string{"lsi-megaraid": "2.0.0"}, "2.0.0", false},
+		{"pin for other provider", "", map[string]string{"lsi-pci": "9.9.9"}, "2.0.0", false},
+		{"pinned version not installed", "", map[string]string{"lsi-megaraid": "3.0.0"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &models.Job{ID: "job-" + tt.name, Type: models.JobTypeConfigRAID, ProfileID: tt.profileID, ProviderVersions: tt.jobPins}
+			spec, err := d.Dispatch(db, job, nil, machine)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dispatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if spec.ProviderVersion != tt.wantVersion {
+				t.Errorf("ProviderVersion = %s, want %s", spec.ProviderVersion, tt.wantVersion)
+			}
+			session, _ := d.Sessions().Get("lsi-megaraid", job.ID)
+			key, _ := base64.StdEncoding.DecodeString(spec.SessionKey)
+			if plain, _ := providers.DRM().DecryptWithSessionKey(session.Blob, key); !strings.HasPrefix(string(plain), tt.wantVersion+":") {
+				t.Errorf("session carries %q, want version %s", plain, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDispatch_SchemaValidation(t *testing.T) {
+	db := setupTestDB(t)
+	providers := newFakeProviders(t)
+	schema, err := cspm.ParseSchema([]byte(`{"parameters": [
+		{"name": "level", "type": "string", "required": true, "constraints": {"pattern": "^raid(0|1|5|10)$"}},
+		{"name": "strip_size", "type": "integer", "default": 64},
+		{"name": "drives", "type": "array", "items": {"type": "string"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("ParseSchema() error = %v", err)
+	}
+	providers.providers[1].Schema = schema
+	d := NewDispatcher(providers, "http://10.0.0.10:8080")
+
+	db.Create(&models.OSProfile{ID: "p-raid", Name: "raid", ProviderConfigs: map[string]map[string]interface{}{
+		"lsi-megaraid": {"level": "raid1", "strip_size": 128.0},
+	}})
+	db.Create(&models.Overlay{ID: "o-bad", ProviderID: "lsi-megaraid", MachineID: "machine-bad", Config: models.OverlayConfig{"level": "raid7"}})
+
+	tests := []struct {
+		name      string
+		machineID string
+		profileID string
+		params    map[string]interface{}
+		want      map[string]interface{}
+		wantErr   bool
+	}{
+		{"profile config", "machine-1", "p-raid", nil, map[string]interface{}{"level": "raid1", "strip_size": 128.0}, false},
+		{"job config overrides profile", "machine-1", "p-raid", map[string]interface{}{"level": "raid10"}, map[string]interface{}{"level": "raid10", "strip_size": 128.0}, false},
+		{"missing required", "machine-1", "", nil, nil, true},
+		{"pattern mismatch", "machine-1", "", map[string]interface{}{"level": "RAID-5"}, nil, true},
+		{"array item type", "machine-1", "p-raid", map[string]interface{}{"drives": []interface{}{"sda", 2.0}}, nil, true},
+		{"overlay breaks config", "machine-bad", "p-raid", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := &models.Machine{ID: tt.machineID, HardwareSpec: models.HardwareInfo{
+				StorageControllers: []models.ControllerInfo{{Vendor: "LSI Logic", Model: "MegaRAID SAS 3108"}},
+			}}
+			job := &models.Job{ID: "job-" + tt.name, Type: models.JobTypeConfigRAID, ProfileID: tt.profileID, Params: tt.params}
+			spec, err := d.Dispatch(db, job, nil, machine)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidConfig) {
+					t.Fatalf("Dispatch() error = %v, want %v", err, ErrInvalidConfig)
+				}
+				if _, ok := d.Sessions().Get("lsi-megaraid", job.ID); ok {
+					t.Error("session created for rejected config")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			for k, v := range tt.want {
+				if spec.Config[k] != v {
+					t.Errorf("config[%s] = %v, want %v", k, spec.Config[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatch_TenantProviders(t *testing.T) {
+	db := setupTestDB(t)
+	d := NewDispatcher(newFakeProviders(t), "http://10.0.0.10:8080")
+
+	// lsi-pci 精确匹配但属于其他租户，只能退回关键字匹配的共享Provider
+	db.Create(&models.ProviderTenant{ProviderID: "lsi-pci", TenantID: "tenant-b"})
+	db.Create(&models.Overlay{ID: "o1", ProviderID: "lsi-megaraid", TenantID: "tenant-b", Config: models.OverlayConfig{"level": "raid0"}})
+	db.Create(&models.Overlay{ID: "o2", ProviderID: "lsi-megaraid", TenantID: "tenant-a", Config: models.OverlayConfig{"hot_spare": true}})
+
+	controllers := []models.ControllerInfo{{PCIID: "1000:005f", Vendor: "LSI Logic", Model: "MegaRAID SAS 3108"}}
+	tests := []struct {
+		name         string
+		tenantID     string
+		wantProvider string
+		wantLevel    interface{}
+		wantSpare    interface{}
+	}{
+		{"owner tenant", "tenant-b", "lsi-pci", nil, nil},
+		{"other tenant", "tenant-a", "lsi-megaraid", "raid1", true},
+		{"unassigned", "", "lsi-megaraid", "raid1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := &models.Machine{ID: "machine-1", TenantID: tt.tenantID, HardwareSpec: models.HardwareInfo{StorageControllers: controllers}}
+			job := &models.Job{ID: "job-" + tt.name, Type: models.JobTypeConfigRAID}
+			spec, err := d.Dispatch(db, job, nil, machine)
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			if spec.ProviderID != tt.wantProvider {
+				t.Errorf("provider = %s, want %s", spec.ProviderID, tt.wantProvider)
+			}
+			if spec.Config["level"] != tt.wantLevel || spec.Config["hot_spare"] != tt.wantSpare {
+				t.Errorf("config = %v", spec.Config)
+			}
+		})
+	}
+}
+
+func TestDispatch_Actions(t *testing.T) {
+	db := setupTestDB(t)
+	d := NewDispatcher(newFakeProviders(t), "http://10.0.0.10:8080")
+	machine := &models.Machine{ID: "machine-1"}
+
+	tests := []struct {
+		name       string
+		job        *models.Job
+		step       *models.JobStep
+		wantAction models.StepAction
+		wantErr    error
+	}{
+		{"audit job", &models.Job{ID: "j1", Type: models.JobTypeAudit}, nil, models.StepActionAudit, nil},
+		{"install job", &models.Job{ID: "j2", Type: models.JobTypeInstallOS}, nil, models.StepActionInstallOS, nil},
+		{"bios step", &models.Job{ID: "j3", Type: models.JobTypeProvision},
+			&models.JobStep{ID: "s1", Action: models.StepActionConfigBIOS, Config: map[string]interface{}{"boot_mode": "uefi"}},
+			models.StepActionConfigBIOS, nil},
+		{"raid without controller", &models.Job{ID: "j4", Type: models.JobTypeConfigRAID}, nil, "", ErrNoProvider},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := d.Dispatch(db, tt.job, tt.step, machine)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Dispatch() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if spec.Action != tt.wantAction || spec.ProviderURL != "" || spec.SessionKey != "" || spec.Config == nil {
+				t.Errorf("spec = %+v", spec)
+			}
+			if tt.step != nil && (spec.StepID != tt.step.ID || spec.Config["boot_mode"] != "uefi") {
+				t.Errorf("step spec = %+v", spec)
+			}
+		})
+	}
+}
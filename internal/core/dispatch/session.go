@@ -0,0 +1,103 @@
+package dispatch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ProviderSession 以会话密钥重加密的Provider，绑定到一个任务和机器
+type ProviderSession struct {
+	ProviderID string
+	TaskID     string
+	MachineID  string
+	Blob       []byte
+	ExpiresAt  time.Time
+}
+
+// Expired 检查会话是否过期
+func (s *ProviderSession) Expired(now time.Time) bool {
+	return now.After(s.ExpiresAt)
+}
+
+// SessionStore Provider会话存储（内存）
+type SessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*ProviderSession
+	signKey  []byte
+}
+
+// NewSessionStore 创建会话存储，使用随机密钥签名下载链接
+func NewSessionStore() *SessionStore {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate signing key: %v", err))
+	}
+	return &SessionStore{
+		sessions: make(map[string]*ProviderSession),
+		signKey:  key,
+	}
+}
+
+// Put 保存会话，同一任务的同一Provider只保留最新会话
+func (s *SessionStore) Put(session *ProviderSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, old := range s.sessions {
+		if old.Expired(now) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[sessionKey(session.ProviderID, session.TaskID)] = session
+}
+
+// Get 查询未过期的会话
+func (s *SessionStore) Get(providerID, taskID string) (*ProviderSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionKey(providerID, taskID)]
+	if !ok || session.Expired(time.Now()) {
+		return nil, false
+	}
+	return session, true
+}
+
+// Take 取出未过期的会话并删除（一次性下载）
+func (s *SessionStore) Take(providerID, taskID string) (*ProviderSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(providerID, taskID)
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil, false
+	}
+	delete(s.sessions, key)
+	if session.Expired(time.Now()) {
+		return nil, false
+	}
+	return session, true
+}
+
+// Sign 计算下载链接签名
+func (s *SessionStore) Sign(providerID, taskID, expires string) string {
+	mac := hmac.New(sha256.New, s.signKey)
+	mac.Write([]byte(providerID + "\n" + taskID + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验下载链接签名
+func (s *SessionStore) Verify(providerID, taskID, expires, sig string) bool {
+	return hmac.Equal([]byte(s.Sign(providerID, taskID, expires)), []byte(sig))
+}
+
+func sessionKey(providerID, taskID string) string {
+	return providerID + "/" + taskID
+}
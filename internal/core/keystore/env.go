@@ -0,0 +1,198 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+// EnvPrefix Master Key环境变量前缀：MASTER_KEY_V<版本>=<base64>
+const EnvPrefix = "MASTER_KEY_V"
+
+// KMS 密钥管理服务：包装/解包环境变量中的Master Key
+type KMS interface {
+	Name() string
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// EnvProvider 从环境变量读取Master Key，版本号最大的为当前版本
+//
+// 配置KMS时环境变量中是KMS包装后的密钥，打开时逐个解包。新增版本需要在
+// 外部生成（keytool generate）并配置环境变量，因此不支持 Rotate。
+type EnvProvider struct {
+	name    string
+	keys    map[int]*Key
+	current int
+}
+
+// OpenEnv 从环境变量列表（os.Environ 格式）加载Master Key，kms 为nil时密钥未包装
+func OpenEnv(environ []string, kms KMS) (*EnvProvider, error) {
+	p := &EnvProvider{name: "env", keys: make(map[int]*Key)}
+	if kms != nil {
+		p.name = "env+" + kms.Name()
+	}
+
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimPrefix(name, EnvPrefix))
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid master key variable %s", name)
+		}
+		material, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if kms != nil {
+			if material, err = kms.Decrypt(material); err != nil {
+				return nil, fmt.Errorf("failed to unwrap %s: %w", name, err)
+			}
+		}
+		if len(material) != KeySize {
+			return nil, fmt.Errorf("%s must be %d bytes", name, KeySize)
+		}
+
+		p.keys[version] = &Key{Version: version, Material: material}
+		if version > p.current {
+			p.current = version
+		}
+	}
+
+	if p.current == 0 {
+		return nil, fmt.Errorf("no %s<n> variables configured", EnvPrefix)
+	}
+	return p, nil
+}
+
+// Name 来源名称
+func (p *EnvProvider) Name() string {
+	return p.name
+}
+
+// Current 当前版本
+func (p *EnvProvider) Current() (*Key, error) {
+	return p.Get(p.current)
+}
+
+// Get 指定版本
+func (p *EnvProvider) Get(version int) (*Key, error) {
+	key, ok := p.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrKeyNotFound, version)
+	}
+	return key, nil
+}
+
+// Versions 全部版本
+func (p *EnvProvider) Versions() ([]Key, error) {
+	return sortedKeys(p.keys), nil
+}
+
+// Rotate 环境变量来源不能生成新版本
+func (p *EnvProvider) Rotate() (*Key, error) {
+	return nil, ErrUnsupported
+}
+
+// OpenKMS 按 KMS_PLUGIN 配置打开KMS：local:<密钥文件> 使用本地替身，其余视为插件路径
+func OpenKMS(spec string) (KMS, error) {
+	if path, ok := strings.CutPrefix(spec, "local:"); ok {
+		return OpenLocalKMS(path)
+	}
+	if _, err := os.Stat(spec); err != nil {
+		return nil, fmt.Errorf("KMS plugin: %w", err)
+	}
+	return &PluginKMS{path: spec}, nil
+}
+
+// PluginKMS 外部KMS插件
+//
+// 以 "<plugin> encrypt|decrypt" 调用，标准输入为Base64数据，标准输出为Base64结果，
+// 由插件负责与云KMS通信和认证。
+type PluginKMS struct {
+	path string
+}
+
+// Name 来源名称
+func (k *PluginKMS) Name() string {
+	return "kms:" + k.path
+}
+
+// Encrypt 包装
+func (k *PluginKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	return k.run("encrypt", plaintext)
+}
+
+// Decrypt 解包
+func (k *PluginKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	return k.run("decrypt", ciphertext)
+}
+
+func (k *PluginKMS) run(op string, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(k.path, op)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(input))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("kms plugin %s failed: %w: %s", op, err, strings.TrimSpace(stderr.String()))
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+}
+
+// LocalKMS 本地KMS替身（开发测试用），用本地密钥文件包装/解包
+type LocalKMS struct {
+	path string
+	key  []byte
+}
+
+// OpenLocalKMS 加载本地KMS密钥文件（十六进制，32字节），不存在时生成
+func OpenLocalKMS(path string) (*LocalKMS, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := crypto.GenerateAES256Key()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return &LocalKMS{path: path, key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("invalid local KMS key file %s", path)
+	}
+	return &LocalKMS{path: path, key: key}, nil
+}
+
+// Name 来源名称
+func (k *LocalKMS) Name() string {
+	return "kms-local:" + k.path
+}
+
+// Encrypt 包装
+func (k *LocalKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	return crypto.EncryptFile(plaintext, k.key)
+}
+
+// Decrypt 解包
+func (k *LocalKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	return crypto.DecryptFile(ciphertext, k.key)
+}
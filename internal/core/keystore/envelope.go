@@ -0,0 +1,85 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+// blobMagic 版本化密文头部标识
+var blobMagic = []byte("CBK1")
+
+// blobHeaderSize 头部长度：标识 + 4字节密钥版本（大端）
+const blobHeaderSize = 8
+
+// ErrNotVersioned 数据不是带版本头的密文
+var ErrNotVersioned = errors.New("data is not a versioned key blob")
+
+// Encrypt 用当前版本加密，返回带版本头的密文
+func Encrypt(p Provider, plaintext []byte) ([]byte, error) {
+	key, err := p.Current()
+	if err != nil {
+		return nil, err
+	}
+	return EncryptWith(key, plaintext)
+}
+
+// EncryptWith 用指定版本加密，返回带版本头的密文
+func EncryptWith(key *Key, plaintext []byte) ([]byte, error) {
+	sealed, err := crypto.EncryptFile(plaintext, key.Material)
+	if err != nil {
+		return nil, err
+	}
+	blob := make([]byte, blobHeaderSize, blobHeaderSize+len(sealed))
+	copy(blob, blobMagic)
+	binary.BigEndian.PutUint32(blob[len(blobMagic):], uint32(key.Version))
+	return append(blob, sealed...), nil
+}
+
+// Decrypt 按密文头部记录的版本解密，返回明文和版本号
+func Decrypt(p Provider, blob []byte) ([]byte, int, error) {
+	version, err := BlobVersion(blob)
+	if err != nil {
+		return nil, 0, err
+	}
+	key, err := p.Get(version)
+	if err != nil {
+		return nil, version, err
+	}
+	plaintext, err := crypto.DecryptFile(blob[blobHeaderSize:], key.Material)
+	if err != nil {
+		return nil, version, fmt.Errorf("failed to decrypt with key version %d: %w", version, err)
+	}
+	return plaintext, version, nil
+}
+
+// BlobVersion 读取密文头部记录的密钥版本
+func BlobVersion(blob []byte) (int, error) {
+	if len(blob) < blobHeaderSize || !bytes.Equal(blob[:len(blobMagic)], blobMagic) {
+		return 0, ErrNotVersioned
+	}
+	return int(binary.BigEndian.Uint32(blob[len(blobMagic):blobHeaderSize])), nil
+}
+
+// Rewrap 把密文重新加密到当前版本，已是当前版本时原样返回（changed 为false）
+func Rewrap(p Provider, blob []byte) (rewrapped []byte, changed bool, err error) {
+	current, err := p.Current()
+	if err != nil {
+		return nil, false, err
+	}
+	plaintext, version, err := Decrypt(p, blob)
+	if err != nil {
+		return nil, false, err
+	}
+	if version == current.Version {
+		return blob, false, nil
+	}
+	rewrapped, err = EncryptWith(current, plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return rewrapped, true, nil
+}
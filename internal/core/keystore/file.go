@@ -0,0 +1,233 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+// fileFormatVersion 密钥库文件格式版本
+const fileFormatVersion = 1
+
+// scrypt 参数
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// keystoreFile 密钥库文件：各版本Master Key由口令派生的密钥（scrypt）以AES-GCM加密
+type keystoreFile struct {
+	Format  int          `json:"format"`
+	KDF     kdfParams    `json:"kdf"`
+	Current int          `json:"current"`
+	Keys    []wrappedKey `json:"keys"`
+}
+
+type kdfParams struct {
+	Name string `json:"name"`
+	Salt string `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+type wrappedKey struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key"`
+}
+
+// FileProvider 口令加密的本地密钥库文件
+type FileProvider struct {
+	path string
+	kek  []byte
+
+	mu   sync.RWMutex
+	file keystoreFile
+	keys map[int]*Key
+}
+
+// OpenFile 打开密钥库文件，不存在时创建并生成第一个版本
+func OpenFile(path, passphrase string) (*FileProvider, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return createFile(path, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keystore file %s: %w", path, err)
+	}
+	if file.Format != fileFormatVersion || file.KDF.Name != "scrypt" {
+		return nil, fmt.Errorf("unsupported keystore format in %s", path)
+	}
+	salt, err := base64.StdEncoding.DecodeString(file.KDF.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keystore salt: %w", err)
+	}
+	kek, err := scrypt.Key([]byte(passphrase), salt, file.KDF.N, file.KDF.R, file.KDF.P, KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FileProvider{path: path, kek: kek, file: file, keys: make(map[int]*Key, len(file.Keys))}
+	for _, wk := range file.Keys {
+		material, err := crypto.DecryptAES256(wk.Key, kek)
+		if err != nil || len(material) != KeySize {
+			return nil, ErrBadPassphrase
+		}
+		p.keys[wk.Version] = &Key{Version: wk.Version, Material: material, CreatedAt: wk.CreatedAt}
+	}
+	if _, ok := p.keys[file.Current]; !ok {
+		return nil, fmt.Errorf("%w: current version %d", ErrKeyNotFound, file.Current)
+	}
+	return p, nil
+}
+
+// createFile 创建密钥库并生成版本1
+func createFile(path, passphrase string) (*FileProvider, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("keystore passphrase is required")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kek, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FileProvider{
+		path: path,
+		kek:  kek,
+		file: keystoreFile{
+			Format: fileFormatVersion,
+			KDF: kdfParams{
+				Name: "scrypt",
+				Salt: base64.StdEncoding.EncodeToString(salt),
+				N:    scryptN,
+				R:    scryptR,
+				P:    scryptP,
+			},
+		},
+		keys: make(map[int]*Key),
+	}
+	if _, err := p.Rotate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Name 来源名称
+func (p *FileProvider) Name() string {
+	return "file:" + p.path
+}
+
+// Current 当前版本
+func (p *FileProvider) Current() (*Key, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.get(p.file.Current)
+}
+
+// Get 指定版本
+func (p *FileProvider) Get(version int) (*Key, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.get(version)
+}
+
+func (p *FileProvider) get(version int) (*Key, error) {
+	key, ok := p.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrKeyNotFound, version)
+	}
+	return key, nil
+}
+
+// Versions 全部版本
+func (p *FileProvider) Versions() ([]Key, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return sortedKeys(p.keys), nil
+}
+
+// Rotate 生成新版本并保存密钥库
+func (p *FileProvider) Rotate() (*Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	material, err := crypto.GenerateAES256Key()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := crypto.EncryptAES256(material, p.kek)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{Version: p.file.Current + 1, Material: material, CreatedAt: time.Now().UTC()}
+	file := p.file
+	file.Keys = append(append([]wrappedKey(nil), p.file.Keys...), wrappedKey{
+		Version:   key.Version,
+		CreatedAt: key.CreatedAt,
+		Key:       wrapped,
+	})
+	file.Current = key.Version
+	if err := writeFileAtomic(p.path, file); err != nil {
+		return nil, err
+	}
+
+	p.file = file
+	p.keys[key.Version] = key
+	return key, nil
+}
+
+// writeFileAtomic 写入临时文件后改名，避免中断时损坏密钥库
+func writeFileAtomic(path string, file keystoreFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sortedKeys 按版本号升序返回
+func sortedKeys(keys map[int]*Key) []Key {
+	list := make([]Key, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, *k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// randomString 随机字符串（URL安全Base64）
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
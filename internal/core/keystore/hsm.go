@@ -0,0 +1,63 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrHSMUnavailable 本构建未包含PKCS#11支持
+var ErrHSMUnavailable = errors.New("PKCS#11 HSM support is not available in this build")
+
+// HSMConfig PKCS#11 HSM配置
+type HSMConfig struct {
+	Module   string // PKCS#11 模块（.so）路径
+	Slot     int
+	PIN      string
+	KeyLabel string // HSM中包装Master Key的密钥标签
+}
+
+// HSMProvider PKCS#11 HSM（桩实现）
+//
+// 目标实现：Master Key以 HSM 中 KeyLabel 密钥包装（C_WrapKey）后保存，
+// 使用时在 HSM 会话中解包（C_UnwrapKey），包装密钥不离开 HSM。
+// 当前构建未链接 PKCS#11 库，只校验配置，取密钥和轮换均返回 ErrHSMUnavailable。
+type HSMProvider struct {
+	cfg HSMConfig
+}
+
+// OpenHSM 校验HSM配置并打开会话
+func OpenHSM(cfg HSMConfig) (*HSMProvider, error) {
+	if cfg.Module == "" || cfg.KeyLabel == "" {
+		return nil, errors.New("HSM_MODULE and HSM_KEY_LABEL are required")
+	}
+	if _, err := os.Stat(cfg.Module); err != nil {
+		return nil, fmt.Errorf("PKCS#11 module: %w", err)
+	}
+	return &HSMProvider{cfg: cfg}, nil
+}
+
+// Name 来源名称
+func (p *HSMProvider) Name() string {
+	return fmt.Sprintf("hsm:%s#%d/%s", p.cfg.Module, p.cfg.Slot, p.cfg.KeyLabel)
+}
+
+// Current 当前版本
+func (p *HSMProvider) Current() (*Key, error) {
+	return nil, ErrHSMUnavailable
+}
+
+// Get 指定版本
+func (p *HSMProvider) Get(version int) (*Key, error) {
+	return nil, ErrHSMUnavailable
+}
+
+// Versions 全部版本
+func (p *HSMProvider) Versions() ([]Key, error) {
+	return nil, ErrHSMUnavailable
+}
+
+// Rotate 生成新版本
+func (p *HSMProvider) Rotate() (*Key, error) {
+	return nil, ErrHSMUnavailable
+}
@@ -0,0 +1,165 @@
+// Package keystore Master Key管理
+//
+// Master Key按版本管理，来源可以是口令加密的本地密钥库文件、PKCS#11 HSM
+// 或环境变量（可经KMS包装）。Store中的Provider用某一版本加密保存，密文头部
+// 记录版本号；轮换后旧版本仍保留用于解密，离线轮换命令（cmd/keytool）把已
+// 保存的Provider重新加密到新版本。
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeySize Master Key长度（AES-256）
+const KeySize = 32
+
+var (
+	// ErrKeyNotFound 密钥版本不存在
+	ErrKeyNotFound = errors.New("master key version not found")
+	// ErrUnsupported 密钥来源不支持该操作
+	ErrUnsupported = errors.New("operation not supported by key provider")
+	// ErrBadPassphrase 口令错误或密钥库已损坏
+	ErrBadPassphrase = errors.New("wrong keystore passphrase or corrupted keystore")
+)
+
+// Key 一个版本的Master Key
+type Key struct {
+	Version   int
+	Material  []byte
+	CreatedAt time.Time
+}
+
+// Provider Master Key来源
+type Provider interface {
+	// Name 来源名称（日志显示）
+	Name() string
+	// Current 当前用于加密的版本
+	Current() (*Key, error)
+	// Get 指定版本（解密旧版本加密的数据）
+	Get(version int) (*Key, error)
+	// Versions 全部可用版本（按版本号升序）
+	Versions() ([]Key, error)
+	// Rotate 生成新版本并设为当前版本，不能生成密钥的来源返回 ErrUnsupported
+	Rotate() (*Key, error)
+}
+
+// 密钥来源类型
+const (
+	TypeFile = "file"
+	TypeHSM  = "hsm"
+	TypeEnv  = "env"
+)
+
+// Config 密钥来源配置
+type Config struct {
+	Type string
+
+	// file：口令加密的密钥库文件，口令直接给出或从文件读取
+	File           string
+	Passphrase     string
+	PassphraseFile string
+
+	// hsm：PKCS#11
+	HSM HSMConfig
+
+	// env：MASTER_KEY_V<版本> 环境变量，KMSPlugin 非空时为KMS包装后的密钥
+	Environ   []string
+	KMSPlugin string
+}
+
+// ConfigFromEnv 从环境变量读取配置（服务端和 keytool 共用）
+//
+//	KEY_PROVIDER              file（默认）| hsm | env
+//	KEYSTORE_FILE             密钥库文件（默认 ./data/keys/keystore.json）
+//	KEYSTORE_PASSPHRASE       密钥库口令
+//	KEYSTORE_PASSPHRASE_FILE  密钥库口令文件（默认 ./data/keys/keystore.pass，不存在时自动生成）
+//	HSM_MODULE / HSM_SLOT / HSM_PIN / HSM_KEY_LABEL
+//	KMS_PLUGIN                local:<密钥文件> 或 KMS插件可执行文件路径
+func ConfigFromEnv() Config {
+	slot, _ := strconv.Atoi(os.Getenv("HSM_SLOT"))
+	return Config{
+		Type:           envOr("KEY_PROVIDER", TypeFile),
+		File:           envOr("KEYSTORE_FILE", "./data/keys/keystore.json"),
+		Passphrase:     os.Getenv("KEYSTORE_PASSPHRASE"),
+		PassphraseFile: envOr("KEYSTORE_PASSPHRASE_FILE", "./data/keys/keystore.pass"),
+		HSM: HSMConfig{
+			Module:   os.Getenv("HSM_MODULE"),
+			Slot:     slot,
+			PIN:      os.Getenv("HSM_PIN"),
+			KeyLabel: os.Getenv("HSM_KEY_LABEL"),
+		},
+		Environ:   os.Environ(),
+		KMSPlugin: os.Getenv("KMS_PLUGIN"),
+	}
+}
+
+// Open 按配置打开密钥来源（文件密钥库不存在时创建并生成第一个版本）
+func Open(cfg Config) (Provider, error) {
+	switch cfg.Type {
+	case TypeFile:
+		passphrase, err := cfg.passphrase()
+		if err != nil {
+			return nil, err
+		}
+		return OpenFile(cfg.File, passphrase)
+	case TypeHSM:
+		return OpenHSM(cfg.HSM)
+	case TypeEnv:
+		var kms KMS
+		if cfg.KMSPlugin != "" {
+			var err error
+			if kms, err = OpenKMS(cfg.KMSPlugin); err != nil {
+				return nil, err
+			}
+		}
+		return OpenEnv(cfg.Environ, kms)
+	}
+	return nil, fmt.Errorf("unknown key provider: %s", cfg.Type)
+}
+
+// passphrase 密钥库口令：优先使用直接配置的口令，其次读取口令文件，文件不存在时生成
+func (cfg Config) passphrase() (string, error) {
+	if cfg.Passphrase != "" {
+		return cfg.Passphrase, nil
+	}
+	if cfg.PassphraseFile == "" {
+		return "", errors.New("keystore passphrase is required")
+	}
+
+	data, err := os.ReadFile(cfg.PassphraseFile)
+	if err == nil {
+		passphrase := strings.TrimSpace(string(data))
+		if passphrase == "" {
+			return "", fmt.Errorf("empty keystore passphrase file %s", cfg.PassphraseFile)
+		}
+		return passphrase, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	passphrase, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.PassphraseFile), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(cfg.PassphraseFile, []byte(passphrase+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return passphrase, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
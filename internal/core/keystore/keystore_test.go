@@ -0,0 +1,180 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "keystore.json")
+
+	p, err := OpenFile(path, "secret")
+	if err != nil {
+		t.Fatalf("OpenFile() create error = %v", err)
+	}
+	v1, err := p.Current()
+	if err != nil || v1.Version != 1 || len(v1.Material) != KeySize {
+		t.Fatalf("Current() = %+v, %v", v1, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("keystore mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	v2, err := p.Rotate()
+	if err != nil || v2.Version != 2 {
+		t.Fatalf("Rotate() = %+v, %v", v2, err)
+	}
+
+	// 重新打开后保留全部版本
+	reopened, err := OpenFile(path, "secret")
+	if err != nil {
+		t.Fatalf("OpenFile() reopen error = %v", err)
+	}
+	current, _ := reopened.Current()
+	old, err := reopened.Get(1)
+	if err != nil || current.Version != 2 || !bytes.Equal(old.Material, v1.Material) {
+		t.Errorf("reopened keystore does not match: current=%d err=%v", current.Version, err)
+	}
+	if versions, _ := reopened.Versions(); len(versions) != 2 || versions[0].Version != 1 {
+		t.Errorf("Versions() = %+v", versions)
+	}
+	if _, err := reopened.Get(3); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(3) error = %v, want ErrKeyNotFound", err)
+	}
+
+	if _, err := OpenFile(path, "wrong"); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("OpenFile() wrong passphrase error = %v, want ErrBadPassphrase", err)
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, KeySize)
+	key2 := bytes.Repeat([]byte{2}, KeySize)
+	kms, err := OpenLocalKMS(filepath.Join(t.TempDir(), "kms.key"))
+	if err != nil {
+		t.Fatalf("OpenLocalKMS() error = %v", err)
+	}
+	wrapped, _ := kms.Encrypt(key2)
+
+	tests := []struct {
+		name        string
+		environ     []string
+		kms         KMS
+		wantErr     bool
+		wantCurrent int
+		wantKey     []byte
+	}{
+		{"plain keys", []string{
+			"PATH=/bin",
+			"MASTER_KEY_V1=" + base64.StdEncoding.EncodeToString(key1),
+			"MASTER_KEY_V2=" + base64.StdEncoding.EncodeToString(key2),
+		}, nil, false, 2, key2},
+		{"kms wrapped", []string{"MASTER_KEY_V2=" + base64.StdEncoding.EncodeToString(wrapped)}, kms, false, 2, key2},
+		{"wrong length", []string{"MASTER_KEY_V1=" + base64.StdEncoding.EncodeToString([]byte("short"))}, nil, true, 0, nil},
+		{"bad version", []string{"MASTER_KEY_VX=" + base64.StdEncoding.EncodeToString(key1)}, nil, true, 0, nil},
+		{"no keys", []string{"PATH=/bin"}, nil, true, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := OpenEnv(tt.environ, tt.kms)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			current, _ := p.Current()
+			if current.Version != tt.wantCurrent || !bytes.Equal(current.Material, tt.wantKey) {
+				t.Errorf("Current() = v%d", current.Version)
+			}
+			if _, err := p.Rotate(); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("Rotate() error = %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestHSMStub(t *testing.T) {
+	if _, err := OpenHSM(HSMConfig{}); err == nil {
+		t.Errorf("OpenHSM() without module should fail")
+	}
+
+	module := filepath.Join(t.TempDir(), "pkcs11.so")
+	os.WriteFile(module, nil, 0644)
+	p, err := OpenHSM(HSMConfig{Module: module, KeyLabel: "cloudboot-master"})
+	if err != nil {
+		t.Fatalf("OpenHSM() error = %v", err)
+	}
+	if _, err := p.Current(); !errors.Is(err, ErrHSMUnavailable) {
+		t.Errorf("Current() error = %v, want ErrHSMUnavailable", err)
+	}
+}
+
+func TestEnvelopeRewrap(t *testing.T) {
+	p, err := OpenFile(filepath.Join(t.TempDir(), "keystore.json"), "secret")
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+
+	plaintext := []byte("provider binary")
+	blob, err := Encrypt(p, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if v, _ := BlobVersion(blob); v != 1 {
+		t.Errorf("BlobVersion() = %d, want 1", v)
+	}
+
+	// 未轮换时不改写
+	if _, changed, err := Rewrap(p, blob); err != nil || changed {
+		t.Errorf("Rewrap() before rotation changed=%v err=%v", changed, err)
+	}
+
+	p.Rotate()
+	rewrapped, changed, err := Rewrap(p, blob)
+	if err != nil || !changed {
+		t.Fatalf("Rewrap() changed=%v err=%v", changed, err)
+	}
+	got, version, err := Decrypt(p, rewrapped)
+	if err != nil || version != 2 || !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt() = %q v%d, %v", got, version, err)
+	}
+
+	// 旧版本密文仍可解密
+	if got, _, err := Decrypt(p, blob); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt(old blob) = %q, %v", got, err)
+	}
+	if _, _, err := Decrypt(p, []byte("plain data")); !errors.Is(err, ErrNotVersioned) {
+		t.Errorf("Decrypt(unversioned) error = %v, want ErrNotVersioned", err)
+	}
+}
+
+func TestConfigPassphraseFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Type:           TypeFile,
+		File:           filepath.Join(dir, "keystore.json"),
+		PassphraseFile: filepath.Join(dir, "keystore.pass"),
+	}
+
+	p, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	first, _ := p.Current()
+
+	// 生成的口令文件在重启后复用
+	p, err = Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() reopen error = %v", err)
+	}
+	again, _ := p.Current()
+	if !bytes.Equal(first.Material, again.Material) {
+		t.Errorf("reopened keystore returned a different key")
+	}
+}
@@ -0,0 +1,38 @@
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+)
+
+// LoadOrCreateSigningKey 加载ECDSA签名私钥（PEM），不存在时生成并保存
+//
+// 用于没有官方公钥的开发环境：本地签发的License和Provider包在重启后仍能通过校验。
+func LoadOrCreateSigningKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return crypto.PrivateKeyFromPEM(string(data))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := crypto.GenerateECDSAKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	pemStr, err := crypto.PrivateKeyToPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(pemStr), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,305 @@
+// Package license 商业License管理
+//
+// License文件为JSON（字段同 models.License），由官方私钥对固定顺序的字段
+// 签名。导入时校验签名后写入 licenses 表，启动时重新校验已保存的License，
+// 只有签名有效的License参与功能判定。License过期后进入宽限期，宽限期内功能
+// 仍可用但持续告警，宽限期结束后功能关闭。
+package license
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"gorm.io/gorm"
+)
+
+// DefaultGracePeriod License过期后功能仍可用的默认时长
+const DefaultGracePeriod = 14 * 24 * time.Hour
+
+// WarnBefore 到期前开始告警的时长
+const WarnBefore = 30 * 24 * time.Hour
+
+var (
+	// ErrInvalidLicense License文件格式错误或缺少必填字段
+	ErrInvalidLicense = errors.New("invalid license file")
+	// ErrInvalidSignature License签名与官方公钥不符
+	ErrInvalidSignature = errors.New("invalid license signature")
+	// ErrExpired License已过期且超出宽限期
+	ErrExpired = errors.New("license expired")
+)
+
+// State License状态
+type State string
+
+const (
+	StateActive   State = "active"   // 有效
+	StateExpiring State = "expiring" // 有效，WarnBefore 内到期
+	StateGrace    State = "grace"    // 已过期，宽限期内功能仍可用
+	StateExpired  State = "expired"  // 已过期且超出宽限期
+)
+
+// Status License及其当前状态
+type Status struct {
+	License     models.License
+	State       State
+	DaysLeft    int       // 距到期天数（已过期为负数）
+	GraceEndsAt time.Time // 宽限期结束时间
+}
+
+// Usable 功能是否可用（有效、即将到期或宽限期内）
+func (s Status) Usable() bool {
+	return s.State != StateExpired
+}
+
+// Manager 已导入License的签名校验、持久化与功能判定
+type Manager struct {
+	db          *gorm.DB
+	publicKey   *ecdsa.PublicKey
+	gracePeriod time.Duration
+	now         func() time.Time
+
+	mu       sync.RWMutex
+	licenses []models.License // 签名有效的License
+	onChange []func(current *models.License)
+	stopCh   chan struct{}
+}
+
+// NewManager 创建License管理器（publicKey 为官方签名公钥）
+func NewManager(db *gorm.DB, publicKey *ecdsa.PublicKey, gracePeriod time.Duration) *Manager {
+	return &Manager{
+		db:          db,
+		publicKey:   publicKey,
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// LoadPublicKey 从PEM文件加载官方签名公钥
+func LoadPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.PublicKeyFromPEM(string(data))
+}
+
+// Payload License的签名内容：固定顺序字段的JSON数组
+func Payload(l *models.License) []byte {
+	features := l.Features
+	if features == nil {
+		features = models.Features{}
+	}
+	data, _ := json.Marshal([]interface{}{
+		l.ID,
+		l.CustomerName,
+		l.CustomerCode,
+		l.ProductKey,
+		features,
+		l.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+	return data
+}
+
+// Sign 使用官方私钥签名License（签发工具和测试使用）
+func Sign(l *models.License, privateKey *ecdsa.PrivateKey) error {
+	signature, err := crypto.SignData(Payload(l), privateKey)
+	if err != nil {
+		return err
+	}
+	l.Signature = signature
+	return nil
+}
+
+// Verify 校验License签名
+func (m *Manager) Verify(l *models.License) error {
+	if l.Signature == "" || m.publicKey == nil {
+		return ErrInvalidSignature
+	}
+	ok, err := crypto.VerifySignature(Payload(l), l.Signature, m.publicKey)
+	if err != nil || !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Load 从数据库加载并重新校验全部License，签名无效的License不参与功能判定
+func (m *Manager) Load() error {
+	var rows []models.License
+	if err := m.db.Order("expires_at").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	valid := make([]models.License, 0, len(rows))
+	for _, l := range rows {
+		if err := m.Verify(&l); err != nil {
+			log.Printf("⚠️  License %s (%s) 签名无效，已忽略", l.ID, l.CustomerCode)
+			continue
+		}
+		valid = append(valid, l)
+	}
+
+	m.mu.Lock()
+	m.licenses = valid
+	callbacks := m.onChange
+	m.mu.Unlock()
+
+	current := m.Current()
+	for _, fn := range callbacks {
+		fn(current)
+	}
+	return nil
+}
+
+// OnChange 注册License变化回调（每次加载或导入后以当前生效的License调用，可能为nil）
+func (m *Manager) OnChange(fn func(current *models.License)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = append(m.onChange, fn)
+}
+
+// Import 校验并保存License文件，同一客户的旧License被替换
+func (m *Manager) Import(data []byte) (*models.License, error) {
+	var l models.License
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLicense, err)
+	}
+	l.ID = strings.TrimSpace(l.ID)
+	l.CustomerCode = strings.TrimSpace(l.CustomerCode)
+	if l.ID == "" || l.CustomerCode == "" || l.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("%w: id, customer_code and expires_at are required", ErrInvalidLicense)
+	}
+	if err := m.Verify(&l); err != nil {
+		return nil, err
+	}
+	if m.status(l).State == StateExpired {
+		return nil, ErrExpired
+	}
+
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? OR customer_code = ?", l.ID, l.CustomerCode).Delete(&models.License{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&l).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.Load(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Statuses 返回签名有效的License及其状态（按到期时间排序）
+func (m *Manager) Statuses() []Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(m.licenses))
+	for _, l := range m.licenses {
+		statuses = append(statuses, m.status(l))
+	}
+	return statuses
+}
+
+// HasFeature 是否存在包含该功能且仍可用的License
+func (m *Manager) HasFeature(feature string) bool {
+	for _, s := range m.Statuses() {
+		if s.Usable() && s.License.HasFeature(feature) {
+			return true
+		}
+	}
+	return false
+}
+
+// Current 当前生效的License（可用License中最晚到期的一个），没有时返回nil
+func (m *Manager) Current() *models.License {
+	statuses := m.Statuses()
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].License.ExpiresAt.After(statuses[j].License.ExpiresAt)
+	})
+	for _, s := range statuses {
+		if s.Usable() {
+			l := s.License
+			return &l
+		}
+	}
+	return nil
+}
+
+// CheckExpiry 记录即将到期、宽限期和已过期License的告警
+func (m *Manager) CheckExpiry() {
+	statuses := m.Statuses()
+	if len(statuses) == 0 {
+		log.Println("⚠️  未导入有效License，商业功能不可用")
+		return
+	}
+	for _, s := range statuses {
+		l := s.License
+		switch s.State {
+		case StateExpiring:
+			log.Printf("⚠️  License %s (%s) 将在 %d 天后到期", l.ID, l.CustomerCode, s.DaysLeft)
+		case StateGrace:
+			log.Printf("⚠️  License %s (%s) 已过期，宽限期至 %s，届时商业功能将关闭",
+				l.ID, l.CustomerCode, s.GraceEndsAt.Format(time.RFC3339))
+		case StateExpired:
+			log.Printf("❌ License %s (%s) 已过期，商业功能已关闭", l.ID, l.CustomerCode)
+		}
+	}
+}
+
+// Start 启动到期巡检
+func (m *Manager) Start(interval time.Duration) {
+	m.CheckExpiry()
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				m.CheckExpiry()
+			case <-m.stopCh:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止到期巡检
+func (m *Manager) Stop() {
+	close(m.stopCh)
+}
+
+// status 计算License当前状态
+func (m *Manager) status(l models.License) Status {
+	now := m.now()
+	s := Status{
+		License:     l,
+		DaysLeft:    int(l.ExpiresAt.Sub(now).Hours() / 24),
+		GraceEndsAt: l.ExpiresAt.Add(m.gracePeriod),
+	}
+	switch {
+	case now.After(s.GraceEndsAt):
+		s.State = StateExpired
+	case now.After(l.ExpiresAt):
+		s.State = StateGrace
+	case l.ExpiresAt.Sub(now) <= WarnBefore:
+		s.State = StateExpiring
+	default:
+		s.State = StateActive
+	}
+	return s
+}
@@ -0,0 +1,189 @@
+package license
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/crypto"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupManager(t *testing.T) (*Manager, *ecdsa.PrivateKey) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.License{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	key, err := crypto.GenerateECDSAKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return NewManager(db, &key.PublicKey, DefaultGracePeriod), key
+}
+
+func signedFile(t *testing.T, key *ecdsa.PrivateKey, l models.License) []byte {
+	t.Helper()
+	if err := Sign(&l, key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	data, _ := json.Marshal(l)
+	return data
+}
+
+func TestImport(t *testing.T) {
+	m, key := setupManager(t)
+	otherKey, _ := crypto.GenerateECDSAKeyPair()
+
+	valid := models.License{
+		ID:           "lic-1",
+		CustomerName: "ACME",
+		CustomerCode: "acme",
+		Features:     models.Features{models.FeatureAudit},
+		ExpiresAt:    time.Now().Add(90 * 24 * time.Hour),
+	}
+	tampered := signedFile(t, key, valid)
+	var l models.License
+	json.Unmarshal(tampered, &l)
+	l.Features = append(l.Features, models.FeatureMultiTenant)
+	tampered, _ = json.Marshal(l)
+
+	longExpired := valid
+	longExpired.ExpiresAt = time.Now().Add(-30 * 24 * time.Hour)
+	missingCode := valid
+	missingCode.CustomerCode = ""
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"valid", signedFile(t, key, valid), nil},
+		{"tampered features", tampered, ErrInvalidSignature},
+		{"signed by another key", signedFile(t, otherKey, valid), ErrInvalidSignature},
+		{"unsigned", func() []byte { d, _ := json.Marshal(valid); return d }(), ErrInvalidSignature},
+		{"expired beyond grace", signedFile(t, key, longExpired), ErrExpired},
+		{"missing customer code", signedFile(t, key, missingCode), ErrInvalidLicense},
+		{"not json", []byte("not a license"), ErrInvalidLicense},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Import(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Import() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if !m.HasFeature(models.FeatureAudit) || m.HasFeature(models.FeatureMultiTenant) {
+		t.Errorf("HasFeature() does not match the imported license")
+	}
+}
+
+func TestImportReplacesCustomerLicense(t *testing.T) {
+	m, key := setupManager(t)
+
+	var changed []string
+	m.OnChange(func(current *models.License) {
+		if current != nil {
+			changed = append(changed, current.ID)
+		}
+	})
+
+	first := models.License{ID: "lic-1", CustomerCode: "acme", ExpiresAt: time.Now().Add(24 * time.Hour)}
+	renewed := models.License{ID: "lic-2", CustomerCode: "acme", ExpiresAt: time.Now().Add(365 * 24 * time.Hour),
+		Features: models.Features{models.FeatureAdvancedRAID}}
+	for _, l := range []models.License{first, renewed} {
+		if _, err := m.Import(signedFile(t, key, l)); err != nil {
+			t.Fatalf("Import(%s) error = %v", l.ID, err)
+		}
+	}
+
+	statuses := m.Statuses()
+	if len(statuses) != 1 || statuses[0].License.ID != "lic-2" {
+		t.Errorf("Statuses() = %+v, want only lic-2", statuses)
+	}
+	if current := m.Current(); current == nil || current.ID != "lic-2" {
+		t.Errorf("Current() = %+v, want lic-2", current)
+	}
+	if len(changed) != 2 || changed[1] != "lic-2" {
+		t.Errorf("OnChange calls = %v", changed)
+	}
+}
+
+func TestLoadIgnoresInvalidSignature(t *testing.T) {
+	m, key := setupManager(t)
+	l := models.License{ID: "lic-1", CustomerCode: "acme", ExpiresAt: time.Now().Add(24 * time.Hour),
+		Features: models.Features{models.FeatureAudit}}
+	if _, err := m.Import(signedFile(t, key, l)); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	// 直接修改数据库中的License（绕过导入）后重新加载
+	m.db.Model(&models.License{}).Where("id = ?", "lic-1").
+		Update("features", `["audit","multi_tenant"]`)
+	if err := m.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(m.Statuses()) != 0 || m.HasFeature(models.FeatureAudit) {
+		t.Errorf("tampered license should be ignored after Load()")
+	}
+	if m.Current() != nil {
+		t.Errorf("Current() should be nil without valid licenses")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	m, _ := setupManager(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		wantState State
+		wantDays  int
+		usable    bool
+	}{
+		{"active", now.Add(90 * 24 * time.Hour), StateActive, 90, true},
+		{"expiring", now.Add(10 * 24 * time.Hour), StateExpiring, 10, true},
+		{"grace", now.Add(-3 * 24 * time.Hour), StateGrace, -3, true},
+		{"expired", now.Add(-15 * 24 * time.Hour), StateExpired, -15, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := m.status(models.License{ID: "lic", ExpiresAt: tt.expiresAt})
+			if s.State != tt.wantState || s.DaysLeft != tt.wantDays || s.Usable() != tt.usable {
+				t.Errorf("status() = %s/%d/%v, want %s/%d/%v",
+					s.State, s.DaysLeft, s.Usable(), tt.wantState, tt.wantDays, tt.usable)
+			}
+		})
+	}
+}
+
+func TestHasFeatureAfterGrace(t *testing.T) {
+	m, key := setupManager(t)
+	l := models.License{ID: "lic-1", CustomerCode: "acme", ExpiresAt: time.Now().Add(24 * time.Hour),
+		Features: models.Features{models.FeatureOfflineBundle}}
+	if _, err := m.Import(signedFile(t, key, l)); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	m.now = func() time.Time { return l.ExpiresAt.Add(24 * time.Hour) }
+	if !m.HasFeature(models.FeatureOfflineBundle) {
+		t.Errorf("feature should stay available during the grace period")
+	}
+	m.now = func() time.Time { return l.ExpiresAt.Add(DefaultGracePeriod + time.Hour) }
+	if m.HasFeature(models.FeatureOfflineBundle) {
+		t.Errorf("feature should be disabled after the grace period")
+	}
+}
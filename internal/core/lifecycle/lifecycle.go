@@ -0,0 +1,151 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+// 触发状态迁移的角色
+const (
+	ActorUser      = "user"
+	ActorAgent     = "agent"
+	ActorInstaller = "installer"
+	ActorSystem    = "system"
+)
+
+var (
+	// ErrInvalidTransition 状态机不允许的迁移
+	ErrInvalidTransition = errors.New("invalid machine status transition")
+	// ErrGuardRejected 迁移被前置条件拒绝
+	ErrGuardRejected = errors.New("machine status transition rejected")
+	// ErrConflict 迁移期间机器状态已被并发修改
+	ErrConflict = errors.New("machine status changed concurrently")
+)
+
+// Cause 迁移原因，写入 machine_events
+type Cause struct {
+	Actor  string
+	JobID  string
+	Reason string
+}
+
+// Guard 迁移前置条件，返回错误即拒绝迁移
+type Guard func(tx *gorm.DB, machine *models.Machine) error
+
+// guards 进入目标状态前需满足的条件
+var guards = map[models.MachineStatus][]Guard{
+	models.MachineStatusInstalling:     {requireInstallJob},
+	models.MachineStatusMaintenance:    {requireNoRunningJob},
+	models.MachineStatusDecommissioned: {requireNoRunningJob},
+}
+
+// Transition 迁移机器状态并记录事件
+// 同状态迁移为空操作，不记录事件；仅当数据库中的状态仍为 machine.Status 时迁移，否则返回 ErrConflict
+func Transition(db *gorm.DB, machine *models.Machine, to models.MachineStatus, cause Cause) error {
+	from := machine.Status
+	if from == to {
+		return nil
+	}
+	if !to.IsValid() || !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, guard := range guards[to] {
+			if err := guard(tx, machine); err != nil {
+				return fmt.Errorf("%w: %s -> %s: %v", ErrGuardRejected, from, to, err)
+			}
+		}
+
+		now := time.Now()
+		result := tx.Model(&models.Machine{}).Where("id = ? AND status = ?", machine.ID, from).
+			Updates(map[string]interface{}{"status": to, "updated_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update machine status: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %s -> %s", ErrConflict, from, to)
+		}
+
+		event := models.MachineEvent{
+			MachineID:  machine.ID,
+			FromStatus: from,
+			ToStatus:   to,
+			Actor:      cause.Actor,
+			JobID:      cause.JobID,
+			Reason:     cause.Reason,
+			CreatedAt:  now,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return fmt.Errorf("failed to record machine event: %w", err)
+		}
+
+		machine.Status = to
+		machine.UpdatedAt = now
+		return nil
+	})
+}
+
+// OnJobFinished 根据任务结果驱动机器状态
+// 安装成功 → active；任何任务失败 → error；其他结果不改变状态
+func OnJobFinished(db *gorm.DB, job *models.Job, actor string) error {
+	var target models.MachineStatus
+	switch {
+	case job.Status == models.JobStatusFailed:
+		target = models.MachineStatusError
+	case job.Status == models.JobStatusSuccess && slices.Contains(models.InstallJobTypes, job.Type):
+		target = models.MachineStatusActive
+	default:
+		return nil
+	}
+
+	var machine models.Machine
+	if err := db.Where("id = ?", job.MachineID).First(&machine).Error; err != nil {
+		return fmt.Errorf("machine not found: %s", job.MachineID)
+	}
+
+	reason := fmt.Sprintf("job %s %s", job.Type, job.Status)
+	if job.Error != "" {
+		reason += ": " + job.Error
+	}
+	return Transition(db, &machine, target, Cause{Actor: actor, JobID: job.ID, Reason: reason})
+}
+
+// History 查询机器状态迁移记录（按时间倒序）
+func History(db *gorm.DB, machineID string) ([]models.MachineEvent, error) {
+	var events []models.MachineEvent
+	err := db.Where("machine_id = ?", machineID).Order("id DESC").Find(&events).Error
+	return events, err
+}
+
+// requireInstallJob 进入 installing 前必须存在待执行或执行中的安装任务
+func requireInstallJob(tx *gorm.DB, machine *models.Machine) error {
+	var count int64
+	err := tx.Model(&models.Job{}).Where("machine_id = ? AND type IN (?) AND status IN (?)",
+		machine.ID, models.InstallJobTypes, []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}).
+		Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check install jobs: %w", err)
+	}
+	if count == 0 {
+		return errors.New("no pending install job")
+	}
+	return nil
+}
+
+// requireNoRunningJob 维护/下线前不能有执行中的任务
+func requireNoRunningJob(tx *gorm.DB, machine *models.Machine) error {
+	var count int64
+	if err := tx.Model(&models.Job{}).Where("machine_id = ? AND status = ?", machine.ID, models.JobStatusRunning).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check running jobs: %w", err)
+	}
+	if count > 0 {
+		return errors.New("machine has running jobs")
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Machine{}, &models.Job{}, &models.MachineEvent{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return db
+}
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    models.MachineStatus
+		to      models.MachineStatus
+		withJob models.JobStatus
+		wantErr error
+	}{
+		{"discovered to ready", models.MachineStatusDiscovered, models.MachineStatusReady, "", nil},
+		{"skip ready", models.MachineStatusDiscovered, models.MachineStatusActive, "", ErrInvalidTransition},
+		{"unknown status", models.MachineStatusReady, "broken", "", ErrInvalidTransition},
+		{"install without job", models.MachineStatusReady, models.MachineStatusInstalling, "", ErrGuardRejected},
+		{"install with job", models.MachineStatusReady, models.MachineStatusInstalling, models.JobStatusPending, nil},
+		{"maintenance with running job", models.MachineStatusActive, models.MachineStatusMaintenance, models.JobStatusRunning, ErrGuardRejected},
+		{"decommission", models.MachineStatusMaintenance, models.MachineStatusDecommissioned, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			machine := models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: tt.from}
+			db.Create(&machine)
+			if tt.withJob != "" {
+				db.Create(&models.Job{ID: "job-1", MachineID: machine.ID, Type: models.JobTypeInstallOS, Status: tt.withJob})
+			}
+
+			err := Transition(db, &machine, tt.to, Cause{Actor: ActorUser, Reason: "test"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Transition() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var stored models.Machine
+			db.First(&stored, "id = ?", machine.ID)
+			events, _ := History(db, machine.ID)
+
+			if tt.wantErr != nil {
+				if stored.Status != tt.from || len(events) != 0 {
+					t.Errorf("rejected transition changed state: status = %s, events = %d", stored.Status, len(events))
+				}
+				return
+			}
+			if stored.Status != tt.to {
+				t.Errorf("status = %s, want %s", stored.Status, tt.to)
+			}
+			if len(events) != 1 || events[0].FromStatus != tt.from || events[0].ToStatus != tt.to || events[0].Actor != ActorUser {
+				t.Errorf("events = %+v", events)
+			}
+		})
+	}
+}
+
+func TestTransition_Conflict(t *testing.T) {
+	db := setupTestDB(t)
+	machine := models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusDiscovered}
+	db.Create(&machine)
+
+	// 两个请求基于同一份旧状态迁移，只有先提交的成功
+	stale := machine
+	if err := Transition(db, &machine, models.MachineStatusReady, Cause{Actor: ActorUser}); err != nil {
+		t.Fatalf("Transition() error = %v", err)
+	}
+	if err := Transition(db, &stale, models.MachineStatusError, Cause{Actor: ActorUser}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Transition() with stale status error = %v, want %v", err, ErrConflict)
+	}
+
+	var stored models.Machine
+	db.First(&stored, "id = ?", machine.ID)
+	events, _ := History(db, machine.ID)
+	if stored.Status != models.MachineStatusReady || len(events) != 1 {
+		t.Errorf("status = %s, events = %d, want ready with 1 event", stored.Status, len(events))
+	}
+}
+
+func TestOnJobFinished(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobType models.JobType
+		status  models.JobStatus
+		want    models.MachineStatus
+	}{
+		{"install success", models.JobTypeInstallOS, models.JobStatusSuccess, models.MachineStatusActive},
+		{"install failure", models.JobTypeInstallOS, models.JobStatusFailed, models.MachineStatusError},
+		{"raid success keeps status", models.JobTypeConfigRAID, models.JobStatusSuccess, models.MachineStatusInstalling},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusInstalling})
+			job := models.Job{ID: "job-1", MachineID: "machine-1", Type: tt.jobType, Status: tt.status, Error: "disk not found"}
+			db.Create(&job)
+
+			if err := OnJobFinished(db, &job, ActorInstaller); err != nil {
+				t.Fatalf("OnJobFinished() error = %v", err)
+			}
+
+			var stored models.Machine
+			db.First(&stored, "id = ?", "machine-1")
+			if stored.Status != tt.want {
+				t.Errorf("status = %s, want %s", stored.Status, tt.want)
+			}
+			if tt.want != models.MachineStatusInstalling {
+				events, _ := History(db, "machine-1")
+				if len(events) != 1 || events[0].JobID != "job-1" {
+					t.Errorf("events = %+v, want one event for job-1", events)
+				}
+			}
+		})
+	}
+}
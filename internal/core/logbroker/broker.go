@@ -2,86 +2,128 @@ package logbroker
 
 import (
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 	"time"
 )
 
+// historyLimit 每个Job在内存中保留的历史日志条数
+const historyLimit = 1000
+
 // LogMessage 日志消息
 type LogMessage struct {
+	// ID Job内单调递增的日志ID（发布时分配，用作SSE事件ID）
+	ID        int64                  `json:"id"`
 	Timestamp time.Time              `json:"ts"`
 	Level     string                 `json:"level"` // DEBUG, INFO, WARN, ERROR
 	Component string                 `json:"component"`
 	Message   string                 `json:"msg"`
 	Data      map[string]interface{} `json:"data,omitempty"`
+	// Seq Agent侧序号（每个日志流单调递增），0表示无序号
+	Seq int64 `json:"seq,omitempty"`
 }
 
 // Broker 日志代理，管理多个Job的日志流
 type Broker struct {
 	mu sync.RWMutex
-	// jobID -> []chan LogMessage
-	subscribers map[string][]chan LogMessage
+	// jobID -> []*Subscription
+	subscribers map[string][]*Subscription
+	// Subscribe 返回的channel -> 对应订阅
+	legacy map[<-chan LogMessage]*legacySubscriber
+	// jobID -> 已分配的最大日志ID
+	lastID map[string]int64
 	// jobID -> []LogMessage (历史日志)
 	history map[string][]LogMessage
+	// jobID/stream -> 已接收的最大序号（用于重放去重）
+	lastSeq map[string]int64
+	// store 持久化存储，为 nil 时仅保留内存历史
+	store Store
 }
 
 // NewBroker 创建新的Broker
 func NewBroker() *Broker {
 	return &Broker{
-		subscribers: make(map[string][]chan LogMessage),
+		subscribers: make(map[string][]*Subscription),
+		legacy:      make(map[<-chan LogMessage]*legacySubscriber),
+		lastID:      make(map[string]int64),
 		history:     make(map[string][]LogMessage),
+		lastSeq:     make(map[string]int64),
 	}
 }
 
-// Subscribe 订阅指定Job的日志流
-// 返回一个channel，客户端从中接收日志消息
-func (b *Broker) Subscribe(jobID string) <-chan LogMessage {
+// SetStore 设置日志持久化存储
+func (b *Broker) SetStore(store Store) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	// 创建缓冲channel
-	ch := make(chan LogMessage, 100)
+	b.store = store
+}
 
-	// 添加到订阅列表
-	if _, ok := b.subscribers[jobID]; !ok {
-		b.subscribers[jobID] = make([]chan LogMessage, 0)
-	}
-	b.subscribers[jobID] = append(b.subscribers[jobID], ch)
+// Store 返回日志持久化存储（未设置时为 nil）
+func (b *Broker) Store() Store {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 
-	// 立即发送历史日志
-	if history, ok := b.history[jobID]; ok {
-		go func() {
-			for _, msg := range history {
-				select {
-				case ch <- msg:
-				default:
-					// channel满了，跳过
-				}
-			}
-		}()
-	}
+	return b.store
+}
 
-	return ch
+// legacySubscriber Subscribe 的channel适配
+type legacySubscriber struct {
+	sub  *Subscription
+	stop chan struct{}
 }
 
-// Unsubscribe 取消订阅
-func (b *Broker) Unsubscribe(jobID string, ch <-chan LogMessage) {
+// Subscribe 订阅指定Job的日志流
+// 返回一个channel，客户端从中接收日志消息（先历史后实时，不丢弃）；
+// 需要断点续传或丢失通知时使用 SubscribeFrom
+func (b *Broker) Subscribe(jobID string) <-chan LogMessage {
+	sub := b.SubscribeFrom(jobID, 0)
+	out := make(chan LogMessage, 100)
+	ls := &legacySubscriber{sub: sub, stop: make(chan struct{})}
+
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	b.legacy[out] = ls
+	b.mu.Unlock()
 
-	if subs, ok := b.subscribers[jobID]; ok {
-		// 找到并移除channel
-		for i, subscriber := range subs {
-			if subscriber == ch {
-				b.subscribers[jobID] = append(subs[:i], subs[i+1:]...)
-				close(subscriber)
-				break
+	go func() {
+		defer close(out)
+		forward := func(ev Event) bool {
+			if ev.Type != EventLog {
+				return true
+			}
+			select {
+			case out <- ev.Message:
+				return true
+			case <-ls.stop:
+				return false
 			}
 		}
-
-		// 如果没有订阅者了，清理
-		if len(b.subscribers[jobID]) == 0 {
-			delete(b.subscribers, jobID)
+		for _, ev := range sub.Backlog() {
+			if !forward(ev) {
+				return
+			}
+		}
+		for ev := range sub.Events() {
+			if !forward(ev) {
+				return
+			}
 		}
+	}()
+
+	return out
+}
+
+// Unsubscribe 取消订阅
+func (b *Broker) Unsubscribe(jobID string, ch <-chan LogMessage) {
+	b.mu.Lock()
+	ls, ok := b.legacy[ch]
+	delete(b.legacy, ch)
+	b.mu.Unlock()
+
+	if ok {
+		close(ls.stop)
+		ls.sub.Close()
 	}
 }
 
@@ -90,29 +132,75 @@ func (b *Broker) Publish(jobID string, msg LogMessage) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	b.publishLocked(jobID, msg)
+}
+
+// PublishSequenced 发布带序号的Agent日志，丢弃已接收过的序号
+// Agent断线重连后会重放未确认的日志，同一日志流内序号不大于已接收最大序号的视为重复。
+// 返回是否实际发布
+func (b *Broker) PublishSequenced(jobID, stream string, msg LogMessage) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := jobID + "/" + stream
+	if msg.Seq <= b.lastSeq[key] {
+		return false
+	}
+	b.lastSeq[key] = msg.Seq
+	b.publishLocked(jobID, msg)
+	return true
+}
+
+// LastSeq 返回指定日志流已接收的最大序号
+func (b *Broker) LastSeq(jobID, stream string) int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.lastSeq[jobID+"/"+stream]
+}
+
+func (b *Broker) publishLocked(jobID string, msg LogMessage) {
+	msg.ID = b.lastIDLocked(jobID) + 1
+	b.lastID[jobID] = msg.ID
+
+	// 持久化
+	if b.store != nil {
+		if err := b.store.Append(jobID, msg); err != nil {
+			log.Printf("⚠️  任务 %s 日志持久化失败: %v", jobID, err)
+		}
+	}
+
 	// 保存到历史
 	if _, ok := b.history[jobID]; !ok {
-		b.history[jobID] = make([]LogMessage, 0, 1000)
+		b.history[jobID] = make([]LogMessage, 0, historyLimit)
 	}
 	b.history[jobID] = append(b.history[jobID], msg)
 
 	// 限制历史日志大小
-	if len(b.history[jobID]) > 1000 {
-		b.history[jobID] = b.history[jobID][len(b.history[jobID])-1000:]
+	if len(b.history[jobID]) > historyLimit {
+		b.history[jobID] = b.history[jobID][len(b.history[jobID])-historyLimit:]
 	}
 
-	// 发送给所有订阅者
-	if subs, ok := b.subscribers[jobID]; ok {
-		for _, ch := range subs {
-			select {
-			case ch <- msg:
-			default:
-				// channel满了，跳过（避免阻塞）
-			}
-		}
+	// 发送给所有订阅者（慢消费者记录丢失范围，不阻塞）
+	for _, sub := range b.subscribers[jobID] {
+		sub.deliverLocked(msg)
 	}
 }
 
+// lastIDLocked Job已分配的最大日志ID，重启后从持久化存储恢复
+func (b *Broker) lastIDLocked(jobID string) int64 {
+	if id, ok := b.lastID[jobID]; ok || b.store == nil {
+		return id
+	}
+
+	var id int64
+	if tail := b.recentHistoryLocked(jobID); len(tail) > 0 {
+		id = tail[len(tail)-1].ID
+	}
+	b.lastID[jobID] = id
+	return id
+}
+
 // PublishHTML 发布HTML格式的日志（用于SSE）
 func (b *Broker) PublishHTML(jobID string, level string, message string) {
 	msg := LogMessage{
@@ -125,18 +213,74 @@ func (b *Broker) PublishHTML(jobID string, level string, message string) {
 }
 
 // GetHistory 获取指定Job的历史日志
+// 配置了持久化存储时返回完整日志，否则返回内存中最近的日志
+// 读取持久化存储时不持有锁，不阻塞发布
 func (b *Broker) GetHistory(jobID string) []LogMessage {
 	b.mu.RLock()
-	defer b.mu.RUnlock()
+	store := b.store
+	history := make([]LogMessage, len(b.history[jobID]))
+	copy(history, b.history[jobID])
+	b.mu.RUnlock()
 
-	if history, ok := b.history[jobID]; ok {
-		// 返回副本
-		result := make([]LogMessage, len(history))
-		copy(result, history)
-		return result
+	if store != nil {
+		result := []LogMessage{}
+		err := store.Scan(jobID, func(msg LogMessage) bool {
+			result = append(result, msg)
+			return true
+		})
+		if err == nil {
+			return result
+		}
+		log.Printf("⚠️  读取任务 %s 持久化日志失败: %v", jobID, err)
 	}
 
-	return []LogMessage{}
+	return history
+}
+
+// Seal 任务结束后归档日志并释放内存历史，返回日志存储位置（未配置存储时为空）
+func (b *Broker) Seal(jobID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.store == nil {
+		return "", nil
+	}
+	if err := b.store.Seal(jobID); err != nil {
+		return "", err
+	}
+
+	delete(b.history, jobID)
+	delete(b.lastID, jobID)
+	for key := range b.lastSeq {
+		if strings.HasPrefix(key, jobID+"/") {
+			delete(b.lastSeq, key)
+		}
+	}
+	return b.store.Path(jobID), nil
+}
+
+// recentHistoryLocked 内存中的最近历史，内存中没有时从持久化存储加载
+func (b *Broker) recentHistoryLocked(jobID string) []LogMessage {
+	if history, ok := b.history[jobID]; ok || b.store == nil {
+		return history
+	}
+
+	var tail []LogMessage
+	err := b.store.Scan(jobID, func(msg LogMessage) bool {
+		tail = append(tail, msg)
+		if len(tail) > historyLimit {
+			tail = tail[1:]
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("⚠️  读取任务 %s 持久化日志失败: %v", jobID, err)
+		return nil
+	}
+	if len(tail) > 0 {
+		b.history[jobID] = tail
+	}
+	return tail
 }
 
 // ClearHistory 清理指定Job的历史日志
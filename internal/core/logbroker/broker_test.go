@@ -187,6 +187,38 @@ func TestBroker_ClearHistory(t *testing.T) {
 	}
 }
 
+func TestBroker_PublishSequenced(t *testing.T) {
+	broker := NewBroker()
+	jobID := "test-job-seq"
+
+	tests := []struct {
+		stream string
+		seq    int64
+		want   bool
+	}{
+		{"s1", 1, true},
+		{"s1", 2, true},
+		{"s1", 2, false}, // replayed
+		{"s1", 1, false}, // replayed
+		{"s2", 1, true},  // independent stream
+		{"s1", 3, true},
+	}
+
+	for _, tt := range tests {
+		msg := LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "line", Seq: tt.seq}
+		if got := broker.PublishSequenced(jobID, tt.stream, msg); got != tt.want {
+			t.Errorf("PublishSequenced(%s, %d) = %v, want %v", tt.stream, tt.seq, got, tt.want)
+		}
+	}
+
+	if got := len(broker.GetHistory(jobID)); got != 4 {
+		t.Errorf("History length = %d, want 4", got)
+	}
+	if got := broker.LastSeq(jobID, "s1"); got != 3 {
+		t.Errorf("LastSeq(s1) = %d, want 3", got)
+	}
+}
+
 func TestLogMessage_FormatAsHTML(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -251,3 +283,108 @@ func TestLogMessage_FormatAsHTML(t *testing.T) {
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || contains(s[1:], substr)))
 }
+
+func TestBroker_SubscribeFrom(t *testing.T) {
+	broker := NewBroker()
+	jobID := "test-job-resume"
+
+	for i := 0; i < 5; i++ {
+		broker.Publish(jobID, LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "line"})
+	}
+
+	tests := []struct {
+		name    string
+		afterID int64
+		wantIDs []int64
+	}{
+		{"from start", 0, []int64{1, 2, 3, 4, 5}},
+		{"resume", 3, []int64{4, 5}},
+		{"up to date", 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := broker.SubscribeFrom(jobID, tt.afterID)
+			defer sub.Close()
+
+			var ids []int64
+			for _, ev := range sub.Backlog() {
+				if ev.Type != EventLog {
+					t.Fatalf("unexpected backlog event %v", ev.Type)
+				}
+				ids = append(ids, ev.Message.ID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("backlog IDs = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("backlog IDs = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestBroker_SubscribeFrom_HistoryTrimmed(t *testing.T) {
+	broker := NewBroker()
+	jobID := "test-job-trimmed"
+
+	for i := 0; i < historyLimit+10; i++ {
+		broker.Publish(jobID, LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "line"})
+	}
+
+	sub := broker.SubscribeFrom(jobID, 0)
+	defer sub.Close()
+
+	backlog := sub.Backlog()
+	if len(backlog) == 0 || backlog[0].Type != EventGap || backlog[0].From != 1 || backlog[0].To != 10 {
+		t.Fatalf("first backlog event = %+v, want gap 1-10", backlog[0])
+	}
+}
+
+func TestBroker_SlowConsumerGap(t *testing.T) {
+	broker := NewBroker()
+	jobID := "test-job-slow"
+
+	sub := broker.SubscribeFrom(jobID, 0)
+	total := subscriberBuffer + 20
+	for i := 0; i < total; i++ {
+		broker.Publish(jobID, LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "line"})
+	}
+
+	// 消费者赶上后，下一条日志前先收到丢失范围
+	for i := 0; i < subscriberBuffer; i++ {
+		if ev := <-sub.Events(); ev.Type != EventLog || ev.Message.ID != int64(i+1) {
+			t.Fatalf("event %d = %+v", i, ev)
+		}
+	}
+	broker.Publish(jobID, LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "after"})
+
+	gap := <-sub.Events()
+	if gap.Type != EventGap || gap.From != int64(subscriberBuffer+1) || gap.To != int64(total) {
+		t.Errorf("gap = %+v, want %d-%d", gap, subscriberBuffer+1, total)
+	}
+	if ev := <-sub.Events(); ev.Type != EventLog || ev.Message.ID != int64(total+1) {
+		t.Errorf("event after gap = %+v", ev)
+	}
+
+	// 可以从历史补回丢失的日志
+	if got := len(broker.Range(jobID, gap.From, gap.To)); got != 20 {
+		t.Errorf("Range() = %d messages, want 20", got)
+	}
+
+	// 任务结束事件即使缓冲区已满也能送达，随后关闭
+	for i := 0; i < subscriberBuffer+5; i++ {
+		broker.Publish(jobID, LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "line"})
+	}
+	broker.Finish(jobID, "success")
+
+	var last Event
+	for ev := range sub.Events() {
+		last = ev
+	}
+	if last.Type != EventFinished || last.Status != "success" {
+		t.Errorf("last event = %+v, want finished", last)
+	}
+}
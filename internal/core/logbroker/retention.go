@@ -0,0 +1,62 @@
+package logbroker
+
+import (
+	"log"
+	"time"
+)
+
+// Retention 定时清理过期的任务日志
+type Retention struct {
+	store    Store
+	maxAge   time.Duration
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+// NewRetention 创建日志保留策略，删除最后写入超过 maxAge 的任务日志
+func NewRetention(store Store, maxAge, interval time.Duration) *Retention {
+	return &Retention{
+		store:    store,
+		maxAge:   maxAge,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动定时清理
+func (r *Retention) Start() {
+	log.Printf("🗂️  启动任务日志清理 (保留: %v, 间隔: %v)", r.maxAge, r.interval)
+
+	go r.prune()
+
+	ticker := time.NewTicker(r.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.prune()
+			case <-r.stopCh:
+				ticker.Stop()
+				log.Println("🗂️  任务日志清理已停止")
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定时清理
+func (r *Retention) Stop() {
+	close(r.stopCh)
+}
+
+// prune 执行一次清理
+func (r *Retention) prune() {
+	n, err := r.store.Prune(time.Now().Add(-r.maxAge))
+	if err != nil {
+		log.Printf("❌ 任务日志清理失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("🗂️  已清理 %d 个过期任务日志", n)
+	}
+}
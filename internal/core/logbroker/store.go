@@ -0,0 +1,355 @@
+package logbroker
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSegmentSize 活动段超过该大小后压缩归档
+const DefaultSegmentSize = 4 << 20
+
+// ErrInvalidJobID 任务ID不能作为存储路径
+var ErrInvalidJobID = errors.New("invalid job id")
+
+// Store 日志持久化存储
+type Store interface {
+	// Append 追加日志
+	Append(jobID string, msgs ...LogMessage) error
+	// Scan 按写入顺序遍历任务的全部日志，fn 返回 false 时停止
+	Scan(jobID string, fn func(LogMessage) bool) error
+	// Seal 任务结束后归档（压缩活动段）
+	Seal(jobID string) error
+	// Path 任务日志的存储位置
+	Path(jobID string) string
+	// Prune 删除最后写入早于 before 的任务日志，返回删除的任务数
+	Prune(before time.Time) (int, error)
+}
+
+const (
+	activeSegment = "active.ndjson"
+	sealedSuffix  = ".ndjson.gz"
+)
+
+// FileStore 基于文件的日志存储
+//
+// 每个任务一个目录，日志以NDJSON追加到活动段 active.ndjson，
+// 超过段大小或任务结束时压缩为 000001.ndjson.gz、000002.ndjson.gz ...
+type FileStore struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+}
+
+// NewFileStore 创建文件日志存储
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log dir: %w", err)
+	}
+	return &FileStore{dir: dir, segmentSize: DefaultSegmentSize}, nil
+}
+
+// SetSegmentSize 设置活动段归档阈值（字节）
+func (s *FileStore) SetSegmentSize(size int64) {
+	s.segmentSize = size
+}
+
+// Path 任务日志目录
+func (s *FileStore) Path(jobID string) string {
+	return filepath.Join(s.dir, jobID)
+}
+
+// Append 追加日志到活动段
+func (s *FileStore) Append(jobID string, msgs ...LogMessage) error {
+	if err := validateJobID(jobID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := s.Path(jobID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, activeSegment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= s.segmentSize {
+		return s.sealLocked(dir)
+	}
+	return nil
+}
+
+// Scan 依次读取已归档段和活动段
+// 只在打开段文件时持有锁，解压和解析在锁外进行，不阻塞并发的 Append；
+// 活动段只读到打开时的长度，已打开的文件不受之后的归档和清理影响
+func (s *FileStore) Scan(jobID string, fn func(LogMessage) bool) error {
+	if err := validateJobID(jobID); err != nil {
+		return err
+	}
+
+	segments, err := s.openSegments(s.Path(jobID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer closeSegments(segments)
+
+	for _, seg := range segments {
+		more, err := scanSegment(seg, fn)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+// segmentFile 已打开的日志段，size 为活动段打开时的长度（归档段为 -1）
+type segmentFile struct {
+	name string
+	file *os.File
+	size int64
+}
+
+// openSegments 在锁内按顺序打开任务的全部段
+func (s *FileStore) openSegments(dir string) ([]segmentFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := sealedSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segmentFile
+	for _, name := range append(names, activeSegment) {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			closeSegments(segments)
+			return nil, err
+		}
+		seg := segmentFile{name: name, file: f, size: -1}
+		if name == activeSegment {
+			info, err := f.Stat()
+			if err != nil {
+				f.Close()
+				closeSegments(segments)
+				return nil, err
+			}
+			seg.size = info.Size()
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+func closeSegments(segments []segmentFile) {
+	for _, seg := range segments {
+		seg.file.Close()
+	}
+}
+
+// Seal 压缩活动段
+func (s *FileStore) Seal(jobID string) error {
+	if err := validateJobID(jobID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sealLocked(s.Path(jobID))
+}
+
+// Prune 删除过期任务日志
+func (s *FileStore) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, entry.Name())
+		last, err := lastModified(dir)
+		if err != nil || !last.Before(before) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// sealLocked 将活动段压缩为下一个归档段
+func (s *FileStore) sealLocked(dir string) error {
+	active := filepath.Join(dir, activeSegment)
+	src, err := os.Open(active)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer src.Close()
+
+	segments, err := sealedSegments(dir)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%06d%s", len(segments)+1, sealedSuffix)
+	tmp := filepath.Join(dir, name+".tmp")
+
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return os.Remove(active)
+}
+
+// sealedSegments 按序号排列的归档段
+func sealedSegments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), sealedSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// scanSegment 读取一个段，返回是否继续
+func scanSegment(seg segmentFile, fn func(LogMessage) bool) (bool, error) {
+	var r io.Reader = seg.file
+	if seg.size >= 0 {
+		r = io.LimitReader(seg.file, seg.size)
+	}
+	if strings.HasSuffix(seg.name, ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return false, fmt.Errorf("corrupt log segment %s: %w", seg.name, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg LogMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			// 跳过写入中断导致的残行
+			continue
+		}
+		if !fn(msg) {
+			return false, nil
+		}
+	}
+	return true, scanner.Err()
+}
+
+// lastModified 目录中最新文件的修改时间
+func lastModified(dir string) (time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var last time.Time
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	if last.IsZero() {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return time.Time{}, err
+		}
+		last = info.ModTime()
+	}
+	return last, nil
+}
+
+func validateJobID(jobID string) error {
+	if jobID == "" || jobID == "." || jobID == ".." || strings.ContainsAny(jobID, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidJobID, jobID)
+	}
+	return nil
+}
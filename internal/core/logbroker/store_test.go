@@ -0,0 +1,211 @@
+package logbroker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, store Store, jobID string) []LogMessage {
+	t.Helper()
+	var msgs []LogMessage
+	if err := store.Scan(jobID, func(msg LogMessage) bool {
+		msgs = append(msgs, msg)
+		return true
+	}); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	return msgs
+}
+
+func TestFileStore_AppendRotateScan(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetSegmentSize(512)
+
+	for i := 0; i < 50; i++ {
+		msg := LogMessage{Timestamp: time.Now(), Level: "INFO", Component: "agent", Message: fmt.Sprintf("line %d", i)}
+		if err := store.Append("job-1", msg); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(store.Path("job-1"), "*"+sealedSuffix))
+	if len(segments) < 2 {
+		t.Errorf("expected rotated segments, got %v", segments)
+	}
+
+	msgs := collect(t, store, "job-1")
+	if len(msgs) != 50 {
+		t.Fatalf("Scan() returned %d messages, want 50", len(msgs))
+	}
+	for i, msg := range msgs {
+		if want := fmt.Sprintf("line %d", i); msg.Message != want {
+			t.Errorf("message %d = %q, want %q", i, msg.Message, want)
+		}
+	}
+
+	// 归档后仍可完整读取
+	if err := store.Seal("job-1"); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path("job-1"), activeSegment)); !os.IsNotExist(err) {
+		t.Error("active segment should be compressed after Seal()")
+	}
+	if got := len(collect(t, store, "job-1")); got != 50 {
+		t.Errorf("Scan() after Seal() returned %d messages, want 50", got)
+	}
+
+	// 未知任务没有日志
+	if got := len(collect(t, store, "job-unknown")); got != 0 {
+		t.Errorf("Scan() for unknown job returned %d messages", got)
+	}
+}
+
+func TestFileStore_InvalidJobID(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", "..", "../etc", `a\b`} {
+		if err := store.Append(id, LogMessage{Message: "x"}); !errors.Is(err, ErrInvalidJobID) {
+			t.Errorf("Append(%q) error = %v, want ErrInvalidJobID", id, err)
+		}
+	}
+}
+
+func TestFileStore_Prune(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Append("old-job", LogMessage{Message: "old"})
+	store.Append("new-job", LogMessage{Message: "new"})
+
+	past := time.Now().Add(-60 * 24 * time.Hour)
+	os.Chtimes(filepath.Join(store.Path("old-job"), activeSegment), past, past)
+
+	n, err := store.Prune(time.Now().Add(-30 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Prune() = %d, want 1", n)
+	}
+	if got := len(collect(t, store, "old-job")); got != 0 {
+		t.Errorf("old job still has %d messages", got)
+	}
+	if got := len(collect(t, store, "new-job")); got != 1 {
+		t.Errorf("new job has %d messages, want 1", got)
+	}
+}
+
+func TestBroker_PersistentHistory(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := NewBroker()
+	broker.SetStore(store)
+	for i := 0; i < 1200; i++ {
+		broker.Publish("job-1", LogMessage{Timestamp: time.Now(), Level: "INFO", Message: fmt.Sprintf("line %d", i)})
+	}
+	if _, err := broker.Seal("job-1"); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	// 重启后从存储读取完整历史（超过内存上限）
+	restarted := NewBroker()
+	restarted.SetStore(store)
+	history := restarted.GetHistory("job-1")
+	if len(history) != 1200 {
+		t.Fatalf("GetHistory() length = %d, want 1200", len(history))
+	}
+
+	// 订阅时回放完整历史，日志ID在重启后继续递增
+	ch := restarted.Subscribe("job-1")
+	for i := 0; i < 1200; i++ {
+		select {
+		case msg := <-ch:
+			if msg.ID != int64(i+1) {
+				t.Fatalf("replayed message %d has ID %d", i, msg.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for replayed history after %d messages", i)
+		}
+	}
+	restarted.Publish("job-1", LogMessage{Timestamp: time.Now(), Level: "INFO", Message: "late"})
+	if history := restarted.GetHistory("job-1"); history[len(history)-1].ID != 1201 {
+		t.Errorf("ID after restart = %d, want 1201", history[len(history)-1].ID)
+	}
+}
+
+// blockingStore 读取第一条日志时阻塞，模拟读取大任务的持久化日志
+type blockingStore struct {
+	*FileStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Scan(jobID string, fn func(LogMessage) bool) error {
+	first := true
+	return s.FileStore.Scan(jobID, func(msg LogMessage) bool {
+		if first {
+			first = false
+			close(s.started)
+			<-s.release
+		}
+		return fn(msg)
+	})
+}
+
+func TestBroker_SubscribeFromDoesNotBlockPublish(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &blockingStore{FileStore: fs, started: make(chan struct{}), release: make(chan struct{})}
+	broker := NewBroker()
+	broker.SetStore(store)
+	for i := 0; i < 3; i++ {
+		broker.Publish("job-1", LogMessage{Level: "INFO", Message: fmt.Sprintf("line %d", i)})
+	}
+
+	subscribed := make(chan *Subscription)
+	go func() { subscribed <- broker.SubscribeFrom("job-1", 0) }()
+	<-store.started
+
+	// 订阅方读取历史期间发布不被阻塞
+	published := make(chan struct{})
+	go func() {
+		broker.Publish("job-1", LogMessage{Level: "INFO", Message: "live"})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish blocked while a subscriber was reading history")
+	}
+
+	close(store.release)
+	sub := <-subscribed
+	defer sub.Close()
+
+	var ids []int64
+	for _, ev := range sub.Backlog() {
+		ids = append(ids, ev.Message.ID)
+	}
+	ev := <-sub.Events()
+	ids = append(ids, ev.Message.ID)
+	if fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Errorf("backlog + live IDs = %v, want [1 2 3 4]", ids)
+	}
+}
@@ -0,0 +1,217 @@
+package logbroker
+
+import (
+	"log"
+)
+
+// subscriberBuffer 订阅者缓冲的事件数，额外预留两个位置给 gap 和 finished 事件
+const subscriberBuffer = 256
+
+// EventType 订阅事件类型
+type EventType string
+
+const (
+	// EventLog 日志消息
+	EventLog EventType = "log"
+	// EventGap 一段日志未能送达（消费过慢或历史已不可用）
+	EventGap EventType = "gap"
+	// EventFinished 任务结束，之后不会再有日志
+	EventFinished EventType = "finished"
+)
+
+// Event 订阅事件
+type Event struct {
+	Type    EventType
+	Message LogMessage // EventLog
+	From    int64      // EventGap 丢失的日志ID范围（闭区间）
+	To      int64
+	Status  string // EventFinished 任务最终状态
+}
+
+// Subscription 一个Job日志流的订阅
+//
+// 订阅时先通过 Backlog 取得 afterID 之后的历史，再从 Events 接收实时事件，两者之间不会重复或遗漏。
+// 消费过慢时不会阻塞发布方：溢出的日志记为一个丢失范围，缓冲区有空位后以 EventGap 通知。
+type Subscription struct {
+	jobID   string
+	broker  *Broker
+	ch      chan Event
+	backlog []Event
+
+	// 尚未通知的丢失范围
+	missFrom int64
+	missTo   int64
+	closed   bool
+}
+
+// Backlog 订阅前的历史事件
+func (s *Subscription) Backlog() []Event {
+	return s.backlog
+}
+
+// Events 实时事件，任务结束或取消订阅后关闭
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close 取消订阅
+func (s *Subscription) Close() {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeLocked(s)
+}
+
+// deliverLocked 投递日志，缓冲区满时记录丢失范围
+func (s *Subscription) deliverLocked(msg LogMessage) {
+	if s.closed {
+		return
+	}
+	if s.missTo > 0 {
+		if !s.trySend(Event{Type: EventGap, From: s.missFrom, To: s.missTo}) {
+			s.missTo = msg.ID
+			return
+		}
+		s.missFrom, s.missTo = 0, 0
+	}
+	if !s.trySend(Event{Type: EventLog, Message: msg}) {
+		s.missFrom, s.missTo = msg.ID, msg.ID
+	}
+}
+
+// finishLocked 发送结束事件并关闭（使用预留位置，不会阻塞）
+func (s *Subscription) finishLocked(status string) {
+	if s.closed {
+		return
+	}
+	if s.missTo > 0 {
+		s.ch <- Event{Type: EventGap, From: s.missFrom, To: s.missTo}
+	}
+	s.ch <- Event{Type: EventFinished, Status: status}
+	s.closed = true
+	close(s.ch)
+}
+
+func (s *Subscription) trySend(ev Event) bool {
+	if len(s.ch) >= subscriberBuffer {
+		return false
+	}
+	s.ch <- ev
+	return true
+}
+
+// SubscribeFrom 订阅指定Job的日志流，afterID 为客户端已收到的最后日志ID（SSE Last-Event-ID），0表示从头开始
+//
+// 在锁内登记订阅并记下当前最大日志ID，之后的日志直接投递给订阅；
+// 此前的历史在锁外从持久化存储读取，读取大任务的日志不阻塞发布
+func (b *Broker) SubscribeFrom(jobID string, afterID int64) *Subscription {
+	sub := &Subscription{
+		jobID:  jobID,
+		broker: b,
+		ch:     make(chan Event, subscriberBuffer+2),
+	}
+
+	b.mu.Lock()
+	last := b.lastIDLocked(jobID)
+	store := b.store
+	var history []LogMessage
+	if store == nil {
+		for _, msg := range b.history[jobID] {
+			if msg.ID > afterID {
+				history = append(history, msg)
+			}
+		}
+	}
+	b.subscribers[jobID] = append(b.subscribers[jobID], sub)
+	b.mu.Unlock()
+
+	// 历史：有持久化存储时读取完整日志，否则只有内存中最近的日志
+	if store != nil {
+		err := store.Scan(jobID, func(msg LogMessage) bool {
+			if msg.ID > last {
+				return false
+			}
+			if msg.ID > afterID || afterID == 0 {
+				history = append(history, msg)
+			}
+			return true
+		})
+		if err != nil {
+			log.Printf("⚠️  读取任务 %s 持久化日志失败: %v", jobID, err)
+			history = nil
+		}
+	}
+
+	// 客户端期望的下一条日志已不可用时先通知丢失范围
+	next := afterID + 1
+	if len(history) > 0 && history[0].ID > next {
+		sub.backlog = append(sub.backlog, Event{Type: EventGap, From: next, To: history[0].ID - 1})
+	} else if len(history) == 0 && last >= next {
+		sub.backlog = append(sub.backlog, Event{Type: EventGap, From: next, To: last})
+	}
+	for _, msg := range history {
+		sub.backlog = append(sub.backlog, Event{Type: EventLog, Message: msg})
+	}
+	return sub
+}
+
+// Range 读取指定ID范围（闭区间）的日志，用于补发丢失范围
+// 读取持久化存储时不持有锁
+func (b *Broker) Range(jobID string, from, to int64) []LogMessage {
+	b.mu.RLock()
+	store := b.store
+	var memory []LogMessage
+	for _, msg := range b.history[jobID] {
+		if msg.ID >= from && msg.ID <= to {
+			memory = append(memory, msg)
+		}
+	}
+	b.mu.RUnlock()
+
+	if store != nil {
+		var result []LogMessage
+		err := store.Scan(jobID, func(msg LogMessage) bool {
+			if msg.ID >= from && msg.ID <= to {
+				result = append(result, msg)
+			}
+			return msg.ID < to
+		})
+		if err == nil {
+			return result
+		}
+		log.Printf("⚠️  读取任务 %s 持久化日志失败: %v", jobID, err)
+	}
+	return memory
+}
+
+// Finish 通知订阅者任务已结束并关闭订阅，然后归档日志
+// 返回日志存储位置（未配置存储时为空）
+func (b *Broker) Finish(jobID, status string) (string, error) {
+	b.mu.Lock()
+	for _, sub := range b.subscribers[jobID] {
+		sub.finishLocked(status)
+	}
+	delete(b.subscribers, jobID)
+	b.mu.Unlock()
+
+	return b.Seal(jobID)
+}
+
+// removeLocked 移除订阅并关闭channel
+func (b *Broker) removeLocked(sub *Subscription) {
+	subs := b.subscribers[sub.jobID]
+	for i, s := range subs {
+		if s == sub {
+			b.subscribers[sub.jobID] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(b.subscribers[sub.jobID]) == 0 {
+		delete(b.subscribers, sub.jobID)
+	}
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,197 @@
+package logindex
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	queueSize     = 4096
+	batchSize     = 500
+	flushInterval = 500 * time.Millisecond
+)
+
+// ftsSchema SQLite FTS4 外部内容表，正文取自 log_entries，由触发器同步
+var ftsSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS log_entries_fts USING fts4(content="log_entries", message)`,
+	`CREATE TRIGGER IF NOT EXISTS log_entries_ai AFTER INSERT ON log_entries BEGIN
+		INSERT INTO log_entries_fts(docid, message) VALUES (new.id, new.message);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS log_entries_bd BEFORE DELETE ON log_entries BEGIN
+		DELETE FROM log_entries_fts WHERE docid = old.id;
+	END`,
+}
+
+// Index 任务日志检索索引
+//
+// 日志按 level/component/时间 建普通索引，正文使用 SQLite FTS4 全文索引
+// （SQLite未编译FTS时退化为LIKE）。写入在后台批量提交，检索结果可能有亚秒级延迟。
+type Index struct {
+	db    *gorm.DB
+	fts   bool
+	queue chan models.LogEntry
+
+	mu      sync.Mutex // 串行化批量写入
+	flushCh chan chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// New 创建日志索引并初始化表结构
+func New(db *gorm.DB) (*Index, error) {
+	if err := db.AutoMigrate(&models.LogEntry{}); err != nil {
+		return nil, err
+	}
+
+	ix := &Index{
+		db:      db,
+		fts:     true,
+		queue:   make(chan models.LogEntry, queueSize),
+		flushCh: make(chan chan struct{}),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	for _, stmt := range ftsSchema {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("⚠️  SQLite不支持FTS4，日志全文检索退化为LIKE: %v", err)
+			ix.fts = false
+			break
+		}
+	}
+	return ix, nil
+}
+
+// Start 启动后台批量写入
+func (ix *Index) Start() {
+	go ix.run()
+}
+
+// Stop 写入剩余日志并停止
+func (ix *Index) Stop() {
+	close(ix.stopCh)
+	<-ix.doneCh
+}
+
+// Add 将日志加入索引队列，队列满时同步写入
+func (ix *Index) Add(jobID string, msg logbroker.LogMessage) {
+	entry := models.LogEntry{
+		JobID:     jobID,
+		LogID:     msg.ID,
+		Timestamp: msg.Timestamp.UTC(),
+		Level:     strings.ToUpper(msg.Level),
+		Component: msg.Component,
+		Message:   msg.Message,
+	}
+	select {
+	case ix.queue <- entry:
+	default:
+		ix.write([]models.LogEntry{entry})
+	}
+}
+
+// Flush 等待队列中的日志写入完成
+func (ix *Index) Flush() {
+	done := make(chan struct{})
+	select {
+	case ix.flushCh <- done:
+		<-done
+	case <-ix.doneCh:
+	}
+}
+
+// Prune 删除早于 before 的索引条目
+func (ix *Index) Prune(before time.Time) (int64, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	result := ix.db.Where("timestamp < ?", before.UTC()).Delete(&models.LogEntry{})
+	return result.RowsAffected, result.Error
+}
+
+// run 后台批量写入循环
+func (ix *Index) run() {
+	defer close(ix.doneCh)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.LogEntry, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			ix.write(batch)
+			batch = batch[:0]
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case entry := <-ix.queue:
+				batch = append(batch, entry)
+				if len(batch) >= batchSize {
+					flush()
+				}
+			default:
+				flush()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case entry := <-ix.queue:
+			batch = append(batch, entry)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-ix.flushCh:
+			drain()
+			close(done)
+		case <-ix.stopCh:
+			drain()
+			return
+		}
+	}
+}
+
+// write 在一个事务中写入一批条目
+func (ix *Index) write(entries []models.LogEntry) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.db.CreateInBatches(entries, 100).Error; err != nil {
+		log.Printf("⚠️  写入日志索引失败 (%d 条): %v", len(entries), err)
+	}
+}
+
+// matchExpr 将检索文本转换为FTS4短语查询，无法使用全文索引时返回空串
+// FTS4默认分词器只按ASCII字母数字切词，含非ASCII字符（如中文）的文本改用LIKE
+func (ix *Index) matchExpr(text string) string {
+	if !ix.fts {
+		return ""
+	}
+	var words []string
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		for _, r := range w {
+			if r > unicode.MaxASCII {
+				return ""
+			}
+		}
+		words = append(words, w)
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	return `"` + strings.Join(words, " ") + `"`
+}
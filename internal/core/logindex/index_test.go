@@ -0,0 +1,196 @@
+package logindex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupIndex(t *testing.T) *Index {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := New(db)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ix.Start()
+	t.Cleanup(ix.Stop)
+	return ix
+}
+
+func TestIndex_Search(t *testing.T) {
+	ix := setupIndex(t)
+	if !ix.fts {
+		t.Log("SQLite built without FTS4, testing LIKE fallback")
+	}
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	logs := []struct {
+		jobID string
+		msg   logbroker.LogMessage
+	}{
+		{"job-1", logbroker.LogMessage{ID: 1, Timestamp: base, Level: "info", Component: "raid", Message: "Creating VD 0 on controller 0"}},
+		{"job-1", logbroker.LogMessage{ID: 2, Timestamp: base.Add(time.Minute), Level: "ERROR", Component: "raid", Message: "PD 0:1 offline, rebuild required"}},
+		{"job-2", logbroker.LogMessage{ID: 1, Timestamp: base.Add(time.Hour), Level: "ERROR", Component: "RAID", Message: "PD 0:3 offline"}},
+		{"job-2", logbroker.LogMessage{ID: 2, Timestamp: base.Add(time.Hour), Level: "WARN", Component: "agent", Message: "磁盘温度过高 100%_hot"}},
+	}
+	for _, l := range logs {
+		ix.Add(l.jobID, l.msg)
+	}
+	ix.Flush()
+
+	tests := []struct {
+		name      string
+		query     Query
+		wantTotal int64
+	}{
+		{name: "All", query: Query{}, wantTotal: 4},
+		{name: "Job", query: Query{JobID: "job-1"}, wantTotal: 2},
+		{name: "Level case-insensitive", query: Query{Levels: []string{"error"}}, wantTotal: 2},
+		{name: "Multiple levels", query: Query{Levels: []string{"ERROR", "WARN"}}, wantTotal: 3},
+		{name: "Component case-insensitive", query: Query{Component: "raid"}, wantTotal: 3},
+		{name: "Phrase", query: Query{Text: "pd 0:1 OFFLINE"}, wantTotal: 1},
+		{name: "Word", query: Query{Text: "offline"}, wantTotal: 2},
+		{name: "Non-ASCII text", query: Query{Text: "温度"}, wantTotal: 1},
+		{name: "LIKE wildcards escaped", query: Query{Text: "温度过高 100%_"}, wantTotal: 1},
+		{name: "Since", query: Query{Since: base.Add(30 * time.Minute)}, wantTotal: 2},
+		{name: "Until", query: Query{Until: base.Add(time.Minute)}, wantTotal: 2},
+		{name: "Combined", query: Query{Levels: []string{"ERROR"}, Text: "offline", Since: base.Add(30 * time.Minute)}, wantTotal: 1},
+		{name: "No match", query: Query{Text: "kernel panic"}, wantTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, total, err := ix.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if total != tt.wantTotal || int64(len(entries)) != tt.wantTotal {
+				t.Errorf("Search() = %d entries, total %d, want %d", len(entries), total, tt.wantTotal)
+			}
+			for i := range entries {
+				msg := logbroker.LogMessage{Timestamp: entries[i].Timestamp, Level: entries[i].Level, Component: entries[i].Component, Message: entries[i].Message}
+				if !tt.query.Match(&msg) {
+					t.Errorf("Search() returned %+v, which Match() rejects", entries[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIndex_SearchJobs(t *testing.T) {
+	ix := setupIndex(t)
+	ix.db.Create(&models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeConfigRAID, Status: models.JobStatusFailed})
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ix.Add("job-1", logbroker.LogMessage{ID: int64(i + 1), Timestamp: base.Add(time.Duration(i) * time.Minute), Level: "ERROR", Message: "PD offline"})
+	}
+	ix.Add("job-deleted", logbroker.LogMessage{ID: 1, Timestamp: base.Add(time.Hour), Level: "ERROR", Message: "PD offline"})
+	ix.Flush()
+
+	matches, err := ix.SearchJobs(Query{Text: "offline"})
+	if err != nil {
+		t.Fatalf("SearchJobs() error = %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("SearchJobs() = %d jobs, want 2", len(matches))
+	}
+
+	// 按最近命中时间倒序
+	if matches[0].JobID != "job-deleted" || matches[0].Job != nil {
+		t.Errorf("matches[0] = %+v, want job-deleted without job", matches[0])
+	}
+	m := matches[1]
+	if m.JobID != "job-1" || m.Matches != 3 || m.Job == nil {
+		t.Fatalf("matches[1] = %+v, want job-1 with 3 matches", m)
+	}
+	if !m.FirstMatch.Equal(base) || !m.LastMatch.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("match range = %v..%v, want %v..%v", m.FirstMatch, m.LastMatch, base, base.Add(2*time.Minute))
+	}
+	if m.Sample == nil || m.Sample.LogID != 1 {
+		t.Errorf("Sample = %+v, want first match", m.Sample)
+	}
+}
+
+func TestIndex_SearchJobs_Scope(t *testing.T) {
+	ix := setupIndex(t)
+	ix.db.Create(&models.Job{ID: "job-a", MachineID: "machine-1", Type: models.JobTypeConfigRAID, Status: models.JobStatusFailed, TenantID: "tenant-a"})
+	ix.db.Create(&models.Job{ID: "job-b", MachineID: "machine-2", Type: models.JobTypeConfigRAID, Status: models.JobStatusFailed, TenantID: "tenant-b"})
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// 其他租户的命中时间更近，排在前面也不能挤掉本租户的结果
+	ix.Add("job-a", logbroker.LogMessage{ID: 1, Timestamp: base, Level: "ERROR", Message: "PD offline"})
+	ix.Add("job-b", logbroker.LogMessage{ID: 1, Timestamp: base.Add(time.Minute), Level: "ERROR", Message: "PD offline"})
+	ix.Add("job-deleted", logbroker.LogMessage{ID: 1, Timestamp: base.Add(time.Hour), Level: "ERROR", Message: "PD offline"})
+	ix.Flush()
+
+	tests := []struct {
+		name  string
+		scope *tenant.Scope
+		want  []string
+	}{
+		{"No scope", nil, []string{"job-deleted", "job-b", "job-a"}},
+		{"Unrestricted", &tenant.Unrestricted, []string{"job-deleted", "job-b", "job-a"}},
+		{"Tenant A", &tenant.Scope{Enabled: true, TenantID: "tenant-a"}, []string{"job-a"}},
+		{"Tenant B", &tenant.Scope{Enabled: true, TenantID: "tenant-b"}, []string{"job-b"}},
+		{"Unknown tenant", &tenant.Scope{Enabled: true, TenantID: "tenant-c"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := ix.SearchJobs(Query{Text: "offline", Limit: 1, Scope: tt.scope})
+			if err != nil {
+				t.Fatalf("SearchJobs() error = %v", err)
+			}
+			var want []string
+			if len(tt.want) > 0 {
+				want = tt.want[:1]
+			}
+			if len(matches) != len(want) || (len(want) > 0 && matches[0].JobID != want[0]) {
+				t.Errorf("SearchJobs(limit 1) = %+v, want %v", matches, want)
+			}
+
+			entries, total, err := ix.Search(Query{Text: "offline", Scope: tt.scope})
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if total != int64(len(tt.want)) || len(entries) != len(tt.want) {
+				t.Errorf("Search() = %d entries (total %d), want %d", len(entries), total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestIndex_Prune(t *testing.T) {
+	ix := setupIndex(t)
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ix.Add("job-old", logbroker.LogMessage{ID: 1, Timestamp: base, Level: "INFO", Message: "old line"})
+	ix.Add("job-new", logbroker.LogMessage{ID: 1, Timestamp: base.Add(48 * time.Hour), Level: "INFO", Message: "new line"})
+	ix.Flush()
+
+	n, err := ix.Prune(base.Add(24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Prune() = %d, want 1", n)
+	}
+
+	// 全文索引同步删除
+	if entries, _, _ := ix.Search(Query{Text: "line"}); len(entries) != 1 || entries[0].JobID != "job-new" {
+		t.Errorf("Search() after prune = %+v, want only job-new", entries)
+	}
+}
@@ -0,0 +1,191 @@
+package logindex
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/core/tenant"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+// DefaultLimit 检索默认返回条数
+const DefaultLimit = 100
+
+// MaxLimit 检索最多返回条数
+const MaxLimit = 1000
+
+// Query 日志检索条件，零值字段不参与过滤
+type Query struct {
+	JobID     string
+	Levels    []string // 任一匹配（不区分大小写）
+	Component string   // 不区分大小写
+	Since     time.Time
+	Until     time.Time
+	Text      string // 正文包含（不区分大小写）
+	Limit     int
+	Offset    int
+
+	// Scope 非nil时只检索租户范围内任务的日志（已删除的任务没有归属，仅不限租户时返回）
+	Scope *tenant.Scope
+}
+
+// Match 检查单条日志是否满足条件（用于直接过滤日志存储）
+func (q *Query) Match(msg *logbroker.LogMessage) bool {
+	if len(q.Levels) > 0 {
+		ok := false
+		for _, level := range q.Levels {
+			if strings.EqualFold(level, msg.Level) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if q.Component != "" && !strings.EqualFold(q.Component, msg.Component) {
+		return false
+	}
+	if !q.Since.IsZero() && msg.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && msg.Timestamp.After(q.Until) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(msg.Message), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// JobMatch 全局检索中一个任务的命中情况
+type JobMatch struct {
+	JobID      string           `json:"job_id"`
+	Matches    int64            `json:"matches"`
+	FirstMatch time.Time        `json:"first_match"`
+	LastMatch  time.Time        `json:"last_match"`
+	Sample     *models.LogEntry `json:"sample,omitempty"` // 第一条命中的日志
+	Job        *models.Job      `json:"job,omitempty"`
+}
+
+// Search 检索日志条目，返回当前页和总命中数
+func (ix *Index) Search(q Query) ([]models.LogEntry, int64, error) {
+	var total int64
+	if err := ix.where(q).Model(&models.LogEntry{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.LogEntry
+	err := ix.where(q).Order("timestamp, job_id, log_id").
+		Limit(limit(q.Limit)).Offset(q.Offset).Find(&entries).Error
+	return entries, total, err
+}
+
+// SearchJobs 跨任务检索，按最近命中时间倒序返回命中的任务
+func (ix *Index) SearchJobs(q Query) ([]JobMatch, error) {
+	var rows []struct {
+		JobID      string
+		Matches    int64
+		FirstMatch string
+		LastMatch  string
+	}
+	err := ix.where(q).Model(&models.LogEntry{}).
+		Select("job_id, COUNT(*) AS matches, MIN(timestamp) AS first_match, MAX(timestamp) AS last_match").
+		Group("job_id").Order("last_match DESC").
+		Limit(limit(q.Limit)).Offset(q.Offset).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]JobMatch, 0, len(rows))
+	jobIDs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		m := JobMatch{JobID: row.JobID, Matches: row.Matches}
+		m.FirstMatch, _ = parseTimestamp(row.FirstMatch)
+		m.LastMatch, _ = parseTimestamp(row.LastMatch)
+
+		sq := q
+		sq.JobID = row.JobID
+		var sample models.LogEntry
+		if err := ix.where(sq).Order("timestamp, log_id").First(&sample).Error; err == nil {
+			m.Sample = &sample
+		}
+
+		matches = append(matches, m)
+		jobIDs = append(jobIDs, row.JobID)
+	}
+
+	// 附带任务信息（已删除的任务没有）
+	var jobs []models.Job
+	if len(jobIDs) > 0 {
+		if err := ix.db.Where("id IN ?", jobIDs).Find(&jobs).Error; err != nil {
+			return nil, err
+		}
+	}
+	for i := range jobs {
+		for j := range matches {
+			if matches[j].JobID == jobs[i].ID {
+				matches[j].Job = &jobs[i]
+			}
+		}
+	}
+	return matches, nil
+}
+
+// where 构建检索条件
+func (ix *Index) where(q Query) *gorm.DB {
+	tx := ix.db.Model(&models.LogEntry{})
+	if q.JobID != "" {
+		tx = tx.Where("job_id = ?", q.JobID)
+	}
+	if q.Scope != nil && !q.Scope.All {
+		tx = tx.Where("job_id IN (?)", q.Scope.Apply(ix.db.Model(&models.Job{}).Select("id")))
+	}
+	if len(q.Levels) > 0 {
+		levels := make([]string, len(q.Levels))
+		for i, level := range q.Levels {
+			levels[i] = strings.ToUpper(level)
+		}
+		tx = tx.Where("level IN ?", levels)
+	}
+	if q.Component != "" {
+		tx = tx.Where("component = ? COLLATE NOCASE", q.Component)
+	}
+	if !q.Since.IsZero() {
+		tx = tx.Where("timestamp >= ?", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		tx = tx.Where("timestamp <= ?", q.Until.UTC())
+	}
+	if q.Text != "" {
+		if expr := ix.matchExpr(q.Text); expr != "" {
+			tx = tx.Where("id IN (SELECT docid FROM log_entries_fts WHERE log_entries_fts MATCH ?)", expr)
+		} else {
+			tx = tx.Where(`message LIKE ? ESCAPE '\'`, "%"+escapeLike(q.Text)+"%")
+		}
+	}
+	return tx
+}
+
+func limit(n int) int {
+	if n <= 0 {
+		return DefaultLimit
+	}
+	return min(n, MaxLimit)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// parseTimestamp 解析聚合查询返回的时间（SQLite以文本存储）
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Parse("2006-01-02 15:04:05", s)
+}
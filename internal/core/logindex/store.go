@@ -0,0 +1,39 @@
+package logindex
+
+import (
+	"log"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+)
+
+// indexedStore 写入日志存储的同时更新检索索引
+type indexedStore struct {
+	logbroker.Store
+	index *Index
+}
+
+// Wrap 为日志存储增加检索索引，保留策略同时清理索引
+func Wrap(store logbroker.Store, index *Index) logbroker.Store {
+	return &indexedStore{Store: store, index: index}
+}
+
+// Append 追加日志并加入索引
+func (s *indexedStore) Append(jobID string, msgs ...logbroker.LogMessage) error {
+	if err := s.Store.Append(jobID, msgs...); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		s.index.Add(jobID, msg)
+	}
+	return nil
+}
+
+// Prune 删除过期日志及其索引
+func (s *indexedStore) Prune(before time.Time) (int, error) {
+	n, err := s.Store.Prune(before)
+	if _, ierr := s.index.Prune(before); ierr != nil {
+		log.Printf("⚠️  清理日志索引失败: %v", ierr)
+	}
+	return n, err
+}
@@ -0,0 +1,190 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrQuotaExceeded 租户配额不足
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+	// ErrNotFound 租户不存在
+	ErrNotFound = errors.New("tenant not found")
+)
+
+// 配额资源
+const (
+	ResourceMachines   = "machines"
+	ResourceProfiles   = "profiles"
+	ResourceProviders  = "providers"
+	ResourceActiveJobs = "active_jobs"
+)
+
+// Scope 当前请求可访问的租户范围
+//
+// 未启用多租户或调用者可跨租户访问时 All 为 true，不做任何过滤；
+// 否则只能访问 TenantID 的资源（空串表示未分配租户的资源）。
+type Scope struct {
+	Enabled  bool   // 多租户功能已启用
+	All      bool   // 不限租户
+	TenantID string // All 为 false 时的租户
+}
+
+// Unrestricted 不限租户的范围（未启用多租户、系统内部调用）
+var Unrestricted = Scope{All: true}
+
+// Apply 按租户范围过滤查询（表需要有 tenant_id 列）
+func (s Scope) Apply(db *gorm.DB) *gorm.DB {
+	if s.All {
+		return db
+	}
+	return db.Where("tenant_id = ?", s.TenantID)
+}
+
+// Allows 范围内是否可以访问属于 tenantID 的资源
+func (s Scope) Allows(tenantID string) bool {
+	return s.All || s.TenantID == tenantID
+}
+
+// Assign 新建资源归属的租户（不限租户时为未分配）
+func (s Scope) Assign() string {
+	if s.All {
+		return ""
+	}
+	return s.TenantID
+}
+
+// Get 查询租户
+func Get(db *gorm.DB, id string) (*models.Tenant, error) {
+	var t models.Tenant
+	if err := db.Where("id = ?", id).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Usage 统计租户当前的资源用量
+func Usage(db *gorm.DB, tenantID string) (models.Quota, error) {
+	var usage models.Quota
+	for resource, dst := range map[string]*int{
+		ResourceMachines:   &usage.Machines,
+		ResourceProfiles:   &usage.Profiles,
+		ResourceProviders:  &usage.Providers,
+		ResourceActiveJobs: &usage.ActiveJobs,
+	} {
+		n, err := count(db, tenantID, resource)
+		if err != nil {
+			return usage, err
+		}
+		*dst = int(n)
+	}
+	return usage, nil
+}
+
+// CheckQuota 检查租户是否还能新增 n 个资源（未分配租户的资源不受配额限制）
+func CheckQuota(db *gorm.DB, tenantID, resource string, n int) error {
+	if tenantID == "" {
+		return nil
+	}
+	t, err := Get(db, tenantID)
+	if err != nil {
+		return err
+	}
+
+	var limit int
+	switch resource {
+	case ResourceMachines:
+		limit = t.Quota.Machines
+	case ResourceProfiles:
+		limit = t.Quota.Profiles
+	case ResourceProviders:
+		limit = t.Quota.Providers
+	case ResourceActiveJobs:
+		limit = t.Quota.ActiveJobs
+	default:
+		return fmt.Errorf("unknown quota resource: %s", resource)
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	used, err := count(db, tenantID, resource)
+	if err != nil {
+		return err
+	}
+	if int(used)+n > limit {
+		return fmt.Errorf("%w: %s (%d/%d)", ErrQuotaExceeded, resource, used, limit)
+	}
+	return nil
+}
+
+// count 统计租户的某类资源数量
+func count(db *gorm.DB, tenantID, resource string) (int64, error) {
+	var query *gorm.DB
+	switch resource {
+	case ResourceMachines:
+		query = db.Model(&models.Machine{})
+	case ResourceProfiles:
+		query = db.Model(&models.OSProfile{})
+	case ResourceProviders:
+		query = db.Model(&models.ProviderTenant{})
+	case ResourceActiveJobs:
+		query = db.Model(&models.Job{}).Where("status IN ?", []models.JobStatus{
+			models.JobStatusPending,
+			models.JobStatusRunning,
+		})
+	default:
+		return 0, fmt.Errorf("unknown quota resource: %s", resource)
+	}
+
+	var n int64
+	err := query.Where("tenant_id = ?", tenantID).Count(&n).Error
+	return n, err
+}
+
+// ProviderOwners 返回Provider归属的租户（不在结果中的Provider为共享）
+func ProviderOwners(db *gorm.DB) (map[string]string, error) {
+	var rows []models.ProviderTenant
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string, len(rows))
+	for _, row := range rows {
+		owners[row.ProviderID] = row.TenantID
+	}
+	return owners, nil
+}
+
+// ProviderOwner 返回Provider归属的租户，共享Provider返回空串
+func ProviderOwner(db *gorm.DB, providerID string) (string, error) {
+	var rows []models.ProviderTenant
+	if err := db.Where("provider_id = ?", providerID).Limit(1).Find(&rows).Error; err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+	return rows[0].TenantID, nil
+}
+
+// SetProviderOwner 设置Provider归属的租户，tenantID 为空时设为共享
+func SetProviderOwner(db *gorm.DB, providerID, tenantID string) error {
+	if err := db.Where("provider_id = ?", providerID).Delete(&models.ProviderTenant{}).Error; err != nil {
+		return err
+	}
+	if tenantID == "" {
+		return nil
+	}
+	return db.Create(&models.ProviderTenant{ProviderID: providerID, TenantID: tenantID}).Error
+}
+
+// ProviderVisible 共享Provider对所有租户可见，租户私有Provider只对本租户可见
+func (s Scope) ProviderVisible(owner string) bool {
+	return owner == "" || s.Allows(owner)
+}
@@ -0,0 +1,127 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTenantDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Tenant{}, &models.ProviderTenant{},
+		&models.Machine{}, &models.OSProfile{}, &models.Job{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return db
+}
+
+func TestScope(t *testing.T) {
+	db := setupTenantDB(t)
+	db.Create(&models.Machine{ID: "m1", Hostname: "a1", MacAddress: "aa:00:00:00:00:01", TenantID: "a"})
+	db.Create(&models.Machine{ID: "m2", Hostname: "b1", MacAddress: "aa:00:00:00:00:02", TenantID: "b"})
+	db.Create(&models.Machine{ID: "m3", Hostname: "u1", MacAddress: "aa:00:00:00:00:03"})
+
+	tests := []struct {
+		name       string
+		scope      Scope
+		wantCount  int64
+		wantAssign string
+		allowsB    bool
+		sharedProv bool
+	}{
+		{"unrestricted", Unrestricted, 3, "", true, true},
+		{"tenant a", Scope{Enabled: true, TenantID: "a"}, 1, "a", false, true},
+		{"unassigned", Scope{Enabled: true}, 1, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var count int64
+			tt.scope.Apply(db.Model(&models.Machine{})).Count(&count)
+			if count != tt.wantCount {
+				t.Errorf("Apply() count = %d, want %d", count, tt.wantCount)
+			}
+			if got := tt.scope.Assign(); got != tt.wantAssign {
+				t.Errorf("Assign() = %q, want %q", got, tt.wantAssign)
+			}
+			if got := tt.scope.Allows("b"); got != tt.allowsB {
+				t.Errorf("Allows(b) = %v, want %v", got, tt.allowsB)
+			}
+			if got := tt.scope.ProviderVisible(""); got != tt.sharedProv {
+				t.Errorf("ProviderVisible(shared) = %v, want %v", got, tt.sharedProv)
+			}
+		})
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	db := setupTenantDB(t)
+	db.Create(&models.Tenant{ID: "a", Name: "A", Quota: models.Quota{Machines: 2, ActiveJobs: 1}})
+	db.Create(&models.Machine{ID: "m1", Hostname: "a1", MacAddress: "aa:00:00:00:00:01", TenantID: "a"})
+	db.Create(&models.Job{ID: "j1", MachineID: "m1", TenantID: "a", Status: models.JobStatusSuccess})
+	db.Create(&models.Job{ID: "j2", MachineID: "m1", TenantID: "a", Status: models.JobStatusRunning})
+	db.Create(&models.ProviderTenant{ProviderID: "p1", TenantID: "a"})
+
+	tests := []struct {
+		name     string
+		tenantID string
+		resource string
+		n        int
+		wantErr  error
+	}{
+		{"within quota", "a", ResourceMachines, 1, nil},
+		{"over quota", "a", ResourceMachines, 2, ErrQuotaExceeded},
+		{"finished jobs not counted", "a", ResourceActiveJobs, 0, nil},
+		{"active jobs full", "a", ResourceActiveJobs, 1, ErrQuotaExceeded},
+		{"unlimited", "a", ResourceProfiles, 100, nil},
+		{"unassigned", "", ResourceMachines, 100, nil},
+		{"unknown tenant", "missing", ResourceMachines, 1, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckQuota(db, tt.tenantID, tt.resource, tt.n)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckQuota() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	usage, err := Usage(db, "a")
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+	if usage != (models.Quota{Machines: 1, Providers: 1, ActiveJobs: 1}) {
+		t.Errorf("Usage() = %+v", usage)
+	}
+}
+
+func TestProviderOwner(t *testing.T) {
+	db := setupTenantDB(t)
+
+	if err := SetProviderOwner(db, "p1", "a"); err != nil {
+		t.Fatalf("SetProviderOwner() error = %v", err)
+	}
+	if owner, err := ProviderOwner(db, "p1"); err != nil || owner != "a" {
+		t.Errorf("ProviderOwner(p1) = %q, %v", owner, err)
+	}
+	if owner, err := ProviderOwner(db, "p2"); err != nil || owner != "" {
+		t.Errorf("ProviderOwner(p2) = %q, %v, want shared", owner, err)
+	}
+
+	// 设为共享后不再有归属记录
+	if err := SetProviderOwner(db, "p1", ""); err != nil {
+		t.Fatalf("SetProviderOwner() error = %v", err)
+	}
+	owners, err := ProviderOwners(db)
+	if err != nil || len(owners) != 0 {
+		t.Errorf("ProviderOwners() = %v, %v", owners, err)
+	}
+}
@@ -0,0 +1,366 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/lifecycle"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrNoRunningStep 任务当前没有执行中的步骤
+var ErrNoRunningStep = errors.New("no running step")
+
+// Create 创建工作流任务及其步骤
+func Create(db *gorm.DB, job *models.Job, p Pipeline) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	steps := p.Instantiate(job)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return fmt.Errorf("failed to create job: %w", err)
+		}
+		if err := tx.Create(&steps).Error; err != nil {
+			return fmt.Errorf("failed to create job steps: %w", err)
+		}
+		job.Steps = steps
+		return nil
+	})
+}
+
+// Steps 按序号查询任务的步骤
+func Steps(db *gorm.DB, jobID string) ([]models.JobStep, error) {
+	var steps []models.JobStep
+	err := db.Where("job_id = ?", jobID).Order("seq").Find(&steps).Error
+	return steps, err
+}
+
+// NextStep 取出下一个可执行的步骤并标记为运行中
+// 已有步骤在运行或没有可执行步骤时返回 nil
+func NextStep(db *gorm.DB, job *models.Job) (*models.JobStep, error) {
+	if job.IsTerminal() {
+		return nil, nil
+	}
+
+	var next *models.JobStep
+	err := db.Transaction(func(tx *gorm.DB) error {
+		steps, err := Steps(tx, job.ID)
+		if err != nil {
+			return err
+		}
+		if err := resolve(tx, job, steps); err != nil {
+			return err
+		}
+
+		byName := indexSteps(steps)
+		for i := range steps {
+			step := &steps[i]
+			if step.Status == models.StepStatusRunning {
+				return nil
+			}
+			if next == nil && step.Status == models.StepStatusPending && depsDone(step, byName) {
+				next = step
+			}
+		}
+		if next == nil {
+			return nil
+		}
+
+		now := time.Now()
+		next.Status = models.StepStatusRunning
+		next.Attempts++
+		next.StartedAt = &now
+		next.FinishedAt = nil
+		if err := tx.Save(next).Error; err != nil {
+			return err
+		}
+		return syncJob(tx, job, steps)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// RunningStep 查询任务当前执行中的步骤
+func RunningStep(db *gorm.DB, jobID string) (*models.JobStep, error) {
+	var step models.JobStep
+	if err := db.Where("job_id = ? AND status = ?", jobID, models.StepStatusRunning).First(&step).Error; err != nil {
+		return nil, ErrNoRunningStep
+	}
+	return &step, nil
+}
+
+// CompleteStep 记录步骤执行结果
+// 失败且未用尽重试次数时步骤回到 pending 等待重新下发
+func CompleteStep(db *gorm.DB, job *models.Job, stepID string, success bool, errMsg string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		steps, err := Steps(tx, job.ID)
+		if err != nil {
+			return err
+		}
+
+		var step *models.JobStep
+		for i := range steps {
+			if steps[i].ID == stepID {
+				step = &steps[i]
+			}
+		}
+		if step == nil || step.Status != models.StepStatusRunning {
+			return ErrNoRunningStep
+		}
+
+		finish(step, success, errMsg)
+		if err := tx.Save(step).Error; err != nil {
+			return err
+		}
+		if err := resolve(tx, job, steps); err != nil {
+			return err
+		}
+		return syncJob(tx, job, steps)
+	})
+}
+
+// RecordStepResult 保存Agent上报的步骤结构化结果
+func RecordStepResult(db *gorm.DB, stepID string, result map[string]interface{}) error {
+	if result == nil {
+		return nil
+	}
+	return db.Model(&models.JobStep{ID: stepID}).Select("result").
+		Updates(&models.JobStep{Result: result}).Error
+}
+
+// Cancel 取消任务中尚未结束的步骤
+func Cancel(db *gorm.DB, jobID string) error {
+	now := time.Now()
+	return db.Model(&models.JobStep{}).
+		Where("job_id = ? AND status IN ?", jobID, []models.StepStatus{models.StepStatusPending, models.StepStatusRunning}).
+		Updates(map[string]interface{}{"status": models.StepStatusSkipped, "error": "job cancelled", "finished_at": now}).Error
+}
+
+// ExpireTimedOut 将超时的运行中步骤按失败处理，返回处理数量
+// 任务因此结束时交给 finisher 驱动机器状态并归档日志
+func ExpireTimedOut(db *gorm.DB, now time.Time, finisher *Finisher) (int, error) {
+	var running []models.JobStep
+	if err := db.Where("status = ? AND timeout_seconds > 0", models.StepStatusRunning).Find(&running).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, step := range running {
+		if !step.TimedOut(now) {
+			continue
+		}
+
+		var job models.Job
+		if err := db.Where("id = ?", step.JobID).First(&job).Error; err != nil {
+			continue
+		}
+		msg := fmt.Sprintf("step %s timed out after %ds", step.Name, step.TimeoutSeconds)
+		if err := CompleteStep(db, &job, step.ID, false, msg); err != nil {
+			return expired, err
+		}
+		expired++
+
+		finisher.Finish(db, &job, lifecycle.ActorSystem)
+	}
+	return expired, nil
+}
+
+// finish 结束一次步骤执行
+func finish(step *models.JobStep, success bool, errMsg string) {
+	now := time.Now()
+	if success {
+		step.Status = models.StepStatusSuccess
+		step.Error = ""
+		step.FinishedAt = &now
+		return
+	}
+
+	step.Error = errMsg
+	if step.Attempts <= step.MaxRetries {
+		// 还有重试机会
+		step.Status = models.StepStatusPending
+		step.StartedAt = nil
+		return
+	}
+	step.Status = models.StepStatusFailed
+	step.FinishedAt = &now
+}
+
+// resolve 推进不可执行的步骤直到稳定：
+// 上游失败或被取消的步骤跳过；依赖完成但条件不满足的步骤跳过
+func resolve(tx *gorm.DB, job *models.Job, steps []models.JobStep) error {
+	byName := indexSteps(steps)
+
+	var machine *models.Machine
+	var m models.Machine
+	if err := tx.Where("id = ?", job.MachineID).First(&m).Error; err == nil {
+		machine = &m
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := range steps {
+			step := &steps[i]
+			if step.Status != models.StepStatusPending {
+				continue
+			}
+
+			if dep := failedDep(step, byName); dep != "" {
+				skip(step, fmt.Sprintf("upstream step %s failed", dep))
+			} else if depsDone(step, byName) {
+				ok, err := conditionsMet(step, job, machine)
+				if err != nil {
+					return fmt.Errorf("step %s: %w", step.Name, err)
+				}
+				if ok {
+					continue
+				}
+				skip(step, "conditions not met")
+			} else {
+				continue
+			}
+
+			if err := tx.Save(step).Error; err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+	return nil
+}
+
+// syncJob 根据步骤状态汇总任务状态
+func syncJob(tx *gorm.DB, job *models.Job, steps []models.JobStep) error {
+	allDone, started := true, false
+	var failed *models.JobStep
+	current := ""
+	for i := range steps {
+		step := &steps[i]
+		if !step.IsDone() {
+			allDone = false
+		}
+		if step.Attempts > 0 {
+			started = true
+		}
+		if step.Status == models.StepStatusFailed && failed == nil {
+			failed = step
+		}
+		if step.Status == models.StepStatusRunning {
+			current = step.Name
+		}
+	}
+
+	switch {
+	case failed != nil:
+		job.SetError(fmt.Errorf("step %s failed: %s", failed.Name, failed.Error))
+		current = failed.Name
+	case allDone:
+		job.SetSuccess()
+		current = "completed"
+	case started:
+		job.Status = models.JobStatusRunning
+	}
+	if current != "" {
+		job.UpdateStep(current)
+	}
+	job.UpdatedAt = time.Now()
+
+	return tx.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":       job.Status,
+		"step_current": job.StepCurrent,
+		"error":        job.Error,
+		"updated_at":   job.UpdatedAt,
+	}).Error
+}
+
+func indexSteps(steps []models.JobStep) map[string]*models.JobStep {
+	byName := make(map[string]*models.JobStep, len(steps))
+	for i := range steps {
+		byName[steps[i].Name] = &steps[i]
+	}
+	return byName
+}
+
+// depsDone 依赖全部成功或跳过
+func depsDone(step *models.JobStep, byName map[string]*models.JobStep) bool {
+	for _, dep := range step.DependsOn {
+		d, ok := byName[dep]
+		if !ok || (d.Status != models.StepStatusSuccess && d.Status != models.StepStatusSkipped) {
+			return false
+		}
+	}
+	return true
+}
+
+// failedDep 返回失败的依赖名；因上游失败被跳过的依赖同样视为失败
+func failedDep(step *models.JobStep, byName map[string]*models.JobStep) string {
+	for _, dep := range step.DependsOn {
+		d, ok := byName[dep]
+		if !ok {
+			continue
+		}
+		if d.Status == models.StepStatusFailed ||
+			(d.Status == models.StepStatusSkipped && d.Error != "" && d.Error != "conditions not met") {
+			return dep
+		}
+	}
+	return ""
+}
+
+func conditionsMet(step *models.JobStep, job *models.Job, machine *models.Machine) (bool, error) {
+	for _, cond := range step.Conditions {
+		ok, err := evalCondition(cond, job, machine)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func skip(step *models.JobStep, reason string) {
+	now := time.Now()
+	step.Status = models.StepStatusSkipped
+	step.Error = reason
+	step.FinishedAt = &now
+}
+
+// FindInstallJob 查找机器当前应由PXE安装器执行的任务：
+// 待执行/执行中的 install_os 任务，或 install_os 步骤正在执行的工作流任务
+func FindInstallJob(db *gorm.DB, machineID string) (*models.Job, error) {
+	active := []models.JobStatus{models.JobStatusPending, models.JobStatusRunning}
+
+	var job models.Job
+	err := db.Where("machine_id = ? AND type = ? AND status IN (?)", machineID, models.JobTypeInstallOS, active).
+		First(&job).Error
+	if err == nil {
+		return &job, nil
+	}
+
+	err = db.Where("machine_id = ? AND status IN (?) AND id IN (?)", machineID, active,
+		db.Model(&models.JobStep{}).Select("job_id").
+			Where("action = ? AND status = ?", models.StepActionInstallOS, models.StepStatusRunning)).
+		First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ActiveJob 查找机器上未结束的工作流任务（带步骤的任务）
+func ActiveJob(db *gorm.DB, machineID string) (*models.Job, error) {
+	var job models.Job
+	err := db.Where("machine_id = ? AND status IN (?) AND id IN (?)", machineID,
+		[]models.JobStatus{models.JobStatusPending, models.JobStatusRunning},
+		db.Model(&models.JobStep{}).Select("job_id")).
+		Order("created_at").First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
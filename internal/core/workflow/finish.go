@@ -0,0 +1,68 @@
+package workflow
+
+import (
+	"log"
+	"slices"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/lifecycle"
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/gorm"
+)
+
+// Finisher 任务结束钩子
+// Agent上报、用户取消、步骤超时等所有终态迁移都经过这里：
+// 驱动机器状态，通知日志订阅者任务结束，归档任务日志并记录存储位置
+type Finisher struct {
+	broker *logbroker.Broker
+}
+
+// NewFinisher 创建任务结束钩子（broker 为空时只驱动机器状态）
+func NewFinisher(broker *logbroker.Broker) *Finisher {
+	return &Finisher{broker: broker}
+}
+
+// Finish 任务结束时按结果驱动机器状态（迁移失败不影响任务结果）
+func (f *Finisher) Finish(db *gorm.DB, job *models.Job, actor string) {
+	if !job.IsTerminal() {
+		return
+	}
+	if err := lifecycle.OnJobFinished(db, job, actor); err != nil {
+		log.Printf("⚠️  任务 %s 结束后机器状态迁移失败: %v", job.ID, err)
+	}
+	f.closeLogs(db, job)
+}
+
+// Cancel 任务被取消时调用：安装中的机器回到 ready，而不是按失败进入 error
+func (f *Finisher) Cancel(db *gorm.DB, job *models.Job, actor string) {
+	if slices.Contains(models.InstallJobTypes, job.Type) {
+		var machine models.Machine
+		if err := db.Where("id = ?", job.MachineID).First(&machine).Error; err == nil &&
+			machine.Status == models.MachineStatusInstalling {
+			if err := lifecycle.Transition(db, &machine, models.MachineStatusReady, lifecycle.Cause{
+				Actor:  actor,
+				JobID:  job.ID,
+				Reason: "install job cancelled",
+			}); err != nil {
+				log.Printf("⚠️  机器 %s 状态迁移失败: %v", machine.ID, err)
+			}
+		}
+	}
+	f.closeLogs(db, job)
+}
+
+// closeLogs 通知日志订阅者任务结束，归档任务日志并记录存储位置
+func (f *Finisher) closeLogs(db *gorm.DB, job *models.Job) {
+	if f == nil || f.broker == nil {
+		return
+	}
+	logsPath, err := f.broker.Finish(job.ID, string(job.Status))
+	if err != nil {
+		log.Printf("⚠️  任务 %s 日志归档失败: %v", job.ID, err)
+		return
+	}
+	if logsPath != "" {
+		job.LogsPath = logsPath
+		db.Model(&models.Job{}).Where("id = ?", job.ID).Update("logs_path", logsPath)
+	}
+}
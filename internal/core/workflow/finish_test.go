@@ -0,0 +1,106 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/core/logbroker"
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+)
+
+func newTestBroker(t *testing.T) *logbroker.Broker {
+	store, err := logbroker.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	broker := logbroker.NewBroker()
+	broker.SetStore(store)
+	return broker
+}
+
+// waitFinished 读取订阅事件直到任务结束，返回最终状态
+func waitFinished(t *testing.T, sub *logbroker.Subscription) string {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatal("subscription closed without a finished event")
+			}
+			if ev.Type == logbroker.EventFinished {
+				return ev.Status
+			}
+		case <-timeout:
+			t.Fatal("no finished event delivered")
+		}
+	}
+}
+
+func TestExpireTimedOut_FinishesJob(t *testing.T) {
+	db := setupTestDB(t)
+	broker := newTestBroker(t)
+	job := createProvisionJob(t, db, nil)
+	broker.Publish(job.ID, logbroker.LogMessage{Level: "INFO", Message: "auditing"})
+	sub := broker.SubscribeFrom(job.ID, 0)
+	defer sub.Close()
+
+	// audit 允许重试2次，第3次超时后任务失败
+	finisher := NewFinisher(broker)
+	for i := 0; i < 3; i++ {
+		if step, err := NextStep(db, job); err != nil || step == nil {
+			t.Fatalf("NextStep() = %v, %v", step, err)
+		}
+		if _, err := ExpireTimedOut(db, time.Now().Add(11*time.Minute), finisher); err != nil {
+			t.Fatalf("ExpireTimedOut() error = %v", err)
+		}
+	}
+
+	if status := waitFinished(t, sub); status != string(models.JobStatusFailed) {
+		t.Errorf("finished status = %s, want failed", status)
+	}
+	var stored models.Job
+	db.First(&stored, "id = ?", job.ID)
+	if stored.Status != models.JobStatusFailed || stored.LogsPath == "" {
+		t.Errorf("job = %s (logs %q), want failed with archived logs", stored.Status, stored.LogsPath)
+	}
+	var machine models.Machine
+	db.First(&machine, "id = ?", job.MachineID)
+	if machine.Status != models.MachineStatusError {
+		t.Errorf("machine status = %s, want error", machine.Status)
+	}
+}
+
+func TestFinisher_Cancel(t *testing.T) {
+	db := setupTestDB(t)
+	broker := newTestBroker(t)
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusInstalling})
+	job := &models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeInstallOS, Status: models.JobStatusFailed, Error: "Cancelled by user"}
+	db.Create(job)
+	broker.Publish(job.ID, logbroker.LogMessage{Level: "INFO", Message: "installing"})
+	sub := broker.SubscribeFrom(job.ID, 0)
+	defer sub.Close()
+
+	NewFinisher(broker).Cancel(db, job, "user")
+
+	if status := waitFinished(t, sub); status != string(models.JobStatusFailed) {
+		t.Errorf("finished status = %s, want failed", status)
+	}
+	var stored models.Job
+	db.First(&stored, "id = ?", job.ID)
+	if stored.LogsPath == "" || stored.LogsPath != job.LogsPath {
+		t.Errorf("logs path = %q (in memory %q), want archived logs", stored.LogsPath, job.LogsPath)
+	}
+	var archived []string
+	if err := broker.Store().Scan(job.ID, func(msg logbroker.LogMessage) bool {
+		archived = append(archived, msg.Message)
+		return true
+	}); err != nil || len(archived) != 1 || archived[0] != "installing" {
+		t.Errorf("archived logs = %v, %v, want [installing]", archived, err)
+	}
+	var machine models.Machine
+	db.First(&machine, "id = ?", job.MachineID)
+	if machine.Status != models.MachineStatusReady {
+		t.Errorf("machine status = %s, want ready", machine.Status)
+	}
+}
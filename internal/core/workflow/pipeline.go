@@ -0,0 +1,148 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/google/uuid"
+)
+
+// StepSpec 工作流步骤定义
+type StepSpec struct {
+	Name      string
+	Action    models.StepAction
+	DependsOn []string
+	// Conditions 全部满足才执行，否则跳过；见 evalCondition
+	Conditions []string
+	MaxRetries int
+	Timeout    time.Duration
+	// ParamKey 从 Job.Params 中取出作为步骤配置下发给Agent
+	ParamKey string
+}
+
+// Pipeline 工作流定义（步骤构成DAG）
+type Pipeline struct {
+	Name  string
+	Steps []StepSpec
+}
+
+// ProvisionPipeline 标准装机流程
+//
+//	audit → firmware_check ┐
+//	      → config_raid    ├→ install_os → verify
+//	      → config_bios    ┘
+var ProvisionPipeline = Pipeline{
+	Name: string(models.JobTypeProvision),
+	Steps: []StepSpec{
+		{Name: "audit", Action: models.StepActionAudit, MaxRetries: 2, Timeout: 10 * time.Minute},
+		{Name: "firmware_check", Action: models.StepActionFirmwareCheck, DependsOn: []string{"audit"},
+			Conditions: []string{"param:firmware"}, MaxRetries: 1, Timeout: 30 * time.Minute, ParamKey: "firmware"},
+		{Name: "config_raid", Action: models.StepActionConfigRAID, DependsOn: []string{"audit"},
+			Conditions: []string{"param:raid", "raid_controller"}, MaxRetries: 1, Timeout: 20 * time.Minute, ParamKey: "raid"},
+		{Name: "config_bios", Action: models.StepActionConfigBIOS, DependsOn: []string{"audit"},
+			Conditions: []string{"param:bios"}, MaxRetries: 1, Timeout: 15 * time.Minute, ParamKey: "bios"},
+		{Name: "install_os", Action: models.StepActionInstallOS, DependsOn: []string{"firmware_check", "config_raid", "config_bios"},
+			Timeout: 90 * time.Minute},
+		{Name: "verify", Action: models.StepActionVerify, DependsOn: []string{"install_os"}, MaxRetries: 2, Timeout: 15 * time.Minute},
+	},
+}
+
+// pipelines 已注册的工作流
+var pipelines = map[string]Pipeline{
+	ProvisionPipeline.Name: ProvisionPipeline,
+}
+
+// Lookup 查找工作流定义
+func Lookup(name string) (Pipeline, bool) {
+	p, ok := pipelines[name]
+	return p, ok
+}
+
+// Validate 检查工作流：步骤名唯一、依赖存在、无环
+func (p Pipeline) Validate() error {
+	specs := make(map[string]StepSpec, len(p.Steps))
+	for _, s := range p.Steps {
+		if s.Name == "" {
+			return fmt.Errorf("pipeline %s: step name is empty", p.Name)
+		}
+		if _, dup := specs[s.Name]; dup {
+			return fmt.Errorf("pipeline %s: duplicate step %s", p.Name, s.Name)
+		}
+		specs[s.Name] = s
+	}
+
+	// 0=未访问 1=访问中 2=已完成
+	state := make(map[string]int, len(specs))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("pipeline %s: dependency cycle %s", p.Name, strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range specs[name].DependsOn {
+			if _, ok := specs[dep]; !ok {
+				return fmt.Errorf("pipeline %s: step %s depends on unknown step %s", p.Name, name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, s := range p.Steps {
+		if err := visit(s.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Instantiate 为任务生成步骤记录
+func (p Pipeline) Instantiate(job *models.Job) []models.JobStep {
+	steps := make([]models.JobStep, 0, len(p.Steps))
+	for i, s := range p.Steps {
+		step := models.JobStep{
+			ID:             uuid.New().String(),
+			JobID:          job.ID,
+			Name:           s.Name,
+			Action:         s.Action,
+			Seq:            i,
+			DependsOn:      s.DependsOn,
+			Conditions:     s.Conditions,
+			Status:         models.StepStatusPending,
+			MaxRetries:     s.MaxRetries,
+			TimeoutSeconds: int(s.Timeout / time.Second),
+		}
+		if s.ParamKey != "" {
+			if cfg, ok := job.Params[s.ParamKey].(map[string]interface{}); ok {
+				step.Config = cfg
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// evalCondition 计算步骤条件
+//
+//	param:<key>      Job.Params 中存在非空的 <key>
+//	raid_controller  机器硬件清单中存在存储控制器
+func evalCondition(cond string, job *models.Job, machine *models.Machine) (bool, error) {
+	if key, ok := strings.CutPrefix(cond, "param:"); ok {
+		v, exists := job.Params[key]
+		return exists && v != nil && v != "", nil
+	}
+
+	switch cond {
+	case "raid_controller":
+		return machine != nil && len(machine.HardwareSpec.StorageControllers) > 0, nil
+	default:
+		return false, fmt.Errorf("unknown condition: %s", cond)
+	}
+}
@@ -0,0 +1,62 @@
+package workflow
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sweeper 定时处理超时步骤
+type Sweeper struct {
+	db       *gorm.DB
+	interval time.Duration
+	finisher *Finisher
+	stopCh   chan struct{}
+}
+
+// NewSweeper 创建超时步骤巡检器
+func NewSweeper(db *gorm.DB, interval time.Duration, finisher *Finisher) *Sweeper {
+	return &Sweeper{
+		db:       db,
+		interval: interval,
+		finisher: finisher,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动巡检
+func (s *Sweeper) Start() {
+	log.Printf("⏱️  启动工作流超时巡检 (间隔: %v)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-s.stopCh:
+				ticker.Stop()
+				log.Println("⏱️  工作流超时巡检已停止")
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止巡检
+func (s *Sweeper) Stop() {
+	close(s.stopCh)
+}
+
+// sweep 执行一次巡检
+func (s *Sweeper) sweep() {
+	n, err := ExpireTimedOut(s.db, time.Now(), s.finisher)
+	if err != nil {
+		log.Printf("❌ 工作流超时巡检失败: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("⏱️  %d 个工作流步骤执行超时", n)
+	}
+}
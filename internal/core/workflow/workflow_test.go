@@ -0,0 +1,179 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Machine{}, &models.Job{}, &models.JobStep{}, &models.MachineEvent{}); err != nil {
+		t.Fatalf("Failed to migrate tables: %v", err)
+	}
+	return db
+}
+
+func createProvisionJob(t *testing.T, db *gorm.DB, params map[string]interface{}) *models.Job {
+	db.Create(&models.Machine{ID: "machine-1", Hostname: "web-01", MacAddress: "aa:bb:cc:dd:ee:01", Status: models.MachineStatusInstalling})
+	job := &models.Job{ID: "job-1", MachineID: "machine-1", Type: models.JobTypeProvision, Status: models.JobStatusPending, Params: params}
+	if err := Create(db, job, ProvisionPipeline); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return job
+}
+
+// runStep 取出下一个步骤并上报结果，返回步骤名
+func runStep(t *testing.T, db *gorm.DB, job *models.Job, success bool) string {
+	step, err := NextStep(db, job)
+	if err != nil {
+		t.Fatalf("NextStep() error = %v", err)
+	}
+	if step == nil {
+		t.Fatal("NextStep() returned no step")
+	}
+	if err := CompleteStep(db, job, step.ID, success, "boom"); err != nil {
+		t.Fatalf("CompleteStep(%s) error = %v", step.Name, err)
+	}
+	return step.Name
+}
+
+func stepStatuses(t *testing.T, db *gorm.DB, jobID string) map[string]models.StepStatus {
+	steps, err := Steps(db, jobID)
+	if err != nil {
+		t.Fatalf("Steps() error = %v", err)
+	}
+	statuses := make(map[string]models.StepStatus, len(steps))
+	for _, s := range steps {
+		statuses[s.Name] = s.Status
+	}
+	return statuses
+}
+
+func TestPipeline_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []StepSpec
+		wantErr string
+	}{
+		{"provision", ProvisionPipeline.Steps, ""},
+		{"duplicate", []StepSpec{{Name: "a"}, {Name: "a"}}, "duplicate step a"},
+		{"unknown dependency", []StepSpec{{Name: "a", DependsOn: []string{"b"}}}, "unknown step b"},
+		{"cycle", []StepSpec{
+			{Name: "a", DependsOn: []string{"c"}},
+			{Name: "b", DependsOn: []string{"a"}},
+			{Name: "c", DependsOn: []string{"b"}},
+		}, "dependency cycle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Pipeline{Name: "test", Steps: tt.steps}.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEngine_Provision(t *testing.T) {
+	db := setupTestDB(t)
+	job := createProvisionJob(t, db, map[string]interface{}{
+		"bios": map[string]interface{}{"boot_mode": "uefi"},
+	})
+
+	// audit 首次失败后重试；无固件参数、无RAID控制器时对应步骤跳过
+	want := []struct {
+		step    string
+		success bool
+	}{
+		{"audit", false},
+		{"audit", true},
+		{"config_bios", true},
+		{"install_os", true},
+		{"verify", true},
+	}
+	for _, w := range want {
+		if got := runStep(t, db, job, w.success); got != w.step {
+			t.Fatalf("dispatched %s, want %s", got, w.step)
+		}
+	}
+
+	if step, _ := NextStep(db, job); step != nil {
+		t.Errorf("NextStep() after completion = %s, want nil", step.Name)
+	}
+
+	statuses := stepStatuses(t, db, job.ID)
+	if statuses["firmware_check"] != models.StepStatusSkipped || statuses["config_raid"] != models.StepStatusSkipped {
+		t.Errorf("conditional steps not skipped: %v", statuses)
+	}
+
+	var stored models.Job
+	db.First(&stored, "id = ?", job.ID)
+	if stored.Status != models.JobStatusSuccess || stored.StepCurrent != "completed" {
+		t.Errorf("job = %s (%s), want success (completed)", stored.Status, stored.StepCurrent)
+	}
+
+	steps, _ := Steps(db, job.ID)
+	if steps[3].Config["boot_mode"] != "uefi" {
+		t.Errorf("config_bios config = %v, want bios params", steps[3].Config)
+	}
+}
+
+func TestEngine_UpstreamFailure(t *testing.T) {
+	db := setupTestDB(t)
+	job := createProvisionJob(t, db, nil)
+
+	// audit 重试2次后仍失败
+	for i := 0; i < 3; i++ {
+		runStep(t, db, job, false)
+	}
+
+	statuses := stepStatuses(t, db, job.ID)
+	if statuses["audit"] != models.StepStatusFailed {
+		t.Errorf("audit = %s, want failed", statuses["audit"])
+	}
+	for _, name := range []string{"install_os", "verify"} {
+		if statuses[name] != models.StepStatusSkipped {
+			t.Errorf("%s = %s, want skipped", name, statuses[name])
+		}
+	}
+	if job.Status != models.JobStatusFailed || !strings.Contains(job.Error, "step audit failed") {
+		t.Errorf("job = %s (%s), want failed", job.Status, job.Error)
+	}
+}
+
+func TestExpireTimedOut(t *testing.T) {
+	db := setupTestDB(t)
+	job := createProvisionJob(t, db, nil)
+
+	step, err := NextStep(db, job)
+	if err != nil || step == nil {
+		t.Fatalf("NextStep() = %v, %v", step, err)
+	}
+
+	if n, _ := ExpireTimedOut(db, time.Now(), nil); n != 0 {
+		t.Errorf("ExpireTimedOut() before timeout = %d, want 0", n)
+	}
+	if n, _ := ExpireTimedOut(db, time.Now().Add(11*time.Minute), nil); n != 1 {
+		t.Errorf("ExpireTimedOut() after timeout = %d, want 1", n)
+	}
+
+	steps, _ := Steps(db, job.ID)
+	if steps[0].Status != models.StepStatusPending || !strings.Contains(steps[0].Error, "timed out") {
+		t.Errorf("audit = %s (%s), want pending retry after timeout", steps[0].Status, steps[0].Error)
+	}
+}
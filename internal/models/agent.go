@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// AgentBootstrapToken iPXE下发给BootOS的一次性引导令牌，只保存SHA-256摘要
+type AgentBootstrapToken struct {
+	TokenHash  string    `gorm:"primaryKey;type:varchar(64)" json:"-"`
+	MacAddress string    `gorm:"index;type:varchar(17)" json:"mac_address"`
+	SourceIP   string    `gorm:"type:varchar(45)" json:"source_ip"` // 获取iPXE脚本的地址，注册须来自同一地址
+	Bound      bool      `json:"bound"`                             // 签发时已确认请求方就是该机器（租约/已知地址匹配或运维批准）
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName 指定表名
+func (AgentBootstrapToken) TableName() string {
+	return "agent_bootstrap_tokens"
+}
+
+// AgentCredential 机器的Agent凭据版本，版本变化后此前签发的Agent令牌全部失效
+type AgentCredential struct {
+	MachineID     string     `gorm:"primaryKey;type:varchar(36)" json:"machine_id"`
+	Generation    int        `json:"generation"`
+	IssuedAt      time.Time  `json:"issued_at"`                // 最近一次凭据轮换时间
+	ReenrollUntil *time.Time `json:"reenroll_until,omitempty"` // 运维批准重新注册的截止时间，期间可用引导令牌轮换凭据
+}
+
+// TableName 指定表名
+func (AgentCredential) TableName() string {
+	return "agent_credentials"
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent 操作审计记录（只追加，按 Seq 构成哈希链）
+type AuditEvent struct {
+	Seq          uint64          `gorm:"primaryKey;autoIncrement:false" json:"seq"`
+	Timestamp    time.Time       `gorm:"index" json:"timestamp"`
+	Actor        string          `gorm:"index;type:varchar(100)" json:"actor"`          // 用户名或 system
+	ActorToken   string          `gorm:"type:varchar(16)" json:"actor_token,omitempty"` // 使用API令牌时记录令牌前缀
+	SourceIP     string          `gorm:"type:varchar(64)" json:"source_ip"`
+	RequestID    string          `gorm:"index;type:varchar(64)" json:"request_id"`
+	Action       string          `gorm:"index;type:varchar(64)" json:"action"` // 如 machine.create
+	ResourceType string          `gorm:"index;type:varchar(32)" json:"resource_type"`
+	ResourceID   string          `gorm:"index;type:varchar(255)" json:"resource_id"`
+	Changes      json.RawMessage `gorm:"type:text" json:"changes,omitempty"` // 字段级 before/after 差异
+	PrevHash     string          `gorm:"type:varchar(64)" json:"prev_hash"`
+	Hash         string          `gorm:"uniqueIndex;type:varchar(64)" json:"hash"`
+}
+
+// TableName 指定表名
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
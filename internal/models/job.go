@@ -10,16 +10,25 @@ type Job struct {
 	MachineID   string    `gorm:"index;column:machine_id" json:"machine_id"`
 	Type        JobType   `gorm:"type:varchar(50)" json:"type"`
 	Status      JobStatus `gorm:"type:varchar(20);index" json:"status"`
-	ProfileID   string    `gorm:"type:varchar(36);index" json:"profile_id"` // OS Profile ID (for install_os jobs)
+	TenantID    string    `gorm:"type:varchar(36);index" json:"tenant_id,omitempty"` // 继承自机器
+	ProfileID   string    `gorm:"type:varchar(36);index" json:"profile_id"`          // OS Profile ID (for install_os jobs)
 	StepCurrent string    `gorm:"type:varchar(100)" json:"step_current"`
 	LogsPath    string    `gorm:"type:varchar(255)" json:"logs_path"`
 	Error       string    `gorm:"type:text" json:"error,omitempty"`
 	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 
+	// Params 工作流参数（raid/bios/firmware等），供步骤条件和配置使用
+	Params map[string]interface{} `gorm:"serializer:json;type:text" json:"params,omitempty"`
+	// ProviderVersions 本任务固定使用的Provider版本（provider_id → version），优先于配置模板和默认版本
+	ProviderVersions map[string]string `gorm:"serializer:json;type:text" json:"provider_versions,omitempty"`
+	// Result Agent上报的结构化结果（无步骤任务）
+	Result map[string]interface{} `gorm:"serializer:json;type:text" json:"result,omitempty"`
+
 	// 关联
 	Machine *Machine   `gorm:"foreignKey:MachineID" json:"machine,omitempty"`
 	Profile *OSProfile `gorm:"foreignKey:ProfileID" json:"profile,omitempty"`
+	Steps   []JobStep  `gorm:"foreignKey:JobID" json:"steps,omitempty"`
 }
 
 // JobType 任务类型枚举
@@ -32,8 +41,13 @@ const (
 	JobTypeConfigRAID JobType = "config_raid"
 	// JobTypeInstallOS 操作系统安装
 	JobTypeInstallOS JobType = "install_os"
+	// JobTypeProvision 完整装机工作流（审计→固件→RAID→BIOS→安装→验证）
+	JobTypeProvision JobType = "provision"
 )
 
+// InstallJobTypes 会触发PXE安装的任务类型
+var InstallJobTypes = []JobType{JobTypeInstallOS, JobTypeProvision}
+
 // JobStatus 任务状态枚举
 type JobStatus string
 
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+)
+
+// JobStep 工作流中的一个步骤（DAG节点）
+type JobStep struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	JobID      string     `gorm:"index;column:job_id" json:"job_id"`
+	Name       string     `gorm:"type:varchar(50)" json:"name"`   // 在工作流内唯一，供依赖引用
+	Action     StepAction `gorm:"type:varchar(50)" json:"action"` // 下发给Agent的动作
+	Seq        int        `json:"seq"`                            // 同时可运行时按序号先后下发
+	DependsOn  []string   `gorm:"serializer:json;type:text" json:"depends_on"`
+	Conditions []string   `gorm:"serializer:json;type:text" json:"conditions,omitempty"` // 全部满足才执行，否则跳过
+	Status     StepStatus `gorm:"type:varchar(20);index" json:"status"`
+	Attempts   int        `json:"attempts"`
+	MaxRetries int        `json:"max_retries"`
+	// TimeoutSeconds 单次执行超时，0表示不限
+	TimeoutSeconds int                    `json:"timeout_seconds"`
+	Config         map[string]interface{} `gorm:"serializer:json;type:text" json:"config,omitempty"`
+	Error          string                 `gorm:"type:text" json:"error,omitempty"`
+	Result         map[string]interface{} `gorm:"serializer:json;type:text" json:"result,omitempty"` // Agent上报的结构化结果
+	StartedAt      *time.Time             `json:"started_at,omitempty"`
+	FinishedAt     *time.Time             `json:"finished_at,omitempty"`
+	CreatedAt      time.Time              `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time              `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// StepAction 步骤动作
+type StepAction string
+
+const (
+	// StepActionAudit 硬件审计
+	StepActionAudit StepAction = "audit"
+	// StepActionFirmwareCheck 固件版本检查
+	StepActionFirmwareCheck StepAction = "firmware_check"
+	// StepActionConfigRAID RAID配置
+	StepActionConfigRAID StepAction = "config_raid"
+	// StepActionConfigBIOS BIOS配置
+	StepActionConfigBIOS StepAction = "config_bios"
+	// StepActionInstallOS 操作系统安装（由PXE安装器执行并回报）
+	StepActionInstallOS StepAction = "install_os"
+	// StepActionVerify 安装后验证
+	StepActionVerify StepAction = "verify"
+)
+
+// StepStatus 步骤状态
+type StepStatus string
+
+const (
+	// StepStatusPending 等待依赖完成
+	StepStatusPending StepStatus = "pending"
+	// StepStatusRunning 执行中
+	StepStatusRunning StepStatus = "running"
+	// StepStatusSuccess 成功
+	StepStatusSuccess StepStatus = "success"
+	// StepStatusFailed 失败（重试已用尽）
+	StepStatusFailed StepStatus = "failed"
+	// StepStatusSkipped 条件不满足或上游失败而跳过
+	StepStatusSkipped StepStatus = "skipped"
+)
+
+// TableName 指定表名
+func (JobStep) TableName() string {
+	return "job_steps"
+}
+
+// IsDone 检查步骤是否已结束（成功、失败或跳过）
+func (s *JobStep) IsDone() bool {
+	return s.Status == StepStatusSuccess || s.Status == StepStatusFailed || s.Status == StepStatusSkipped
+}
+
+// TimedOut 检查运行中的步骤是否超时
+func (s *JobStep) TimedOut(now time.Time) bool {
+	return s.Status == StepStatusRunning && s.TimeoutSeconds > 0 && s.StartedAt != nil &&
+		now.Sub(*s.StartedAt) > time.Duration(s.TimeoutSeconds)*time.Second
+}
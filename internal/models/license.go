@@ -30,9 +30,11 @@ func (l *License) IsExpired() bool {
 	return time.Now().After(l.ExpiresAt)
 }
 
-// IsValid 检查License是否有效（未过期 + 签名验证）
+// IsValid 检查License是否未过期
+//
+// 签名由 license.Manager 在导入和启动加载时使用官方公钥校验，
+// 宽限期也由其判定；这里只看到期时间。
 func (l *License) IsValid() bool {
-	// TODO: 实现签名验证逻辑
 	return !l.IsExpired()
 }
 
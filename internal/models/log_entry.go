@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// LogEntry 任务日志检索索引条目（日志正文另存于日志存储，此表仅用于查询）
+type LogEntry struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	JobID     string    `gorm:"type:varchar(36);index:idx_log_entries_job,priority:1" json:"job_id"`
+	LogID     int64     `gorm:"index:idx_log_entries_job,priority:2" json:"id"` // Job内日志ID
+	Timestamp time.Time `gorm:"index" json:"ts"`
+	Level     string    `gorm:"type:varchar(10);index" json:"level"`
+	Component string    `gorm:"type:varchar(50);index" json:"component"`
+	Message   string    `gorm:"type:text" json:"msg"`
+}
+
+// TableName 指定表名
+func (LogEntry) TableName() string {
+	return "log_entries"
+}
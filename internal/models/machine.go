@@ -11,6 +11,7 @@ type Machine struct {
 	MacAddress    string         `gorm:"uniqueIndex;column:mac_address" json:"mac_address"`
 	IPAddress     string         `gorm:"column:ip_address" json:"ip_address"`
 	Status        MachineStatus  `gorm:"type:varchar(20);index" json:"status"`
+	TenantID      string         `gorm:"type:varchar(36);index" json:"tenant_id,omitempty"` // 所属租户（空表示未分配）
 	HardwareSpec  HardwareInfo   `gorm:"serializer:json;type:text" json:"hardware_spec"`
 	CreatedAt     time.Time      `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt     time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
@@ -30,8 +31,44 @@ const (
 	MachineStatusActive MachineStatus = "active"
 	// MachineStatusError 发生错误
 	MachineStatusError MachineStatus = "error"
+	// MachineStatusMaintenance 维护中，不接受安装任务
+	MachineStatusMaintenance MachineStatus = "maintenance"
+	// MachineStatusDecommissioned 已下线（终态）
+	MachineStatusDecommissioned MachineStatus = "decommissioned"
 )
 
+// machineTransitions 机器生命周期允许的状态迁移
+//
+//	discovered → ready → installing → active
+//	任意非终态 → error / maintenance / decommissioned（installing 仅可进入 error）
+var machineTransitions = map[MachineStatus][]MachineStatus{
+	MachineStatusDiscovered:  {MachineStatusReady, MachineStatusError, MachineStatusMaintenance, MachineStatusDecommissioned},
+	MachineStatusReady:       {MachineStatusInstalling, MachineStatusError, MachineStatusMaintenance, MachineStatusDecommissioned},
+	MachineStatusInstalling:  {MachineStatusActive, MachineStatusError, MachineStatusReady}, // ready: 安装任务被取消
+	MachineStatusActive:      {MachineStatusInstalling, MachineStatusError, MachineStatusMaintenance, MachineStatusDecommissioned},
+	MachineStatusError:       {MachineStatusReady, MachineStatusInstalling, MachineStatusMaintenance, MachineStatusDecommissioned},
+	MachineStatusMaintenance: {MachineStatusReady, MachineStatusDecommissioned},
+}
+
+// IsValid 检查是否为已定义的机器状态
+func (s MachineStatus) IsValid() bool {
+	_, ok := machineTransitions[s]
+	return ok || s == MachineStatusDecommissioned
+}
+
+// CanTransitionTo 检查状态迁移是否被允许（同状态视为允许）
+func (s MachineStatus) CanTransitionTo(to MachineStatus) bool {
+	if s == to {
+		return true
+	}
+	for _, next := range machineTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 // HardwareInfo 标准化硬件指纹 (Schema v1.0)
 type HardwareInfo struct {
 	SchemaVersion       string              `json:"schema_version"`
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// MachineEvent 机器状态迁移记录
+type MachineEvent struct {
+	ID         uint          `gorm:"primaryKey;autoIncrement" json:"id"`
+	MachineID  string        `gorm:"index;column:machine_id" json:"machine_id"`
+	FromStatus MachineStatus `gorm:"type:varchar(20)" json:"from_status"`
+	ToStatus   MachineStatus `gorm:"type:varchar(20)" json:"to_status"`
+	Actor      string        `gorm:"type:varchar(100)" json:"actor"`           // user, agent, installer, system
+	JobID      string        `gorm:"type:varchar(36)" json:"job_id,omitempty"` // 触发迁移的任务
+	Reason     string        `gorm:"type:text" json:"reason"`
+	CreatedAt  time.Time     `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// TableName 指定表名
+func (MachineEvent) TableName() string {
+	return "machine_events"
+}
@@ -176,3 +176,30 @@ func TestMachine_IsReady(t *testing.T) {
 		})
 	}
 }
+
+func TestMachineStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from MachineStatus
+		to   MachineStatus
+		want bool
+	}{
+		{MachineStatusDiscovered, MachineStatusReady, true},
+		{MachineStatusDiscovered, MachineStatusInstalling, false},
+		{MachineStatusReady, MachineStatusInstalling, true},
+		{MachineStatusInstalling, MachineStatusActive, true},
+		{MachineStatusInstalling, MachineStatusMaintenance, false},
+		{MachineStatusActive, MachineStatusInstalling, true},
+		{MachineStatusError, MachineStatusReady, true},
+		{MachineStatusMaintenance, MachineStatusActive, false},
+		{MachineStatusDecommissioned, MachineStatusReady, false},
+		{MachineStatusReady, MachineStatusReady, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+				t.Errorf("CanTransitionTo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
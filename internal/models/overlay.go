@@ -10,6 +10,7 @@ type Overlay struct {
 	ID          string                 `gorm:"primaryKey" json:"id"`
 	ProviderID  string                 `gorm:"index;type:varchar(100)" json:"provider_id"`
 	MachineID   string                 `gorm:"index;type:varchar(100)" json:"machine_id,omitempty"` // Optional: specific to a machine
+	TenantID    string                 `gorm:"index;type:varchar(36)" json:"tenant_id,omitempty"`   // Empty: shared by all tenants
 	Name        string                 `gorm:"type:varchar(200)" json:"name"`
 	Description string                 `gorm:"type:text" json:"description"`
 	Config      OverlayConfig          `gorm:"serializer:json;type:text" json:"config"` // Override configuration
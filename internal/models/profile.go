@@ -11,8 +11,14 @@ type OSProfile struct {
 	Distro    string        `gorm:"type:varchar(50)" json:"distro"`    // centos7, ubuntu22, rocky8, suse15
 	Version   string        `gorm:"type:varchar(20)" json:"version"`   // 7.9, 22.04, 8.8, 15.5
 	Config    ProfileConfig `gorm:"serializer:json;type:text" json:"config"`
+	TenantID  string        `gorm:"type:varchar(36);index" json:"tenant_id,omitempty"`
 	CreatedAt time.Time     `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time     `gorm:"autoUpdateTime" json:"updated_at"`
+
+	// ProviderVersions 使用该模板的任务固定使用的Provider版本（provider_id → version），未指定的使用默认版本
+	ProviderVersions map[string]string `gorm:"serializer:json;type:text" json:"provider_versions,omitempty"`
+	// ProviderConfigs 使用该模板的任务的Provider配置（provider_id → 配置），优先级低于任务自身的配置
+	ProviderConfigs map[string]map[string]interface{} `gorm:"serializer:json;type:text" json:"provider_configs,omitempty"`
 }
 
 // ProfileConfig 安装配置详情
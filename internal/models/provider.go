@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// ProviderVersion Private Store中安装的Provider版本（同一Provider可并存多个版本，其中一个为默认版本）
+type ProviderVersion struct {
+	ProviderID string `gorm:"primaryKey;type:varchar(100)" json:"provider_id"`
+	Version    string `gorm:"primaryKey;type:varchar(50)" json:"version"`
+	Name       string `gorm:"type:varchar(200)" json:"name"`
+	Vendor     string `gorm:"type:varchar(100)" json:"vendor"`
+	Model      string `gorm:"type:varchar(100)" json:"model"`
+	Checksum   string `gorm:"type:varchar(64)" json:"checksum"` // 明文SHA256
+	KeyVersion int    `json:"key_version"`                      // 加密保存使用的Master Key版本
+	IsDefault  bool   `gorm:"index" json:"is_default"`
+	// Metadata 完整的Provider元数据JSON（manifest、水印、schema等）
+	Metadata  string    `gorm:"type:text" json:"-"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (ProviderVersion) TableName() string {
+	return "provider_versions"
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// Tenant 租户（组织），机器、任务、配置模板和Provider按租户隔离
+type Tenant struct {
+	ID          string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	Name        string    `gorm:"uniqueIndex;type:varchar(100)" json:"name"`
+	Description string    `gorm:"type:text" json:"description"`
+	Quota       Quota     `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Quota 租户配额，0 表示不限制
+type Quota struct {
+	Machines   int `json:"machines"`
+	Profiles   int `json:"profiles"`
+	Providers  int `json:"providers"`
+	ActiveJobs int `json:"active_jobs"` // 同时处于 pending/running 的任务数
+}
+
+// TableName 指定表名
+func (Tenant) TableName() string {
+	return "tenants"
+}
+
+// ProviderTenant Provider归属的租户（没有记录的Provider为全部租户共享）
+type ProviderTenant struct {
+	ProviderID string    `gorm:"primaryKey;type:varchar(100)" json:"provider_id"`
+	TenantID   string    `gorm:"index;type:varchar(36)" json:"tenant_id"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName 指定表名
+func (ProviderTenant) TableName() string {
+	return "provider_tenants"
+}
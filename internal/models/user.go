@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+)
+
+// User 控制台/API用户
+type User struct {
+	ID           string     `gorm:"primaryKey" json:"id"`
+	Username     string     `gorm:"uniqueIndex;type:varchar(64)" json:"username"`
+	DisplayName  string     `gorm:"type:varchar(100)" json:"display_name"`
+	Email        string     `gorm:"type:varchar(255)" json:"email"`
+	PasswordHash string     `gorm:"type:varchar(100)" json:"-"` // bcrypt
+	Roles        []string   `gorm:"serializer:json;type:text" json:"roles"`
+	TenantID     string     `gorm:"type:varchar(36);index" json:"tenant_id,omitempty"` // 所属租户（空表示不属于任何租户）
+	Disabled     bool       `json:"disabled"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (User) TableName() string {
+	return "users"
+}
+
+// HasRole 检查用户是否拥有指定角色
+func (u *User) HasRole(name string) bool {
+	for _, r := range u.Roles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Role 角色，授予一组权限（resource:action）
+type Role struct {
+	Name        string    `gorm:"primaryKey;type:varchar(50)" json:"name"`
+	Description string    `gorm:"type:varchar(255)" json:"description"`
+	Permissions []string  `gorm:"serializer:json;type:text" json:"permissions"`
+	BuiltIn     bool      `json:"built_in"` // 内置角色不可修改或删除
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (Role) TableName() string {
+	return "roles"
+}
+
+// APIToken 自动化调用使用的API令牌，只保存令牌的SHA-256摘要
+type APIToken struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	UserID     string     `gorm:"index;type:varchar(36)" json:"user_id"`
+	Name       string     `gorm:"type:varchar(100)" json:"name"`
+	Prefix     string     `gorm:"type:varchar(16)" json:"prefix"` // 令牌明文前缀，便于识别
+	TokenHash  string     `gorm:"uniqueIndex;type:varchar(64)" json:"-"`
+	Scopes     []string   `gorm:"serializer:json;type:text" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // 为空表示不过期
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName 指定表名
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// IsExpired 检查令牌是否过期
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Session Web控制台登录会话，ID为会话Cookie的SHA-256摘要
+type Session struct {
+	ID        string    `gorm:"primaryKey;type:varchar(64)" json:"-"`
+	UserID    string    `gorm:"index;type:varchar(36)" json:"user_id"`
+	SourceIP  string    `gorm:"type:varchar(64)" json:"source_ip"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName 指定表名
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -28,8 +28,10 @@ func SignData(data []byte, privateKey *ecdsa.PrivateKey) (string, error) {
 		return "", fmt.Errorf("failed to sign: %w", err)
 	}
 
-	// 序列化签名（r和s拼接）
-	signature := append(r.Bytes(), s.Bytes()...)
+	// 序列化签名（r和s各补齐为32字节后拼接，否则前导零字节会导致长度不足64）
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
 
 	// Base64编码
 	return base64.StdEncoding.EncodeToString(signature), nil
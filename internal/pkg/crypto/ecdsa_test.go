@@ -34,6 +34,26 @@ func TestSignAndVerify(t *testing.T) {
 	}
 }
 
+func TestSignAndVerify_Repeated(t *testing.T) {
+	// r或s有前导零字节时签名仍须为64字节（约1/128的概率）
+	privateKey, err := GenerateECDSAKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		data := []byte{byte(i), byte(i >> 8)}
+		signature, err := SignData(data, privateKey)
+		if err != nil {
+			t.Fatalf("Failed to sign data: %v", err)
+		}
+		valid, err := VerifySignature(data, signature, &privateKey.PublicKey)
+		if err != nil || !valid {
+			t.Fatalf("iteration %d: verification failed: valid=%v err=%v", i, valid, err)
+		}
+	}
+}
+
 func TestVerifyWithWrongData(t *testing.T) {
 	privateKey, _ := GenerateECDSAKeyPair()
 	originalData := []byte("Original data")
@@ -1,12 +1,17 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+// ErrBackupNotFound 备份文件不存在或名称非法
+var ErrBackupNotFound = errors.New("backup not found")
+
 // BackupManager 数据库备份管理器
 type BackupManager struct {
 	dbPath     string
@@ -90,6 +95,65 @@ func (bm *BackupManager) Restore(backupFile string) error {
 	return nil
 }
 
+// StageRestore 准备从备份恢复：将备份复制为 <数据库文件>.restore，下次启动时生效
+// 运行中的服务持有数据库连接，直接替换文件并不安全，因此恢复需要重启服务完成
+func (bm *BackupManager) StageRestore(name string) error {
+	backupFile, err := bm.backupPath(name)
+	if err != nil {
+		return err
+	}
+	staged := bm.dbFile() + ".restore"
+	if err := copyFile(backupFile, staged+".tmp"); err != nil {
+		return fmt.Errorf("failed to stage restore: %w", err)
+	}
+	return os.Rename(staged+".tmp", staged)
+}
+
+// ApplyStagedRestore 应用已准备的恢复（需在 Init 之前调用）
+// 原数据库保留为 <数据库文件>.before-restore，返回是否执行了恢复
+func (bm *BackupManager) ApplyStagedRestore() (bool, error) {
+	dbFile := bm.dbFile()
+	staged := dbFile + ".restore"
+	if _, err := os.Stat(staged); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if _, err := os.Stat(dbFile); err == nil {
+		if err := os.Rename(dbFile, dbFile+".before-restore"); err != nil {
+			return false, fmt.Errorf("failed to keep current database: %w", err)
+		}
+	}
+	// 旧的WAL文件属于被替换的数据库，必须一并移除
+	_ = os.Remove(dbFile + "-wal")
+	_ = os.Remove(dbFile + "-shm")
+
+	if err := os.Rename(staged, dbFile); err != nil {
+		return false, fmt.Errorf("failed to apply restore: %w", err)
+	}
+	return true, nil
+}
+
+// backupPath 校验备份名称（仅允许备份目录下的 cloudboot-*.db）并返回完整路径
+func (bm *BackupManager) backupPath(name string) (string, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, "cloudboot-") || !strings.HasSuffix(name, ".db") {
+		return "", fmt.Errorf("%w: %s", ErrBackupNotFound, name)
+	}
+	path := filepath.Join(bm.backupDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrBackupNotFound, name)
+	}
+	return path, nil
+}
+
+// dbFile 从DSN中提取数据库文件路径（去掉 file: 前缀和查询参数）
+func (bm *BackupManager) dbFile() string {
+	path := strings.TrimPrefix(bm.dbPath, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
 // ListBackups 列出所有备份文件
 func (bm *BackupManager) ListBackups() ([]BackupInfo, error) {
 	files, err := filepath.Glob(filepath.Join(bm.backupDir, "cloudboot-*.db"))
@@ -104,6 +168,7 @@ func (bm *BackupManager) ListBackups() ([]BackupInfo, error) {
 			continue
 		}
 		backups = append(backups, BackupInfo{
+			Name:      filepath.Base(file),
 			Path:      file,
 			Size:      info.Size(),
 			CreatedAt: info.ModTime(),
@@ -136,9 +201,10 @@ func (bm *BackupManager) cleanOldBackups() error {
 
 // BackupInfo 备份文件信息
 type BackupInfo struct {
-	Path      string
-	Size      int64
-	CreatedAt time.Time
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // copyFile 复制文件
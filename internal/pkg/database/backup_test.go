@@ -136,3 +136,50 @@ func TestBackupManager_Restore(t *testing.T) {
 	DB.Model(&TestModel{}).Count(&count)
 	assert.Equal(t, int64(1), count)
 }
+
+func TestBackupManager_StagedRestore(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "test.db")
+	backupDir := filepath.Join(tempDir, "backups")
+
+	err := Init(Config{DSN: dbPath + "?_journal_mode=WAL"})
+	require.NoError(t, err)
+
+	type TestModel struct {
+		ID   uint   `gorm:"primaryKey"`
+		Name string `gorm:"type:varchar(100)"`
+	}
+	require.NoError(t, DB.AutoMigrate(&TestModel{}))
+	require.NoError(t, DB.Create(&TestModel{Name: "test1"}).Error)
+
+	bm := NewBackupManager(dbPath+"?_journal_mode=WAL", backupDir)
+	backupFile, err := bm.Backup()
+	require.NoError(t, err)
+	require.NoError(t, DB.Create(&TestModel{Name: "test2"}).Error)
+
+	// 非法名称
+	for _, name := range []string{"../test.db", "other.db", "cloudboot-missing.db"} {
+		assert.ErrorIs(t, bm.StageRestore(name), ErrBackupNotFound, name)
+	}
+
+	require.NoError(t, bm.StageRestore(filepath.Base(backupFile)))
+
+	// 没有准备恢复时不做任何事
+	other := NewBackupManager(filepath.Join(tempDir, "other.db"), backupDir)
+	applied, err := other.ApplyStagedRestore()
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	// 模拟重启：关闭连接后应用恢复
+	require.NoError(t, Close())
+	applied, err = bm.ApplyStagedRestore()
+	require.NoError(t, err)
+	assert.True(t, applied)
+	assert.FileExists(t, dbPath+".before-restore")
+	assert.NoFileExists(t, dbPath+".restore")
+
+	require.NoError(t, Init(Config{DSN: dbPath + "?_journal_mode=WAL"}))
+	var count int64
+	DB.Model(&TestModel{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
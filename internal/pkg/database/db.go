@@ -55,6 +55,20 @@ func AutoMigrate() error {
 		&models.Job{},
 		&models.OSProfile{},
 		&models.License{},
+		&models.MachineEvent{},
+		&models.JobStep{},
+		&models.Overlay{},
+		&models.LogEntry{},
+		&models.User{},
+		&models.Role{},
+		&models.APIToken{},
+		&models.Session{},
+		&models.AgentBootstrapToken{},
+		&models.AgentCredential{},
+		&models.AuditEvent{},
+		&models.Tenant{},
+		&models.ProviderTenant{},
+		&models.ProviderVersion{},
 	)
 
 	if err != nil {
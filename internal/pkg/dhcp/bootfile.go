@@ -0,0 +1,124 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Arch 客户端系统架构 (RFC 4578 Option 93)
+type Arch uint16
+
+const (
+	ArchBIOS     Arch = 0  // Intel x86PC (Legacy BIOS)
+	ArchEFIIA32  Arch = 6  // EFI IA32
+	ArchEFIBC    Arch = 7  // EFI BC (x86-64)
+	ArchEFIX64   Arch = 9  // EFI x86-64
+	ArchEFIARM32 Arch = 10 // EFI ARM32
+	ArchEFIARM64 Arch = 11 // EFI ARM64
+	ArchHTTPX64  Arch = 16 // UEFI HTTP Boot x86-64
+	ArchHTTPARM  Arch = 19 // UEFI HTTP Boot ARM64
+)
+
+// String 返回架构名称
+func (a Arch) String() string {
+	switch a {
+	case ArchBIOS:
+		return "bios"
+	case ArchEFIIA32:
+		return "efi-ia32"
+	case ArchEFIBC, ArchEFIX64:
+		return "efi-x64"
+	case ArchEFIARM32:
+		return "efi-arm32"
+	case ArchEFIARM64:
+		return "efi-arm64"
+	case ArchHTTPX64:
+		return "http-x64"
+	case ArchHTTPARM:
+		return "http-arm64"
+	default:
+		return fmt.Sprintf("arch-%d", uint16(a))
+	}
+}
+
+// BootFiles 各架构对应的iPXE引导文件（TFTP路径）
+type BootFiles struct {
+	BIOS  string // Legacy BIOS: undionly.kpxe
+	EFI64 string // UEFI x86-64: ipxe.efi
+	ARM64 string // UEFI ARM64: ipxe-arm64.efi
+}
+
+// DefaultBootFiles 默认引导文件名
+func DefaultBootFiles() BootFiles {
+	return BootFiles{
+		BIOS:  "undionly.kpxe",
+		EFI64: "ipxe.efi",
+		ARM64: "ipxe-arm64.efi",
+	}
+}
+
+// ClientInfo 从DHCP请求中识别出的PXE客户端信息
+type ClientInfo struct {
+	MAC      net.HardwareAddr
+	Arch     Arch
+	IsPXE    bool // Option 60 以 "PXEClient" 开头
+	IsIPXE   bool // Option 77 为 "iPXE" 或携带 Option 175
+	UserCls  string
+	VendorCl string
+}
+
+// IdentifyClient 解析请求中的PXE相关选项
+func IdentifyClient(p *Packet) ClientInfo {
+	info := ClientInfo{
+		MAC:      p.CHAddr,
+		VendorCl: string(p.Options[OptVendorClass]),
+	}
+
+	info.IsPXE = strings.HasPrefix(info.VendorCl, "PXEClient")
+
+	if v := p.Options[OptClientArch]; len(v) >= 2 {
+		info.Arch = Arch(binary.BigEndian.Uint16(v[:2]))
+	}
+
+	info.UserCls = parseUserClass(p.Options[OptUserClass])
+	if info.UserCls == "iPXE" {
+		info.IsIPXE = true
+	}
+	if _, ok := p.Options[OptIPXEEncap]; ok {
+		info.IsIPXE = true
+	}
+
+	return info
+}
+
+// SelectBootFile 根据客户端架构选择引导文件
+// iPXE客户端直接返回HTTP脚本地址（链式加载），其余返回对应架构的iPXE固件
+func SelectBootFile(info ClientInfo, files BootFiles, httpServerURL string) string {
+	if info.IsIPXE {
+		return fmt.Sprintf("%s/boot/ipxe/%s", strings.TrimRight(httpServerURL, "/"), info.MAC.String())
+	}
+
+	switch info.Arch {
+	case ArchEFIBC, ArchEFIX64, ArchHTTPX64:
+		return files.EFI64
+	case ArchEFIARM64, ArchHTTPARM:
+		return files.ARM64
+	default:
+		return files.BIOS
+	}
+}
+
+// parseUserClass 解析Option 77
+// iPXE发送的是纯字符串 "iPXE"，RFC 3004格式则是 长度+数据 的列表
+func parseUserClass(v []byte) string {
+	if len(v) == 0 {
+		return ""
+	}
+	if int(v[0]) == len(v)-1 && bytes.IndexByte(v[1:], 0) < 0 {
+		return string(v[1:])
+	}
+	return string(v)
+}
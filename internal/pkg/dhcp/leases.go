@@ -0,0 +1,199 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Lease 动态地址租约
+type Lease struct {
+	MAC       string
+	IP        net.IP
+	ExpiresAt time.Time
+}
+
+// LeasePool 动态地址池（仅Full DHCP模式使用）
+type LeasePool struct {
+	mu       sync.Mutex
+	start    uint32
+	end      uint32
+	duration time.Duration
+	byMAC    map[string]*Lease
+	byIP     map[uint32]*Lease
+	reserved func(ip net.IP) bool
+}
+
+// NewLeasePool 创建地址池，范围为[start, end]闭区间
+func NewLeasePool(start, end net.IP, duration time.Duration) (*LeasePool, error) {
+	s, e := start.To4(), end.To4()
+	if s == nil || e == nil {
+		return nil, fmt.Errorf("lease range must be IPv4: %s - %s", start, end)
+	}
+	if ipToUint32(s) > ipToUint32(e) {
+		return nil, fmt.Errorf("invalid lease range: %s > %s", start, end)
+	}
+
+	return &LeasePool{
+		start:    ipToUint32(s),
+		end:      ipToUint32(e),
+		duration: duration,
+		byMAC:    make(map[string]*Lease),
+		byIP:     make(map[uint32]*Lease),
+	}, nil
+}
+
+// SetReservedFunc 设置保留地址判断函数，保留地址不会被动态分配
+func (p *LeasePool) SetReservedFunc(fn func(ip net.IP) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reserved = fn
+}
+
+// Allocate 为MAC分配地址（优先沿用已有租约和客户端请求的地址）
+func (p *LeasePool) Allocate(mac string, requested net.IP) (net.IP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	// 1. 已有租约直接续用
+	if lease, ok := p.byMAC[mac]; ok {
+		return lease.IP, nil
+	}
+
+	// 2. 客户端请求的地址可用则分配
+	if requested != nil && p.isFree(requested, now) {
+		return p.bind(mac, requested, now), nil
+	}
+
+	// 3. 顺序查找空闲地址
+	for n := p.start; n <= p.end; n++ {
+		ip := uint32ToIP(n)
+		if p.isFree(ip, now) {
+			return p.bind(mac, ip, now), nil
+		}
+		if n == p.end {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("lease pool exhausted")
+}
+
+// Confirm 确认租约（DHCPREQUEST），地址不属于该MAC时返回false
+func (p *LeasePool) Confirm(mac string, ip net.IP) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	key := ipToUint32(ip.To4())
+
+	if lease, ok := p.byIP[key]; ok && lease.MAC != mac && lease.ExpiresAt.After(now) {
+		return false
+	}
+	if !p.inRange(ip) {
+		return false
+	}
+	if p.reserved != nil && p.reserved(ip) {
+		return false
+	}
+
+	p.bind(mac, ip, now)
+	return true
+}
+
+// Release 释放租约
+func (p *LeasePool) Release(mac string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if lease, ok := p.byMAC[mac]; ok {
+		delete(p.byIP, ipToUint32(lease.IP.To4()))
+		delete(p.byMAC, mac)
+	}
+}
+
+// Lookup 返回MAC未过期的租约地址
+func (p *LeasePool) Lookup(mac string) (net.IP, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lease, ok := p.byMAC[mac]
+	if !ok || !lease.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+	return lease.IP, true
+}
+
+// Leases 返回当前租约快照
+func (p *LeasePool) Leases() []Lease {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	leases := make([]Lease, 0, len(p.byMAC))
+	for _, lease := range p.byMAC {
+		leases = append(leases, *lease)
+	}
+	return leases
+}
+
+// bind 绑定地址到MAC（需持有锁）
+func (p *LeasePool) bind(mac string, ip net.IP, now time.Time) net.IP {
+	if old, ok := p.byMAC[mac]; ok {
+		delete(p.byIP, ipToUint32(old.IP.To4()))
+	}
+
+	ip = ip.To4()
+	lease := &Lease{
+		MAC:       mac,
+		IP:        ip,
+		ExpiresAt: now.Add(p.duration),
+	}
+	p.byMAC[mac] = lease
+	p.byIP[ipToUint32(ip)] = lease
+	return ip
+}
+
+// isFree 检查地址是否可分配（需持有锁）
+func (p *LeasePool) isFree(ip net.IP, now time.Time) bool {
+	if !p.inRange(ip) {
+		return false
+	}
+	if p.reserved != nil && p.reserved(ip) {
+		return false
+	}
+	lease, ok := p.byIP[ipToUint32(ip.To4())]
+	if !ok {
+		return true
+	}
+	if lease.ExpiresAt.Before(now) {
+		// 过期租约回收
+		delete(p.byMAC, lease.MAC)
+		delete(p.byIP, ipToUint32(lease.IP.To4()))
+		return true
+	}
+	return false
+}
+
+// inRange 检查地址是否在池范围内
+func (p *LeasePool) inRange(ip net.IP) bool {
+	v4 := ip.To4()
+	if v4 == nil {
+		return false
+	}
+	n := ipToUint32(v4)
+	return n >= p.start && n <= p.end
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
@@ -0,0 +1,256 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// BOOTP操作码
+const (
+	OpRequest byte = 1
+	OpReply   byte = 2
+)
+
+// DHCP消息类型 (Option 53)
+const (
+	MsgDiscover byte = 1
+	MsgOffer    byte = 2
+	MsgRequest  byte = 3
+	MsgDecline  byte = 4
+	MsgAck      byte = 5
+	MsgNak      byte = 6
+	MsgRelease  byte = 7
+	MsgInform   byte = 8
+)
+
+// 常用DHCP选项编号
+const (
+	OptSubnetMask    byte = 1
+	OptRouter        byte = 3
+	OptDNS           byte = 6
+	OptHostname      byte = 12
+	OptRequestedIP   byte = 50
+	OptLeaseTime     byte = 51
+	OptMessageType   byte = 53
+	OptServerID      byte = 54
+	OptParamList     byte = 55
+	OptRenewalTime   byte = 58
+	OptRebindingTime byte = 59
+	OptVendorClass   byte = 60
+	OptClientID      byte = 61
+	OptTFTPServer    byte = 66
+	OptBootfileName  byte = 67
+	OptUserClass     byte = 77
+	OptClientArch    byte = 93
+	OptClientNDI     byte = 94
+	OptClientUUID    byte = 97
+	OptIPXEEncap     byte = 175
+	OptPad           byte = 0
+	OptEnd           byte = 255
+	bootpFixedLength      = 236
+)
+
+// magicCookie DHCP魔数 (RFC 2131)
+var magicCookie = []byte{99, 130, 83, 99}
+
+// Packet DHCPv4报文
+type Packet struct {
+	Op      byte
+	HType   byte
+	HLen    byte
+	Hops    byte
+	XID     uint32
+	Secs    uint16
+	Flags   uint16
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	SName   string
+	File    string
+	Options Options
+}
+
+// Options DHCP选项集合（选项编号 -> 原始值）
+type Options map[byte][]byte
+
+// ParsePacket 解析DHCPv4报文
+func ParsePacket(data []byte) (*Packet, error) {
+	if len(data) < bootpFixedLength+len(magicCookie) {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+
+	p := &Packet{
+		Op:      data[0],
+		HType:   data[1],
+		HLen:    data[2],
+		Hops:    data[3],
+		XID:     binary.BigEndian.Uint32(data[4:8]),
+		Secs:    binary.BigEndian.Uint16(data[8:10]),
+		Flags:   binary.BigEndian.Uint16(data[10:12]),
+		CIAddr:  net.IP(append([]byte(nil), data[12:16]...)),
+		YIAddr:  net.IP(append([]byte(nil), data[16:20]...)),
+		SIAddr:  net.IP(append([]byte(nil), data[20:24]...)),
+		GIAddr:  net.IP(append([]byte(nil), data[24:28]...)),
+		SName:   cString(data[44:108]),
+		File:    cString(data[108:236]),
+		Options: make(Options),
+	}
+
+	hlen := int(p.HLen)
+	if hlen > 16 {
+		return nil, fmt.Errorf("invalid hardware address length: %d", hlen)
+	}
+	p.CHAddr = net.HardwareAddr(append([]byte(nil), data[28:28+hlen]...))
+
+	if string(data[236:240]) != string(magicCookie) {
+		return nil, fmt.Errorf("invalid magic cookie")
+	}
+
+	// 解析TLV格式的选项
+	opts := data[240:]
+	for i := 0; i < len(opts); {
+		code := opts[i]
+		if code == OptPad {
+			i++
+			continue
+		}
+		if code == OptEnd {
+			break
+		}
+		if i+1 >= len(opts) {
+			return nil, fmt.Errorf("truncated option %d", code)
+		}
+		length := int(opts[i+1])
+		if i+2+length > len(opts) {
+			return nil, fmt.Errorf("option %d overflows packet", code)
+		}
+		// RFC 3396: 同一选项多次出现时拼接
+		p.Options[code] = append(p.Options[code], opts[i+2:i+2+length]...)
+		i += 2 + length
+	}
+
+	return p, nil
+}
+
+// Marshal 序列化DHCPv4报文
+func (p *Packet) Marshal() []byte {
+	buf := make([]byte, bootpFixedLength, 576)
+	buf[0] = p.Op
+	buf[1] = p.HType
+	buf[2] = p.HLen
+	buf[3] = p.Hops
+	binary.BigEndian.PutUint32(buf[4:8], p.XID)
+	binary.BigEndian.PutUint16(buf[8:10], p.Secs)
+	binary.BigEndian.PutUint16(buf[10:12], p.Flags)
+	copy(buf[12:16], ipv4OrZero(p.CIAddr))
+	copy(buf[16:20], ipv4OrZero(p.YIAddr))
+	copy(buf[20:24], ipv4OrZero(p.SIAddr))
+	copy(buf[24:28], ipv4OrZero(p.GIAddr))
+	copy(buf[28:44], p.CHAddr)
+	copy(buf[44:107], p.SName)
+	copy(buf[108:235], p.File)
+
+	buf = append(buf, magicCookie...)
+
+	// 消息类型放在首位，便于部分PXE固件解析
+	if v, ok := p.Options[OptMessageType]; ok {
+		buf = appendOption(buf, OptMessageType, v)
+	}
+	for code := 1; code < 255; code++ {
+		c := byte(code)
+		if c == OptMessageType {
+			continue
+		}
+		if v, ok := p.Options[c]; ok {
+			buf = appendOption(buf, c, v)
+		}
+	}
+	buf = append(buf, OptEnd)
+
+	// BOOTP最小报文长度300字节
+	for len(buf) < 300 {
+		buf = append(buf, OptPad)
+	}
+
+	return buf
+}
+
+// MessageType 返回DHCP消息类型
+func (p *Packet) MessageType() byte {
+	if v := p.Options[OptMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// IsBroadcast 客户端是否要求广播回复
+func (p *Packet) IsBroadcast() bool {
+	return p.Flags&0x8000 != 0
+}
+
+// SetIP 设置IPv4类型的选项
+func (o Options) SetIP(code byte, ips ...net.IP) {
+	v := make([]byte, 0, 4*len(ips))
+	for _, ip := range ips {
+		v = append(v, ipv4OrZero(ip)...)
+	}
+	o[code] = v
+}
+
+// SetUint32 设置32位整数类型的选项
+func (o Options) SetUint32(code byte, value uint32) {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, value)
+	o[code] = v
+}
+
+// SetString 设置字符串类型的选项
+func (o Options) SetString(code byte, value string) {
+	o[code] = []byte(value)
+}
+
+// IP 读取IPv4类型的选项
+func (o Options) IP(code byte) net.IP {
+	if v := o[code]; len(v) >= 4 {
+		return net.IP(append([]byte(nil), v[:4]...))
+	}
+	return nil
+}
+
+// appendOption 追加选项，超过255字节时按RFC 3396拆分
+func appendOption(buf []byte, code byte, value []byte) []byte {
+	if len(value) == 0 {
+		return append(buf, code, 0)
+	}
+	for len(value) > 0 {
+		n := len(value)
+		if n > 255 {
+			n = 255
+		}
+		buf = append(buf, code, byte(n))
+		buf = append(buf, value[:n]...)
+		value = value[n:]
+	}
+	return buf
+}
+
+// ipv4OrZero 返回4字节IPv4地址，无效时返回0.0.0.0
+func ipv4OrZero(ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return net.IPv4zero.To4()
+}
+
+// cString 读取以\0结尾的字符串
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,68 @@
+package dhcp
+
+import (
+	"net"
+	"strings"
+
+	"github.com/cloudboot/cloudboot-ng/internal/models"
+	"github.com/cloudboot/cloudboot-ng/internal/pkg/database"
+)
+
+// Reservation 固定地址分配
+type Reservation struct {
+	MAC      string
+	IP       net.IP
+	Hostname string
+}
+
+// ReservationStore 固定地址查询接口
+type ReservationStore interface {
+	// LookupMAC 根据MAC查询固定地址
+	LookupMAC(mac string) (*Reservation, bool)
+
+	// IsReserved 检查地址是否已被某台机器占用
+	IsReserved(ip net.IP) bool
+}
+
+// MachineReservations 基于 models.Machine 的固定地址表
+// MacAddress/IPAddress 非空的机器即视为一条保留记录
+type MachineReservations struct{}
+
+// NewMachineReservations 创建基于机器表的保留地址查询
+func NewMachineReservations() *MachineReservations {
+	return &MachineReservations{}
+}
+
+// LookupMAC 根据MAC查询机器的固定IP
+func (r *MachineReservations) LookupMAC(mac string) (*Reservation, bool) {
+	if database.DB == nil {
+		return nil, false
+	}
+
+	var machine models.Machine
+	if err := database.DB.Where("mac_address = ?", strings.ToLower(mac)).First(&machine).Error; err != nil {
+		return nil, false
+	}
+
+	ip := net.ParseIP(machine.IPAddress).To4()
+	if ip == nil {
+		return nil, false
+	}
+
+	return &Reservation{
+		MAC:      machine.MacAddress,
+		IP:       ip,
+		Hostname: machine.Hostname,
+	}, true
+}
+
+// IsReserved 检查地址是否已分配给某台机器
+func (r *MachineReservations) IsReserved(ip net.IP) bool {
+	if database.DB == nil {
+		return false
+	}
+
+	var count int64
+	database.DB.Model(&models.Machine{}).Where("ip_address = ?", ip.String()).Count(&count)
+	return count > 0
+}
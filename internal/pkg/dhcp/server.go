@@ -0,0 +1,426 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// Mode DHCP工作模式
+type Mode string
+
+const (
+	// ModeFull 完整DHCP：分配地址 + PXE引导参数
+	ModeFull Mode = "full"
+	// ModeProxy ProxyDHCP：仅下发PXE引导参数，地址由现网DHCP分配
+	ModeProxy Mode = "proxy"
+)
+
+// Config DHCP服务器配置
+type Config struct {
+	Mode          Mode
+	ListenAddr    string        // 监听地址，默认 0.0.0.0:67
+	ServerIP      net.IP        // 本机地址（Server Identifier / next-server）
+	HTTPServerURL string        // CloudBoot HTTP地址，用于iPXE链式加载
+	BootFiles     BootFiles     // 各架构的iPXE固件文件名
+	RangeStart    net.IP        // 动态地址池起始（Full模式）
+	RangeEnd      net.IP        // 动态地址池结束（Full模式）
+	SubnetMask    net.IP        // 子网掩码（Full模式）
+	Router        net.IP        // 默认网关（Full模式）
+	DNS           []net.IP      // DNS服务器（Full模式）
+	LeaseTime     time.Duration // 租约时长（Full模式）
+}
+
+// Server DHCP/ProxyDHCP服务器
+type Server struct {
+	config       Config
+	conns        []*net.UDPConn
+	pool         *LeasePool
+	reservations ReservationStore
+}
+
+// NewServer 创建DHCP服务器
+func NewServer(config Config, reservations ReservationStore) (*Server, error) {
+	if config.Mode != ModeFull && config.Mode != ModeProxy {
+		return nil, fmt.Errorf("unsupported dhcp mode: %q", config.Mode)
+	}
+	if config.ServerIP.To4() == nil {
+		return nil, fmt.Errorf("server ip is required")
+	}
+	if config.ListenAddr == "" {
+		config.ListenAddr = "0.0.0.0:67"
+	}
+	if config.BootFiles == (BootFiles{}) {
+		config.BootFiles = DefaultBootFiles()
+	}
+	if config.LeaseTime == 0 {
+		config.LeaseTime = 12 * time.Hour
+	}
+
+	s := &Server{
+		config:       config,
+		reservations: reservations,
+	}
+
+	if config.Mode == ModeFull {
+		if config.SubnetMask.To4() == nil {
+			return nil, fmt.Errorf("subnet mask is required in full mode")
+		}
+		pool, err := NewLeasePool(config.RangeStart, config.RangeEnd, config.LeaseTime)
+		if err != nil {
+			return nil, err
+		}
+		if reservations != nil {
+			pool.SetReservedFunc(reservations.IsReserved)
+		}
+		s.pool = pool
+	}
+
+	return s, nil
+}
+
+// Start 启动DHCP服务器
+// Proxy模式额外监听4011端口，处理PXE Boot Server Discovery请求
+func (s *Server) Start() error {
+	addrs := []string{s.config.ListenAddr}
+	if s.config.Mode == ModeProxy {
+		host, _, err := net.SplitHostPort(s.config.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid listen address: %w", err)
+		}
+		addrs = append(addrs, net.JoinHostPort(host, "4011"))
+	}
+
+	for _, addr := range addrs {
+		conn, err := listen(addr)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		s.conns = append(s.conns, conn)
+		go s.serve(conn)
+	}
+
+	log.Printf("✅ DHCP服务器启动成功: %v (模式: %s)", addrs, s.config.Mode)
+	log.Printf("📁 引导文件: BIOS=%s UEFI=%s ARM64=%s", s.config.BootFiles.BIOS, s.config.BootFiles.EFI64, s.config.BootFiles.ARM64)
+
+	return nil
+}
+
+// Stop 停止DHCP服务器
+func (s *Server) Stop() error {
+	var firstErr error
+	for _, conn := range s.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.conns = nil
+	return firstErr
+}
+
+// Leases 返回当前动态租约（Proxy模式为空）
+func (s *Server) Leases() []Lease {
+	if s.pool == nil {
+		return nil
+	}
+	return s.pool.Leases()
+}
+
+// LeaseIP 返回本服务器分配给MAC的地址（固定地址或未过期的动态租约）
+// Proxy模式地址由现网DHCP分配，始终返回false
+func (s *Server) LeaseIP(mac string) (net.IP, bool) {
+	if s.pool == nil {
+		return nil, false
+	}
+	if s.reservations != nil {
+		if r, ok := s.reservations.LookupMAC(mac); ok {
+			return r.IP, true
+		}
+	}
+	return s.pool.Lookup(mac)
+}
+
+// listen 创建允许广播的UDP监听
+func listen(addr string) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve UDP address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen UDP: %w", err)
+	}
+
+	if err := enableBroadcast(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable broadcast: %w", err)
+	}
+
+	return conn, nil
+}
+
+// serve 处理DHCP请求
+func (s *Server) serve(conn *net.UDPConn) {
+	buffer := make([]byte, 1500)
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+
+	for {
+		n, clientAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("⚠️  DHCP读取错误: %v", err)
+			continue
+		}
+
+		req, err := ParsePacket(buffer[:n])
+		if err != nil {
+			log.Printf("⚠️  DHCP报文解析失败 (%s): %v", clientAddr, err)
+			continue
+		}
+
+		reply := s.Handle(req)
+		if reply == nil {
+			continue
+		}
+
+		s.send(conn, reply, replyAddr(req, clientAddr, localPort))
+	}
+}
+
+// Handle 处理单个DHCP请求并返回应答（nil表示不应答）
+func (s *Server) Handle(req *Packet) *Packet {
+	if req.Op != OpRequest || len(req.CHAddr) != 6 {
+		return nil
+	}
+
+	switch s.config.Mode {
+	case ModeProxy:
+		return s.handleProxy(req)
+	default:
+		return s.handleFull(req)
+	}
+}
+
+// handleFull 完整DHCP模式
+func (s *Server) handleFull(req *Packet) *Packet {
+	mac := req.CHAddr.String()
+	client := IdentifyClient(req)
+
+	switch req.MessageType() {
+	case MsgDiscover:
+		ip, hostname, err := s.assignAddress(mac, req.Options.IP(OptRequestedIP))
+		if err != nil {
+			log.Printf("❌ DHCP地址分配失败 (%s): %v", mac, err)
+			return nil
+		}
+		log.Printf("📤 DHCPOFFER %s -> %s (arch: %s, ipxe: %v)", ip, mac, client.Arch, client.IsIPXE)
+		return s.buildLeaseReply(req, MsgOffer, ip, hostname, client)
+
+	case MsgRequest:
+		// 非发给本服务器的REQUEST（客户端选择了其他DHCP服务器）
+		if sid := req.Options.IP(OptServerID); sid != nil && !sid.Equal(s.config.ServerIP) {
+			if s.pool != nil {
+				s.pool.Release(mac)
+			}
+			return nil
+		}
+
+		requested := req.Options.IP(OptRequestedIP)
+		if requested == nil && !req.CIAddr.Equal(net.IPv4zero) {
+			requested = req.CIAddr
+		}
+
+		ip, hostname, ok := s.confirmAddress(mac, requested)
+		if !ok {
+			log.Printf("📤 DHCPNAK %s (requested: %s)", mac, requested)
+			return s.buildNak(req)
+		}
+		log.Printf("📤 DHCPACK %s -> %s", ip, mac)
+		return s.buildLeaseReply(req, MsgAck, ip, hostname, client)
+
+	case MsgRelease, MsgDecline:
+		if s.pool != nil {
+			s.pool.Release(mac)
+		}
+		return nil
+
+	case MsgInform:
+		reply := s.newReply(req, MsgAck)
+		reply.CIAddr = req.CIAddr
+		s.applyNetworkOptions(reply)
+		if client.IsPXE {
+			s.applyBootOptions(reply, client)
+		}
+		return reply
+	}
+
+	return nil
+}
+
+// handleProxy ProxyDHCP模式 (PXE规范 2.1)
+// 仅响应PXE客户端，不分配地址，只下发next-server与引导文件
+func (s *Server) handleProxy(req *Packet) *Packet {
+	client := IdentifyClient(req)
+	if !client.IsPXE {
+		return nil
+	}
+
+	var msgType byte
+	switch req.MessageType() {
+	case MsgDiscover:
+		msgType = MsgOffer
+	case MsgRequest, MsgInform:
+		msgType = MsgAck
+	default:
+		return nil
+	}
+
+	reply := s.newReply(req, msgType)
+	reply.CIAddr = req.CIAddr
+	s.applyBootOptions(reply, client)
+
+	log.Printf("📤 ProxyDHCP %s -> %s (file: %s)", dhcpMsgName(msgType), client.MAC, reply.File)
+	return reply
+}
+
+// assignAddress 为DISCOVER选择地址：固定地址优先，其次动态池
+func (s *Server) assignAddress(mac string, requested net.IP) (net.IP, string, error) {
+	if s.reservations != nil {
+		if r, ok := s.reservations.LookupMAC(mac); ok {
+			return r.IP, r.Hostname, nil
+		}
+	}
+	ip, err := s.pool.Allocate(mac, requested)
+	return ip, "", err
+}
+
+// confirmAddress 校验REQUEST中的地址是否可确认
+func (s *Server) confirmAddress(mac string, requested net.IP) (net.IP, string, bool) {
+	if requested == nil {
+		return nil, "", false
+	}
+	if s.reservations != nil {
+		if r, ok := s.reservations.LookupMAC(mac); ok {
+			return r.IP, r.Hostname, r.IP.Equal(requested)
+		}
+	}
+	return requested, "", s.pool.Confirm(mac, requested)
+}
+
+// buildLeaseReply 构建OFFER/ACK
+func (s *Server) buildLeaseReply(req *Packet, msgType byte, ip net.IP, hostname string, client ClientInfo) *Packet {
+	reply := s.newReply(req, msgType)
+	reply.YIAddr = ip
+
+	lease := uint32(s.config.LeaseTime / time.Second)
+	reply.Options.SetUint32(OptLeaseTime, lease)
+	reply.Options.SetUint32(OptRenewalTime, lease/2)
+	reply.Options.SetUint32(OptRebindingTime, lease*7/8)
+	s.applyNetworkOptions(reply)
+
+	if hostname != "" {
+		reply.Options.SetString(OptHostname, hostname)
+	}
+	if client.IsPXE || client.IsIPXE {
+		s.applyBootOptions(reply, client)
+	}
+
+	return reply
+}
+
+// buildNak 构建NAK
+func (s *Server) buildNak(req *Packet) *Packet {
+	reply := s.newReply(req, MsgNak)
+	// NAK必须广播
+	reply.Flags |= 0x8000
+	return reply
+}
+
+// newReply 构建应答骨架
+func (s *Server) newReply(req *Packet, msgType byte) *Packet {
+	reply := &Packet{
+		Op:      OpReply,
+		HType:   req.HType,
+		HLen:    req.HLen,
+		XID:     req.XID,
+		Flags:   req.Flags,
+		GIAddr:  req.GIAddr,
+		CHAddr:  req.CHAddr,
+		Options: make(Options),
+	}
+	reply.Options[OptMessageType] = []byte{msgType}
+	reply.Options.SetIP(OptServerID, s.config.ServerIP)
+	return reply
+}
+
+// applyNetworkOptions 写入子网/网关/DNS
+func (s *Server) applyNetworkOptions(reply *Packet) {
+	if s.config.SubnetMask.To4() != nil {
+		reply.Options.SetIP(OptSubnetMask, s.config.SubnetMask)
+	}
+	if s.config.Router.To4() != nil {
+		reply.Options.SetIP(OptRouter, s.config.Router)
+	}
+	if len(s.config.DNS) > 0 {
+		reply.Options.SetIP(OptDNS, s.config.DNS...)
+	}
+}
+
+// applyBootOptions 写入PXE引导参数（next-server + 引导文件）
+func (s *Server) applyBootOptions(reply *Packet, client ClientInfo) {
+	bootFile := SelectBootFile(client, s.config.BootFiles, s.config.HTTPServerURL)
+
+	reply.SIAddr = s.config.ServerIP
+	reply.File = bootFile
+	reply.Options.SetString(OptVendorClass, "PXEClient")
+	reply.Options.SetString(OptTFTPServer, s.config.ServerIP.String())
+	reply.Options.SetString(OptBootfileName, bootFile)
+}
+
+// send 发送应答
+func (s *Server) send(conn *net.UDPConn, reply *Packet, dst *net.UDPAddr) {
+	if _, err := conn.WriteToUDP(reply.Marshal(), dst); err != nil {
+		log.Printf("❌ DHCP发送失败 (%s): %v", dst, err)
+	}
+}
+
+// replyAddr 计算应答目的地址 (RFC 2131 4.1)
+// localPort 为收到请求的监听端口，4011端口上的请求直接回复源地址
+func replyAddr(req *Packet, clientAddr *net.UDPAddr, localPort int) *net.UDPAddr {
+	switch {
+	case !req.GIAddr.Equal(net.IPv4zero) && req.GIAddr.To4() != nil:
+		// 经过DHCP Relay
+		return &net.UDPAddr{IP: req.GIAddr, Port: 67}
+	case localPort == 4011 && clientAddr != nil:
+		// PXE Boot Server Discovery，直接回复源地址
+		return clientAddr
+	case !req.CIAddr.Equal(net.IPv4zero) && req.CIAddr.To4() != nil:
+		return &net.UDPAddr{IP: req.CIAddr, Port: 68}
+	default:
+		return &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	}
+}
+
+// dhcpMsgName 返回消息类型名称（日志用）
+func dhcpMsgName(t byte) string {
+	switch t {
+	case MsgDiscover:
+		return "DISCOVER"
+	case MsgOffer:
+		return "OFFER"
+	case MsgRequest:
+		return "REQUEST"
+	case MsgAck:
+		return "ACK"
+	case MsgNak:
+		return "NAK"
+	default:
+		return fmt.Sprintf("TYPE-%d", t)
+	}
+}
@@ -0,0 +1,301 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// staticReservations 测试用固定地址表
+type staticReservations map[string]*Reservation
+
+func (r staticReservations) LookupMAC(mac string) (*Reservation, bool) {
+	res, ok := r[mac]
+	return res, ok
+}
+
+func (r staticReservations) IsReserved(ip net.IP) bool {
+	for _, res := range r {
+		if res.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestRequest(mac string, msgType byte) *Packet {
+	hw, _ := net.ParseMAC(mac)
+	p := &Packet{
+		Op:      OpRequest,
+		HType:   1,
+		HLen:    6,
+		XID:     0xdeadbeef,
+		CHAddr:  hw,
+		Options: make(Options),
+	}
+	p.Options[OptMessageType] = []byte{msgType}
+	return p
+}
+
+func withPXE(p *Packet, arch Arch) *Packet {
+	p.Options.SetString(OptVendorClass, "PXEClient:Arch:00000:UNDI:002001")
+	p.Options[OptClientArch] = []byte{byte(arch >> 8), byte(arch)}
+	return p
+}
+
+func newFullServer(t *testing.T, reservations ReservationStore) *Server {
+	s, err := NewServer(Config{
+		Mode:          ModeFull,
+		ServerIP:      net.ParseIP("10.0.0.10"),
+		HTTPServerURL: "http://10.0.0.10:8080",
+		RangeStart:    net.ParseIP("10.0.0.100"),
+		RangeEnd:      net.ParseIP("10.0.0.102"),
+		SubnetMask:    net.ParseIP("255.255.255.0"),
+		Router:        net.ParseIP("10.0.0.1"),
+		DNS:           []net.IP{net.ParseIP("10.0.0.2")},
+		LeaseTime:     time.Hour,
+	}, reservations)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	return s
+}
+
+func TestPacket_MarshalParseRoundTrip(t *testing.T) {
+	req := withPXE(newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover), ArchEFIX64)
+	req.Options.SetIP(OptRequestedIP, net.ParseIP("10.0.0.150"))
+
+	parsed, err := ParsePacket(req.Marshal())
+	if err != nil {
+		t.Fatalf("ParsePacket() error = %v", err)
+	}
+
+	if parsed.XID != req.XID {
+		t.Errorf("XID = %x, want %x", parsed.XID, req.XID)
+	}
+	if parsed.CHAddr.String() != "aa:bb:cc:dd:ee:01" {
+		t.Errorf("CHAddr = %s, want aa:bb:cc:dd:ee:01", parsed.CHAddr)
+	}
+	if parsed.MessageType() != MsgDiscover {
+		t.Errorf("MessageType = %d, want %d", parsed.MessageType(), MsgDiscover)
+	}
+	if ip := parsed.Options.IP(OptRequestedIP); !ip.Equal(net.ParseIP("10.0.0.150")) {
+		t.Errorf("Requested IP = %s, want 10.0.0.150", ip)
+	}
+}
+
+func TestParsePacket_Invalid(t *testing.T) {
+	if _, err := ParsePacket([]byte{1, 2, 3}); err == nil {
+		t.Error("ParsePacket() should fail on short packet")
+	}
+
+	data := newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover).Marshal()
+	data[236] = 0
+	if _, err := ParsePacket(data); err == nil {
+		t.Error("ParsePacket() should fail on bad magic cookie")
+	}
+}
+
+func TestSelectBootFile(t *testing.T) {
+	files := DefaultBootFiles()
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	tests := []struct {
+		name   string
+		client ClientInfo
+		want   string
+	}{
+		{"Legacy BIOS", ClientInfo{MAC: mac, Arch: ArchBIOS}, "undionly.kpxe"},
+		{"UEFI x64", ClientInfo{MAC: mac, Arch: ArchEFIX64}, "ipxe.efi"},
+		{"UEFI BC", ClientInfo{MAC: mac, Arch: ArchEFIBC}, "ipxe.efi"},
+		{"UEFI ARM64", ClientInfo{MAC: mac, Arch: ArchEFIARM64}, "ipxe-arm64.efi"},
+		{"iPXE chainload", ClientInfo{MAC: mac, Arch: ArchEFIX64, IsIPXE: true}, "http://10.0.0.10:8080/boot/ipxe/aa:bb:cc:dd:ee:ff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SelectBootFile(tt.client, files, "http://10.0.0.10:8080/")
+			if got != tt.want {
+				t.Errorf("SelectBootFile() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentifyClient_IPXE(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *Packet)
+	}{
+		{"User class string", func(p *Packet) { p.Options.SetString(OptUserClass, "iPXE") }},
+		{"User class RFC 3004", func(p *Packet) { p.Options[OptUserClass] = append([]byte{4}, "iPXE"...) }},
+		{"iPXE encapsulated options", func(p *Packet) { p.Options[OptIPXEEncap] = []byte{1, 1, 1} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := withPXE(newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover), ArchBIOS)
+			tt.setup(p)
+
+			if info := IdentifyClient(p); !info.IsIPXE {
+				t.Error("IsIPXE = false, want true")
+			}
+		})
+	}
+}
+
+func TestServer_FullModeDiscoverRequest(t *testing.T) {
+	s := newFullServer(t, staticReservations{})
+
+	offer := s.Handle(withPXE(newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover), ArchBIOS))
+	if offer == nil {
+		t.Fatal("expected OFFER")
+	}
+	if offer.MessageType() != MsgOffer {
+		t.Errorf("MessageType = %d, want OFFER", offer.MessageType())
+	}
+	if !offer.YIAddr.Equal(net.ParseIP("10.0.0.100")) {
+		t.Errorf("YIAddr = %s, want 10.0.0.100", offer.YIAddr)
+	}
+	if offer.File != "undionly.kpxe" {
+		t.Errorf("File = %s, want undionly.kpxe", offer.File)
+	}
+	if !offer.SIAddr.Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("SIAddr = %s, want 10.0.0.10", offer.SIAddr)
+	}
+
+	req := withPXE(newTestRequest("aa:bb:cc:dd:ee:01", MsgRequest), ArchBIOS)
+	req.Options.SetIP(OptRequestedIP, offer.YIAddr)
+	req.Options.SetIP(OptServerID, net.ParseIP("10.0.0.10"))
+
+	ack := s.Handle(req)
+	if ack == nil || ack.MessageType() != MsgAck {
+		t.Fatal("expected ACK")
+	}
+	if !ack.YIAddr.Equal(offer.YIAddr) {
+		t.Errorf("ACK YIAddr = %s, want %s", ack.YIAddr, offer.YIAddr)
+	}
+	if ip, ok := s.LeaseIP("aa:bb:cc:dd:ee:01"); !ok || !ip.Equal(offer.YIAddr) {
+		t.Errorf("LeaseIP() = %s, %v, want %s", ip, ok, offer.YIAddr)
+	}
+	if _, ok := s.LeaseIP("aa:bb:cc:dd:ee:03"); ok {
+		t.Error("LeaseIP() should not find a MAC without lease")
+	}
+
+	// 其他客户端请求已占用的地址应被拒绝
+	steal := newTestRequest("aa:bb:cc:dd:ee:02", MsgRequest)
+	steal.Options.SetIP(OptRequestedIP, offer.YIAddr)
+	if nak := s.Handle(steal); nak == nil || nak.MessageType() != MsgNak {
+		t.Error("expected NAK for address held by another client")
+	}
+}
+
+func TestServer_FullModeReservation(t *testing.T) {
+	s := newFullServer(t, staticReservations{
+		"aa:bb:cc:dd:ee:10": {MAC: "aa:bb:cc:dd:ee:10", IP: net.ParseIP("10.0.0.50").To4(), Hostname: "server-10"},
+		"aa:bb:cc:dd:ee:11": {MAC: "aa:bb:cc:dd:ee:11", IP: net.ParseIP("10.0.0.100").To4(), Hostname: "server-11"},
+	})
+
+	offer := s.Handle(newTestRequest("aa:bb:cc:dd:ee:10", MsgDiscover))
+	if offer == nil || !offer.YIAddr.Equal(net.ParseIP("10.0.0.50")) {
+		t.Fatalf("expected reserved address 10.0.0.50, got %v", offer)
+	}
+	if string(offer.Options[OptHostname]) != "server-10" {
+		t.Errorf("Hostname = %s, want server-10", offer.Options[OptHostname])
+	}
+	if ip, ok := s.LeaseIP("aa:bb:cc:dd:ee:10"); !ok || !ip.Equal(net.ParseIP("10.0.0.50")) {
+		t.Errorf("LeaseIP() = %s, %v, want reserved 10.0.0.50", ip, ok)
+	}
+
+	// 动态分配应跳过被保留的 10.0.0.100
+	dyn := s.Handle(newTestRequest("aa:bb:cc:dd:ee:99", MsgDiscover))
+	if dyn == nil || !dyn.YIAddr.Equal(net.ParseIP("10.0.0.101")) {
+		t.Fatalf("expected dynamic address 10.0.0.101, got %v", dyn)
+	}
+}
+
+func TestServer_ProxyMode(t *testing.T) {
+	s, err := NewServer(Config{
+		Mode:          ModeProxy,
+		ServerIP:      net.ParseIP("10.0.0.10"),
+		HTTPServerURL: "http://10.0.0.10:8080",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	// 非PXE客户端不应答
+	if reply := s.Handle(newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover)); reply != nil {
+		t.Error("ProxyDHCP should ignore non-PXE clients")
+	}
+
+	offer := s.Handle(withPXE(newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover), ArchEFIARM64))
+	if offer == nil {
+		t.Fatal("expected ProxyDHCP OFFER")
+	}
+	if offer.YIAddr != nil {
+		t.Errorf("ProxyDHCP must not assign address, got %s", offer.YIAddr)
+	}
+	if offer.File != "ipxe-arm64.efi" {
+		t.Errorf("File = %s, want ipxe-arm64.efi", offer.File)
+	}
+	if string(offer.Options[OptVendorClass]) != "PXEClient" {
+		t.Errorf("Option 60 = %s, want PXEClient", offer.Options[OptVendorClass])
+	}
+	if _, ok := s.LeaseIP("aa:bb:cc:dd:ee:01"); ok {
+		t.Error("ProxyDHCP does not assign addresses, LeaseIP() should return false")
+	}
+}
+
+func TestReplyAddr(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("10.0.0.50"), Port: 68}
+	relayed := newTestRequest("aa:bb:cc:dd:ee:01", MsgRequest)
+	relayed.GIAddr = net.ParseIP("10.1.0.1").To4()
+	renewing := newTestRequest("aa:bb:cc:dd:ee:01", MsgRequest)
+	renewing.CIAddr = net.ParseIP("10.0.0.50").To4()
+
+	tests := []struct {
+		name      string
+		req       *Packet
+		client    *net.UDPAddr
+		localPort int
+		want      string
+	}{
+		{"Broadcast", newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover), client, 67, "255.255.255.255:68"},
+		{"Relay", relayed, client, 67, "10.1.0.1:67"},
+		{"Client address", renewing, client, 67, "10.0.0.50:68"},
+		// 按收到请求的端口判断，而不是客户端源端口
+		{"Boot server discovery", renewing, client, 4011, "10.0.0.50:68"},
+		{"Client source port 4011", newTestRequest("aa:bb:cc:dd:ee:01", MsgDiscover), &net.UDPAddr{IP: net.ParseIP("10.0.0.50"), Port: 4011}, 67, "255.255.255.255:68"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replyAddr(tt.req, tt.client, tt.localPort).String(); got != tt.want {
+				t.Errorf("replyAddr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeasePool_Exhausted(t *testing.T) {
+	pool, err := NewLeasePool(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewLeasePool() error = %v", err)
+	}
+
+	for _, mac := range []string{"a", "b"} {
+		if _, err := pool.Allocate(mac, nil); err != nil {
+			t.Fatalf("Allocate(%s) error = %v", mac, err)
+		}
+	}
+
+	if _, err := pool.Allocate("c", nil); err == nil {
+		t.Error("Allocate() should fail when pool is exhausted")
+	}
+
+	pool.Release("a")
+	if _, err := pool.Allocate("c", nil); err != nil {
+		t.Errorf("Allocate() after release error = %v", err)
+	}
+}
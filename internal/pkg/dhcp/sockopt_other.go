@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package dhcp
+
+import "net"
+
+// enableBroadcast Windows下UDP套接字默认允许广播
+func enableBroadcast(conn *net.UDPConn) error {
+	return nil
+}
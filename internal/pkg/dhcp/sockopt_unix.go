@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package dhcp
+
+import (
+	"net"
+	"syscall"
+)
+
+// enableBroadcast 开启SO_BROADCAST（DHCP应答需广播到255.255.255.255）
+func enableBroadcast(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
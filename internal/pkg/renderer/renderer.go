@@ -10,6 +10,14 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// Keys shared between echo.Context and template data, set by the auth middleware
+const (
+	// CurrentUserKey holds the logged-in user
+	CurrentUserKey = "currentUser"
+	// PermissionsKey holds a map[string]bool of the user's permissions
+	PermissionsKey = "can"
+)
+
 // TemplateRenderer is a custom HTML template renderer for Echo
 type TemplateRenderer struct {
 	pages     map[string]*template.Template  // Independent template for each page
@@ -50,7 +58,7 @@ func NewTemplateRenderer(templatesPath string) (*TemplateRenderer, error) {
 		return nil, err
 	}
 
-	// Also load boot templates (Kickstart, iPXE, AutoYaST)
+	// Also load boot templates (iPXE)
 	bootPath := filepath.Join(templatesPath, "boot", "*.tmpl")
 	bootFiles, err := filepath.Glob(bootPath)
 	if err == nil {
@@ -134,6 +142,24 @@ func NewTemplateRendererFromFS(templateFS fs.FS) (*TemplateRenderer, error) {
 		return nil, err
 	}
 
+	// Boot templates (iPXE) are standalone, don't need layout/components
+	bootFiles, err := fs.Glob(templateFS, "boot/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	for _, bootFile := range bootFiles {
+		pageName := filepath.Base(bootFile)
+		content, err := fs.ReadFile(templateFS, bootFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read boot template %s: %w", bootFile, err)
+		}
+		tmpl, err := template.New(pageName).Funcs(funcMap).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse boot template %s: %w", pageName, err)
+		}
+		renderer.pages[pageName] = tmpl
+	}
+
 	// Get base layout
 	baseContent, err := fs.ReadFile(templateFS, "layouts/base.html")
 	if err != nil {
@@ -200,6 +226,17 @@ func (t *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c
 		return tmpl.Execute(w, data)
 	}
 
+	// Expose the authenticated user and permissions (set by the auth middleware)
+	if m, ok := data.(map[string]interface{}); ok && c != nil {
+		for _, key := range []string{CurrentUserKey, PermissionsKey} {
+			if _, exists := m[key]; !exists {
+				if v := c.Get(key); v != nil {
+					m[key] = v
+				}
+			}
+		}
+	}
+
 	// HTML pages execute base.html which will call the content block
 	// The content block is defined in each page template as {{define "content"}}
 	return tmpl.ExecuteTemplate(w, "base.html", data)
@@ -0,0 +1,82 @@
+package tftp
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"path"
+	"strings"
+)
+
+// GenericScriptName 通用iPXE脚本文件名（外部DHCP常用的iPXE二段引导文件）
+const GenericScriptName = "boot.ipxe"
+
+// NewIPXEHook 创建iPXE虚拟文件钩子，所有内容均在内存中生成，不落盘
+//   - firmware: 嵌入的iPXE固件(undionly.kpxe / ipxe.efi 等)，可为nil
+//   - boot.ipxe: 通用脚本，由iPXE按自身MAC链式加载HTTP启动脚本
+//   - <mac>.ipxe / ipxe/<mac>: 指定MAC的脚本（支持 aa:bb.. / aa-bb.. / aabb.. 格式）
+func NewIPXEHook(firmware fs.FS, serverURL string) FileHook {
+	serverURL = strings.TrimRight(serverURL, "/")
+
+	return func(filename string, remote *net.UDPAddr) ([]byte, bool, error) {
+		if filename == GenericScriptName {
+			return []byte(chainScript(serverURL, "${netX/mac}", "")), true, nil
+		}
+
+		if mac, ok := macFromScriptName(filename); ok {
+			return []byte(chainScript(serverURL, mac, mac)), true, nil
+		}
+
+		if firmware != nil {
+			content, err := fs.ReadFile(firmware, filename)
+			if err == nil {
+				return content, true, nil
+			}
+		}
+
+		return nil, false, nil
+	}
+}
+
+// chainScript 生成链式加载到HTTP启动脚本的iPXE脚本
+func chainScript(serverURL, macExpr, forMAC string) string {
+	var b strings.Builder
+	b.WriteString("#!ipxe\n")
+	if forMAC != "" {
+		fmt.Fprintf(&b, "# CloudBoot NG - generated for %s\n", forMAC)
+	} else {
+		b.WriteString("# CloudBoot NG - generic chainload script\n")
+	}
+	fmt.Fprintf(&b, "chain --autofree %s/boot/ipxe/%s || goto failed\n", serverURL, macExpr)
+	b.WriteString(":failed\n")
+	b.WriteString("echo [ERROR] Chainload failed, retrying in 10s...\n")
+	b.WriteString("sleep 10\n")
+	b.WriteString("reboot\n")
+	return b.String()
+}
+
+// macFromScriptName 从脚本文件名中解析MAC地址
+func macFromScriptName(filename string) (string, bool) {
+	var candidate string
+	switch {
+	case strings.HasPrefix(filename, "ipxe/"):
+		candidate = strings.TrimSuffix(strings.TrimPrefix(filename, "ipxe/"), ".ipxe")
+	case path.Ext(filename) == ".ipxe" && !strings.Contains(filename, "/"):
+		candidate = strings.TrimSuffix(filename, ".ipxe")
+	default:
+		return "", false
+	}
+
+	hex := strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(candidate))
+	if len(hex) != 12 {
+		return "", false
+	}
+	for _, c := range hex {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", false
+		}
+	}
+
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s",
+		hex[0:2], hex[2:4], hex[4:6], hex[6:8], hex[8:10], hex[10:12]), true
+}
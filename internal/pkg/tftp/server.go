@@ -1,20 +1,68 @@
 package tftp
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// TFTP操作码 (RFC 1350 / RFC 2347)
+const (
+	opRRQ   uint16 = 1
+	opWRQ   uint16 = 2
+	opDATA  uint16 = 3
+	opACK   uint16 = 4
+	opERROR uint16 = 5
+	opOACK  uint16 = 6
+)
+
+// TFTP错误码
+const (
+	errNotDefined      uint16 = 0
+	errFileNotFound    uint16 = 1
+	errAccessViolation uint16 = 2
+	errIllegalOp       uint16 = 4
+	errOptionRefused   uint16 = 8
+)
+
+const (
+	// DefaultBlockSize RFC 1350 默认块大小
+	DefaultBlockSize = 512
+	// MinBlockSize / MaxBlockSize RFC 2348 允许的块大小范围
+	MinBlockSize = 8
+	MaxBlockSize = 65464
+
+	// DefaultTimeout 默认重传超时
+	DefaultTimeout = 3 * time.Second
+	// DefaultRetries 默认最大重传次数
+	DefaultRetries = 5
+)
+
+// FileHook 虚拟文件钩子
+// 命中时返回文件内容(ok=true)，未命中时返回ok=false并回落到磁盘文件
+type FileHook func(filename string, remote *net.UDPAddr) (content []byte, ok bool, err error)
+
 // Server TFTP服务器
 type Server struct {
 	addr      string
 	conn      *net.UDPConn
 	filesRoot string
+	hook      FileHook
+	timeout   time.Duration
+	retries   int
+
+	mu     sync.Mutex
+	closed bool
 }
 
 // NewServer 创建TFTP服务器
@@ -22,6 +70,23 @@ func NewServer(addr string, filesRoot string) *Server {
 	return &Server{
 		addr:      addr,
 		filesRoot: filesRoot,
+		timeout:   DefaultTimeout,
+		retries:   DefaultRetries,
+	}
+}
+
+// SetFileHook 设置虚拟文件钩子（优先于磁盘文件）
+func (s *Server) SetFileHook(hook FileHook) {
+	s.hook = hook
+}
+
+// SetRetransmit 设置默认重传超时和最大重传次数
+func (s *Server) SetRetransmit(timeout time.Duration, retries int) {
+	if timeout > 0 {
+		s.timeout = timeout
+	}
+	if retries > 0 {
+		s.retries = retries
 	}
 }
 
@@ -38,7 +103,7 @@ func (s *Server) Start() error {
 	}
 
 	s.conn = conn
-	log.Printf("✅ TFTP服务器启动成功: %s", s.addr)
+	log.Printf("✅ TFTP服务器启动成功: %s", conn.LocalAddr())
 	log.Printf("📁 文件根目录: %s", s.filesRoot)
 
 	// 启动请求处理循环
@@ -49,25 +114,48 @@ func (s *Server) Start() error {
 
 // Stop 停止TFTP服务器
 func (s *Server) Stop() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
 	if s.conn != nil {
 		return s.conn.Close()
 	}
 	return nil
 }
 
+// Addr 返回实际监听地址（未启动时为nil）
+func (s *Server) Addr() net.Addr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
 // serve 处理TFTP请求
 func (s *Server) serve() {
-	buffer := make([]byte, 516) // TFTP最大包大小
+	buffer := make([]byte, 65536)
 
 	for {
 		n, clientAddr, err := s.conn.ReadFromUDP(buffer)
 		if err != nil {
+			if s.isClosed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Printf("⚠️  TFTP读取错误: %v", err)
 			continue
 		}
 
-		// 异步处理每个请求
-		go s.handleRequest(buffer[:n], clientAddr)
+		// 异步处理每个请求（复制数据，避免缓冲区被下一次读取覆盖）
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+		go s.handleRequest(data, clientAddr)
 	}
 }
 
@@ -80,144 +168,331 @@ func (s *Server) handleRequest(data []byte, clientAddr *net.UDPAddr) {
 	opcode := uint16(data[0])<<8 | uint16(data[1])
 
 	switch opcode {
-	case 1: // RRQ (Read Request)
+	case opRRQ: // RRQ (Read Request)
 		s.handleReadRequest(data[2:], clientAddr)
-	case 2: // WRQ (Write Request)
-		s.sendError(clientAddr, 2, "Write not supported")
+	case opWRQ: // WRQ (Write Request)
+		s.sendError(clientAddr, errAccessViolation, "Write not supported")
 	default:
-		s.sendError(clientAddr, 4, "Illegal TFTP operation")
+		s.sendError(clientAddr, errIllegalOp, "Illegal TFTP operation")
 	}
 }
 
 // handleReadRequest 处理读取请求
 func (s *Server) handleReadRequest(data []byte, clientAddr *net.UDPAddr) {
-	// 解析文件名
-	filename, mode := s.parseRRQ(data)
+	// 解析文件名、模式和扩展选项
+	filename, mode, options := s.parseRRQ(data)
 	if filename == "" {
-		s.sendError(clientAddr, 4, "Invalid filename")
+		s.sendError(clientAddr, errIllegalOp, "Invalid filename")
 		return
 	}
 
 	log.Printf("📥 TFTP RRQ: %s (mode: %s) from %s", filename, mode, clientAddr)
 
-	// 打开文件
-	filePath := filepath.Join(s.filesRoot, filename)
-	file, err := os.Open(filePath)
+	name, err := sanitizePath(filename)
 	if err != nil {
-		log.Printf("❌ 文件不存在: %s", filePath)
-		s.sendError(clientAddr, 1, "File not found")
+		log.Printf("🚫 拒绝非法路径: %s from %s", filename, clientAddr)
+		s.sendError(clientAddr, errAccessViolation, "Access violation")
 		return
 	}
-	defer file.Close()
 
-	// 发送文件数据
-	s.sendFile(file, clientAddr)
-}
+	reader, size, err := s.openFile(name, clientAddr)
+	if err != nil {
+		if errors.Is(err, errAccessDenied) {
+			s.sendError(clientAddr, errAccessViolation, "Access violation")
+			return
+		}
+		log.Printf("❌ 文件不存在: %s", name)
+		s.sendError(clientAddr, errFileNotFound, "File not found")
+		return
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-// sendFile 发送文件数据
-func (s *Server) sendFile(file *os.File, clientAddr *net.UDPAddr) {
-	// 创建新的UDP连接用于数据传输
+	// 选项协商 (RFC 2347/2348/2349)
+	tr := &transfer{
+		blockSize: DefaultBlockSize,
+		timeout:   s.timeout,
+		retries:   s.retries,
+	}
+	accepted, err := tr.negotiate(options, size)
+	if err != nil {
+		s.sendError(clientAddr, errOptionRefused, err.Error())
+		return
+	}
+
+	// 创建新的UDP连接用于数据传输（新的TID）
 	conn, err := net.DialUDP("udp", nil, clientAddr)
 	if err != nil {
 		log.Printf("❌ 无法连接客户端: %v", err)
 		return
 	}
 	defer conn.Close()
+	tr.conn = conn
+
+	if len(accepted) > 0 {
+		if err := tr.sendOACK(accepted); err != nil {
+			log.Printf("❌ TFTP选项协商失败 %s: %v", name, err)
+			return
+		}
+	}
+
+	// 发送文件数据
+	blocks, err := tr.sendFile(reader)
+	if err != nil {
+		log.Printf("❌ TFTP传输失败 %s: %v", name, err)
+		return
+	}
+	log.Printf("✅ 文件传输完成: %s (%d bytes, %d blocks, blksize %d)", name, size, blocks, tr.blockSize)
+}
+
+var errAccessDenied = errors.New("access denied")
+
+// openFile 打开文件：优先虚拟文件钩子，其次磁盘文件
+func (s *Server) openFile(name string, clientAddr *net.UDPAddr) (io.Reader, int64, error) {
+	if s.hook != nil {
+		content, ok, err := s.hook(name, clientAddr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			return bytes.NewReader(content), int64(len(content)), nil
+		}
+	}
+
+	if s.filesRoot == "" {
+		return nil, 0, os.ErrNotExist
+	}
+
+	root, err := filepath.Abs(s.filesRoot)
+	if err != nil {
+		return nil, 0, err
+	}
+	filePath := filepath.Join(root, filepath.FromSlash(name))
+
+	// 解析符号链接，防止通过链接逃逸根目录
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resolvedRoot, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolvedRoot
+	}
+	if !isWithin(root, resolved) {
+		return nil, 0, errAccessDenied
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, 0, os.ErrNotExist
+	}
+
+	return file, info.Size(), nil
+}
+
+// sanitizePath 规范化客户端请求路径，拒绝目录穿越
+func sanitizePath(filename string) (string, error) {
+	if strings.ContainsRune(filename, 0) {
+		return "", errAccessDenied
+	}
 
+	// 兼容Windows风格分隔符，统一去掉前导斜杠（TFTP路径相对根目录）
+	name := strings.ReplaceAll(filename, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errAccessDenied
+		}
+	}
+
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" || name == "." {
+		return "", errAccessDenied
+	}
+	return name, nil
+}
+
+// isWithin 检查target是否位于root目录内
+func isWithin(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// sendError 发送错误响应
+func (s *Server) sendError(clientAddr *net.UDPAddr, errorCode uint16, errorMsg string) {
+	s.conn.WriteToUDP(errorPacket(errorCode, errorMsg), clientAddr)
+}
+
+// parseRRQ 解析RRQ包
+// 格式: 文件名\0模式\0[选项名\0选项值\0]...
+func (s *Server) parseRRQ(data []byte) (filename string, mode string, options map[string]string) {
+	parts := strings.Split(string(data), "\x00")
+	// 以\0结尾时最后一段为空
+	if len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) < 2 {
+		return "", "", nil
+	}
+
+	options = make(map[string]string)
+	for i := 2; i+1 < len(parts); i += 2 {
+		options[strings.ToLower(parts[i])] = parts[i+1]
+	}
+
+	return parts[0], strings.ToLower(parts[1]), options
+}
+
+// transfer 单次文件传输会话
+type transfer struct {
+	conn      *net.UDPConn
+	blockSize int
+	timeout   time.Duration
+	retries   int
+}
+
+// negotiate 处理RFC 2347扩展选项，返回需要在OACK中确认的选项
+// 未识别的选项按RFC要求忽略
+func (t *transfer) negotiate(options map[string]string, size int64) ([][2]string, error) {
+	var accepted [][2]string
+
+	if v, ok := options["blksize"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < MinBlockSize {
+			return nil, fmt.Errorf("invalid blksize: %s", v)
+		}
+		if n > MaxBlockSize {
+			n = MaxBlockSize
+		}
+		t.blockSize = n
+		accepted = append(accepted, [2]string{"blksize", strconv.Itoa(n)})
+	}
+
+	if v, ok := options["timeout"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 255 {
+			return nil, fmt.Errorf("invalid timeout: %s", v)
+		}
+		t.timeout = time.Duration(n) * time.Second
+		accepted = append(accepted, [2]string{"timeout", v})
+	}
+
+	if _, ok := options["tsize"]; ok {
+		// RRQ中客户端发送tsize=0，服务端回复实际文件大小
+		accepted = append(accepted, [2]string{"tsize", strconv.FormatInt(size, 10)})
+	}
+
+	return accepted, nil
+}
+
+// sendOACK 发送OACK并等待客户端ACK(0)
+func (t *transfer) sendOACK(accepted [][2]string) error {
+	packet := []byte{0x00, byte(opOACK)}
+	for _, kv := range accepted {
+		packet = append(packet, kv[0]...)
+		packet = append(packet, 0)
+		packet = append(packet, kv[1]...)
+		packet = append(packet, 0)
+	}
+	return t.sendAndWait(packet, 0)
+}
+
+// sendFile 按协商的块大小发送文件，返回发送的块数
+func (t *transfer) sendFile(reader io.Reader) (int, error) {
+	buffer := make([]byte, t.blockSize)
 	blockNum := uint16(1)
-	buffer := make([]byte, 512)
+	blocks := 0
 
 	for {
-		// 读取数据块
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			log.Printf("❌ 读取文件错误: %v", err)
-			return
+		// 读取数据块（ReadFull保证除最后一块外都是满块）
+		n, err := io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.conn.Write(errorPacket(errNotDefined, "Read error"))
+			return blocks, fmt.Errorf("read file: %w", err)
 		}
 
 		// 构建DATA包
 		dataPacket := make([]byte, 4+n)
-		dataPacket[0] = 0x00 // Opcode: DATA (高字节)
-		dataPacket[1] = 0x03 // Opcode: DATA (低字节)
+		dataPacket[0] = 0x00
+		dataPacket[1] = byte(opDATA)
 		dataPacket[2] = byte(blockNum >> 8)
 		dataPacket[3] = byte(blockNum & 0xFF)
 		copy(dataPacket[4:], buffer[:n])
 
-		// 发送数据包（带重试）
-		ackReceived := false
-		for retry := 0; retry < 5; retry++ {
-			_, writeErr := conn.Write(dataPacket)
-			if writeErr != nil {
-				log.Printf("⚠️  发送数据失败: %v", writeErr)
-				continue
-			}
+		if err := t.sendAndWait(dataPacket, blockNum); err != nil {
+			return blocks, err
+		}
+		blocks++
 
-			// 等待ACK
-			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
-			ackBuffer := make([]byte, 516)
-			ackN, readErr := conn.Read(ackBuffer)
+		// 最后一个数据包（小于块大小，可能为0字节）
+		if n < t.blockSize {
+			return blocks, nil
+		}
 
-			if readErr == nil && ackN >= 4 {
-				ackOpcode := uint16(ackBuffer[0])<<8 | uint16(ackBuffer[1])
-				ackBlock := uint16(ackBuffer[2])<<8 | uint16(ackBuffer[3])
+		// 块号按RFC约定回绕
+		blockNum++
+	}
+}
 
-				if ackOpcode == 4 && ackBlock == blockNum {
-					ackReceived = true
-					break
-				}
-			}
+// sendAndWait 发送数据包并等待对应块号的ACK，超时重传
+// 重复的旧ACK只忽略不重传，避免"魔法师学徒"综合症
+func (t *transfer) sendAndWait(packet []byte, block uint16) error {
+	ackBuffer := make([]byte, 516)
 
-			time.Sleep(100 * time.Millisecond)
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if _, err := t.conn.Write(packet); err != nil {
+			return fmt.Errorf("send block %d: %w", block, err)
 		}
 
-		if !ackReceived {
-			log.Printf("❌ 未收到ACK for block %d", blockNum)
-			return
-		}
+		deadline := time.Now().Add(t.timeout)
+		for {
+			t.conn.SetReadDeadline(deadline)
+			n, err := t.conn.Read(ackBuffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // 超时，重传
+				}
+				// ICMP不可达等错误视为客户端已放弃
+				return fmt.Errorf("wait ack %d: %w", block, err)
+			}
+			if n < 4 {
+				continue
+			}
 
-		// 最后一个数据包（小于512字节）
-		if n < 512 {
-			log.Printf("✅ 文件传输完成: %d blocks", blockNum)
-			return
-		}
+			opcode := uint16(ackBuffer[0])<<8 | uint16(ackBuffer[1])
+			ackBlock := uint16(ackBuffer[2])<<8 | uint16(ackBuffer[3])
 
-		blockNum++
+			switch opcode {
+			case opACK:
+				if ackBlock == block {
+					return nil
+				}
+				// 旧块的重复ACK，继续等待
+			case opERROR:
+				return fmt.Errorf("client aborted: %s", strings.TrimRight(string(ackBuffer[4:n]), "\x00"))
+			}
+		}
 	}
+
+	return fmt.Errorf("no ack for block %d after %d retries", block, t.retries)
 }
 
-// sendError 发送错误响应
-func (s *Server) sendError(clientAddr *net.UDPAddr, errorCode uint16, errorMsg string) {
+// errorPacket 构建ERROR包
+func errorPacket(errorCode uint16, errorMsg string) []byte {
 	packet := make([]byte, 5+len(errorMsg))
 	packet[0] = 0x00 // Opcode: ERROR (高字节)
-	packet[1] = 0x05 // Opcode: ERROR (低字节)
+	packet[1] = byte(opERROR)
 	packet[2] = byte(errorCode >> 8)
 	packet[3] = byte(errorCode & 0xFF)
 	copy(packet[4:], errorMsg)
 	packet[len(packet)-1] = 0x00 // 结束符
-
-	s.conn.WriteToUDP(packet, clientAddr)
-}
-
-// parseRRQ 解析RRQ包
-func (s *Server) parseRRQ(data []byte) (filename string, mode string) {
-	// RRQ格式: 文件名\0模式\0
-	parts := make([]string, 0, 2)
-	start := 0
-
-	for i, b := range data {
-		if b == 0 {
-			parts = append(parts, string(data[start:i]))
-			start = i + 1
-
-			if len(parts) >= 2 {
-				break
-			}
-		}
-	}
-
-	if len(parts) >= 2 {
-		return parts[0], parts[1]
-	}
-
-	return "", ""
+	return packet
 }
@@ -0,0 +1,260 @@
+package tftp
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testClient 测试用TFTP客户端
+type testClient struct {
+	t    *testing.T
+	conn *net.UDPConn
+	peer *net.UDPAddr // 传输阶段服务端的TID
+}
+
+func startTestServer(t *testing.T, root string, hook FileHook) *Server {
+	t.Helper()
+	s := NewServer("127.0.0.1:0", root)
+	s.SetFileHook(hook)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+func (c *testClient) sendRRQ(server net.Addr, filename string, options ...string) {
+	packet := []byte{0, byte(opRRQ)}
+	packet = append(packet, filename...)
+	packet = append(packet, 0)
+	packet = append(packet, "octet"...)
+	packet = append(packet, 0)
+	for _, opt := range options {
+		packet = append(packet, opt...)
+		packet = append(packet, 0)
+	}
+	if _, err := c.conn.WriteToUDP(packet, server.(*net.UDPAddr)); err != nil {
+		c.t.Fatalf("send RRQ error = %v", err)
+	}
+}
+
+func (c *testClient) recv(timeout time.Duration) (uint16, []byte) {
+	buf := make([]byte, 65536)
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, addr, err := c.conn.ReadFromUDP(buf)
+	if err != nil {
+		c.t.Fatalf("recv error = %v", err)
+	}
+	c.peer = addr
+	return uint16(buf[0])<<8 | uint16(buf[1]), buf[2:n]
+}
+
+func (c *testClient) ack(block uint16) {
+	c.conn.WriteToUDP([]byte{0, byte(opACK), byte(block >> 8), byte(block)}, c.peer)
+}
+
+// download 完成一次下载，返回内容和每个DATA包的负载长度
+func (c *testClient) download(first uint16, payload []byte, blockSize int) ([]byte, []int) {
+	var content bytes.Buffer
+	var sizes []int
+	op, body := first, payload
+	for {
+		if op != opDATA {
+			c.t.Fatalf("opcode = %d, want DATA", op)
+		}
+		block := uint16(body[0])<<8 | uint16(body[1])
+		content.Write(body[2:])
+		sizes = append(sizes, len(body)-2)
+		c.ack(block)
+		if len(body)-2 < blockSize {
+			return content.Bytes(), sizes
+		}
+		op, body = c.recv(2 * time.Second)
+	}
+}
+
+func TestServer_ReadDefaultBlockSize(t *testing.T) {
+	root := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 1200)
+	os.WriteFile(filepath.Join(root, "undionly.kpxe"), data, 0644)
+
+	s := startTestServer(t, root, nil)
+	c := newTestClient(t)
+	c.sendRRQ(s.Addr(), "undionly.kpxe")
+
+	op, body := c.recv(2 * time.Second)
+	got, sizes := c.download(op, body, DefaultBlockSize)
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("content length = %d, want %d", len(got), len(data))
+	}
+	if len(sizes) != 3 || sizes[0] != 512 || sizes[2] != 176 {
+		t.Errorf("block sizes = %v, want [512 512 176]", sizes)
+	}
+}
+
+func TestServer_OptionNegotiation(t *testing.T) {
+	root := t.TempDir()
+	data := bytes.Repeat([]byte("y"), 3000)
+	os.WriteFile(filepath.Join(root, "ipxe.efi"), data, 0644)
+
+	s := startTestServer(t, root, nil)
+	c := newTestClient(t)
+	c.sendRRQ(s.Addr(), "ipxe.efi", "blksize", "1468", "tsize", "0", "timeout", "2", "unknown", "1")
+
+	op, body := c.recv(2 * time.Second)
+	if op != opOACK {
+		t.Fatalf("opcode = %d, want OACK", op)
+	}
+	oack := string(body)
+	for _, want := range []string{"blksize\x001468\x00", "tsize\x003000\x00", "timeout\x002\x00"} {
+		if !strings.Contains(oack, want) {
+			t.Errorf("OACK %q missing %q", oack, want)
+		}
+	}
+	if strings.Contains(oack, "unknown") {
+		t.Errorf("OACK should not acknowledge unknown options: %q", oack)
+	}
+
+	c.ack(0)
+	op, body = c.recv(2 * time.Second)
+	got, sizes := c.download(op, body, 1468)
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("content length = %d, want %d", len(got), len(data))
+	}
+	if sizes[0] != 1468 {
+		t.Errorf("first block size = %d, want 1468", sizes[0])
+	}
+}
+
+func TestServer_RetransmitOnLostAck(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "small.bin"), []byte("hello"), 0644)
+
+	s := startTestServer(t, root, nil)
+	c := newTestClient(t)
+	c.sendRRQ(s.Addr(), "small.bin", "timeout", "1")
+
+	op, _ := c.recv(2 * time.Second)
+	if op != opOACK {
+		t.Fatalf("opcode = %d, want OACK", op)
+	}
+
+	// 不确认OACK，服务端应在超时后重传
+	op, _ = c.recv(3 * time.Second)
+	if op != opOACK {
+		t.Fatalf("retransmitted opcode = %d, want OACK", op)
+	}
+
+	c.ack(0)
+	op, body := c.recv(2 * time.Second)
+	if op != opDATA || string(body[2:]) != "hello" {
+		t.Fatalf("DATA = %q, want hello", body)
+	}
+
+	// 丢弃第一个DATA的ACK，应收到相同块号的重传
+	op, body = c.recv(3 * time.Second)
+	if op != opDATA || body[1] != 1 {
+		t.Fatalf("expected retransmitted DATA block 1, got op=%d body=%q", op, body)
+	}
+	c.ack(1)
+}
+
+func TestServer_PathTraversal(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "tftpboot")
+	os.Mkdir(root, 0755)
+	os.WriteFile(filepath.Join(base, "secret"), []byte("secret"), 0644)
+	os.Symlink(filepath.Join(base, "secret"), filepath.Join(root, "link"))
+
+	s := startTestServer(t, root, nil)
+
+	for _, name := range []string{"../secret", "/../secret", "a/../../secret", "..\\secret", "link"} {
+		t.Run(name, func(t *testing.T) {
+			c := newTestClient(t)
+			c.sendRRQ(s.Addr(), name)
+			op, body := c.recv(2 * time.Second)
+			if op != opERROR {
+				t.Fatalf("opcode = %d, want ERROR", op)
+			}
+			if code := uint16(body[0])<<8 | uint16(body[1]); code != errAccessViolation {
+				t.Errorf("error code = %d, want %d", code, errAccessViolation)
+			}
+		})
+	}
+}
+
+func TestServer_FileNotFound(t *testing.T) {
+	s := startTestServer(t, t.TempDir(), nil)
+	c := newTestClient(t)
+	c.sendRRQ(s.Addr(), "missing.efi")
+
+	op, body := c.recv(2 * time.Second)
+	if op != opERROR || (uint16(body[0])<<8|uint16(body[1])) != errFileNotFound {
+		t.Fatalf("expected File not found error, got op=%d body=%q", op, body)
+	}
+}
+
+func TestIPXEHook(t *testing.T) {
+	firmware := fstest.MapFS{"undionly.kpxe": {Data: []byte("firmware")}}
+	hook := NewIPXEHook(firmware, "http://10.0.0.10:8080/")
+
+	tests := []struct {
+		name     string
+		filename string
+		wantOK   bool
+		contains string
+	}{
+		{"Embedded firmware", "undionly.kpxe", true, "firmware"},
+		{"Generic script", "boot.ipxe", true, "chain --autofree http://10.0.0.10:8080/boot/ipxe/${netX/mac}"},
+		{"Per-MAC script hyphen", "AA-BB-CC-DD-EE-FF.ipxe", true, "/boot/ipxe/aa:bb:cc:dd:ee:ff"},
+		{"Per-MAC script dir", "ipxe/aabbccddeeff", true, "/boot/ipxe/aa:bb:cc:dd:ee:ff"},
+		{"Invalid MAC", "not-a-mac.ipxe", false, ""},
+		{"Fallback to disk", "ipxe.efi", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, ok, err := hook(tt.filename, nil)
+			if err != nil {
+				t.Fatalf("hook() error = %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !strings.Contains(string(content), tt.contains) {
+				t.Errorf("content %q missing %q", content, tt.contains)
+			}
+		})
+	}
+}
+
+func TestServer_VirtualFileHook(t *testing.T) {
+	hook := NewIPXEHook(nil, "http://10.0.0.10:8080")
+	s := startTestServer(t, "", hook)
+	c := newTestClient(t)
+	c.sendRRQ(s.Addr(), "/aa:bb:cc:dd:ee:ff.ipxe")
+
+	op, body := c.recv(2 * time.Second)
+	got, _ := c.download(op, body, DefaultBlockSize)
+	if !strings.HasPrefix(string(got), "#!ipxe") {
+		t.Errorf("script = %q, want iPXE script", got)
+	}
+}
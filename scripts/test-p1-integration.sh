@@ -34,12 +34,18 @@ function info() {
     echo -e "${YELLOW}→${NC} $1"
 }
 
-# 测试1: Agent注册
+# 测试1: Agent注册（先模拟PXE启动，从iPXE脚本的 cloudboot.token 获取一次性引导令牌）
 info "Test 1: Agent Registration"
+BOOTSTRAP_TOKEN=$(curl -s ${SERVER_URL}/boot/ipxe/${TEST_MAC} | grep -o 'cloudboot\.token=[^ ]*' | head -1 | cut -d= -f2)
+if [ -z "$BOOTSTRAP_TOKEN" ]; then
+    fail "No bootstrap token in iPXE script"
+fi
+
 REGISTER_RESP=$(curl -s -X POST ${SERVER_URL}/api/boot/v1/register \
   -H "Content-Type: application/json" \
   -d '{
     "mac_address": "'"${TEST_MAC}"'",
+    "bootstrap_token": "'"${BOOTSTRAP_TOKEN}"'",
     "ip_address": "10.0.0.100",
     "hostname": "test-server-001",
     "hardware_spec": {
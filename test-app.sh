@@ -4,6 +4,12 @@
 PORT=8081
 BASE_URL="http://localhost:$PORT"
 
+# /api/v1 与页面需要认证: API_TOKEN=cbt_xxx ./test-app.sh
+if [ -z "$API_TOKEN" ]; then
+  echo "⚠️  未设置 API_TOKEN，需要认证的接口将返回 401"
+fi
+AUTH_HEADER="Authorization: Bearer $API_TOKEN"
+
 echo "╔════════════════════════════════════════════════════════════╗"
 echo "║        CloudBoot NG 应用功能测试                          ║"
 echo "╚════════════════════════════════════════════════════════════╝"
@@ -27,24 +33,24 @@ echo ""
 
 # 3. API 端点测试
 echo "✅ 3. Machines API"
-curl -s $BASE_URL/api/v1/machines | jq .
+curl -s -H "$AUTH_HEADER" $BASE_URL/api/v1/machines | jq .
 echo ""
 
 echo "✅ 4. Profiles API"
-curl -s $BASE_URL/api/v1/profiles | jq .
+curl -s -H "$AUTH_HEADER" $BASE_URL/api/v1/profiles | jq .
 echo ""
 
 echo "✅ 5. Jobs API"
-curl -s $BASE_URL/api/v1/jobs | jq .
+curl -s -H "$AUTH_HEADER" $BASE_URL/api/v1/jobs | jq .
 echo ""
 
 echo "✅ 6. Store Providers API"
-curl -s $BASE_URL/api/v1/store/providers | jq .
+curl -s -H "$AUTH_HEADER" $BASE_URL/api/v1/store/providers | jq .
 echo ""
 
 # 7. Design System 页面
 echo "✅ 7. Design System 页面 (SSR 渲染)"
-DESIGN_RESPONSE=$(curl -s $BASE_URL/design-system)
+DESIGN_RESPONSE=$(curl -s -H "$AUTH_HEADER" $BASE_URL/design-system)
 if [[ $DESIGN_RESPONSE == *"CloudBoot NG Design System"* ]]; then
   echo "   ✓ Design System 页面渲染成功"
 else
@@ -54,7 +60,7 @@ echo ""
 
 # 8. 主页
 echo "✅ 8. 主页渲染"
-HOME_RESPONSE=$(curl -s $BASE_URL/)
+HOME_RESPONSE=$(curl -s -H "$AUTH_HEADER" $BASE_URL/)
 if [[ $HOME_RESPONSE == *"CloudBoot NG"* ]]; then
   echo "   ✓ 主页渲染成功"
 else
@@ -64,7 +70,7 @@ echo ""
 
 # 9. OS Designer 页面
 echo "✅ 9. OS Designer 页面"
-DESIGNER_RESPONSE=$(curl -s $BASE_URL/os-designer)
+DESIGNER_RESPONSE=$(curl -s -H "$AUTH_HEADER" $BASE_URL/os-designer)
 if [[ $DESIGNER_RESPONSE == *"OS"* ]]; then
   echo "   ✓ OS Designer 页面渲染成功"
 else
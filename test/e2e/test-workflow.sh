@@ -60,14 +60,31 @@ if [ ! -f "bin/cloudboot-server" ]; then
 fi
 
 # Start server in background
+# Fixed bootstrap admin password so the test can log in and mint an API token
+ADMIN_PASSWORD="${ADMIN_PASSWORD:-e2e-admin-password}"
 info "Starting server on port 8080..."
-./bin/cloudboot-server &
+ADMIN_PASSWORD="$ADMIN_PASSWORD" ./bin/cloudboot-server &
 SERVER_PID=$!
 sleep 3
 
 # Check health
 check_service "CloudBoot Server" "http://localhost:8080/health"
 
+# Log in and create an API token for /api/v1
+COOKIE_JAR=$(mktemp)
+curl -sf -c "$COOKIE_JAR" -o /dev/null -X POST http://localhost:8080/login \
+    --data-urlencode "username=admin" --data-urlencode "password=$ADMIN_PASSWORD"
+API_TOKEN=$(curl -sf -b "$COOKIE_JAR" -X POST http://localhost:8080/api/v1/auth/tokens \
+    -H "Content-Type: application/json" \
+    -d '{"name":"e2e","scopes":["read","write"],"expires_in":"1h"}' | jq -r '.token')
+rm -f "$COOKIE_JAR"
+if [ -n "$API_TOKEN" ] && [ "$API_TOKEN" != "null" ]; then
+    pass "API token created"
+else
+    fail "Failed to log in as admin"
+fi
+AUTH=(-H "Authorization: Bearer $API_TOKEN")
+
 # Step 2: Seed Database
 info "Step 2: Seeding database..."
 cd "$PROJECT_ROOT/tools/seed"
@@ -78,21 +95,21 @@ pass "Database seeded"
 info "Step 3: Testing API endpoints..."
 
 # Test machines endpoint
-if curl -sf http://localhost:8080/api/v1/machines | jq -e '.machines' >/dev/null 2>&1; then
+if curl -sf "${AUTH[@]}" http://localhost:8080/api/v1/machines | jq -e '.machines' >/dev/null 2>&1; then
     pass "GET /api/v1/machines works"
 else
     fail "GET /api/v1/machines failed"
 fi
 
 # Test profiles endpoint
-if curl -sf http://localhost:8080/api/v1/profiles | jq -e '.profiles' >/dev/null 2>&1; then
+if curl -sf "${AUTH[@]}" http://localhost:8080/api/v1/profiles | jq -e '.profiles' >/dev/null 2>&1; then
     pass "GET /api/v1/profiles works"
 else
     fail "GET /api/v1/profiles failed"
 fi
 
 # Test jobs endpoint
-if curl -sf http://localhost:8080/api/v1/jobs | jq -e '.jobs' >/dev/null 2>&1; then
+if curl -sf "${AUTH[@]}" http://localhost:8080/api/v1/jobs | jq -e '.jobs' >/dev/null 2>&1; then
     pass "GET /api/v1/jobs works"
 else
     fail "GET /api/v1/jobs failed"
@@ -100,10 +117,19 @@ fi
 
 # Step 4: Test Agent Registration
 info "Step 4: Testing agent registration..."
+# The iPXE script hands BootOS a single-use bootstrap token via cloudboot.token
+BOOTSTRAP_TOKEN=$(curl -sf http://localhost:8080/boot/ipxe/aa:bb:cc:dd:ee:ff | grep -o 'cloudboot\.token=[^ ]*' | head -1 | cut -d= -f2)
+if [ -n "$BOOTSTRAP_TOKEN" ]; then
+    pass "Bootstrap token issued by iPXE script"
+else
+    fail "No bootstrap token in iPXE script"
+fi
+
 REGISTER_RESPONSE=$(curl -sf -X POST http://localhost:8080/api/boot/v1/register \
     -H "Content-Type: application/json" \
     -d '{
         "mac_address": "aa:bb:cc:dd:ee:ff",
+        "bootstrap_token": "'"$BOOTSTRAP_TOKEN"'",
         "ip_address": "192.168.1.200",
         "hardware_spec": {
             "system_manufacturer": "Test Vendor",
@@ -115,14 +141,23 @@ REGISTER_RESPONSE=$(curl -sf -X POST http://localhost:8080/api/boot/v1/register
 
 if echo "$REGISTER_RESPONSE" | jq -e '.machine_id' >/dev/null 2>&1; then
     MACHINE_ID=$(echo "$REGISTER_RESPONSE" | jq -r '.machine_id')
+    AGENT_TOKEN=$(echo "$REGISTER_RESPONSE" | jq -r '.agent_token')
     pass "Agent registration successful (Machine ID: $MACHINE_ID)"
 else
     fail "Agent registration failed"
 fi
+AGENT_AUTH=(-H "Authorization: Bearer $AGENT_TOKEN")
+
+# Boot API rejects callers without an agent token
+if [ "$(curl -s -o /dev/null -w '%{http_code}' "http://localhost:8080/api/boot/v1/task?machine_id=$MACHINE_ID")" = "401" ]; then
+    pass "Task polling without agent token rejected"
+else
+    fail "Task polling without agent token was not rejected"
+fi
 
 # Step 5: Test Task Polling
 info "Step 5: Testing task polling..."
-TASK_RESPONSE=$(curl -sf "http://localhost:8080/api/boot/v1/task?machine_id=$MACHINE_ID")
+TASK_RESPONSE=$(curl -sf "${AGENT_AUTH[@]}" "http://localhost:8080/api/boot/v1/task?machine_id=$MACHINE_ID")
 
 if echo "$TASK_RESPONSE" | jq -e '.no_task' >/dev/null 2>&1; then
     pass "Task polling works (no task available)"
@@ -134,11 +169,11 @@ fi
 info "Step 6: Testing provision request..."
 
 # Get first profile
-PROFILE_ID=$(curl -sf http://localhost:8080/api/v1/profiles | jq -r '.profiles[0].id')
+PROFILE_ID=$(curl -sf "${AUTH[@]}" http://localhost:8080/api/v1/profiles | jq -r '.profiles[0].id')
 
 if [ -n "$PROFILE_ID" ] && [ "$PROFILE_ID" != "null" ]; then
     # Provision machine
-    PROVISION_RESPONSE=$(curl -sf -X POST "http://localhost:8080/api/v1/machines/$MACHINE_ID/provision" \
+    PROVISION_RESPONSE=$(curl -sf "${AUTH[@]}" -X POST "http://localhost:8080/api/v1/machines/$MACHINE_ID/provision" \
         -H "Content-Type: application/json" \
         -d "{\"profile_id\": \"$PROFILE_ID\"}")
 
@@ -155,7 +190,7 @@ fi
 # Step 7: Test Log Upload
 info "Step 7: Testing log upload..."
 if [ -n "$JOB_ID" ]; then
-    LOG_RESPONSE=$(curl -sf -X POST http://localhost:8080/api/boot/v1/logs \
+    LOG_RESPONSE=$(curl -sf "${AGENT_AUTH[@]}" -X POST http://localhost:8080/api/boot/v1/logs \
         -H "Content-Type: application/json" \
         -d "{
             \"job_id\": \"$JOB_ID\",
@@ -181,10 +216,10 @@ fi
 # Step 8: Test Status Report
 info "Step 8: Testing status report..."
 if [ -n "$JOB_ID" ]; then
-    STATUS_RESPONSE=$(curl -sf -X POST http://localhost:8080/api/boot/v1/status \
+    STATUS_RESPONSE=$(curl -sf "${AGENT_AUTH[@]}" -X POST http://localhost:8080/api/boot/v1/status \
         -H "Content-Type: application/json" \
         -d "{
-            \"job_id\": \"$JOB_ID\",
+            \"task_id\": \"$JOB_ID\",
             \"status\": \"running\",
             \"step_current\": \"Installing packages\"
         }")
@@ -202,7 +237,7 @@ fi
 info "Step 9: Testing SSE log streaming..."
 if [ -n "$JOB_ID" ]; then
     # Start SSE stream in background and capture for 2 seconds
-    timeout 2 curl -sf "http://localhost:8080/api/stream/logs/$JOB_ID" > /tmp/sse-test.log 2>&1 || true
+    timeout 2 curl -sf "${AUTH[@]}" "http://localhost:8080/api/stream/logs/$JOB_ID" > /tmp/sse-test.log 2>&1 || true
 
     if [ -s /tmp/sse-test.log ]; then
         pass "SSE log streaming works"
@@ -217,7 +252,7 @@ fi
 # Step 10: Test Profile Preview
 info "Step 10: Testing profile config preview..."
 if [ -n "$PROFILE_ID" ]; then
-    PREVIEW_RESPONSE=$(curl -sf "http://localhost:8080/api/v1/profiles/$PROFILE_ID/preview")
+    PREVIEW_RESPONSE=$(curl -sf "${AUTH[@]}" "http://localhost:8080/api/v1/profiles/$PROFILE_ID/preview")
 
     if echo "$PREVIEW_RESPONSE" | grep -q "Kickstart"; then
         pass "Profile config preview works"
@@ -15,6 +15,11 @@ var StaticFiles embed.FS
 //go:embed templates
 var TemplateFiles embed.FS
 
+// IPXEFiles embeds iPXE firmware served by the built-in TFTP server
+//
+//go:embed ipxe
+var IPXEFiles embed.FS
+
 // GetStaticAssets returns the static file system without the "static/" prefix
 // This allows serving files directly without the path prefix
 // Example: /static/css/output.css -> /css/output.css
@@ -28,6 +33,12 @@ func GetTemplateAssets() (fs.FS, error) {
 	return fs.Sub(TemplateFiles, "templates")
 }
 
+// GetIPXEAssets returns the embedded iPXE firmware without the "ipxe/" prefix
+// Example: ipxe/undionly.kpxe -> undionly.kpxe
+func GetIPXEAssets() (fs.FS, error) {
+	return fs.Sub(IPXEFiles, "ipxe")
+}
+
 // GetRawStaticFS returns the raw embedded static file system (with "static/" prefix)
 // Use this if you need access to the full path structure
 func GetRawStaticFS() fs.FS {